@@ -0,0 +1,141 @@
+package valuestore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// countingWriteCloser counts the bytes written to values and TOC files, for
+// the PhysicalWriteBytes stat, and times the writes to the disk's stats.
+type countingWriteCloser struct {
+	io.WriteCloser
+	vs   *DefaultValueStore
+	disk *disk
+}
+
+// Write retries, once there is space again, what couldn't be written for
+// lack of it; see diskFreeWait.
+func (w *countingWriteCloser) Write(b []byte) (int, error) {
+	written := 0
+	for retried := false; ; retried = true {
+		start := time.Now()
+		n, err := w.WriteCloser.Write(b[written:])
+		w.disk.write(time.Since(start), err)
+		atomic.AddInt64(&w.vs.physicalWriteBytes, int64(n))
+		written += n
+		if err == nil {
+			if retried {
+				w.vs.diskFreeRetried()
+			}
+			return written, nil
+		}
+		if !w.vs.diskFreeWait(err) {
+			return written, err
+		}
+	}
+}
+
+// Sync passes through to the underlying writer, if it can sync, so wrapping
+// doesn't defeat Config.StrictSync.
+func (w *countingWriteCloser) Sync() error {
+	if s, ok := w.WriteCloser.(interface {
+		Sync() error
+	}); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// diskBytes returns the total size of the values and TOC files.
+func (vs *DefaultValueStore) diskBytes() uint64 {
+	var total uint64
+	for _, dirs := range []struct {
+		dirs   []string
+		suffix string
+	}{{vs.valuesDirs(), ".values"}, {vs.tocDirs(), ".valuestoc"}} {
+		for _, dir := range dirs.dirs {
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil {
+				vs.logError("error reading %s: %s\n", dir, err)
+				continue
+			}
+			for _, fi := range fis {
+				if strings.HasSuffix(fi.Name(), dirs.suffix) {
+					total += uint64(fi.Size())
+				}
+			}
+		}
+	}
+	return total
+}
+
+// ValuesFileStats describes a values file on disk.
+type ValuesFileStats struct {
+	Name string
+	// ID is the timestamp, in nanoseconds, the file is named with; it is
+	// what CompactFile is given.
+	ID int64
+	// Age is how long ago the file was started.
+	Age   time.Duration
+	Bytes int64
+	// Waste is the fraction of the file's entries that are stale, as
+	// estimated by compaction's latest sample of the file, or -1 if it hasn't
+	// been sampled yet.
+	Waste float64
+}
+
+func (f *ValuesFileStats) String() string {
+	if f.Waste < 0 {
+		return fmt.Sprintf("%d bytes, waste unknown", f.Bytes)
+	}
+	return fmt.Sprintf("%d bytes, %.0f%% waste", f.Bytes, f.Waste*100)
+}
+
+// valuesFileStats returns the stats for each values file, oldest first, and
+// forgets the compaction samples of files no longer there.
+func (vs *DefaultValueStore) valuesFileStats() []ValuesFileStats {
+	var fis []os.FileInfo
+	for _, dir := range vs.valuesDirs() {
+		dirFIs, err := ioutil.ReadDir(dir)
+		if err != nil {
+			vs.logError("error reading %s: %s\n", dir, err)
+			return nil
+		}
+		fis = append(fis, dirFIs...)
+	}
+	sort.Slice(fis, func(i int, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	var files []ValuesFileStats
+	present := make(map[int64]struct{}, len(fis))
+	vs.compactionState.wasteLock.Lock()
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".values") {
+			continue
+		}
+		namets, err := strconv.ParseInt(strings.TrimSuffix(fi.Name(), ".values"), 10, 64)
+		if err != nil {
+			continue
+		}
+		present[namets] = struct{}{}
+		waste, ok := vs.compactionState.waste[namets]
+		if !ok {
+			waste = -1
+		}
+		files = append(files, ValuesFileStats{Name: fi.Name(), ID: namets, Age: time.Since(time.Unix(0, namets)), Bytes: fi.Size(), Waste: waste})
+	}
+	for namets := range vs.compactionState.waste {
+		if _, ok := present[namets]; !ok {
+			delete(vs.compactionState.waste, namets)
+		}
+	}
+	vs.compactionState.wasteLock.Unlock()
+	return files
+}
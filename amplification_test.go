@@ -0,0 +1,48 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAmplificationStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "amplification")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	defer vs.Close()
+	vs.EnableWrites()
+	logical := int64(0)
+	for keyB := uint64(1); keyB <= 1000; keyB++ {
+		value := []byte(fmt.Sprintf("value %d", keyB))
+		if _, err = vs.Write(1, keyB, 1000, value); err != nil {
+			t.Fatal(err)
+		}
+		logical += int64(len(value))
+	}
+	vs.Flush()
+	stats := vs.Stats(false).(*Stats)
+	if stats.LogicalWriteBytes != logical || stats.PhysicalWriteBytes <= logical || stats.WriteAmplification <= 1 {
+		t.Fatal(stats.LogicalWriteBytes, logical, stats.PhysicalWriteBytes, stats.WriteAmplification)
+	}
+	if stats.DiskBytes < uint64(stats.PhysicalWriteBytes) || stats.SpaceAmplification < 1 {
+		t.Fatal(stats.DiskBytes, stats.PhysicalWriteBytes, stats.SpaceAmplification)
+	}
+	// Compaction rewrites are physical writes only.
+	files := vs.ListFiles()
+	if len(files) != 1 {
+		t.Fatal(files)
+	}
+	if err = vs.CompactFile(files[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	stats = vs.Stats(false).(*Stats)
+	if stats.LogicalWriteBytes != 0 || stats.PhysicalWriteBytes <= logical || stats.WriteAmplification != 0 {
+		t.Fatal(stats.LogicalWriteBytes, stats.PhysicalWriteBytes, stats.WriteAmplification)
+	}
+}
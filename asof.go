@@ -0,0 +1,162 @@
+package valuestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var errBadTOCHeader = errors.New("bad toc header")
+
+// asOfEntry is the newest TOC entry found so far by ReadAsOf.
+type asOfEntry struct {
+	timestampbits uint64
+	blockID       uint32
+	fileOffset    uint64
+	length        uint32
+}
+
+// ReadAsOf is Read of keyA, keyB as it was at timestampmicro: the newest
+// version written at or before then. The current version and any kept with
+// Config.RetainVersions are looked at first; failing those, every TOC file is
+// scanned for the key's entries, so older versions can be found for as long
+// as compaction has yet to remove them. Versions replaced before ever
+// reaching disk leave no trace and a scan of every TOC file is slow, so this
+// is meant for debugging rather than normal reads. As with Read, ErrNotFound
+// with the timestamp returned indicates the version found was a deletion
+// marker, and with 0 that no version was found. Only the local copy is read.
+func (vs *DefaultValueStore) ReadAsOf(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, []byte, error) {
+	atomic.AddInt32(&vs.reads, 1)
+	timestampbits, value, err := vs.readAsOf(keyA, keyB, timestampmicro, value)
+	if err != nil {
+		atomic.AddInt32(&vs.readErrors, 1)
+	}
+	return int64(timestampbits >> _TSB_UTIL_BITS), value, err
+}
+
+func (vs *DefaultValueStore) readAsOf(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (uint64, []byte, error) {
+	for _, v := range vs.ListVersions(keyA, keyB) {
+		if v.Timestampmicro > timestampmicro {
+			continue
+		}
+		timestampbits, value2, err := vs.readVersion(keyA, keyB, v.Timestampmicro, value)
+		if err != ErrNotFound || timestampbits != 0 {
+			return timestampbits, value2, err
+		}
+		// Dropped since being listed; the TOC files may still have it.
+		break
+	}
+	// The Snapshot keeps compaction from removing the files being scanned.
+	s := vs.Snapshot()
+	defer s.Release()
+	names, err := vs.tocNames()
+	if err != nil {
+		return 0, value, err
+	}
+	var found asOfEntry
+	for _, name := range names {
+		namets, err := strconv.ParseInt(strings.TrimSuffix(path.Base(name), ".valuestoc"), 10, 64)
+		if err != nil {
+			continue
+		}
+		blockID := vs.valueLocBlockIDFromTimestampnano(namets)
+		if blockID == 0 {
+			continue
+		}
+		err = vs.tocEntries(name, func(b []byte, entrySize int) {
+			if binary.BigEndian.Uint64(b) != keyA || binary.BigEndian.Uint64(b[8:]) != keyB {
+				return
+			}
+			timestampbits := binary.BigEndian.Uint64(b[16:])
+			if timestampbits&_TSB_LOCAL_REMOVAL != 0 || int64(timestampbits>>_TSB_UTIL_BITS) > timestampmicro || timestampbits>>_TSB_UTIL_BITS <= found.timestampbits>>_TSB_UTIL_BITS {
+				return
+			}
+			fileOffset, length := tocEntryLocation(b, entrySize)
+			found = asOfEntry{timestampbits: timestampbits, blockID: blockID, fileOffset: fileOffset, length: length}
+		})
+		if err != nil {
+			vs.logError("error reading %s: %s\n", name, err)
+		}
+	}
+	if found.timestampbits == 0 {
+		return 0, value, ErrNotFound
+	}
+	vf, ok := vs.valueLocBlock(found.blockID).(*valuesFile)
+	if !ok {
+		return 0, value, ErrNotFound
+	}
+	return vf.readAt(keyA, keyB, found.timestampbits, found.fileOffset, found.length, value)
+}
+
+// tocEntries calls entry with each entry in the TOC file, passing over those
+// in blocks that fail their checksums. Batch markers are included.
+func (vs *DefaultValueStore) tocEntries(name string, entry func(b []byte, entrySize int)) error {
+	fp, err := vs.openFile(name)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	interval, newHash, err := readChecksumHeader(fp)
+	if err != nil {
+		return err
+	}
+	h := newHash()
+	buf := make([]byte, interval+4)
+	overflow := make([]byte, 0, _TOC_ENTRY_SIZE_MAX)
+	entrySize := 0
+	lost := false
+	for block := int64(0); ; block++ {
+		n, err := io.ReadFull(fp, buf)
+		if n < 4 {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			return nil
+		}
+		n -= 4
+		if checksum32(h, buf[:n]) != binary.BigEndian.Uint32(buf[n:]) {
+			if block == 0 {
+				return errBadTOCHeader
+			}
+			overflow = overflow[:0]
+			lost = true
+			if n < int(interval) {
+				return nil
+			}
+			continue
+		}
+		j := 0
+		if block == 0 {
+			if entrySize = tocEntrySize(buf); entrySize == 0 {
+				return errBadTOCHeader
+			}
+			j = 32
+		} else if lost {
+			j = (entrySize - int((block*int64(interval)-32)%int64(entrySize))) % entrySize
+			lost = false
+		}
+		last := n < int(interval)
+		if last && n >= 16 && binary.BigEndian.Uint32(buf[n-16:]) == 0 && bytes.Equal(buf[n-4:n], []byte("TERM")) {
+			n -= 16
+		}
+		if len(overflow) > 0 && j+entrySize-len(overflow) <= n {
+			k := j + entrySize - len(overflow)
+			overflow = append(overflow, buf[j:k]...)
+			entry(overflow, entrySize)
+			overflow = overflow[:0]
+			j = k
+		}
+		for ; j+entrySize <= n; j += entrySize {
+			entry(buf[j:], entrySize)
+		}
+		overflow = append(overflow[:0], buf[j:n]...)
+		if last || err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package valuestore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReadAsOf(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	defer vs.Close()
+	for ts := int64(1001); ts <= 1003; ts++ {
+		if _, err := vs.Write(1, 1, ts*10, []byte(fmt.Sprintf("v%d", ts-1000))); err != nil {
+			t.Fatal(err)
+		}
+		// Flushed each time, so each version reaches a TOC file.
+		vs.Flush()
+	}
+	if _, err := vs.Delete(1, 1, 10050); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	for _, c := range []struct {
+		asOf  int64
+		ts    int64
+		value string
+		err   error
+	}{
+		{10009, 0, "", ErrNotFound},
+		{10010, 10010, "v1", nil},
+		{10015, 10010, "v1", nil},
+		{10020, 10020, "v2", nil},
+		{10049, 10030, "v3", nil},
+		{10050, 10050, "", ErrNotFound},
+		{20000, 10050, "", ErrNotFound},
+	} {
+		if ts, value, err := vs.ReadAsOf(1, 1, c.asOf, nil); err != c.err || ts != c.ts || string(value) != c.value {
+			t.Fatal(c.asOf, ts, string(value), err)
+		}
+	}
+	if ts, _, err := vs.ReadAsOf(1, 2, 20000, nil); err != ErrNotFound || ts != 0 {
+		t.Fatal(ts, err)
+	}
+}
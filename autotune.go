@@ -0,0 +1,109 @@
+package valuestore
+
+import (
+	"os"
+	"path"
+	"runtime"
+)
+
+// hardwareInfo is what could be detected about the machine and the device
+// holding the values files; zero values mean unknown.
+type hardwareInfo struct {
+	cores        int
+	memAvailable uint64
+	// rotational is only valid if rotationalKnown is true.
+	rotational      bool
+	rotationalKnown bool
+	freeBytes       uint64
+}
+
+// detectHardware checks the device of p or, if p doesn't exist yet, of its
+// nearest existing parent.
+func detectHardware(p string) hardwareInfo {
+	for {
+		if _, err := os.Stat(p); err == nil || path.Dir(p) == p {
+			break
+		}
+		p = path.Dir(p)
+	}
+	hw := hardwareInfo{cores: runtime.NumCPU(), memAvailable: detectMemAvailable(), freeBytes: detectFreeBytes(p)}
+	hw.rotational, hw.rotationalKnown = detectRotational(p)
+	return hw
+}
+
+// autoTune fills in the zero tuning values in cfg based on hw. Values already
+// set are left alone, and anything autoTune leaves zero will get the usual
+// defaults.
+func autoTune(cfg *Config, hw hardwareInfo) {
+	if cfg.Workers == 0 && hw.cores > 0 {
+		cfg.Workers = hw.cores
+	}
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if hw.rotationalKnown {
+		if hw.rotational {
+			// Seeks are expensive; batch writes into larger pages and keep
+			// the number of concurrent readers and compactors low.
+			if cfg.PageSize == 0 {
+				cfg.PageSize = 16 * 1024 * 1024
+			}
+			if cfg.WritePagesPerWorker == 0 {
+				cfg.WritePagesPerWorker = 4
+			}
+			if cfg.ValuesFileReaders == 0 {
+				cfg.ValuesFileReaders = 2
+			}
+			if cfg.CompactionWorkers == 0 {
+				cfg.CompactionWorkers = 1
+			}
+		} else {
+			if cfg.ValuesFileReaders == 0 {
+				cfg.ValuesFileReaders = workers * 2
+			}
+			if cfg.CompactionWorkers == 0 {
+				cfg.CompactionWorkers = (workers + 1) / 2
+			}
+		}
+	}
+	if hw.memAvailable > 0 {
+		// Keep the write buffers to about a sixteenth of the available
+		// memory, first by using fewer pages and then smaller ones.
+		budget := hw.memAvailable / 16
+		pageSize := uint64(cfg.PageSize)
+		if pageSize == 0 {
+			pageSize = 4 * 1024 * 1024
+		}
+		pages := uint64(cfg.WritePagesPerWorker)
+		if pages == 0 {
+			pages = 3
+		}
+		if uint64(workers)*pages*pageSize > budget && cfg.WritePagesPerWorker == 0 {
+			pages = 2
+			cfg.WritePagesPerWorker = 2
+		}
+		if uint64(workers)*pages*pageSize > budget && cfg.PageSize == 0 {
+			// resolveConfig will still raise this to hold at least one
+			// ValueCap value.
+			pageSize = budget / (uint64(workers) * pages)
+			if pageSize < 1024*1024 {
+				pageSize = 1024 * 1024
+			}
+			cfg.PageSize = int(pageSize)
+		}
+		if cfg.OutPullReplicationBloomN == 0 && hw.memAvailable < 1024*1024*1024 {
+			cfg.OutPullReplicationBloomN = 100000
+		}
+	}
+	if hw.freeBytes > 0 && cfg.ValuesFileCap == 0 {
+		// Smaller values files when space is tight, so compaction needs less
+		// room to rewrite a file.
+		if c := hw.freeBytes / 64; c < 4*1024*1024*1024-1 {
+			if c < 64*1024*1024 {
+				c = 64 * 1024 * 1024
+			}
+			cfg.ValuesFileCap = int(c)
+		}
+	}
+}
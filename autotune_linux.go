@@ -0,0 +1,89 @@
+package valuestore
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func detectMemAvailable() uint64 {
+	fp, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+func detectFileLimit() uint64 {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+	return uint64(rl.Cur)
+}
+
+func detectFreeBytes(p string) uint64 {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p, &st); err != nil {
+		return 0
+	}
+	return st.Bavail * uint64(st.Bsize)
+}
+
+// blockDeviceDir returns the sysfs directory of the block device holding p,
+// or empty if p can't be checked.
+func blockDeviceDir(p string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(p, &st); err != nil {
+		return ""
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+}
+
+func detectRotational(p string) (bool, bool) {
+	base := blockDeviceDir(p)
+	if base == "" {
+		return false, false
+	}
+	// A partition doesn't have its own queue, so the parent device is
+	// checked too. The base is a symlink, so the .. can't be cleaned away.
+	for _, name := range []string{base + "/queue/rotational", base + "/../queue/rotational"} {
+		if b, err := ioutil.ReadFile(name); err == nil {
+			return strings.TrimSpace(string(b)) == "1", true
+		}
+	}
+	return false, false
+}
+
+// detectInflightName returns the sysfs file giving the reads and writes in
+// flight for the device holding p, or empty if there isn't one.
+func detectInflightName(p string) string {
+	base := blockDeviceDir(p)
+	if base == "" {
+		return ""
+	}
+	name := base + "/inflight"
+	if _, err := os.Stat(name); err != nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package valuestore
+
+func detectMemAvailable() uint64 {
+	return 0
+}
+
+func detectFileLimit() uint64 {
+	return 0
+}
+
+func detectFreeBytes(p string) uint64 {
+	return 0
+}
+
+func detectRotational(p string) (bool, bool) {
+	return false, false
+}
+
+func detectInflightName(p string) string {
+	return ""
+}
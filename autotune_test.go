@@ -0,0 +1,50 @@
+package valuestore
+
+import "testing"
+
+func TestAutoTuneRotational(t *testing.T) {
+	cfg := &Config{ValuesFileReaders: 5}
+	autoTune(cfg, hardwareInfo{cores: 8, rotational: true, rotationalKnown: true})
+	if cfg.Workers != 8 || cfg.PageSize != 16*1024*1024 || cfg.CompactionWorkers != 1 {
+		t.Fatal(cfg.Workers, cfg.PageSize, cfg.CompactionWorkers)
+	}
+	// Explicit settings are left alone.
+	if cfg.ValuesFileReaders != 5 {
+		t.Fatal(cfg.ValuesFileReaders)
+	}
+	cfg = &Config{}
+	autoTune(cfg, hardwareInfo{cores: 8, rotationalKnown: true})
+	if cfg.ValuesFileReaders != 16 || cfg.CompactionWorkers != 4 || cfg.PageSize != 0 {
+		t.Fatal(cfg.ValuesFileReaders, cfg.CompactionWorkers, cfg.PageSize)
+	}
+}
+
+func TestAutoTuneMemory(t *testing.T) {
+	cfg := &Config{}
+	autoTune(cfg, hardwareInfo{cores: 8, memAvailable: 512 * 1024 * 1024})
+	// 8 workers * 2 pages must fit in 32M.
+	if cfg.WritePagesPerWorker != 2 || cfg.PageSize != 2*1024*1024 {
+		t.Fatal(cfg.WritePagesPerWorker, cfg.PageSize)
+	}
+	if cfg.OutPullReplicationBloomN != 100000 {
+		t.Fatal(cfg.OutPullReplicationBloomN)
+	}
+	cfg = &Config{}
+	autoTune(cfg, hardwareInfo{cores: 8, memAvailable: 64 * 1024 * 1024 * 1024})
+	if cfg.WritePagesPerWorker != 0 || cfg.PageSize != 0 || cfg.OutPullReplicationBloomN != 0 {
+		t.Fatal(cfg.WritePagesPerWorker, cfg.PageSize, cfg.OutPullReplicationBloomN)
+	}
+}
+
+func TestAutoTuneFreeBytes(t *testing.T) {
+	cfg := &Config{}
+	autoTune(cfg, hardwareInfo{freeBytes: 64 * 1024 * 1024 * 1024})
+	if cfg.ValuesFileCap != 1024*1024*1024 {
+		t.Fatal(cfg.ValuesFileCap)
+	}
+	cfg = &Config{}
+	autoTune(cfg, hardwareInfo{freeBytes: 1024 * 1024 * 1024 * 1024})
+	if cfg.ValuesFileCap != 0 {
+		t.Fatal(cfg.ValuesFileCap)
+	}
+}
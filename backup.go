@@ -0,0 +1,248 @@
+package valuestore
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// BACKUP_MANIFEST_VERSION is the BackupManifest.Version BackupTo writes and
+// RestoreFrom accepts.
+const BACKUP_MANIFEST_VERSION = 1
+
+// The names of the entries in a backup tar: the manifest comes first, then
+// the values files under BACKUP_VALUES_DIR and the TOC files under
+// BACKUP_TOC_DIR, each with its name as in Path or PathTOC.
+const (
+	BACKUP_MANIFEST   = "manifest.json"
+	BACKUP_VALUES_DIR = "values/"
+	BACKUP_TOC_DIR    = "valuestoc/"
+)
+
+// BackupManifest is the JSON first entry of a backup tar written by BackupTo,
+// describing the rest.
+type BackupManifest struct {
+	Version int
+	// Timestampmicro is when the backup was taken; values written after may
+	// or may not be included.
+	Timestampmicro int64
+	// ValuesFiles maps each values file's name to its length in the backup.
+	ValuesFiles map[string]int64
+	// TOCFiles maps each TOC file's name to its length in the backup.
+	TOCFiles map[string]int64
+}
+
+// BackupTo writes a tar of the store's values and TOC files to w, led by a
+// BackupManifest; RestoreFrom turns it back into a store's directories.
+//
+// The store keeps running while the backup is written. Buffered writes are
+// flushed first and a Snapshot is held throughout, so compaction leaves the
+// files alone while they're copied. Files still being written are copied up
+// to their length as of the start, the TOC files before the values files, so
+// every TOC entry copied has its value copied too; recovery of the restored
+// files then treats them as it would after a crash.
+func (vs *DefaultValueStore) BackupTo(w io.Writer) error {
+	s := vs.Snapshot()
+	defer s.Release()
+	vs.Flush()
+	// The first Snapshot pins the files the buffered writes were flushed
+	// alongside; this one takes in any the flush had to start.
+	s = vs.Snapshot()
+	defer s.Release()
+	manifest := &BackupManifest{
+		Version:        BACKUP_MANIFEST_VERSION,
+		Timestampmicro: brimtime.TimeToUnixMicro(time.Now()),
+		ValuesFiles:    map[string]int64{},
+		TOCFiles:       map[string]int64{},
+	}
+	tocNames, err := vs.backupNames(vs.tocDirs(), ".valuestoc", s.nanos, manifest.TOCFiles)
+	if err != nil {
+		return err
+	}
+	valuesNames, err := vs.backupNames(vs.valuesDirs(), ".values", s.nanos, manifest.ValuesFiles)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	mtime := time.Now()
+	if err = tw.WriteHeader(&tar.Header{Name: BACKUP_MANIFEST, Mode: 0644, Size: int64(len(b)), ModTime: mtime}); err != nil {
+		return err
+	}
+	if _, err = tw.Write(b); err != nil {
+		return err
+	}
+	for _, name := range valuesNames {
+		if err = backupFile(tw, BACKUP_VALUES_DIR+path.Base(name), name, manifest.ValuesFiles[path.Base(name)], mtime); err != nil {
+			return err
+		}
+	}
+	for _, name := range tocNames {
+		if err = backupFile(tw, BACKUP_TOC_DIR+path.Base(name), name, manifest.TOCFiles[path.Base(name)], mtime); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// backupNames returns the full names of the files in the dirs with the
+// suffix that existed as of nanos, sorted by their base names, recording
+// their current lengths in lengths by base name. Files WriteStream is still
+// writing are left out, as they have no values stored in them yet.
+func (vs *DefaultValueStore) backupNames(dirs []string, suffix string, nanos int64, lengths map[string]int64) ([]string, error) {
+	var names []string
+	for _, dir := range dirs {
+		fp, err := os.Open(dir)
+		if err != nil {
+			return nil, err
+		}
+		all, err := fp.Readdirnames(-1)
+		fp.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range all {
+			if !strings.HasSuffix(name, suffix) {
+				continue
+			}
+			namets, err := strconv.ParseInt(strings.TrimSuffix(name, suffix), 10, 64)
+			if err != nil || namets >= nanos || vs.writeStreaming(namets) {
+				continue
+			}
+			fi, err := os.Stat(path.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			lengths[name] = fi.Size()
+			names = append(names, path.Join(dir, name))
+		}
+	}
+	sort.Slice(names, func(i int, j int) bool {
+		return path.Base(names[i]) < path.Base(names[j])
+	})
+	return names, nil
+}
+
+// backupFile writes the first length bytes of the file at fpath to tw as
+// name.
+func backupFile(tw *tar.Writer, name string, fpath string, length int64, mtime time.Time) error {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: length, ModTime: mtime}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, fp, length)
+	return err
+}
+
+// RestoreFrom reads a tar written by BackupTo from r and writes its files into
+// the Path and PathTOC the Config describes, creating them if need be; New
+// with that Config then opens the restored store. Path and PathTOC must not
+// already have values or TOC files, else ErrRestoreNotEmpty is returned. An
+// error is returned if the tar does not match its manifest, in which case
+// some files may have been written already. With Config.Paths, the files are
+// all restored into the first of them; new files spread across the rest.
+func RestoreFrom(r io.Reader, c *Config) error {
+	cfg := resolveConfig(c)
+	for _, p := range []string{cfg.Path, cfg.PathTOC} {
+		if err := checkPath(p); err != nil {
+			return err
+		}
+		fp, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		names, err := fp.Readdirnames(-1)
+		fp.Close()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if strings.HasSuffix(name, ".values") || strings.HasSuffix(name, ".valuestoc") {
+				return ErrRestoreNotEmpty
+			}
+		}
+	}
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != BACKUP_MANIFEST {
+		return fmt.Errorf("backup starts with %q rather than %q", hdr.Name, BACKUP_MANIFEST)
+	}
+	manifest := &BackupManifest{}
+	if err = json.NewDecoder(tr).Decode(manifest); err != nil {
+		return err
+	}
+	if manifest.Version != BACKUP_MANIFEST_VERSION {
+		return fmt.Errorf("backup manifest version %d is not %d", manifest.Version, BACKUP_MANIFEST_VERSION)
+	}
+	restored := 0
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var dir string
+		var length int64
+		var ok bool
+		name := hdr.Name
+		if strings.HasPrefix(name, BACKUP_VALUES_DIR) {
+			name = strings.TrimPrefix(name, BACKUP_VALUES_DIR)
+			dir = cfg.Path
+			length, ok = manifest.ValuesFiles[name]
+		} else if strings.HasPrefix(name, BACKUP_TOC_DIR) {
+			name = strings.TrimPrefix(name, BACKUP_TOC_DIR)
+			dir = cfg.PathTOC
+			length, ok = manifest.TOCFiles[name]
+		}
+		// Only plain names are accepted, keeping the files inside Path and
+		// PathTOC.
+		if !ok || hdr.Size != length || path.Base(name) != name || name == ".." {
+			return fmt.Errorf("backup entry %q does not match the manifest", hdr.Name)
+		}
+		if err = restoreFile(tr, path.Join(dir, name)); err != nil {
+			return err
+		}
+		restored++
+	}
+	if restored != len(manifest.ValuesFiles)+len(manifest.TOCFiles) {
+		return fmt.Errorf("backup has %d of the %d files in its manifest", restored, len(manifest.ValuesFiles)+len(manifest.TOCFiles))
+	}
+	return nil
+}
+
+func restoreFile(r io.Reader, fpath string) error {
+	fp, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(fp, r); err != nil {
+		fp.Close()
+		return err
+	}
+	if err = fp.Sync(); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
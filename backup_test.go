@@ -0,0 +1,68 @@
+package valuestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	vs.EnableWrites()
+	defer vs.Close()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, err := vs.Write(keyA, 2, int64(1000+keyA), []byte(fmt.Sprintf("value%d", keyA))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = vs.Delete(5, 2, 5000); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err = vs.BackupTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err = RestoreFrom(bytes.NewReader(buf.Bytes()), &Config{Path: dir, IgnoreEnv: true}); err != ErrRestoreNotEmpty {
+		t.Fatal(err)
+	}
+	dir2, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+	if err = RestoreFrom(bytes.NewReader(buf.Bytes()), &Config{Path: dir2 + "/values", PathTOC: dir2 + "/valuestoc", IgnoreEnv: true}); err != nil {
+		t.Fatal(err)
+	}
+	vs2, err := NewWithContext(context.Background(), &Config{Path: dir2 + "/values", PathTOC: dir2 + "/valuestoc", IgnoreEnv: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vs2.Close()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		ts, v, err := vs2.Read(keyA, 2, nil)
+		if keyA == 5 {
+			if err != ErrNotFound || ts != 5000 {
+				t.Fatal(ts, err)
+			}
+		} else if err != nil || ts != int64(1000+keyA) || string(v) != fmt.Sprintf("value%d", keyA) {
+			t.Fatal(keyA, ts, string(v), err)
+		}
+	}
+	// A tar that doesn't match its manifest is refused.
+	dir3, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir3)
+	if err = RestoreFrom(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), &Config{Path: dir3, IgnoreEnv: true}); err == nil {
+		t.Fatal("truncated backup restored")
+	}
+}
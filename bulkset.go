@@ -1,78 +1,146 @@
 package valuestore
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
+	"sort"
 	"sync/atomic"
 	"time"
 )
 
-// bsm: senderNodeID:8 entries:n
+// bsm: senderNodeID:8 msgID:8 flags:1 entries:n
+// bsm flags: ackPolicy in the low two bits, checksum in bit 5, compressed in
+// bit 6, backfill in the high bit
 // bsm entry: keyA:8, keyB:8, timestampbits:8, length:4, value:n
-const _BULK_SET_MSG_TYPE = 0x44f58445991a4aa1
-const _BULK_SET_MSG_HEADER_LENGTH = 8
+// bsm with the checksum flag: the entries are followed by checksum:4; see
+// msgChecksum
+const _BULK_SET_MSG_TYPE = 0x5f0e82a4c93d71b6
+const _BULK_SET_MSG_HEADER_LENGTH = 17
 const _BULK_SET_MSG_ENTRY_HEADER_LENGTH = 28
 const _BULK_SET_MSG_MIN_ENTRY_LENGTH = 28
+const _BULK_SET_MSG_FLAG_ACK_POLICY_MASK = 0x03
+const _BULK_SET_MSG_FLAG_CHECKSUM = 0x20
+const _BULK_SET_MSG_FLAG_COMPRESSED = 0x40
+const _BULK_SET_MSG_FLAG_BACKFILL = 0x80
 
 type bulkSetState struct {
+	// outMsgID is the last msgID given to an outgoing bulk-set message; the
+	// msgIDs are echoed back in the bulk-set-ack messages, see peerFlowAcked.
+	outMsgID             uint64
 	msgCap               int
 	inMsgChan            chan *bulkSetMsg
+	inPriorityMsgChan    chan *bulkSetMsg
 	inFreeMsgChan        chan *bulkSetMsg
 	inResponseMsgTimeout time.Duration
 	outFreeMsgChan       chan *bulkSetMsg
 	inBulkSetDoneChans   []chan struct{}
+	inPriorityDoneChans  []chan struct{}
+	inPool               *msgPool
+	outPool              *msgPool
+	hook                 ReplicationHookFunc
+	resolver             ConflictResolverFunc
+}
+
+// The ack policies a bulk-set message may request of its receiver.
+const (
+	// BULK_SET_ACK_APPLIED has the receiver ack just the entries it stored
+	// and is responsible for, allowing the sender to remove its copies.
+	BULK_SET_ACK_APPLIED = 0
+	// BULK_SET_ACK_ALL has the receiver ack every entry with a status of
+	// how it was handled.
+	BULK_SET_ACK_ALL = 1
+	// BULK_SET_ACK_NONE has the receiver send no ack at all.
+	BULK_SET_ACK_NONE = 2
+)
+
+type bulkSetKey struct {
+	keyA uint64
+	keyB uint64
 }
 
 type bulkSetMsg struct {
-	vs     *DefaultValueStore
-	header []byte
-	body   []byte
+	vs       *DefaultValueStore
+	header   []byte
+	body     []byte
+	peerFlow []uint64
+	// admitted is set for incoming messages once the sending node has been
+	// charged for it with inBulkSetAdmit.
+	admitted bool
 }
 
 func (vs *DefaultValueStore) bulkSetConfig(cfg *Config) {
+	vs.bulkSetState.msgCap = cfg.BulkSetMsgCap
 	if vs.msgRing != nil {
 		vs.msgRing.SetMsgHandler(_BULK_SET_MSG_TYPE, vs.newInBulkSetMsg)
-		vs.bulkSetState.inMsgChan = make(chan *bulkSetMsg, cfg.InBulkSetMsgs)
-		vs.bulkSetState.inFreeMsgChan = make(chan *bulkSetMsg, cfg.InBulkSetMsgs)
-		for i := 0; i < cap(vs.bulkSetState.inFreeMsgChan); i++ {
-			vs.bulkSetState.inFreeMsgChan <- &bulkSetMsg{
-				vs:     vs,
-				header: make([]byte, _BULK_SET_MSG_HEADER_LENGTH),
-				body:   make([]byte, cfg.BulkSetMsgCap),
+		// Unbuffered so that the order messages are worked on is decided by
+		// the inBulkSetScheduler rather than by arrival.
+		vs.bulkSetState.inMsgChan = make(chan *bulkSetMsg)
+		vs.bulkSetState.inPriorityMsgChan = make(chan *bulkSetMsg)
+		vs.bulkSetState.hook = cfg.ReplicationHook
+		vs.bulkSetState.resolver = cfg.ConflictResolver
+		vs.bulkSetState.inPool = vs.newMsgPool("inBulkSetMsgPool", cfg, cfg.InBulkSetMsgs, func() bool {
+			select {
+			case <-vs.bulkSetState.inFreeMsgChan:
+				return true
+			default:
+				return false
 			}
+		})
+		vs.bulkSetState.inFreeMsgChan = make(chan *bulkSetMsg, vs.bulkSetState.inPool.max)
+		for i := int32(0); i < vs.bulkSetState.inPool.min; i++ {
+			vs.bulkSetState.inFreeMsgChan <- vs.allocBulkSetMsg()
 		}
 		vs.bulkSetState.inBulkSetDoneChans = make([]chan struct{}, cfg.InBulkSetWorkers)
 		for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
 			vs.bulkSetState.inBulkSetDoneChans[i] = make(chan struct{}, 1)
 		}
-		vs.bulkSetState.msgCap = cfg.BulkSetMsgCap
-		vs.bulkSetState.outFreeMsgChan = make(chan *bulkSetMsg, cfg.OutBulkSetMsgs)
-		for i := 0; i < cap(vs.bulkSetState.outFreeMsgChan); i++ {
-			vs.bulkSetState.outFreeMsgChan <- &bulkSetMsg{
-				vs:     vs,
-				header: make([]byte, _BULK_SET_MSG_HEADER_LENGTH),
-				body:   make([]byte, cfg.BulkSetMsgCap),
-			}
+		vs.bulkSetState.inPriorityDoneChans = make([]chan struct{}, cfg.InBulkSetPriorityWorkers)
+		for i := 0; i < len(vs.bulkSetState.inPriorityDoneChans); i++ {
+			vs.bulkSetState.inPriorityDoneChans[i] = make(chan struct{}, 1)
 		}
 		vs.bulkSetState.inResponseMsgTimeout = time.Duration(cfg.InBulkSetResponseMsgTimeout) * time.Millisecond
 	}
+	// Outgoing messages are also needed just for remote replication.
+	if vs.msgRing != nil || cfg.RemoteMsgRing != nil {
+		vs.bulkSetState.outPool = vs.newMsgPool("outBulkSetMsgPool", cfg, cfg.OutBulkSetMsgs, func() bool {
+			select {
+			case <-vs.bulkSetState.outFreeMsgChan:
+				return true
+			default:
+				return false
+			}
+		})
+		vs.bulkSetState.outFreeMsgChan = make(chan *bulkSetMsg, vs.bulkSetState.outPool.max)
+		for i := int32(0); i < vs.bulkSetState.outPool.min; i++ {
+			vs.bulkSetState.outFreeMsgChan <- vs.allocBulkSetMsg()
+		}
+	}
 }
 
 func (vs *DefaultValueStore) bulkSetLaunch() {
 	for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
-		go vs.inBulkSet(vs.bulkSetState.inBulkSetDoneChans[i])
+		go vs.inBulkSet(vs.bulkSetState.inMsgChan, vs.bulkSetState.inBulkSetDoneChans[i])
+	}
+	for i := 0; i < len(vs.bulkSetState.inPriorityDoneChans); i++ {
+		go vs.inBulkSet(vs.bulkSetState.inPriorityMsgChan, vs.bulkSetState.inPriorityDoneChans[i])
 	}
 }
 
-// newInBulkSetMsg reads bulk-set messages from the MsgRing and puts them on
-// the inMsgChan for the inBulkSet workers to work on.
+// newInBulkSetMsg reads bulk-set messages from the MsgRing and queues them for
+// the inBulkSetScheduler to hand to the inBulkSet workers.
 func (vs *DefaultValueStore) newInBulkSetMsg(r io.Reader, l uint64) (uint64, error) {
 	var bsm *bulkSetMsg
 	select {
 	case bsm = <-vs.bulkSetState.inFreeMsgChan:
+		vs.bulkSetState.inPool.got(len(vs.bulkSetState.inFreeMsgChan))
 	default:
-		// If there isn't a free bulkSetMsg, just read and discard the incoming
-		// bulk-set message.
+		if vs.bulkSetState.inPool.grow() {
+			bsm = vs.allocBulkSetMsg()
+			break
+		}
+		// If there isn't a free bulkSetMsg, and the pool is at its maximum
+		// size, just read and discard the incoming bulk-set message.
 		left := l
 		var sn int
 		var err error
@@ -125,6 +193,28 @@ func (vs *DefaultValueStore) newInBulkSetMsg(r io.Reader, l uint64) (uint64, err
 		}
 	}
 	l -= uint64(len(bsm.header))
+	// If the sending node is already using its share of the incoming bulk-set
+	// messages, or is over its byte rate, throw this one away so that other
+	// nodes' messages can still get through.
+	if !vs.inBulkSetAdmit(bsm.nodeID(), l) {
+		vs.bulkSetState.inFreeMsgChan <- bsm
+		left := l
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err = r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				atomic.AddInt32(&vs.inBulkSetInvalids, 1)
+				return _BULK_SET_MSG_HEADER_LENGTH + l - left, err
+			}
+		}
+		atomic.AddInt32(&vs.inBulkSetPeerDrops, 1)
+		return _BULK_SET_MSG_HEADER_LENGTH + l, nil
+	}
+	bsm.admitted = true
 	// TODO: I think we should cap the body size to vs.bulkSetState.msgCap but
 	// that also means that the inBulkSet worker will need to handle the likely
 	// trailing truncated entry. Once all this is done, the overall cluster
@@ -145,76 +235,246 @@ func (vs *DefaultValueStore) newInBulkSetMsg(r io.Reader, l uint64) (uint64, err
 		sn, err = r.Read(bsm.body[n:])
 		n += sn
 		if err != nil {
-			vs.bulkSetState.inFreeMsgChan <- bsm
+			vs.inBulkSetFree(bsm)
 			atomic.AddInt32(&vs.inBulkSetInvalids, 1)
 			return uint64(len(bsm.header)) + uint64(n), err
 		}
 	}
-	vs.bulkSetState.inMsgChan <- bsm
+	if bsm.header[16]&_BULK_SET_MSG_FLAG_CHECKSUM != 0 {
+		var ok bool
+		if bsm.body, ok = msgChecksumVerify(bsm.header, bsm.body); !ok {
+			vs.inBulkSetFree(bsm)
+			atomic.AddInt32(&vs.inBulkSetCorrupts, 1)
+			return uint64(len(bsm.header)) + l, nil
+		}
+		bsm.header[16] &^= _BULK_SET_MSG_FLAG_CHECKSUM
+	}
+	vs.inBulkSetEnqueue(bsm)
 	atomic.AddInt32(&vs.inBulkSets, 1)
 	return uint64(len(bsm.header)) + l, nil
 }
 
-// inBulkSet actually processes incoming bulk-set messages; there may be more
-// than one of these workers.
-func (vs *DefaultValueStore) inBulkSet(doneChan chan struct{}) {
+// inBulkSet actually processes incoming bulk-set messages from msgChan; there
+// may be more than one of these workers for each lane.
+func (vs *DefaultValueStore) inBulkSet(msgChan chan *bulkSetMsg, doneChan chan struct{}) {
+	// newest records the newest timestampbits for each key in the message
+	// being processed, so that duplicate entries can be coalesced and only
+	// the newest one written.
+	newest := make(map[bulkSetKey]uint64)
+	// batch is reused for each message to apply all its entries with one
+	// writeBatch call.
+	var batch []valueWriteBatchEntry
+	// backfill holds the outgoing messages, by partition, used to pass on
+	// entries from a backfill message to the other replicas.
+	backfill := make(map[uint32]*bulkSetMsg)
+	// local is used to read local values when looking for conflicts.
+	var local []byte
+	if vs.bulkSetState.resolver != nil {
+		local = make([]byte, vs.valueCap)
+	}
+	// inflated holds the entries of a compressed message with their values
+	// decompressed.
+	var inflated []byte
 	for {
-		bsm := <-vs.bulkSetState.inMsgChan
+		var bsm *bulkSetMsg
+		select {
+		case bsm = <-msgChan:
+		case <-vs.closeState.backgroundChan:
+		}
 		if bsm == nil {
 			break
 		}
-		body := bsm.body
-		var err error
+		for k := range newest {
+			delete(newest, k)
+		}
+		entries := bsm.body
+		if bsm.compressed() {
+			var err error
+			if inflated, err = vs.bulkSetInflate(inflated[:0], bsm.body); err != nil {
+				atomic.AddInt32(&vs.inBulkSetInvalids, 1)
+				vs.inBulkSetFree(bsm)
+				continue
+			}
+			entries = inflated
+		}
+		for body := entries; len(body) >= _BULK_SET_MSG_ENTRY_HEADER_LENGTH; {
+			k := bulkSetKey{binary.BigEndian.Uint64(body), binary.BigEndian.Uint64(body[8:])}
+			timestampbits := binary.BigEndian.Uint64(body[16:])
+			if t, ok := newest[k]; !ok || timestampbits > t {
+				newest[k] = timestampbits
+			}
+			body = body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH+binary.BigEndian.Uint32(body[24:]):]
+		}
+		body := entries
 		ring := vs.msgRing.Ring()
 		var rightwardPartitionShift uint64
 		var bsam *bulkSetAckMsg
-		var rtimestampbits uint64
 		if ring != nil {
 			rightwardPartitionShift = 64 - uint64(ring.PartitionBitCount())
-			// Only ack if there is someone to ack to, which should always be
-			// the case but just in case.
-			if bsm.nodeID() != 0 {
+			// Only ack if asked to and there is someone to ack to, which
+			// should always be the case but just in case.
+			if bsm.nodeID() != 0 && bsm.ackPolicy() != BULK_SET_ACK_NONE {
 				bsam = vs.newOutBulkSetAckMsg()
+				bsam.status = bsm.ackPolicy() == BULK_SET_ACK_ALL
+				bsam.addMsgID(bsm.msgID())
 			}
 		}
-		for len(body) > _BULK_SET_MSG_ENTRY_HEADER_LENGTH {
+		for len(body) >= _BULK_SET_MSG_ENTRY_HEADER_LENGTH {
 			keyA := binary.BigEndian.Uint64(body)
 			keyB := binary.BigEndian.Uint64(body[8:])
 			timestampbits := binary.BigEndian.Uint64(body[16:])
 			l := binary.BigEndian.Uint32(body[24:])
+			k := bulkSetKey{keyA, keyB}
+			if newest[k] != timestampbits {
+				atomic.AddInt32(&vs.inBulkSetCoalesced, 1)
+				if bsam != nil && bsam.status {
+					bsam.addStatus(keyA, keyB, timestampbits, _BULK_SET_ACK_STATUS_OVERRIDDEN)
+				}
+				if vs.bulkSetState.hook != nil {
+					vs.bulkSetState.hook(keyA, keyB, int64(timestampbits>>_TSB_UTIL_BITS), timestampbits&_TSB_DELETION != 0, body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH:_BULK_SET_MSG_ENTRY_HEADER_LENGTH+l], false)
+				}
+				body = body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH+l:]
+				continue
+			}
+			// Zeroed so any later entry with the exact same timestampbits is
+			// coalesced as well.
+			newest[k] = 0
 			atomic.AddInt32(&vs.inBulkSetWrites, 1)
 			// Attempt to store everything received...
-			rtimestampbits, err = vs.write(keyA, keyB, timestampbits, body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH:_BULK_SET_MSG_ENTRY_HEADER_LENGTH+l])
-			if err != nil {
+			batch = append(batch, valueWriteBatchEntry{
+				keyA:          keyA,
+				keyB:          keyB,
+				timestampbits: timestampbits,
+				value:         body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH : _BULK_SET_MSG_ENTRY_HEADER_LENGTH+l],
+			})
+			body = body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH+l:]
+		}
+		vs.writeBatch(batch, false)
+		for i := range batch {
+			e := &batch[i]
+			if e.err != nil {
 				atomic.AddInt32(&vs.inBulkSetWriteErrors, 1)
-			} else if rtimestampbits != timestampbits {
+			} else if e.ptimestampbits != e.timestampbits {
 				atomic.AddInt32(&vs.inBulkSetWritesOverridden, 1)
 			}
-			// But only ack on success, there is someone to ack to, and the
-			// local node is responsible for the data.
-			if err == nil && bsam != nil && ring != nil && ring.Responsible(uint32(keyA>>rightwardPartitionShift)) {
-				bsam.add(keyA, keyB, timestampbits)
+			if bsam != nil && bsam.status {
+				// Everything is acked, with how it went.
+				status := byte(_BULK_SET_ACK_STATUS_APPLIED)
+				if e.err != nil {
+					status = _BULK_SET_ACK_STATUS_ERROR
+				} else if ring == nil || !ring.Responsible(uint32(e.keyA>>rightwardPartitionShift)) {
+					status = _BULK_SET_ACK_STATUS_NOT_RESPONSIBLE
+				} else if e.ptimestampbits >= e.timestampbits {
+					status = _BULK_SET_ACK_STATUS_OVERRIDDEN
+				}
+				bsam.addStatus(e.keyA, e.keyB, e.timestampbits, status)
+			} else if e.err == nil && bsam != nil && ring != nil && ring.Responsible(uint32(e.keyA>>rightwardPartitionShift)) {
+				// Otherwise only ack on success, there is someone to ack to,
+				// and the local node is responsible for the data.
+				bsam.add(e.keyA, e.keyB, e.timestampbits)
 			}
-			body = body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH+l:]
+			if local != nil && e.err == nil && e.timestampbits&_TSB_DELETION == 0 && e.ptimestampbits >= e.timestampbits && e.ptimestampbits>>_TSB_UTIL_BITS == e.timestampbits>>_TSB_UTIL_BITS {
+				local = vs.inBulkSetConflict(e, local)
+			}
+			if e.err == nil && vs.bulkSetState.hook != nil {
+				vs.bulkSetState.hook(e.keyA, e.keyB, int64(e.timestampbits>>_TSB_UTIL_BITS), e.timestampbits&_TSB_DELETION != 0, e.value, e.ptimestampbits < e.timestampbits)
+			}
+			// Backfilled entries are passed on right away rather than waiting
+			// for them to age past replicationIgnoreRecent, which would add
+			// that delay to every hop. Only entries actually applied here are
+			// passed on, so this stops once all the replicas have them.
+			if bsm.backfill() && e.err == nil && e.ptimestampbits < e.timestampbits && ring != nil {
+				if partition := uint32(e.keyA >> rightwardPartitionShift); ring.Responsible(partition) {
+					vs.outBulkSetBackfill(backfill, partition, e.keyA, e.keyB, e.timestampbits, e.value)
+				}
+			}
+			// The values reference bsm.body, or inflated, which are about to
+			// be reused.
+			e.value = nil
+		}
+		batch = batch[:0]
+		for partition, obsm := range backfill {
+			vs.outBulkSetBackfillSend(obsm, partition)
+			delete(backfill, partition)
 		}
 		if bsam != nil {
-			atomic.AddInt32(&vs.outBulkSetAcks, 1)
-			vs.msgRing.MsgToNode(bsam, bsm.nodeID(), vs.bulkSetState.inResponseMsgTimeout)
+			vs.outBulkSetAck(bsam, bsm.nodeID())
 		}
-		vs.bulkSetState.inFreeMsgChan <- bsm
+		vs.inBulkSetFree(bsm)
+	}
+	// Closed rather than sent on, so Close still sees the worker is gone
+	// if something else was told first.
+	close(doneChan)
+}
+
+// bulkSetInflate appends to dst the entries of a compressed message's body
+// with their values decompressed, giving the body as it would be had the
+// message not been compressed.
+func (vs *DefaultValueStore) bulkSetInflate(dst []byte, body []byte) ([]byte, error) {
+	for len(body) >= _BULK_SET_MSG_ENTRY_HEADER_LENGTH {
+		l := int(binary.BigEndian.Uint32(body[24:]))
+		if _BULK_SET_MSG_ENTRY_HEADER_LENGTH+l > len(body) {
+			return dst, errBadCompressedValue
+		}
+		o := len(dst)
+		dst = append(dst, body[:_BULK_SET_MSG_ENTRY_HEADER_LENGTH]...)
+		var err error
+		if dst, err = vs.decompress(dst, body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH:_BULK_SET_MSG_ENTRY_HEADER_LENGTH+l]); err != nil {
+			return dst, err
+		}
+		binary.BigEndian.PutUint32(dst[o+24:], uint32(len(dst)-o-_BULK_SET_MSG_ENTRY_HEADER_LENGTH))
+		body = body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH+l:]
 	}
-	doneChan <- struct{}{}
+	return dst, nil
+}
+
+// inBulkSetConflict checks whether the entry, not applied because of an equal
+// local timestamp, has a different value than the local one and if so calls
+// the ConflictResolver. The local buffer is returned for reuse.
+func (vs *DefaultValueStore) inBulkSetConflict(e *valueWriteBatchEntry, local []byte) []byte {
+	timestampbits, local, err := vs.read(e.keyA, e.keyB, local[:0])
+	if err != nil || timestampbits>>_TSB_UTIL_BITS != e.timestampbits>>_TSB_UTIL_BITS || bytes.Equal(local, e.value) {
+		return local
+	}
+	atomic.AddInt32(&vs.inBulkSetConflicts, 1)
+	timestampmicro := int64(timestampbits >> _TSB_UTIL_BITS)
+	var value []byte
+	switch resolution, merged := vs.bulkSetState.resolver(e.keyA, e.keyB, timestampmicro, local, e.value); resolution {
+	case CONFLICT_KEEP_REMOTE:
+		value = e.value
+	case CONFLICT_MERGE:
+		value = merged
+	default:
+		return local
+	}
+	if timestampmicro >= TIMESTAMPMICRO_MAX {
+		return local
+	}
+	if _, err = vs.write(e.keyA, e.keyB, uint64(timestampmicro+1)<<_TSB_UTIL_BITS, value); err != nil {
+		atomic.AddInt32(&vs.inBulkSetWriteErrors, 1)
+	}
+	return local
 }
 
 // newOutBulkSetMsg gives an initialized bulkSetMsg for filling out and
 // eventually sending using the MsgRing. The MsgRing (or someone else if the
 // message doesn't end up with the MsgRing) will call bulkSetMsg.Free()
 // eventually and the bulkSetMsg will be requeued for reuse later. There is a
-// fixed number of outgoing bulkSetMsg instances that can exist at any given
+// maximum number of outgoing bulkSetMsg instances that can exist at any given
 // time, capping memory usage. Once the limit is reached, this method will
 // block until a bulkSetMsg is available to return.
 func (vs *DefaultValueStore) newOutBulkSetMsg() *bulkSetMsg {
-	bsm := <-vs.bulkSetState.outFreeMsgChan
+	var bsm *bulkSetMsg
+	select {
+	case bsm = <-vs.bulkSetState.outFreeMsgChan:
+		vs.bulkSetState.outPool.got(len(vs.bulkSetState.outFreeMsgChan))
+	default:
+		if vs.bulkSetState.outPool.grow() {
+			bsm = vs.allocBulkSetMsg()
+		} else {
+			bsm = <-vs.bulkSetState.outFreeMsgChan
+		}
+	}
 	if vs.msgRing != nil {
 		if r := vs.msgRing.Ring(); r != nil {
 			if n := r.LocalNode(); n != nil {
@@ -222,15 +482,51 @@ func (vs *DefaultValueStore) newOutBulkSetMsg() *bulkSetMsg {
 			}
 		}
 	}
+	binary.BigEndian.PutUint64(bsm.header[8:], atomic.AddUint64(&vs.bulkSetState.outMsgID, 1))
+	bsm.header[16] = BULK_SET_ACK_APPLIED | _BULK_SET_MSG_FLAG_CHECKSUM
 	bsm.body = bsm.body[:0]
 	return bsm
 }
 
+func (vs *DefaultValueStore) allocBulkSetMsg() *bulkSetMsg {
+	return &bulkSetMsg{
+		vs:     vs,
+		header: make([]byte, _BULK_SET_MSG_HEADER_LENGTH),
+		body:   make([]byte, vs.bulkSetState.msgCap),
+	}
+}
+
+// outBulkSetBackfill adds the entry to the outgoing backfill message for the
+// partition, sending the message on and starting another if it is full.
+func (vs *DefaultValueStore) outBulkSetBackfill(backfill map[uint32]*bulkSetMsg, partition uint32, keyA uint64, keyB uint64, timestampbits uint64, value []byte) {
+	bsm := backfill[partition]
+	if bsm != nil && !bsm.add(keyA, keyB, timestampbits, value) {
+		vs.outBulkSetBackfillSend(bsm, partition)
+		bsm = nil
+	}
+	if bsm == nil {
+		bsm = vs.newOutBulkSetMsg()
+		bsm.setAckPolicy(BULK_SET_ACK_NONE)
+		bsm.setBackfill()
+		backfill[partition] = bsm
+		bsm.add(keyA, keyB, timestampbits, value)
+	}
+}
+
+func (vs *DefaultValueStore) outBulkSetBackfillSend(bsm *bulkSetMsg, partition uint32) {
+	if vs.msgToOtherReplicas(bsm, partition, vs.bulkSetState.inResponseMsgTimeout) {
+		atomic.AddInt32(&vs.outBulkSetBackfills, 1)
+	}
+}
+
 func (bsm *bulkSetMsg) MsgType() uint64 {
 	return _BULK_SET_MSG_TYPE
 }
 
 func (bsm *bulkSetMsg) MsgLength() uint64 {
+	if bsm.header[16]&_BULK_SET_MSG_FLAG_CHECKSUM != 0 {
+		return uint64(len(bsm.header) + len(bsm.body) + _MSG_CHECKSUM_LENGTH)
+	}
 	return uint64(len(bsm.header) + len(bsm.body))
 }
 
@@ -240,17 +536,67 @@ func (bsm *bulkSetMsg) WriteContent(w io.Writer) (uint64, error) {
 		return uint64(n), err
 	}
 	n, err = w.Write(bsm.body)
-	return uint64(len(bsm.header)) + uint64(n), err
+	if err != nil || bsm.header[16]&_BULK_SET_MSG_FLAG_CHECKSUM == 0 {
+		return uint64(len(bsm.header)) + uint64(n), err
+	}
+	c := msgChecksumTrailer(bsm.header, bsm.body)
+	cn, err := w.Write(c[:])
+	return uint64(len(bsm.header)+len(bsm.body)) + uint64(cn), err
 }
 
 func (bsm *bulkSetMsg) Free() {
+	if bsm.ackPolicy() == BULK_SET_ACK_NONE {
+		bsm.vs.peerFlowFree(bsm)
+	} else {
+		// An ack is waited for no longer than the receiver would take to
+		// send it, were it configured as this store is.
+		bsm.vs.peerFlowAwaitAck(bsm, bsm.msgID(), bsm.vs.bulkSetState.inResponseMsgTimeout+bsm.vs.bulkSetAckState.outDelay)
+	}
 	bsm.vs.bulkSetState.outFreeMsgChan <- bsm
 }
 
+func (bsm *bulkSetMsg) peerFlowNodeIDs() *[]uint64 {
+	return &bsm.peerFlow
+}
+
 func (bsm *bulkSetMsg) nodeID() uint64 {
 	return binary.BigEndian.Uint64(bsm.header)
 }
 
+func (bsm *bulkSetMsg) msgID() uint64 {
+	return binary.BigEndian.Uint64(bsm.header[8:])
+}
+
+func (bsm *bulkSetMsg) ackPolicy() int {
+	return int(bsm.header[16] & _BULK_SET_MSG_FLAG_ACK_POLICY_MASK)
+}
+
+func (bsm *bulkSetMsg) setAckPolicy(ackPolicy int) {
+	bsm.header[16] = bsm.header[16]&^_BULK_SET_MSG_FLAG_ACK_POLICY_MASK | byte(ackPolicy)&_BULK_SET_MSG_FLAG_ACK_POLICY_MASK
+}
+
+// backfill indicates the message came from a handoff or backfill operation
+// rather than routine replication; these are processed in their own lane
+// and passed on to the other replicas, see inBulkSetEnqueue and inBulkSet.
+func (bsm *bulkSetMsg) backfill() bool {
+	return bsm.header[16]&_BULK_SET_MSG_FLAG_BACKFILL != 0
+}
+
+func (bsm *bulkSetMsg) setBackfill() {
+	bsm.header[16] |= _BULK_SET_MSG_FLAG_BACKFILL
+}
+
+// compressed indicates each value in the message is framed as compress
+// frames it, as remote replication and, with Config.ReplicationCompression,
+// replication between nodes send them; see bulkSetInflate.
+func (bsm *bulkSetMsg) compressed() bool {
+	return bsm.header[16]&_BULK_SET_MSG_FLAG_COMPRESSED != 0
+}
+
+func (bsm *bulkSetMsg) setCompressed() {
+	bsm.header[16] |= _BULK_SET_MSG_FLAG_COMPRESSED
+}
+
 func (bsm *bulkSetMsg) add(keyA uint64, keyB uint64, timestampbits uint64, value []byte) bool {
 	// CONSIDER: I'd rather not have "useless" checks every place wasting
 	// cycles when the caller should have already validated the input; but here
@@ -267,3 +613,42 @@ func (bsm *bulkSetMsg) add(keyA uint64, keyB uint64, timestampbits uint64, value
 	copy(bsm.body[o+_BULK_SET_MSG_ENTRY_HEADER_LENGTH:], value)
 	return true
 }
+
+// dedupeKeyList sorts the keyA, keyB pairs in list and removes any duplicate
+// pairs, returning the possibly shortened list. Outgoing bulk-set messages are
+// built by reading the current value for each key, so a duplicate key would
+// just ship the same entry twice.
+func dedupeKeyList(list []uint64) []uint64 {
+	if len(list) < 4 {
+		return list
+	}
+	sort.Sort(keyPairList(list))
+	j := 2
+	for i := 2; i+1 < len(list); i += 2 {
+		if list[i] != list[j-2] || list[i+1] != list[j-1] {
+			list[j] = list[i]
+			list[j+1] = list[i+1]
+			j += 2
+		}
+	}
+	return list[:j]
+}
+
+// keyPairList implements sort.Interface for a list of keyA, keyB pairs.
+type keyPairList []uint64
+
+func (l keyPairList) Len() int {
+	return len(l) / 2
+}
+
+func (l keyPairList) Less(i int, j int) bool {
+	if l[i*2] != l[j*2] {
+		return l[i*2] < l[j*2]
+	}
+	return l[i*2+1] < l[j*2+1]
+}
+
+func (l keyPairList) Swap(i int, j int) {
+	l[i*2], l[j*2] = l[j*2], l[i*2]
+	l[i*2+1], l[j*2+1] = l[j*2+1], l[i*2+1]
+}
@@ -61,7 +61,7 @@ func (w *testErrorWriter) Write(p []byte) (int, error) {
 }
 
 func TestBulkSetReadObviouslyTooShort(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
 	for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
 		vs.bulkSetState.inMsgChan <- nil
 	}
@@ -93,7 +93,7 @@ func TestBulkSetReadObviouslyTooShort(t *testing.T) {
 }
 
 func TestBulkSetRead(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
 	for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
 		vs.bulkSetState.inMsgChan <- nil
 	}
@@ -137,7 +137,7 @@ func TestBulkSetRead(t *testing.T) {
 }
 
 func TestBulkSetReadLowSendCap(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}, BulkSetMsgCap: _BULK_SET_MSG_HEADER_LENGTH + 1})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}, BulkSetMsgCap: _BULK_SET_MSG_HEADER_LENGTH + 1})
 	for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
 		vs.bulkSetState.inMsgChan <- nil
 	}
@@ -166,7 +166,7 @@ func TestBulkSetMsgWithoutAck(t *testing.T) {
 	r := b.Ring()
 	r.SetLocalNode(n.ID())
 	m := &msgRingPlaceholder{ring: r}
-	vs := New(&Config{
+	vs := newTestStore(t, &Config{
 		MsgRing:          m,
 		InBulkSetWorkers: 1,
 		InBulkSetMsgs:    1,
@@ -209,7 +209,7 @@ func TestBulkSetMsgWithAck(t *testing.T) {
 	r := b.Ring()
 	r.SetLocalNode(n.ID())
 	m := &msgRingPlaceholder{ring: r}
-	vs := New(&Config{
+	vs := newTestStore(t, &Config{
 		MsgRing:          m,
 		InBulkSetWorkers: 1,
 		InBulkSetMsgs:    1,
@@ -252,7 +252,7 @@ func TestBulkSetMsgWithAck(t *testing.T) {
 
 func TestBulkSetMsgWithoutRing(t *testing.T) {
 	m := &msgRingPlaceholder{}
-	vs := New(&Config{
+	vs := newTestStore(t, &Config{
 		MsgRing:          m,
 		InBulkSetWorkers: 1,
 		InBulkSetMsgs:    1,
@@ -287,13 +287,123 @@ func TestBulkSetMsgWithoutRing(t *testing.T) {
 	}
 }
 
+func TestBulkSetMsgAckPolicies(t *testing.T) {
+	b := ring.NewBuilder(64)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingHolder{ring: r}
+	vs := newTestStore(t, &Config{
+		MsgRing:          m,
+		InBulkSetWorkers: 1,
+		InBulkSetMsgs:    1,
+	})
+	vs.EnableAll()
+	defer vs.DisableAll()
+	bsm := <-vs.bulkSetState.inFreeMsgChan
+	binary.BigEndian.PutUint64(bsm.header, 123)
+	bsm.setAckPolicy(BULK_SET_ACK_NONE)
+	bsm.body = bsm.body[:0]
+	if !bsm.add(1, 2, 0x300, []byte("testing")) {
+		t.Fatal("")
+	}
+	vs.bulkSetState.inMsgChan <- bsm
+	bsm = <-vs.bulkSetState.inFreeMsgChan
+	binary.BigEndian.PutUint64(bsm.header, 123)
+	bsm.setAckPolicy(BULK_SET_ACK_ALL)
+	bsm.body = bsm.body[:0]
+	if !bsm.add(3, 4, 0x300, []byte("older")) {
+		t.Fatal("")
+	}
+	if !bsm.add(3, 4, 0x400, []byte("newer")) {
+		t.Fatal("")
+	}
+	vs.bulkSetState.inMsgChan <- bsm
+	<-vs.bulkSetState.inFreeMsgChan
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.held) != 1 {
+		t.Fatal(len(m.held))
+	}
+	bsam := m.held[0].(*bulkSetAckMsg)
+	if bsam.MsgType() != _BULK_SET_ACK_STATUS_MSG_TYPE {
+		t.Fatal(bsam.MsgType())
+	}
+	if len(bsam.body) != 2*_BULK_SET_ACK_STATUS_MSG_ENTRY_LENGTH {
+		t.Fatal(len(bsam.body))
+	}
+	if ts := binary.BigEndian.Uint64(bsam.body[16:]); ts != 0x300 || bsam.body[24] != _BULK_SET_ACK_STATUS_OVERRIDDEN {
+		t.Fatal(ts, bsam.body[24])
+	}
+	if ts := binary.BigEndian.Uint64(bsam.body[25+16:]); ts != 0x400 || bsam.body[25+24] != _BULK_SET_ACK_STATUS_APPLIED {
+		t.Fatal(ts, bsam.body[25+24])
+	}
+}
+
+func TestBulkSetMsgBackfill(t *testing.T) {
+	b := ring.NewBuilder(64)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingHolder{ring: r}
+	vs := newTestStore(t, &Config{
+		MsgRing:          m,
+		InBulkSetWorkers: 1,
+		InBulkSetMsgs:    1,
+	})
+	vs.EnableAll()
+	defer vs.DisableAll()
+	if _, err := vs.write(3, 4, 0x500, []byte("local")); err != nil {
+		t.Fatal(err)
+	}
+	bsm := <-vs.bulkSetState.inFreeMsgChan
+	binary.BigEndian.PutUint64(bsm.header, 123)
+	bsm.header[16] = 0
+	bsm.setAckPolicy(BULK_SET_ACK_NONE)
+	bsm.setBackfill()
+	if bsm.ackPolicy() != BULK_SET_ACK_NONE || !bsm.backfill() {
+		t.Fatal(bsm.header[16])
+	}
+	bsm.body = bsm.body[:0]
+	if !bsm.add(1, 2, 0x300, []byte("testing")) {
+		t.Fatal("")
+	}
+	if !bsm.add(3, 4, 0x400, []byte("older")) {
+		t.Fatal("")
+	}
+	vs.bulkSetState.inMsgChan <- bsm
+	<-vs.bulkSetState.inFreeMsgChan
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	// Just the entry that was applied should be passed on.
+	if len(m.held) != 1 {
+		t.Fatal(len(m.held))
+	}
+	obsm := m.held[0].(*bulkSetMsg)
+	if !obsm.backfill() || obsm.ackPolicy() != BULK_SET_ACK_NONE {
+		t.Fatal(obsm.header[16])
+	}
+	if len(obsm.body) != _BULK_SET_MSG_ENTRY_HEADER_LENGTH+7 || binary.BigEndian.Uint64(obsm.body) != 1 {
+		t.Fatal(obsm.body)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.OutBulkSetBackfills != 1 {
+		t.Fatal(stats.OutBulkSetBackfills)
+	}
+}
+
 func TestBulkSetMsgOut(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
 	bsm := vs.newOutBulkSetMsg()
 	if bsm.MsgType() != _BULK_SET_MSG_TYPE {
 		t.Fatal(bsm.MsgType())
 	}
-	if bsm.MsgLength() != _BULK_SET_MSG_HEADER_LENGTH {
+	if bsm.MsgLength() != _BULK_SET_MSG_HEADER_LENGTH+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(bsm.MsgLength())
 	}
 	buf := bytes.NewBuffer(nil)
@@ -301,10 +411,12 @@ func TestBulkSetMsgOut(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if n != _BULK_SET_MSG_HEADER_LENGTH {
+	if n != _BULK_SET_MSG_HEADER_LENGTH+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(n)
 	}
-	if !bytes.Equal(buf.Bytes(), []byte{0, 0, 0, 0, 0, 0, 0, 0}) {
+	expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x20}
+	c := msgChecksumTrailer(expected, nil)
+	if !bytes.Equal(buf.Bytes(), append(expected, c[:]...)) {
 		t.Fatal(buf.Bytes())
 	}
 	bsm.Free()
@@ -315,7 +427,7 @@ func TestBulkSetMsgOut(t *testing.T) {
 	if bsm.MsgType() != _BULK_SET_MSG_TYPE {
 		t.Fatal(bsm.MsgType())
 	}
-	if bsm.MsgLength() != _BULK_SET_MSG_HEADER_LENGTH+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+0+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+7 {
+	if bsm.MsgLength() != _BULK_SET_MSG_HEADER_LENGTH+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+0+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+7+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(bsm.MsgLength())
 	}
 	buf = bytes.NewBuffer(nil)
@@ -323,11 +435,13 @@ func TestBulkSetMsgOut(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if n != _BULK_SET_MSG_HEADER_LENGTH+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+0+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+7 {
+	if n != _BULK_SET_MSG_HEADER_LENGTH+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+0+_BULK_SET_MSG_ENTRY_HEADER_LENGTH+7+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(n)
 	}
-	if !bytes.Equal(buf.Bytes(), []byte{
-		0, 0, 0, 0, 0, 0, 48, 57, // header
+	expected = []byte{
+		0, 0, 0, 0, 0, 0, 48, 57, // header nodeID
+		0, 0, 0, 0, 0, 0, 0, 2, // header msgID
+		0x20,                   // header flags: checksum, ackPolicy
 		0, 0, 0, 0, 0, 0, 0, 1, // keyA
 		0, 0, 0, 0, 0, 0, 0, 2, // keyB
 		0, 0, 0, 0, 0, 0, 3, 0, // timestamp
@@ -337,7 +451,9 @@ func TestBulkSetMsgOut(t *testing.T) {
 		0, 0, 0, 0, 0, 0, 6, 0, // timestamp
 		0, 0, 0, 7, // length
 		116, 101, 115, 116, 105, 110, 103, // "testing"
-	}) {
+	}
+	c = msgChecksumTrailer(expected[:_BULK_SET_MSG_HEADER_LENGTH], expected[_BULK_SET_MSG_HEADER_LENGTH:])
+	if !bytes.Equal(buf.Bytes(), append(expected, c[:]...)) {
 		t.Fatal(buf.Bytes())
 	}
 	bsm.Free()
@@ -351,7 +467,7 @@ func TestBulkSetMsgOutDefaultsToFromLocalNode(t *testing.T) {
 	}
 	r := b.Ring()
 	r.SetLocalNode(n.ID())
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{ring: r}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{ring: r}})
 	bsm := vs.newOutBulkSetMsg()
 	if binary.BigEndian.Uint64(bsm.header) != n.ID() {
 		t.Fatal(bsm)
@@ -359,7 +475,7 @@ func TestBulkSetMsgOutDefaultsToFromLocalNode(t *testing.T) {
 }
 
 func TestBulkSetMsgOutWriteError(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
 	bsm := vs.newOutBulkSetMsg()
 	_, err := bsm.WriteContent(&testErrorWriter{})
 	if err == nil {
@@ -369,7 +485,7 @@ func TestBulkSetMsgOutWriteError(t *testing.T) {
 }
 
 func TestBulkSetMsgOutHitCap(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}, BulkSetMsgCap: _BULK_SET_MSG_HEADER_LENGTH + _BULK_SET_MSG_ENTRY_HEADER_LENGTH + 3})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}, BulkSetMsgCap: _BULK_SET_MSG_HEADER_LENGTH + _BULK_SET_MSG_ENTRY_HEADER_LENGTH + 3})
 	bsm := vs.newOutBulkSetMsg()
 	if !bsm.add(1, 2, 0x300, []byte("1")) {
 		t.Fatal("")
@@ -378,3 +494,168 @@ func TestBulkSetMsgOutHitCap(t *testing.T) {
 		t.Fatal("")
 	}
 }
+
+func TestBulkSetMsgCoalescesDuplicates(t *testing.T) {
+	b := ring.NewBuilder(64)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingPlaceholder{ring: r}
+	vs := newTestStore(t, &Config{
+		MsgRing:          m,
+		InBulkSetWorkers: 1,
+		InBulkSetMsgs:    1,
+	})
+	vs.EnableAll()
+	defer vs.DisableAll()
+	bsm := <-vs.bulkSetState.inFreeMsgChan
+	bsm.body = bsm.body[:0]
+	if !bsm.add(1, 2, 0x300, []byte("older")) {
+		t.Fatal("")
+	}
+	if !bsm.add(1, 2, 0x500, []byte("newest")) {
+		t.Fatal("")
+	}
+	if !bsm.add(1, 2, 0x400, []byte("middle")) {
+		t.Fatal("")
+	}
+	if !bsm.add(1, 2, 0x500, []byte("newest")) {
+		t.Fatal("")
+	}
+	vs.bulkSetState.inMsgChan <- bsm
+	<-vs.bulkSetState.inFreeMsgChan
+	ts, v, err := vs.Read(1, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != 5 {
+		t.Fatal(ts)
+	}
+	if string(v) != "newest" {
+		t.Fatal(string(v))
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.InBulkSetWrites != 1 {
+		t.Fatal(stats.InBulkSetWrites)
+	}
+	if stats.InBulkSetCoalesced != 3 {
+		t.Fatal(stats.InBulkSetCoalesced)
+	}
+}
+
+func TestDedupeKeyList(t *testing.T) {
+	list := dedupeKeyList([]uint64{3, 4, 1, 2, 3, 4, 1, 3, 1, 2})
+	if len(list) != 6 {
+		t.Fatal(list)
+	}
+	for i, v := range []uint64{1, 2, 1, 3, 3, 4} {
+		if list[i] != v {
+			t.Fatal(list)
+		}
+	}
+	list = dedupeKeyList([]uint64{5, 6})
+	if len(list) != 2 {
+		t.Fatal(list)
+	}
+}
+
+func TestBulkSetMsgReplicationHook(t *testing.T) {
+	type call struct {
+		keyA    uint64
+		value   string
+		applied bool
+	}
+	var calls []call
+	vs := newTestStore(t, &Config{
+		MsgRing:          &msgRingPlaceholder{},
+		InBulkSetWorkers: 1,
+		InBulkSetMsgs:    1,
+		ReplicationHook: func(keyA uint64, keyB uint64, timestampmicro int64, deletion bool, value []byte, applied bool) {
+			calls = append(calls, call{keyA, string(value), applied})
+		},
+	})
+	vs.EnableAll()
+	defer vs.DisableAll()
+	if _, err := vs.write(1, 2, 0x500, []byte("local")); err != nil {
+		t.Fatal(err)
+	}
+	bsm := <-vs.bulkSetState.inFreeMsgChan
+	bsm.body = bsm.body[:0]
+	if !bsm.add(1, 2, 0x300, []byte("older")) {
+		t.Fatal("")
+	}
+	if !bsm.add(3, 4, 0x300, []byte("newer")) {
+		t.Fatal("")
+	}
+	if !bsm.add(3, 4, 0x200, []byte("oldest")) {
+		t.Fatal("")
+	}
+	vs.bulkSetState.inMsgChan <- bsm
+	<-vs.bulkSetState.inFreeMsgChan
+	if len(calls) != 3 {
+		t.Fatal(calls)
+	}
+	if calls[0] != (call{3, "oldest", false}) || calls[1] != (call{1, "older", false}) || calls[2] != (call{3, "newer", true}) {
+		t.Fatal(calls)
+	}
+}
+
+func TestBulkSetMsgConflictResolver(t *testing.T) {
+	var conflicts []string
+	vs := newTestStore(t, &Config{
+		MsgRing:          &msgRingPlaceholder{},
+		InBulkSetWorkers: 1,
+		InBulkSetMsgs:    1,
+		ConflictResolver: func(keyA uint64, keyB uint64, timestampmicro int64, local []byte, remote []byte) (int, []byte) {
+			conflicts = append(conflicts, string(local)+"/"+string(remote))
+			switch keyA {
+			case 3:
+				return CONFLICT_KEEP_REMOTE, nil
+			case 5:
+				return CONFLICT_MERGE, append(append([]byte{}, local...), remote...)
+			}
+			return CONFLICT_KEEP_LOCAL, nil
+		},
+	})
+	vs.EnableAll()
+	defer vs.DisableAll()
+	for _, keyA := range []uint64{1, 3, 5, 7} {
+		if _, err := vs.write(keyA, 2, 0x500, []byte("local")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bsm := <-vs.bulkSetState.inFreeMsgChan
+	bsm.body = bsm.body[:0]
+	for _, keyA := range []uint64{1, 3, 5} {
+		if !bsm.add(keyA, 2, 0x500, []byte("remote")) {
+			t.Fatal("")
+		}
+	}
+	// The same value isn't a conflict.
+	if !bsm.add(7, 2, 0x500, []byte("local")) {
+		t.Fatal("")
+	}
+	vs.bulkSetState.inMsgChan <- bsm
+	<-vs.bulkSetState.inFreeMsgChan
+	if len(conflicts) != 3 || conflicts[0] != "local/remote" {
+		t.Fatal(conflicts)
+	}
+	for keyA, expected := range map[uint64]string{1: "local", 3: "remote", 5: "localremote", 7: "local"} {
+		timestampbits, value, err := vs.read(keyA, 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != expected {
+			t.Fatal(keyA, string(value))
+		}
+		if expected != "local" && timestampbits != 0x600 {
+			t.Fatal(keyA, timestampbits)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSetConflicts != 3 {
+		t.Fatal(stats.InBulkSetConflicts)
+	}
+}
@@ -3,48 +3,109 @@ package valuestore
 import (
 	"encoding/binary"
 	"io"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
-// bsam: entries:n
+// bsam: senderNodeID:8 msgIDCount:4 msgIDs:n entries:n
+// bsam msgID: msgID:8
 // bsam entry: keyA:8, keyB:8, timestampbits:8
-const _BULK_SET_ACK_MSG_TYPE = 0x39589f4746844e3b
+// bsam checksum: checksum:4 after the entries; see bulkSetAckMsgChecksummed
+//
+// The msgIDs are those of the bulk-set messages acknowledged, which the
+// sender of those uses to free their peer flow window slots; see
+// peerFlowAcked. There is no version negotiation between nodes: when this
+// layout, or the bulk-set layout with its msgID, changes, so does the message
+// type, and nodes on either side of the change simply drop each other's
+// bulk-set and bulk-set-ack messages. As bulk-set messages carry the values
+// of push replication, handoffs, and pull replication responses alike, no
+// values move between upgraded and older nodes during a rolling upgrade; the
+// replicas catch up once all nodes are upgraded.
+const _BULK_SET_ACK_MSG_TYPE = 0x7a40c2d95e1b836f
+const _BULK_SET_ACK_MSG_HEADER_LENGTH = 12
+const _BULK_SET_ACK_MSG_ID_LENGTH = 8
 const _BULK_SET_ACK_MSG_ENTRY_LENGTH = 24
 
+// Status bulk-set-ack messages are sent in response to bulk-set messages with
+// the BULK_SET_ACK_ALL policy; they have the same layout as bulk-set-ack
+// messages but each entry also has a status.
+// bsasm entry: keyA:8, keyB:8, timestampbits:8, status:1
+const _BULK_SET_ACK_STATUS_MSG_TYPE = 0xc61f3a08d27e945b
+const _BULK_SET_ACK_STATUS_MSG_ENTRY_LENGTH = 25
+
+const (
+	// _BULK_SET_ACK_STATUS_APPLIED indicates the entry was stored.
+	_BULK_SET_ACK_STATUS_APPLIED = 0
+	// _BULK_SET_ACK_STATUS_OVERRIDDEN indicates the same or a newer entry
+	// was already stored.
+	_BULK_SET_ACK_STATUS_OVERRIDDEN = 1
+	// _BULK_SET_ACK_STATUS_NOT_RESPONSIBLE indicates the entry was stored but
+	// the receiver is not responsible for it.
+	_BULK_SET_ACK_STATUS_NOT_RESPONSIBLE = 2
+	// _BULK_SET_ACK_STATUS_ERROR indicates the entry could not be stored.
+	_BULK_SET_ACK_STATUS_ERROR = 3
+)
+
 type bulkSetAckState struct {
 	inMsgChan             chan *bulkSetAckMsg
 	inFreeMsgChan         chan *bulkSetAckMsg
 	outFreeMsgChan        chan *bulkSetAckMsg
 	inBulkSetAckDoneChans []chan struct{}
+	outDelay              time.Duration
+	outPendingLock        sync.Mutex
+	outPending            map[uint64]*bulkSetAckMsg
+	msgCap                int
+	inPool                *msgPool
+	outPool               *msgPool
 }
 
 type bulkSetAckMsg struct {
-	vs   *DefaultValueStore
-	body []byte
+	vs *DefaultValueStore
+	// header is the senderNodeID and msgIDCount followed by the msgIDs of
+	// the bulk-set messages being acknowledged.
+	header   []byte
+	body     []byte
+	peerFlow []uint64
+	status   bool
 }
 
 func (vs *DefaultValueStore) bulkSetAckConfig(cfg *Config) {
 	if vs.msgRing != nil {
 		vs.msgRing.SetMsgHandler(_BULK_SET_ACK_MSG_TYPE, vs.newInBulkSetAckMsg)
-		vs.bulkSetAckState.inMsgChan = make(chan *bulkSetAckMsg, cfg.InBulkSetAckMsgs)
-		vs.bulkSetAckState.inFreeMsgChan = make(chan *bulkSetAckMsg, cfg.InBulkSetAckMsgs)
-		for i := 0; i < cap(vs.bulkSetAckState.inFreeMsgChan); i++ {
-			vs.bulkSetAckState.inFreeMsgChan <- &bulkSetAckMsg{
-				vs:   vs,
-				body: make([]byte, cfg.BulkSetAckMsgCap),
+		vs.msgRing.SetMsgHandler(_BULK_SET_ACK_STATUS_MSG_TYPE, vs.newInBulkSetAckStatusMsg)
+		vs.bulkSetAckState.msgCap = cfg.BulkSetAckMsgCap
+		vs.bulkSetAckState.inPool = vs.newMsgPool("inBulkSetAckMsgPool", cfg, cfg.InBulkSetAckMsgs, func() bool {
+			select {
+			case <-vs.bulkSetAckState.inFreeMsgChan:
+				return true
+			default:
+				return false
 			}
+		})
+		vs.bulkSetAckState.inMsgChan = make(chan *bulkSetAckMsg, vs.bulkSetAckState.inPool.max)
+		vs.bulkSetAckState.inFreeMsgChan = make(chan *bulkSetAckMsg, vs.bulkSetAckState.inPool.max)
+		for i := int32(0); i < vs.bulkSetAckState.inPool.min; i++ {
+			vs.bulkSetAckState.inFreeMsgChan <- vs.allocBulkSetAckMsg()
 		}
 		vs.bulkSetAckState.inBulkSetAckDoneChans = make([]chan struct{}, cfg.InBulkSetAckWorkers)
 		for i := 0; i < len(vs.bulkSetAckState.inBulkSetAckDoneChans); i++ {
 			vs.bulkSetAckState.inBulkSetAckDoneChans[i] = make(chan struct{}, 1)
 		}
-		vs.bulkSetAckState.outFreeMsgChan = make(chan *bulkSetAckMsg, cfg.OutBulkSetAckMsgs)
-		for i := 0; i < cap(vs.bulkSetAckState.outFreeMsgChan); i++ {
-			vs.bulkSetAckState.outFreeMsgChan <- &bulkSetAckMsg{
-				vs:   vs,
-				body: make([]byte, cfg.BulkSetAckMsgCap),
+		vs.bulkSetAckState.outPool = vs.newMsgPool("outBulkSetAckMsgPool", cfg, cfg.OutBulkSetAckMsgs, func() bool {
+			select {
+			case <-vs.bulkSetAckState.outFreeMsgChan:
+				return true
+			default:
+				return false
 			}
+		})
+		vs.bulkSetAckState.outFreeMsgChan = make(chan *bulkSetAckMsg, vs.bulkSetAckState.outPool.max)
+		for i := int32(0); i < vs.bulkSetAckState.outPool.min; i++ {
+			vs.bulkSetAckState.outFreeMsgChan <- vs.allocBulkSetAckMsg()
 		}
+		vs.bulkSetAckState.outDelay = time.Duration(cfg.OutBulkSetAckDelay) * time.Millisecond
+		vs.bulkSetAckState.outPending = make(map[uint64]*bulkSetAckMsg)
 	}
 }
 
@@ -57,12 +118,30 @@ func (vs *DefaultValueStore) bulkSetAckLaunch() {
 // newInBulkSetAckMsg reads bulk-set-ack messages from the MsgRing and puts
 // them on the inMsgChan for the inBulkSetAck workers to work on.
 func (vs *DefaultValueStore) newInBulkSetAckMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInBulkSetAckMsg(r, l, false)
+}
+
+// newInBulkSetAckStatusMsg is the MsgRing handler for status bulk-set-ack
+// messages; these are queued with the regular bulk-set-ack messages.
+func (vs *DefaultValueStore) newInBulkSetAckStatusMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInBulkSetAckMsg(r, l, true)
+}
+
+func (vs *DefaultValueStore) readInBulkSetAckMsg(r io.Reader, l uint64, status bool) (uint64, error) {
 	var bsam *bulkSetAckMsg
 	select {
 	case bsam = <-vs.bulkSetAckState.inFreeMsgChan:
+		vs.bulkSetAckState.inPool.got(len(vs.bulkSetAckState.inFreeMsgChan))
+		bsam.status = status
 	default:
-		// If there isn't a free bulkSetAckMsg, just read and discard the
-		// incoming bulk-set-ack message.
+		if vs.bulkSetAckState.inPool.grow() {
+			bsam = vs.allocBulkSetAckMsg()
+			bsam.status = status
+			break
+		}
+		// If there isn't a free bulkSetAckMsg, and the pool is at its
+		// maximum size, just read and discard the incoming bulk-set-ack
+		// message.
 		left := l
 		var sn int
 		var err error
@@ -81,9 +160,76 @@ func (vs *DefaultValueStore) newInBulkSetAckMsg(r io.Reader, l uint64) (uint64,
 		atomic.AddInt32(&vs.inBulkSetAckDrops, 1)
 		return l, nil
 	}
+	// If the message is obviously too short, just throw it away.
+	if l < _BULK_SET_ACK_MSG_HEADER_LENGTH {
+		vs.bulkSetAckState.inFreeMsgChan <- bsam
+		left := l
+		var sn int
+		var err error
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err = r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				atomic.AddInt32(&vs.inBulkSetAckInvalids, 1)
+				return l - left, err
+			}
+		}
+		atomic.AddInt32(&vs.inBulkSetAckInvalids, 1)
+		return l, nil
+	}
 	var n int
 	var sn int
 	var err error
+	bsam.header = bsam.header[:_BULK_SET_ACK_MSG_HEADER_LENGTH]
+	for n != len(bsam.header) {
+		sn, err = r.Read(bsam.header[n:])
+		n += sn
+		if err != nil {
+			vs.bulkSetAckState.inFreeMsgChan <- bsam
+			atomic.AddInt32(&vs.inBulkSetAckInvalids, 1)
+			return uint64(n), err
+		}
+	}
+	h := _BULK_SET_ACK_MSG_HEADER_LENGTH + uint64(binary.BigEndian.Uint32(bsam.header[8:]))*_BULK_SET_ACK_MSG_ID_LENGTH
+	if h > l {
+		// Claims more msgIDs than the message holds; just toss the rest.
+		vs.bulkSetAckState.inFreeMsgChan <- bsam
+		left := l - uint64(n)
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err = r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				atomic.AddInt32(&vs.inBulkSetAckInvalids, 1)
+				return l - left, err
+			}
+		}
+		atomic.AddInt32(&vs.inBulkSetAckInvalids, 1)
+		return l, nil
+	}
+	if h > uint64(cap(bsam.header)) {
+		header := make([]byte, h)
+		copy(header, bsam.header)
+		bsam.header = header
+	}
+	bsam.header = bsam.header[:h]
+	for n != len(bsam.header) {
+		sn, err = r.Read(bsam.header[n:])
+		n += sn
+		if err != nil {
+			vs.bulkSetAckState.inFreeMsgChan <- bsam
+			atomic.AddInt32(&vs.inBulkSetAckInvalids, 1)
+			return uint64(n), err
+		}
+	}
+	l -= h
 	// TODO: Need to read up the actual msg cap and toss rest.
 	if l > uint64(cap(bsam.body)) {
 		bsam.body = make([]byte, l)
@@ -96,78 +242,225 @@ func (vs *DefaultValueStore) newInBulkSetAckMsg(r io.Reader, l uint64) (uint64,
 		if err != nil {
 			vs.bulkSetAckState.inFreeMsgChan <- bsam
 			atomic.AddInt32(&vs.inBulkSetAckInvalids, 1)
-			return uint64(n), err
+			return h + uint64(n), err
+		}
+	}
+	if bulkSetAckMsgChecksummed(len(bsam.body), bsam.entryLength()) {
+		var ok bool
+		if bsam.body, ok = msgChecksumVerify(bsam.header, bsam.body); !ok {
+			vs.bulkSetAckState.inFreeMsgChan <- bsam
+			atomic.AddInt32(&vs.inBulkSetAckCorrupts, 1)
+			return h + l, nil
 		}
 	}
 	vs.bulkSetAckState.inMsgChan <- bsam
 	atomic.AddInt32(&vs.inBulkSetAcks, 1)
-	return l, nil
+	return h + l, nil
 }
 
 // inBulkSetAck actually processes incoming bulk-set-ack messages; there may be
 // more than one of these workers.
 func (vs *DefaultValueStore) inBulkSetAck(doneChan chan struct{}) {
+	// batch is reused for each message to apply all its local removals with
+	// one writeBatch call.
+	var batch []valueWriteBatchEntry
 	for {
-		bsam := <-vs.bulkSetAckState.inMsgChan
+		var bsam *bulkSetAckMsg
+		select {
+		case bsam = <-vs.bulkSetAckState.inMsgChan:
+		case <-vs.closeState.backgroundChan:
+		}
 		if bsam == nil {
 			break
 		}
+		atomic.AddInt32(&vs.inBulkSetAckedMsgs, int32(bsam.msgIDCount()))
+		for i := 0; i < bsam.msgIDCount(); i++ {
+			vs.peerFlowAcked(bsam.msgID(i), bsam.nodeID())
+		}
 		ring := vs.msgRing.Ring()
 		var rightwardPartitionShift uint64
 		if ring != nil {
 			rightwardPartitionShift = 64 - uint64(ring.PartitionBitCount())
 		}
 		b := bsam.body
+		entryLength := bsam.entryLength()
+		tombstoneNodeID, tombstoneAcks := vs.tombstoneAckNode(bsam)
 		// div mul just ensures any trailing bytes are dropped
-		l := len(b) / _BULK_SET_ACK_MSG_ENTRY_LENGTH * _BULK_SET_ACK_MSG_ENTRY_LENGTH
-		for o := 0; o < l; o += _BULK_SET_ACK_MSG_ENTRY_LENGTH {
+		l := len(b) / entryLength * entryLength
+		for o := 0; o < l; o += entryLength {
+			if bsam.status {
+				// Only entries the other end actually has can be removed.
+				switch b[o+24] {
+				case _BULK_SET_ACK_STATUS_APPLIED, _BULK_SET_ACK_STATUS_OVERRIDDEN:
+				default:
+					atomic.AddInt32(&vs.inBulkSetAckFailures, 1)
+					continue
+				}
+			}
 			keyA := binary.BigEndian.Uint64(b[o:])
+			if tombstoneAcks {
+				vs.tombstoneAckAdd(tombstoneNodeID, keyA, binary.BigEndian.Uint64(b[o+8:]), binary.BigEndian.Uint64(b[o+16:]))
+			}
 			if ring != nil && !ring.Responsible(uint32(keyA>>rightwardPartitionShift)) {
 				atomic.AddInt32(&vs.inBulkSetAckWrites, 1)
-				timestampbits := binary.BigEndian.Uint64(b[o+16:]) | _TSB_LOCAL_REMOVAL
-				rtimestampbits, err := vs.write(keyA, binary.BigEndian.Uint64(b[o+8:]), timestampbits, nil)
-				if err != nil {
-					atomic.AddInt32(&vs.inBulkSetAckWriteErrors, 1)
-				} else if rtimestampbits != timestampbits {
-					atomic.AddInt32(&vs.inBulkSetAckWritesOverridden, 1)
-				}
+				batch = append(batch, valueWriteBatchEntry{
+					keyA:          keyA,
+					keyB:          binary.BigEndian.Uint64(b[o+8:]),
+					timestampbits: binary.BigEndian.Uint64(b[o+16:]) | _TSB_LOCAL_REMOVAL,
+				})
+			}
+		}
+		vs.writeBatch(batch, false)
+		for i := range batch {
+			e := &batch[i]
+			if e.err != nil {
+				atomic.AddInt32(&vs.inBulkSetAckWriteErrors, 1)
+			} else if e.ptimestampbits != e.timestampbits {
+				atomic.AddInt32(&vs.inBulkSetAckWritesOverridden, 1)
 			}
 		}
+		vs.pushBacklogRemove(batch)
+		batch = batch[:0]
 		vs.bulkSetAckState.inFreeMsgChan <- bsam
 	}
-	doneChan <- struct{}{}
+	// Closed rather than sent on, so Close still sees the worker is gone
+	// if something else was told first.
+	close(doneChan)
 }
 
 // newOutBulkSetAckMsg gives an initialized bulkSetAckMsg for filling out and
 // eventually sending using the MsgRing. The MsgRing (or someone else if the
 // message doesn't end up with the MsgRing) will call bulkSetAckMsg.Free()
 // eventually and the bulkSetAckMsg will be requeued for reuse later. There is
-// a fixed number of outgoing bulkSetAckMsg instances that can exist at any
+// a maximum number of outgoing bulkSetAckMsg instances that can exist at any
 // given time, capping memory usage. Once the limit is reached, this method
 // will block until a bulkSetAckMsg is available to return.
 func (vs *DefaultValueStore) newOutBulkSetAckMsg() *bulkSetAckMsg {
-	bsam := <-vs.bulkSetAckState.outFreeMsgChan
+	var bsam *bulkSetAckMsg
+	select {
+	case bsam = <-vs.bulkSetAckState.outFreeMsgChan:
+		vs.bulkSetAckState.outPool.got(len(vs.bulkSetAckState.outFreeMsgChan))
+	default:
+		if vs.bulkSetAckState.outPool.grow() {
+			bsam = vs.allocBulkSetAckMsg()
+		} else {
+			bsam = <-vs.bulkSetAckState.outFreeMsgChan
+		}
+	}
+	bsam.header = bsam.header[:_BULK_SET_ACK_MSG_HEADER_LENGTH]
+	binary.BigEndian.PutUint64(bsam.header, 0)
+	if vs.msgRing != nil {
+		if r := vs.msgRing.Ring(); r != nil {
+			if n := r.LocalNode(); n != nil {
+				binary.BigEndian.PutUint64(bsam.header, n.ID())
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(bsam.header[8:], 0)
 	bsam.body = bsam.body[:0]
+	bsam.status = false
 	return bsam
 }
 
+// outBulkSetAck sends the bulk-set-ack message to nodeID. If
+// OutBulkSetAckDelay is configured, the message is instead held for up to that
+// long so that acks for additional bulk-set messages from the same node can
+// be sent along with it.
+func (vs *DefaultValueStore) outBulkSetAck(bsam *bulkSetAckMsg, nodeID uint64) {
+	if vs.bulkSetAckState.outDelay <= 0 {
+		if vs.msgToNode(bsam, nodeID, vs.bulkSetState.inResponseMsgTimeout) {
+			atomic.AddInt32(&vs.outBulkSetAcks, 1)
+		}
+		return
+	}
+	vs.bulkSetAckState.outPendingLock.Lock()
+	pending := vs.bulkSetAckState.outPending[nodeID]
+	if pending != nil && pending.merge(bsam) {
+		vs.bulkSetAckState.outPendingLock.Unlock()
+		bsam.Free()
+		return
+	}
+	// Either nothing was pending or the pending message had no more room;
+	// in the latter case it is sent now and this message takes its place.
+	full := pending
+	vs.bulkSetAckState.outPending[nodeID] = bsam
+	vs.bulkSetAckState.outPendingLock.Unlock()
+	time.AfterFunc(vs.bulkSetAckState.outDelay, func() {
+		vs.bulkSetAckState.outPendingLock.Lock()
+		if vs.bulkSetAckState.outPending[nodeID] != bsam {
+			vs.bulkSetAckState.outPendingLock.Unlock()
+			return
+		}
+		delete(vs.bulkSetAckState.outPending, nodeID)
+		vs.bulkSetAckState.outPendingLock.Unlock()
+		if vs.msgToNode(bsam, nodeID, vs.bulkSetState.inResponseMsgTimeout) {
+			atomic.AddInt32(&vs.outBulkSetAcks, 1)
+		}
+	})
+	if full != nil {
+		if vs.msgToNode(full, nodeID, vs.bulkSetState.inResponseMsgTimeout) {
+			atomic.AddInt32(&vs.outBulkSetAcks, 1)
+		}
+	}
+}
+
+func (vs *DefaultValueStore) allocBulkSetAckMsg() *bulkSetAckMsg {
+	return &bulkSetAckMsg{
+		vs:     vs,
+		header: make([]byte, _BULK_SET_ACK_MSG_HEADER_LENGTH),
+		body:   make([]byte, vs.bulkSetAckState.msgCap),
+	}
+}
+
 func (bsam *bulkSetAckMsg) MsgType() uint64 {
+	if bsam.status {
+		return _BULK_SET_ACK_STATUS_MSG_TYPE
+	}
 	return _BULK_SET_ACK_MSG_TYPE
 }
 
 func (bsam *bulkSetAckMsg) MsgLength() uint64 {
-	return uint64(len(bsam.body))
+	return uint64(len(bsam.header) + len(bsam.body) + _MSG_CHECKSUM_LENGTH)
 }
 
 func (bsam *bulkSetAckMsg) WriteContent(w io.Writer) (uint64, error) {
-	n, err := w.Write(bsam.body)
-	return uint64(n), err
+	n, err := w.Write(bsam.header)
+	if err != nil {
+		return uint64(n), err
+	}
+	n, err = w.Write(bsam.body)
+	if err != nil {
+		return uint64(len(bsam.header)) + uint64(n), err
+	}
+	c := msgChecksumTrailer(bsam.header, bsam.body)
+	cn, err := w.Write(c[:])
+	return uint64(len(bsam.header)+len(bsam.body)) + uint64(cn), err
+}
+
+func (bsam *bulkSetAckMsg) entryLength() int {
+	if bsam.status {
+		return _BULK_SET_ACK_STATUS_MSG_ENTRY_LENGTH
+	}
+	return _BULK_SET_ACK_MSG_ENTRY_LENGTH
+}
+
+// bulkSetAckMsgChecksummed indicates whether an incoming body of length l
+// ends with a checksum. There is no flag for it: the entries are a whole
+// number of entryLength, so the checksum is the remainder when there is one,
+// and nodes from before it existed drop it as trailing bytes.
+func bulkSetAckMsgChecksummed(l int, entryLength int) bool {
+	return l%entryLength == _MSG_CHECKSUM_LENGTH
 }
 
 func (bsam *bulkSetAckMsg) Free() {
+	bsam.vs.peerFlowFree(bsam)
 	bsam.vs.bulkSetAckState.outFreeMsgChan <- bsam
 }
 
+func (bsam *bulkSetAckMsg) peerFlowNodeIDs() *[]uint64 {
+	return &bsam.peerFlow
+}
+
 func (bsam *bulkSetAckMsg) add(keyA uint64, keyB uint64, timestampbits uint64) bool {
 	o := len(bsam.body)
 	if o+_BULK_SET_ACK_MSG_ENTRY_LENGTH >= cap(bsam.body) {
@@ -179,3 +472,52 @@ func (bsam *bulkSetAckMsg) add(keyA uint64, keyB uint64, timestampbits uint64) b
 	binary.BigEndian.PutUint64(bsam.body[o+16:], timestampbits)
 	return true
 }
+
+// addStatus is add for status bulk-set-ack messages.
+func (bsam *bulkSetAckMsg) addStatus(keyA uint64, keyB uint64, timestampbits uint64, status byte) bool {
+	o := len(bsam.body)
+	if o+_BULK_SET_ACK_STATUS_MSG_ENTRY_LENGTH >= cap(bsam.body) {
+		return false
+	}
+	bsam.body = bsam.body[:o+_BULK_SET_ACK_STATUS_MSG_ENTRY_LENGTH]
+	binary.BigEndian.PutUint64(bsam.body[o:], keyA)
+	binary.BigEndian.PutUint64(bsam.body[o+8:], keyB)
+	binary.BigEndian.PutUint64(bsam.body[o+16:], timestampbits)
+	bsam.body[o+24] = status
+	return true
+}
+
+func (bsam *bulkSetAckMsg) nodeID() uint64 {
+	return binary.BigEndian.Uint64(bsam.header)
+}
+
+func (bsam *bulkSetAckMsg) msgIDCount() int {
+	if len(bsam.header) < _BULK_SET_ACK_MSG_HEADER_LENGTH {
+		return 0
+	}
+	return (len(bsam.header) - _BULK_SET_ACK_MSG_HEADER_LENGTH) / _BULK_SET_ACK_MSG_ID_LENGTH
+}
+
+func (bsam *bulkSetAckMsg) addMsgID(msgID uint64) {
+	var b [_BULK_SET_ACK_MSG_ID_LENGTH]byte
+	binary.BigEndian.PutUint64(b[:], msgID)
+	bsam.header = append(bsam.header, b[:]...)
+	binary.BigEndian.PutUint32(bsam.header[8:], uint32(bsam.msgIDCount()))
+}
+
+// msgID returns the ith of the msgIDs acknowledged.
+func (bsam *bulkSetAckMsg) msgID(i int) uint64 {
+	return binary.BigEndian.Uint64(bsam.header[_BULK_SET_ACK_MSG_HEADER_LENGTH+i*_BULK_SET_ACK_MSG_ID_LENGTH:])
+}
+
+// merge adds the msgIDs and entries of other to bsam, returning false if
+// there isn't room.
+func (bsam *bulkSetAckMsg) merge(other *bulkSetAckMsg) bool {
+	if bsam.status != other.status || len(bsam.body)+len(other.body) >= cap(bsam.body) {
+		return false
+	}
+	bsam.body = append(bsam.body, other.body...)
+	bsam.header = append(bsam.header, other.header[_BULK_SET_ACK_MSG_HEADER_LENGTH:]...)
+	binary.BigEndian.PutUint32(bsam.header[8:], uint32(bsam.msgIDCount()))
+	return true
+}
@@ -4,12 +4,13 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/gholt/ring"
 )
 
 func TestBulkSetAckRead(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
 	for i := 0; i < len(vs.bulkSetAckState.inBulkSetAckDoneChans); i++ {
 		vs.bulkSetAckState.inMsgChan <- nil
 	}
@@ -40,7 +41,7 @@ func TestBulkSetAckRead(t *testing.T) {
 }
 
 func TestBulkSetAckReadLowSendCap(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}, BulkSetAckMsgCap: 1})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}, BulkSetAckMsgCap: 1})
 	for i := 0; i < len(vs.bulkSetAckState.inBulkSetAckDoneChans); i++ {
 		vs.bulkSetAckState.inMsgChan <- nil
 	}
@@ -66,7 +67,7 @@ func TestBulkSetAckMsgIncoming(t *testing.T) {
 	r := b.Ring()
 	r.SetLocalNode(n.ID() + 1) // so we're not responsible for anything
 	m := &msgRingPlaceholder{ring: r}
-	vs := New(&Config{
+	vs := newTestStore(t, &Config{
 		MsgRing:             m,
 		InBulkSetAckWorkers: 1,
 		InBulkSetAckMsgs:    1,
@@ -115,7 +116,7 @@ func TestBulkSetAckMsgIncoming(t *testing.T) {
 
 func TestBulkSetAckMsgIncomingNoRing(t *testing.T) {
 	m := &msgRingPlaceholder{}
-	vs := New(&Config{
+	vs := newTestStore(t, &Config{
 		MsgRing:             m,
 		InBulkSetAckWorkers: 1,
 		InBulkSetAckMsgs:    1,
@@ -164,12 +165,12 @@ func TestBulkSetAckMsgIncomingNoRing(t *testing.T) {
 }
 
 func TestBulkSetAckMsgOut(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
 	bsam := vs.newOutBulkSetAckMsg()
 	if bsam.MsgType() != _BULK_SET_ACK_MSG_TYPE {
 		t.Fatal(bsam.MsgType())
 	}
-	if bsam.MsgLength() != 0 {
+	if bsam.MsgLength() != _BULK_SET_ACK_MSG_HEADER_LENGTH+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(bsam.MsgLength())
 	}
 	buf := bytes.NewBuffer(nil)
@@ -177,20 +178,23 @@ func TestBulkSetAckMsgOut(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if n != 0 {
+	if n != _BULK_SET_ACK_MSG_HEADER_LENGTH+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(n)
 	}
-	if !bytes.Equal(buf.Bytes(), []byte{}) {
+	expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	c := msgChecksumTrailer(expected, nil)
+	if !bytes.Equal(buf.Bytes(), append(expected, c[:]...)) {
 		t.Fatal(buf.Bytes())
 	}
 	bsam.Free()
 	bsam = vs.newOutBulkSetAckMsg()
+	bsam.addMsgID(9)
 	bsam.add(1, 2, 0x300)
 	bsam.add(4, 5, 0x600)
 	if bsam.MsgType() != _BULK_SET_ACK_MSG_TYPE {
 		t.Fatal(bsam.MsgType())
 	}
-	if bsam.MsgLength() != _BULK_SET_ACK_MSG_ENTRY_LENGTH+_BULK_SET_ACK_MSG_ENTRY_LENGTH {
+	if bsam.MsgLength() != _BULK_SET_ACK_MSG_HEADER_LENGTH+_BULK_SET_ACK_MSG_ID_LENGTH+_BULK_SET_ACK_MSG_ENTRY_LENGTH+_BULK_SET_ACK_MSG_ENTRY_LENGTH+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(bsam.MsgLength())
 	}
 	buf = bytes.NewBuffer(nil)
@@ -198,24 +202,30 @@ func TestBulkSetAckMsgOut(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if n != _BULK_SET_ACK_MSG_ENTRY_LENGTH+_BULK_SET_ACK_MSG_ENTRY_LENGTH {
+	if n != _BULK_SET_ACK_MSG_HEADER_LENGTH+_BULK_SET_ACK_MSG_ID_LENGTH+_BULK_SET_ACK_MSG_ENTRY_LENGTH+_BULK_SET_ACK_MSG_ENTRY_LENGTH+_MSG_CHECKSUM_LENGTH {
 		t.Fatal(n)
 	}
-	if !bytes.Equal(buf.Bytes(), []byte{
+	expected = []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, // senderNodeID
+		0, 0, 0, 1, // msgIDCount
+		0, 0, 0, 0, 0, 0, 0, 9, // msgID
 		0, 0, 0, 0, 0, 0, 0, 1, // keyA
 		0, 0, 0, 0, 0, 0, 0, 2, // keyB
 		0, 0, 0, 0, 0, 0, 3, 0, // timestamp
 		0, 0, 0, 0, 0, 0, 0, 4, // keyA
 		0, 0, 0, 0, 0, 0, 0, 5, // keyB
 		0, 0, 0, 0, 0, 0, 6, 0, // timestamp
-	}) {
+	}
+	h := _BULK_SET_ACK_MSG_HEADER_LENGTH + _BULK_SET_ACK_MSG_ID_LENGTH
+	c = msgChecksumTrailer(expected[:h], expected[h:])
+	if !bytes.Equal(buf.Bytes(), append(expected, c[:]...)) {
 		t.Fatal(buf.Bytes())
 	}
 	bsam.Free()
 }
 
 func TestBulkSetAckMsgOutWriteError(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
 	bsam := vs.newOutBulkSetAckMsg()
 	bsam.add(1, 2, 0x300)
 	_, err := bsam.WriteContent(&testErrorWriter{})
@@ -226,7 +236,7 @@ func TestBulkSetAckMsgOutWriteError(t *testing.T) {
 }
 
 func TestBulkSetAckMsgOutHitCap(t *testing.T) {
-	vs := New(&Config{MsgRing: &msgRingPlaceholder{}, BulkSetAckMsgCap: _BULK_SET_ACK_MSG_ENTRY_LENGTH + 3})
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}, BulkSetAckMsgCap: _BULK_SET_ACK_MSG_ENTRY_LENGTH + 3})
 	bsam := vs.newOutBulkSetAckMsg()
 	if !bsam.add(1, 2, 0x300) {
 		t.Fatal("")
@@ -235,3 +245,88 @@ func TestBulkSetAckMsgOutHitCap(t *testing.T) {
 		t.Fatal("")
 	}
 }
+
+func TestBulkSetAckMsgAggregate(t *testing.T) {
+	m := &msgRingPlaceholder{}
+	vs := newTestStore(t, &Config{MsgRing: m, OutBulkSetAckDelay: 50})
+	for i := uint64(1); i <= 3; i++ {
+		bsam := vs.newOutBulkSetAckMsg()
+		bsam.addMsgID(i)
+		bsam.add(i, i, 0x300)
+		vs.outBulkSetAck(bsam, 123)
+	}
+	vs.bulkSetAckState.outPendingLock.Lock()
+	bsam := vs.bulkSetAckState.outPending[123]
+	if bsam == nil {
+		t.Fatal("")
+	}
+	if bsam.msgIDCount() != 3 {
+		t.Fatal(bsam.msgIDCount())
+	}
+	if len(bsam.body) != 3*_BULK_SET_ACK_MSG_ENTRY_LENGTH {
+		t.Fatal(len(bsam.body))
+	}
+	vs.bulkSetAckState.outPendingLock.Unlock()
+	for {
+		m.lock.Lock()
+		sent := len(m.msgToNodeIDs)
+		m.lock.Unlock()
+		if sent > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.lock.Lock()
+	if len(m.msgToNodeIDs) != 1 || m.msgToNodeIDs[0] != 123 {
+		t.Fatal(m.msgToNodeIDs)
+	}
+	m.lock.Unlock()
+}
+
+func TestBulkSetAckReadMsgIDs(t *testing.T) {
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
+	for i := 0; i < len(vs.bulkSetAckState.inBulkSetAckDoneChans); i++ {
+		vs.bulkSetAckState.inMsgChan <- nil
+	}
+	for _, doneChan := range vs.bulkSetAckState.inBulkSetAckDoneChans {
+		<-doneChan
+	}
+	out := vs.newOutBulkSetAckMsg()
+	out.addMsgID(7)
+	out.addMsgID(8)
+	out.add(1, 2, 0x300)
+	buf := bytes.NewBuffer(nil)
+	if _, err := out.WriteContent(buf); err != nil {
+		t.Fatal(err)
+	}
+	l := uint64(buf.Len())
+	n, err := vs.newInBulkSetAckMsg(buf, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != l {
+		t.Fatal(n)
+	}
+	bsam := <-vs.bulkSetAckState.inMsgChan
+	if bsam.msgIDCount() != 2 {
+		t.Fatal(bsam.msgIDCount())
+	}
+	if len(bsam.body) != _BULK_SET_ACK_MSG_ENTRY_LENGTH {
+		t.Fatal(len(bsam.body))
+	}
+	// Claiming more msgIDs than there are bytes is invalid.
+	b := make([]byte, 20)
+	b[11] = 3
+	n, err = vs.newInBulkSetAckMsg(bytes.NewBuffer(b), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Fatal(n)
+	}
+	select {
+	case bsam := <-vs.bulkSetAckState.inMsgChan:
+		t.Fatal(bsam)
+	default:
+	}
+}
@@ -0,0 +1,187 @@
+package valuestore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bulkSetPeersState keeps incoming bulk-set messages from any one sending
+// node from crowding out those from other nodes. Each node may only hold a
+// share of the incoming bulk-set messages at a time, may optionally be
+// limited to a byte rate, and the messages that are accepted are handed to
+// the inBulkSet workers round-robin across the sending nodes rather than
+// strictly in arrival order.
+//
+// Messages are also split into lanes, each with its own scheduler and
+// workers, so that backfill messages (handoffs and repairs) aren't stuck
+// behind a large backlog of routine pull-replication responses.
+type bulkSetPeersState struct {
+	msgs        int
+	bytesPerSec float64
+	lock        sync.Mutex
+	peers       map[uint64]*bulkSetPeer
+	lanes       [_BULK_SET_LANES]bulkSetLane
+}
+
+// The lanes incoming bulk-set messages are split into.
+const (
+	_BULK_SET_LANE_ROUTINE = iota
+	_BULK_SET_LANE_PRIORITY
+	_BULK_SET_LANES
+)
+
+type bulkSetLane struct {
+	order      []uint64
+	orderIndex int
+	queuedChan chan struct{}
+	msgChan    chan *bulkSetMsg
+}
+
+type bulkSetPeer struct {
+	inUse  int
+	queues [_BULK_SET_LANES][]*bulkSetMsg
+	tokens float64
+	last   time.Time
+}
+
+func (vs *DefaultValueStore) bulkSetPeersConfig(cfg *Config) {
+	vs.bulkSetPeersState.msgs = cfg.InBulkSetPeerMsgs
+	vs.bulkSetPeersState.bytesPerSec = float64(cfg.InBulkSetPeerBytesPerSec)
+	vs.bulkSetPeersState.peers = make(map[uint64]*bulkSetPeer)
+	if vs.msgRing != nil {
+		for i := range vs.bulkSetPeersState.lanes {
+			vs.bulkSetPeersState.lanes[i].queuedChan = make(chan struct{}, cap(vs.bulkSetState.inFreeMsgChan))
+		}
+		vs.bulkSetPeersState.lanes[_BULK_SET_LANE_ROUTINE].msgChan = vs.bulkSetState.inMsgChan
+		vs.bulkSetPeersState.lanes[_BULK_SET_LANE_PRIORITY].msgChan = vs.bulkSetState.inPriorityMsgChan
+	}
+}
+
+func (vs *DefaultValueStore) bulkSetPeersLaunch() {
+	for i := range vs.bulkSetPeersState.lanes {
+		if vs.bulkSetPeersState.lanes[i].queuedChan != nil {
+			vs.closeState.backgroundWG.Add(1)
+			go vs.inBulkSetScheduler(i)
+		}
+	}
+}
+
+// inBulkSetAdmit reports whether a bulk-set message of l bytes from nodeID
+// should be accepted, given the node's share of incoming messages and any
+// byte rate limit. If true is returned, the node is charged for the message
+// and inBulkSetRelease must be called once the message is done with.
+func (vs *DefaultValueStore) inBulkSetAdmit(nodeID uint64, l uint64) bool {
+	s := &vs.bulkSetPeersState
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p := s.peers[nodeID]
+	if p == nil {
+		p = &bulkSetPeer{tokens: s.bytesPerSec, last: time.Now()}
+		s.peers[nodeID] = p
+	}
+	if p.inUse >= s.msgs {
+		return false
+	}
+	if s.bytesPerSec > 0 {
+		now := time.Now()
+		p.tokens += now.Sub(p.last).Seconds() * s.bytesPerSec
+		if p.tokens > s.bytesPerSec {
+			p.tokens = s.bytesPerSec
+		}
+		p.last = now
+		// A node with any budget left may send a message larger than that
+		// budget; it will just have to wait longer before the next one.
+		if p.tokens <= 0 {
+			return false
+		}
+		p.tokens -= float64(l)
+	}
+	p.inUse++
+	return true
+}
+
+// inBulkSetRelease undoes the charge made by a successful inBulkSetAdmit.
+func (vs *DefaultValueStore) inBulkSetRelease(nodeID uint64) {
+	s := &vs.bulkSetPeersState
+	s.lock.Lock()
+	if p := s.peers[nodeID]; p != nil && p.inUse > 0 {
+		p.inUse--
+		if p.inUse == 0 && len(p.queues[_BULK_SET_LANE_ROUTINE]) == 0 && len(p.queues[_BULK_SET_LANE_PRIORITY]) == 0 && (s.bytesPerSec <= 0 || p.tokens >= s.bytesPerSec) {
+			delete(s.peers, nodeID)
+		}
+	}
+	s.lock.Unlock()
+}
+
+// inBulkSetFree releases any charge against the sending node for an incoming
+// bulk-set message and returns the message for reuse.
+func (vs *DefaultValueStore) inBulkSetFree(bsm *bulkSetMsg) {
+	if bsm.admitted {
+		bsm.admitted = false
+		vs.inBulkSetRelease(bsm.nodeID())
+	}
+	vs.bulkSetState.inFreeMsgChan <- bsm
+}
+
+// inBulkSetEnqueue queues an admitted bulk-set message for the scheduler of
+// its lane.
+func (vs *DefaultValueStore) inBulkSetEnqueue(bsm *bulkSetMsg) {
+	s := &vs.bulkSetPeersState
+	nodeID := bsm.nodeID()
+	lane := _BULK_SET_LANE_ROUTINE
+	if bsm.backfill() {
+		lane = _BULK_SET_LANE_PRIORITY
+		atomic.AddInt32(&vs.inBulkSetPriorities, 1)
+	}
+	l := &s.lanes[lane]
+	s.lock.Lock()
+	p := s.peers[nodeID]
+	if p == nil {
+		p = &bulkSetPeer{tokens: s.bytesPerSec, last: time.Now()}
+		s.peers[nodeID] = p
+	}
+	if len(p.queues[lane]) == 0 {
+		l.order = append(l.order, nodeID)
+	}
+	p.queues[lane] = append(p.queues[lane], bsm)
+	s.lock.Unlock()
+	l.queuedChan <- struct{}{}
+}
+
+// inBulkSetScheduler moves queued bulk-set messages to the lane's msgChan,
+// taking one message from each sending node in turn.
+func (vs *DefaultValueStore) inBulkSetScheduler(lane int) {
+	s := &vs.bulkSetPeersState
+	l := &s.lanes[lane]
+	defer vs.closeState.backgroundWG.Done()
+	for {
+		select {
+		case <-l.queuedChan:
+		case <-vs.closeState.backgroundChan:
+			return
+		}
+		s.lock.Lock()
+		if l.orderIndex >= len(l.order) {
+			l.orderIndex = 0
+		}
+		nodeID := l.order[l.orderIndex]
+		p := s.peers[nodeID]
+		bsm := p.queues[lane][0]
+		p.queues[lane][0] = nil
+		p.queues[lane] = p.queues[lane][1:]
+		if len(p.queues[lane]) == 0 {
+			copy(l.order[l.orderIndex:], l.order[l.orderIndex+1:])
+			l.order = l.order[:len(l.order)-1]
+		} else {
+			l.orderIndex++
+		}
+		s.lock.Unlock()
+		select {
+		case l.msgChan <- bsm:
+		case <-vs.closeState.backgroundChan:
+			vs.inBulkSetFree(bsm)
+			return
+		}
+	}
+}
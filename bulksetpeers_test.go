@@ -0,0 +1,126 @@
+package valuestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func bulkSetPeersTestMsg(nodeID uint64) *bytes.Buffer {
+	b := make([]byte, 100)
+	binary.BigEndian.PutUint64(b, nodeID)
+	return bytes.NewBuffer(b)
+}
+
+func TestBulkSetPeersShare(t *testing.T) {
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}, InBulkSetMsgs: 4, InBulkSetPeerMsgs: 2})
+	for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
+		vs.bulkSetState.inMsgChan <- nil
+	}
+	for _, doneChan := range vs.bulkSetState.inBulkSetDoneChans {
+		<-doneChan
+	}
+	// Node 3's message will be taken by the scheduler right away, which then
+	// blocks until it's received; everything after that queues up.
+	if _, err := vs.newInBulkSetMsg(bulkSetPeersTestMsg(3), 100); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		vs.bulkSetPeersState.lock.Lock()
+		queued := len(vs.bulkSetPeersState.peers[3].queues[_BULK_SET_LANE_ROUTINE])
+		vs.bulkSetPeersState.lock.Unlock()
+		if queued == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		n, err := vs.newInBulkSetMsg(bulkSetPeersTestMsg(1), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 100 {
+			t.Fatal(n)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSets != 3 || stats.InBulkSetPeerDrops != 1 {
+		t.Fatal(stats.InBulkSets, stats.InBulkSetPeerDrops)
+	}
+	// Another node still gets through, and ahead of node 1's second message.
+	if _, err := vs.newInBulkSetMsg(bulkSetPeersTestMsg(2), 100); err != nil {
+		t.Fatal(err)
+	}
+	var nodeIDs []uint64
+	for i := 0; i < 4; i++ {
+		bsm := <-vs.bulkSetState.inMsgChan
+		nodeIDs = append(nodeIDs, bsm.nodeID())
+		vs.inBulkSetFree(bsm)
+	}
+	if nodeIDs[0] != 3 || nodeIDs[1] != 1 || nodeIDs[2] != 2 || nodeIDs[3] != 1 {
+		t.Fatal(nodeIDs)
+	}
+	if _, err := vs.newInBulkSetMsg(bulkSetPeersTestMsg(1), 100); err != nil {
+		t.Fatal(err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSets != 2 || stats.InBulkSetPeerDrops != 0 {
+		t.Fatal(stats.InBulkSets, stats.InBulkSetPeerDrops)
+	}
+	<-vs.bulkSetState.inMsgChan
+}
+
+func TestBulkSetPeersPriorityLane(t *testing.T) {
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}, InBulkSetMsgs: 4, InBulkSetPeerMsgs: 4})
+	for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
+		vs.bulkSetState.inMsgChan <- nil
+	}
+	for _, doneChan := range vs.bulkSetState.inBulkSetDoneChans {
+		<-doneChan
+	}
+	for i := 0; i < len(vs.bulkSetState.inPriorityDoneChans); i++ {
+		vs.bulkSetState.inPriorityMsgChan <- nil
+	}
+	for _, doneChan := range vs.bulkSetState.inPriorityDoneChans {
+		<-doneChan
+	}
+	// With nothing taking the routine messages, those back up while the
+	// backfill message still gets through.
+	for i := 0; i < 2; i++ {
+		if _, err := vs.newInBulkSetMsg(bulkSetPeersTestMsg(1), 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := bulkSetPeersTestMsg(1)
+	b.Bytes()[16] = _BULK_SET_MSG_FLAG_BACKFILL
+	if _, err := vs.newInBulkSetMsg(b, 100); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case bsm := <-vs.bulkSetState.inPriorityMsgChan:
+		if !bsm.backfill() {
+			t.Fatal(bsm.header)
+		}
+		vs.inBulkSetFree(bsm)
+	case <-time.After(time.Second):
+		t.Fatal("backfill message not received")
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSetPriorities != 1 {
+		t.Fatal(stats.InBulkSetPriorities)
+	}
+	<-vs.bulkSetState.inMsgChan
+	<-vs.bulkSetState.inMsgChan
+}
+
+func TestBulkSetPeersBytesPerSec(t *testing.T) {
+	vs := newTestStore(t, &Config{InBulkSetMsgs: 4, InBulkSetPeerMsgs: 4, InBulkSetPeerBytesPerSec: 1000})
+	if !vs.inBulkSetAdmit(1, 1500) {
+		t.Fatal("")
+	}
+	// Node 1 is now in debt, but node 2 is unaffected.
+	if vs.inBulkSetAdmit(1, 10) {
+		t.Fatal("")
+	}
+	if !vs.inBulkSetAdmit(2, 10) {
+		t.Fatal("")
+	}
+}
@@ -0,0 +1,66 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// Values and TOC files record the checksum algorithm and interval they were
+// written with in the last four bytes of their 32 byte header; the high byte
+// is the algorithm and the low three bytes are the interval. Files written
+// before the algorithm was recorded have a zero high byte, which is murmur3,
+// so they read the same as before. Readers honor what each file records, so
+// the configured ChecksumInterval can change without invalidating existing
+// files.
+const (
+	_CHECKSUM_MURMUR3 = 0
+)
+
+// _CHECKSUM_INTERVAL_MAX is the largest interval the header can record.
+const _CHECKSUM_INTERVAL_MAX = 1<<24 - 1
+
+var checksumAlgorithms = map[byte]func() hash.Hash32{
+	_CHECKSUM_MURMUR3: murmur3.New32,
+}
+
+func putChecksumHeader(head []byte, algorithm byte, interval uint32) {
+	binary.BigEndian.PutUint32(head[28:], uint32(algorithm)<<24|interval)
+}
+
+// checksumHeader returns the checksum interval and hash func recorded in the
+// given header.
+func checksumHeader(head []byte) (uint32, func() hash.Hash32, error) {
+	v := binary.BigEndian.Uint32(head[28:])
+	newHash := checksumAlgorithms[byte(v>>24)]
+	if newHash == nil {
+		return 0, nil, fmt.Errorf("unknown checksum algorithm %d", v>>24)
+	}
+	interval := v & _CHECKSUM_INTERVAL_MAX
+	if interval < 32 {
+		return 0, nil, fmt.Errorf("bad checksum interval %d", interval)
+	}
+	return interval, newHash, nil
+}
+
+// readChecksumHeader reads the header at the start of rs and returns the
+// checksum interval and hash func it records, leaving rs at the start again.
+func readChecksumHeader(rs io.ReadSeeker) (uint32, func() hash.Hash32, error) {
+	head := make([]byte, 32)
+	if _, err := io.ReadFull(rs, head); err != nil {
+		return 0, nil, err
+	}
+	if _, err := rs.Seek(0, 0); err != nil {
+		return 0, nil, err
+	}
+	return checksumHeader(head)
+}
+
+func checksum32(h hash.Hash32, b []byte) uint32 {
+	h.Reset()
+	h.Write(b)
+	return h.Sum32()
+}
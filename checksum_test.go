@@ -0,0 +1,25 @@
+package valuestore
+
+import (
+	"testing"
+)
+
+func TestChecksumHeader(t *testing.T) {
+	head := []byte("VALUESTORE v0                   ")
+	putChecksumHeader(head, _CHECKSUM_MURMUR3, 1234)
+	if string(head[:28]) != "VALUESTORE v0               " {
+		t.Fatal(string(head[:28]))
+	}
+	interval, newHash, err := checksumHeader(head)
+	if err != nil || interval != 1234 || newHash == nil {
+		t.Fatal(interval, err)
+	}
+	putChecksumHeader(head, 200, 1234)
+	if _, _, err = checksumHeader(head); err == nil {
+		t.Fatal("")
+	}
+	putChecksumHeader(head, _CHECKSUM_MURMUR3, 0)
+	if _, _, err = checksumHeader(head); err == nil {
+		t.Fatal("")
+	}
+}
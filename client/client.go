@@ -0,0 +1,291 @@
+// Package client is the Go client for a valuestore run as a service, over
+// either the gRPC service of package grpc or the HTTP API of
+// valuestore.NewHTTPHandler, so applications needn't each write their own.
+//
+// It hashes key names into keys with Key, gives each attempt a timeout,
+// retries failed attempts, and, given the ring, sends each call to the nodes
+// responsible for the key's partition, trying the next of them when one
+// fails. Without a ring, the calls go to the Config.Addresses, the next
+// tried when one fails.
+//
+// Writes and deletes carry their timestampmicro, so repeating one is
+// harmless, and they are retried as readily as reads are.
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gholt/ring"
+	"github.com/spaolacci/murmur3"
+	gogrpc "google.golang.org/grpc"
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// The defaults for the Config fields left zero.
+const (
+	_TIMEOUT     = 10 * time.Second
+	_RETRIES     = 2
+	_RETRY_DELAY = 100 * time.Millisecond
+)
+
+// ErrNoAddresses is returned by calls with no address to send them to:
+// neither Config.Addresses nor a ring node responsible for the key, with an
+// address at Config.RingAddressIndex, were given.
+var ErrNoAddresses error = errors.New("no addresses")
+
+// Config describes how a Client reaches the store; the zero value of each
+// field has the default noted.
+type Config struct {
+	// Addresses are the host:port addresses of the servers, tried in turn
+	// for calls made without a Ring.
+	Addresses []string
+	// Ring, if set, sends each call to the ResponsibleNodes of its key's
+	// partition, at their Address(RingAddressIndex), instead of to the
+	// Addresses. SetRing changes it as the ring does.
+	Ring             ring.Ring
+	RingAddressIndex int
+	// HTTP speaks to valuestore.NewHTTPHandler, mounted at the root of each
+	// address, instead of to the gRPC service.
+	HTTP bool
+	// HTTPScheme is the scheme of the HTTP URLs; default "http".
+	HTTPScheme string
+	// DialOptions are given to grpc.NewClient for each address; default
+	// insecure transport credentials.
+	DialOptions []gogrpc.DialOption
+	// Timeout is the most each attempt of a call may take; default 10s.
+	Timeout time.Duration
+	// Retries is how many more attempts a call may make after the first
+	// fails with a retryable error, such as a server being unavailable or an
+	// attempt timing out; default 2, and negative for none.
+	Retries int
+	// RetryDelay is the wait before the first retry, doubled for each after;
+	// default 100ms.
+	RetryDelay time.Duration
+}
+
+// conn is a connection to one address, over gRPC or HTTP.
+type conn interface {
+	read(ctx context.Context, keyA uint64, keyB uint64) (int64, []byte, error)
+	lookup(ctx context.Context, keyA uint64, keyB uint64) (int64, uint32, error)
+	write(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error)
+	delete(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64) (int64, error)
+	close() error
+}
+
+// retryableError marks an error from a conn as worth trying again, on the
+// next address.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// Client makes the store's calls against its servers; it is safe for
+// concurrent use.
+type Client struct {
+	cfg   Config
+	lock  sync.Mutex
+	ring  ring.Ring
+	conns map[string]conn
+	next  int
+}
+
+// New returns a Client for the store cfg describes; no connections are made
+// until the first call.
+func New(cfg *Config) *Client {
+	c := &Client{cfg: *cfg, ring: cfg.Ring, conns: map[string]conn{}}
+	c.cfg.Addresses = append([]string(nil), cfg.Addresses...)
+	if c.cfg.HTTPScheme == "" {
+		c.cfg.HTTPScheme = "http"
+	}
+	if c.cfg.Timeout <= 0 {
+		c.cfg.Timeout = _TIMEOUT
+	}
+	if c.cfg.Retries == 0 {
+		c.cfg.Retries = _RETRIES
+	} else if c.cfg.Retries < 0 {
+		c.cfg.Retries = 0
+	}
+	if c.cfg.RetryDelay <= 0 {
+		c.cfg.RetryDelay = _RETRY_DELAY
+	}
+	return c
+}
+
+// Key returns the keyA, keyB for the key name, its 128 bit murmur3 hash.
+func Key(name []byte) (uint64, uint64) {
+	return murmur3.Sum128(name)
+}
+
+// SetRing replaces the ring calls are routed with, as the ring changes; nil
+// goes back to Config.Addresses.
+func (c *Client) SetRing(r ring.Ring) {
+	c.lock.Lock()
+	c.ring = r
+	c.lock.Unlock()
+}
+
+// Close closes the connections made; calls made after may reopen them.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	conns := c.conns
+	c.conns = map[string]conn{}
+	c.lock.Unlock()
+	var err error
+	for _, cn := range conns {
+		if cerr := cn.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Read returns the timestampmicro and value stored for keyA, keyB, or
+// valuestore.ErrNotFound, as the store's Read does.
+func (c *Client) Read(ctx context.Context, keyA uint64, keyB uint64) (int64, []byte, error) {
+	var timestampmicro int64
+	var value []byte
+	err := c.call(ctx, keyA, func(ctx context.Context, cn conn) error {
+		var err error
+		timestampmicro, value, err = cn.read(ctx, keyA, keyB)
+		return err
+	})
+	return timestampmicro, value, err
+}
+
+// Lookup returns the timestampmicro and length of the value stored for keyA,
+// keyB, or valuestore.ErrNotFound, as the store's Lookup does.
+func (c *Client) Lookup(ctx context.Context, keyA uint64, keyB uint64) (int64, uint32, error) {
+	var timestampmicro int64
+	var length uint32
+	err := c.call(ctx, keyA, func(ctx context.Context, cn conn) error {
+		var err error
+		timestampmicro, length, err = cn.lookup(ctx, keyA, keyB)
+		return err
+	})
+	return timestampmicro, length, err
+}
+
+// Write stores the value for keyA, keyB at timestampmicro, 0 being the
+// current time, and returns the timestampmicro previously stored, as the
+// store's Write does.
+func (c *Client) Write(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
+	if timestampmicro == 0 {
+		timestampmicro = brimtime.TimeToUnixMicro(time.Now())
+	}
+	var ptimestampmicro int64
+	err := c.call(ctx, keyA, func(ctx context.Context, cn conn) error {
+		var err error
+		ptimestampmicro, err = cn.write(ctx, keyA, keyB, timestampmicro, value)
+		return err
+	})
+	return ptimestampmicro, err
+}
+
+// Delete deletes keyA, keyB at timestampmicro, 0 being the current time, and
+// returns the timestampmicro previously stored, as the store's Delete does.
+func (c *Client) Delete(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64) (int64, error) {
+	if timestampmicro == 0 {
+		timestampmicro = brimtime.TimeToUnixMicro(time.Now())
+	}
+	var ptimestampmicro int64
+	err := c.call(ctx, keyA, func(ctx context.Context, cn conn) error {
+		var err error
+		ptimestampmicro, err = cn.delete(ctx, keyA, keyB, timestampmicro)
+		return err
+	})
+	return ptimestampmicro, err
+}
+
+// call makes f's attempts, each with Config.Timeout, against the addresses
+// for keyA in turn until one does not fail with a retryableError or the
+// retries run out; the error returned is then unwrapped.
+func (c *Client) call(ctx context.Context, keyA uint64, f func(ctx context.Context, cn conn) error) error {
+	addresses := c.addresses(keyA)
+	if len(addresses) == 0 {
+		return ErrNoAddresses
+	}
+	delay := c.cfg.RetryDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		var cn conn
+		cn, err = c.conn(addresses[attempt%len(addresses)])
+		if err == nil {
+			actx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+			err = f(actx, cn)
+			cancel()
+		}
+		var rerr *retryableError
+		if !errors.As(err, &rerr) {
+			return err
+		}
+		err = rerr.err
+		if attempt >= c.cfg.Retries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// addresses returns the addresses to try for keyA: those of the ring's nodes
+// responsible for its partition, or Config.Addresses starting from the one
+// after the last call's.
+func (c *Client) addresses(keyA uint64) []string {
+	c.lock.Lock()
+	r := c.ring
+	next := c.next
+	c.next++
+	c.lock.Unlock()
+	if r == nil {
+		n := len(c.cfg.Addresses)
+		if n == 0 {
+			return nil
+		}
+		addresses := make([]string, n)
+		for i := range addresses {
+			addresses[i] = c.cfg.Addresses[(next+i)%n]
+		}
+		return addresses
+	}
+	var addresses []string
+	for _, n := range r.ResponsibleNodes(uint32(keyA >> (64 - r.PartitionBitCount()))) {
+		if a := n.Address(c.cfg.RingAddressIndex); a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses
+}
+
+// conn returns the connection to address, making it if need be.
+func (c *Client) conn(address string) (conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cn := c.conns[address]; cn != nil {
+		return cn, nil
+	}
+	var cn conn
+	if c.cfg.HTTP {
+		cn = newHTTPConn(c.cfg.HTTPScheme + "://" + address)
+	} else {
+		var err error
+		if cn, err = newGRPCConn(address, c.cfg.DialOptions); err != nil {
+			return nil, err
+		}
+	}
+	c.conns[address] = cn
+	return cn, nil
+}
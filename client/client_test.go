@@ -0,0 +1,231 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+	"github.com/spaolacci/murmur3"
+
+	"github.com/pandemicsyn/valuestore"
+	vsgrpc "github.com/pandemicsyn/valuestore/grpc"
+)
+
+func newTestStore(t *testing.T) *valuestore.DefaultValueStore {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := valuestore.New(&valuestore.Config{Path: dir, IgnoreEnv: true})
+	vs.EnableWrites()
+	t.Cleanup(func() {
+		vs.Close()
+		os.RemoveAll(dir)
+	})
+	return vs
+}
+
+// serveGRPC serves vs with package grpc and returns the address.
+func serveGRPC(t *testing.T, vs valuestore.ValueStore) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := vsgrpc.NewServer(vs)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// serveHTTP serves vs with valuestore.NewHTTPHandler and returns the address.
+func serveHTTP(t *testing.T, vs valuestore.ValueStore) string {
+	s := httptest.NewServer(valuestore.NewHTTPHandler(vs))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// deadAddress returns an address nothing listens on.
+func deadAddress(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := lis.Addr().String()
+	lis.Close()
+	return address
+}
+
+func testClientCalls(t *testing.T, c *Client) {
+	ctx := context.Background()
+	if ts, err := c.Write(ctx, 1, 2, 1000, []byte("value")); err != nil || ts != 0 {
+		t.Fatal(ts, err)
+	}
+	if ts, value, err := c.Read(ctx, 1, 2); err != nil || ts != 1000 || string(value) != "value" {
+		t.Fatal(ts, string(value), err)
+	}
+	if ts, length, err := c.Lookup(ctx, 1, 2); err != nil || ts != 1000 || length != 5 {
+		t.Fatal(ts, length, err)
+	}
+	// A value larger than one message, and an empty one.
+	large := bytes.Repeat([]byte("0123456789abcdef"), (_VALUE_CHUNK*5/2)/16)
+	for keyB, value := range [][]byte{large, {}} {
+		if _, err := c.Write(ctx, 3, uint64(keyB), 1000, value); err != nil {
+			t.Fatal(err)
+		}
+		if _, value2, err := c.Read(ctx, 3, uint64(keyB)); err != nil || !bytes.Equal(value2, value) {
+			t.Fatal(len(value2), err)
+		}
+	}
+	if ts, err := c.Delete(ctx, 1, 2, 2000); err != nil || ts != 1000 {
+		t.Fatal(ts, err)
+	}
+	if _, _, err := c.Read(ctx, 1, 2); err != valuestore.ErrNotFound {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Lookup(ctx, 1, 2); err != valuestore.ErrNotFound {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, 1, 3, 3000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Delete(ctx, 1, 3, 2000); err != valuestore.ErrNewerVersion {
+		t.Fatal(err)
+	}
+	// A zero timestampmicro is the current time.
+	before := time.Now().UnixNano() / 1000
+	if _, err := c.Write(ctx, 1, 4, 0, []byte("now")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, _, err := c.Lookup(ctx, 1, 4); err != nil || ts < before {
+		t.Fatal(ts, before, err)
+	}
+}
+
+func TestClientGRPC(t *testing.T) {
+	c := New(&Config{Addresses: []string{serveGRPC(t, newTestStore(t))}})
+	defer c.Close()
+	testClientCalls(t, c)
+}
+
+func TestClientHTTP(t *testing.T) {
+	vs := newTestStore(t)
+	c := New(&Config{Addresses: []string{serveHTTP(t, vs)}, HTTP: true})
+	defer c.Close()
+	testClientCalls(t, c)
+	// Lookup is a HEAD, which the server answers without reading the value.
+	vs.Stats(false)
+	if ts, length, err := c.Lookup(context.Background(), 1, 3); err != nil || ts != 3000 || length != 5 {
+		t.Fatal(ts, length, err)
+	}
+	if stats := vs.Stats(false).(*valuestore.Stats); stats.Reads != 0 || stats.Lookups != 1 {
+		t.Fatal(stats.Reads, stats.Lookups)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	vs := newTestStore(t)
+	for _, cfg := range []*Config{
+		{Addresses: []string{deadAddress(t), serveGRPC(t, vs)}},
+		{Addresses: []string{deadAddress(t), serveHTTP(t, vs)}, HTTP: true},
+	} {
+		cfg.RetryDelay = time.Millisecond
+		c := New(cfg)
+		// Whichever address each call starts with, it gets to the live one.
+		for i := 0; i < 4; i++ {
+			if _, err := c.Write(context.Background(), 1, 2, int64(1000+i), []byte("value")); err != nil {
+				t.Fatal(i, err)
+			}
+		}
+		c.Close()
+		cfg.Retries = -1
+		c = New(cfg)
+		var failed bool
+		for i := 0; i < 2; i++ {
+			if _, _, err := c.Read(context.Background(), 1, 2); err != nil {
+				failed = true
+			}
+		}
+		if !failed {
+			t.Fatal("no failure without retries")
+		}
+		c.Close()
+	}
+	if _, _, err := New(&Config{}).Read(context.Background(), 1, 2); err != ErrNoAddresses {
+		t.Fatal(err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	// A server that accepts but never answers.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	c := New(&Config{Addresses: []string{lis.Addr().String()}, HTTP: true, Timeout: 10 * time.Millisecond, RetryDelay: time.Millisecond})
+	defer c.Close()
+	start := time.Now()
+	if _, _, err := c.Read(context.Background(), 1, 2); err == nil {
+		t.Fatal("read answered")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatal(elapsed)
+	}
+}
+
+func TestClientRing(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(1)
+	var stores []*valuestore.DefaultValueStore
+	for i := 0; i < 2; i++ {
+		vs := newTestStore(t)
+		stores = append(stores, vs)
+		if _, err := b.AddNode(true, 1, nil, []string{serveGRPC(t, vs)}, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := b.Ring()
+	c := New(&Config{Ring: r})
+	defer c.Close()
+	for partition := uint64(0); partition < 16; partition++ {
+		keyA := partition << 60
+		if _, err := c.Write(context.Background(), keyA, 1, 1000, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		address := r.ResponsibleNodes(uint32(keyA >> (64 - r.PartitionBitCount())))[0].Address(0)
+		for i, n := range r.Nodes() {
+			_, _, err := stores[i].Lookup(keyA, 1)
+			if (err == nil) != (n.Address(0) == address) {
+				t.Fatal(keyA, i, err)
+			}
+		}
+	}
+	// Without the ring, and with no Addresses, there is nowhere to go.
+	c.SetRing(nil)
+	if _, _, err := c.Read(context.Background(), 0, 1); err != ErrNoAddresses {
+		t.Fatal(err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	keyA, keyB := Key([]byte("name"))
+	if a, b := murmur3.Sum128([]byte("name")); keyA != a || keyB != b {
+		t.Fatal(keyA, keyB)
+	}
+}
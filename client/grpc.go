@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/pandemicsyn/valuestore"
+	vsgrpc "github.com/pandemicsyn/valuestore/grpc"
+)
+
+// _VALUE_CHUNK is the most value bytes sent in one message, as with the
+// server.
+const _VALUE_CHUNK = 1 << 20
+
+// grpcConn is a conn to the ValueStore service of package grpc.
+type grpcConn struct {
+	cc *gogrpc.ClientConn
+	c  vsgrpc.ValueStoreClient
+}
+
+func newGRPCConn(address string, opts []gogrpc.DialOption) (*grpcConn, error) {
+	if len(opts) == 0 {
+		opts = []gogrpc.DialOption{gogrpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	cc, err := gogrpc.NewClient(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcConn{cc: cc, c: vsgrpc.NewValueStoreClient(cc)}, nil
+}
+
+// grpcError returns err as the valuestore error its status code stands for,
+// or as a retryableError for the codes worth trying again.
+func grpcError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return valuestore.ErrNotFound
+	case codes.FailedPrecondition:
+		return valuestore.ErrNewerVersion
+	case codes.ResourceExhausted:
+		return &retryableError{valuestore.ErrDiskFull}
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		return &retryableError{err}
+	}
+	return err
+}
+
+func (c *grpcConn) read(ctx context.Context, keyA uint64, keyB uint64) (int64, []byte, error) {
+	stream, err := c.c.Read(ctx, &vsgrpc.ReadRequest{KeyA: keyA, KeyB: keyB})
+	if err != nil {
+		return 0, nil, grpcError(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return 0, nil, grpcError(err)
+	}
+	timestampmicro := resp.GetTimestampmicro()
+	value := make([]byte, 0, resp.GetLength())
+	for {
+		value = append(value, resp.GetValue()...)
+		if len(value) >= cap(value) {
+			return timestampmicro, value, nil
+		}
+		if resp, err = stream.Recv(); err != nil {
+			if err == io.EOF {
+				err = &retryableError{errors.New("value shorter than length")}
+			}
+			return 0, nil, grpcError(err)
+		}
+	}
+}
+
+func (c *grpcConn) lookup(ctx context.Context, keyA uint64, keyB uint64) (int64, uint32, error) {
+	resp, err := c.c.Lookup(ctx, &vsgrpc.LookupRequest{KeyA: keyA, KeyB: keyB})
+	if err != nil {
+		return 0, 0, grpcError(err)
+	}
+	return resp.GetTimestampmicro(), resp.GetLength(), nil
+}
+
+func (c *grpcConn) write(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
+	stream, err := c.c.Write(ctx)
+	if err != nil {
+		return 0, grpcError(err)
+	}
+	req := &vsgrpc.WriteRequest{KeyA: keyA, KeyB: keyB, Timestampmicro: timestampmicro, Length: uint32(len(value))}
+	for first := true; first || len(value) > 0; first = false {
+		chunk := value
+		if len(chunk) > _VALUE_CHUNK {
+			chunk = chunk[:_VALUE_CHUNK]
+		}
+		req.Value = chunk
+		// An error sending is the stream ending; CloseAndRecv gives the
+		// reason.
+		if stream.Send(req) != nil {
+			break
+		}
+		value = value[len(chunk):]
+		req = &vsgrpc.WriteRequest{}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, grpcError(err)
+	}
+	return resp.GetTimestampmicro(), nil
+}
+
+func (c *grpcConn) delete(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64) (int64, error) {
+	resp, err := c.c.Delete(ctx, &vsgrpc.DeleteRequest{KeyA: keyA, KeyB: keyB, Timestampmicro: timestampmicro})
+	if err != nil {
+		return 0, grpcError(err)
+	}
+	return resp.GetTimestampmicro(), nil
+}
+
+func (c *grpcConn) close() error {
+	return c.cc.Close()
+}
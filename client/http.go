@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pandemicsyn/valuestore"
+)
+
+// httpConn is a conn to a valuestore.NewHTTPHandler at baseURL.
+type httpConn struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newHTTPConn(baseURL string) *httpConn {
+	return &httpConn{baseURL: baseURL, client: &http.Client{}}
+}
+
+// do makes the request for the key, with timestampmicro as its
+// valuestore.HTTP_TIMESTAMP unless 0, and returns the response if its status
+// is 2xx; otherwise the error is the valuestore error the status stands for,
+// with the HTTP_TIMESTAMP given along with a 404, or a retryableError for
+// the statuses worth trying again.
+func (c *httpConn) do(ctx context.Context, method string, keyA uint64, keyB uint64, timestampmicro int64, body []byte) (*http.Response, int64, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.baseURL+"/values/"+strconv.FormatUint(keyA, 10)+"/"+strconv.FormatUint(keyB, 10), r)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	if timestampmicro != 0 {
+		req.Header.Set(valuestore.HTTP_TIMESTAMP, strconv.FormatInt(timestampmicro, 10))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, &retryableError{err}
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, 0, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	err = errors.New(strings.TrimSpace(string(msg)))
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		timestampmicro, _ = strconv.ParseInt(resp.Header.Get(valuestore.HTTP_TIMESTAMP), 10, 64)
+		return nil, timestampmicro, valuestore.ErrNotFound
+	case http.StatusConflict:
+		timestampmicro, _ = strconv.ParseInt(resp.Header.Get(valuestore.HTTP_PREVIOUS_TIMESTAMP), 10, 64)
+		return nil, timestampmicro, valuestore.ErrNewerVersion
+	case http.StatusInsufficientStorage:
+		return nil, 0, &retryableError{valuestore.ErrDiskFull}
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return nil, 0, &retryableError{err}
+	}
+	return nil, 0, err
+}
+
+func (c *httpConn) read(ctx context.Context, keyA uint64, keyB uint64) (int64, []byte, error) {
+	resp, timestampmicro, err := c.do(ctx, http.MethodGet, keyA, keyB, 0, nil)
+	if err != nil {
+		return timestampmicro, nil, err
+	}
+	defer resp.Body.Close()
+	timestampmicro, _ = strconv.ParseInt(resp.Header.Get(valuestore.HTTP_TIMESTAMP), 10, 64)
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, &retryableError{err}
+	}
+	return timestampmicro, value, nil
+}
+
+// lookup makes a HEAD, which the handler answers from Lookup without reading
+// the value.
+func (c *httpConn) lookup(ctx context.Context, keyA uint64, keyB uint64) (int64, uint32, error) {
+	resp, timestampmicro, err := c.do(ctx, http.MethodHead, keyA, keyB, 0, nil)
+	if err != nil {
+		return timestampmicro, 0, err
+	}
+	resp.Body.Close()
+	timestampmicro, _ = strconv.ParseInt(resp.Header.Get(valuestore.HTTP_TIMESTAMP), 10, 64)
+	return timestampmicro, uint32(resp.ContentLength), nil
+}
+
+func (c *httpConn) write(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
+	if value == nil {
+		value = []byte{}
+	}
+	resp, _, err := c.do(ctx, http.MethodPut, keyA, keyB, timestampmicro, value)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return strconv.ParseInt(resp.Header.Get(valuestore.HTTP_PREVIOUS_TIMESTAMP), 10, 64)
+}
+
+func (c *httpConn) delete(ctx context.Context, keyA uint64, keyB uint64, timestampmicro int64) (int64, error) {
+	resp, ptimestampmicro, err := c.do(ctx, http.MethodDelete, keyA, keyB, timestampmicro, nil)
+	if err != nil {
+		return ptimestampmicro, err
+	}
+	resp.Body.Close()
+	return strconv.ParseInt(resp.Header.Get(valuestore.HTTP_PREVIOUS_TIMESTAMP), 10, 64)
+}
+
+func (c *httpConn) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,101 @@
+package valuestore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// closeState tracks the goroutines Close has to stop, in two groups:
+//
+// The background goroutines, the launchers, the incoming message workers,
+// and anything else that runs periodically, return once backgroundChan is
+// closed; they may still be writing until then.
+//
+// The writers, the memWriters, memClearers, vfWriter, and tocWriter, return
+// once writersChan is closed, which is only done after the background
+// goroutines are gone and a final Flush has emptied them.
+type closeState struct {
+	closed         uint32
+	backgroundChan chan struct{}
+	backgroundWG   sync.WaitGroup
+	writersChan    chan struct{}
+	writersWG      sync.WaitGroup
+}
+
+func (vs *DefaultValueStore) closeConfig(cfg *Config) {
+	vs.closeState.backgroundChan = make(chan struct{})
+	vs.closeState.writersChan = make(chan struct{})
+}
+
+// Close shuts the store down so that the same paths may be opened again,
+// even within the same process: the background passes and workers are
+// stopped, with any pass in progress aborted; writes are disabled; buffered
+// data is flushed to disk, closing the values and TOC files being written;
+// the writers are stopped; the values file readers and the manifest are
+// closed; and the directory locks are released.
+//
+// Close does not stop the Config.MsgRing from delivering messages, so the
+// ring should be stopped, or given to a newly opened store, first. Once
+// Close is called, the store should not be used other than for Stats;
+// background controls such as EnableCompaction do nothing and writes fail.
+// Calling Close more than once is harmless.
+func (vs *DefaultValueStore) Close() {
+	if !atomic.CompareAndSwapUint32(&vs.closeState.closed, 0, 1) {
+		return
+	}
+	close(vs.closeState.backgroundChan)
+	atomic.StoreUint32(&vs.tombstoneDiscardState.abort, 1)
+	atomic.StoreUint32(&vs.compactionState.abort, 1)
+	atomic.StoreUint32(&vs.pullReplicationState.outAbort, 1)
+	atomic.StoreUint32(&vs.pushReplicationState.outAbort, 1)
+	vs.closeState.backgroundWG.Wait()
+	for _, doneChan := range vs.bulkSetState.inBulkSetDoneChans {
+		<-doneChan
+	}
+	for _, doneChan := range vs.bulkSetState.inPriorityDoneChans {
+		<-doneChan
+	}
+	for _, doneChan := range vs.bulkSetAckState.inBulkSetAckDoneChans {
+		<-doneChan
+	}
+	vs.DisableWrites()
+	vs.Flush()
+	close(vs.closeState.writersChan)
+	vs.closeState.writersWG.Wait()
+	vs.closeFiles()
+}
+
+// closeFiles closes the values file readers and the manifest and releases
+// the directory locks; it is the end of Close and what NewWithContext does
+// on error, as anything recovery opened is held through these.
+func (vs *DefaultValueStore) closeFiles() {
+	vs.readerCloseAll()
+	vs.manifestClose()
+	vs.dirUnlock()
+}
+
+// closeSleep sleeps for d, returning false early if Close has been called.
+func (vs *DefaultValueStore) closeSleep(d time.Duration) bool {
+	select {
+	case <-vs.closeState.backgroundChan:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// backgroundNotify gives the notification to a launcher through its
+// notifyChan and waits for it to be handled, unless Close is called first.
+func (vs *DefaultValueStore) backgroundNotify(notifyChan chan *backgroundNotification, notification *backgroundNotification) {
+	notification.doneChan = make(chan struct{}, 1)
+	select {
+	case notifyChan <- notification:
+	case <-vs.closeState.backgroundChan:
+		return
+	}
+	select {
+	case <-notification.doneChan:
+	case <-vs.closeState.backgroundChan:
+	}
+}
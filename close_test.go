@@ -0,0 +1,44 @@
+package valuestore
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	before := runtime.NumGoroutine()
+	vs := h.open()
+	vs.EnableAll()
+	h.workload(vs, 0, 500)
+	// These are left buffered for Close to flush.
+	for keyB := uint64(500); keyB < 600; keyB++ {
+		value := []byte("unflushed")
+		if _, err := vs.Write(1, keyB, int64(1000+keyB), value); err != nil {
+			t.Fatal(err)
+		}
+		h.values[keyB] = value
+	}
+	vs.Close()
+	vs.Close()
+	if _, err := vs.Write(1, 1, 2000, []byte("late")); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	vs.EnableCompaction()
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after Close, %d before New", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := vs.readersOpen(); n != 0 {
+		t.Fatalf("%d readers left open", n)
+	}
+	vs = h.open()
+	if readable := h.verify(vs); readable != len(h.values) {
+		t.Fatalf("%d of %d keys readable after reopening", readable, len(h.values))
+	}
+	vs.Close()
+}
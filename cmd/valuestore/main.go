@@ -0,0 +1,381 @@
+// Command valuestore is an admin tool for operators debugging a store's
+// on-disk state. It works on the store the -config file, -path, and -pathtoc
+// describe, with the environment overrides New applies; the commands opening
+// the store can't be used while another process has it open.
+//
+//	valuestore [flags] inspect [-entries] FILE|ID
+//	valuestore [flags] get KEYA KEYB
+//	valuestore [flags] set [-timestamp MICROS] KEYA KEYB [VALUE]
+//	valuestore [flags] delete [-timestamp MICROS] KEYA KEYB
+//	valuestore [flags] verify [-repair]
+//	valuestore [flags] compact [ID...]
+//	valuestore [flags] stats [-debug]
+//
+// inspect verifies one TOC file and its values file, given by either's name
+// or their ID, and with -entries lists the TOC entries; with no -path, the
+// file's directory is used. verify is valuestore.VerifyFiles over all the
+// files. get writes the value to stdout; set reads it from stdin if not
+// given. Keys are decimal, or hexadecimal with a 0x prefix, and timestamps
+// default to the current time. compact compacts the files with the IDs
+// given, or runs a compaction pass, then lists the files. The exit status is
+// 1 on any error, including problems found by inspect and verify.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gholt/brimtime.v1"
+
+	"github.com/pandemicsyn/valuestore"
+)
+
+var errUsage = errors.New("usage")
+
+const usage = `usage: valuestore [-config FILE] [-path DIR] [-pathtoc DIR] COMMAND [ARGS]
+commands:
+    inspect [-entries] FILE|ID
+    get KEYA KEYB
+    set [-timestamp MICROS] KEYA KEYB [VALUE]
+    delete [-timestamp MICROS] KEYA KEYB
+    verify [-repair]
+    compact [ID...]
+    stats [-debug]
+`
+
+type command struct {
+	name   string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	cfg    *valuestore.Config
+	// pathSet indicates the store's location came from the flags or config
+	// file rather than the defaults.
+	pathSet bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit status.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("valuestore", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	configName := flags.String("config", "", "")
+	path := flags.String("path", "", "")
+	pathtoc := flags.String("pathtoc", "", "")
+	if flags.Parse(args) != nil || flags.NArg() == 0 {
+		io.WriteString(stderr, usage)
+		return 2
+	}
+	c := &command{name: flags.Arg(0), stdin: stdin, stdout: stdout, stderr: stderr, cfg: &valuestore.Config{}}
+	if *configName != "" {
+		var err error
+		if c.cfg, err = valuestore.LoadConfig(*configName); err != nil {
+			fmt.Fprintf(stderr, "valuestore: %s\n", err)
+			return 1
+		}
+	}
+	if *path != "" {
+		c.cfg.Path = *path
+	}
+	if *pathtoc != "" {
+		c.cfg.PathTOC = *pathtoc
+	}
+	c.pathSet = c.cfg.Path != "" || c.cfg.PathTOC != "" || len(c.cfg.Paths) != 0
+	commands := map[string]func(args []string) error{
+		"inspect": c.inspect,
+		"get":     c.get,
+		"set":     c.set,
+		"delete":  c.delete,
+		"verify":  c.verify,
+		"compact": c.compact,
+		"stats":   c.stats,
+	}
+	f := commands[c.name]
+	if f == nil {
+		io.WriteString(stderr, usage)
+		return 2
+	}
+	if err := f(flags.Args()[1:]); err != nil {
+		if err == errUsage {
+			io.WriteString(stderr, usage)
+			return 2
+		}
+		fmt.Fprintf(stderr, "valuestore %s: %s\n", c.name, err)
+		return 1
+	}
+	return 0
+}
+
+// parse parses the command's flags from args and returns the rest, which
+// must number from min to max, max < 0 for no limit.
+func (c *command) parse(flags *flag.FlagSet, args []string, min int, max int) ([]string, error) {
+	flags.SetOutput(ioutil.Discard)
+	if flags.Parse(args) != nil || flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		return nil, errUsage
+	}
+	return flags.Args(), nil
+}
+
+func (c *command) open() (*valuestore.DefaultValueStore, error) {
+	return valuestore.NewWithContext(context.Background(), c.cfg)
+}
+
+func parseKeys(args []string) (uint64, uint64, error) {
+	var keys [2]uint64
+	for i, s := range args[:2] {
+		var err error
+		if strings.HasPrefix(s, "0x") {
+			keys[i], err = strconv.ParseUint(s[2:], 16, 64)
+		} else {
+			keys[i], err = strconv.ParseUint(s, 10, 64)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid key %q", s)
+		}
+	}
+	return keys[0], keys[1], nil
+}
+
+func (c *command) inspect(args []string) error {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	entries := flags.Bool("entries", false, "")
+	args, err := c.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(args[0])
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".values"), ".valuestoc")
+	id, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid file name or ID %q", args[0])
+	}
+	if !c.pathSet && filepath.Base(args[0]) != args[0] {
+		c.cfg.Path = filepath.Dir(args[0])
+	}
+	var each func(e *valuestore.TOCEntry)
+	if *entries {
+		each = func(e *valuestore.TOCEntry) {
+			if e.Timestampmicro == 0 {
+				fmt.Fprintf(c.stdout, "batch marker\n")
+				return
+			}
+			var notes string
+			for _, n := range []struct {
+				set  bool
+				note string
+			}{{e.Deletion, " deletion"}, {e.Orphaned, " orphaned"}, {e.BadLength, " bad-length"}} {
+				if n.set {
+					notes += n.note
+				}
+			}
+			fmt.Fprintf(c.stdout, "%016x %016x %d offset %d length %d%s\n", e.KeyA, e.KeyB, e.Timestampmicro, e.Offset, e.Length, notes)
+		}
+	}
+	f, err := valuestore.InspectFile(c.cfg, id, each)
+	if err == valuestore.ErrNotFound {
+		return fmt.Errorf("no TOC file with ID %d", id)
+	}
+	if err != nil {
+		return err
+	}
+	c.printFileReport(f, true)
+	if problems := f.Problems(); problems != 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
+
+// printFileReport writes the report's problems, or with all every field.
+func (c *command) printFileReport(f *valuestore.VerifyFileReport, all bool) {
+	fmt.Fprintf(c.stdout, "%s: %d entries, %d problems\n", f.TOCPath, f.Entries, f.Problems())
+	for _, l := range []struct {
+		show  bool
+		label string
+		value interface{}
+	}{
+		{all, "values file", f.ValuesPath},
+		{f.Err != nil, "error", f.Err},
+		{all || f.ValuesFileMissing, "values file missing", f.ValuesFileMissing},
+		{all || f.ValuesFileDirty, "values file dirty", f.ValuesFileDirty},
+		{all || f.ValuesFileDirty, "values file trusted to", f.ValuesFileTrusted},
+		{all || f.BadHeader, "bad header", f.BadHeader},
+		{all || f.ChecksumFailures != 0, "checksum failures", f.ChecksumFailures},
+		{all || f.Truncated, "truncated", f.Truncated},
+		{all || f.Orphaned != 0, "orphaned entries", f.Orphaned},
+		{all || f.BadLengths != 0, "bad lengths", f.BadLengths},
+		{f.RepairedAt != 0, "repaired at", f.RepairedAt},
+	} {
+		if l.show {
+			fmt.Fprintf(c.stdout, "    %s: %v\n", l.label, l.value)
+		}
+	}
+}
+
+func (c *command) get(args []string) error {
+	args, err := c.parse(flag.NewFlagSet(c.name, flag.ContinueOnError), args, 2, 2)
+	if err != nil {
+		return err
+	}
+	keyA, keyB, err := parseKeys(args)
+	if err != nil {
+		return err
+	}
+	vs, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	timestampmicro, value, err := vs.Read(keyA, keyB, nil)
+	if err == valuestore.ErrNotFound && timestampmicro != 0 {
+		return fmt.Errorf("deleted at timestampmicro %d", timestampmicro)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "timestampmicro %d\n", timestampmicro)
+	_, err = c.stdout.Write(value)
+	return err
+}
+
+// timestampFlag adds the -timestamp flag to flags, defaulting to now.
+func timestampFlag(flags *flag.FlagSet) *int64 {
+	return flags.Int64("timestamp", brimtime.TimeToUnixMicro(time.Now()), "")
+}
+
+func (c *command) set(args []string) error {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	timestampmicro := timestampFlag(flags)
+	args, err := c.parse(flags, args, 2, 3)
+	if err != nil {
+		return err
+	}
+	keyA, keyB, err := parseKeys(args)
+	if err != nil {
+		return err
+	}
+	var value []byte
+	if len(args) == 3 {
+		value = []byte(args[2])
+	} else if value, err = ioutil.ReadAll(c.stdin); err != nil {
+		return err
+	}
+	vs, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	vs.EnableWrites()
+	ptimestampmicro, err := vs.Write(keyA, keyB, *timestampmicro, value)
+	if err != nil {
+		return err
+	}
+	if ptimestampmicro >= *timestampmicro {
+		fmt.Fprintf(c.stderr, "timestampmicro %d already stored; not written\n", ptimestampmicro)
+	}
+	return nil
+}
+
+func (c *command) delete(args []string) error {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	timestampmicro := timestampFlag(flags)
+	args, err := c.parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	keyA, keyB, err := parseKeys(args)
+	if err != nil {
+		return err
+	}
+	vs, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	vs.EnableWrites()
+	ptimestampmicro, err := vs.Delete(keyA, keyB, *timestampmicro)
+	if err == valuestore.ErrNewerVersion {
+		return fmt.Errorf("timestampmicro %d already stored; not deleted", ptimestampmicro)
+	}
+	return err
+}
+
+func (c *command) verify(args []string) error {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "")
+	if _, err := c.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+	report, err := valuestore.VerifyFiles(c.cfg, *repair)
+	if err != nil {
+		return err
+	}
+	for _, f := range report.Files {
+		c.printFileReport(f, false)
+	}
+	for _, name := range report.OrphanValuesFiles {
+		fmt.Fprintf(c.stdout, "%s: no TOC file\n", name)
+	}
+	if problems := report.Problems(); problems != 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
+
+func (c *command) compact(args []string) error {
+	args, err := c.parse(flag.NewFlagSet(c.name, flag.ContinueOnError), args, 0, -1)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, len(args))
+	for i, s := range args {
+		if ids[i], err = strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("invalid ID %q", s)
+		}
+	}
+	vs, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	vs.EnableWrites()
+	if len(ids) == 0 {
+		vs.CompactionPass()
+	}
+	for _, id := range ids {
+		if err = vs.CompactFile(id); err != nil {
+			return fmt.Errorf("%d: %s", id, err)
+		}
+	}
+	vs.Flush()
+	for _, f := range vs.ListFiles() {
+		fmt.Fprintf(c.stdout, "%d %s\n", f.ID, f.String())
+	}
+	return nil
+}
+
+func (c *command) stats(args []string) error {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	debug := flags.Bool("debug", false, "")
+	if _, err := c.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+	vs, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	_, err = io.WriteString(c.stdout, vs.Stats(*debug).String())
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestorecmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	do := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(append([]string{"-path", dir}, args...), strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+	if code, _, stderr := do("", "set", "-timestamp", "1000", "1", "0x2", "value"); code != 0 {
+		t.Fatal(code, stderr)
+	}
+	if code, _, stderr := do("from stdin", "set", "-timestamp", "1000", "3", "4"); code != 0 {
+		t.Fatal(code, stderr)
+	}
+	if code, stdout, stderr := do("", "get", "0x1", "2"); code != 0 || stdout != "value" || stderr != "timestampmicro 1000\n" {
+		t.Fatal(code, stdout, stderr)
+	}
+	if code, stdout, stderr := do("", "get", "3", "4"); code != 0 || stdout != "from stdin" {
+		t.Fatal(code, stdout, stderr)
+	}
+	if code, _, stderr := do("", "set", "-timestamp", "500", "1", "2", "older"); code != 0 || !strings.Contains(stderr, "not written") {
+		t.Fatal(code, stderr)
+	}
+	if code, _, stderr := do("", "delete", "-timestamp", "500", "1", "2"); code != 1 || !strings.Contains(stderr, "not deleted") {
+		t.Fatal(code, stderr)
+	}
+	if code, _, stderr := do("", "delete", "-timestamp", "2000", "1", "2"); code != 0 {
+		t.Fatal(code, stderr)
+	}
+	if code, _, stderr := do("", "get", "1", "2"); code != 1 || !strings.Contains(stderr, "deleted at timestampmicro 2000") {
+		t.Fatal(code, stderr)
+	}
+	if code, _, stderr := do("", "get", "5", "6"); code != 1 || !strings.Contains(stderr, "not found") {
+		t.Fatal(code, stderr)
+	}
+	if code, stdout, stderr := do("", "verify"); code != 0 || !strings.Contains(stdout, "0 problems") {
+		t.Fatal(code, stdout, stderr)
+	}
+	tocs, err := filepath.Glob(filepath.Join(dir, "*.valuestoc"))
+	if err != nil || len(tocs) == 0 {
+		t.Fatal(tocs, err)
+	}
+	// Without -path, the file's directory is used.
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"inspect", "-entries", tocs[0]}, nil, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "values file: ") || !strings.Contains(stdout.String(), "0000000000000001 0000000000000002 1000 ") {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+	if code, stdout, stderr := do("", "stats"); code != 0 || !strings.Contains(stdout, "Values") {
+		t.Fatal(code, stdout, stderr)
+	}
+	if code, stdout, stderr := do("", "compact"); code != 0 || stdout == "" {
+		t.Fatal(code, stdout, stderr)
+	}
+	for _, args := range [][]string{nil, {"unknown"}, {"get", "1"}, {"verify", "extra"}, {"stats", "-unknown"}} {
+		if code, _, _ := do("", args...); code != 2 {
+			t.Fatal(args, code)
+		}
+	}
+	if code, _, stderr := do("", "get", "1", "x"); code != 1 || !strings.Contains(stderr, "invalid key") {
+		t.Fatal(code, stderr)
+	}
+}
@@ -4,37 +4,113 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"os"
 	"path"
-	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
-
-	"github.com/spaolacci/murmur3"
 )
 
 type compactionState struct {
-	interval     int
+	// interval and threshold may be changed while running, so are accessed
+	// atomically; threshold holds the math.Float64bits of the float64.
+	interval     int64
 	workerCount  int
 	ageThreshold int64
 	abort        uint32
-	threshold    float64
+	threshold    uint64
 	notifyChan   chan *backgroundNotification
+	// dropPageCache is set to have the OS drop the cached pages of the files
+	// compaction reads through.
+	dropPageCache bool
+	// recompress is set to have files written with another codec compacted
+	// regardless of how stale they are.
+	recompress bool
+	// reencrypt is set to have files written with another encryption key
+	// compacted regardless of how stale they are.
+	reencrypt bool
+	// waste holds, by values file timestamp, the fraction of its entries
+	// found stale by the latest sample of it; see ValuesFileStats.
+	wasteLock sync.Mutex
+	waste     map[int64]float64
+	// bytesPerSec may be changed while running, so is accessed atomically;
+	// tokens is the token bucket, shared by the workers, that enforces it.
+	bytesPerSec int64
+	tokensLock  sync.Mutex
+	tokens      float64
+	tokensLast  time.Time
+	filter      CompactionFilterFunc
 }
 
 func (vs *DefaultValueStore) compactionConfig(cfg *Config) {
-	vs.compactionState.interval = cfg.CompactionInterval
-	vs.compactionState.threshold = cfg.CompactionThreshold
+	vs.compactionState.interval = int64(cfg.CompactionInterval)
+	vs.compactionState.threshold = math.Float64bits(cfg.CompactionThreshold)
+	vs.compactionState.dropPageCache = cfg.CompactionDropPageCache
+	vs.compactionState.recompress = cfg.CompactionRecompress
+	vs.compactionState.reencrypt = cfg.CompactionReencrypt
+	vs.compactionState.waste = make(map[int64]float64)
 	vs.compactionState.ageThreshold = int64(cfg.CompactionAgeThreshold * 1000000000)
 	vs.compactionState.notifyChan = make(chan *backgroundNotification, 1)
 	vs.compactionState.workerCount = cfg.CompactionWorkers
+	vs.compactionState.bytesPerSec = int64(cfg.CompactionMaxBytesPerSec)
+	vs.compactionState.tokens = float64(cfg.CompactionMaxBytesPerSec)
+	vs.compactionState.tokensLast = time.Now()
+	vs.compactionState.filter = cfg.CompactionFilter
+}
+
+// compactionFilter returns true if Config.CompactionFilter has the live entry
+// dropped rather than rewritten, having replaced it with a local removal.
+func (vs *DefaultValueStore) compactionFilter(keyA uint64, keyB uint64, timestampbits uint64, value []byte) (bool, error) {
+	if vs.compactionState.filter == nil || vs.compactionState.filter(keyA, keyB, int64(timestampbits>>_TSB_UTIL_BITS), value) {
+		return false, nil
+	}
+	if _, err := vs.write(keyA, keyB, timestampbits|_TSB_LOCAL_REMOVAL, nil); err != nil {
+		return false, err
+	}
+	atomic.AddInt32(&vs.compactionFiltered, 1)
+	return true, nil
+}
+
+// compactionThrottle is called with the length of each value compaction
+// rewrites and waits as needed to stay within CompactionMaxBytesPerSec. A
+// value larger than the budget left is let through, the wait coming before
+// the next one instead.
+func (vs *DefaultValueStore) compactionThrottle(length int) {
+	bytesPerSec := float64(atomic.LoadInt64(&vs.compactionState.bytesPerSec))
+	if bytesPerSec <= 0 {
+		return
+	}
+	s := &vs.compactionState
+	s.tokensLock.Lock()
+	now := time.Now()
+	s.tokens += now.Sub(s.tokensLast).Seconds() * bytesPerSec
+	if s.tokens > bytesPerSec {
+		s.tokens = bytesPerSec
+	}
+	s.tokensLast = now
+	var wait time.Duration
+	if s.tokens <= 0 {
+		wait = time.Duration(-s.tokens / bytesPerSec * float64(time.Second))
+	}
+	s.tokens -= float64(length)
+	s.tokensLock.Unlock()
+	if wait > 0 {
+		atomic.AddInt32(&vs.compactionThrottles, 1)
+		select {
+		case <-time.After(wait):
+		case <-vs.closeState.backgroundChan:
+		}
+	}
 }
 
 func (vs *DefaultValueStore) compactionLaunch() {
+	vs.closeState.backgroundWG.Add(1)
 	go vs.compactionLauncher()
 }
 
@@ -42,37 +118,55 @@ func (vs *DefaultValueStore) compactionLaunch() {
 // EnableCompaction is called. A compaction pass searches for files
 // with a percentage of XX deleted entries.
 func (vs *DefaultValueStore) DisableCompaction() {
-	c := make(chan struct{}, 1)
-	vs.compactionState.notifyChan <- &backgroundNotification{
-		disable:  true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.compactionState.notifyChan, &backgroundNotification{disable: true})
 }
 
 // EnableCompaction will resume compaction passes.
 // A compaction pass searches for files with a percentage of XX deleted
 // entries.
 func (vs *DefaultValueStore) EnableCompaction() {
-	c := make(chan struct{}, 1)
-	vs.compactionState.notifyChan <- &backgroundNotification{
-		enable:   true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.compactionState.notifyChan, &backgroundNotification{enable: true})
 }
 
 // CompactionPass will immediately execute a compaction pass to compact stale files.
 func (vs *DefaultValueStore) CompactionPass() {
 	atomic.StoreUint32(&vs.compactionState.abort, 1)
-	c := make(chan struct{}, 1)
-	vs.compactionState.notifyChan <- &backgroundNotification{doneChan: c}
-	<-c
+	vs.backgroundNotify(vs.compactionState.notifyChan, &backgroundNotification{})
+}
+
+// MigrationPass will immediately execute a compaction pass that also
+// compacts, however fresh their contents, the files not in the format new
+// files are written in: values files with another codec, encryption key, or
+// checksum interval, and TOC files of the other version; see _TOC_HEADER_V0.
+// This rolls format changes, made by changing the Config and restarting, out
+// over the existing files. Files younger than Config.CompactionAgeThreshold
+// are still left alone.
+func (vs *DefaultValueStore) MigrationPass() {
+	atomic.StoreUint32(&vs.compactionState.abort, 1)
+	vs.backgroundNotify(vs.compactionState.notifyChan, &backgroundNotification{migrate: true})
+}
+
+// CompactFile will immediately compact the values file with the given ID, as
+// given by ListFiles, however fresh its contents, rather than waiting for a
+// compaction pass to find it worth doing; even while compaction is disabled.
+// ErrFileInUse is returned for a file still being written or pinned by a
+// Snapshot.
+func (vs *DefaultValueStore) CompactFile(id int64) error {
+	notification := &backgroundNotification{compactFile: id, err: ErrClosed}
+	vs.backgroundNotify(vs.compactionState.notifyChan, notification)
+	return notification.err
+}
+
+// ListFiles returns the stats for each values file, oldest first, the same as
+// Stats gives in ValuesFiles.
+func (vs *DefaultValueStore) ListFiles() []ValuesFileStats {
+	return vs.valuesFileStats()
 }
 
 func (vs *DefaultValueStore) compactionLauncher() {
+	defer vs.closeState.backgroundWG.Done()
 	var enabled bool
-	interval := float64(vs.compactionState.interval) * float64(time.Second)
+	interval := float64(atomic.LoadInt64(&vs.compactionState.interval)) * float64(time.Second)
 	vs.randMutex.Lock()
 	nextRun := time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 	vs.randMutex.Unlock()
@@ -83,13 +177,18 @@ func (vs *DefaultValueStore) compactionLauncher() {
 			select {
 			case notification = <-vs.compactionState.notifyChan:
 			case <-time.After(sleep):
+			case <-vs.closeState.backgroundChan:
+				return
 			}
 		} else {
 			select {
 			case notification = <-vs.compactionState.notifyChan:
+			case <-vs.closeState.backgroundChan:
+				return
 			default:
 			}
 		}
+		interval = float64(atomic.LoadInt64(&vs.compactionState.interval)) * float64(time.Second)
 		vs.randMutex.Lock()
 		nextRun = time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 		vs.randMutex.Unlock()
@@ -105,12 +204,17 @@ func (vs *DefaultValueStore) compactionLauncher() {
 				notification.doneChan <- struct{}{}
 				continue
 			}
+			if notification.compactFile != 0 {
+				notification.err = vs.compactionFile(notification.compactFile)
+				notification.doneChan <- struct{}{}
+				continue
+			}
 			atomic.StoreUint32(&vs.compactionState.abort, 0)
-			vs.compactionPass()
+			vs.compactionPass(notification.migrate)
 			notification.doneChan <- struct{}{}
 		} else if enabled {
 			atomic.StoreUint32(&vs.compactionState.abort, 0)
-			vs.compactionPass()
+			vs.compactionPass(false)
 		}
 	}
 }
@@ -118,25 +222,24 @@ func (vs *DefaultValueStore) compactionLauncher() {
 type compactionJob struct {
 	name             string
 	candidateBlockID uint32
+	namets           int64
+	migrate          bool
+	// merge holds more small files to compact along with this one, so that
+	// their live entries are rewritten together; see compactionMergeable.
+	merge []compactionJob
 }
 
-func (vs *DefaultValueStore) compactionPass() {
+func (vs *DefaultValueStore) compactionPass(migrate bool) {
 	if vs.logDebug != nil {
 		begin := time.Now()
 		defer func() {
 			vs.logDebug("compaction pass took %s\n", time.Now().Sub(begin))
 		}()
 	}
-	fp, err := os.Open(vs.pathtoc)
-	if err != nil {
-		panic(err)
-	}
-	names, err := fp.Readdirnames(-1)
-	fp.Close()
+	names, err := vs.tocNames()
 	if err != nil {
 		panic(err)
 	}
-	sort.Strings(names)
 
 	compactionJobs := make(chan compactionJob, len(names))
 	compactionResults := make(chan string, len(names))
@@ -148,12 +251,34 @@ func (vs *DefaultValueStore) compactionPass() {
 	}
 
 	submitted := 0
+	var merge *compactionJob
+	var mergeBytes uint64
 	for i := 0; i < len(names); i++ {
-		namets, valid := vs.compactionCandidate(path.Join(vs.pathtoc, names[i]))
-		if valid {
-			compactionJobs <- compactionJob{path.Join(vs.pathtoc, names[i]), vs.valueLocBlockIDFromTimestampnano(namets)}
-			submitted++
+		namets, valid := vs.compactionCandidate(names[i])
+		if !valid {
+			continue
 		}
+		c := compactionJob{names[i], vs.valueLocBlockIDFromTimestampnano(namets), namets, migrate, nil}
+		if bytes, ok := vs.compactionMergeable(c); ok {
+			if merge != nil && mergeBytes+bytes <= vs.valuesFileCap {
+				merge.merge = append(merge.merge, c)
+				mergeBytes += bytes
+				continue
+			}
+			if merge != nil {
+				compactionJobs <- *merge
+				submitted++
+			}
+			merge = &c
+			mergeBytes = bytes
+			continue
+		}
+		compactionJobs <- c
+		submitted++
+	}
+	if merge != nil {
+		compactionJobs <- *merge
+		submitted++
 	}
 	close(compactionJobs)
 	if vs.logDebug != nil {
@@ -165,6 +290,147 @@ func (vs *DefaultValueStore) compactionPass() {
 	close(compactionResults)
 }
 
+// compactionMergeable returns whether the file would be compacted just for
+// being small, with fewer than 100 entries, along with the size of its
+// values file. Such files are grouped, up to ValuesFileCap of them at a time,
+// so that each group's live entries are rewritten into one values file
+// rather than into as many small files again. A group of one is left to
+// compactionRun to judge by its stale entries, as rewriting it would only
+// make another small file, itself found small on the next pass.
+func (vs *DefaultValueStore) compactionMergeable(c compactionJob) (uint64, bool) {
+	fi, err := os.Stat(c.name)
+	if err != nil || fi.Size()/34 >= 100 {
+		return 0, false
+	}
+	if vs.compactionState.recompress && vs.compactionRecompress(c.candidateBlockID) || vs.compactionState.reencrypt && vs.compactionReencrypt(c.candidateBlockID) || c.migrate && vs.compactionMigrate(c.name, c.candidateBlockID) {
+		return 0, false
+	}
+	fi, err = os.Stat(vs.valuesName(c.namets))
+	if err != nil {
+		return 0, false
+	}
+	return uint64(fi.Size()), true
+}
+
+// compactionRecompress returns true if the values file was written with a
+// codec other than the configured Compression.
+func (vs *DefaultValueStore) compactionRecompress(blockID uint32) bool {
+	vf, ok := vs.valueLocBlock(blockID).(*valuesFile)
+	return ok && vf.codec != vs.compressionState.codec
+}
+
+// compactionReencrypt returns true if the values file was written with an
+// encryption key other than the configured EncryptionKey.
+func (vs *DefaultValueStore) compactionReencrypt(blockID uint32) bool {
+	vf, ok := vs.valueLocBlock(blockID).(*valuesFile)
+	return ok && vf.keyID != vs.encryptionState.keyID
+}
+
+// compactionFile is CompactFile once in the launcher, so it can't overlap a
+// compaction pass.
+func (vs *DefaultValueStore) compactionFile(namets int64) error {
+	name := vs.tocName(namets)
+	if _, err := os.Stat(name); err != nil {
+		return err
+	}
+	if namets == int64(atomic.LoadUint64(&vs.activeTOCA)) || namets == int64(atomic.LoadUint64(&vs.activeTOCB)) || vs.snapshotPinned(namets) || vs.writeStreaming(namets) {
+		return ErrFileInUse
+	}
+	atomic.StoreUint32(&vs.compactionState.abort, 0)
+	atomic.AddInt32(&vs.fileCompactions, 1)
+	results, errs := vs.compactionSwap([]compactionJob{{name: name, candidateBlockID: vs.valueLocBlockIDFromTimestampnano(namets), namets: namets}})
+	if errs[0] != nil {
+		return errs[0]
+	}
+	if vs.logDebug != nil {
+		vs.logDebug("Compacted %s: (total %d, rewrote %d, stale %d)\n", name, results[0].count, results[0].rewrote, results[0].stale)
+	}
+	return nil
+}
+
+// compactionSwap compacts the given files so that a crash at any point
+// leaves their entries either in them or in their rewrites, not lost between
+// the two: compactFile rewrites what is still needed, the rewrites are
+// flushed and fsynced, and only then are the swaps recorded in the manifest
+// and the old files removed. Until its swap is recorded, recovery rolls a
+// file's compaction back, keeping the old files; any rewrites that made it
+// to disk are then duplicates, which it already resolves. Files compacted
+// together share the one flush, so their rewrites end up in the same values
+// file. The results and errors are in the order of the files given.
+func (vs *DefaultValueStore) compactionSwap(jobs []compactionJob) ([]compactionResult, []error) {
+	results := make([]compactionResult, len(jobs))
+	errs := make([]error, len(jobs))
+	for _, c := range jobs {
+		vs.manifestAppend(_MANIFEST_COMPACT_BEGIN, c.namets)
+	}
+	compacted := 0
+	for i, c := range jobs {
+		results[i], errs[i] = vs.compactFile(c.name, c.candidateBlockID)
+		if errs[i] == nil && results[i].rewrote+results[i].stale != results[i].count {
+			errs[i] = fmt.Errorf("compaction of %s stopped after %d of %d entries", c.name, results[i].rewrote+results[i].stale, results[i].count)
+		}
+		if errs[i] == nil {
+			compacted++
+		}
+	}
+	var err error
+	if compacted > 0 {
+		atomic.AddInt32(&vs.syncPolicyState.durable, 1)
+		err = vs.syncWait()
+		atomic.AddInt32(&vs.syncPolicyState.durable, -1)
+		vs.failpoint(_FAILPOINT_COMPACTION_REWRITTEN)
+	}
+	for i, c := range jobs {
+		if errs[i] == nil {
+			errs[i] = err
+		}
+		if errs[i] != nil {
+			vs.manifestAppend(_MANIFEST_CREATE, c.namets)
+		} else {
+			vs.manifestAppend(_MANIFEST_COMPACT, c.namets)
+		}
+	}
+	vs.manifestSync()
+	for i, c := range jobs {
+		if errs[i] != nil {
+			continue
+		}
+		if errs[i] = os.Remove(c.name); errs[i] == nil {
+			vs.failpoint(_FAILPOINT_COMPACTION_REMOVE)
+			errs[i] = vs.fileBackend.Remove(vs.valuesName(c.namets))
+		}
+		if errs[i] == nil {
+			vs.pathsForget(c.namets)
+		}
+	}
+	return results, errs
+}
+
+// compactionMigrate returns true if the values file, or its TOC file at
+// name, is not in the format new files are written in.
+func (vs *DefaultValueStore) compactionMigrate(name string, blockID uint32) bool {
+	vf, ok := vs.valueLocBlock(blockID).(*valuesFile)
+	if !ok {
+		return false
+	}
+	if vf.codec != vs.compressionState.codec || vf.keyID != vs.encryptionState.keyID || vf.checksumInterval != vs.checksumInterval {
+		return true
+	}
+	fp, err := vs.openFile(name)
+	if err != nil {
+		vs.logError("error opening %s: %s\n", name, err)
+		return false
+	}
+	head := make([]byte, 32)
+	_, err = io.ReadFull(fp, head)
+	fp.Close()
+	if err != nil {
+		vs.logError("error reading %s: %s\n", name, err)
+		return false
+	}
+	return tocEntrySize(head) != vs.tocEntrySize
+}
+
 // compactionCandidate verifies that the given toc is a valid candidate for
 // compaction and also returns the extracted namets.
 // TODO: This doesn't need to be its own func anymore
@@ -189,97 +455,132 @@ func (vs *DefaultValueStore) compactionCandidate(name string) (int64, bool) {
 	if namets >= time.Now().UnixNano()-vs.compactionState.ageThreshold {
 		return namets, false
 	}
+	if vs.snapshotPinned(namets) {
+		return namets, false
+	}
+	if vs.writeStreaming(namets) {
+		return namets, false
+	}
 	return namets, true
 }
 
 func (vs *DefaultValueStore) compactionWorker(id int, tocfiles <-chan compactionJob, result chan<- string) {
 	for c := range tocfiles {
-		fstat, err := os.Stat(c.name)
-		if err != nil {
-			vs.logError("Unable to stat %s because: %v\n", c.name, err)
-			continue
+		vs.compactionRun(c)
+		result <- c.name
+	}
+}
+
+// compactionRun compacts the job's file, or group of files, if it is worth
+// doing.
+func (vs *DefaultValueStore) compactionRun(c compactionJob) {
+	if len(c.merge) > 0 {
+		jobs := append([]compactionJob{c}, c.merge...)
+		atomic.AddInt32(&vs.mergedCompactions, 1)
+		atomic.AddInt32(&vs.smallFileCompactions, int32(len(jobs)))
+		results, errs := vs.compactionSwap(jobs)
+		for i, job := range jobs {
+			vs.compactionDone(job.name, results[i], errs[i])
 		}
-		total := int(fstat.Size()) / 34
-		if total < 100 {
-			atomic.AddInt32(&vs.smallFileCompactions, 1)
-			result, err := vs.compactFile(c.name, c.candidateBlockID)
-			if err != nil {
-				vs.logCritical("%s\n", err)
-			}
-			if (result.rewrote + result.stale) == result.count {
-				err = os.Remove(c.name)
-				if err != nil {
-					vs.logCritical("Unable to remove %s %s\n", c.name, err)
-					continue
-				}
-				err = os.Remove(c.name[:len(c.name)-len("toc")])
-				if err != nil {
-					vs.logCritical("Unable to remove %s values %s\n", c.name, err)
-					continue
-				}
-				if vs.logDebug != nil {
-					vs.logDebug("Compacted %s (total %d, rewrote %d, stale %d)\n", c.name, result.count, result.rewrote, result.stale)
-				}
-			}
+		return
+	}
+	fstat, err := os.Stat(c.name)
+	if err != nil {
+		vs.logError("Unable to stat %s because: %v\n", c.name, err)
+		return
+	}
+	total := int(fstat.Size()) / 34
+	recompress := vs.compactionState.recompress && vs.compactionRecompress(c.candidateBlockID)
+	reencrypt := vs.compactionState.reencrypt && vs.compactionReencrypt(c.candidateBlockID)
+	migrate := c.migrate && !recompress && !reencrypt && vs.compactionMigrate(c.name, c.candidateBlockID)
+	if recompress || reencrypt || migrate {
+		if reencrypt {
+			atomic.AddInt32(&vs.reencryptionCompactions, 1)
+		} else if recompress {
+			atomic.AddInt32(&vs.recompressionCompactions, 1)
 		} else {
-			rand.Seed(time.Now().UnixNano())
-			skipOffset := rand.Intn(int(float64(total) * 0.01)) //randomly skip up to the first 1% of entries
-			skipTotal := total - skipOffset
-			staleTarget := int(float64(skipTotal) * vs.compactionState.threshold)
-			skip := skipTotal/staleTarget - 1
-			count, stale, err := vs.sampleTOC(c.name, c.candidateBlockID, skipOffset, skip)
-			if err != nil {
-				continue
-			}
-			if vs.logDebug != nil {
-				vs.logDebug("%s sample result: %d %d %d\n", c.name, count, stale, staleTarget)
-			}
-			if stale >= staleTarget {
-				atomic.AddInt32(&vs.compactions, 1)
-				if vs.logDebug != nil {
-					vs.logDebug("Triggering compaction for %s with %d entries.\n", c.name, count)
-				}
-				result, err := vs.compactFile(c.name, c.candidateBlockID)
-				if err != nil {
-					vs.logCritical("%s\n", err)
-				}
-				if (result.rewrote + result.stale) == result.count {
-					err = os.Remove(c.name)
-					if err != nil {
-						vs.logCritical("Unable to remove %s %s\n", c.name, err)
-						continue
-					}
-					err = os.Remove(c.name[:len(c.name)-len("toc")])
-					if err != nil {
-						vs.logCritical("Unable to remove %s values %s\n", c.name, err)
-						continue
-					}
-					if vs.logDebug != nil {
-						vs.logDebug("Compacted %s: (total %d, rewrote %d, stale %d)\n", c.name, result.count, result.rewrote, result.stale)
-					}
-				}
-			}
+			atomic.AddInt32(&vs.migrationCompactions, 1)
 		}
-		result <- c.name
+		results, errs := vs.compactionSwap([]compactionJob{c})
+		vs.compactionDone(c.name, results[0], errs[0])
+		return
+	}
+	// A small file with no others to merge with is sampled whole.
+	skipOffset := 0
+	if total >= 100 {
+		rand.Seed(time.Now().UnixNano())
+		skipOffset = rand.Intn(int(float64(total) * 0.01)) //randomly skip up to the first 1% of entries
+	}
+	skipTotal := total - skipOffset
+	staleTarget := int(float64(skipTotal) * math.Float64frombits(atomic.LoadUint64(&vs.compactionState.threshold)))
+	if staleTarget < 1 {
+		staleTarget = 1
+	}
+	skip := skipTotal/staleTarget - 1
+	count, stale, err := vs.sampleTOC(c.name, c.candidateBlockID, skipOffset, skip)
+	if err != nil {
+		return
+	}
+	if sampled := (count - skipOffset) / (skip + 1); sampled > 0 {
+		vs.compactionState.wasteLock.Lock()
+		vs.compactionState.waste[c.namets] = float64(stale) / float64(sampled)
+		vs.compactionState.wasteLock.Unlock()
+	}
+	if vs.logDebug != nil {
+		vs.logDebug("%s sample result: %d %d %d\n", c.name, count, stale, staleTarget)
+	}
+	if stale >= staleTarget {
+		atomic.AddInt32(&vs.compactions, 1)
+		if vs.logDebug != nil {
+			vs.logDebug("Triggering compaction for %s with %d entries.\n", c.name, count)
+		}
+		results, errs := vs.compactionSwap([]compactionJob{c})
+		vs.compactionDone(c.name, results[0], errs[0])
+	}
+}
+
+// compactionDone logs how compacting the file at name went.
+func (vs *DefaultValueStore) compactionDone(name string, result compactionResult, err error) {
+	if err != nil {
+		if err != ErrClosed {
+			vs.logCritical("Unable to compact %s %s\n", name, err)
+		}
+		return
+	}
+	if vs.logDebug != nil {
+		vs.logDebug("Compacted %s (total %d, rewrote %d, stale %d)\n", name, result.count, result.rewrote, result.stale)
 	}
 }
 
+// sampleTOC and compactFile count batch markers, see _TSB_BATCH_BEGIN, as
+// stale entries; they are only needed until their batch's values file is
+// compacted away.
 func (vs *DefaultValueStore) sampleTOC(name string, candidateBlockID uint32, skipOffset, skipCount int) (int, int, error) {
 	count := 0
 	stale := 0
-	fromDiskBuf := make([]byte, vs.checksumInterval+4)
-	fromDiskOverflow := make([]byte, 0, 32)
-	fp, err := os.Open(name)
+	fromDiskOverflow := make([]byte, 0, _TOC_ENTRY_SIZE_MAX)
+	fp, err := vs.openFile(name)
 	if err != nil {
 		vs.logError("error opening %s: %s\n", name, err)
 		return 0, 0, err
 	}
+	interval, newHash, err := readChecksumHeader(fp)
+	if err != nil {
+		vs.logError("bad header checksum settings: %s: %s\n", name, err)
+		fp.Close()
+		return 0, 0, err
+	}
+	h := newHash()
+	fromDiskBuf := make([]byte, interval+4)
 	checksumFailures := 0
 	first := true
 	terminated := false
+	entrySize := 0
 	fromDiskOverflow = fromDiskOverflow[:0]
+	pacer := vs.newBackgroundPacer()
 	skipCounter := 0 - skipOffset
 	for {
+		pacer.pause()
 		n, err := io.ReadFull(fp, fromDiskBuf)
 		if n < 4 {
 			if err != io.EOF && err != io.ErrUnexpectedEOF {
@@ -288,23 +589,19 @@ func (vs *DefaultValueStore) sampleTOC(name string, candidateBlockID uint32, ski
 			break
 		}
 		n -= 4
-		if murmur3.Sum32(fromDiskBuf[:n]) != binary.BigEndian.Uint32(fromDiskBuf[n:]) {
+		if checksum32(h, fromDiskBuf[:n]) != binary.BigEndian.Uint32(fromDiskBuf[n:]) {
 			checksumFailures++
 		} else {
 			j := 0
 			if first {
-				if !bytes.Equal(fromDiskBuf[:28], []byte("VALUESTORETOC v0            ")) {
+				if entrySize = tocEntrySize(fromDiskBuf); entrySize == 0 {
 					vs.logError("bad header: %s\n", name)
 					break
 				}
-				if binary.BigEndian.Uint32(fromDiskBuf[28:]) != vs.checksumInterval {
-					vs.logError("bad header checksum interval: %s\n", name)
-					break
-				}
 				j += 32
 				first = false
 			}
-			if n < int(vs.checksumInterval) {
+			if n < int(interval) {
 				if binary.BigEndian.Uint32(fromDiskBuf[n-16:]) != 0 {
 					vs.logError("bad terminator size marker: %s\n", name)
 					break
@@ -317,8 +614,8 @@ func (vs *DefaultValueStore) sampleTOC(name string, candidateBlockID uint32, ski
 				terminated = true
 			}
 			if len(fromDiskOverflow) > 0 {
-				j += 32 - len(fromDiskOverflow)
-				fromDiskOverflow = append(fromDiskOverflow, fromDiskBuf[j-32+len(fromDiskOverflow):j]...)
+				j += entrySize - len(fromDiskOverflow)
+				fromDiskOverflow = append(fromDiskOverflow, fromDiskBuf[j-entrySize+len(fromDiskOverflow):j]...)
 				keyB := binary.BigEndian.Uint64(fromDiskOverflow[8:])
 				keyA := binary.BigEndian.Uint64(fromDiskOverflow)
 				timestampbits := binary.BigEndian.Uint64(fromDiskOverflow[16:])
@@ -326,7 +623,7 @@ func (vs *DefaultValueStore) sampleTOC(name string, candidateBlockID uint32, ski
 				count++
 				if skipCounter == skipCount {
 					tsm, blockid, _, _ := vs.lookup(keyA, keyB)
-					if tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && blockid != candidateBlockID || tsm&_TSB_DELETION != 0 {
+					if (timestampbits>>_TSB_UTIL_BITS == 0 || tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && !vs.inValuesFile(blockid, candidateBlockID) || tsm&_TSB_DELETION != 0) && !vs.versionsKept(keyA, keyB, timestampbits, candidateBlockID) {
 						stale++
 					}
 					skipCounter = 0
@@ -335,14 +632,14 @@ func (vs *DefaultValueStore) sampleTOC(name string, candidateBlockID uint32, ski
 				}
 
 			}
-			for ; j+32 <= n; j += 32 {
+			for ; j+entrySize <= n; j += entrySize {
 				keyB := binary.BigEndian.Uint64(fromDiskBuf[j+8:])
 				keyA := binary.BigEndian.Uint64(fromDiskBuf[j:])
 				timestampbits := binary.BigEndian.Uint64(fromDiskBuf[j+16:])
 				tsm, blockid, _, _ := vs.lookup(keyA, keyB)
 				count++
 				if skipCounter == skipCount {
-					if tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && blockid != candidateBlockID || tsm&_TSB_DELETION != 0 {
+					if (timestampbits>>_TSB_UTIL_BITS == 0 || tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && !vs.inValuesFile(blockid, candidateBlockID) || tsm&_TSB_DELETION != 0) && !vs.versionsKept(keyA, keyB, timestampbits, candidateBlockID) {
 						stale++
 					}
 					skipCounter = 0
@@ -361,6 +658,9 @@ func (vs *DefaultValueStore) sampleTOC(name string, candidateBlockID uint32, ski
 		}
 	}
 	fp.Close()
+	if vs.compactionState.dropPageCache {
+		vs.dropPageCacheName(name)
+	}
 	if !terminated {
 		vs.logError("early end of file: %s\n", name)
 	}
@@ -371,6 +671,19 @@ func (vs *DefaultValueStore) sampleTOC(name string, candidateBlockID uint32, ski
 
 }
 
+// compactionRewrite writes the live entry's value anew for compaction. An
+// error is returned if the key is still found in the values file being
+// compacted, as its entry would then be lost along with the file.
+func (vs *DefaultValueStore) compactionRewrite(keyA uint64, keyB uint64, timestampbits uint64, candidateBlockID uint32, value []byte) error {
+	if _, err := vs.write(keyA, keyB, timestampbits|_TSB_COMPACTION_REWRITE, value); err != nil {
+		return err
+	}
+	if _, blockID, _, _ := vs.lookup(keyA, keyB); vs.inValuesFile(blockID, candidateBlockID) {
+		return fmt.Errorf("rewrite of %016x %016x left it in the values file", keyA, keyB)
+	}
+	return nil
+}
+
 type compactionResult struct {
 	checksumFailures int
 	count            int
@@ -380,17 +693,27 @@ type compactionResult struct {
 
 func (vs *DefaultValueStore) compactFile(name string, candidateBlockID uint32) (compactionResult, error) {
 	var cr compactionResult
-	fromDiskBuf := make([]byte, vs.checksumInterval+4)
-	fromDiskOverflow := make([]byte, 0, 32)
-	fp, err := os.Open(name)
+	fromDiskOverflow := make([]byte, 0, _TOC_ENTRY_SIZE_MAX)
+	fp, err := vs.openFile(name)
 	if err != nil {
 		vs.logError("error opening %s: %s\n", name, err)
 		return cr, errors.New("Error opening toc")
 	}
+	interval, newHash, err := readChecksumHeader(fp)
+	if err != nil {
+		vs.logError("bad header checksum settings: %s: %s\n", name, err)
+		fp.Close()
+		return cr, errors.New("Bad header checksum settings")
+	}
+	h := newHash()
+	fromDiskBuf := make([]byte, interval+4)
 	first := true
 	terminated := false
+	entrySize := 0
 	fromDiskOverflow = fromDiskOverflow[:0]
+	pacer := vs.newBackgroundPacer()
 	for {
+		pacer.pause()
 		n, err := io.ReadFull(fp, fromDiskBuf)
 		if n < 4 {
 			if err != io.EOF && err != io.ErrUnexpectedEOF {
@@ -400,23 +723,19 @@ func (vs *DefaultValueStore) compactFile(name string, candidateBlockID uint32) (
 			break
 		}
 		n -= 4
-		if murmur3.Sum32(fromDiskBuf[:n]) != binary.BigEndian.Uint32(fromDiskBuf[n:]) {
+		if checksum32(h, fromDiskBuf[:n]) != binary.BigEndian.Uint32(fromDiskBuf[n:]) {
 			cr.checksumFailures++
 		} else {
 			j := 0
 			if first {
-				if !bytes.Equal(fromDiskBuf[:28], []byte("VALUESTORETOC v0            ")) {
+				if entrySize = tocEntrySize(fromDiskBuf); entrySize == 0 {
 					vs.logError("bad header: %s\n", name)
 					return cr, errors.New("Bad header")
 				}
-				if binary.BigEndian.Uint32(fromDiskBuf[28:]) != vs.checksumInterval {
-					vs.logError("bad header checksum interval: %s\n", name)
-					return cr, errors.New("Bad header checksum interval")
-				}
 				j += 32
 				first = false
 			}
-			if n < int(vs.checksumInterval) {
+			if n < int(interval) {
 				if binary.BigEndian.Uint32(fromDiskBuf[n-16:]) != 0 {
 					vs.logError("bad terminator size marker: %s\n", name)
 					return cr, errors.New("Error on toc term size marker")
@@ -429,14 +748,21 @@ func (vs *DefaultValueStore) compactFile(name string, candidateBlockID uint32) (
 				terminated = true
 			}
 			if len(fromDiskOverflow) > 0 {
-				j += 32 - len(fromDiskOverflow)
-				fromDiskOverflow = append(fromDiskOverflow, fromDiskBuf[j-32+len(fromDiskOverflow):j]...)
+				j += entrySize - len(fromDiskOverflow)
+				fromDiskOverflow = append(fromDiskOverflow, fromDiskBuf[j-entrySize+len(fromDiskOverflow):j]...)
 				keyB := binary.BigEndian.Uint64(fromDiskOverflow[8:])
 				keyA := binary.BigEndian.Uint64(fromDiskOverflow)
 				timestampbits := binary.BigEndian.Uint64(fromDiskOverflow[16:])
 				fromDiskOverflow = fromDiskOverflow[:0]
 				tsm, blockid, _, _ := vs.lookup(keyA, keyB)
-				if tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && blockid != candidateBlockID || tsm&_TSB_DELETION != 0 {
+				if kept, err := vs.versionsCompact(keyA, keyB, timestampbits, candidateBlockID); kept {
+					if err != nil {
+						vs.logCritical("Error on version rewrite %s\n", err)
+						return cr, errors.New("Error on version rewrite")
+					}
+					cr.count++
+					cr.rewrote++
+				} else if timestampbits>>_TSB_UTIL_BITS == 0 || tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && !vs.inValuesFile(blockid, candidateBlockID) || tsm&_TSB_DELETION != 0 {
 					cr.count++
 					cr.stale++
 				} else {
@@ -446,21 +772,39 @@ func (vs *DefaultValueStore) compactFile(name string, candidateBlockID uint32) (
 						vs.logCritical("Error on rewrite read %s\n", err)
 						return cr, errors.New("Error on read for compaction rewrite.")
 					}
-					_, err = vs.write(keyA, keyB, timestampbits|_TSB_COMPACTION_REWRITE, value)
+					dropped, err := vs.compactionFilter(keyA, keyB, timestampbits, value)
 					if err != nil {
-						vs.logCritical("Error on rewrite %s\n", err)
-						return cr, errors.New("Write error on compaction rewrite.")
+						vs.logCritical("Error on filter removal %s\n", err)
+						return cr, errors.New("Error on compaction filter removal.")
+					}
+					if dropped {
+						cr.count++
+						cr.stale++
+					} else {
+						vs.compactionThrottle(len(value))
+						err = vs.compactionRewrite(keyA, keyB, timestampbits, candidateBlockID, value)
+						if err != nil {
+							vs.logCritical("Error on rewrite %s\n", err)
+							return cr, errors.New("Write error on compaction rewrite.")
+						}
+						cr.count++
+						cr.rewrote++
 					}
-					cr.count++
-					cr.rewrote++
 				}
 			}
-			for ; j+32 <= n; j += 32 {
+			for ; j+entrySize <= n; j += entrySize {
 				keyB := binary.BigEndian.Uint64(fromDiskBuf[j+8:])
 				keyA := binary.BigEndian.Uint64(fromDiskBuf[j:])
 				timestampbits := binary.BigEndian.Uint64(fromDiskBuf[j+16:])
 				tsm, blockid, _, _ := vs.lookup(keyA, keyB)
-				if tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && blockid != candidateBlockID || tsm&_TSB_DELETION != 0 {
+				if kept, err := vs.versionsCompact(keyA, keyB, timestampbits, candidateBlockID); kept {
+					if err != nil {
+						vs.logCritical("Error on version rewrite %s\n", err)
+						return cr, errors.New("Error on version rewrite")
+					}
+					cr.count++
+					cr.rewrote++
+				} else if timestampbits>>_TSB_UTIL_BITS == 0 || tsm>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS && !vs.inValuesFile(blockid, candidateBlockID) || tsm&_TSB_DELETION != 0 {
 					cr.count++
 					cr.stale++
 				} else {
@@ -470,13 +814,24 @@ func (vs *DefaultValueStore) compactFile(name string, candidateBlockID uint32) (
 						vs.logCritical("Error on rewrite read %s\n", err)
 						return cr, errors.New("Error on rewrite read")
 					}
-					_, err = vs.write(keyA, keyB, timestampbits|_TSB_COMPACTION_REWRITE, value)
+					dropped, err := vs.compactionFilter(keyA, keyB, timestampbits, value)
 					if err != nil {
-						vs.logCritical("Error on rewrite %s\n", err)
-						return cr, errors.New("Error on rewrite")
+						vs.logCritical("Error on filter removal %s\n", err)
+						return cr, errors.New("Error on filter removal")
+					}
+					if dropped {
+						cr.count++
+						cr.stale++
+					} else {
+						vs.compactionThrottle(len(value))
+						err = vs.compactionRewrite(keyA, keyB, timestampbits, candidateBlockID, value)
+						if err != nil {
+							vs.logCritical("Error on rewrite %s\n", err)
+							return cr, errors.New("Error on rewrite")
+						}
+						cr.count++
+						cr.rewrote++
 					}
-					cr.count++
-					cr.rewrote++
 				}
 			}
 			if j != n {
@@ -490,6 +845,10 @@ func (vs *DefaultValueStore) compactFile(name string, candidateBlockID uint32) (
 		}
 	}
 	fp.Close()
+	if vs.compactionState.dropPageCache {
+		vs.dropPageCacheName(name)
+		vs.dropPageCacheName(vs.valuesName(vs.valueLocBlock(candidateBlockID).timestampnano()))
+	}
 	if !terminated {
 		vs.logError("early end of file: %s\n", name)
 		return cr, nil
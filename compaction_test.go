@@ -0,0 +1,300 @@
+package valuestore
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMigrationPass(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 200)
+	vs.Close()
+	// Only the checksum interval changes, which compaction alone leaves be.
+	h.cfg.ChecksumInterval = 2048
+	vs = h.open()
+	defer vs.Close()
+	vs.compactionState.ageThreshold = 0
+	vs.compactionPass(false)
+	if stats := vs.Stats(false).(*Stats); stats.MigrationCompactions != 0 {
+		t.Fatal(stats.MigrationCompactions)
+	}
+	vs.compactionPass(true)
+	vs.Flush()
+	if stats := vs.Stats(false).(*Stats); stats.MigrationCompactions == 0 {
+		t.Fatal(stats.MigrationCompactions)
+	}
+	if readable := h.verify(vs); readable != len(h.values) {
+		t.Fatal(readable, len(h.values))
+	}
+	for _, name := range h.files(".values") {
+		fp, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		interval, _, err := readChecksumHeader(fp)
+		fp.Close()
+		if err != nil || interval != 2048 {
+			t.Fatal(name, interval, err)
+		}
+	}
+}
+
+func TestCompactFile(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 200)
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	files := vs.ListFiles()
+	if len(files) != 1 || files[0].Waste != -1 || files[0].Age <= 0 {
+		t.Fatalf("%#v", files)
+	}
+	id := files[0].ID
+	s := vs.Snapshot()
+	if err := vs.CompactFile(id); err != ErrFileInUse {
+		t.Fatal(err)
+	}
+	s.Release()
+	if err := vs.CompactFile(12345); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	// Fresh as the file is, it is compacted when asked.
+	if err := vs.CompactFile(id); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	for _, f := range vs.ListFiles() {
+		if f.ID == id {
+			t.Fatalf("%#v", f)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.FileCompactions != 1 {
+		t.Fatal(stats.FileCompactions)
+	}
+	if readable := h.verify(vs); readable != len(h.values) {
+		t.Fatal(readable, len(h.values))
+	}
+}
+
+func TestCompactionSwap(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 200)
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	files := vs.ListFiles()
+	if len(files) != 1 {
+		t.Fatalf("%#v", files)
+	}
+	id := files[0].ID
+	if err := vs.CompactFile(id); err != nil {
+		t.Fatal(err)
+	}
+	// The rewrites are on disk by the time the old files are gone, so a
+	// crash right after loses nothing.
+	crashed := h.crash()
+	if readable := h.verify(crashed); readable != len(h.values) {
+		t.Fatal(readable, len(h.values))
+	}
+	ops := crashed.manifestRead()
+	if _, ok := ops[id]; ok {
+		t.Fatal(ops)
+	}
+	crashed.Close()
+	files = vs.ListFiles()
+	if len(files) == 0 {
+		t.Fatalf("%#v", files)
+	}
+	// A crash midway through a compaction has it rolled back.
+	id = files[0].ID
+	vs.manifestAppend(_MANIFEST_COMPACT_BEGIN, id)
+	crashed = h.crash()
+	defer crashed.Close()
+	if stats := crashed.Stats(false).(*Stats); stats.RecoveryCompactionRollbacks != 1 || stats.RecoveryStrayFiles != 0 {
+		t.Fatal(stats.RecoveryCompactionRollbacks, stats.RecoveryStrayFiles)
+	}
+	if ops = crashed.manifestRead(); ops[id] != _MANIFEST_CREATE {
+		t.Fatal(ops)
+	}
+	if readable := h.verify(crashed); readable != len(h.values) {
+		t.Fatal(readable, len(h.values))
+	}
+}
+
+func TestCompactionMerge(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	h.cfg.CompactionAgeThreshold = 1
+	for i := 0; i < 4; i++ {
+		vs := h.open()
+		h.workload(vs, uint64(i*20+1), 20)
+		vs.Close()
+	}
+	if values := h.files(".values"); len(values) != 4 {
+		t.Fatal(values)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	vs := h.open()
+	defer vs.Close()
+	vs.CompactionPass()
+	// The small files' entries are all rewritten into the one file.
+	if values := h.files(".values"); len(values) != 1 {
+		t.Fatal(values)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.MergedCompactions != 1 || stats.SmallFileCompactions != 4 {
+		t.Fatal(stats.MergedCompactions, stats.SmallFileCompactions)
+	}
+	if readable := h.verify(vs); readable != len(h.values) {
+		t.Fatal(readable, len(h.values))
+	}
+}
+
+func TestCompactionFilter(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 200)
+	vs.Close()
+	var lock sync.Mutex
+	seen := 0
+	h.cfg.CompactionFilter = func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+		lock.Lock()
+		seen++
+		lock.Unlock()
+		if timestampmicro != int64(1000+keyB) || !bytes.Equal(value, h.values[keyB]) {
+			t.Error(keyB, timestampmicro)
+		}
+		return keyB%2 == 0
+	}
+	vs = h.open()
+	defer vs.Close()
+	files := vs.ListFiles()
+	if len(files) != 1 {
+		t.Fatalf("%#v", files)
+	}
+	if err := vs.CompactFile(files[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if seen != 200 {
+		t.Fatal(seen)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.CompactionFiltered != 100 {
+		t.Fatal(stats.CompactionFiltered)
+	}
+	for keyB, value := range h.values {
+		_, v, err := vs.read(1, keyB, nil)
+		if keyB%2 == 0 {
+			if err != nil || !bytes.Equal(v, value) {
+				t.Fatal(keyB, err)
+			}
+		} else if err != ErrNotFound {
+			t.Fatal(keyB, err)
+		}
+	}
+}
+
+func TestCompactionThrottle(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 200)
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	files := vs.ListFiles()
+	if len(files) != 1 {
+		t.Fatalf("%#v", files)
+	}
+	bytes := 0
+	for _, value := range h.values {
+		bytes += len(value)
+	}
+	// A second's worth may go at once, the rest at the rate.
+	rate := bytes / 3
+	vs.SetCompactionMaxBytesPerSec(rate)
+	if cfg := vs.Config(); cfg.CompactionMaxBytesPerSec != rate {
+		t.Fatal(cfg.CompactionMaxBytesPerSec)
+	}
+	start := time.Now()
+	if err := vs.CompactFile(files[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatal(elapsed)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.CompactionThrottles == 0 {
+		t.Fatal(stats.CompactionThrottles)
+	}
+	vs.SetCompactionMaxBytesPerSec(-1)
+	if cfg := vs.Config(); cfg.CompactionMaxBytesPerSec != 0 {
+		t.Fatal(cfg.CompactionMaxBytesPerSec)
+	}
+}
+
+func TestCompactionRewritten(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 200)
+	vs.Close()
+	vs = h.open()
+	// The second compaction rewrites entries the first already had, whose
+	// timestampbits carry _TSB_COMPACTION_REWRITE, so are the same as those
+	// of their rewrites.
+	for i := 0; i < 2; i++ {
+		files := vs.ListFiles()
+		if len(files) != 1 {
+			t.Fatalf("%d %#v", i, files)
+		}
+		if err := vs.CompactFile(files[0].ID); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	if readable := h.verify(vs); readable != len(h.values) {
+		t.Fatal(readable, len(h.values))
+	}
+}
+
+func TestCompactionMergeSettles(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	for i := 0; i < 4; i++ {
+		vs := h.open()
+		h.workload(vs, uint64(i*20+1), 20)
+		vs.Close()
+	}
+	vs := h.open()
+	vs.compactionState.ageThreshold = 0
+	// The merged file is still small, but has no others to merge with, so
+	// later passes leave it be rather than rewriting it again each time.
+	var settled []string
+	for i := 0; i < 3; i++ {
+		vs.compactionPass(false)
+		values := h.files(".values")
+		if len(values) != 1 || settled != nil && values[0] != settled[0] {
+			t.Fatal(i, values, settled)
+		}
+		settled = values
+	}
+	if stats := vs.Stats(false).(*Stats); stats.MergedCompactions != 1 || stats.SmallFileCompactions != 4 || stats.Compactions != 0 {
+		t.Fatal(stats.MergedCompactions, stats.SmallFileCompactions, stats.Compactions)
+	}
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	if readable := h.verify(vs); readable != len(h.values) {
+		t.Fatal(readable, len(h.values))
+	}
+}
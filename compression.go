@@ -0,0 +1,198 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// The codecs Config.Compression may name. While Compression is other than
+// "none", new values files are written with a v1 header naming the codec and
+// each value in them is stored with a leading byte giving the codec it was
+// compressed with, followed, if compressed, by its uncompressed length as a
+// uvarint; values that don't get smaller are stored as is, with
+// _COMPRESSION_NONE. Values files with a v0 header hold values as is, so
+// files written with and without compression can be mixed freely.
+const (
+	_COMPRESSION_NONE   = 0
+	_COMPRESSION_SNAPPY = 1
+	_COMPRESSION_LZ4    = 2
+	_COMPRESSION_ZSTD   = 3
+)
+
+var compressionCodecs = map[string]byte{
+	"none":   _COMPRESSION_NONE,
+	"snappy": _COMPRESSION_SNAPPY,
+	"lz4":    _COMPRESSION_LZ4,
+	"zstd":   _COMPRESSION_ZSTD,
+}
+
+var errBadCompressedValue = errors.New("bad compressed value")
+
+type compressionState struct {
+	// codec is what new values are compressed with; framed is set when it
+	// isn't _COMPRESSION_NONE, in which case values in memory and in new
+	// values files have the leading codec byte.
+	codec  byte
+	framed bool
+	// The zstd encoder and decoder are safe for concurrent EncodeAll and
+	// DecodeAll calls; the decoder is only made once a zstd value is read.
+	zstdEncoder     *zstd.Encoder
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderOnce sync.Once
+	zstdDecoderErr  error
+}
+
+func (vs *DefaultValueStore) compressionConfig(cfg *Config) error {
+	vs.compressionState.codec = compressionCodecs[cfg.Compression]
+	vs.compressionState.framed = vs.compressionState.codec != _COMPRESSION_NONE
+	if vs.compressionState.codec == _COMPRESSION_ZSTD {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return err
+		}
+		vs.compressionState.zstdEncoder = enc
+	}
+	return nil
+}
+
+// valuesFileHead returns the header for a new values file, before the
+// checksum settings are put in it.
+func (vs *DefaultValueStore) valuesFileHead() []byte {
+	if !vs.compressionState.framed {
+		return []byte("VALUESTORE v0                   ")
+	}
+	for name, codec := range compressionCodecs {
+		if codec == vs.compressionState.codec {
+			return []byte(fmt.Sprintf("VALUESTORE v1 %-18s", name))
+		}
+	}
+	panic("unknown codec")
+}
+
+// valuesFileHeadCodec returns whether the values file with the given header
+// holds framed values and, if so, the codec it was written with.
+func valuesFileHeadCodec(head []byte) (bool, byte) {
+	if string(head[:14]) != "VALUESTORE v1 " {
+		return false, _COMPRESSION_NONE
+	}
+	return true, compressionCodecs[strings.TrimRight(string(head[14:28]), " ")]
+}
+
+// compress stores value in dst, reusing its space, as it is to be kept in a
+// framed values file; see _COMPRESSION_NONE.
+func (vs *DefaultValueStore) compress(dst []byte, value []byte) []byte {
+	return compressValue(vs.compressionState.codec, vs.compressionState.zstdEncoder, dst, value)
+}
+
+// compressValue is compress with the codec given; zstdEncoder is only used,
+// and so only needed, for _COMPRESSION_ZSTD.
+func compressValue(codec byte, zstdEncoder *zstd.Encoder, dst []byte, value []byte) []byte {
+	if len(value) == 0 {
+		return dst[:0]
+	}
+	dst = append(dst[:0], codec)
+	var uvarint [binary.MaxVarintLen64]byte
+	dst = append(dst, uvarint[:binary.PutUvarint(uvarint[:], uint64(len(value)))]...)
+	n := len(dst)
+	switch codec {
+	case _COMPRESSION_SNAPPY:
+		dst = growBytes(dst, snappy.MaxEncodedLen(len(value)))
+		dst = dst[:n+len(snappy.Encode(dst[n:], value))]
+	case _COMPRESSION_LZ4:
+		dst = growBytes(dst, lz4.CompressBlockBound(len(value)))
+		// lz4 gives 0, rather than an error, for incompressible data; either
+		// way the value is then stored as is below.
+		if c, err := lz4.CompressBlock(value, dst[n:], nil); err == nil && c > 0 {
+			dst = dst[:n+c]
+		}
+	case _COMPRESSION_ZSTD:
+		dst = zstdEncoder.EncodeAll(value, dst)
+	}
+	if len(dst) > len(value) {
+		dst = append(append(dst[:0], _COMPRESSION_NONE), value...)
+	}
+	return dst
+}
+
+// storedLengthMax returns the most space a value of the given length may take
+// once stored; compress never makes a value more than a byte longer.
+func (vs *DefaultValueStore) storedLengthMax(length int) int {
+	if vs.compressionState.framed && length > 0 {
+		return length + 1
+	}
+	return length
+}
+
+// decompress appends to dst the value stored framed in stored.
+func (vs *DefaultValueStore) decompress(dst []byte, stored []byte) ([]byte, error) {
+	return vs.decompressMax(dst, stored, int(vs.valueCap))
+}
+
+// decompressMax is decompress for something framed the same way that may be
+// up to max bytes, rather than ValueCap, once decompressed.
+func (vs *DefaultValueStore) decompressMax(dst []byte, stored []byte, max int) ([]byte, error) {
+	if len(stored) == 0 {
+		return dst, nil
+	}
+	if stored[0] == _COMPRESSION_NONE {
+		return append(dst, stored[1:]...), nil
+	}
+	length, n := binary.Uvarint(stored[1:])
+	if n <= 0 || length > uint64(max) {
+		return dst, errBadCompressedValue
+	}
+	payload := stored[1+n:]
+	start := len(dst)
+	dst = growBytes(dst, int(length))
+	switch stored[0] {
+	case _COMPRESSION_SNAPPY:
+		if l, err := snappy.DecodedLen(payload); err != nil || l != int(length) {
+			return dst[:start], errBadCompressedValue
+		}
+		if _, err := snappy.Decode(dst[start:], payload); err != nil {
+			return dst[:start], err
+		}
+	case _COMPRESSION_LZ4:
+		if c, err := lz4.UncompressBlock(payload, dst[start:]); err != nil {
+			return dst[:start], err
+		} else if c != int(length) {
+			return dst[:start], errBadCompressedValue
+		}
+	case _COMPRESSION_ZSTD:
+		s := &vs.compressionState
+		s.zstdDecoderOnce.Do(func() {
+			s.zstdDecoder, s.zstdDecoderErr = zstd.NewReader(nil)
+		})
+		if s.zstdDecoderErr != nil {
+			return dst[:start], s.zstdDecoderErr
+		}
+		out, err := s.zstdDecoder.DecodeAll(payload, dst[:start])
+		if err != nil {
+			return dst[:start], err
+		}
+		if len(out)-start != int(length) {
+			return dst[:start], errBadCompressedValue
+		}
+		return out, nil
+	default:
+		return dst[:start], fmt.Errorf("unknown compression codec %d", stored[0])
+	}
+	return dst, nil
+}
+
+// growBytes extends b by n bytes, reallocating if need be.
+func growBytes(b []byte, n int) []byte {
+	if len(b)+n <= cap(b) {
+		return b[:len(b)+n]
+	}
+	b2 := make([]byte, len(b)+n)
+	copy(b2, b)
+	return b2
+}
@@ -0,0 +1,132 @@
+package valuestore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	for _, codec := range []string{"snappy", "lz4", "zstd"} {
+		testCompression(t, codec)
+	}
+}
+
+func testCompression(t *testing.T, codec string) {
+	h := newCrashHarness(t)
+	defer h.close()
+	values := map[uint64][]byte{
+		1: bytes.Repeat([]byte("a"), 4096),
+		2: bytes.Repeat([]byte("0123456789"), 300),
+		3: []byte("x"),
+		4: {},
+	}
+	check := func(vs *DefaultValueStore, when string) {
+		for keyB, expected := range values {
+			if ts, value, err := vs.Read(1, keyB, []byte("prefix")); err != nil || ts != int64(1000+keyB) || !bytes.Equal(value, append([]byte("prefix"), expected...)) {
+				t.Fatalf("%s %s: key %d: %d %d %v", codec, when, keyB, ts, len(value), err)
+			}
+		}
+		entries := make([]ReadMultiEntry, 0, len(values))
+		for keyB := range values {
+			entries = append(entries, ReadMultiEntry{KeyA: 1, KeyB: keyB})
+		}
+		vs.ReadMulti(entries)
+		for _, e := range entries {
+			if e.Err != nil || !bytes.Equal(e.Value, values[e.KeyB]) {
+				t.Fatalf("%s %s: ReadMulti key %d: %d %v", codec, when, e.KeyB, len(e.Value), e.Err)
+			}
+		}
+	}
+	// Written uncompressed first, then compressed, then recompressed.
+	vs := h.open()
+	for keyB := uint64(1); keyB <= 2; keyB++ {
+		if _, err := vs.Write(1, keyB, int64(1000+keyB), values[keyB]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Close()
+	h.cfg.Compression = codec
+	vs = h.open()
+	for keyB := uint64(3); keyB <= 4; keyB++ {
+		if _, err := vs.Write(1, keyB, int64(1000+keyB), values[keyB]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values[5] = bytes.Repeat([]byte("b"), 3000)
+	if _, err := vs.WriteStream(1, 5, 1005, uint32(len(values[5])), bytes.NewReader(values[5])); err != nil {
+		t.Fatal(err)
+	}
+	check(vs, "mixed")
+	vs.Flush()
+	check(vs, "mixed flushed")
+	if _, length, err := vs.Lookup(1, 1); err != nil || length != 4096 {
+		t.Fatalf("%s: uncompressed value's length was %d %v", codec, length, err)
+	}
+	vs.Close()
+	h.cfg.CompactionRecompress = true
+	vs = h.open()
+	if _, err := vs.Write(1, 6, 1006, bytes.Repeat([]byte("c"), 2000)); err != nil {
+		t.Fatal(err)
+	}
+	values[6] = bytes.Repeat([]byte("c"), 2000)
+	check(vs, "reopened")
+	vs.compactionState.ageThreshold = 0
+	vs.compactionPass(false)
+	vs.Flush()
+	if stats := vs.Stats(false).(*Stats); stats.RecompressionCompactions == 0 {
+		t.Fatalf("%s: no recompression compactions", codec)
+	}
+	check(vs, "recompressed")
+	if _, length, err := vs.Lookup(1, 1); err != nil || length >= 4096 {
+		t.Fatalf("%s: recompressed value's length was %d %v", codec, length, err)
+	}
+	for _, name := range h.files(".values") {
+		fp, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head := make([]byte, 32)
+		_, err = io.ReadFull(fp, head)
+		fp.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if framed, c := valuesFileHeadCodec(head); !framed || c != compressionCodecs[codec] {
+			t.Fatalf("%s: %s left with header %q", codec, name, head[:28])
+		}
+	}
+	vs.Close()
+	h.cfg.Compression = "none"
+	vs = h.open()
+	check(vs, "read without compression")
+	vs.Close()
+}
+
+func TestCompressionIncompressible(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	h.cfg.Compression = "snappy"
+	vs := h.open()
+	value := make([]byte, 4096)
+	for i := range value {
+		value[i] = byte(i * 7 % 251)
+	}
+	if _, err := vs.Write(1, 1, 1000, value); err != nil {
+		t.Fatal(err)
+	}
+	if _, length, err := vs.Lookup(1, 1); err != nil || length != 4097 {
+		t.Fatal(length, err)
+	}
+	if _, v, err := vs.Read(1, 1, nil); err != nil || !bytes.Equal(v, value) {
+		t.Fatal(len(v), err)
+	}
+	vs.Close()
+	if c := resolveConfig(&Config{IgnoreEnv: true, Compression: "ZSTD", LogWarning: func(string, ...interface{}) {}}).Compression; c != "zstd" {
+		t.Fatal(c)
+	}
+	if c := resolveConfig(&Config{IgnoreEnv: true, Compression: "gzip", LogWarning: func(string, ...interface{}) {}}).Compression; c != "none" {
+		t.Fatal(c)
+	}
+}
@@ -1,43 +1,150 @@
 package valuestore
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/gholt/ring"
 	"github.com/gholt/valuelocmap"
 )
 
 type LogFunc func(format string, v ...interface{})
 
+// ReplicationHookFunc is called for entries from incoming bulk-set messages;
+// applied will be false if the entry was skipped because the same or a newer
+// entry was already stored. The value is only valid for the duration of the
+// call.
+type ReplicationHookFunc func(keyA uint64, keyB uint64, timestampmicro int64, deletion bool, value []byte, applied bool)
+
+// The resolutions a ConflictResolverFunc may return.
+const (
+	// CONFLICT_KEEP_LOCAL leaves the locally stored value as is.
+	CONFLICT_KEEP_LOCAL = 0
+	// CONFLICT_KEEP_REMOTE stores the incoming value instead.
+	CONFLICT_KEEP_REMOTE = 1
+	// CONFLICT_MERGE stores the merged value returned instead.
+	CONFLICT_MERGE = 2
+)
+
+// ConflictResolverFunc is called when an incoming bulk-set entry has the same
+// timestamp as the locally stored entry but a different value. It returns one
+// of CONFLICT_KEEP_LOCAL, CONFLICT_KEEP_REMOTE, or CONFLICT_MERGE with the
+// merged value. Kept remote or merged values are stored one microsecond newer
+// than the conflicting entries so that they replicate out over both. For the
+// replicas to converge, the resolver should give the same result regardless
+// of which value is local; for example, keeping the greater of the two. The
+// local and remote values are only valid for the duration of the call.
+type ConflictResolverFunc func(keyA uint64, keyB uint64, timestampmicro int64, local []byte, remote []byte) (int, []byte)
+
+// The actions a DiskHealthFunc may return, from least to most severe.
+const (
+	// DISK_HEALTHY leaves the store running as usual.
+	DISK_HEALTHY = 0
+	// DISK_READ_ONLY disables writes, as DisableWrites does, so the disk is
+	// only read from while it is replaced.
+	DISK_READ_ONLY = 1
+	// DISK_EVACUATE disables writes and the background work other than push
+	// replication, which is enabled, so the data can be handed off once the
+	// ring moves this node's partitions elsewhere.
+	DISK_EVACUATE = 2
+)
+
+// DiskHealthFunc is called every DiskHealthInterval with the stats since the
+// last call for each disk the store uses. It returns one of DISK_HEALTHY,
+// DISK_READ_ONLY, or DISK_EVACUATE; deployments can judge by the stats given
+// and by signals of their own, such as SMART data.
+type DiskHealthFunc func(stats DiskStats) int
+
+// RecoveryCallbackFunc is called with the progress recovery has made loading
+// the existing data at startup.
+type RecoveryCallbackFunc func(progress RecoveryProgress)
+
+// CompactionFilterFunc is called by compaction for each live entry it is
+// about to rewrite and returns whether to keep it. The value is only valid
+// for the duration of the call.
+type CompactionFilterFunc func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool
+
 // Config represents the set of values for configuring a ValueStore. Note that
 // changing the values (shallow changes) in this structure will have no effect
 // on existing ValueStores; but deep changes (such as reconfiguring an existing
 // Logger) will.
+//
+// The funcs and interfaces are skipped when encoding a Config as JSON, such
+// as the one returned by DefaultValueStore.Config.
 type Config struct {
+	// EnvPrefix sets the prefix of the environment variables that override
+	// the other values here, such as EnvPrefix + "PATH" overriding Path. This
+	// allows several ValueStores in one process to be configured separately.
+	// Defaults to "VALUESTORE_".
+	EnvPrefix string
+	// IgnoreEnv set true will ignore all environment variables, using just
+	// the values here and the defaults.
+	IgnoreEnv bool
+	// AutoTune set true will detect the number of cores, available memory,
+	// and the kind of device and free space at Path, and use them to fill in
+	// any of Workers, PageSize, WritePagesPerWorker, ValuesFileReaders,
+	// ValuesFileCap, CompactionWorkers, and OutPullReplicationBloomN left
+	// zero. DefaultValueStore.Config reports the values chosen.
+	AutoTune bool
 	// LogCritical sets the func to use for critical messages. Defaults logging
 	// to os.Stderr.
-	LogCritical LogFunc
+	LogCritical LogFunc `json:"-"`
 	// LogError sets the func to use for error messages. Defaults logging to
 	// os.Stderr.
-	LogError LogFunc
+	LogError LogFunc `json:"-"`
 	// LogWarning sets the func to use for warning messages. Defaults logging
 	// to os.Stderr.
-	LogWarning LogFunc
+	LogWarning LogFunc `json:"-"`
 	// LogInfo sets the func to use for info messages. Defaults logging to
 	// os.Stdout.
-	LogInfo LogFunc
+	LogInfo LogFunc `json:"-"`
 	// LogDebug sets the func to use for debug messages. Defaults not logging
 	// debug messages.
-	LogDebug LogFunc
+	LogDebug LogFunc `json:"-"`
+	// ReplicationHook sets the func to call for each entry from incoming
+	// bulk-set messages, such as those from replication and handoffs, that is
+	// either stored or skipped as older. This is not called for local Write
+	// and Delete calls. Defaults to nil, no hook.
+	ReplicationHook ReplicationHookFunc `json:"-"`
+	// ConflictResolver sets the func to call when an incoming bulk-set entry
+	// has the same timestamp as the local entry but a different value; these
+	// are also counted in the InBulkSetConflicts stat. This requires reading
+	// the local value for every incoming entry with a matching timestamp, so
+	// conflicts are only looked for if this is set. Defaults to nil, the local
+	// value is kept and conflicts are not looked for.
+	ConflictResolver ConflictResolverFunc `json:"-"`
+	// DiskHealth sets the func to call every DiskHealthInterval to judge the
+	// health of the disks; the most severe action it returns is taken
+	// automatically. Defaults to nil, the disks' stats are only reported.
+	DiskHealth DiskHealthFunc `json:"-"`
+	// RecoveryCallback sets the func to call every RecoveryCallbackInterval
+	// while recovery loads the existing data at startup, and once more when it
+	// is done. Defaults to nil, no callback.
+	RecoveryCallback RecoveryCallbackFunc `json:"-"`
+	// CompactionFilter sets the func to call for each live entry compaction
+	// rewrites; an entry it returns false for is dropped instead, as a local
+	// removal, for application-level expiry or scrubbing without scans of
+	// their own. It may be called from several compaction workers at once.
+	// Defaults to nil, every live entry is kept.
+	CompactionFilter CompactionFilterFunc `json:"-"`
 	// Rand sets the rand.Rand to use as a random data source. Defaults to a
 	// new randomizer based on the current time.
-	Rand *rand.Rand
+	Rand *rand.Rand `json:"-"`
 	// Path sets the path where values files will be written; tocvalues files
 	// will also be written here unless overridden with PathTOC. Defaults to
 	// the current working directory.
@@ -45,6 +152,37 @@ type Config struct {
 	// PathTOC sets the path where tocvalues files will be written. Defaults to
 	// the Path value.
 	PathTOC string
+	// Paths sets several paths, usually each on its own disk, to spread the
+	// values files across, rather than putting RAID under the store; Path is
+	// then the first of them. Recovery finds the files wherever they are
+	// among the Paths and PathsTOC, so paths may be added to the list later.
+	// The environment variable is a list, as in PATH. Defaults to just Path.
+	Paths []string
+	// PathsTOC sets where the tocvalues files for the values files in each
+	// of the Paths will be written, the same length as Paths; PathTOC is
+	// then the first of them. Defaults to PathTOC for each of the Paths if
+	// PathTOC is set, otherwise to the Paths.
+	PathsTOC []string
+	// PathsPlacement names how each new values file picks from the Paths:
+	// "round-robin" takes each in turn; "free-space" takes the one with the
+	// most free space. Defaults to "round-robin".
+	PathsPlacement string
+	// PathsLimits sets the most bytes of values files to keep in each of the
+	// Paths, the same length as Paths, 0 being no limit. New values files
+	// only go to the Paths with room for a whole ValuesFileCap; with none,
+	// writes return ErrDiskFull, as with DiskFreeMinimum, until one has
+	// room again. The environment variable is a list, as in PATH. Defaults
+	// to no limits.
+	PathsLimits []int
+	// PathsRebalanceInterval indicates how many seconds between passes of
+	// the rebalancer, which, with PathsLimits, moves the oldest values files
+	// off any of the Paths over its limit by compacting them into the values
+	// files being written on the Paths with room; it is throttled as
+	// compaction is, by CompactionMaxBytesPerSec. Defaults to 60 seconds.
+	PathsRebalanceInterval int
+	// FileBackend sets where the values files are kept, such as object
+	// storage for cold data or memory for testing. Defaults to the local disk.
+	FileBackend FileBackend `json:"-"`
 	// ValueCap indicates the maximum number of bytes any given value may be.
 	// Defaults to 4,194,304 bytes.
 	ValueCap int
@@ -63,8 +201,18 @@ type Config struct {
 	// etc.). This will also have an impact on memory usage. Defaults to
 	// GOMAXPROCS.
 	Workers int
+	// BackgroundCPUPercent indicates how much of the Workers' CPU each
+	// background pass (compaction, tombstone discard, and outgoing pull and
+	// push replication) may use, so they don't crowd out foreground requests
+	// on machines with few cores. Each such pass uses no more than this
+	// percentage of Workers, but at least one, and each of its workers pauses
+	// between batches of work to be busy no more than this percentage of the
+	// time. Defaults to 100, no limit.
+	BackgroundCPUPercent int
 	// ChecksumInterval indicates how many bytes are output to a file before a
-	// 4-byte checksum is also output. Defaults to 65,532 bytes.
+	// 4-byte checksum is also output. Defaults to 65,532 bytes; it must be
+	// between 32 and 16,777,215 bytes. Each file records the interval it was
+	// written with, so this can be changed without affecting existing files.
 	ChecksumInterval int
 	// PageSize controls the size of each chunk of memory allocated. Defaults
 	// to 4,194,304 bytes.
@@ -76,26 +224,142 @@ type Config struct {
 	// ValueLocMap allows overriding the default ValueLocMap, an interface used
 	// by ValueStore for tracking the mappings from keys to the locations of
 	// their values. Defaults to github.com/gholt/valuelocmap.New().
-	ValueLocMap valuelocmap.ValueLocMap
+	ValueLocMap valuelocmap.ValueLocMap `json:"-"`
 	// MsgRing sets the ring.MsgRing to use for determining the key ranges the
 	// ValueStore is responsible for as well as providing methods to send
 	// messages to other nodes.
-	MsgRing ring.MsgRing
+	MsgRing ring.MsgRing `json:"-"`
 	// MsgCap indicates the maximum bytes for outgoing messages. Defaults to
 	// 16,777,216 bytes.
 	MsgCap int
 	// MsgTimeout indicates the maximum milliseconds a message can be pending
 	// before just discarding it. Defaults to 100 milliseconds.
 	MsgTimeout int
+	// MessageAuthKey, if set, has every message sent on the MsgRing and
+	// RemoteMsgRing carry an HMAC-SHA256 of its content with this key, and
+	// every message received without a matching one dropped, so only nodes
+	// holding the key can replicate writes in. All the nodes, and any
+	// cluster remote replication ships to, must be given the same key. From
+	// the environment it is given in hex. Defaults to none.
+	MessageAuthKey []byte `json:"-"`
+	// MsgPoolMinPercent indicates the percentage of their configured sizes
+	// (InBulkSetMsgs, OutBulkSetMsgs, etc.) the message pools start at and
+	// may shrink back down to when underused. Defaults to 100.
+	MsgPoolMinPercent int
+	// MsgPoolMaxPercent indicates the percentage of their configured sizes
+	// the message pools may grow to under load. Defaults to
+	// MsgPoolMinPercent, fixed size pools.
+	MsgPoolMaxPercent int
+	// MsgPoolInterval indicates how many seconds a message must sit unused in
+	// a message pool above its minimum size before it is discarded. Defaults
+	// to 60 seconds.
+	MsgPoolInterval int
 	// ValuesFileCap indicates how large a values file can be before closing it
-	// and opening a new one. Defaults to 4,294,967,295 bytes.
+	// and opening a new one. Defaults to 4,294,967,295 bytes. Larger caps,
+	// useful to limit the number of files on very large disks, write TOC files
+	// in a format with 64 bit offsets that older versions cannot read.
 	ValuesFileCap int
-	// ValuesFileReaders indicates how many open file descriptors are allowed
-	// per values file for reading. Defaults to Workers.
+	// StrictSync set true will fsync values files before any TOC entries
+	// referencing the newly written values are written, so that recovery
+	// after a power loss can't find TOC entries for values that never made it
+	// to disk. This costs a sync for each batch of values written. Defaults
+	// to false.
+	StrictSync bool
+	// DirectIO set true will write values files with O_DIRECT, bypassing the
+	// OS page cache, so the large sequential writes of new values and of
+	// compaction don't push out the pages that reads depend on. It needs
+	// Linux, the default FileBackend, no encryption, and a ChecksumInterval
+	// four less than a multiple of 4096; otherwise a warning is logged and
+	// values files are written as usual. Defaults to false.
+	DirectIO bool
+	// SyncPolicy names when writes are made durable: "never" leaves values
+	// buffered until a page fills or Flush is called, as usual; "interval"
+	// flushes, fsyncing the values and TOC files, every SyncInterval while
+	// there are new writes; "always" has Write, Delete, WriteBatch.Commit,
+	// and WriteStream wait for such a flush before returning, shared with
+	// whatever other writes are waiting. Each flush closes the files being
+	// written, so the stricter policies make many more, smaller files for
+	// compaction to tidy up. See also WriteDurable. Defaults to "never".
+	SyncPolicy string
+	// SyncInterval indicates how many milliseconds apart the "interval"
+	// SyncPolicy flushes. Defaults to 1000.
+	SyncInterval int
+	// Compression names the codec values are compressed with as they are
+	// written to values files: "none", "snappy", "lz4", or "zstd". Values that
+	// don't get smaller are stored as is. Values files written with any
+	// setting remain readable with any other, so this may be changed between
+	// restarts; see CompactionRecompress. Note that Lookup then gives the
+	// stored, compressed, length. Defaults to "none".
+	Compression string
+	// EncryptionKey, if set, is a 16, 24, or 32 byte AES key that new values
+	// and TOC files are encrypted with, using AES-GCM a checksum interval at
+	// a time. Each file records the EncryptionKeyID it was written with, so
+	// the key may be rotated by moving the old one into EncryptionKeys; files
+	// written with and without encryption may be mixed freely. See
+	// CompactionReencrypt. From the environment it is given in hex. Defaults
+	// to none.
+	EncryptionKey []byte `json:"-"`
+	// EncryptionKeyID identifies the EncryptionKey in the files written with
+	// it; 0 is reserved for unencrypted files. Defaults to 1.
+	EncryptionKeyID uint32
+	// EncryptionKeys holds the older keys, by ID, that existing files may
+	// still be encrypted with. Files with a key ID neither here nor that of
+	// EncryptionKey cannot be read, and are reported by Preflight.
+	EncryptionKeys map[uint32][]byte `json:"-"`
+	// PreflightStrict set true will have NewWithContext fail on any problem
+	// Preflight finds, such as mismatched values and TOC files or too little
+	// free space, rather than just logging warnings for those. Path problems
+	// always fail. Defaults to false.
+	PreflightStrict bool
+	// ValuesFileReaders indicates how many concurrent reads are allowed per
+	// values file, each through its own file descriptor. These descriptors
+	// are opened as needed and count against ReaderBudget. With
+	// ReaderTuneInterval set, this is just the starting point. Defaults to
+	// Workers.
 	ValuesFileReaders int
+	// ValuesFileReadersMax indicates the most concurrent reads per values
+	// file that reader tuning may allow. Defaults to four times
+	// ValuesFileReaders.
+	ValuesFileReadersMax int
+	// ReaderTuneInterval indicates how many milliseconds between adjustments
+	// of the concurrent reads allowed per values file, based on how long
+	// reads wait and take and on the device's queue. Use -1 to disable, which
+	// keeps ValuesFileReaders as is. Defaults to -1.
+	ReaderTuneInterval int
+	// ReaderQueueDepth indicates how many requests may be in flight on the
+	// device holding the values files before reader tuning backs off,
+	// allowing fewer concurrent reads. Only checked where the device's queue
+	// can be seen, currently just Linux. Defaults to 32.
+	ReaderQueueDepth int
+	// ReaderBudget indicates how many file descriptors may be open for
+	// reading values files, across all the values files; the least recently
+	// used are closed to make room for others. Defaults to half the process'
+	// open file limit, or 1024 if that can't be determined.
+	ReaderBudget int
+	// ReadCacheBytes indicates how many bytes of values read from the values
+	// files to keep in memory, least recently used dropped first, so hot
+	// values are served without a disk read. Defaults to 0, no cache.
+	ReadCacheBytes int
+	// RetainVersions indicates how many versions of each key, the current one
+	// included, to keep; the older ones are listed by ListVersions and read
+	// with ReadVersion. Older versions are kept as newer ones replace them,
+	// not when they arrive out of order, and are dropped when the key is
+	// handed off or its deletion marker expires. Their locations are kept in
+	// memory, in addition to the location map, and compaction rewrites them
+	// with the current values. Only the current version replicates. Defaults
+	// to 1, only the current version.
+	RetainVersions int
 	// RecoveryBatchSize indicates how many keys to set in a batch while
 	// performing recovery (initial start up). Defaults to 1,048,576 keys.
 	RecoveryBatchSize int
+	// RecoveryDropPageCache set true will have recovery advise the OS to drop
+	// its cached pages of each file once recovery has read it, so the one-pass
+	// read at start up doesn't leave the page cache full of cold data.
+	// Defaults to false.
+	RecoveryDropPageCache bool
+	// RecoveryCallbackInterval indicates how many milliseconds apart the
+	// calls to RecoveryCallback are. Defaults to 1000.
+	RecoveryCallbackInterval int
 	// TombstoneDiscardInterval overrides the BackgroundInterval value just for
 	// discard passes (discarding expired tombstones [deletion markers]).
 	TombstoneDiscardInterval int
@@ -106,9 +370,30 @@ type Config struct {
 	// TombstoneAge indicates how many seconds old a deletion marker may be
 	// before it is permanently removed. Defaults to 14,400 seconds (4 hours).
 	TombstoneAge int
+	// TombstoneDiscardAcked set true will keep a deletion marker past
+	// TombstoneAge until every other replica has acknowledged it, so a replica
+	// offline for longer than TombstoneAge can't bring the deleted value back
+	// when it returns. Each discard pass sends the expired markers on to the
+	// replicas yet to acknowledge them, and push replication keeps handing off
+	// markers however old. Has no effect without a MsgRing. Defaults to false.
+	TombstoneDiscardAcked bool
 	// ReplicationIgnoreRecent indicates how many seconds old a value should be
 	// before it is included in replication processing. Defaults to 60 seconds.
 	ReplicationIgnoreRecent int
+	// MaxFutureTimestamp indicates how many seconds ahead of the local clock
+	// the timestamp given to Write or Delete may be before the call is
+	// rejected with a *TimestampError. Defaults to 0, no limit.
+	MaxFutureTimestamp int
+	// MaxPastTimestamp indicates how many seconds behind the local clock the
+	// timestamp given to Write or Delete may be before the call is rejected
+	// with a *TimestampError. Defaults to 0, no limit.
+	MaxPastTimestamp int
+	// MonotonicWrites set true will have Write raise any timestampmicro not
+	// newer than the one already stored for the key to one microsecond past
+	// it, and return the timestampmicro actually used rather than the
+	// previous one. This is meant for applications with a single writer per
+	// key; concurrent writers to the same key can still override each other.
+	MonotonicWrites bool
 	// OutPullReplicationInterval overrides the BackgroundInterval value just
 	// for outgoing pull replication passes.
 	OutPullReplicationInterval int
@@ -133,6 +418,22 @@ type Config struct {
 	// outgoing pull replication message can be pending before just discarding
 	// it. Defaults to MsgTimeout.
 	OutPullReplicationMsgTimeout int
+	// OutPullReplicationMode names how outgoing pull replication describes
+	// what the local node has: "bloom" sends a bloom filter of the items in
+	// each range, "merkle" sends a level of a hash tree over them and only
+	// descends into subranges other replicas find differ, which costs far
+	// less on partitions that are mostly in sync. Nodes handle incoming
+	// requests of either kind regardless. Defaults to "bloom".
+	OutPullReplicationMode string
+	// PullReplicationFilter names the filter outgoing "bloom" mode pull
+	// replication requests describe the local node's items with: "bloom", or
+	// "cuckoo" for a cuckoo filter, which for the same
+	// OutPullReplicationBloomN and OutPullReplicationBloomP is about the same
+	// size, has a false positive rate at or often well under the P-factor,
+	// and supports removing entries. Each request says which it carries, but nodes from before this
+	// option only understand "bloom", so choose "cuckoo" only once every node
+	// handles both. Defaults to "bloom".
+	PullReplicationFilter string
 	// InPullReplicationWorkers indicates how many incoming pull-replication
 	// messages can be processed at the same time. Defaults to Workers.
 	InPullReplicationWorkers int
@@ -144,6 +445,11 @@ type Config struct {
 	// an outgoing response message to an incoming pull replication message can
 	// be pending before just discarding it. Defaults to MsgTimeout.
 	InPullReplicationResponseMsgTimeout int
+	// InPullReplicationResponseMsgs indicates the most bulk-set messages,
+	// each of up to BulkSetMsgCap bytes, an incoming pull-replication message
+	// may be answered with, so a replica far out of sync can catch up in a
+	// pass or two rather than one message's worth at a time. Defaults to 16.
+	InPullReplicationResponseMsgs int
 	// OutPushReplicationInterval overrides the BackgroundInterval value just
 	// for outgoing push replication passes.
 	OutPushReplicationInterval int
@@ -158,6 +464,122 @@ type Config struct {
 	// outgoing push replication message can be pending before just discarding
 	// it. Defaults to MsgTimeout.
 	OutPushReplicationMsgTimeout int
+	// OutPushReplicationAckPolicy indicates what the receivers of outgoing
+	// push replication bulk-set messages should ack: BULK_SET_ACK_APPLIED,
+	// BULK_SET_ACK_ALL, or BULK_SET_ACK_NONE. Note that with
+	// BULK_SET_ACK_NONE the pushed data will never be removed locally.
+	// Defaults to BULK_SET_ACK_APPLIED.
+	OutPushReplicationAckPolicy int
+	// OutPushReplicationBacklog indicates how many keys sent by push
+	// replication but not yet acked will be remembered, and saved to
+	// PathTOC, so they can be resent right after a restart. Use -1 to
+	// disable. Defaults to 65536.
+	OutPushReplicationBacklog int
+	// OutPushReplicationBacklogInterval indicates how many seconds between
+	// saves of the push replication backlog. Defaults to
+	// OutPushReplicationInterval.
+	OutPushReplicationBacklogInterval int
+	// HandoffInterval indicates how many milliseconds apart to check whether
+	// the MsgRing's ring has changed, handing off any partitions this node is
+	// no longer responsible for; see also ValueStore.RingChanged. Defaults to
+	// 1000.
+	HandoffInterval int
+	// RemoteMsgRing sets the ring.MsgRing of another cluster, on its own
+	// ring, that local writes should be shipped to asynchronously, such as a
+	// disaster recovery copy in another datacenter. Defaults to nil, no
+	// remote replication.
+	RemoteMsgRing ring.MsgRing `json:"-"`
+	// RemoteReplicationInterval indicates the maximum milliseconds between
+	// shipping batches of writes to the RemoteMsgRing. Defaults to 1000.
+	RemoteReplicationInterval int
+	// RemoteReplicationBatch indicates how many keys waiting to be shipped to
+	// the RemoteMsgRing will cause a batch to be shipped before
+	// RemoteReplicationInterval is up. Defaults to 1024.
+	RemoteReplicationBatch int
+	// RemoteReplicationBacklog indicates how many keys can wait to be shipped
+	// to the RemoteMsgRing; past that, the next batch scans for the keys
+	// written since the last one instead. Defaults to 65536.
+	RemoteReplicationBacklog int
+	// RemoteReplicationMsgTimeout indicates the maximum milliseconds a
+	// message to the RemoteMsgRing can be pending before just discarding it.
+	// Defaults to MsgTimeout.
+	RemoteReplicationMsgTimeout int
+	// RemoteReplicationCompression names the codec, one of those Compression
+	// may name, the values shipped to the RemoteMsgRing are compressed with,
+	// saving bandwidth between datacenters at the cost of some CPU on each
+	// end. The remote cluster must be running a version that understands
+	// compressed bulk-set messages. Defaults to "none".
+	RemoteReplicationCompression string
+	// ReplicationCompression names the codec, one of those Compression may
+	// name, the values in pull replication responses, push replication, and
+	// handoff are compressed with, as are the filters pull-replication
+	// messages carry, cutting bandwidth between nodes at the cost of some CPU
+	// on each end. Messages are flagged as compressed, and incoming messages
+	// are handled either way, but other nodes must be running a version that
+	// understands them. Defaults to "none".
+	ReplicationCompression string
+	// RemoteReplicationMaxBytesPerSec indicates the maximum rate, in bytes of
+	// bulk-set messages, writes are shipped to the RemoteMsgRing at, keeping
+	// the link between datacenters from being swamped, such as while catching
+	// up after an outage. Defaults to 0, no limit.
+	RemoteReplicationMaxBytesPerSec int
+	// RemoteReadFallback set true will have Read, when a value fails to read
+	// locally, such as from a checksum mismatch, ask the other replicas for
+	// it and return their copy instead of the error, also repairing the local
+	// copy. A values file missing when the store is opened is then skipped
+	// rather than being a *RecoveryError, with the other replicas restoring
+	// what it held. Defaults to false.
+	RemoteReadFallback bool
+	// ReadRepair set true will have Read ask the other replicas whether they
+	// have anything newer for the key than the local copy. If the key was
+	// not found locally, Read waits for an answer and returns that instead;
+	// otherwise the local value is returned right away and a newer one just
+	// repairs the local copy for later reads. This closes the window between
+	// replication passes for hot keys, at the cost of a message to each
+	// other replica for every Read. Defaults to false.
+	ReadRepair bool
+	// RemoteReadTimeout indicates the maximum milliseconds Read will wait for
+	// another replica to send back a value due to RemoteReadFallback or
+	// ReadRepair. Defaults to MsgTimeout.
+	RemoteReadTimeout int
+	// Quorum indicates how many replicas, counting the local node if it is
+	// one, must answer ReadQuorum or store the value for WriteQuorum before
+	// they succeed. It is capped to the ring's replica count. Defaults to 0,
+	// meaning a majority of the ring's replica count.
+	Quorum int
+	// QuorumTimeout indicates the maximum milliseconds ReadQuorum and
+	// WriteQuorum will wait for the other replicas. Defaults to MsgTimeout.
+	QuorumTimeout int
+	// ErasureDataShards indicates how many data shards WriteErasure splits
+	// a value into, with ErasureParityShards parity shards computed from
+	// them, each stored under a key of its own; any ErasureDataShards of the
+	// shards are enough to rebuild the value. Defaults to 0, storing values
+	// whole.
+	ErasureDataShards int
+	// ErasureParityShards indicates how many parity shards WriteErasure
+	// computes; that many shards can be lost without losing the value. The
+	// data and parity shards together are capped to 255. Defaults to 2.
+	ErasureParityShards int
+	// ErasureMinLength indicates the shortest value WriteErasure splits into
+	// shards; shorter ones are stored whole. Defaults to 65536.
+	ErasureMinLength int
+	// DiskHealthInterval indicates how many seconds between calls to
+	// DiskHealth. Defaults to 60 seconds.
+	DiskHealthInterval int
+	// DiskFreeMinimum indicates how many bytes must stay free on the
+	// filesystem holding Path; below that, Write, WriteBatch.Commit, and
+	// WriteStream return ErrDiskFull, while reads, deletes, compaction, and
+	// the background passes carry on. Defaults to 0, free space is not
+	// checked.
+	DiskFreeMinimum int
+	// DiskFreeHysteresis indicates how many bytes beyond DiskFreeMinimum must
+	// be free before writes are accepted again, so they don't flap on and off
+	// around the minimum. Defaults to a tenth of DiskFreeMinimum.
+	DiskFreeHysteresis int
+	// DiskFreeInterval indicates how many milliseconds between checks of the
+	// free space, and between retries of a values or TOC file write that
+	// failed for lack of space. Defaults to 1000 milliseconds.
+	DiskFreeInterval int
 	// BulkSetMsgCap indicates the maximum bytes for bulk-set messages.
 	// Defaults to MsgCap.
 	BulkSetMsgCap int
@@ -168,10 +590,24 @@ type Config struct {
 	// InBulkSetWorkers indicates how many incoming bulk-set messages can be
 	// processed at the same time. Defaults to Workers.
 	InBulkSetWorkers int
+	// InBulkSetPriorityWorkers indicates how many incoming backfill bulk-set
+	// messages, from handoffs and repairs, can be processed at the same time.
+	// These are in addition to the InBulkSetWorkers, which process the
+	// routine bulk-set messages. Defaults to InBulkSetWorkers / 4, with a
+	// minimum of 1.
+	InBulkSetPriorityWorkers int
 	// InBulkSetMsgs indicates how many incoming bulk-set messages can be
 	// buffered before dropping additional ones. Defaults to InBulkSetWorkers *
 	// 4.
 	InBulkSetMsgs int
+	// InBulkSetPeerMsgs indicates how many of the InBulkSetMsgs a single
+	// sending node may be using at a time before additional ones from that
+	// node are dropped. Defaults to InBulkSetMsgs / 2.
+	InBulkSetPeerMsgs int
+	// InBulkSetPeerBytesPerSec indicates the maximum rate of incoming
+	// bulk-set bytes accepted from a single sending node before additional
+	// messages from that node are dropped. Defaults to 0, no limit.
+	InBulkSetPeerBytesPerSec int
 	// InBulkSetResponseMsgTimeout indicates the maximum milliseconds a
 	// response message to an incoming bulk-set message can be pending before
 	// just discarding it. Defaults to MsgTimeout.
@@ -190,6 +626,18 @@ type Config struct {
 	// be buffered before blocking on creating more. Defaults to
 	// InBulkSetWorkers * 4.
 	OutBulkSetAckMsgs int
+	// OutBulkSetAckDelay indicates how many milliseconds an outgoing
+	// bulk-set-ack message may be held so that acks for additional incoming
+	// bulk-set messages from the same node can be sent in the same message.
+	// Defaults to 0, sending each ack immediately.
+	OutBulkSetAckDelay int
+	// OutPeerMsgWindow indicates how many outgoing replication messages
+	// (pull-replication, bulk-set, and bulk-set-ack) may be outstanding to any
+	// one peer node at a time. Additional messages for that node are skipped
+	// until earlier ones are sent or time out, or, for bulk-set messages that
+	// ask for acks, are acked, keeping a slow peer from using up the outgoing
+	// message pools. Defaults to Workers.
+	OutPeerMsgWindow int
 	// CompactionInterval overrides the BackgroundInterval value just for
 	// compaction passes.
 	CompactionInterval int
@@ -202,12 +650,59 @@ type Config struct {
 	// CompactionAgeThreshold indicates how old a given file must be before it
 	// is considered for compaction. Defaults to 300 seconds.
 	CompactionAgeThreshold int
+	// CompactionDropPageCache set true will have compaction advise the OS to
+	// drop its cached pages of each file once compaction has read it, so
+	// compaction doesn't push the data regular reads use out of the page
+	// cache. Defaults to false.
+	CompactionDropPageCache bool
+	// CompactionRecompress set true will have compaction also rewrite any
+	// values file, old enough to be considered, that was written with a
+	// Compression other than the current one, so its values are stored with
+	// the current codec. Defaults to false.
+	CompactionRecompress bool
+	// CompactionReencrypt set true will have compaction also rewrite any
+	// values file, old enough to be considered, that was written with an
+	// encryption key other than the current EncryptionKey, or without one
+	// while there is one, so old keys may be retired. Defaults to false.
+	CompactionReencrypt bool
+	// CompactionMaxBytesPerSec indicates the maximum rate, across all the
+	// compaction workers, at which compaction rewrites value bytes, so it
+	// leaves the disks room for foreground reads; see
+	// SetCompactionMaxBytesPerSec. Defaults to 0, no limit.
+	CompactionMaxBytesPerSec int
+}
+
+// copy returns a copy of the Config that shares none of its slices or maps,
+// so that neither copy's Paths or keys change with the other's.
+func (c *Config) copy() *Config {
+	cfg := *c
+	cfg.Paths = append([]string(nil), c.Paths...)
+	cfg.PathsTOC = append([]string(nil), c.PathsTOC...)
+	cfg.PathsLimits = append([]int(nil), c.PathsLimits...)
+	cfg.MessageAuthKey = append([]byte(nil), c.MessageAuthKey...)
+	cfg.EncryptionKey = append([]byte(nil), c.EncryptionKey...)
+	if c.EncryptionKeys != nil {
+		cfg.EncryptionKeys = make(map[uint32][]byte, len(c.EncryptionKeys))
+		for id, key := range c.EncryptionKeys {
+			cfg.EncryptionKeys[id] = append([]byte(nil), key...)
+		}
+	}
+	return &cfg
 }
 
 func resolveConfig(c *Config) *Config {
 	cfg := &Config{}
 	if c != nil {
-		*cfg = *c
+		cfg = c.copy()
+	}
+	if cfg.EnvPrefix == "" {
+		cfg.EnvPrefix = "VALUESTORE_"
+	}
+	getenv := func(name string) string {
+		if cfg.IgnoreEnv {
+			return ""
+		}
+		return os.Getenv(cfg.EnvPrefix + name)
 	}
 	if cfg.LogCritical == nil {
 		cfg.LogCritical = log.New(os.Stderr, "ValueStore ", log.LstdFlags).Printf
@@ -225,19 +720,94 @@ func resolveConfig(c *Config) *Config {
 	if cfg.Rand == nil {
 		cfg.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	}
-	if env := os.Getenv("VALUESTORE_PATH"); env != "" {
+	if env := getenv("PATH"); env != "" {
 		cfg.Path = env
 	}
-	if cfg.Path == "" {
-		cfg.Path = "."
+	if env := getenv("PATHS"); env != "" {
+		cfg.Paths = filepath.SplitList(env)
 	}
-	if env := os.Getenv("VALUESTORE_PATH_TOC"); env != "" {
+	if len(cfg.Paths) == 0 {
+		if cfg.Path == "" {
+			cfg.Path = "."
+		}
+		cfg.Paths = []string{cfg.Path}
+	}
+	cfg.Path = cfg.Paths[0]
+	if env := getenv("PATH_TOC"); env != "" {
 		cfg.PathTOC = env
 	}
-	if cfg.PathTOC == "" {
-		cfg.PathTOC = cfg.Path
+	if env := getenv("PATHS_TOC"); env != "" {
+		cfg.PathsTOC = filepath.SplitList(env)
 	}
-	if env := os.Getenv("VALUESTORE_VALUE_CAP"); env != "" {
+	if len(cfg.PathsTOC) == 0 {
+		cfg.PathsTOC = make([]string, len(cfg.Paths))
+		for i := range cfg.PathsTOC {
+			cfg.PathsTOC[i] = cfg.PathTOC
+			if cfg.PathTOC == "" {
+				cfg.PathsTOC[i] = cfg.Paths[i]
+			}
+		}
+	}
+	if len(cfg.PathsTOC) != len(cfg.Paths) {
+		cfg.LogWarning("%d PathsTOC for %d Paths, using the Paths\n", len(cfg.PathsTOC), len(cfg.Paths))
+		cfg.PathsTOC = cfg.Paths
+	}
+	cfg.PathTOC = cfg.PathsTOC[0]
+	if env := getenv("PATHS_PLACEMENT"); env != "" {
+		cfg.PathsPlacement = env
+	}
+	cfg.PathsPlacement = strings.ToLower(cfg.PathsPlacement)
+	if cfg.PathsPlacement == "" {
+		cfg.PathsPlacement = "round-robin"
+	}
+	if _, ok := pathsPlacements[cfg.PathsPlacement]; !ok {
+		cfg.LogWarning("unknown PathsPlacement %q, using round-robin\n", cfg.PathsPlacement)
+		cfg.PathsPlacement = "round-robin"
+	}
+	if env := getenv("PATHS_LIMITS"); env != "" {
+		cfg.PathsLimits = nil
+		for _, s := range filepath.SplitList(env) {
+			val, err := strconv.Atoi(s)
+			if err != nil {
+				cfg.LogWarning("invalid PathsLimits %q, using no limits\n", env)
+				cfg.PathsLimits = nil
+				break
+			}
+			cfg.PathsLimits = append(cfg.PathsLimits, val)
+		}
+	}
+	if len(cfg.PathsLimits) != 0 && len(cfg.PathsLimits) != len(cfg.Paths) {
+		cfg.LogWarning("%d PathsLimits for %d Paths, using no limits\n", len(cfg.PathsLimits), len(cfg.Paths))
+		cfg.PathsLimits = nil
+	}
+	for i := range cfg.PathsLimits {
+		if cfg.PathsLimits[i] < 0 {
+			cfg.PathsLimits[i] = 0
+		}
+	}
+	if env := getenv("PATHS_REBALANCE_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.PathsRebalanceInterval = val
+		}
+	}
+	if cfg.PathsRebalanceInterval == 0 {
+		cfg.PathsRebalanceInterval = 60
+	}
+	if cfg.PathsRebalanceInterval < 1 {
+		cfg.PathsRebalanceInterval = 1
+	}
+	if cfg.FileBackend == nil {
+		cfg.FileBackend = osFileBackend{}
+	}
+	if env := getenv("AUTO_TUNE"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.AutoTune = val != 0
+		}
+	}
+	if cfg.AutoTune {
+		autoTune(cfg, detectHardware(cfg.Path))
+	}
+	if env := getenv("VALUE_CAP"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.ValueCap = val
 		}
@@ -251,7 +821,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.ValueCap > math.MaxUint32 {
 		cfg.ValueCap = math.MaxUint32
 	}
-	if env := os.Getenv("VALUESTORE_BACKGROUND_INTERVAL"); env != "" {
+	if env := getenv("BACKGROUND_INTERVAL"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.BackgroundInterval = val
 		}
@@ -262,7 +832,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.BackgroundInterval < 1 {
 		cfg.BackgroundInterval = 1
 	}
-	if env := os.Getenv("VALUESTORE_WORKERS"); env != "" {
+	if env := getenv("WORKERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.Workers = val
 		}
@@ -273,7 +843,21 @@ func resolveConfig(c *Config) *Config {
 	if cfg.Workers < 1 {
 		cfg.Workers = 1
 	}
-	if env := os.Getenv("VALUESTORE_CHECKSUM_INTERVAL"); env != "" {
+	if env := getenv("BACKGROUND_CPU_PERCENT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.BackgroundCPUPercent = val
+		}
+	}
+	if cfg.BackgroundCPUPercent == 0 {
+		cfg.BackgroundCPUPercent = 100
+	}
+	if cfg.BackgroundCPUPercent < 1 {
+		cfg.BackgroundCPUPercent = 1
+	}
+	if cfg.BackgroundCPUPercent > 100 {
+		cfg.BackgroundCPUPercent = 100
+	}
+	if env := getenv("CHECKSUM_INTERVAL"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.ChecksumInterval = val
 		}
@@ -281,10 +865,13 @@ func resolveConfig(c *Config) *Config {
 	if cfg.ChecksumInterval == 0 {
 		cfg.ChecksumInterval = 64*1024 - 4
 	}
-	if cfg.ChecksumInterval < 1 {
-		cfg.ChecksumInterval = 1
+	if cfg.ChecksumInterval < 32 {
+		cfg.ChecksumInterval = 32
 	}
-	if env := os.Getenv("VALUESTORE_PAGE_SIZE"); env != "" {
+	if cfg.ChecksumInterval > _CHECKSUM_INTERVAL_MAX {
+		cfg.ChecksumInterval = _CHECKSUM_INTERVAL_MAX
+	}
+	if env := getenv("PAGE_SIZE"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.PageSize = val
 		}
@@ -312,7 +899,7 @@ func resolveConfig(c *Config) *Config {
 	// flush the previous page's data.
 	// TODO: Make the 32 a const
 	cfg.minValueAlloc = cfg.ChecksumInterval/(cfg.PageSize/32+1) + 1
-	if env := os.Getenv("VALUESTORE_WRITE_PAGES_PER_WORKER"); env != "" {
+	if env := getenv("WRITE_PAGES_PER_WORKER"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.WritePagesPerWorker = val
 		}
@@ -323,7 +910,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.WritePagesPerWorker < 2 {
 		cfg.WritePagesPerWorker = 2
 	}
-	if env := os.Getenv("VALUESTORE_MSG_CAP"); env != "" {
+	if env := getenv("MSG_CAP"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.MsgCap = val
 		}
@@ -335,7 +922,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.MsgCap < 1 {
 		cfg.MsgCap = 1
 	}
-	if env := os.Getenv("VALUESTORE_MSG_TIMEOUT"); env != "" {
+	if env := getenv("MSG_TIMEOUT"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.MsgTimeout = val
 		}
@@ -346,7 +933,42 @@ func resolveConfig(c *Config) *Config {
 	if cfg.MsgTimeout < 1 {
 		cfg.MsgTimeout = 100
 	}
-	if env := os.Getenv("VALUESTORE_VALUES_FILE_CAP"); env != "" {
+	if env := getenv("MESSAGE_AUTH_KEY"); env != "" {
+		if val, err := hex.DecodeString(env); err == nil {
+			cfg.MessageAuthKey = val
+		}
+	}
+	if env := getenv("MSG_POOL_MIN_PERCENT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.MsgPoolMinPercent = val
+		}
+	}
+	if cfg.MsgPoolMinPercent == 0 {
+		cfg.MsgPoolMinPercent = 100
+	}
+	if cfg.MsgPoolMinPercent < 1 {
+		cfg.MsgPoolMinPercent = 1
+	}
+	if env := getenv("MSG_POOL_MAX_PERCENT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.MsgPoolMaxPercent = val
+		}
+	}
+	if cfg.MsgPoolMaxPercent < cfg.MsgPoolMinPercent {
+		cfg.MsgPoolMaxPercent = cfg.MsgPoolMinPercent
+	}
+	if env := getenv("MSG_POOL_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.MsgPoolInterval = val
+		}
+	}
+	if cfg.MsgPoolInterval == 0 {
+		cfg.MsgPoolInterval = 60
+	}
+	if cfg.MsgPoolInterval < 1 {
+		cfg.MsgPoolInterval = 1
+	}
+	if env := getenv("VALUES_FILE_CAP"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.ValuesFileCap = val
 		}
@@ -358,10 +980,68 @@ func resolveConfig(c *Config) *Config {
 	if cfg.ValuesFileCap < 48+cfg.ValueCap { // header value trailer
 		cfg.ValuesFileCap = 48 + cfg.ValueCap
 	}
-	if cfg.ValuesFileCap > math.MaxUint32 {
-		cfg.ValuesFileCap = math.MaxUint32
+	if env := getenv("PREFLIGHT_STRICT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.PreflightStrict = val != 0
+		}
+	}
+	if env := getenv("STRICT_SYNC"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.StrictSync = val != 0
+		}
+	}
+	if env := getenv("DIRECT_IO"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.DirectIO = val != 0
+		}
+	}
+	if env := getenv("SYNC_POLICY"); env != "" {
+		cfg.SyncPolicy = env
+	}
+	cfg.SyncPolicy = strings.ToLower(cfg.SyncPolicy)
+	if cfg.SyncPolicy == "" {
+		cfg.SyncPolicy = "never"
+	}
+	if _, ok := syncPolicies[cfg.SyncPolicy]; !ok {
+		cfg.LogWarning("unknown SyncPolicy %q, using never\n", cfg.SyncPolicy)
+		cfg.SyncPolicy = "never"
+	}
+	if env := getenv("SYNC_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.SyncInterval = val
+		}
+	}
+	if cfg.SyncInterval == 0 {
+		cfg.SyncInterval = 1000
+	}
+	if cfg.SyncInterval < 1 {
+		cfg.SyncInterval = 1
+	}
+	if env := getenv("COMPRESSION"); env != "" {
+		cfg.Compression = env
+	}
+	cfg.Compression = strings.ToLower(cfg.Compression)
+	if cfg.Compression == "" {
+		cfg.Compression = "none"
+	}
+	if _, ok := compressionCodecs[cfg.Compression]; !ok {
+		cfg.LogWarning("unknown Compression %q, using none\n", cfg.Compression)
+		cfg.Compression = "none"
+	}
+	if env := getenv("ENCRYPTION_KEY"); env != "" {
+		if val, err := hex.DecodeString(env); err == nil {
+			cfg.EncryptionKey = val
+		}
+	}
+	if env := getenv("ENCRYPTION_KEY_ID"); env != "" {
+		if val, err := strconv.ParseUint(env, 10, 32); err == nil {
+			cfg.EncryptionKeyID = uint32(val)
+		}
 	}
-	if env := os.Getenv("VALUESTORE_VALUES_FILE_READERS"); env != "" {
+	if cfg.EncryptionKeyID == 0 {
+		cfg.EncryptionKeyID = 1
+	}
+	if env := getenv("VALUES_FILE_READERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.ValuesFileReaders = val
 		}
@@ -372,7 +1052,70 @@ func resolveConfig(c *Config) *Config {
 	if cfg.ValuesFileReaders < 1 {
 		cfg.ValuesFileReaders = 1
 	}
-	if env := os.Getenv("VALUESTORE_RECOVERY_BATCH_SIZE"); env != "" {
+	if env := getenv("VALUES_FILE_READERS_MAX"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ValuesFileReadersMax = val
+		}
+	}
+	if cfg.ValuesFileReadersMax == 0 {
+		cfg.ValuesFileReadersMax = cfg.ValuesFileReaders * 4
+	}
+	if cfg.ValuesFileReadersMax < cfg.ValuesFileReaders {
+		cfg.ValuesFileReadersMax = cfg.ValuesFileReaders
+	}
+	if env := getenv("READER_TUNE_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ReaderTuneInterval = val
+		}
+	}
+	if cfg.ReaderTuneInterval == 0 {
+		cfg.ReaderTuneInterval = -1
+	}
+	if cfg.ReaderTuneInterval < -1 {
+		cfg.ReaderTuneInterval = -1
+	}
+	if env := getenv("READER_QUEUE_DEPTH"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ReaderQueueDepth = val
+		}
+	}
+	if cfg.ReaderQueueDepth == 0 {
+		cfg.ReaderQueueDepth = 32
+	}
+	if cfg.ReaderQueueDepth < 1 {
+		cfg.ReaderQueueDepth = 1
+	}
+	if env := getenv("READER_BUDGET"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ReaderBudget = val
+		}
+	}
+	if cfg.ReaderBudget == 0 {
+		cfg.ReaderBudget = int(detectFileLimit() / 2)
+	}
+	if cfg.ReaderBudget == 0 {
+		cfg.ReaderBudget = 1024
+	}
+	if cfg.ReaderBudget < 1 {
+		cfg.ReaderBudget = 1
+	}
+	if env := getenv("READ_CACHE_BYTES"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ReadCacheBytes = val
+		}
+	}
+	if cfg.ReadCacheBytes < 0 {
+		cfg.ReadCacheBytes = 0
+	}
+	if env := getenv("RETAIN_VERSIONS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RetainVersions = val
+		}
+	}
+	if cfg.RetainVersions < 1 {
+		cfg.RetainVersions = 1
+	}
+	if env := getenv("RECOVERY_BATCH_SIZE"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.RecoveryBatchSize = val
 		}
@@ -383,7 +1126,23 @@ func resolveConfig(c *Config) *Config {
 	if cfg.RecoveryBatchSize < 1 {
 		cfg.RecoveryBatchSize = 1
 	}
-	if env := os.Getenv("VALUESTORE_TOMBSTONE_DISCARD_INTERVAL"); env != "" {
+	if env := getenv("RECOVERY_DROP_PAGE_CACHE"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RecoveryDropPageCache = val != 0
+		}
+	}
+	if env := getenv("RECOVERY_CALLBACK_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RecoveryCallbackInterval = val
+		}
+	}
+	if cfg.RecoveryCallbackInterval == 0 {
+		cfg.RecoveryCallbackInterval = 1000
+	}
+	if cfg.RecoveryCallbackInterval < 1 {
+		cfg.RecoveryCallbackInterval = 1
+	}
+	if env := getenv("TOMBSTONE_DISCARD_INTERVAL"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.TombstoneDiscardInterval = val
 		}
@@ -394,7 +1153,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.TombstoneDiscardInterval < 1 {
 		cfg.TombstoneDiscardInterval = 1
 	}
-	if env := os.Getenv("VALUESTORE_TOMBSTONE_DISCARD_BATCH_SIZE"); env != "" {
+	if env := getenv("TOMBSTONE_DISCARD_BATCH_SIZE"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.TombstoneDiscardBatchSize = val
 		}
@@ -405,7 +1164,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.TombstoneDiscardBatchSize < 1 {
 		cfg.TombstoneDiscardBatchSize = 1
 	}
-	if env := os.Getenv("VALUESTORE_TOMBSTONE_AGE"); env != "" {
+	if env := getenv("TOMBSTONE_AGE"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.TombstoneAge = val
 		}
@@ -416,7 +1175,12 @@ func resolveConfig(c *Config) *Config {
 	if cfg.TombstoneAge < 0 {
 		cfg.TombstoneAge = 0
 	}
-	if env := os.Getenv("VALUESTORE_REPLICATION_IGNORE_RECENT"); env != "" {
+	if env := getenv("TOMBSTONE_DISCARD_ACKED"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.TombstoneDiscardAcked = val != 0
+		}
+	}
+	if env := getenv("REPLICATION_IGNORE_RECENT"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.ReplicationIgnoreRecent = val
 		}
@@ -427,7 +1191,28 @@ func resolveConfig(c *Config) *Config {
 	if cfg.ReplicationIgnoreRecent < 0 {
 		cfg.ReplicationIgnoreRecent = 0
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PULL_REPLICATION_INTERVAL"); env != "" {
+	if env := getenv("MAX_FUTURE_TIMESTAMP"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.MaxFutureTimestamp = val
+		}
+	}
+	if cfg.MaxFutureTimestamp < 0 {
+		cfg.MaxFutureTimestamp = 0
+	}
+	if env := getenv("MAX_PAST_TIMESTAMP"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.MaxPastTimestamp = val
+		}
+	}
+	if cfg.MaxPastTimestamp < 0 {
+		cfg.MaxPastTimestamp = 0
+	}
+	if env := getenv("MONOTONIC_WRITES"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.MonotonicWrites = val != 0
+		}
+	}
+	if env := getenv("OUT_PULL_REPLICATION_INTERVAL"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPullReplicationInterval = val
 		}
@@ -438,7 +1223,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPullReplicationInterval < 1 {
 		cfg.OutPullReplicationInterval = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PULL_REPLICATION_WORKERS"); env != "" {
+	if env := getenv("OUT_PULL_REPLICATION_WORKERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPullReplicationWorkers = val
 		}
@@ -449,7 +1234,10 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPullReplicationWorkers < 1 {
 		cfg.OutPullReplicationWorkers = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PULL_REPLICATION_MSGS"); env != "" {
+	if cfg.OutPullReplicationWorkers > backgroundWorkerCap(cfg) {
+		cfg.OutPullReplicationWorkers = backgroundWorkerCap(cfg)
+	}
+	if env := getenv("OUT_PULL_REPLICATION_MSGS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPullReplicationMsgs = val
 		}
@@ -460,7 +1248,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPullReplicationMsgs < 1 {
 		cfg.OutPullReplicationMsgs = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PULL_REPLICATION_BLOOM_N"); env != "" {
+	if env := getenv("OUT_PULL_REPLICATION_BLOOM_N"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPullReplicationBloomN = val
 		}
@@ -471,7 +1259,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPullReplicationBloomN < 1 {
 		cfg.OutPullReplicationBloomN = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PULL_REPLICATION_BLOOM_P"); env != "" {
+	if env := getenv("OUT_PULL_REPLICATION_BLOOM_P"); env != "" {
 		if val, err := strconv.ParseFloat(env, 64); err == nil {
 			cfg.OutPullReplicationBloomP = val
 		}
@@ -482,7 +1270,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPullReplicationBloomP < 0.000001 {
 		cfg.OutPullReplicationBloomP = 0.000001
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PULL_REPLICATION_MSG_TIMEOUT"); env != "" {
+	if env := getenv("OUT_PULL_REPLICATION_MSG_TIMEOUT"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPullReplicationMsgTimeout = val
 		}
@@ -493,7 +1281,29 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPullReplicationMsgTimeout < 1 {
 		cfg.OutPullReplicationMsgTimeout = 100
 	}
-	if env := os.Getenv("VALUESTORE_IN_PULL_REPLICATION_WORKERS"); env != "" {
+	if env := getenv("OUT_PULL_REPLICATION_MODE"); env != "" {
+		cfg.OutPullReplicationMode = env
+	}
+	cfg.OutPullReplicationMode = strings.ToLower(cfg.OutPullReplicationMode)
+	if cfg.OutPullReplicationMode == "" {
+		cfg.OutPullReplicationMode = "bloom"
+	}
+	if cfg.OutPullReplicationMode != "bloom" && cfg.OutPullReplicationMode != "merkle" {
+		cfg.LogWarning("unknown OutPullReplicationMode %q, using bloom\n", cfg.OutPullReplicationMode)
+		cfg.OutPullReplicationMode = "bloom"
+	}
+	if env := getenv("PULL_REPLICATION_FILTER"); env != "" {
+		cfg.PullReplicationFilter = env
+	}
+	cfg.PullReplicationFilter = strings.ToLower(cfg.PullReplicationFilter)
+	if cfg.PullReplicationFilter == "" {
+		cfg.PullReplicationFilter = "bloom"
+	}
+	if cfg.PullReplicationFilter != "bloom" && cfg.PullReplicationFilter != "cuckoo" {
+		cfg.LogWarning("unknown PullReplicationFilter %q, using bloom\n", cfg.PullReplicationFilter)
+		cfg.PullReplicationFilter = "bloom"
+	}
+	if env := getenv("IN_PULL_REPLICATION_WORKERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InPullReplicationWorkers = val
 		}
@@ -504,7 +1314,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InPullReplicationWorkers < 1 {
 		cfg.InPullReplicationWorkers = 1
 	}
-	if env := os.Getenv("VALUESTORE_IN_PULL_REPLICATION_MSGS"); env != "" {
+	if env := getenv("IN_PULL_REPLICATION_MSGS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InPullReplicationMsgs = val
 		}
@@ -515,7 +1325,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InPullReplicationMsgs < 1 {
 		cfg.InPullReplicationMsgs = 1
 	}
-	if env := os.Getenv("VALUESTORE_IN_PULL_REPLICATION_RESPONSE_MSG_TIMEOUT"); env != "" {
+	if env := getenv("IN_PULL_REPLICATION_RESPONSE_MSG_TIMEOUT"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InPullReplicationResponseMsgTimeout = val
 		}
@@ -526,7 +1336,18 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InPullReplicationResponseMsgTimeout < 1 {
 		cfg.InPullReplicationResponseMsgTimeout = 100
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PUSH_REPLICATION_INTERVAL"); env != "" {
+	if env := getenv("IN_PULL_REPLICATION_RESPONSE_MSGS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.InPullReplicationResponseMsgs = val
+		}
+	}
+	if cfg.InPullReplicationResponseMsgs == 0 {
+		cfg.InPullReplicationResponseMsgs = 16
+	}
+	if cfg.InPullReplicationResponseMsgs < 1 {
+		cfg.InPullReplicationResponseMsgs = 1
+	}
+	if env := getenv("OUT_PUSH_REPLICATION_INTERVAL"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPushReplicationInterval = val
 		}
@@ -537,7 +1358,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPushReplicationInterval < 1 {
 		cfg.OutPushReplicationInterval = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PUSH_REPLICATION_WORKERS"); env != "" {
+	if env := getenv("OUT_PUSH_REPLICATION_WORKERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPushReplicationWorkers = val
 		}
@@ -548,7 +1369,10 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPushReplicationWorkers < 1 {
 		cfg.OutPushReplicationWorkers = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PUSH_REPLICATION_MSGS"); env != "" {
+	if cfg.OutPushReplicationWorkers > backgroundWorkerCap(cfg) {
+		cfg.OutPushReplicationWorkers = backgroundWorkerCap(cfg)
+	}
+	if env := getenv("OUT_PUSH_REPLICATION_MSGS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPushReplicationMsgs = val
 		}
@@ -559,7 +1383,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPushReplicationMsgs < 1 {
 		cfg.OutPushReplicationMsgs = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_PUSH_REPLICATION_MSG_TIMEOUT"); env != "" {
+	if env := getenv("OUT_PUSH_REPLICATION_MSG_TIMEOUT"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutPushReplicationMsgTimeout = val
 		}
@@ -570,7 +1394,236 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutPushReplicationMsgTimeout < 1 {
 		cfg.OutPushReplicationMsgTimeout = 100
 	}
-	if env := os.Getenv("VALUESTORE_BULK_SET_MSG_CAP"); env != "" {
+	if env := getenv("OUT_PUSH_REPLICATION_ACK_POLICY"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.OutPushReplicationAckPolicy = val
+		}
+	}
+	if cfg.OutPushReplicationAckPolicy != BULK_SET_ACK_ALL && cfg.OutPushReplicationAckPolicy != BULK_SET_ACK_NONE {
+		cfg.OutPushReplicationAckPolicy = BULK_SET_ACK_APPLIED
+	}
+	if env := getenv("OUT_PUSH_REPLICATION_BACKLOG"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.OutPushReplicationBacklog = val
+		}
+	}
+	if cfg.OutPushReplicationBacklog == 0 {
+		cfg.OutPushReplicationBacklog = 65536
+	}
+	if cfg.OutPushReplicationBacklog < 0 {
+		cfg.OutPushReplicationBacklog = 0
+	}
+	if env := getenv("OUT_PUSH_REPLICATION_BACKLOG_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.OutPushReplicationBacklogInterval = val
+		}
+	}
+	if cfg.OutPushReplicationBacklogInterval == 0 {
+		cfg.OutPushReplicationBacklogInterval = cfg.OutPushReplicationInterval
+	}
+	if cfg.OutPushReplicationBacklogInterval < 1 {
+		cfg.OutPushReplicationBacklogInterval = 1
+	}
+	if env := getenv("HANDOFF_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.HandoffInterval = val
+		}
+	}
+	if cfg.HandoffInterval < 1 {
+		cfg.HandoffInterval = 1000
+	}
+	if env := getenv("REMOTE_REPLICATION_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RemoteReplicationInterval = val
+		}
+	}
+	if cfg.RemoteReplicationInterval == 0 {
+		cfg.RemoteReplicationInterval = 1000
+	}
+	if cfg.RemoteReplicationInterval < 1 {
+		cfg.RemoteReplicationInterval = 1
+	}
+	if env := getenv("REMOTE_REPLICATION_BATCH"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RemoteReplicationBatch = val
+		}
+	}
+	if cfg.RemoteReplicationBatch == 0 {
+		cfg.RemoteReplicationBatch = 1024
+	}
+	if cfg.RemoteReplicationBatch < 1 {
+		cfg.RemoteReplicationBatch = 1
+	}
+	if env := getenv("REMOTE_REPLICATION_BACKLOG"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RemoteReplicationBacklog = val
+		}
+	}
+	if cfg.RemoteReplicationBacklog == 0 {
+		cfg.RemoteReplicationBacklog = 65536
+	}
+	if cfg.RemoteReplicationBacklog < 1 {
+		cfg.RemoteReplicationBacklog = 1
+	}
+	if env := getenv("REMOTE_REPLICATION_MSG_TIMEOUT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RemoteReplicationMsgTimeout = val
+		}
+	}
+	if cfg.RemoteReplicationMsgTimeout == 0 {
+		cfg.RemoteReplicationMsgTimeout = cfg.MsgTimeout
+	}
+	if cfg.RemoteReplicationMsgTimeout < 1 {
+		cfg.RemoteReplicationMsgTimeout = 100
+	}
+	if env := getenv("REMOTE_REPLICATION_COMPRESSION"); env != "" {
+		cfg.RemoteReplicationCompression = env
+	}
+	cfg.RemoteReplicationCompression = strings.ToLower(cfg.RemoteReplicationCompression)
+	if cfg.RemoteReplicationCompression == "" {
+		cfg.RemoteReplicationCompression = "none"
+	}
+	if _, ok := compressionCodecs[cfg.RemoteReplicationCompression]; !ok {
+		cfg.LogWarning("unknown RemoteReplicationCompression %q, using none\n", cfg.RemoteReplicationCompression)
+		cfg.RemoteReplicationCompression = "none"
+	}
+	if env := getenv("REPLICATION_COMPRESSION"); env != "" {
+		cfg.ReplicationCompression = env
+	}
+	cfg.ReplicationCompression = strings.ToLower(cfg.ReplicationCompression)
+	if cfg.ReplicationCompression == "" {
+		cfg.ReplicationCompression = "none"
+	}
+	if _, ok := compressionCodecs[cfg.ReplicationCompression]; !ok {
+		cfg.LogWarning("unknown ReplicationCompression %q, using none\n", cfg.ReplicationCompression)
+		cfg.ReplicationCompression = "none"
+	}
+	if env := getenv("REMOTE_REPLICATION_MAX_BYTES_PER_SEC"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RemoteReplicationMaxBytesPerSec = val
+		}
+	}
+	if cfg.RemoteReplicationMaxBytesPerSec < 0 {
+		cfg.RemoteReplicationMaxBytesPerSec = 0
+	}
+	if env := getenv("REMOTE_READ_FALLBACK"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RemoteReadFallback = val != 0
+		}
+	}
+	if env := getenv("READ_REPAIR"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ReadRepair = val != 0
+		}
+	}
+	if env := getenv("REMOTE_READ_TIMEOUT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.RemoteReadTimeout = val
+		}
+	}
+	if cfg.RemoteReadTimeout == 0 {
+		cfg.RemoteReadTimeout = cfg.MsgTimeout
+	}
+	if cfg.RemoteReadTimeout < 1 {
+		cfg.RemoteReadTimeout = 100
+	}
+	if env := getenv("QUORUM"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.Quorum = val
+		}
+	}
+	if cfg.Quorum < 0 {
+		cfg.Quorum = 0
+	}
+	if env := getenv("QUORUM_TIMEOUT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.QuorumTimeout = val
+		}
+	}
+	if cfg.QuorumTimeout == 0 {
+		cfg.QuorumTimeout = cfg.MsgTimeout
+	}
+	if cfg.QuorumTimeout < 1 {
+		cfg.QuorumTimeout = 100
+	}
+	if env := getenv("ERASURE_DATA_SHARDS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ErasureDataShards = val
+		}
+	}
+	if cfg.ErasureDataShards < 0 {
+		cfg.ErasureDataShards = 0
+	}
+	if cfg.ErasureDataShards > 254 {
+		cfg.ErasureDataShards = 254
+	}
+	if env := getenv("ERASURE_PARITY_SHARDS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ErasureParityShards = val
+		}
+	}
+	if cfg.ErasureParityShards == 0 {
+		cfg.ErasureParityShards = 2
+	}
+	if cfg.ErasureParityShards < 1 {
+		cfg.ErasureParityShards = 1
+	}
+	if cfg.ErasureDataShards+cfg.ErasureParityShards > 255 {
+		cfg.ErasureParityShards = 255 - cfg.ErasureDataShards
+	}
+	if env := getenv("ERASURE_MIN_LENGTH"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.ErasureMinLength = val
+		}
+	}
+	if cfg.ErasureMinLength == 0 {
+		cfg.ErasureMinLength = 65536
+	}
+	if cfg.ErasureMinLength < 1 {
+		cfg.ErasureMinLength = 1
+	}
+	if env := getenv("DISK_HEALTH_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.DiskHealthInterval = val
+		}
+	}
+	if cfg.DiskHealthInterval == 0 {
+		cfg.DiskHealthInterval = 60
+	}
+	if cfg.DiskHealthInterval < 1 {
+		cfg.DiskHealthInterval = 1
+	}
+	if env := getenv("DISK_FREE_MINIMUM"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.DiskFreeMinimum = val
+		}
+	}
+	if cfg.DiskFreeMinimum < 0 {
+		cfg.DiskFreeMinimum = 0
+	}
+	if env := getenv("DISK_FREE_HYSTERESIS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.DiskFreeHysteresis = val
+		}
+	}
+	if cfg.DiskFreeHysteresis == 0 {
+		cfg.DiskFreeHysteresis = cfg.DiskFreeMinimum / 10
+	}
+	if cfg.DiskFreeHysteresis < 0 {
+		cfg.DiskFreeHysteresis = 0
+	}
+	if env := getenv("DISK_FREE_INTERVAL"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.DiskFreeInterval = val
+		}
+	}
+	if cfg.DiskFreeInterval == 0 {
+		cfg.DiskFreeInterval = 1000
+	}
+	if cfg.DiskFreeInterval < 1 {
+		cfg.DiskFreeInterval = 1
+	}
+	if env := getenv("BULK_SET_MSG_CAP"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.BulkSetMsgCap = val
 		}
@@ -581,7 +1634,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.BulkSetMsgCap < 1 {
 		cfg.BulkSetMsgCap = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_BULK_SET_MSGS"); env != "" {
+	if env := getenv("OUT_BULK_SET_MSGS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutBulkSetMsgs = val
 		}
@@ -592,7 +1645,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutBulkSetMsgs < 1 {
 		cfg.OutBulkSetMsgs = 1
 	}
-	if env := os.Getenv("VALUESTORE_IN_BULK_SET_WORKERS"); env != "" {
+	if env := getenv("IN_BULK_SET_WORKERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InBulkSetWorkers = val
 		}
@@ -603,7 +1656,18 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InBulkSetWorkers < 1 {
 		cfg.InBulkSetWorkers = 1
 	}
-	if env := os.Getenv("VALUESTORE_IN_BULK_SET_MSGS"); env != "" {
+	if env := getenv("IN_BULK_SET_PRIORITY_WORKERS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.InBulkSetPriorityWorkers = val
+		}
+	}
+	if cfg.InBulkSetPriorityWorkers == 0 {
+		cfg.InBulkSetPriorityWorkers = cfg.InBulkSetWorkers / 4
+	}
+	if cfg.InBulkSetPriorityWorkers < 1 {
+		cfg.InBulkSetPriorityWorkers = 1
+	}
+	if env := getenv("IN_BULK_SET_MSGS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InBulkSetMsgs = val
 		}
@@ -614,7 +1678,26 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InBulkSetMsgs < 1 {
 		cfg.InBulkSetMsgs = 1
 	}
-	if env := os.Getenv("VALUESTORE_IN_BULK_SET_RESPONSE_MSG_TIMEOUT"); env != "" {
+	if env := getenv("IN_BULK_SET_PEER_MSGS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.InBulkSetPeerMsgs = val
+		}
+	}
+	if cfg.InBulkSetPeerMsgs == 0 {
+		cfg.InBulkSetPeerMsgs = cfg.InBulkSetMsgs / 2
+	}
+	if cfg.InBulkSetPeerMsgs < 1 {
+		cfg.InBulkSetPeerMsgs = 1
+	}
+	if env := getenv("IN_BULK_SET_PEER_BYTES_PER_SEC"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.InBulkSetPeerBytesPerSec = val
+		}
+	}
+	if cfg.InBulkSetPeerBytesPerSec < 0 {
+		cfg.InBulkSetPeerBytesPerSec = 0
+	}
+	if env := getenv("IN_BULK_SET_RESPONSE_MSG_TIMEOUT"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InBulkSetResponseMsgTimeout = val
 		}
@@ -625,7 +1708,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InBulkSetResponseMsgTimeout < 1 {
 		cfg.InBulkSetResponseMsgTimeout = 100
 	}
-	if env := os.Getenv("VALUESTORE_OUT_BULK_SET_ACK_MSG_CAP"); env != "" {
+	if env := getenv("OUT_BULK_SET_ACK_MSG_CAP"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.BulkSetAckMsgCap = val
 		}
@@ -636,7 +1719,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.BulkSetAckMsgCap < 1 {
 		cfg.BulkSetAckMsgCap = 1
 	}
-	if env := os.Getenv("VALUESTORE_IN_BULK_SET_ACK_WORKERS"); env != "" {
+	if env := getenv("IN_BULK_SET_ACK_WORKERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InBulkSetAckWorkers = val
 		}
@@ -647,7 +1730,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InBulkSetAckWorkers < 1 {
 		cfg.InBulkSetAckWorkers = 1
 	}
-	if env := os.Getenv("VALUESTORE_IN_BULK_SET_ACK_MSGS"); env != "" {
+	if env := getenv("IN_BULK_SET_ACK_MSGS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.InBulkSetAckMsgs = val
 		}
@@ -658,7 +1741,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.InBulkSetAckMsgs < 1 {
 		cfg.InBulkSetAckMsgs = 1
 	}
-	if env := os.Getenv("VALUESTORE_OUT_BULK_SET_ACK_MSGS"); env != "" {
+	if env := getenv("OUT_BULK_SET_ACK_MSGS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.OutBulkSetAckMsgs = val
 		}
@@ -669,7 +1752,26 @@ func resolveConfig(c *Config) *Config {
 	if cfg.OutBulkSetAckMsgs < 1 {
 		cfg.OutBulkSetAckMsgs = 1
 	}
-	if env := os.Getenv("VALUESTORE_COMPACTION_INTERVAL"); env != "" {
+	if env := getenv("OUT_BULK_SET_ACK_DELAY"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.OutBulkSetAckDelay = val
+		}
+	}
+	if cfg.OutBulkSetAckDelay < 0 {
+		cfg.OutBulkSetAckDelay = 0
+	}
+	if env := getenv("OUT_PEER_MSG_WINDOW"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.OutPeerMsgWindow = val
+		}
+	}
+	if cfg.OutPeerMsgWindow == 0 {
+		cfg.OutPeerMsgWindow = cfg.Workers
+	}
+	if cfg.OutPeerMsgWindow < 1 {
+		cfg.OutPeerMsgWindow = 1
+	}
+	if env := getenv("COMPACTION_INTERVAL"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.CompactionInterval = val
 		}
@@ -680,7 +1782,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.CompactionInterval < 1 {
 		cfg.CompactionInterval = 1
 	}
-	if env := os.Getenv("VALUESTORE_COMPACTION_WORKERS"); env != "" {
+	if env := getenv("COMPACTION_WORKERS"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.CompactionWorkers = val
 		}
@@ -691,7 +1793,10 @@ func resolveConfig(c *Config) *Config {
 	if cfg.CompactionWorkers < 1 {
 		cfg.CompactionWorkers = 1
 	}
-	if env := os.Getenv("VALUESTORE_COMPACTION_THRESHOLD"); env != "" {
+	if cfg.CompactionWorkers > backgroundWorkerCap(cfg) {
+		cfg.CompactionWorkers = backgroundWorkerCap(cfg)
+	}
+	if env := getenv("COMPACTION_THRESHOLD"); env != "" {
 		if val, err := strconv.ParseFloat(env, 64); err == nil {
 			cfg.CompactionThreshold = val
 		}
@@ -702,7 +1807,7 @@ func resolveConfig(c *Config) *Config {
 	if cfg.CompactionThreshold >= 1.0 || cfg.CompactionThreshold <= 0.01 {
 		cfg.CompactionThreshold = 0.10
 	}
-	if env := os.Getenv("VALUESTORE_COMPACTION_AGE_THRESHOLD"); env != "" {
+	if env := getenv("COMPACTION_AGE_THRESHOLD"); env != "" {
 		if val, err := strconv.Atoi(env); err == nil {
 			cfg.CompactionAgeThreshold = val
 		}
@@ -713,5 +1818,177 @@ func resolveConfig(c *Config) *Config {
 	if cfg.CompactionAgeThreshold < 1 {
 		cfg.CompactionAgeThreshold = 1
 	}
+	if env := getenv("COMPACTION_DROP_PAGE_CACHE"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.CompactionDropPageCache = val != 0
+		}
+	}
+	if env := getenv("COMPACTION_RECOMPRESS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.CompactionRecompress = val != 0
+		}
+	}
+	if env := getenv("COMPACTION_REENCRYPT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.CompactionReencrypt = val != 0
+		}
+	}
+	if env := getenv("COMPACTION_MAX_BYTES_PER_SEC"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			cfg.CompactionMaxBytesPerSec = val
+		}
+	}
+	if cfg.CompactionMaxBytesPerSec < 0 {
+		cfg.CompactionMaxBytesPerSec = 0
+	}
 	return cfg
 }
+
+// JSON returns the Config encoded as indented JSON; the funcs and interfaces
+// are skipped. Combined with DefaultValueStore.Config, this can be used to
+// record what a ValueStore was actually started with.
+func (cfg *Config) JSON() ([]byte, error) {
+	return json.MarshalIndent(cfg, "", "    ")
+}
+
+// ConfigFromJSON returns the Config decoded from JSON, such as that from
+// Config.JSON.
+func ConfigFromJSON(b []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadConfig returns the Config read from the file at name: TOML if the name
+// ends in ".toml" and JSON, as from Config.JSON, otherwise. Keys are the
+// Config's field names, such as ValueCap = 4096 in TOML; unknown keys are an
+// error so a misspelled setting isn't silently ignored. As with any Config,
+// the defaults and environment overrides are applied once it is given to New.
+func LoadConfig(name string) (*Config, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if strings.HasSuffix(strings.ToLower(name), ".toml") {
+		md, err := toml.Decode(string(b), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %s", name, undecoded[0])
+		}
+		return cfg, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return cfg, nil
+}
+
+// ConfigDiff is a field that differs between two Configs, as returned by
+// Config.Diff.
+type ConfigDiff struct {
+	Field string
+	From  interface{}
+	To    interface{}
+}
+
+func (d ConfigDiff) String() string {
+	return fmt.Sprintf("%s: %v -> %v", d.Field, d.From, d.To)
+}
+
+// Diff returns the fields that differ from cfg to other, in the order they
+// are declared. The funcs and interfaces, which are skipped by JSON, are
+// skipped here too.
+func (cfg *Config) Diff(other *Config) []ConfigDiff {
+	var diffs []ConfigDiff
+	a := reflect.ValueOf(cfg).Elem()
+	b := reflect.ValueOf(other).Elem()
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		if av, bv := a.Field(i).Interface(), b.Field(i).Interface(); !reflect.DeepEqual(av, bv) {
+			diffs = append(diffs, ConfigDiff{Field: f.Name, From: av, To: bv})
+		}
+	}
+	return diffs
+}
+
+// ConfigError is a setting Config.Validate found to be out of range or in
+// conflict with other settings.
+type ConfigError struct {
+	Field string
+	Value interface{}
+	// Used is what New would quietly use instead, if anything.
+	Used interface{}
+	// Detail describes the problem when there is nothing New would use
+	// instead.
+	Detail string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s: %s", e.Field, e.Detail)
+	}
+	return fmt.Sprintf("%s: %v is out of range or conflicts with other settings; %v would be used", e.Field, e.Value, e.Used)
+}
+
+// Validate returns an error for each setting New would quietly correct, such
+// as a negative PageSize or a CompactionThreshold over 1, and for unusable
+// encryption keys; it returns nil if there are none. Zero values, which get
+// the defaults, are fine; environment overrides are not checked. This is
+// meant for catching misconfiguration in tests and deployment tooling.
+func (cfg *Config) Validate() []*ConfigError {
+	c := *cfg
+	c.IgnoreEnv = true
+	c.LogWarning = func(string, ...interface{}) {}
+	resolved := resolveConfig(&c)
+	var errs []*ConfigError
+	a := reflect.ValueOf(cfg).Elem()
+	b := reflect.ValueOf(resolved).Elem()
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" || f.Name == "IgnoreEnv" {
+			continue
+		}
+		av, bv := a.Field(i), b.Field(i)
+		if av.IsZero() {
+			continue
+		}
+		if av.Kind() == reflect.String && strings.EqualFold(av.String(), bv.String()) {
+			continue
+		}
+		if !reflect.DeepEqual(av.Interface(), bv.Interface()) {
+			errs = append(errs, &ConfigError{Field: f.Name, Value: av.Interface(), Used: bv.Interface()})
+		}
+	}
+	keyError := func(field string, keyID uint32, key []byte) {
+		if keyID == 0 {
+			errs = append(errs, &ConfigError{Field: field, Value: keyID, Detail: "key ID 0 is reserved for unencrypted files"})
+		}
+		if l := len(key); l != 16 && l != 24 && l != 32 {
+			errs = append(errs, &ConfigError{Field: field, Value: keyID, Detail: fmt.Sprintf("key ID %d is %d bytes rather than 16, 24, or 32", keyID, l)})
+		}
+	}
+	if len(cfg.EncryptionKey) > 0 {
+		keyError("EncryptionKey", resolved.EncryptionKeyID, cfg.EncryptionKey)
+	}
+	keyIDs := make([]int, 0, len(cfg.EncryptionKeys))
+	for keyID := range cfg.EncryptionKeys {
+		keyIDs = append(keyIDs, int(keyID))
+	}
+	sort.Ints(keyIDs)
+	for _, keyID := range keyIDs {
+		keyError("EncryptionKeys", uint32(keyID), cfg.EncryptionKeys[uint32(keyID)])
+	}
+	return errs
+}
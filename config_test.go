@@ -0,0 +1,236 @@
+package valuestore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestConfigEffective(t *testing.T) {
+	os.Setenv("VALUESTORE_IN_BULK_SET_WORKERS", "3")
+	defer os.Unsetenv("VALUESTORE_IN_BULK_SET_WORKERS")
+	vs := newTestStore(t, &Config{InBulkSetWorkers: 1, CompactionWorkers: -5})
+	cfg := vs.Config()
+	if cfg.InBulkSetWorkers != 3 {
+		t.Fatal(cfg.InBulkSetWorkers)
+	}
+	if cfg.CompactionWorkers != 1 {
+		t.Fatal(cfg.CompactionWorkers)
+	}
+	if cfg.InBulkSetMsgs != 12 {
+		t.Fatal(cfg.InBulkSetMsgs)
+	}
+	cfg.InBulkSetWorkers = 10
+	if vs.Config().InBulkSetWorkers != 3 {
+		t.Fatal(vs.Config().InBulkSetWorkers)
+	}
+	b, err := json.Marshal(vs.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["InBulkSetWorkers"] != float64(3) {
+		t.Fatal(m["InBulkSetWorkers"])
+	}
+	if _, ok := m["LogError"]; ok {
+		t.Fatal(m)
+	}
+}
+
+func TestConfigCopy(t *testing.T) {
+	in := &Config{IgnoreEnv: true, MessageAuthKey: []byte("auth key"), EncryptionKey: []byte("0123456789abcdef")}
+	vs := newTestStore(t, in)
+	in.MessageAuthKey[0] = 'X'
+	in.EncryptionKey[0] = 'X'
+	cfg := vs.Config()
+	if string(cfg.MessageAuthKey) != "auth key" || string(cfg.EncryptionKey) != "0123456789abcdef" {
+		t.Fatal(string(cfg.MessageAuthKey), string(cfg.EncryptionKey))
+	}
+	cfg.MessageAuthKey[0] = 'X'
+	cfg.EncryptionKey[0] = 'X'
+	if cfg = vs.Config(); string(cfg.MessageAuthKey) != "auth key" || string(cfg.EncryptionKey) != "0123456789abcdef" {
+		t.Fatal(string(cfg.MessageAuthKey), string(cfg.EncryptionKey))
+	}
+	in = &Config{Paths: []string{"a", "b"}, PathsTOC: []string{"c", "d"}, EncryptionKeys: map[uint32][]byte{1: []byte("key")}}
+	cfg = in.copy()
+	in.Paths[0] = "x"
+	in.PathsTOC[0] = "x"
+	in.EncryptionKeys[1][0] = 'X'
+	in.EncryptionKeys[2] = nil
+	if cfg.Paths[0] != "a" || cfg.PathsTOC[0] != "c" || string(cfg.EncryptionKeys[1]) != "key" || len(cfg.EncryptionKeys) != 1 {
+		t.Fatal(cfg.Paths, cfg.PathsTOC, cfg.EncryptionKeys)
+	}
+}
+
+func TestConfigEnvPrefix(t *testing.T) {
+	os.Setenv("VALUESTORE_DISK1_IN_BULK_SET_WORKERS", "5")
+	defer os.Unsetenv("VALUESTORE_DISK1_IN_BULK_SET_WORKERS")
+	os.Setenv("VALUESTORE_DISK2_IN_BULK_SET_WORKERS", "7")
+	defer os.Unsetenv("VALUESTORE_DISK2_IN_BULK_SET_WORKERS")
+	if cfg := resolveConfig(&Config{EnvPrefix: "VALUESTORE_DISK1_"}); cfg.InBulkSetWorkers != 5 {
+		t.Fatal(cfg.InBulkSetWorkers)
+	}
+	if cfg := resolveConfig(&Config{EnvPrefix: "VALUESTORE_DISK2_"}); cfg.InBulkSetWorkers != 7 {
+		t.Fatal(cfg.InBulkSetWorkers)
+	}
+	if cfg := resolveConfig(&Config{InBulkSetWorkers: 2}); cfg.EnvPrefix != "VALUESTORE_" || cfg.InBulkSetWorkers != 2 {
+		t.Fatal(cfg.EnvPrefix, cfg.InBulkSetWorkers)
+	}
+}
+
+func TestConfigIgnoreEnv(t *testing.T) {
+	os.Setenv("VALUESTORE_IN_BULK_SET_WORKERS", "5")
+	defer os.Unsetenv("VALUESTORE_IN_BULK_SET_WORKERS")
+	if cfg := resolveConfig(&Config{IgnoreEnv: true, InBulkSetWorkers: 2}); cfg.InBulkSetWorkers != 2 {
+		t.Fatal(cfg.InBulkSetWorkers)
+	}
+	if cfg := resolveConfig(&Config{InBulkSetWorkers: 2}); cfg.InBulkSetWorkers != 5 {
+		t.Fatal(cfg.InBulkSetWorkers)
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	for _, p := range []func() *Config{ProfileSSD, ProfileHDD, ProfileLowMemory, ProfileBulkLoad} {
+		in := p()
+		in.IgnoreEnv = true
+		cfg := resolveConfig(in)
+		// The profiles should be coherent enough that resolving them doesn't
+		// have to adjust anything they set.
+		if (in.PageSize != 0 && cfg.PageSize != in.PageSize) || cfg.WritePagesPerWorker != in.WritePagesPerWorker {
+			t.Fatal(cfg.PageSize, in.PageSize, cfg.WritePagesPerWorker, in.WritePagesPerWorker)
+		}
+		if cfg.OutPullReplicationBloomN != in.OutPullReplicationBloomN || cfg.OutPullReplicationBloomP != in.OutPullReplicationBloomP {
+			t.Fatal(cfg.OutPullReplicationBloomN, cfg.OutPullReplicationBloomP)
+		}
+		if cfg.BulkSetMsgCap < cfg.ValueCap {
+			t.Fatal(cfg.BulkSetMsgCap, cfg.ValueCap)
+		}
+	}
+	cfg := ProfileHDD()
+	cfg.ValuesFileReaders = 3
+	if cfg = resolveConfig(cfg); cfg.ValuesFileReaders != 3 {
+		t.Fatal(cfg.ValuesFileReaders)
+	}
+}
+
+func TestConfigAutoTune(t *testing.T) {
+	cfg := resolveConfig(&Config{IgnoreEnv: true, AutoTune: true, Workers: 3})
+	if !cfg.AutoTune || cfg.Workers != 3 {
+		t.Fatal(cfg.AutoTune, cfg.Workers)
+	}
+}
+
+func TestConfigJSONDiff(t *testing.T) {
+	a := resolveConfig(&Config{IgnoreEnv: true, InBulkSetWorkers: 2})
+	b, err := a.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ConfigFromJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := a.Diff(c); len(d) != 0 {
+		t.Fatal(d)
+	}
+	c.InBulkSetWorkers = 3
+	c.Path = "elsewhere"
+	d := a.Diff(c)
+	if len(d) != 2 {
+		t.Fatal(d)
+	}
+	if d[0].Field != "Path" || d[0].From != a.Path || d[0].To != "elsewhere" {
+		t.Fatal(d[0])
+	}
+	if d[1].String() != "InBulkSetWorkers: 2 -> 3" {
+		t.Fatal(d[1].String())
+	}
+	if _, err = ConfigFromJSON([]byte("{")); err == nil {
+		t.Fatal("")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestoreconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, contents string) string {
+		name = path.Join(dir, name)
+		if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	cfg, err := LoadConfig(write("a.toml", "# comment\nPath = \"/data/values\"\nValueCap = 4096\nCompactionThreshold = 0.25\nStrictSync = true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Path != "/data/values" || cfg.ValueCap != 4096 || cfg.CompactionThreshold != 0.25 || !cfg.StrictSync {
+		t.Fatal(cfg.Path, cfg.ValueCap, cfg.CompactionThreshold, cfg.StrictSync)
+	}
+	if _, err = LoadConfig(write("b.toml", "ValueCapp = 4096\n")); err == nil || !strings.Contains(err.Error(), "ValueCapp") {
+		t.Fatal(err)
+	}
+	a := resolveConfig(&Config{IgnoreEnv: true, InBulkSetWorkers: 2})
+	b, err := a.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = LoadConfig(write("c.json", string(b))); err != nil {
+		t.Fatal(err)
+	}
+	if d := a.Diff(cfg); len(d) != 0 {
+		t.Fatal(d)
+	}
+	if _, err = LoadConfig(write("d.json", `{"ValueCapp": 4096}`)); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+	// The environment still overrides what the file says.
+	os.Setenv("VALUESTORECONFIGTEST_VALUE_CAP", "2048")
+	defer os.Unsetenv("VALUESTORECONFIGTEST_VALUE_CAP")
+	if cfg, err = LoadConfig(write("e.toml", "EnvPrefix = \"VALUESTORECONFIGTEST_\"\nValueCap = 4096\n")); err != nil {
+		t.Fatal(err)
+	}
+	if c := resolveConfig(cfg).ValueCap; c != 2048 {
+		t.Fatal(c)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if errs := resolveConfig(&Config{IgnoreEnv: true}).Validate(); errs != nil {
+		t.Fatal(errs)
+	}
+	for _, cfg := range []*Config{{}, ProfileSSD(), ProfileHDD(), {Compression: "ZSTD", ReaderTuneInterval: -1}} {
+		if errs := cfg.Validate(); errs != nil {
+			t.Fatal(errs)
+		}
+	}
+	errs := (&Config{
+		PageSize:            -1,
+		CompactionThreshold: 1.5,
+		Compression:         "gzip",
+		EncryptionKey:       []byte("short"),
+		EncryptionKeys:      map[uint32][]byte{0: make([]byte, 16)},
+	}).Validate()
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	if strings.Join(fields, " ") != "PageSize Compression CompactionThreshold EncryptionKey EncryptionKeys" {
+		t.Fatal(errs)
+	}
+	if s := errs[0].Error(); !strings.HasPrefix(s, "PageSize: -1 is out of range") {
+		t.Fatal(s)
+	}
+	if s := errs[3].Error(); s != "EncryptionKey: key ID 1 is 5 bytes rather than 16, 24, or 32" {
+		t.Fatal(s)
+	}
+}
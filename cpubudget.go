@@ -0,0 +1,64 @@
+package valuestore
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// _BACKGROUND_PACE_MIN is how long a background worker runs between pauses
+// at the least, so the pauses aren't lost in timer granularity.
+const _BACKGROUND_PACE_MIN = 10 * time.Millisecond
+
+// cpuBudgetState keeps the background passes (compaction, tombstone discard,
+// and outgoing pull and push replication) within Config.BackgroundCPUPercent.
+// Their worker counts are capped when the Config is resolved, see
+// backgroundWorkerCap, and each of their workers uses a backgroundPacer to
+// pause between batches of work.
+type cpuBudgetState struct {
+	percent int
+}
+
+// backgroundPacer paces one background worker. It is not safe for use by
+// more than one goroutine.
+type backgroundPacer struct {
+	vs    *DefaultValueStore
+	start time.Time
+}
+
+func (vs *DefaultValueStore) cpuBudgetConfig(cfg *Config) {
+	vs.cpuBudgetState.percent = cfg.BackgroundCPUPercent
+}
+
+// backgroundWorkerCap returns the most workers a background pass may use
+// with cfg's Workers and BackgroundCPUPercent.
+func backgroundWorkerCap(cfg *Config) int {
+	workers := (cfg.Workers*cfg.BackgroundCPUPercent + 99) / 100
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+func (vs *DefaultValueStore) newBackgroundPacer() *backgroundPacer {
+	return &backgroundPacer{vs: vs, start: time.Now()}
+}
+
+// pause is called by a background worker between batches of work. Once the
+// worker has been busy a while, it sleeps long enough to have been busy only
+// BackgroundCPUPercent of the time; otherwise it just yields the processor
+// to any other goroutines waiting, such as those serving foreground requests.
+func (p *backgroundPacer) pause() {
+	percent := p.vs.cpuBudgetState.percent
+	if percent >= 100 {
+		return
+	}
+	busy := time.Since(p.start)
+	if busy < _BACKGROUND_PACE_MIN {
+		runtime.Gosched()
+		return
+	}
+	atomic.AddInt32(&p.vs.backgroundPauses, 1)
+	time.Sleep(busy * time.Duration(100-percent) / time.Duration(percent))
+	p.start = time.Now()
+}
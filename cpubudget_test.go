@@ -0,0 +1,73 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackgroundCPUPercentWorkers(t *testing.T) {
+	cfg := resolveConfig(&Config{IgnoreEnv: true, Workers: 8, BackgroundCPUPercent: 25, OutPushReplicationWorkers: 1})
+	if cfg.CompactionWorkers != 2 || cfg.OutPullReplicationWorkers != 2 || cfg.OutPushReplicationWorkers != 1 {
+		t.Fatal(cfg.CompactionWorkers, cfg.OutPullReplicationWorkers, cfg.OutPushReplicationWorkers)
+	}
+	cfg = resolveConfig(&Config{IgnoreEnv: true, Workers: 2, BackgroundCPUPercent: 1})
+	if cfg.CompactionWorkers != 1 || backgroundWorkerCap(cfg) != 1 {
+		t.Fatal(cfg.CompactionWorkers, backgroundWorkerCap(cfg))
+	}
+	cfg = resolveConfig(&Config{IgnoreEnv: true, Workers: 8})
+	if cfg.BackgroundCPUPercent != 100 || cfg.CompactionWorkers != 8 {
+		t.Fatal(cfg.BackgroundCPUPercent, cfg.CompactionWorkers)
+	}
+}
+
+func TestBackgroundPacer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpubudget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true, BackgroundCPUPercent: 50})
+	defer vs.Close()
+	vs.EnableWrites()
+	p := vs.newBackgroundPacer()
+	// Not busy long enough to pause yet.
+	p.pause()
+	if stats := vs.Stats(false).(*Stats); stats.BackgroundPauses != 0 {
+		t.Fatal(stats.BackgroundPauses)
+	}
+	// Busy for 20ms at 50% should pause about as long.
+	p.start = time.Now().Add(-20 * time.Millisecond)
+	start := time.Now()
+	p.pause()
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatal(d)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.BackgroundPauses != 1 {
+		t.Fatal(stats.BackgroundPauses)
+	}
+	// Compaction still works when paced.
+	for keyB := uint64(1); keyB <= 1000; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	files := vs.ListFiles()
+	if len(files) != 1 {
+		t.Fatal(files)
+	}
+	if err = vs.CompactFile(files[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	for keyB := uint64(1); keyB <= 1000; keyB++ {
+		if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d", keyB) {
+			t.Fatal(keyB, string(value), err)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.FileCompactions != 1 {
+		t.Fatal(stats.FileCompactions)
+	}
+}
@@ -0,0 +1,472 @@
+package valuestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// crashHarness runs a write workload against a store in a temporary
+// directory, copies the files on disk as they are when a failpoint is
+// reached, just as killing the process there would leave them, and then
+// recovers a new store from the copy to verify what survived.
+type crashHarness struct {
+	t        *testing.T
+	dir      string
+	crashDir string
+	cfg      *Config
+	values   map[uint64][]byte
+	stores   []*DefaultValueStore
+	killed   chan struct{}
+	dirs     []string
+}
+
+func newCrashHarness(t *testing.T) *crashHarness {
+	dir, err := ioutil.TempDir("", "valuestorecrash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashDir, err := ioutil.TempDir("", "valuestorecrash")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return &crashHarness{
+		t:        t,
+		dir:      dir,
+		crashDir: crashDir,
+		killed:   make(chan struct{}),
+		cfg: &Config{
+			Path:                      dir,
+			IgnoreEnv:                 true,
+			Workers:                   2,
+			ChecksumInterval:          1024,
+			ValueCap:                  4096,
+			PageSize:                  64 * 1024,
+			MsgCap:                    64 * 1024,
+			RecoveryBatchSize:         1024,
+			TombstoneDiscardBatchSize: 1024,
+			OutPullReplicationBloomN:  1000,
+		},
+		values: map[uint64][]byte{},
+	}
+}
+
+// close closes any store the test left open and removes the directories.
+func (h *crashHarness) close() {
+	for _, vs := range h.stores {
+		vs.Close()
+	}
+	os.RemoveAll(h.dir)
+	os.RemoveAll(h.crashDir)
+	for _, dir := range h.dirs {
+		os.RemoveAll(dir)
+	}
+}
+
+func (h *crashHarness) open() *DefaultValueStore {
+	cfg := *h.cfg
+	return h.openConfig(&cfg)
+}
+
+func (h *crashHarness) openConfig(cfg *Config) *DefaultValueStore {
+	vs, err := NewWithContext(context.Background(), cfg)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.stores = append(h.stores, vs)
+	vs.EnableWrites()
+	return vs
+}
+
+// killAt has the files copied to crashDir the skip+1th time vs reaches the
+// failpoint name. The goroutine reaching it waits for the copy, as it would
+// never have continued had the process been killed there, while the others
+// carry on; so the manifest is copied first and the values files last, as
+// what they have written by then is a superset of what the TOC files refer
+// to.
+func (h *crashHarness) killAt(vs *DefaultValueStore, name string, skip int) {
+	var lock sync.Mutex
+	vs.failpointState.hook = func(n string) {
+		lock.Lock()
+		defer lock.Unlock()
+		if n != name || skip < 0 {
+			return
+		}
+		if skip--; skip < 0 {
+			h.copyFiles(h.crashDir)
+			close(h.killed)
+		}
+	}
+}
+
+// crash opens a store on a copy of the files as they are now, as if the
+// process were killed at this point, leaving the store writing them be.
+func (h *crashHarness) crash() *DefaultValueStore {
+	dir, err := ioutil.TempDir("", "valuestorecrash")
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.dirs = append(h.dirs, dir)
+	h.copyFiles(dir)
+	cfg := *h.cfg
+	cfg.Path = dir
+	return h.openConfig(&cfg)
+}
+
+func (h *crashHarness) copyFiles(dst string) {
+	var names []string
+	for _, pattern := range []string{_MANIFEST_NAME, "*.valuestoc", "*.values"} {
+		matches, err := filepath.Glob(path.Join(h.dir, pattern))
+		if err != nil {
+			h.t.Error(err)
+			return
+		}
+		names = append(names, matches...)
+	}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(dst, path.Base(name)), b, 0644)
+		}
+		if err != nil {
+			h.t.Error(err)
+			return
+		}
+	}
+}
+
+// recover opens a store on the files copied by killAt, which from then on
+// stands in for the store's directory.
+func (h *crashHarness) recover() *DefaultValueStore {
+	select {
+	case <-h.killed:
+	default:
+		h.t.Fatal("the failpoint was not reached")
+	}
+	h.dir = h.crashDir
+	h.cfg.Path = h.crashDir
+	return h.open()
+}
+
+// workload writes count keys, starting at keyB first, with values of varying
+// lengths and flushes them to disk.
+func (h *crashHarness) workload(vs *DefaultValueStore, first uint64, count int) {
+	for i := 0; i < count; i++ {
+		keyB := first + uint64(i)
+		value := bytes.Repeat([]byte(fmt.Sprintf("%d.", keyB)), i%64+1)
+		if _, err := vs.Write(1, keyB, int64(1000+keyB), value); err != nil {
+			h.t.Fatal(err)
+		}
+		h.values[keyB] = value
+	}
+	vs.Flush()
+}
+
+// files returns the names of the files in the store's directory with the
+// given suffix, oldest first.
+func (h *crashHarness) files(suffix string) []string {
+	names, err := filepath.Glob(path.Join(h.dir, "*"+suffix))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.ParseInt(strings.TrimSuffix(path.Base(names[i]), suffix), 10, 64)
+		b, _ := strconv.ParseInt(strings.TrimSuffix(path.Base(names[j]), suffix), 10, 64)
+		return a < b
+	})
+	return names
+}
+
+func (h *crashHarness) truncate(name string, fraction float64) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if err = os.Truncate(name, int64(float64(fi.Size())*fraction)); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// verify checks the recovered store: any value it returns must be exactly
+// what was written, with the timestamp it was written with. It returns how
+// many of the written keys were readable.
+func (h *crashHarness) verify(vs *DefaultValueStore) int {
+	readable := 0
+	for keyB, expected := range h.values {
+		ts, value, err := vs.Read(1, keyB, nil)
+		if err != nil {
+			continue
+		}
+		if ts != int64(1000+keyB) || !bytes.Equal(value, expected) {
+			h.t.Fatalf("key %d: got %d %q, expected %d %q", keyB, ts, value, 1000+keyB, expected)
+		}
+		readable++
+	}
+	return readable
+}
+
+// verifyWritable checks the recovered store takes new writes and that they
+// in turn survive another restart; it closes vs.
+func (h *crashHarness) verifyWritable(vs *DefaultValueStore) {
+	h.workload(vs, 1000000, 10)
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	for keyB := uint64(1000000); keyB < 1000010; keyB++ {
+		ts, value, err := vs.Read(1, keyB, nil)
+		if err != nil || ts != int64(1000+keyB) || !bytes.Equal(value, h.values[keyB]) {
+			h.t.Fatal(keyB, ts, err)
+		}
+	}
+}
+
+func TestCrashRecoveryClean(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 1000)
+	vs.Close()
+	vs = h.open()
+	if n := h.verify(vs); n != len(h.values) {
+		t.Fatal(n, len(h.values))
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DirtyValuesFiles != 0 || stats.RecoveryUntrusted != 0 {
+		t.Fatal(stats.DirtyValuesFiles, stats.RecoveryUntrusted)
+	}
+	h.verifyWritable(vs)
+}
+
+func TestCrashRecoveryMidPage(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	// Small pages, so TOC blocks are written as the workload goes rather
+	// than only by Flush.
+	h.cfg.PageSize = 4096
+	vs := h.open()
+	h.workload(vs, 1, 1000)
+	durable := len(h.values)
+	// Killed after a few pages more, partway through writing the next one.
+	h.killAt(vs, _FAILPOINT_VALUES_PAGE, 120)
+	h.workload(vs, 1001, 1000)
+	vs.Close()
+	vs = h.recover()
+	n := h.verify(vs)
+	if n <= durable || n == len(h.values) {
+		t.Fatal(n, durable, len(h.values))
+	}
+	// The keys of the unfinished page never made it to the TOC, so they are
+	// simply unknown rather than failing to read.
+	for keyB := range h.values {
+		if _, _, err := vs.Read(1, keyB, nil); err != nil && err != ErrNotFound {
+			t.Fatal(keyB, err)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DirtyValuesFiles != 1 {
+		t.Fatal(stats.DirtyValuesFiles)
+	}
+	h.verifyWritable(vs)
+}
+
+func TestCrashRecoveryMidTOCWrite(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	// Small pages, so TOC blocks are written as the workload goes rather
+	// than only by Flush.
+	h.cfg.PageSize = 4096
+	vs := h.open()
+	h.workload(vs, 1, 1000)
+	durable := len(h.values)
+	// Killed partway through the TOC, before its later entries and
+	// terminator.
+	h.killAt(vs, _FAILPOINT_TOC_WRITE, 2)
+	h.workload(vs, 1001, 1000)
+	vs.Close()
+	vs = h.recover()
+	if n := h.verify(vs); n <= durable || n == len(h.values) {
+		t.Fatal(n, durable, len(h.values))
+	}
+	h.verifyWritable(vs)
+}
+
+func TestCrashRecoveryMidCompaction(t *testing.T) {
+	for _, failpoint := range []string{_FAILPOINT_COMPACTION_REWRITTEN, _FAILPOINT_COMPACTION_REMOVE} {
+		h := newCrashHarness(t)
+		vs := h.open()
+		h.workload(vs, 1, 1000)
+		old := h.files(".valuestoc")
+		if len(old) != 1 {
+			t.Fatal(old)
+		}
+		namets, err := strconv.ParseInt(strings.TrimSuffix(path.Base(old[0]), ".valuestoc"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Killed with the rewrite on disk but the old files not yet, or
+		// only partly, removed.
+		h.killAt(vs, failpoint, 0)
+		if err = vs.CompactFile(namets); err != nil {
+			t.Fatal(err)
+		}
+		vs.Close()
+		vs = h.recover()
+		if n := h.verify(vs); n != len(h.values) {
+			t.Fatal(failpoint, n, len(h.values))
+		}
+		h.verifyWritable(vs)
+		h.close()
+	}
+}
+
+func TestCrashRecoveryRecompaction(t *testing.T) {
+	for _, failpoint := range []string{_FAILPOINT_COMPACTION_REWRITTEN, _FAILPOINT_COMPACTION_REMOVE} {
+		h := newCrashHarness(t)
+		vs := h.open()
+		h.workload(vs, 1, 1000)
+		// The second compaction is of the file the first wrote, whose
+		// entries are all rewrites already; killed partway through it.
+		h.killAt(vs, failpoint, 1)
+		for i := 0; i < 2; i++ {
+			files := vs.ListFiles()
+			if len(files) != 1 {
+				t.Fatal(failpoint, i, files)
+			}
+			if err := vs.CompactFile(files[0].ID); err != nil {
+				t.Fatal(failpoint, i, err)
+			}
+		}
+		vs.Close()
+		// Reopened as closed, and as killed.
+		vs = h.open()
+		if n := h.verify(vs); n != len(h.values) {
+			t.Fatal(failpoint, n, len(h.values))
+		}
+		vs.Close()
+		vs = h.recover()
+		if n := h.verify(vs); n != len(h.values) {
+			t.Fatal(failpoint, n, len(h.values))
+		}
+		h.verifyWritable(vs)
+		h.close()
+	}
+}
+
+func TestCrashRecoveryDuplicates(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 100)
+	vs.Close()
+	// A copy of the files under a newer name has every entry at two
+	// locations with the same timestamp, as a partial compaction might.
+	tocs := h.files(".valuestoc")
+	values := h.files(".values")
+	if len(tocs) != 1 || len(values) != 1 {
+		t.Fatal(tocs, values)
+	}
+	namets, err := strconv.ParseInt(strings.TrimSuffix(path.Base(tocs[0]), ".valuestoc"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range [][2]string{
+		{tocs[0], path.Join(h.dir, fmt.Sprintf("%d.valuestoc", namets+1))},
+		{values[0], path.Join(h.dir, fmt.Sprintf("%019d.values", namets+1))},
+	} {
+		b, err := ioutil.ReadFile(c[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(c[1], b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs = h.open()
+	defer vs.Close()
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryDuplicates != 100 {
+		t.Fatal(stats.RecoveryDuplicates)
+	}
+	if n := h.verify(vs); n != len(h.values) {
+		t.Fatal(n, len(h.values))
+	}
+	newer := vs.valueLocBlockIDFromTimestampnano(namets + 1)
+	for keyB := range h.values {
+		if _, blockID, _, _ := vs.vlm.Get(1, keyB); blockID != newer {
+			t.Fatal(keyB, blockID, newer)
+		}
+	}
+}
+
+func TestCrashRecoveryChecksumIntervalChanged(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 1000)
+	vs.Close()
+	// Files record their own interval, so changing it doesn't affect them.
+	h.cfg.ChecksumInterval = 4096
+	vs = h.open()
+	if n := h.verify(vs); n != len(h.values) {
+		t.Fatal(n, len(h.values))
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DirtyValuesFiles != 0 {
+		t.Fatal(stats.DirtyValuesFiles)
+	}
+	old := h.files(".valuestoc")[0]
+	namets, err := strconv.ParseInt(strings.TrimSuffix(path.Base(old), ".valuestoc"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := vs.compactFile(old, vs.valueLocBlockIDFromTimestampnano(namets))
+	if err != nil || result.rewrote != len(h.values) {
+		t.Fatal(result, err)
+	}
+	h.verifyWritable(vs)
+}
+
+func TestCrashRecoveryTOCV1(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	h.cfg.ValuesFileCap = 1 << 33
+	vs := h.open()
+	h.workload(vs, 1, 1000)
+	vs.Close()
+	old := h.files(".valuestoc")[0]
+	b, err := ioutil.ReadFile(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:28]) != _TOC_HEADER_V1 {
+		t.Fatal(string(b[:28]))
+	}
+	// Both formats are read regardless of the current cap.
+	for _, valuesFileCap := range []int{1 << 33, 0} {
+		h.cfg.ValuesFileCap = valuesFileCap
+		vs := h.open()
+		if n := h.verify(vs); n != len(h.values) {
+			t.Fatal(valuesFileCap, n, len(h.values))
+		}
+		h.verifyWritable(vs)
+	}
+	vs = h.open()
+	defer vs.Close()
+	namets, err := strconv.ParseInt(strings.TrimSuffix(path.Base(old), ".valuestoc"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := vs.compactFile(old, vs.valueLocBlockIDFromTimestampnano(namets))
+	if err != nil || result.rewrote != 1000 {
+		t.Fatal(result, err)
+	}
+}
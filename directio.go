@@ -0,0 +1,48 @@
+package valuestore
+
+import (
+	"unsafe"
+)
+
+// _DIRECT_IO_ALIGNMENT is what the offsets, lengths, and memory of O_DIRECT
+// writes are kept a multiple of; 4096 suits the logical block sizes of
+// common disks and filesystems.
+const _DIRECT_IO_ALIGNMENT = 4096
+
+// directIOConfig sets whether values files are written with O_DIRECT, which
+// needs every block written, including the encryption header if there were
+// one, to be a multiple of _DIRECT_IO_ALIGNMENT; only the last, short, write
+// of each file goes through the page cache.
+func (vs *DefaultValueStore) directIOConfig(cfg *Config) {
+	if !cfg.DirectIO {
+		return
+	}
+	if !directIOSupported {
+		vs.logWarning("DirectIO is not supported on this platform; ignoring\n")
+		return
+	}
+	if _, ok := vs.fileBackend.(osFileBackend); !ok {
+		vs.logWarning("DirectIO needs the default FileBackend; ignoring\n")
+		return
+	}
+	if vs.encryptionState.keyID != 0 {
+		vs.logWarning("DirectIO can't be used with encryption; ignoring\n")
+		return
+	}
+	if (vs.checksumInterval+4)%_DIRECT_IO_ALIGNMENT != 0 {
+		vs.logWarning("DirectIO needs ChecksumInterval to be four less than a multiple of %d; ignoring\n", _DIRECT_IO_ALIGNMENT)
+		return
+	}
+	vs.directIO = true
+}
+
+// alignedBytes returns a slice of length n whose memory starts on a
+// _DIRECT_IO_ALIGNMENT boundary.
+func alignedBytes(n int) []byte {
+	b := make([]byte, n+_DIRECT_IO_ALIGNMENT)
+	o := int(uintptr(unsafe.Pointer(&b[0])) & (_DIRECT_IO_ALIGNMENT - 1))
+	if o != 0 {
+		o = _DIRECT_IO_ALIGNMENT - o
+	}
+	return b[o : o+n]
+}
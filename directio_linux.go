@@ -0,0 +1,61 @@
+package valuestore
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+const directIOSupported = true
+
+// directWriteCloser writes a values file opened with O_DIRECT, copying each
+// block into aligned memory first as O_DIRECT requires. A write that isn't a
+// whole number of aligned blocks, which is only the last of the file, turns
+// O_DIRECT off, so it and anything after it go through the page cache.
+type directWriteCloser struct {
+	fp     *os.File
+	direct bool
+	buf    []byte
+}
+
+// createDirect creates the file at name for writing with O_DIRECT or, if the
+// filesystem doesn't support O_DIRECT, as usual.
+func createDirect(name string) (io.WriteCloser, error) {
+	fp, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_DIRECT, 0666)
+	if err == nil {
+		return &directWriteCloser{fp: fp, direct: true}, nil
+	}
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EINVAL {
+		return nil, err
+	}
+	return os.Create(name)
+}
+
+func (w *directWriteCloser) Write(b []byte) (int, error) {
+	if w.direct && len(b)%_DIRECT_IO_ALIGNMENT != 0 {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, w.fp.Fd(), syscall.F_GETFL, 0)
+		if errno == 0 {
+			_, _, errno = syscall.Syscall(syscall.SYS_FCNTL, w.fp.Fd(), syscall.F_SETFL, flags&^syscall.O_DIRECT)
+		}
+		if errno != 0 {
+			return 0, errno
+		}
+		w.direct = false
+	}
+	if !w.direct {
+		return w.fp.Write(b)
+	}
+	if cap(w.buf) < len(b) {
+		w.buf = alignedBytes(len(b))
+	}
+	copy(w.buf[:len(b)], b)
+	return w.fp.Write(w.buf[:len(b)])
+}
+
+func (w *directWriteCloser) Sync() error {
+	return w.fp.Sync()
+}
+
+func (w *directWriteCloser) Close() error {
+	return w.fp.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package valuestore
+
+import (
+	"io"
+)
+
+const directIOSupported = false
+
+func createDirect(name string) (io.WriteCloser, error) {
+	return osFileBackend{}.Create(name)
+}
@@ -0,0 +1,69 @@
+package valuestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestDirectIO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "directio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Path: dir, IgnoreEnv: true, DirectIO: true}
+	vs := New(cfg)
+	if vs.directIO != directIOSupported {
+		t.Fatal(vs.directIO)
+	}
+	vs.EnableWrites()
+	// Enough to fill several blocks, so most writes are direct and the
+	// last, short, one isn't.
+	value := bytes.Repeat([]byte("0123456789"), 1000)
+	for keyA := uint64(1); keyA <= 50; keyA++ {
+		if _, err = vs.Write(keyA, 2, 1000, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	for keyA := uint64(1); keyA <= 50; keyA++ {
+		if _, v, err := vs.Read(keyA, 2, nil); err != nil || !bytes.Equal(v, value) {
+			t.Fatal(keyA, err, len(v))
+		}
+	}
+	vs.Close()
+	vs = New(cfg)
+	defer vs.Close()
+	for keyA := uint64(1); keyA <= 50; keyA++ {
+		if _, v, err := vs.Read(keyA, 2, nil); err != nil || !bytes.Equal(v, value) {
+			t.Fatal(keyA, err, len(v))
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DirtyValuesFiles != 0 {
+		t.Fatal(stats.DirtyValuesFiles)
+	}
+}
+
+func TestDirectIOUnaligned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "directio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true, DirectIO: true, ChecksumInterval: 1000})
+	defer vs.Close()
+	if vs.directIO {
+		t.Fatal(vs.directIO)
+	}
+}
+
+func TestAlignedBytes(t *testing.T) {
+	for _, n := range []int{1, 4096, 65536} {
+		if b := alignedBytes(n); len(b) != n || uintptr(unsafe.Pointer(&b[0]))%_DIRECT_IO_ALIGNMENT != 0 {
+			t.Fatal(n, len(b))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package valuestore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const _DIR_LOCK_NAME = "valuestore.lock"
+
+// errDirLockHeld is a *LockError's Err when the lock is held by another
+// store in this process.
+var errDirLockHeld = errors.New("held by another store in this process")
+
+// dirLocks are the lock files held by this process, by name. The flock on
+// each keeps a ValueStore in another process from opening the same
+// directories; being in held keeps another store in this one from doing so,
+// as a process's flocks don't exclude each other everywhere.
+var dirLocks = struct {
+	lock sync.Mutex
+	held map[string]*os.File
+}{held: make(map[string]*os.File)}
+
+// dirLock locks each of the Paths and PathsTOC, failing with a *LockError if
+// another store has any locked.
+func (vs *DefaultValueStore) dirLock() error {
+	if !flockSupported {
+		vs.logWarning("directory locks only exclude stores in this process on this platform\n")
+	}
+	for _, dir := range uniquePaths(vs.valuesDirs(), vs.tocDirs()) {
+		name, err := filepath.Abs(filepath.Join(dir, _DIR_LOCK_NAME))
+		if err != nil {
+			vs.dirUnlock()
+			return &LockError{Path: dir, Err: err}
+		}
+		already := false
+		for _, held := range vs.dirLocks {
+			if held == name {
+				already = true
+			}
+		}
+		if already {
+			continue
+		}
+		if err = dirLockAcquire(name); err != nil {
+			vs.dirUnlock()
+			return &LockError{Path: name, Err: err}
+		}
+		vs.dirLocks = append(vs.dirLocks, name)
+	}
+	return nil
+}
+
+// dirUnlock releases the locks taken by dirLock.
+func (vs *DefaultValueStore) dirUnlock() {
+	for _, name := range vs.dirLocks {
+		dirLockRelease(name)
+	}
+	vs.dirLocks = nil
+}
+
+func dirLockAcquire(name string) error {
+	dirLocks.lock.Lock()
+	defer dirLocks.lock.Unlock()
+	if dirLocks.held[name] != nil {
+		return errDirLockHeld
+	}
+	fp, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err = flock(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	dirLocks.held[name] = fp
+	return nil
+}
+
+func dirLockRelease(name string) {
+	dirLocks.lock.Lock()
+	defer dirLocks.lock.Unlock()
+	if fp := dirLocks.held[name]; fp != nil {
+		// Closing the file releases the lock.
+		fp.Close()
+		delete(dirLocks.held, name)
+	}
+}
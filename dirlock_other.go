@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package valuestore
+
+import (
+	"os"
+)
+
+const flockSupported = false
+
+func flock(fp *os.File) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package valuestore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirLock(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	// Another process's lock is as another open of the lock file.
+	fp, err := os.OpenFile(path.Join(h.dir, _DIR_LOCK_NAME), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flock(fp); err != nil {
+		t.Fatal(err)
+	}
+	cfg := *h.cfg
+	if flockSupported {
+		_, err = NewWithContext(context.Background(), &cfg)
+		var lerr *LockError
+		if !errors.Is(err, ErrLocked) || !errors.As(err, &lerr) || path.Base(lerr.Path) != _DIR_LOCK_NAME {
+			t.Fatal(err)
+		}
+	}
+	fp.Close()
+	// Another store in the same process is locked out too, until the first
+	// is closed.
+	vs := h.open()
+	_, err = NewWithContext(context.Background(), &cfg)
+	var lerr *LockError
+	if !errors.Is(err, ErrLocked) || !errors.As(err, &lerr) || lerr.Err != errDirLockHeld {
+		t.Fatal(err)
+	}
+	vs.Close()
+	vs.Close()
+	name, err := filepath.Abs(path.Join(h.dir, _DIR_LOCK_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp := dirLocks.held[name]; fp != nil {
+		t.Fatal(fp)
+	}
+	vs = h.open()
+	vs.Close()
+}
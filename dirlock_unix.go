@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package valuestore
+
+import (
+	"os"
+	"syscall"
+)
+
+const flockSupported = true
+
+// flock takes an exclusive advisory lock on the file, failing rather than
+// waiting if another open of it holds one.
+func flock(fp *os.File) error {
+	return syscall.Flock(int(fp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
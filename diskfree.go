@@ -0,0 +1,113 @@
+package valuestore
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// diskFreeState refuses new writes, with Config.DiskFreeMinimum, while the
+// free space on Path, or any of the Paths, is below the minimum, leaving the
+// space that is left for the values already buffered and for deletes and
+// compaction to make more. Writes are accepted again once the free space is
+// back above the minimum plus the hysteresis. Separately, a values or TOC
+// file write that fails for lack of space is retried every interval rather
+// than losing the buffered pages; the disk counts as full in the meantime.
+type diskFreeState struct {
+	minimum    uint64
+	hysteresis uint64
+	interval   time.Duration
+	detect     func(p string) uint64
+	// full is set while writes are being refused.
+	full int32
+}
+
+func (vs *DefaultValueStore) diskFreeConfig(cfg *Config) {
+	s := &vs.diskFreeState
+	s.minimum = uint64(cfg.DiskFreeMinimum)
+	s.hysteresis = uint64(cfg.DiskFreeHysteresis)
+	s.interval = time.Duration(cfg.DiskFreeInterval) * time.Millisecond
+	s.detect = detectFreeBytes
+}
+
+func (vs *DefaultValueStore) diskFreeLaunch() {
+	if vs.diskFreeState.minimum > 0 {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.diskFreeChecker()
+	}
+}
+
+func (vs *DefaultValueStore) diskFreeChecker() {
+	for vs.closeSleep(vs.diskFreeState.interval) {
+		vs.diskFreeCheck()
+	}
+	vs.closeState.backgroundWG.Done()
+}
+
+// diskFreeCheck marks the disk full once the free space drops below the
+// minimum, and no longer full once it is back above the minimum plus the
+// hysteresis. Free space that can't be determined leaves things as they are.
+func (vs *DefaultValueStore) diskFreeCheck() {
+	s := &vs.diskFreeState
+	var least uint64
+	dir := vs.path
+	for _, p := range vs.valuesDirs() {
+		if free := s.detect(p); free != 0 && (least == 0 || free < least) {
+			least = free
+			dir = p
+		}
+	}
+	if least == 0 {
+		return
+	}
+	if least < s.minimum {
+		vs.diskFreeFull(dir)
+	} else if least >= s.minimum+s.hysteresis && atomic.CompareAndSwapInt32(&s.full, 1, 0) {
+		vs.logInfo("%d bytes free on %s; accepting writes again\n", least, dir)
+	}
+}
+
+func (vs *DefaultValueStore) diskFreeFull(dir string) {
+	if atomic.CompareAndSwapInt32(&vs.diskFreeState.full, 0, 1) {
+		atomic.AddInt32(&vs.diskFulls, 1)
+		vs.logError("%s is full; refusing writes\n", dir)
+	}
+}
+
+// diskFull returns ErrDiskFull while writes are being refused, for the free
+// space or for the Paths being at their PathsLimits.
+func (vs *DefaultValueStore) diskFull() error {
+	if atomic.LoadInt32(&vs.diskFreeState.full) != 0 || atomic.LoadInt32(&vs.pathsLimitState.full) != 0 {
+		atomic.AddInt32(&vs.diskFullRejections, 1)
+		return ErrDiskFull
+	}
+	return nil
+}
+
+// diskFreeWait is called with the error from writing to a values or TOC
+// file and returns true if the write should be retried: the disk was full,
+// and has now been waited on for an interval. Once a retry succeeds without
+// the free space checker running, diskFreeRetried marks the disk no longer
+// full; with the checker, that's left to it and its hysteresis.
+func (vs *DefaultValueStore) diskFreeWait(err error) bool {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return false
+	}
+	dir := vs.path
+	var perr *os.PathError
+	if errors.As(err, &perr) {
+		dir = path.Dir(perr.Path)
+	}
+	vs.diskFreeFull(dir)
+	atomic.AddInt32(&vs.diskFullRetries, 1)
+	return vs.closeSleep(vs.diskFreeState.interval)
+}
+
+func (vs *DefaultValueStore) diskFreeRetried() {
+	if vs.diskFreeState.minimum == 0 && atomic.CompareAndSwapInt32(&vs.diskFreeState.full, 1, 0) {
+		vs.logInfo("space freed; accepting writes again\n")
+	}
+}
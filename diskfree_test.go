@@ -0,0 +1,101 @@
+package valuestore
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+)
+
+func TestDiskFreeMinimum(t *testing.T) {
+	vs := New(&Config{
+		Path:            t.TempDir(),
+		IgnoreEnv:       true,
+		DiskFreeMinimum: 1000,
+		// Long enough that the checker stays out of the way.
+		DiskFreeInterval: 1000000000,
+	})
+	vs.EnableWrites()
+	defer vs.Close()
+	if vs.diskFreeState.hysteresis != 100 {
+		t.Fatal(vs.diskFreeState.hysteresis)
+	}
+	if _, err := vs.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	free := uint64(999)
+	vs.diskFreeState.detect = func(p string) uint64 {
+		return free
+	}
+	vs.diskFreeCheck()
+	if _, err := vs.Write(1, 3, 1000, []byte("value")); err != ErrDiskFull {
+		t.Fatal(err)
+	}
+	b := vs.NewWriteBatch()
+	b.Write(1, 3, []byte("value"))
+	if err := b.Commit(1001); err != ErrDiskFull {
+		t.Fatal(err)
+	}
+	if _, err := vs.WriteStream(1, 3, 1001, 5, bytes.NewReader([]byte("value"))); err != ErrDiskFull {
+		t.Fatal(err)
+	}
+	// Reads and deletes carry on.
+	if _, v, err := vs.Read(1, 2, nil); err != nil || string(v) != "value" {
+		t.Fatal(string(v), err)
+	}
+	if _, err := vs.Delete(1, 2, 1001); err != nil {
+		t.Fatal(err)
+	}
+	// Not until the hysteresis is cleared too are writes accepted again.
+	free = 1099
+	vs.diskFreeCheck()
+	if _, err := vs.Write(1, 3, 1000, []byte("value")); err != ErrDiskFull {
+		t.Fatal(err)
+	}
+	free = 1100
+	vs.diskFreeCheck()
+	if _, err := vs.Write(1, 3, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DiskFulls != 1 || stats.DiskFullRejections != 4 {
+		t.Fatal(stats.DiskFulls, stats.DiskFullRejections)
+	}
+}
+
+type enospcWriteCloser struct {
+	bytes.Buffer
+	fails int
+}
+
+func (w *enospcWriteCloser) Write(b []byte) (int, error) {
+	if w.fails > 0 {
+		w.fails--
+		n, _ := w.Buffer.Write(b[:len(b)/2])
+		return n, syscall.ENOSPC
+	}
+	return w.Buffer.Write(b)
+}
+
+func (w *enospcWriteCloser) Close() error {
+	return nil
+}
+
+func TestDiskFullRetry(t *testing.T) {
+	vs := New(&Config{Path: t.TempDir(), IgnoreEnv: true, DiskFreeInterval: 1})
+	vs.EnableWrites()
+	defer vs.Close()
+	fp := &enospcWriteCloser{fails: 2}
+	w := &countingWriteCloser{WriteCloser: fp, vs: vs, disk: vs.diskHealthState.values}
+	if n, err := w.Write([]byte("0123456789")); n != 10 || err != nil {
+		t.Fatal(n, err)
+	}
+	if fp.String() != "0123456789" {
+		t.Fatal(fp.String())
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DiskFulls != 1 || stats.DiskFullRetries != 2 {
+		t.Fatal(stats.DiskFulls, stats.DiskFullRetries)
+	}
+	// The disk no longer counts as full once the write has gone through.
+	if _, err := vs.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+}
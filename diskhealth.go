@@ -0,0 +1,230 @@
+package valuestore
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// _DISK_LATENCY_BUCKETS is how many latency histogram buckets are kept; the
+// bucket i counts latencies under 1<<i microseconds, with the last bucket
+// counting anything longer.
+const _DISK_LATENCY_BUCKETS = 25
+
+// DiskStats are the IO stats for one of the directories the store uses,
+// Config.Path or, if different, Config.PathTOC. The latencies are upper
+// bounds, rounded up to the next power of two microseconds.
+type DiskStats struct {
+	Path            string
+	Reads           int32
+	ReadErrors      int32
+	ReadLatencyP50  time.Duration
+	ReadLatencyP99  time.Duration
+	Writes          int32
+	WriteErrors     int32
+	WriteLatencyP50 time.Duration
+	WriteLatencyP99 time.Duration
+}
+
+func (d *DiskStats) String() string {
+	return fmt.Sprintf("%d reads, %d errors, p50 %s, p99 %s; %d writes, %d errors, p50 %s, p99 %s", d.Reads, d.ReadErrors, d.ReadLatencyP50, d.ReadLatencyP99, d.Writes, d.WriteErrors, d.WriteLatencyP50, d.WriteLatencyP99)
+}
+
+// diskCounts are the running totals for a disk; they only go up, and
+// differences between two copies give the stats in between.
+type diskCounts struct {
+	reads        int32
+	readErrors   int32
+	readLatency  [_DISK_LATENCY_BUCKETS]int32
+	writes       int32
+	writeErrors  int32
+	writeLatency [_DISK_LATENCY_BUCKETS]int32
+}
+
+type disk struct {
+	path   string
+	counts diskCounts
+	// lastStats and lastCheck are the counts as of the last Stats call and
+	// the last health check.
+	lastStats diskCounts
+	lastCheck diskCounts
+}
+
+// diskHealthState tracks the IO latencies and errors of each disk and, with
+// Config.DiskHealth set, periodically asks it whether the store should stop
+// using the disk as much.
+type diskHealthState struct {
+	disks    []*disk
+	values   *disk
+	toc      *disk
+	check    DiskHealthFunc
+	interval time.Duration
+	// lock is held while the disks' counts are compared with and copied to
+	// their lastStats or lastCheck.
+	lock sync.Mutex
+	// action is the most severe DiskHealth action taken so far.
+	action int32
+}
+
+func (vs *DefaultValueStore) diskHealthConfig(cfg *Config) {
+	s := &vs.diskHealthState
+	s.values = &disk{path: vs.path}
+	s.disks = []*disk{s.values}
+	s.toc = s.values
+	if vs.pathtoc != vs.path {
+		s.toc = &disk{path: vs.pathtoc}
+		s.disks = append(s.disks, s.toc)
+	}
+	s.check = cfg.DiskHealth
+	s.interval = time.Duration(cfg.DiskHealthInterval) * time.Second
+}
+
+func (vs *DefaultValueStore) diskHealthLaunch() {
+	if vs.diskHealthState.check != nil {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.diskHealthChecker()
+	}
+}
+
+func (vs *DefaultValueStore) diskHealthChecker() {
+	for vs.closeSleep(vs.diskHealthState.interval) {
+		vs.diskHealthCheck()
+	}
+	vs.closeState.backgroundWG.Done()
+}
+
+// diskHealthCheck calls the DiskHealth func with each disk's stats since the
+// last check and acts on the most severe answer. Actions only escalate; once
+// taken they stay in effect until the operator reenables things, such as
+// with EnableAll, so a disk that looks better for a moment isn't trusted
+// again automatically.
+func (vs *DefaultValueStore) diskHealthCheck() {
+	s := &vs.diskHealthState
+	action := DISK_HEALTHY
+	for _, d := range s.disks {
+		s.lock.Lock()
+		counts := d.snapshot()
+		stats := counts.stats(d.path, &d.lastCheck)
+		d.lastCheck = counts
+		s.lock.Unlock()
+		if a := s.check(stats); a > action {
+			action = a
+		}
+	}
+	prev := atomic.LoadInt32(&s.action)
+	if action <= int(prev) || !atomic.CompareAndSwapInt32(&s.action, prev, int32(action)) {
+		return
+	}
+	switch action {
+	case DISK_READ_ONLY:
+		vs.logCritical("disk health check: disabling writes\n")
+		vs.DisableWrites()
+	case DISK_EVACUATE:
+		// Push replication hands off the partitions this node is no longer
+		// responsible for, so once the ring moves them elsewhere, it moves
+		// the data off the disk.
+		vs.logCritical("disk health check: disabling writes and background work other than push replication\n")
+		vs.DisableWrites()
+		vs.DisableTombstoneDiscard()
+		vs.DisableCompaction()
+		vs.DisableOutPullReplication()
+		vs.EnableOutPushReplication()
+	}
+}
+
+// read records a read from the disk that took d and failed with err, if not
+// nil.
+func (dk *disk) read(d time.Duration, err error) {
+	atomic.AddInt32(&dk.counts.reads, 1)
+	atomic.AddInt32(&dk.counts.readLatency[diskLatencyBucket(d)], 1)
+	if err != nil {
+		atomic.AddInt32(&dk.counts.readErrors, 1)
+	}
+}
+
+// write records a write to the disk that took d and failed with err, if not
+// nil.
+func (dk *disk) write(d time.Duration, err error) {
+	atomic.AddInt32(&dk.counts.writes, 1)
+	atomic.AddInt32(&dk.counts.writeLatency[diskLatencyBucket(d)], 1)
+	if err != nil {
+		atomic.AddInt32(&dk.counts.writeErrors, 1)
+	}
+}
+
+// diskStats returns each disk's stats since the last call.
+func (vs *DefaultValueStore) diskStats() []DiskStats {
+	s := &vs.diskHealthState
+	stats := make([]DiskStats, len(s.disks))
+	s.lock.Lock()
+	for i, d := range s.disks {
+		counts := d.snapshot()
+		stats[i] = counts.stats(d.path, &d.lastStats)
+		d.lastStats = counts
+	}
+	s.lock.Unlock()
+	return stats
+}
+
+func diskLatencyBucket(d time.Duration) int {
+	micro := uint64(d / time.Microsecond)
+	i := 0
+	for ; i < _DISK_LATENCY_BUCKETS-1 && micro >= 1<<uint(i); i++ {
+	}
+	return i
+}
+
+func (d *disk) snapshot() diskCounts {
+	var c diskCounts
+	c.reads = atomic.LoadInt32(&d.counts.reads)
+	c.readErrors = atomic.LoadInt32(&d.counts.readErrors)
+	c.writes = atomic.LoadInt32(&d.counts.writes)
+	c.writeErrors = atomic.LoadInt32(&d.counts.writeErrors)
+	for i := 0; i < _DISK_LATENCY_BUCKETS; i++ {
+		c.readLatency[i] = atomic.LoadInt32(&d.counts.readLatency[i])
+		c.writeLatency[i] = atomic.LoadInt32(&d.counts.writeLatency[i])
+	}
+	return c
+}
+
+// stats returns the DiskStats for the counts since the earlier counts.
+func (c *diskCounts) stats(path string, earlier *diskCounts) DiskStats {
+	var readLatency, writeLatency [_DISK_LATENCY_BUCKETS]int32
+	for i := 0; i < _DISK_LATENCY_BUCKETS; i++ {
+		readLatency[i] = c.readLatency[i] - earlier.readLatency[i]
+		writeLatency[i] = c.writeLatency[i] - earlier.writeLatency[i]
+	}
+	return DiskStats{
+		Path:            path,
+		Reads:           c.reads - earlier.reads,
+		ReadErrors:      c.readErrors - earlier.readErrors,
+		ReadLatencyP50:  diskLatencyPercentile(readLatency, 50),
+		ReadLatencyP99:  diskLatencyPercentile(readLatency, 99),
+		Writes:          c.writes - earlier.writes,
+		WriteErrors:     c.writeErrors - earlier.writeErrors,
+		WriteLatencyP50: diskLatencyPercentile(writeLatency, 50),
+		WriteLatencyP99: diskLatencyPercentile(writeLatency, 99),
+	}
+}
+
+// diskLatencyPercentile returns the upper bound of the bucket holding the
+// percentile given, or 0 if the buckets are empty.
+func diskLatencyPercentile(buckets [_DISK_LATENCY_BUCKETS]int32, percentile int) time.Duration {
+	var total int64
+	for _, n := range buckets {
+		total += int64(n)
+	}
+	if total == 0 {
+		return 0
+	}
+	want := (total*int64(percentile) + 99) / 100
+	var seen int64
+	for i, n := range buckets {
+		seen += int64(n)
+		if seen >= want {
+			return time.Duration(1<<uint(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(1<<uint(_DISK_LATENCY_BUCKETS-1)) * time.Microsecond
+}
@@ -0,0 +1,91 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiskLatencyPercentile(t *testing.T) {
+	var buckets [_DISK_LATENCY_BUCKETS]int32
+	if p := diskLatencyPercentile(buckets, 50); p != 0 {
+		t.Fatal(p)
+	}
+	buckets[diskLatencyBucket(0)] += 98
+	buckets[diskLatencyBucket(100*time.Microsecond)] += 1
+	buckets[diskLatencyBucket(time.Hour)] += 1
+	if p := diskLatencyPercentile(buckets, 50); p != time.Microsecond {
+		t.Fatal(p)
+	}
+	if p := diskLatencyPercentile(buckets, 99); p != 128*time.Microsecond {
+		t.Fatal(p)
+	}
+	if p := diskLatencyPercentile(buckets, 100); p != time.Duration(1<<uint(_DISK_LATENCY_BUCKETS-1))*time.Microsecond {
+		t.Fatal(p)
+	}
+}
+
+func TestDiskHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskhealth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	action := DISK_HEALTHY
+	var checked []DiskStats
+	vs := New(&Config{
+		Path:      dir,
+		IgnoreEnv: true,
+		DiskHealth: func(stats DiskStats) int {
+			checked = append(checked, stats)
+			return action
+		},
+		DiskHealthInterval: 3600,
+	})
+	defer vs.Close()
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 100; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	verify := func() {
+		for keyB := uint64(1); keyB <= 100; keyB++ {
+			if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d", keyB) {
+				t.Fatal(keyB, string(value), err)
+			}
+		}
+	}
+	verify()
+	stats := vs.Stats(false).(*Stats)
+	if len(stats.Disks) != 1 || stats.Disks[0].Path != dir || stats.Disks[0].Reads != 100 || stats.Disks[0].Writes == 0 || stats.Disks[0].ReadErrors != 0 || stats.Disks[0].WriteErrors != 0 {
+		t.Fatal(stats.Disks)
+	}
+	// The health check has its own view, since its last check, apart from
+	// the Stats calls.
+	vs.diskHealthCheck()
+	if len(checked) != 1 || checked[0].Reads != 100 {
+		t.Fatal(checked)
+	}
+	if stats = vs.Stats(false).(*Stats); stats.Disks[0].Reads != 0 || stats.DiskHealth != DISK_HEALTHY {
+		t.Fatal(stats.Disks, stats.DiskHealth)
+	}
+	action = DISK_READ_ONLY
+	vs.diskHealthCheck()
+	if _, err := vs.Write(1, 1, 1000000, []byte("x")); err != ErrDisabled {
+		t.Fatal(err)
+	}
+	// Reads still work, and a healthy answer doesn't undo the action.
+	action = DISK_HEALTHY
+	vs.diskHealthCheck()
+	verify()
+	if stats = vs.Stats(false).(*Stats); stats.DiskHealth != DISK_READ_ONLY {
+		t.Fatal(stats.DiskHealth)
+	}
+	if _, err := vs.Write(1, 1, 1000000, []byte("x")); err != ErrDisabled {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,300 @@
+package valuestore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// An encrypted values or TOC file starts with a _ENCRYPTION_HEADER_LENGTH
+// byte header: _ENCRYPTION_MAGIC, the key ID, the block size, and a random
+// 12 byte nonce base for the file. Then follows what the file would hold
+// unencrypted, sealed with AES-GCM a block at a time; each block is a
+// checksum interval plus its checksum, so reads only have to decrypt the
+// blocks they touch, and gains a 16 byte tag. A block's nonce is the base
+// with the block's index XORed into its last 8 bytes, and the header is the
+// additional data for every block. Files without the magic are unencrypted.
+const (
+	_ENCRYPTION_MAGIC         = "VALUESTORE ENC0 "
+	_ENCRYPTION_HEADER_LENGTH = 48
+)
+
+var errDecryption = errors.New("unable to decrypt block")
+
+type encryptionState struct {
+	// keyID is what new files are encrypted with, 0 for none.
+	keyID uint32
+	// aeads holds an AEAD for each key ID files may have been written with.
+	aeads map[uint32]cipher.AEAD
+}
+
+func (vs *DefaultValueStore) encryptionConfig(cfg *Config) error {
+	vs.encryptionState.aeads = make(map[uint32]cipher.AEAD)
+	add := func(keyID uint32, key []byte) error {
+		if keyID == 0 {
+			return errors.New("encryption key ID 0 is reserved for unencrypted files")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("encryption key ID %d: %s", keyID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("encryption key ID %d: %s", keyID, err)
+		}
+		vs.encryptionState.aeads[keyID] = aead
+		return nil
+	}
+	for keyID, key := range cfg.EncryptionKeys {
+		if err := add(keyID, key); err != nil {
+			return err
+		}
+	}
+	if len(cfg.EncryptionKey) > 0 {
+		if err := add(cfg.EncryptionKeyID, cfg.EncryptionKey); err != nil {
+			return err
+		}
+		vs.encryptionState.keyID = cfg.EncryptionKeyID
+	}
+	return nil
+}
+
+// encryptWriteCloser returns w as is, if there is no Config.EncryptionKey,
+// or else writes the header to w and returns a writer encrypting to it in
+// blocks of blockSize, which should be the checksum interval plus 4.
+func (vs *DefaultValueStore) encryptWriteCloser(w io.WriteCloser, blockSize uint32) (io.WriteCloser, error) {
+	keyID := vs.encryptionState.keyID
+	if keyID == 0 {
+		return w, nil
+	}
+	e := &encryptedWriteCloser{
+		w:     w,
+		aead:  vs.encryptionState.aeads[keyID],
+		head:  make([]byte, _ENCRYPTION_HEADER_LENGTH),
+		block: make([]byte, 0, blockSize),
+	}
+	copy(e.head, _ENCRYPTION_MAGIC)
+	binary.BigEndian.PutUint32(e.head[16:], keyID)
+	binary.BigEndian.PutUint32(e.head[20:], blockSize)
+	if _, err := io.ReadFull(rand.Reader, e.head[24:36]); err != nil {
+		return nil, err
+	}
+	copy(e.nonce[:], e.head[24:36])
+	if _, err := w.Write(e.head); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+type encryptedWriteCloser struct {
+	w     io.WriteCloser
+	aead  cipher.AEAD
+	head  []byte
+	nonce [12]byte
+	index uint64
+	block []byte
+	out   []byte
+}
+
+func (e *encryptedWriteCloser) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		c := copy(e.block[len(e.block):cap(e.block)], b)
+		e.block = e.block[:len(e.block)+c]
+		b = b[c:]
+		n += c
+		if len(e.block) == cap(e.block) {
+			if err := e.seal(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (e *encryptedWriteCloser) seal() error {
+	nonce := blockNonce(e.nonce, e.index)
+	e.index++
+	e.out = e.aead.Seal(e.out[:0], nonce[:], e.block, e.head)
+	e.block = e.block[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// Sync passes through to the underlying writer, as countingWriteCloser's
+// does. Values files are only synced after whole blocks, so nothing is left
+// waiting in a partial block.
+func (e *encryptedWriteCloser) Sync() error {
+	if s, ok := e.w.(interface {
+		Sync() error
+	}); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (e *encryptedWriteCloser) Close() error {
+	if len(e.block) > 0 {
+		if err := e.seal(); err != nil {
+			e.w.Close()
+			return err
+		}
+	}
+	return e.w.Close()
+}
+
+func blockNonce(base [12]byte, index uint64) [12]byte {
+	binary.BigEndian.PutUint64(base[4:], binary.BigEndian.Uint64(base[4:])^index)
+	return base
+}
+
+// decryptReadSeeker returns rs as is, with key ID 0, if it is not an
+// encrypted file, or else a reader of its decrypted contents along with the
+// key ID it was written with. Either way, rs is left at the start of the
+// contents.
+func (vs *DefaultValueStore) decryptReadSeeker(rs io.ReadSeeker) (io.ReadSeeker, uint32, error) {
+	head := make([]byte, _ENCRYPTION_HEADER_LENGTH)
+	if _, err := io.ReadFull(rs, head[:len(_ENCRYPTION_MAGIC)]); err != nil || string(head[:len(_ENCRYPTION_MAGIC)]) != _ENCRYPTION_MAGIC {
+		_, err = rs.Seek(0, 0)
+		return rs, 0, err
+	}
+	if _, err := io.ReadFull(rs, head[len(_ENCRYPTION_MAGIC):]); err != nil {
+		return nil, 0, err
+	}
+	keyID := binary.BigEndian.Uint32(head[16:])
+	aead := vs.encryptionState.aeads[keyID]
+	if aead == nil {
+		return nil, keyID, fmt.Errorf("no encryption key for ID %d", keyID)
+	}
+	d := &encryptedReadSeeker{
+		rs:        rs,
+		aead:      aead,
+		head:      head,
+		blockSize: int64(binary.BigEndian.Uint32(head[20:])),
+		index:     -1,
+	}
+	copy(d.nonce[:], head[24:36])
+	if d.blockSize < 1 {
+		return nil, keyID, fmt.Errorf("bad encryption block size %d", d.blockSize)
+	}
+	size, err := rs.Seek(0, 2)
+	if err != nil {
+		return nil, keyID, err
+	}
+	size -= _ENCRYPTION_HEADER_LENGTH
+	sealedSize := d.blockSize + int64(d.aead.Overhead())
+	d.size = size / sealedSize * d.blockSize
+	if rem := size % sealedSize; rem > int64(d.aead.Overhead()) {
+		d.size += rem - int64(d.aead.Overhead())
+	}
+	return d, keyID, nil
+}
+
+type encryptedReadSeeker struct {
+	rs        io.ReadSeeker
+	aead      cipher.AEAD
+	head      []byte
+	nonce     [12]byte
+	blockSize int64
+	size      int64
+	offset    int64
+	// index is that of the block held decrypted in plain, or -1.
+	index  int64
+	plain  []byte
+	sealed []byte
+}
+
+func (d *encryptedReadSeeker) Read(b []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	i := d.offset / d.blockSize
+	if i != d.index {
+		d.index = -1
+		sealedSize := d.blockSize + int64(d.aead.Overhead())
+		if _, err := d.rs.Seek(_ENCRYPTION_HEADER_LENGTH+i*sealedSize, 0); err != nil {
+			return 0, err
+		}
+		if int64(cap(d.sealed)) < sealedSize {
+			d.sealed = make([]byte, sealedSize)
+		}
+		n, err := io.ReadFull(d.rs, d.sealed[:sealedSize])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		nonce := blockNonce(d.nonce, uint64(i))
+		if d.plain, err = d.aead.Open(d.plain[:0], nonce[:], d.sealed[:n], d.head); err != nil {
+			return 0, errDecryption
+		}
+		d.index = i
+	}
+	within := d.offset - i*d.blockSize
+	if within >= int64(len(d.plain)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(b, d.plain[within:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+func (d *encryptedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 1:
+		offset += d.offset
+	case 2:
+		offset += d.size
+	}
+	if offset < 0 {
+		return d.offset, errors.New("negative seek")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *encryptedReadSeeker) Close() error {
+	if c, ok := d.rs.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// encryptionKeyID returns the key ID the file at name was encrypted with, or
+// 0 if it isn't encrypted.
+func encryptionKeyID(name string) (uint32, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+	head := make([]byte, 20)
+	if _, err = io.ReadFull(fp, head); err != nil || string(head[:len(_ENCRYPTION_MAGIC)]) != _ENCRYPTION_MAGIC {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint32(head[16:]), nil
+}
+
+// readSeekCloser is what openFile returns.
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openFile opens the values or TOC file at name for reading, decrypting it if
+// need be.
+func (vs *DefaultValueStore) openFile(name string) (readSeekCloser, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	rs, _, err := vs.decryptReadSeeker(fp)
+	if err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return rs.(readSeekCloser), nil
+}
@@ -0,0 +1,316 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// erasure manifest: version:1 dataShards:1 parityShards:1 length:8 followed,
+// with dataShards 0, by the value itself or otherwise by a murmur3 checksum:4
+// for each shard, data shards first.
+const _ERASURE_MANIFEST_VERSION = 0
+const _ERASURE_MANIFEST_HEADER_LENGTH = 11
+
+var errBadErasureManifest = errors.New("bad erasure manifest")
+
+// erasureState holds the settings WriteErasure splits new values with; reads
+// go by what each value's manifest records, so the settings can change
+// without affecting the values already written.
+type erasureState struct {
+	dataShards   int
+	parityShards int
+	minLength    int
+}
+
+func (vs *DefaultValueStore) erasureConfig(cfg *Config) {
+	vs.erasureState.dataShards = cfg.ErasureDataShards
+	vs.erasureState.parityShards = cfg.ErasureParityShards
+	vs.erasureState.minLength = cfg.ErasureMinLength
+}
+
+// WriteErasure is Write for large values, such as archival data, that would
+// rather not have a full copy kept on every replica. A value at least
+// Config.ErasureMinLength long is split into Config.ErasureDataShards data
+// shards and Config.ErasureParityShards parity shards are computed from them;
+// each shard is written, with the same timestamp, under a key derived from
+// keyA and keyB, so the shards land in other partitions and are spread over
+// the ring. A small manifest recording the shard counts and checksums is then
+// written under keyA and keyB itself and replicates like any other value.
+// Shorter values, or all of them with ErasureDataShards 0, are kept whole in
+// the manifest.
+//
+// Values so written must be read with ReadErasure and deleted with
+// DeleteErasure. A split value may be up to ErasureDataShards times ValueCap
+// long. The return values are those of the Write of the manifest, which isn't
+// made if writing a shard fails.
+func (vs *DefaultValueStore) WriteErasure(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
+	dataShards := vs.erasureState.dataShards
+	parityShards := vs.erasureState.parityShards
+	if dataShards == 0 || len(value) < vs.erasureState.minLength {
+		dataShards = 0
+		parityShards = 0
+	}
+	manifest := make([]byte, _ERASURE_MANIFEST_HEADER_LENGTH, _ERASURE_MANIFEST_HEADER_LENGTH+4*(dataShards+parityShards))
+	manifest[0] = _ERASURE_MANIFEST_VERSION
+	manifest[1] = byte(dataShards)
+	manifest[2] = byte(parityShards)
+	binary.BigEndian.PutUint64(manifest[3:], uint64(len(value)))
+	if dataShards == 0 {
+		return vs.Write(keyA, keyB, timestampmicro, append(manifest, value...))
+	}
+	manifest = manifest[:cap(manifest)]
+	for i, shard := range erasureEncode(value, dataShards, parityShards) {
+		binary.BigEndian.PutUint32(manifest[_ERASURE_MANIFEST_HEADER_LENGTH+4*i:], murmur3.Sum32(shard))
+		shardKeyA, shardKeyB := erasureShardKey(keyA, keyB, i)
+		if _, err := vs.Write(shardKeyA, shardKeyB, timestampmicro, shard); err != nil {
+			return 0, err
+		}
+	}
+	return vs.Write(keyA, keyB, timestampmicro, manifest)
+}
+
+// ReadErasure is Read for values written with WriteErasure. The shards are
+// read locally where possible; those the local node doesn't have are asked
+// of their replicas with ReadQuorum. Any shard missing or not matching its
+// checksum is rebuilt from the others, counted by ErasureReconstructions, and
+// if fewer than the data shard count are to be had ErrErasureShards is
+// returned.
+func (vs *DefaultValueStore) ReadErasure(keyA uint64, keyB uint64, value []byte) (int64, []byte, error) {
+	timestampmicro, manifest, err := vs.Read(keyA, keyB, nil)
+	if err != nil {
+		return timestampmicro, value, err
+	}
+	dataShards, parityShards, length, err := erasureManifest(manifest)
+	if err != nil {
+		return timestampmicro, value, err
+	}
+	if dataShards == 0 {
+		return timestampmicro, append(value, manifest[_ERASURE_MANIFEST_HEADER_LENGTH:]...), nil
+	}
+	shardLength := (length + dataShards - 1) / dataShards
+	shards := make([][]byte, dataShards+parityShards)
+	have := 0
+	get := func(i int, read func(keyA uint64, keyB uint64, value []byte) (int64, []byte, error)) {
+		shardKeyA, shardKeyB := erasureShardKey(keyA, keyB, i)
+		_, shard, err := read(shardKeyA, shardKeyB, nil)
+		if err == nil && len(shard) == shardLength && murmur3.Sum32(shard) == binary.BigEndian.Uint32(manifest[_ERASURE_MANIFEST_HEADER_LENGTH+4*i:]) {
+			shards[i] = shard
+			have++
+		}
+	}
+	for i := 0; i < len(shards) && have < dataShards; i++ {
+		get(i, vs.Read)
+	}
+	if vs.msgRing != nil {
+		for i := 0; i < len(shards) && have < dataShards; i++ {
+			if shards[i] == nil {
+				get(i, vs.ReadQuorum)
+			}
+		}
+	}
+	if have < dataShards {
+		return timestampmicro, value, ErrErasureShards
+	}
+	for i := 0; i < dataShards; i++ {
+		if shards[i] == nil {
+			erasureReconstruct(shards, dataShards)
+			atomic.AddInt32(&vs.erasureReconstructions, 1)
+			break
+		}
+	}
+	start := len(value)
+	for i := 0; i < dataShards; i++ {
+		value = append(value, shards[i]...)
+	}
+	return timestampmicro, value[:start+length], nil
+}
+
+// DeleteErasure is Delete for values written with WriteErasure; the shards
+// named by the manifest, as ReadQuorum finds it, are deleted before the
+// manifest itself.
+func (vs *DefaultValueStore) DeleteErasure(keyA uint64, keyB uint64, timestampmicro int64) (int64, error) {
+	if _, manifest, err := vs.ReadQuorum(keyA, keyB, nil); err == nil {
+		if dataShards, parityShards, _, err := erasureManifest(manifest); err == nil {
+			for i := 0; i < dataShards+parityShards; i++ {
+				shardKeyA, shardKeyB := erasureShardKey(keyA, keyB, i)
+				if _, err := vs.Delete(shardKeyA, shardKeyB, timestampmicro); err != nil && err != ErrNewerVersion {
+					return 0, err
+				}
+			}
+		}
+	}
+	return vs.Delete(keyA, keyB, timestampmicro)
+}
+
+// erasureManifest returns the shard counts and value length the manifest
+// records.
+func erasureManifest(manifest []byte) (int, int, int, error) {
+	if len(manifest) < _ERASURE_MANIFEST_HEADER_LENGTH || manifest[0] != _ERASURE_MANIFEST_VERSION {
+		return 0, 0, 0, errBadErasureManifest
+	}
+	dataShards := int(manifest[1])
+	parityShards := int(manifest[2])
+	length := binary.BigEndian.Uint64(manifest[3:])
+	if dataShards == 0 {
+		if uint64(len(manifest)-_ERASURE_MANIFEST_HEADER_LENGTH) != length {
+			return 0, 0, 0, errBadErasureManifest
+		}
+	} else if len(manifest) != _ERASURE_MANIFEST_HEADER_LENGTH+4*(dataShards+parityShards) || length == 0 || length > uint64(dataShards)<<32 {
+		return 0, 0, 0, errBadErasureManifest
+	}
+	return dataShards, parityShards, int(length), nil
+}
+
+// erasureShardKey returns the key shard i of keyA, keyB is stored under.
+func erasureShardKey(keyA uint64, keyB uint64, i int) (uint64, uint64) {
+	var b [17]byte
+	binary.BigEndian.PutUint64(b[:], keyA)
+	binary.BigEndian.PutUint64(b[8:], keyB)
+	b[16] = byte(i)
+	return murmur3.Sum128(b[:])
+}
+
+// The shards are Reed-Solomon coded over GF(2^8), with the 0x11d polynomial.
+// The data shards are the value itself, zero padded to a multiple of the
+// data shard count, and parity shard i is the sum of data shard j times
+// 1/(x_i + y_j), with x_i = dataShards+i and y_j = j; under the identity
+// rows of the data shards, any dataShards rows of this Cauchy matrix can be
+// inverted, so any dataShards shards give back the data.
+var gfExp, gfLog = gfTables()
+
+func gfTables() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		exp[i+255] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return exp, log
+}
+
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns 1/a; a must not be 0.
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfMulAdd adds src times c to dst.
+func gfMulAdd(dst []byte, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	lc := int(gfLog[c])
+	for i, b := range src {
+		if b != 0 {
+			dst[i] ^= gfExp[int(gfLog[b])+lc]
+		}
+	}
+}
+
+// erasureRow returns the coefficients giving shard i from the data shards.
+func erasureRow(dataShards int, i int) []byte {
+	row := make([]byte, dataShards)
+	if i < dataShards {
+		row[i] = 1
+		return row
+	}
+	for j := range row {
+		row[j] = gfInv(byte(i) ^ byte(j))
+	}
+	return row
+}
+
+// erasureEncode returns the data shards of value followed by the parity
+// shards computed from them.
+func erasureEncode(value []byte, dataShards int, parityShards int) [][]byte {
+	shardLength := (len(value) + dataShards - 1) / dataShards
+	b := make([]byte, shardLength*(dataShards+parityShards))
+	copy(b, value)
+	shards := make([][]byte, dataShards+parityShards)
+	for i := range shards {
+		shards[i] = b[i*shardLength : (i+1)*shardLength]
+	}
+	for i := dataShards; i < len(shards); i++ {
+		for j, c := range erasureRow(dataShards, i) {
+			gfMulAdd(shards[i], shards[j], c)
+		}
+	}
+	return shards
+}
+
+// erasureReconstruct fills in the missing, nil, data shards from the first
+// dataShards shards present; there must be at least that many.
+func erasureReconstruct(shards [][]byte, dataShards int) {
+	rows := make([][]byte, 0, dataShards)
+	present := make([][]byte, 0, dataShards)
+	for i := 0; i < len(shards) && len(present) < dataShards; i++ {
+		if shards[i] != nil {
+			rows = append(rows, erasureRow(dataShards, i))
+			present = append(present, shards[i])
+		}
+	}
+	// present is rows times the data shards, so the data shards are the
+	// inverse of rows times present.
+	inv := gfInvert(rows)
+	for j := 0; j < dataShards; j++ {
+		if shards[j] != nil {
+			continue
+		}
+		shard := make([]byte, len(present[0]))
+		for i, c := range inv[j] {
+			gfMulAdd(shard, present[i], c)
+		}
+		shards[j] = shard
+	}
+}
+
+// gfInvert returns the inverse of the square matrix m, which must be
+// invertible, by Gauss-Jordan elimination; m is reduced to the identity in
+// the process.
+func gfInvert(m [][]byte) [][]byte {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+	for c := 0; c < n; c++ {
+		p := c
+		for m[p][c] == 0 {
+			p++
+		}
+		m[c], m[p] = m[p], m[c]
+		inv[c], inv[p] = inv[p], inv[c]
+		s := gfInv(m[c][c])
+		for j := 0; j < n; j++ {
+			m[c][j] = gfMul(m[c][j], s)
+			inv[c][j] = gfMul(inv[c][j], s)
+		}
+		for r := 0; r < n; r++ {
+			if r == c || m[r][c] == 0 {
+				continue
+			}
+			f := m[r][c]
+			for j := 0; j < n; j++ {
+				m[r][j] ^= gfMul(f, m[c][j])
+				inv[r][j] ^= gfMul(f, inv[c][j])
+			}
+		}
+	}
+	return inv
+}
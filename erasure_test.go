@@ -0,0 +1,100 @@
+package valuestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestErasureReconstruct(t *testing.T) {
+	value := make([]byte, 1001)
+	rand.New(rand.NewSource(1)).Read(value)
+	shards := erasureEncode(value, 4, 3)
+	if len(shards) != 7 || len(shards[0]) != 251 {
+		t.Fatal(len(shards), len(shards[0]))
+	}
+	// Any three shards may be lost.
+	for a := 0; a < 7; a++ {
+		for b := a + 1; b < 7; b++ {
+			for c := b + 1; c < 7; c++ {
+				damaged := append([][]byte{}, shards...)
+				damaged[a], damaged[b], damaged[c] = nil, nil, nil
+				erasureReconstruct(damaged, 4)
+				if !bytes.Equal(bytes.Join(damaged[:4], nil)[:len(value)], value) {
+					t.Fatal(a, b, c)
+				}
+			}
+		}
+	}
+}
+
+func TestErasure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erasure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true, ValueCap: 4096, ErasureDataShards: 4, ErasureParityShards: 2, ErasureMinLength: 1000})
+	defer vs.Close()
+	vs.EnableWrites()
+	// Split values may be larger than ValueCap.
+	value := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(value)
+	if _, err = vs.WriteErasure(1, 2, 1000, value); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vs.WriteErasure(3, 4, 1000, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, v, err := vs.ReadErasure(1, 2, []byte("prefix")); err != nil || ts != 1000 || !bytes.Equal(v, append([]byte("prefix"), value...)) {
+		t.Fatal(ts, err, len(v))
+	}
+	if _, v, err := vs.ReadErasure(3, 4, nil); err != nil || string(v) != "small" {
+		t.Fatal(err, string(v))
+	}
+	// The small value is kept whole in its manifest.
+	if _, length, err := vs.Lookup(3, 4); err != nil || length != _ERASURE_MANIFEST_HEADER_LENGTH+5 {
+		t.Fatal(length, err)
+	}
+	if _, length, err := vs.Lookup(1, 2); err != nil || length != _ERASURE_MANIFEST_HEADER_LENGTH+4*6 {
+		t.Fatal(length, err)
+	}
+	// Losing a data shard and overwriting another with something else still
+	// leaves enough to rebuild the value.
+	shardKeyA, shardKeyB := erasureShardKey(1, 2, 0)
+	if _, err = vs.Delete(shardKeyA, shardKeyB, 2000); err != nil {
+		t.Fatal(err)
+	}
+	shardKeyA, shardKeyB = erasureShardKey(1, 2, 2)
+	if _, err = vs.Write(shardKeyA, shardKeyB, 2000, make([]byte, 2500)); err != nil {
+		t.Fatal(err)
+	}
+	if _, v, err := vs.ReadErasure(1, 2, nil); err != nil || !bytes.Equal(v, value) {
+		t.Fatal(err, len(v))
+	}
+	if stats := vs.Stats(false).(*Stats); stats.ErasureReconstructions != 1 {
+		t.Fatal(stats.ErasureReconstructions)
+	}
+	shardKeyA, shardKeyB = erasureShardKey(1, 2, 5)
+	if _, err = vs.Delete(shardKeyA, shardKeyB, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := vs.ReadErasure(1, 2, nil); err != ErrErasureShards {
+		t.Fatal(err)
+	}
+	// Deleting removes the shards along with the manifest.
+	if _, err = vs.DeleteErasure(1, 2, 3000); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := vs.ReadErasure(1, 2, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		shardKeyA, shardKeyB = erasureShardKey(1, 2, i)
+		if ts, _, err := vs.Lookup(shardKeyA, shardKeyB); err != ErrNotFound || ts != 3000 {
+			t.Fatal(i, ts, err)
+		}
+	}
+}
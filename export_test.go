@@ -0,0 +1,67 @@
+package valuestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExportSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	vs.EnableWrites()
+	defer vs.Close()
+	for keyA := uint64(1); keyA <= 3000; keyA++ {
+		if _, err := vs.Write(keyA, 2, int64(1000+keyA), []byte(fmt.Sprintf("value%d", keyA))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	if _, err = vs.Delete(5, 2, 5000); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err = vs.ExportSince(3500, buf); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[uint64]bool{}
+	b := buf.Bytes()
+	for len(b) > 0 {
+		if len(b) < EXPORT_RECORD_HEADER_LENGTH {
+			t.Fatal(len(b))
+		}
+		keyA := binary.BigEndian.Uint64(b)
+		keyB := binary.BigEndian.Uint64(b[8:])
+		ts := int64(binary.BigEndian.Uint64(b[16:]))
+		deleted := b[24]
+		length := binary.BigEndian.Uint32(b[25:])
+		value := b[EXPORT_RECORD_HEADER_LENGTH : EXPORT_RECORD_HEADER_LENGTH+length]
+		b = b[EXPORT_RECORD_HEADER_LENGTH+length:]
+		if seen[keyA] || keyB != 2 {
+			t.Fatal(keyA, keyB)
+		}
+		seen[keyA] = true
+		if keyA == 5 {
+			if deleted != 1 || ts != 5000 || length != 0 {
+				t.Fatal(deleted, ts, length)
+			}
+		} else if keyA <= 2500 || deleted != 0 || ts != int64(1000+keyA) || string(value) != fmt.Sprintf("value%d", keyA) {
+			t.Fatal(keyA, deleted, ts, string(value))
+		}
+	}
+	if len(seen) != 501 {
+		t.Fatal(len(seen))
+	}
+	// Nothing has changed since the deletion.
+	buf.Reset()
+	if err = vs.ExportSince(5000, buf); err != nil || buf.Len() != 0 {
+		t.Fatal(buf.Len(), err)
+	}
+}
@@ -0,0 +1,32 @@
+package valuestore
+
+// The failpoints, places in the writers where a process could be killed
+// partway through changing the files on disk. Tests set failpointState.hook
+// to stop at them and capture the files as such a kill would leave them.
+const (
+	// _FAILPOINT_VALUES_PAGE is after a checksum block of a values file has
+	// been written while the rest of its page has not.
+	_FAILPOINT_VALUES_PAGE = "values page"
+	// _FAILPOINT_TOC_WRITE is after each write to a TOC file, leaving it
+	// without its later entries and terminator.
+	_FAILPOINT_TOC_WRITE = "toc write"
+	// _FAILPOINT_COMPACTION_REWRITTEN is after a compaction's rewrites are on
+	// disk but before the manifest records the old files as compacted.
+	_FAILPOINT_COMPACTION_REWRITTEN = "compaction rewritten"
+	// _FAILPOINT_COMPACTION_REMOVE is after the manifest records the old
+	// files as compacted and the old TOC file is removed, but before the old
+	// values file is.
+	_FAILPOINT_COMPACTION_REMOVE = "compaction remove"
+)
+
+// failpointState.hook is called with the name of each failpoint reached; it
+// is only set by tests, before the store is used, and is otherwise nil.
+type failpointState struct {
+	hook func(name string)
+}
+
+func (vs *DefaultValueStore) failpoint(name string) {
+	if hook := vs.failpointState.hook; hook != nil {
+		hook(name)
+	}
+}
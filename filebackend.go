@@ -0,0 +1,80 @@
+package valuestore
+
+import (
+	"io"
+	"os"
+)
+
+// FileBackend is where the values files are kept; see Config.FileBackend.
+// The names given are the full names of the files, within Config.Path. Only
+// the values files go through the FileBackend; the TOC files, and the
+// checkpoints and such kept alongside them in PathTOC, stay on local disk so
+// recovery can find the values files. Features that list or measure Path
+// directly, such as BackupTo, VerifyFiles, ListFiles, and the DiskBytes stat,
+// only see values files kept on local disk.
+type FileBackend interface {
+	// Create makes a new, empty file to write; an existing file with the
+	// name is replaced.
+	Create(name string) (FileWriter, error)
+	// OpenRead opens the file to read from offset; reads may seek anywhere in
+	// the file afterward. A file still being written must give what has been
+	// written so far, including anything written after it was opened.
+	OpenRead(name string, offset int64) (FileReader, error)
+	// Remove removes the file.
+	Remove(name string) error
+}
+
+// FileWriter is returned by FileBackend.Create. With Config.StrictSync, Sync
+// is called before the TOC entries for what has been written are, and when
+// the file is done, just before Close.
+type FileWriter interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// FileReader is returned by FileBackend.OpenRead.
+type FileReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// osFileBackend is the default FileBackend, keeping the values files on local
+// disk.
+type osFileBackend struct{}
+
+func (osFileBackend) Create(name string) (FileWriter, error) {
+	return os.Create(name)
+}
+
+func (osFileBackend) OpenRead(name string, offset int64) (FileReader, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if offset != 0 {
+		if _, err = fp.Seek(offset, 0); err != nil {
+			fp.Close()
+			return nil, err
+		}
+	}
+	return fp, nil
+}
+
+func (osFileBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// openValuesReadSeeker and createValuesWriteCloser give the values files of
+// the FileBackend in the form newValuesFile, createValuesFile and
+// valuesFile.check take them.
+func (vs *DefaultValueStore) openValuesReadSeeker(name string) (io.ReadSeeker, error) {
+	return vs.fileBackend.OpenRead(name, 0)
+}
+
+func (vs *DefaultValueStore) createValuesWriteCloser(name string) (io.WriteCloser, error) {
+	if vs.directIO {
+		return createDirect(name)
+	}
+	return vs.fileBackend.Create(name)
+}
@@ -0,0 +1,168 @@
+package valuestore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memBackend keeps the values files in memory.
+type memBackend struct {
+	lock  sync.Mutex
+	files map[string]*memBackendFile
+	syncs int
+}
+
+type memBackendFile struct {
+	lock sync.Mutex
+	b    []byte
+}
+
+type memBackendWriter struct {
+	backend *memBackend
+	f       *memBackendFile
+}
+
+type memBackendReader struct {
+	f      *memBackendFile
+	offset int64
+}
+
+func (m *memBackend) Create(name string) (FileWriter, error) {
+	f := &memBackendFile{}
+	m.lock.Lock()
+	m.files[name] = f
+	m.lock.Unlock()
+	return &memBackendWriter{backend: m, f: f}, nil
+}
+
+func (m *memBackend) OpenRead(name string, offset int64) (FileReader, error) {
+	m.lock.Lock()
+	f := m.files[name]
+	m.lock.Unlock()
+	if f == nil {
+		return nil, os.ErrNotExist
+	}
+	return &memBackendReader{f: f, offset: offset}, nil
+}
+
+func (m *memBackend) Remove(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.files[name] == nil {
+		return os.ErrNotExist
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (w *memBackendWriter) Write(b []byte) (int, error) {
+	w.f.lock.Lock()
+	w.f.b = append(w.f.b, b...)
+	w.f.lock.Unlock()
+	return len(b), nil
+}
+
+func (w *memBackendWriter) Sync() error {
+	w.backend.lock.Lock()
+	w.backend.syncs++
+	w.backend.lock.Unlock()
+	return nil
+}
+
+func (w *memBackendWriter) Close() error {
+	return nil
+}
+
+func (r *memBackendReader) Read(b []byte) (int, error) {
+	r.f.lock.Lock()
+	defer r.f.lock.Unlock()
+	if r.offset >= int64(len(r.f.b)) {
+		return 0, io.EOF
+	}
+	n := copy(b, r.f.b[r.offset:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *memBackendReader) Seek(offset int64, whence int) (int64, error) {
+	r.f.lock.Lock()
+	defer r.f.lock.Unlock()
+	switch whence {
+	case 1:
+		offset += r.offset
+	case 2:
+		offset += int64(len(r.f.b))
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *memBackendReader) Close() error {
+	return nil
+}
+
+func TestFileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filebackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend := &memBackend{files: map[string]*memBackendFile{}}
+	cfg := &Config{Path: dir, IgnoreEnv: true, FileBackend: backend, StrictSync: true}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, err = vs.Write(keyA, 2, 1000, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, v, err := vs.Read(keyA, 2, nil); err != nil || string(v) != "value" {
+			t.Fatal(keyA, err, string(v))
+		}
+	}
+	vs.Close()
+	// Only the TOC files are on disk.
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range names {
+		if strings.HasSuffix(fi.Name(), ".values") {
+			t.Fatal(fi.Name())
+		}
+	}
+	backend.lock.Lock()
+	files := len(backend.files)
+	syncs := backend.syncs
+	for name := range backend.files {
+		if path.Dir(name) != path.Clean(dir) || !strings.HasSuffix(name, ".values") {
+			t.Fatal(name)
+		}
+	}
+	backend.lock.Unlock()
+	if files == 0 || syncs == 0 {
+		t.Fatal(files, syncs)
+	}
+	// Recovery reads the values files from the backend too.
+	vs = New(cfg)
+	defer vs.Close()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, v, err := vs.Read(keyA, 2, nil); err != nil || string(v) != "value" {
+			t.Fatal(keyA, err, string(v))
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DirtyValuesFiles != 0 {
+		t.Fatal(stats.DirtyValuesFiles)
+	}
+}
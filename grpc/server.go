@@ -0,0 +1,234 @@
+// Package grpc serves a valuestore.ValueStore, such as an existing
+// *valuestore.DefaultValueStore, over gRPC as the ValueStore service of
+// valuestore.proto, so the store can be run as a standalone service.
+//
+// Values are sent in chunks of up to 1 MiB, well under gRPC's default 4 MiB
+// message limit, so any value up to the store's ValueCap may be read or
+// written. Writes whose values do not arrive whole in their first request
+// are stored with WriteStream, so the server never has to hold them in
+// memory at once; reads do load the whole value before sending it on.
+//
+// The messages and service code, in valuestore.pb.go and
+// valuestore_grpc.pb.go, are generated from valuestore.proto; run go generate
+// after changing it.
+package grpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative valuestore.proto
+
+import (
+	"context"
+	"io"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/pandemicsyn/valuestore"
+)
+
+// _VALUE_CHUNK is the most value bytes sent in one message.
+const _VALUE_CHUNK = 1 << 20
+
+// Server answers the ValueStore service's calls from its valuestore.ValueStore.
+type Server struct {
+	UnimplementedValueStoreServer
+	vs valuestore.ValueStore
+}
+
+// NewServer returns a grpc.Server, made with the opts, serving vs; it has
+// yet to be given a listener with its Serve method.
+func NewServer(vs valuestore.ValueStore, opts ...gogrpc.ServerOption) *gogrpc.Server {
+	s := gogrpc.NewServer(opts...)
+	Register(s, vs)
+	return s
+}
+
+// Register adds the ValueStore service, serving vs, to s.
+func Register(s gogrpc.ServiceRegistrar, vs valuestore.ValueStore) {
+	RegisterValueStoreServer(s, &Server{vs: vs})
+}
+
+// contextError returns the status error for ctx having been canceled or
+// timed out, or nil while it has not.
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+// statusError returns err as a gRPC status error, with a code for the
+// valuestore errors that have one.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Unknown
+	switch err {
+	case valuestore.ErrNotFound:
+		code = codes.NotFound
+	case valuestore.ErrNewerVersion:
+		code = codes.FailedPrecondition
+	case valuestore.ErrDisabled, valuestore.ErrClosed:
+		code = codes.Unavailable
+	case valuestore.ErrDiskFull:
+		code = codes.ResourceExhausted
+	}
+	if _, ok := err.(*valuestore.TimestampError); ok {
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, err.Error())
+}
+
+// sendChunks calls send with the value in _VALUE_CHUNK pieces, once even for
+// an empty value, with first set for the first piece; it stops, returning
+// the status error, once ctx is done.
+func sendChunks(ctx context.Context, value []byte, send func(chunk []byte, first bool) error) error {
+	first := true
+	for {
+		if err := contextError(ctx); err != nil {
+			return err
+		}
+		chunk := value
+		if len(chunk) > _VALUE_CHUNK {
+			chunk = chunk[:_VALUE_CHUNK]
+		}
+		if err := send(chunk, first); err != nil {
+			return err
+		}
+		value = value[len(chunk):]
+		if len(value) == 0 {
+			return nil
+		}
+		first = false
+	}
+}
+
+func (s *Server) Read(req *ReadRequest, stream ValueStore_ReadServer) error {
+	ctx := stream.Context()
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+	timestampmicro, value, err := s.vs.Read(req.GetKeyA(), req.GetKeyB(), nil)
+	if err != nil {
+		return statusError(err)
+	}
+	return sendChunks(ctx, value, func(chunk []byte, first bool) error {
+		resp := &ReadResponse{Value: chunk}
+		if first {
+			resp.Timestampmicro = timestampmicro
+			resp.Length = uint32(len(value))
+		}
+		return stream.Send(resp)
+	})
+}
+
+// writeReader reads the value chunks of the Write requests after the first;
+// once the stream's context is done it returns that status error instead.
+type writeReader struct {
+	stream ValueStore_WriteServer
+	chunk  []byte
+}
+
+func (r *writeReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if err := contextError(r.stream.Context()); err != nil {
+			return 0, err
+		}
+		req, err := r.stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return 0, status.Error(codes.InvalidArgument, "value shorter than length")
+			}
+			return 0, err
+		}
+		r.chunk = req.GetValue()
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (s *Server) Write(stream ValueStore_WriteServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		if err == io.EOF {
+			return status.Error(codes.InvalidArgument, "no write request")
+		}
+		return err
+	}
+	if err = contextError(stream.Context()); err != nil {
+		return err
+	}
+	var timestampmicro int64
+	value := req.GetValue()
+	switch {
+	case uint64(len(value)) > uint64(req.GetLength()):
+		return status.Error(codes.InvalidArgument, "value longer than length")
+	case uint64(len(value)) == uint64(req.GetLength()):
+		timestampmicro, err = s.vs.Write(req.GetKeyA(), req.GetKeyB(), req.GetTimestampmicro(), value)
+	default:
+		r := &writeReader{stream: stream, chunk: value}
+		timestampmicro, err = s.vs.WriteStream(req.GetKeyA(), req.GetKeyB(), req.GetTimestampmicro(), req.GetLength(), r)
+	}
+	if err != nil {
+		return statusError(err)
+	}
+	return stream.SendAndClose(&WriteResponse{Timestampmicro: timestampmicro})
+}
+
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	timestampmicro, err := s.vs.Delete(req.GetKeyA(), req.GetKeyB(), req.GetTimestampmicro())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &DeleteResponse{Timestampmicro: timestampmicro}, nil
+}
+
+func (s *Server) Lookup(ctx context.Context, req *LookupRequest) (*LookupResponse, error) {
+	timestampmicro, length, err := s.vs.Lookup(req.GetKeyA(), req.GetKeyB())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &LookupResponse{Timestampmicro: timestampmicro, Length: length}, nil
+}
+
+func (s *Server) ReadMulti(req *ReadMultiRequest, stream ValueStore_ReadMultiServer) error {
+	ctx := stream.Context()
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+	keys := req.GetKeys()
+	entries := make([]valuestore.ReadMultiEntry, len(keys))
+	for i, k := range keys {
+		entries[i].KeyA = k.GetKeyA()
+		entries[i].KeyB = k.GetKeyB()
+	}
+	s.vs.ReadMulti(entries)
+	for i := range entries {
+		e := &entries[i]
+		err := contextError(ctx)
+		if err != nil {
+			return err
+		}
+		switch e.Err {
+		case nil:
+			err = sendChunks(ctx, e.Value, func(chunk []byte, first bool) error {
+				resp := &ReadMultiResponse{Index: uint32(i), Value: chunk}
+				if first {
+					resp.Timestampmicro = e.Timestampmicro
+					resp.Length = uint32(len(e.Value))
+				}
+				return stream.Send(resp)
+			})
+		case valuestore.ErrNotFound:
+			err = stream.Send(&ReadMultiResponse{Index: uint32(i), Timestampmicro: e.Timestampmicro, NotFound: true})
+		default:
+			err = stream.Send(&ReadMultiResponse{Index: uint32(i), Error: e.Err.Error()})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pandemicsyn/valuestore"
+)
+
+// testStream is a grpc.ServerStream that receives the messages in recv and
+// records those sent, each passed through protobuf as they would be on the
+// wire.
+type testStream struct {
+	gogrpc.ServerStream
+	ctx  context.Context
+	recv []proto.Message
+	sent [][]byte
+}
+
+func (s *testStream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *testStream) RecvMsg(m interface{}) error {
+	if len(s.recv) == 0 {
+		return io.EOF
+	}
+	b, err := proto.Marshal(s.recv[0])
+	if err != nil {
+		return err
+	}
+	s.recv = s.recv[1:]
+	return proto.Unmarshal(b, m.(proto.Message))
+}
+
+func (s *testStream) SendMsg(m interface{}) error {
+	b, err := proto.Marshal(m.(proto.Message))
+	s.sent = append(s.sent, b)
+	return err
+}
+
+func newTestServer(t *testing.T) (*Server, *valuestore.DefaultValueStore) {
+	dir, err := ioutil.TempDir("", "grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := valuestore.New(&valuestore.Config{Path: dir, IgnoreEnv: true})
+	vs.EnableWrites()
+	t.Cleanup(func() {
+		vs.Close()
+		os.RemoveAll(dir)
+	})
+	return &Server{vs: vs}, vs
+}
+
+func TestServerWriteRead(t *testing.T) {
+	s, _ := newTestServer(t)
+	stream := &testStream{recv: []proto.Message{&WriteRequest{KeyA: 1, KeyB: 2, Timestampmicro: 1000, Length: 5, Value: []byte("small")}}}
+	if err := _ValueStore_Write_Handler(s, stream); err != nil {
+		t.Fatal(err)
+	}
+	var wresp WriteResponse
+	if len(stream.sent) != 1 || proto.Unmarshal(stream.sent[0], &wresp) != nil || wresp.Timestampmicro != 0 {
+		t.Fatal(stream.sent)
+	}
+	// A value larger than one message is sent in chunks both ways.
+	large := bytes.Repeat([]byte("0123456789abcdef"), (_VALUE_CHUNK*5/2)/16)
+	stream = &testStream{recv: []proto.Message{
+		&WriteRequest{KeyA: 3, KeyB: 4, Timestampmicro: 1000, Length: uint32(len(large)), Value: large[:_VALUE_CHUNK]},
+		&WriteRequest{Value: large[_VALUE_CHUNK : 2*_VALUE_CHUNK]},
+		&WriteRequest{Value: large[2*_VALUE_CHUNK:]},
+	}}
+	if err := _ValueStore_Write_Handler(s, stream); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		keyA   uint64
+		value  []byte
+		chunks int
+	}{{1, []byte("small"), 1}, {3, large, 3}} {
+		stream = &testStream{recv: []proto.Message{&ReadRequest{KeyA: c.keyA, KeyB: c.keyA + 1}}}
+		if err := _ValueStore_Read_Handler(s, stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(stream.sent) != c.chunks {
+			t.Fatal(len(stream.sent))
+		}
+		var value []byte
+		for i, b := range stream.sent {
+			var resp ReadResponse
+			if err := proto.Unmarshal(b, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 && (resp.Timestampmicro != 1000 || resp.Length != uint32(len(c.value))) {
+				t.Fatalf("%d %d", resp.Timestampmicro, resp.Length)
+			}
+			value = append(value, resp.Value...)
+		}
+		if !bytes.Equal(value, c.value) {
+			t.Fatal(len(value))
+		}
+	}
+	resp, err := s.Lookup(context.Background(), &LookupRequest{KeyA: 3, KeyB: 4})
+	if err != nil || resp.Timestampmicro != 1000 || resp.Length != uint32(len(large)) {
+		t.Fatal(resp, err)
+	}
+}
+
+func TestServerWriteInvalid(t *testing.T) {
+	s, _ := newTestServer(t)
+	for _, recv := range [][]proto.Message{
+		nil,
+		{&WriteRequest{KeyA: 1, KeyB: 2, Timestampmicro: 1000, Length: 4, Value: []byte("small")}},
+		{&WriteRequest{KeyA: 1, KeyB: 2, Timestampmicro: 1000, Length: 6, Value: []byte("small")}},
+	} {
+		if err := _ValueStore_Write_Handler(s, &testStream{recv: recv}); status.Code(err) != codes.InvalidArgument {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Lookup(context.Background(), &LookupRequest{KeyA: 1, KeyB: 2}); status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{valuestore.ErrNotFound, codes.NotFound},
+		{valuestore.ErrNewerVersion, codes.FailedPrecondition},
+		{valuestore.ErrDisabled, codes.Unavailable},
+		{valuestore.ErrClosed, codes.Unavailable},
+		{valuestore.ErrDiskFull, codes.ResourceExhausted},
+		{&valuestore.TimestampError{Timestampmicro: 2000, Nowmicro: 1000}, codes.InvalidArgument},
+		{status.Error(codes.Canceled, "canceled"), codes.Canceled},
+		{io.ErrUnexpectedEOF, codes.Unknown},
+	} {
+		if err := statusError(c.err); status.Code(err) != c.code {
+			t.Fatal(c.err, err)
+		}
+	}
+}
+
+func TestServerDelete(t *testing.T) {
+	s, vs := newTestServer(t)
+	if _, err := vs.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Delete(context.Background(), &DeleteRequest{KeyA: 1, KeyB: 2, Timestampmicro: 2000})
+	if err != nil || resp.Timestampmicro != 1000 {
+		t.Fatal(resp, err)
+	}
+	if _, err = s.Lookup(context.Background(), &LookupRequest{KeyA: 1, KeyB: 2}); status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+	if err = _ValueStore_Read_Handler(s, &testStream{recv: []proto.Message{&ReadRequest{KeyA: 1, KeyB: 2}}}); status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestServerReadMulti(t *testing.T) {
+	s, vs := newTestServer(t)
+	if _, err := vs.Write(1, 2, 1000, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Write(5, 6, 1000, []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	stream := &testStream{recv: []proto.Message{&ReadMultiRequest{Keys: []*Key{{KeyA: 1, KeyB: 2}, {KeyA: 3, KeyB: 4}, {KeyA: 5, KeyB: 6}}}}}
+	if err := _ValueStore_ReadMulti_Handler(s, stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.sent) != 3 {
+		t.Fatal(len(stream.sent))
+	}
+	for i, expected := range []*ReadMultiResponse{
+		{Index: 0, Timestampmicro: 1000, Length: 3, Value: []byte("one")},
+		{Index: 1, NotFound: true},
+		{Index: 2, Timestampmicro: 1000, Length: 5, Value: []byte("three")},
+	} {
+		resp := &ReadMultiResponse{}
+		if err := proto.Unmarshal(stream.sent[i], resp); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(resp, expected) {
+			t.Fatal(i, resp)
+		}
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	_, vs := newTestServer(t)
+	info := NewServer(vs).GetServiceInfo()["valuestore.ValueStore"]
+	if len(info.Methods) != 5 || info.Metadata != "valuestore.proto" {
+		t.Fatal(info)
+	}
+}
+
+func TestServerCanceled(t *testing.T) {
+	s, vs := newTestServer(t)
+	if _, err := vs.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream := &testStream{ctx: ctx, recv: []proto.Message{&ReadRequest{KeyA: 1, KeyB: 2}}}
+	if err := _ValueStore_Read_Handler(s, stream); status.Code(err) != codes.Canceled || len(stream.sent) != 0 {
+		t.Fatal(err, len(stream.sent))
+	}
+	stream = &testStream{ctx: ctx, recv: []proto.Message{&ReadMultiRequest{Keys: []*Key{{KeyA: 1, KeyB: 2}}}}}
+	if err := _ValueStore_ReadMulti_Handler(s, stream); status.Code(err) != codes.Canceled || len(stream.sent) != 0 {
+		t.Fatal(err, len(stream.sent))
+	}
+	stream = &testStream{ctx: ctx, recv: []proto.Message{&WriteRequest{KeyA: 3, KeyB: 4, Timestampmicro: 1000, Length: 5, Value: []byte("value")}}}
+	if err := _ValueStore_Write_Handler(s, stream); status.Code(err) != codes.Canceled {
+		t.Fatal(err)
+	}
+	if _, _, err := vs.Lookup(3, 4); err != valuestore.ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestServerClient(t *testing.T) {
+	_, vs := newTestServer(t)
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(vs)
+	go s.Serve(lis)
+	defer s.Stop()
+	conn, err := gogrpc.NewClient("passthrough:///bufconn", gogrpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}), gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := NewValueStoreClient(conn)
+	ctx := context.Background()
+	w, err := c.Write(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Send(&WriteRequest{KeyA: 1, KeyB: 2, Timestampmicro: 1000, Length: 5, Value: []byte("value")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.CloseAndRecv(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Read(ctx, &ReadRequest{KeyA: 1, KeyB: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.Recv()
+	if err != nil || resp.GetTimestampmicro() != 1000 || string(resp.GetValue()) != "value" {
+		t.Fatal(resp, err)
+	}
+	if _, err = c.Lookup(ctx, &LookupRequest{KeyA: 3, KeyB: 4}); status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,772 @@
+// The gRPC service package grpc serves; see server.go. The Go code for it,
+// valuestore.pb.go and valuestore_grpc.pb.go, is generated from this file
+// with protoc-gen-go and protoc-gen-go-grpc by go generate, and clients in
+// any language may be generated from it likewise.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.29.3
+// source: valuestore.proto
+
+package grpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Key struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyA          uint64                 `protobuf:"fixed64,1,opt,name=key_a,json=keyA,proto3" json:"key_a,omitempty"`
+	KeyB          uint64                 `protobuf:"fixed64,2,opt,name=key_b,json=keyB,proto3" json:"key_b,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Key) Reset() {
+	*x = Key{}
+	mi := &file_valuestore_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Key) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Key) ProtoMessage() {}
+
+func (x *Key) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Key.ProtoReflect.Descriptor instead.
+func (*Key) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Key) GetKeyA() uint64 {
+	if x != nil {
+		return x.KeyA
+	}
+	return 0
+}
+
+func (x *Key) GetKeyB() uint64 {
+	if x != nil {
+		return x.KeyB
+	}
+	return 0
+}
+
+type ReadRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyA          uint64                 `protobuf:"fixed64,1,opt,name=key_a,json=keyA,proto3" json:"key_a,omitempty"`
+	KeyB          uint64                 `protobuf:"fixed64,2,opt,name=key_b,json=keyB,proto3" json:"key_b,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadRequest) Reset() {
+	*x = ReadRequest{}
+	mi := &file_valuestore_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadRequest) ProtoMessage() {}
+
+func (x *ReadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadRequest.ProtoReflect.Descriptor instead.
+func (*ReadRequest) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReadRequest) GetKeyA() uint64 {
+	if x != nil {
+		return x.KeyA
+	}
+	return 0
+}
+
+func (x *ReadRequest) GetKeyB() uint64 {
+	if x != nil {
+		return x.KeyB
+	}
+	return 0
+}
+
+type ReadResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Timestampmicro int64                  `protobuf:"varint,1,opt,name=timestampmicro,proto3" json:"timestampmicro,omitempty"`
+	Length         uint32                 `protobuf:"varint,2,opt,name=length,proto3" json:"length,omitempty"`
+	Value          []byte                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ReadResponse) Reset() {
+	*x = ReadResponse{}
+	mi := &file_valuestore_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadResponse) ProtoMessage() {}
+
+func (x *ReadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadResponse.ProtoReflect.Descriptor instead.
+func (*ReadResponse) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReadResponse) GetTimestampmicro() int64 {
+	if x != nil {
+		return x.Timestampmicro
+	}
+	return 0
+}
+
+func (x *ReadResponse) GetLength() uint32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *ReadResponse) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type WriteRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	KeyA           uint64                 `protobuf:"fixed64,1,opt,name=key_a,json=keyA,proto3" json:"key_a,omitempty"`
+	KeyB           uint64                 `protobuf:"fixed64,2,opt,name=key_b,json=keyB,proto3" json:"key_b,omitempty"`
+	Timestampmicro int64                  `protobuf:"varint,3,opt,name=timestampmicro,proto3" json:"timestampmicro,omitempty"`
+	Length         uint32                 `protobuf:"varint,4,opt,name=length,proto3" json:"length,omitempty"`
+	Value          []byte                 `protobuf:"bytes,5,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *WriteRequest) Reset() {
+	*x = WriteRequest{}
+	mi := &file_valuestore_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteRequest) ProtoMessage() {}
+
+func (x *WriteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteRequest.ProtoReflect.Descriptor instead.
+func (*WriteRequest) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WriteRequest) GetKeyA() uint64 {
+	if x != nil {
+		return x.KeyA
+	}
+	return 0
+}
+
+func (x *WriteRequest) GetKeyB() uint64 {
+	if x != nil {
+		return x.KeyB
+	}
+	return 0
+}
+
+func (x *WriteRequest) GetTimestampmicro() int64 {
+	if x != nil {
+		return x.Timestampmicro
+	}
+	return 0
+}
+
+func (x *WriteRequest) GetLength() uint32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *WriteRequest) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type WriteResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The timestampmicro previously stored, as valuestore Write returns.
+	Timestampmicro int64 `protobuf:"varint,1,opt,name=timestampmicro,proto3" json:"timestampmicro,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *WriteResponse) Reset() {
+	*x = WriteResponse{}
+	mi := &file_valuestore_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteResponse) ProtoMessage() {}
+
+func (x *WriteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteResponse.ProtoReflect.Descriptor instead.
+func (*WriteResponse) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *WriteResponse) GetTimestampmicro() int64 {
+	if x != nil {
+		return x.Timestampmicro
+	}
+	return 0
+}
+
+type DeleteRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	KeyA           uint64                 `protobuf:"fixed64,1,opt,name=key_a,json=keyA,proto3" json:"key_a,omitempty"`
+	KeyB           uint64                 `protobuf:"fixed64,2,opt,name=key_b,json=keyB,proto3" json:"key_b,omitempty"`
+	Timestampmicro int64                  `protobuf:"varint,3,opt,name=timestampmicro,proto3" json:"timestampmicro,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_valuestore_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeleteRequest) GetKeyA() uint64 {
+	if x != nil {
+		return x.KeyA
+	}
+	return 0
+}
+
+func (x *DeleteRequest) GetKeyB() uint64 {
+	if x != nil {
+		return x.KeyB
+	}
+	return 0
+}
+
+func (x *DeleteRequest) GetTimestampmicro() int64 {
+	if x != nil {
+		return x.Timestampmicro
+	}
+	return 0
+}
+
+type DeleteResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The timestampmicro previously stored, as valuestore Delete returns.
+	Timestampmicro int64 `protobuf:"varint,1,opt,name=timestampmicro,proto3" json:"timestampmicro,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_valuestore_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteResponse) GetTimestampmicro() int64 {
+	if x != nil {
+		return x.Timestampmicro
+	}
+	return 0
+}
+
+type LookupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyA          uint64                 `protobuf:"fixed64,1,opt,name=key_a,json=keyA,proto3" json:"key_a,omitempty"`
+	KeyB          uint64                 `protobuf:"fixed64,2,opt,name=key_b,json=keyB,proto3" json:"key_b,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupRequest) Reset() {
+	*x = LookupRequest{}
+	mi := &file_valuestore_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupRequest) ProtoMessage() {}
+
+func (x *LookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupRequest.ProtoReflect.Descriptor instead.
+func (*LookupRequest) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *LookupRequest) GetKeyA() uint64 {
+	if x != nil {
+		return x.KeyA
+	}
+	return 0
+}
+
+func (x *LookupRequest) GetKeyB() uint64 {
+	if x != nil {
+		return x.KeyB
+	}
+	return 0
+}
+
+type LookupResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Timestampmicro int64                  `protobuf:"varint,1,opt,name=timestampmicro,proto3" json:"timestampmicro,omitempty"`
+	Length         uint32                 `protobuf:"varint,2,opt,name=length,proto3" json:"length,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *LookupResponse) Reset() {
+	*x = LookupResponse{}
+	mi := &file_valuestore_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupResponse) ProtoMessage() {}
+
+func (x *LookupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupResponse.ProtoReflect.Descriptor instead.
+func (*LookupResponse) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *LookupResponse) GetTimestampmicro() int64 {
+	if x != nil {
+		return x.Timestampmicro
+	}
+	return 0
+}
+
+func (x *LookupResponse) GetLength() uint32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+type ReadMultiRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Keys          []*Key                 `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadMultiRequest) Reset() {
+	*x = ReadMultiRequest{}
+	mi := &file_valuestore_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadMultiRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadMultiRequest) ProtoMessage() {}
+
+func (x *ReadMultiRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadMultiRequest.ProtoReflect.Descriptor instead.
+func (*ReadMultiRequest) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ReadMultiRequest) GetKeys() []*Key {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type ReadMultiResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Index          uint32                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Timestampmicro int64                  `protobuf:"varint,2,opt,name=timestampmicro,proto3" json:"timestampmicro,omitempty"`
+	Length         uint32                 `protobuf:"varint,3,opt,name=length,proto3" json:"length,omitempty"`
+	Value          []byte                 `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	NotFound       bool                   `protobuf:"varint,5,opt,name=not_found,json=notFound,proto3" json:"not_found,omitempty"`
+	// Any other error reading the key; the value is then absent.
+	Error         string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadMultiResponse) Reset() {
+	*x = ReadMultiResponse{}
+	mi := &file_valuestore_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadMultiResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadMultiResponse) ProtoMessage() {}
+
+func (x *ReadMultiResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_valuestore_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadMultiResponse.ProtoReflect.Descriptor instead.
+func (*ReadMultiResponse) Descriptor() ([]byte, []int) {
+	return file_valuestore_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ReadMultiResponse) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *ReadMultiResponse) GetTimestampmicro() int64 {
+	if x != nil {
+		return x.Timestampmicro
+	}
+	return 0
+}
+
+func (x *ReadMultiResponse) GetLength() uint32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *ReadMultiResponse) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *ReadMultiResponse) GetNotFound() bool {
+	if x != nil {
+		return x.NotFound
+	}
+	return false
+}
+
+func (x *ReadMultiResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_valuestore_proto protoreflect.FileDescriptor
+
+const file_valuestore_proto_rawDesc = "" +
+	"\n" +
+	"\x10valuestore.proto\x12\n" +
+	"valuestore\"/\n" +
+	"\x03Key\x12\x13\n" +
+	"\x05key_a\x18\x01 \x01(\x06R\x04keyA\x12\x13\n" +
+	"\x05key_b\x18\x02 \x01(\x06R\x04keyB\"7\n" +
+	"\vReadRequest\x12\x13\n" +
+	"\x05key_a\x18\x01 \x01(\x06R\x04keyA\x12\x13\n" +
+	"\x05key_b\x18\x02 \x01(\x06R\x04keyB\"d\n" +
+	"\fReadResponse\x12&\n" +
+	"\x0etimestampmicro\x18\x01 \x01(\x03R\x0etimestampmicro\x12\x16\n" +
+	"\x06length\x18\x02 \x01(\rR\x06length\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\fR\x05value\"\x8e\x01\n" +
+	"\fWriteRequest\x12\x13\n" +
+	"\x05key_a\x18\x01 \x01(\x06R\x04keyA\x12\x13\n" +
+	"\x05key_b\x18\x02 \x01(\x06R\x04keyB\x12&\n" +
+	"\x0etimestampmicro\x18\x03 \x01(\x03R\x0etimestampmicro\x12\x16\n" +
+	"\x06length\x18\x04 \x01(\rR\x06length\x12\x14\n" +
+	"\x05value\x18\x05 \x01(\fR\x05value\"7\n" +
+	"\rWriteResponse\x12&\n" +
+	"\x0etimestampmicro\x18\x01 \x01(\x03R\x0etimestampmicro\"a\n" +
+	"\rDeleteRequest\x12\x13\n" +
+	"\x05key_a\x18\x01 \x01(\x06R\x04keyA\x12\x13\n" +
+	"\x05key_b\x18\x02 \x01(\x06R\x04keyB\x12&\n" +
+	"\x0etimestampmicro\x18\x03 \x01(\x03R\x0etimestampmicro\"8\n" +
+	"\x0eDeleteResponse\x12&\n" +
+	"\x0etimestampmicro\x18\x01 \x01(\x03R\x0etimestampmicro\"9\n" +
+	"\rLookupRequest\x12\x13\n" +
+	"\x05key_a\x18\x01 \x01(\x06R\x04keyA\x12\x13\n" +
+	"\x05key_b\x18\x02 \x01(\x06R\x04keyB\"P\n" +
+	"\x0eLookupResponse\x12&\n" +
+	"\x0etimestampmicro\x18\x01 \x01(\x03R\x0etimestampmicro\x12\x16\n" +
+	"\x06length\x18\x02 \x01(\rR\x06length\"7\n" +
+	"\x10ReadMultiRequest\x12#\n" +
+	"\x04keys\x18\x01 \x03(\v2\x0f.valuestore.KeyR\x04keys\"\xb2\x01\n" +
+	"\x11ReadMultiResponse\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\rR\x05index\x12&\n" +
+	"\x0etimestampmicro\x18\x02 \x01(\x03R\x0etimestampmicro\x12\x16\n" +
+	"\x06length\x18\x03 \x01(\rR\x06length\x12\x14\n" +
+	"\x05value\x18\x04 \x01(\fR\x05value\x12\x1b\n" +
+	"\tnot_found\x18\x05 \x01(\bR\bnotFound\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error2\xd7\x02\n" +
+	"\n" +
+	"ValueStore\x12;\n" +
+	"\x04Read\x12\x17.valuestore.ReadRequest\x1a\x18.valuestore.ReadResponse0\x01\x12>\n" +
+	"\x05Write\x12\x18.valuestore.WriteRequest\x1a\x19.valuestore.WriteResponse(\x01\x12?\n" +
+	"\x06Delete\x12\x19.valuestore.DeleteRequest\x1a\x1a.valuestore.DeleteResponse\x12?\n" +
+	"\x06Lookup\x12\x19.valuestore.LookupRequest\x1a\x1a.valuestore.LookupResponse\x12J\n" +
+	"\tReadMulti\x12\x1c.valuestore.ReadMultiRequest\x1a\x1d.valuestore.ReadMultiResponse0\x01B(Z&github.com/pandemicsyn/valuestore/grpcb\x06proto3"
+
+var (
+	file_valuestore_proto_rawDescOnce sync.Once
+	file_valuestore_proto_rawDescData []byte
+)
+
+func file_valuestore_proto_rawDescGZIP() []byte {
+	file_valuestore_proto_rawDescOnce.Do(func() {
+		file_valuestore_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_valuestore_proto_rawDesc), len(file_valuestore_proto_rawDesc)))
+	})
+	return file_valuestore_proto_rawDescData
+}
+
+var file_valuestore_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_valuestore_proto_goTypes = []any{
+	(*Key)(nil),               // 0: valuestore.Key
+	(*ReadRequest)(nil),       // 1: valuestore.ReadRequest
+	(*ReadResponse)(nil),      // 2: valuestore.ReadResponse
+	(*WriteRequest)(nil),      // 3: valuestore.WriteRequest
+	(*WriteResponse)(nil),     // 4: valuestore.WriteResponse
+	(*DeleteRequest)(nil),     // 5: valuestore.DeleteRequest
+	(*DeleteResponse)(nil),    // 6: valuestore.DeleteResponse
+	(*LookupRequest)(nil),     // 7: valuestore.LookupRequest
+	(*LookupResponse)(nil),    // 8: valuestore.LookupResponse
+	(*ReadMultiRequest)(nil),  // 9: valuestore.ReadMultiRequest
+	(*ReadMultiResponse)(nil), // 10: valuestore.ReadMultiResponse
+}
+var file_valuestore_proto_depIdxs = []int32{
+	0,  // 0: valuestore.ReadMultiRequest.keys:type_name -> valuestore.Key
+	1,  // 1: valuestore.ValueStore.Read:input_type -> valuestore.ReadRequest
+	3,  // 2: valuestore.ValueStore.Write:input_type -> valuestore.WriteRequest
+	5,  // 3: valuestore.ValueStore.Delete:input_type -> valuestore.DeleteRequest
+	7,  // 4: valuestore.ValueStore.Lookup:input_type -> valuestore.LookupRequest
+	9,  // 5: valuestore.ValueStore.ReadMulti:input_type -> valuestore.ReadMultiRequest
+	2,  // 6: valuestore.ValueStore.Read:output_type -> valuestore.ReadResponse
+	4,  // 7: valuestore.ValueStore.Write:output_type -> valuestore.WriteResponse
+	6,  // 8: valuestore.ValueStore.Delete:output_type -> valuestore.DeleteResponse
+	8,  // 9: valuestore.ValueStore.Lookup:output_type -> valuestore.LookupResponse
+	10, // 10: valuestore.ValueStore.ReadMulti:output_type -> valuestore.ReadMultiResponse
+	6,  // [6:11] is the sub-list for method output_type
+	1,  // [1:6] is the sub-list for method input_type
+	1,  // [1:1] is the sub-list for extension type_name
+	1,  // [1:1] is the sub-list for extension extendee
+	0,  // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_valuestore_proto_init() }
+func file_valuestore_proto_init() {
+	if File_valuestore_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_valuestore_proto_rawDesc), len(file_valuestore_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_valuestore_proto_goTypes,
+		DependencyIndexes: file_valuestore_proto_depIdxs,
+		MessageInfos:      file_valuestore_proto_msgTypes,
+	}.Build()
+	File_valuestore_proto = out.File
+	file_valuestore_proto_goTypes = nil
+	file_valuestore_proto_depIdxs = nil
+}
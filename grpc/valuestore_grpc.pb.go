@@ -0,0 +1,304 @@
+// The gRPC service package grpc serves; see server.go. The Go code for it,
+// valuestore.pb.go and valuestore_grpc.pb.go, is generated from this file
+// with protoc-gen-go and protoc-gen-go-grpc by go generate, and clients in
+// any language may be generated from it likewise.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: valuestore.proto
+
+package grpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ValueStore_Read_FullMethodName      = "/valuestore.ValueStore/Read"
+	ValueStore_Write_FullMethodName     = "/valuestore.ValueStore/Write"
+	ValueStore_Delete_FullMethodName    = "/valuestore.ValueStore/Delete"
+	ValueStore_Lookup_FullMethodName    = "/valuestore.ValueStore/Lookup"
+	ValueStore_ReadMulti_FullMethodName = "/valuestore.ValueStore/ReadMulti"
+)
+
+// ValueStoreClient is the client API for ValueStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ValueStoreClient interface {
+	// Read streams the value back in chunks; the first response carries the
+	// timestampmicro and total length. A missing or deleted key is a
+	// NOT_FOUND error.
+	Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReadResponse], error)
+	// Write takes the value in chunks; the first request carries the key,
+	// timestampmicro, and total length. Values not sent whole in the first
+	// request are written with WriteStream, never held whole in memory.
+	Write(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[WriteRequest, WriteResponse], error)
+	// Delete of a key with a newer version already stored is a
+	// FAILED_PRECONDITION error.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Lookup is Read without the value. A missing or deleted key is a
+	// NOT_FOUND error.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	// ReadMulti streams back each key's value in chunks as Read does, each
+	// response naming the index of the key it is for; the keys' responses
+	// are not interleaved.
+	ReadMulti(ctx context.Context, in *ReadMultiRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReadMultiResponse], error)
+}
+
+type valueStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewValueStoreClient(cc grpc.ClientConnInterface) ValueStoreClient {
+	return &valueStoreClient{cc}
+}
+
+func (c *valueStoreClient) Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReadResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ValueStore_ServiceDesc.Streams[0], ValueStore_Read_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReadRequest, ReadResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ValueStore_ReadClient = grpc.ServerStreamingClient[ReadResponse]
+
+func (c *valueStoreClient) Write(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[WriteRequest, WriteResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ValueStore_ServiceDesc.Streams[1], ValueStore_Write_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WriteRequest, WriteResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ValueStore_WriteClient = grpc.ClientStreamingClient[WriteRequest, WriteResponse]
+
+func (c *valueStoreClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, ValueStore_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *valueStoreClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, ValueStore_Lookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *valueStoreClient) ReadMulti(ctx context.Context, in *ReadMultiRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReadMultiResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ValueStore_ServiceDesc.Streams[2], ValueStore_ReadMulti_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReadMultiRequest, ReadMultiResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ValueStore_ReadMultiClient = grpc.ServerStreamingClient[ReadMultiResponse]
+
+// ValueStoreServer is the server API for ValueStore service.
+// All implementations must embed UnimplementedValueStoreServer
+// for forward compatibility.
+type ValueStoreServer interface {
+	// Read streams the value back in chunks; the first response carries the
+	// timestampmicro and total length. A missing or deleted key is a
+	// NOT_FOUND error.
+	Read(*ReadRequest, grpc.ServerStreamingServer[ReadResponse]) error
+	// Write takes the value in chunks; the first request carries the key,
+	// timestampmicro, and total length. Values not sent whole in the first
+	// request are written with WriteStream, never held whole in memory.
+	Write(grpc.ClientStreamingServer[WriteRequest, WriteResponse]) error
+	// Delete of a key with a newer version already stored is a
+	// FAILED_PRECONDITION error.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Lookup is Read without the value. A missing or deleted key is a
+	// NOT_FOUND error.
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	// ReadMulti streams back each key's value in chunks as Read does, each
+	// response naming the index of the key it is for; the keys' responses
+	// are not interleaved.
+	ReadMulti(*ReadMultiRequest, grpc.ServerStreamingServer[ReadMultiResponse]) error
+	mustEmbedUnimplementedValueStoreServer()
+}
+
+// UnimplementedValueStoreServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedValueStoreServer struct{}
+
+func (UnimplementedValueStoreServer) Read(*ReadRequest, grpc.ServerStreamingServer[ReadResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Read not implemented")
+}
+func (UnimplementedValueStoreServer) Write(grpc.ClientStreamingServer[WriteRequest, WriteResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Write not implemented")
+}
+func (UnimplementedValueStoreServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedValueStoreServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedValueStoreServer) ReadMulti(*ReadMultiRequest, grpc.ServerStreamingServer[ReadMultiResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ReadMulti not implemented")
+}
+func (UnimplementedValueStoreServer) mustEmbedUnimplementedValueStoreServer() {}
+func (UnimplementedValueStoreServer) testEmbeddedByValue()                    {}
+
+// UnsafeValueStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ValueStoreServer will
+// result in compilation errors.
+type UnsafeValueStoreServer interface {
+	mustEmbedUnimplementedValueStoreServer()
+}
+
+func RegisterValueStoreServer(s grpc.ServiceRegistrar, srv ValueStoreServer) {
+	// If the following call pancis, it indicates UnimplementedValueStoreServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ValueStore_ServiceDesc, srv)
+}
+
+func _ValueStore_Read_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ValueStoreServer).Read(m, &grpc.GenericServerStream[ReadRequest, ReadResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ValueStore_ReadServer = grpc.ServerStreamingServer[ReadResponse]
+
+func _ValueStore_Write_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ValueStoreServer).Write(&grpc.GenericServerStream[WriteRequest, WriteResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ValueStore_WriteServer = grpc.ClientStreamingServer[WriteRequest, WriteResponse]
+
+func _ValueStore_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ValueStoreServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ValueStore_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ValueStoreServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ValueStore_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ValueStoreServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ValueStore_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ValueStoreServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ValueStore_ReadMulti_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadMultiRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ValueStoreServer).ReadMulti(m, &grpc.GenericServerStream[ReadMultiRequest, ReadMultiResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ValueStore_ReadMultiServer = grpc.ServerStreamingServer[ReadMultiResponse]
+
+// ValueStore_ServiceDesc is the grpc.ServiceDesc for ValueStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ValueStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "valuestore.ValueStore",
+	HandlerType: (*ValueStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Delete",
+			Handler:    _ValueStore_Delete_Handler,
+		},
+		{
+			MethodName: "Lookup",
+			Handler:    _ValueStore_Lookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Read",
+			Handler:       _ValueStore_Read_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Write",
+			Handler:       _ValueStore_Write_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ReadMulti",
+			Handler:       _ValueStore_ReadMulti_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "valuestore.proto",
+}
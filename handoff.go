@@ -0,0 +1,188 @@
+package valuestore
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/gholt/ring"
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// handoffState hands off the partitions this node stops being responsible
+// for as soon as the ring changes, rather than leaving them to the push
+// replication passes, which get to them only on their interval and skip the
+// most recent writes. Every entry in such a partition is sent to its new
+// replicas, with the OutPushReplication settings, and is flagged
+// _TSB_LOCAL_REMOVAL as the acks come in, just as for push replication. A
+// pass interrupted by yet another ring change starts over, comparing against
+// the ring last handed off from.
+type handoffState struct {
+	interval   time.Duration
+	notifyChan chan struct{}
+	// last is the ring the most recent pass completed for.
+	last   ring.Ring
+	list   []uint64
+	valbuf []byte
+}
+
+func (vs *DefaultValueStore) handoffConfig(cfg *Config) {
+	vs.handoffState.interval = time.Duration(cfg.HandoffInterval) * time.Millisecond
+	vs.handoffState.notifyChan = make(chan struct{}, 1)
+}
+
+func (vs *DefaultValueStore) handoffLaunch() {
+	if vs.msgRing != nil {
+		vs.handoffState.last = vs.msgRing.Ring()
+		vs.closeState.backgroundWG.Add(1)
+		go vs.handoffChecker()
+	}
+}
+
+// RingChanged starts a handoff pass right away if the MsgRing's ring has
+// changed, rather than waiting up to Config.HandoffInterval for it to be
+// noticed.
+func (vs *DefaultValueStore) RingChanged() {
+	select {
+	case vs.handoffState.notifyChan <- struct{}{}:
+	default:
+	}
+}
+
+func (vs *DefaultValueStore) handoffChecker() {
+	defer vs.closeState.backgroundWG.Done()
+	for {
+		select {
+		case <-vs.handoffState.notifyChan:
+		case <-time.After(vs.handoffState.interval):
+		case <-vs.closeState.backgroundChan:
+			return
+		}
+		vs.handoffPass()
+	}
+}
+
+// handoffPass hands off the partitions the current ring no longer has this
+// node responsible for but the ring last handed off from did.
+func (vs *DefaultValueStore) handoffPass() {
+	s := &vs.handoffState
+	r := vs.msgRing.Ring()
+	if r == nil {
+		return
+	}
+	if s.last == nil {
+		s.last = r
+		return
+	}
+	if r.Version() == s.last.Version() {
+		return
+	}
+	if vs.logDebug != nil {
+		begin := time.Now()
+		defer func() {
+			vs.logDebug("handoff pass took %s\n", time.Now().Sub(begin))
+		}()
+	}
+	atomic.AddInt32(&vs.handoffPasses, 1)
+	pbc := r.PartitionBitCount()
+	partitionMax := (uint64(1) << pbc) - 1
+	if cap(s.list) < vs.bulkSetState.msgCap/_BULK_SET_MSG_MIN_ENTRY_LENGTH {
+		s.list = make([]uint64, vs.bulkSetState.msgCap/_BULK_SET_MSG_MIN_ENTRY_LENGTH)
+	}
+	if len(s.valbuf) < int(vs.valueCap) {
+		s.valbuf = make([]byte, vs.valueCap)
+	}
+	pacer := vs.newBackgroundPacer()
+	for partition := uint64(0); partition <= partitionMax; partition++ {
+		if r.Responsible(uint32(partition)) || !handoffWasResponsible(s.last, pbc, partition) {
+			continue
+		}
+		if !vs.handoffPartition(r, partition) {
+			return
+		}
+		pacer.pause()
+	}
+	s.last = r
+}
+
+// handoffWasResponsible returns true if the old ring had this node
+// responsible for any of the partition, which is from a ring with pbc
+// partition bits.
+func handoffWasResponsible(old ring.Ring, pbc uint16, partition uint64) bool {
+	oldPBC := old.PartitionBitCount()
+	if oldPBC <= pbc {
+		return old.Responsible(uint32(partition >> (pbc - oldPBC)))
+	}
+	for p := partition << (oldPBC - pbc); p < (partition+1)<<(oldPBC-pbc); p++ {
+		if old.Responsible(uint32(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// handoffPartition sends everything in the partition to its replicas,
+// returning false if it had to stop, as for another ring change or the store
+// closing.
+func (vs *DefaultValueStore) handoffPartition(r ring.Ring, partition uint64) bool {
+	s := &vs.handoffState
+	partitionShift := uint64(64 - r.PartitionBitCount())
+	rangeBegin := partition << partitionShift
+	rangeEnd := uint64(math.MaxUint64)
+	if partition != (uint64(1)<<r.PartitionBitCount())-1 {
+		rangeEnd = ((partition + 1) << partitionShift) - 1
+	}
+	tombstoneCutoff := vs.tombstonePushCutoff(uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS)
+	for start, more := rangeBegin, true; more; {
+		if atomic.LoadUint32(&vs.closeState.closed) != 0 {
+			return false
+		}
+		availableBytes := int64(vs.bulkSetState.msgCap)
+		list := s.list[:0]
+		start, more = vs.vlm.ScanCallback(start, rangeEnd, 0, _TSB_LOCAL_REMOVAL, math.MaxUint64, math.MaxUint64, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+			inMsgLength := _BULK_SET_MSG_ENTRY_HEADER_LENGTH + int64(length)
+			if timestampbits&_TSB_DELETION == 0 || timestampbits >= tombstoneCutoff {
+				list = append(list, keyA, keyB)
+				availableBytes -= inMsgLength
+				if availableBytes < inMsgLength {
+					return false
+				}
+			}
+			return true
+		})
+		if len(list) == 0 {
+			continue
+		}
+		list = dedupeKeyList(list)
+		if r2 := vs.msgRing.Ring(); r2 == nil || r2.Version() != r.Version() {
+			return false
+		}
+		bsm := vs.newOutReplicationBulkSetMsg()
+		bsm.setAckPolicy(vs.pushReplicationState.outAckPolicy)
+		bsm.setBackfill()
+		valbuf := s.valbuf
+		var timestampbits uint64
+		var err error
+		for i := 0; i < len(list); i += 2 {
+			timestampbits, valbuf, err = vs.read(list[i], list[i+1], valbuf[:0])
+			if err == ErrNotFound {
+				if timestampbits == 0 {
+					continue
+				}
+			} else if err != nil {
+				continue
+			}
+			if timestampbits&_TSB_LOCAL_REMOVAL == 0 && (timestampbits&_TSB_DELETION == 0 || timestampbits >= tombstoneCutoff) {
+				if !vs.outReplicationAdd(bsm, list[i], list[i+1], timestampbits, valbuf) {
+					break
+				}
+				atomic.AddInt32(&vs.outBulkSetHandoffValues, 1)
+				vs.pushBacklogAdd(list[i], list[i+1])
+			}
+		}
+		if vs.msgToOtherReplicas(bsm, uint32(partition), vs.pushReplicationState.outMsgTimeout) {
+			atomic.AddInt32(&vs.outBulkSetHandoffs, 1)
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package valuestore
+
+import (
+	"testing"
+
+	"github.com/gholt/ring"
+)
+
+func TestHandoffPass(t *testing.T) {
+	b := ring.NewBuilder(64)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	r1 := b.Ring()
+	r1.SetLocalNode(n.ID())
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	r2 := b.Ring()
+	r2.SetLocalNode(n.ID())
+	m := &msgRingPlaceholder{ring: r1}
+	vs := New(&Config{
+		Path:                t.TempDir(),
+		MsgRing:             m,
+		InBulkSetAckWorkers: 1,
+		InBulkSetAckMsgs:    1,
+		// No acks come back here to free the window.
+		OutPeerMsgWindow: 1000,
+		// Long enough that only the calls below run passes.
+		HandoffInterval: 1000000000,
+	})
+	vs.EnableWrites()
+	defer vs.Close()
+	pbc := r1.PartitionBitCount()
+	var expected []uint32
+	for p := uint64(0); p < 1<<pbc; p++ {
+		if _, err = vs.Write(p<<(64-pbc)|1, 2, 1000, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if r1.Responsible(uint32(p)) && !r2.Responsible(uint32(p)) {
+			expected = append(expected, uint32(p))
+		}
+	}
+	if len(expected) == 0 {
+		t.Fatal("no partitions to hand off")
+	}
+	// Nothing to do until the ring changes.
+	vs.handoffPass()
+	if len(m.msgToPartitions) != 0 {
+		t.Fatal(m.msgToPartitions)
+	}
+	m.ring = r2
+	vs.handoffPass()
+	vs.handoffPass()
+	if len(m.msgToPartitions) != len(expected) {
+		t.Fatal(m.msgToPartitions, expected)
+	}
+	for i, p := range expected {
+		if m.msgToPartitions[i] != p {
+			t.Fatal(m.msgToPartitions, expected)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.HandoffPasses != 1 || stats.OutBulkSetHandoffs != int32(len(expected)) || stats.OutBulkSetHandoffValues != int32(len(expected)) {
+		t.Fatal(stats.HandoffPasses, stats.OutBulkSetHandoffs, stats.OutBulkSetHandoffValues)
+	}
+	// Once acked, the entry is flagged for local removal.
+	keyA := uint64(expected[0])<<(64-pbc) | 1
+	bsam := <-vs.bulkSetAckState.inFreeMsgChan
+	bsam.body = bsam.body[:0]
+	if !bsam.add(keyA, 2, uint64(1000)<<_TSB_UTIL_BITS) {
+		t.Fatal("")
+	}
+	vs.bulkSetAckState.inMsgChan <- bsam
+	<-vs.bulkSetAckState.inFreeMsgChan
+	if _, _, err = vs.Read(keyA, 2, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
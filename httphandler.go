@@ -0,0 +1,231 @@
+package valuestore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// The headers NewHTTPHandler uses for timestampmicros: HTTP_TIMESTAMP gives
+// the one stored with a value read, or the one to store with a PUT or
+// DELETE; HTTP_PREVIOUS_TIMESTAMP gives what Write or Delete returned for a
+// PUT or DELETE.
+const (
+	HTTP_TIMESTAMP          = "X-Timestamp-Micro"
+	HTTP_PREVIOUS_TIMESTAMP = "X-Previous-Timestamp-Micro"
+)
+
+// _HTTP_STREAM_LENGTH is the Content-Length from which a PUT is stored with
+// WriteStream rather than read into memory and given to Write.
+const _HTTP_STREAM_LENGTH = 1 << 20
+
+type httpHandler struct {
+	vs ValueStore
+}
+
+// NewHTTPHandler returns an http.Handler serving vs for debugging and simple
+// integrations; http.StripPrefix can mount it under a path of an existing
+// router. It serves:
+//
+//	GET /values/{keyA}/{keyB}     the value, with HTTP_TIMESTAMP; Range,
+//	                              If-Range, and the other conditional headers
+//	                              work as with http.ServeContent, the
+//	                              timestampmicro being the ETag and
+//	                              Last-Modified
+//	HEAD /values/{keyA}/{keyB}    the same without the value, from Lookup
+//	                              rather than Read, so the Content-Length is
+//	                              the stored length; see Config.Compression
+//	PUT /values/{keyA}/{keyB}     Write of the body, or WriteStream if its
+//	                              Content-Length is 1 MiB or more
+//	DELETE /values/{keyA}/{keyB}  Delete
+//	GET /stats                    Stats(false) as text, or Stats(true) with
+//	                              ?debug=true
+//
+// Keys are decimal, or hexadecimal with a 0x prefix. A PUT or DELETE is made
+// with the HTTP_TIMESTAMP header's timestampmicro, or the current time if it
+// isn't given, and answered with 204 No Content and HTTP_PREVIOUS_TIMESTAMP;
+// as with Write, a PUT older than the value in place still gets 204, which
+// HTTP_PREVIOUS_TIMESTAMP tells apart. Errors are plain text, with the status
+// 404 for ErrNotFound (with HTTP_TIMESTAMP for a deletion marker), 409 for
+// ErrNewerVersion, 413 for values over ValueCap, 503 for ErrDisabled and
+// ErrClosed, and 507 for ErrDiskFull.
+func NewHTTPHandler(vs ValueStore) http.Handler {
+	return &httpHandler{vs: vs}
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/stats" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			httpMethodNotAllowed(w, "GET, HEAD")
+			return
+		}
+		debug, _ := strconv.ParseBool(r.URL.Query().Get("debug"))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, h.vs.Stats(debug).String())
+		return
+	}
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "values" {
+		http.NotFound(w, r)
+		return
+	}
+	keyA, errA := httpParseKey(parts[2])
+	keyB, errB := httpParseKey(parts[3])
+	if errA != nil || errB != nil {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r, keyA, keyB)
+	case http.MethodHead:
+		h.head(w, r, keyA, keyB)
+	case http.MethodPut:
+		h.put(w, r, keyA, keyB)
+	case http.MethodDelete:
+		h.delete(w, r, keyA, keyB)
+	default:
+		httpMethodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+	}
+}
+
+func (h *httpHandler) get(w http.ResponseWriter, r *http.Request, keyA uint64, keyB uint64) {
+	timestampmicro, value, err := h.vs.Read(keyA, keyB, nil)
+	if timestampmicro != 0 {
+		w.Header().Set(HTTP_TIMESTAMP, strconv.FormatInt(timestampmicro, 10))
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	httpServeContent(w, r, timestampmicro, bytes.NewReader(value))
+}
+
+func (h *httpHandler) head(w http.ResponseWriter, r *http.Request, keyA uint64, keyB uint64) {
+	timestampmicro, length, err := h.vs.Lookup(keyA, keyB)
+	if timestampmicro != 0 {
+		w.Header().Set(HTTP_TIMESTAMP, strconv.FormatInt(timestampmicro, 10))
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	httpServeContent(w, r, timestampmicro, io.NewSectionReader(httpNoContent{}, 0, int64(length)))
+}
+
+// httpServeContent answers a GET or HEAD of the value stored with
+// timestampmicro with http.ServeContent.
+func httpServeContent(w http.ResponseWriter, r *http.Request, timestampmicro int64, content io.ReadSeeker) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+strconv.FormatInt(timestampmicro, 10)+`"`)
+	http.ServeContent(w, r, "", time.Unix(0, timestampmicro*int64(time.Microsecond)), content)
+}
+
+// httpNoContent stands in for the value of a HEAD, which http.ServeContent
+// only seeks through, sizing it, and never sends.
+type httpNoContent struct{}
+
+func (httpNoContent) ReadAt(p []byte, off int64) (int, error) {
+	return 0, io.EOF
+}
+
+func (h *httpHandler) put(w http.ResponseWriter, r *http.Request, keyA uint64, keyB uint64) {
+	timestampmicro, ok := httpTimestamp(w, r)
+	if !ok {
+		return
+	}
+	valueCap := int64(h.vs.ValueCap())
+	if r.ContentLength > valueCap {
+		http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var ptimestampmicro int64
+	var err error
+	if r.ContentLength >= _HTTP_STREAM_LENGTH {
+		ptimestampmicro, err = h.vs.WriteStream(keyA, keyB, timestampmicro, uint32(r.ContentLength), r.Body)
+	} else {
+		var value []byte
+		value, err = ioutil.ReadAll(io.LimitReader(r.Body, valueCap+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if int64(len(value)) > valueCap {
+			http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		ptimestampmicro, err = h.vs.Write(keyA, keyB, timestampmicro, value)
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set(HTTP_PREVIOUS_TIMESTAMP, strconv.FormatInt(ptimestampmicro, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpHandler) delete(w http.ResponseWriter, r *http.Request, keyA uint64, keyB uint64) {
+	timestampmicro, ok := httpTimestamp(w, r)
+	if !ok {
+		return
+	}
+	ptimestampmicro, err := h.vs.Delete(keyA, keyB, timestampmicro)
+	w.Header().Set(HTTP_PREVIOUS_TIMESTAMP, strconv.FormatInt(ptimestampmicro, 10))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func httpParseKey(s string) (uint64, error) {
+	if strings.HasPrefix(s, "0x") {
+		return strconv.ParseUint(s[2:], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// httpTimestamp returns the request's HTTP_TIMESTAMP, or the current time
+// without one; if the header is invalid it answers the request and returns
+// false.
+func httpTimestamp(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	s := r.Header.Get(HTTP_TIMESTAMP)
+	if s == "" {
+		return brimtime.TimeToUnixMicro(time.Now()), true
+	}
+	timestampmicro, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || timestampmicro < TIMESTAMPMICRO_MIN || timestampmicro > TIMESTAMPMICRO_MAX {
+		http.Error(w, "invalid "+HTTP_TIMESTAMP, http.StatusBadRequest)
+		return 0, false
+	}
+	return timestampmicro, true
+}
+
+func httpMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err {
+	case ErrNotFound:
+		code = http.StatusNotFound
+	case ErrNewerVersion:
+		code = http.StatusConflict
+	case ErrDisabled, ErrClosed:
+		code = http.StatusServiceUnavailable
+	case ErrDiskFull:
+		code = http.StatusInsufficientStorage
+	}
+	if _, ok := err.(*TimestampError); ok {
+		code = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), code)
+}
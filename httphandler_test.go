@@ -0,0 +1,135 @@
+package valuestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httphandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	defer vs.Close()
+	vs.EnableWrites()
+	ts := httptest.NewServer(http.StripPrefix("/vs", NewHTTPHandler(vs)))
+	defer ts.Close()
+	do := func(method string, path string, header map[string]string, body []byte) (*http.Response, string) {
+		req, err := http.NewRequest(method, ts.URL+"/vs"+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(b)
+	}
+	resp, _ := do("PUT", "/values/1/0x2", map[string]string{HTTP_TIMESTAMP: "1000"}, []byte("0123456789"))
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(HTTP_PREVIOUS_TIMESTAMP) != "0" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+	if _, value, err := vs.Read(1, 2, nil); err != nil || string(value) != "0123456789" {
+		t.Fatal(string(value), err)
+	}
+	resp, body := do("GET", "/values/0x1/2", nil, nil)
+	if resp.StatusCode != http.StatusOK || body != "0123456789" || resp.Header.Get(HTTP_TIMESTAMP) != "1000" {
+		t.Fatal(resp.Status, body, resp.Header)
+	}
+	resp, body = do("GET", "/values/1/2", map[string]string{"Range": "bytes=2-4"}, nil)
+	if resp.StatusCode != http.StatusPartialContent || body != "234" || resp.Header.Get("Content-Range") != "bytes 2-4/10" {
+		t.Fatal(resp.Status, body, resp.Header)
+	}
+	resp, _ = do("GET", "/values/1/2", map[string]string{"If-None-Match": `"1000"`}, nil)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatal(resp.Status)
+	}
+	// HEAD is answered from Lookup, without reading the value.
+	vs.Stats(false)
+	for _, header := range []map[string]string{nil, {"Range": "bytes=2-4,6-7"}} {
+		resp, body = do("HEAD", "/values/1/2", header, nil)
+		if resp.StatusCode/100 != 2 || body != "" || resp.Header.Get(HTTP_TIMESTAMP) != "1000" || resp.Header.Get("ETag") != `"1000"` {
+			t.Fatal(resp.Status, body, resp.Header)
+		}
+	}
+	if resp, _ = do("HEAD", "/values/1/2", nil, nil); resp.ContentLength != 10 {
+		t.Fatal(resp.ContentLength)
+	}
+	if resp, _ = do("HEAD", "/values/1/2", map[string]string{"If-None-Match": `"1000"`}, nil); resp.StatusCode != http.StatusNotModified {
+		t.Fatal(resp.Status)
+	}
+	if resp, _ = do("HEAD", "/values/7/8", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatal(resp.Status)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.Reads != 0 || stats.Lookups != 5 {
+		t.Fatal(stats.Reads, stats.Lookups)
+	}
+	// A large value goes through WriteStream.
+	large := bytes.Repeat([]byte("0123456789abcdef"), _HTTP_STREAM_LENGTH/16)
+	resp, _ = do("PUT", "/values/3/4", map[string]string{HTTP_TIMESTAMP: "1000"}, large)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatal(resp.Status)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.WriteStreams != 1 {
+		t.Fatal(stats.WriteStreams)
+	}
+	if resp, body = do("GET", "/values/3/4", nil, nil); body != string(large) {
+		t.Fatal(resp.Status, len(body))
+	}
+	resp, _ = do("PUT", "/values/5/6", nil, make([]byte, vs.ValueCap()+1))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatal(resp.Status)
+	}
+	resp, _ = do("DELETE", "/values/1/2", map[string]string{HTTP_TIMESTAMP: "500"}, nil)
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get(HTTP_PREVIOUS_TIMESTAMP) != "1000" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+	resp, _ = do("DELETE", "/values/1/2", map[string]string{HTTP_TIMESTAMP: "2000"}, nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(HTTP_PREVIOUS_TIMESTAMP) != "1000" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+	resp, _ = do("GET", "/values/1/2", nil, nil)
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get(HTTP_TIMESTAMP) != "2000" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+	resp, _ = do("GET", "/values/7/8", nil, nil)
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get(HTTP_TIMESTAMP) != "" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+	for _, c := range []struct {
+		method string
+		path   string
+		header map[string]string
+		code   int
+	}{
+		{"GET", "/values/1/x", nil, http.StatusBadRequest},
+		{"GET", "/values/1", nil, http.StatusNotFound},
+		{"GET", "/values/1/2/3", nil, http.StatusNotFound},
+		{"PUT", "/values/1/2", map[string]string{HTTP_TIMESTAMP: "100"}, http.StatusBadRequest},
+		{"POST", "/values/1/2", nil, http.StatusMethodNotAllowed},
+		{"POST", "/stats", nil, http.StatusMethodNotAllowed},
+	} {
+		if resp, _ = do(c.method, c.path, c.header, nil); resp.StatusCode != c.code {
+			t.Fatal(c.method, c.path, resp.Status)
+		}
+	}
+	resp, body = do("GET", "/stats", nil, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "WriteStreams") {
+		t.Fatal(resp.Status, body)
+	}
+}
@@ -54,9 +54,14 @@ func (ktbf *ktBloomFilter) toMsg(prm *pullReplicationMsg, headerOffset int) {
 	binary.BigEndian.PutUint64(prm.header[headerOffset:], ktbf.n)
 	binary.BigEndian.PutUint64(prm.header[headerOffset+8:], math.Float64bits(ktbf.p))
 	binary.BigEndian.PutUint16(prm.header[headerOffset+16:], uint16(ktbf.salt>>16))
+	prm.header[headerOffset+_KT_FILTER_TYPE_OFFSET] = _KT_FILTER_BLOOM
 	copy(prm.body, ktbf.bits)
 }
 
+func (ktbf *ktBloomFilter) msgBodyLength() int {
+	return len(ktbf.bits)
+}
+
 func (ktbf *ktBloomFilter) String() string {
 	return fmt.Sprintf("ktBloomFilter %p n=%d p=%f salt=%d m=%d k=%d bytes=%d", ktbf, ktbf.n, ktbf.p, ktbf.salt>>16, ktbf.m, ktbf.kDiv4*4, len(ktbf.bits))
 }
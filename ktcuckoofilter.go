@@ -0,0 +1,261 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	_KT_CUCKOO_FILTER_BUCKET_ENTRIES = 4
+	_KT_CUCKOO_FILTER_LOAD           = 0.95
+	_KT_CUCKOO_FILTER_MAX_KICKS      = 500
+	// The victim, an entry that could not be placed, follows the buckets as
+	// its bucket index and fingerprint, a zero fingerprint meaning none.
+	_KT_CUCKOO_FILTER_VICTIM_BYTES = 8
+)
+
+// ktCuckooFilter is a key+timestamp cuckoo filter implementation, sized by the
+// same n and p as ktBloomFilter. Each of its buckets holds four fingerprints
+// of just enough bits to keep the false positive rate under p, and each
+// entry may be in one of two buckets, the second derived from the first and
+// the fingerprint alone so entries can be moved between them, and removed,
+// without knowing the keys they were for. An entry that cannot be placed even
+// after moving others about is kept as the victim; should another not fit
+// while there is one it is dropped, which for pull replication only means a
+// value may be sent needlessly.
+type ktCuckooFilter struct {
+	n       uint64
+	p       float64
+	salt    uint32
+	fpBits  uint
+	fpMask  uint32
+	buckets uint32
+	// bits holds the buckets followed by the victim.
+	bits    []byte
+	kick    uint32
+	scratch []byte
+}
+
+// ktCuckooFilterLayout returns the fingerprint bits, bucket count, and
+// length in bytes for n and p, with ok false if they are out of range.
+func ktCuckooFilterLayout(n uint64, p float64) (fpBits uint, buckets uint32, length int, ok bool) {
+	if n == 0 || math.IsNaN(p) || p <= 0 || p >= 1 {
+		return 0, 0, 0, false
+	}
+	fpBits = uint(math.Ceil(math.Log2(2 * _KT_CUCKOO_FILTER_BUCKET_ENTRIES / p)))
+	if fpBits > 32 {
+		fpBits = 32
+	}
+	b := math.Ceil(float64(n) / (_KT_CUCKOO_FILTER_BUCKET_ENTRIES * _KT_CUCKOO_FILTER_LOAD))
+	if b*_KT_CUCKOO_FILTER_BUCKET_ENTRIES*float64(fpBits)/8 > math.MaxInt32 {
+		return 0, 0, 0, false
+	}
+	buckets = uint32(b)
+	length = int((uint64(buckets)*_KT_CUCKOO_FILTER_BUCKET_ENTRIES*uint64(fpBits)+7)/8) + _KT_CUCKOO_FILTER_VICTIM_BYTES
+	return fpBits, buckets, length, true
+}
+
+func newKTCuckooFilter(n uint64, p float64, salt uint16) *ktCuckooFilter {
+	fpBits, buckets, length, ok := ktCuckooFilterLayout(n, p)
+	if !ok {
+		// Config resolution keeps n and p in range, so this only guards
+		// against a filter too large to address.
+		n, p = 1000000, 0.001
+		fpBits, buckets, length, _ = ktCuckooFilterLayout(n, p)
+	}
+	return &ktCuckooFilter{
+		n:       n,
+		p:       p,
+		salt:    uint32(salt) << 16,
+		fpBits:  fpBits,
+		fpMask:  uint32(math.MaxUint32 >> (32 - fpBits)),
+		buckets: buckets,
+		bits:    make([]byte, length),
+		scratch: make([]byte, 28),
+	}
+}
+
+// newKTCuckooFilterFromMsg returns the filter the message carries, or nil if
+// the message body is the wrong size for it.
+func newKTCuckooFilterFromMsg(prm *pullReplicationMsg, headerOffset int) *ktCuckooFilter {
+	n := binary.BigEndian.Uint64(prm.header[headerOffset:])
+	p := math.Float64frombits(binary.BigEndian.Uint64(prm.header[headerOffset+8:]))
+	salt := binary.BigEndian.Uint16(prm.header[headerOffset+16:])
+	fpBits, buckets, length, ok := ktCuckooFilterLayout(n, p)
+	if !ok || len(prm.body) != length {
+		return nil
+	}
+	return &ktCuckooFilter{
+		n:       n,
+		p:       p,
+		salt:    uint32(salt) << 16,
+		fpBits:  fpBits,
+		fpMask:  uint32(math.MaxUint32 >> (32 - fpBits)),
+		buckets: buckets,
+		bits:    prm.body,
+		scratch: make([]byte, 28),
+	}
+}
+
+func (ktcf *ktCuckooFilter) toMsg(prm *pullReplicationMsg, headerOffset int) {
+	binary.BigEndian.PutUint64(prm.header[headerOffset:], ktcf.n)
+	binary.BigEndian.PutUint64(prm.header[headerOffset+8:], math.Float64bits(ktcf.p))
+	binary.BigEndian.PutUint16(prm.header[headerOffset+16:], uint16(ktcf.salt>>16))
+	prm.header[headerOffset+_KT_FILTER_TYPE_OFFSET] = _KT_FILTER_CUCKOO
+	copy(prm.body, ktcf.bits)
+}
+
+func (ktcf *ktCuckooFilter) msgBodyLength() int {
+	return len(ktcf.bits)
+}
+
+func (ktcf *ktCuckooFilter) String() string {
+	return fmt.Sprintf("ktCuckooFilter %p n=%d p=%f salt=%d buckets=%d fingerprint=%d bytes=%d", ktcf, ktcf.n, ktcf.p, ktcf.salt>>16, ktcf.buckets, ktcf.fpBits, len(ktcf.bits))
+}
+
+// index returns the entry's first bucket and its fingerprint, which is never
+// zero as that marks an empty slot.
+func (ktcf *ktCuckooFilter) index(keyA uint64, keyB uint64, timestamp uint64) (uint32, uint32) {
+	scratch := ktcf.scratch
+	binary.BigEndian.PutUint32(scratch, ktcf.salt)
+	binary.BigEndian.PutUint64(scratch[4:], keyA)
+	binary.BigEndian.PutUint64(scratch[12:], keyB)
+	binary.BigEndian.PutUint64(scratch[20:], timestamp)
+	h1, h2 := murmur3.Sum128(scratch)
+	fp := uint32(h2) & ktcf.fpMask
+	if fp == 0 {
+		fp = 1
+	}
+	return uint32(h1 % uint64(ktcf.buckets)), fp
+}
+
+// alt returns the other bucket an entry in bucket i with fingerprint fp could
+// be in; alt(alt(i, fp), fp) == i.
+func (ktcf *ktCuckooFilter) alt(i uint32, fp uint32) uint32 {
+	b := uint64(ktcf.buckets)
+	h := (uint64(fp) * 0x5bd1e995) % b
+	return uint32((h + b - uint64(i)) % b)
+}
+
+// slotBytes returns the bytes slot s of bucket i is packed into and the bit
+// offset of the slot within them.
+func (ktcf *ktCuckooFilter) slotBytes(i uint32, s int) ([]byte, uint) {
+	o := (uint64(i)*_KT_CUCKOO_FILTER_BUCKET_ENTRIES + uint64(s)) * uint64(ktcf.fpBits)
+	return ktcf.bits[o/8 : (o+uint64(ktcf.fpBits)+7)/8], uint(o % 8)
+}
+
+func (ktcf *ktCuckooFilter) slot(i uint32, s int) uint32 {
+	b, shift := ktcf.slotBytes(i, s)
+	var w uint64
+	for j, c := range b {
+		w |= uint64(c) << (8 * uint(j))
+	}
+	return uint32(w>>shift) & ktcf.fpMask
+}
+
+func (ktcf *ktCuckooFilter) setSlot(i uint32, s int, fp uint32) {
+	b, shift := ktcf.slotBytes(i, s)
+	var w uint64
+	for j, c := range b {
+		w |= uint64(c) << (8 * uint(j))
+	}
+	w = w&^(uint64(ktcf.fpMask)<<shift) | uint64(fp)<<shift
+	for j := range b {
+		b[j] = byte(w >> (8 * uint(j)))
+	}
+}
+
+func (ktcf *ktCuckooFilter) victim() (uint32, uint32) {
+	v := ktcf.bits[len(ktcf.bits)-_KT_CUCKOO_FILTER_VICTIM_BYTES:]
+	return binary.BigEndian.Uint32(v), binary.BigEndian.Uint32(v[4:])
+}
+
+func (ktcf *ktCuckooFilter) setVictim(i uint32, fp uint32) {
+	v := ktcf.bits[len(ktcf.bits)-_KT_CUCKOO_FILTER_VICTIM_BYTES:]
+	binary.BigEndian.PutUint32(v, i)
+	binary.BigEndian.PutUint32(v[4:], fp)
+}
+
+func (ktcf *ktCuckooFilter) insert(i uint32, fp uint32) bool {
+	for s := 0; s < _KT_CUCKOO_FILTER_BUCKET_ENTRIES; s++ {
+		if ktcf.slot(i, s) == 0 {
+			ktcf.setSlot(i, s, fp)
+			return true
+		}
+	}
+	return false
+}
+
+func (ktcf *ktCuckooFilter) add(keyA uint64, keyB uint64, timestamp uint64) {
+	i, fp := ktcf.index(keyA, keyB, timestamp)
+	if ktcf.insert(i, fp) {
+		return
+	}
+	i = ktcf.alt(i, fp)
+	if ktcf.insert(i, fp) {
+		return
+	}
+	for k := 0; k < _KT_CUCKOO_FILTER_MAX_KICKS; k++ {
+		s := int(ktcf.kick % _KT_CUCKOO_FILTER_BUCKET_ENTRIES)
+		ktcf.kick++
+		evicted := ktcf.slot(i, s)
+		ktcf.setSlot(i, s, fp)
+		fp = evicted
+		i = ktcf.alt(i, fp)
+		if ktcf.insert(i, fp) {
+			return
+		}
+	}
+	if _, vfp := ktcf.victim(); vfp == 0 {
+		ktcf.setVictim(i, fp)
+	}
+}
+
+func (ktcf *ktCuckooFilter) mayHave(keyA uint64, keyB uint64, timestamp uint64) bool {
+	i, fp := ktcf.index(keyA, keyB, timestamp)
+	i2 := ktcf.alt(i, fp)
+	for s := 0; s < _KT_CUCKOO_FILTER_BUCKET_ENTRIES; s++ {
+		if ktcf.slot(i, s) == fp || ktcf.slot(i2, s) == fp {
+			return true
+		}
+	}
+	vi, vfp := ktcf.victim()
+	return vfp == fp && (vi == i || vi == i2)
+}
+
+// remove takes out an entry that was added; removing one that was not may
+// take out another that shares its fingerprint.
+func (ktcf *ktCuckooFilter) remove(keyA uint64, keyB uint64, timestamp uint64) bool {
+	i, fp := ktcf.index(keyA, keyB, timestamp)
+	i2 := ktcf.alt(i, fp)
+	if vi, vfp := ktcf.victim(); vfp == fp && (vi == i || vi == i2) {
+		ktcf.setVictim(0, 0)
+		return true
+	}
+	for _, b := range [2]uint32{i, i2} {
+		for s := 0; s < _KT_CUCKOO_FILTER_BUCKET_ENTRIES; s++ {
+			if ktcf.slot(b, s) == fp {
+				ktcf.setSlot(b, s, 0)
+				// With room made, the victim may fit again.
+				if vi, vfp := ktcf.victim(); vfp != 0 && (ktcf.insert(vi, vfp) || ktcf.insert(ktcf.alt(vi, vfp), vfp)) {
+					ktcf.setVictim(0, 0)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ktcf *ktCuckooFilter) reset(salt uint16) {
+	b := ktcf.bits
+	l := len(b)
+	for i := 0; i < l; i++ {
+		b[i] = 0
+	}
+	ktcf.salt = uint32(salt) << 16
+	ktcf.kick = 0
+}
@@ -0,0 +1,110 @@
+package valuestore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKTCuckooFilterBasic(t *testing.T) {
+	f := newKTCuckooFilter(10, 0.01, 0)
+	if f.mayHave(1, 2, 3) {
+		t.Fatal("")
+	}
+	f.add(1, 2, 3)
+	if !f.mayHave(1, 2, 3) {
+		t.Fatal("")
+	}
+	f.reset(0)
+	if f.mayHave(1, 2, 3) {
+		t.Fatal("")
+	}
+	s := f.String()
+	if !strings.HasPrefix(s, "ktCuckooFilter 0x") {
+		t.Fatal(s)
+	}
+	if !strings.HasSuffix(s, " n=10 p=0.010000 salt=0 buckets=3 fingerprint=10 bytes=23") {
+		t.Fatal(s)
+	}
+}
+
+func TestKTCuckooFilterLots(t *testing.T) {
+	f := newKTCuckooFilter(10000, 0.01, 0)
+	for i := uint64(0); i < 10000; i++ {
+		f.add(i, i, i)
+	}
+	for i := uint64(0); i < 10000; i++ {
+		if !f.mayHave(i, i, i) {
+			t.Fatal(i)
+		}
+	}
+	falsePositives := 0
+	for i := uint64(0); i < 10000; i++ {
+		if f.mayHave(i, i, 10001) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 100 {
+		t.Fatal(falsePositives)
+	}
+	// No bigger than the bloom filter for the same n and p.
+	if b := newKTBloomFilter(10000, 0.01, 0); f.msgBodyLength() > len(b.bits)*11/10 {
+		t.Fatal(f.msgBodyLength(), len(b.bits))
+	}
+}
+
+func TestKTCuckooFilterRemove(t *testing.T) {
+	f := newKTCuckooFilter(100, 0.001, 0)
+	for i := uint64(0); i < 100; i++ {
+		f.add(i, i, i)
+	}
+	for i := uint64(0); i < 100; i += 2 {
+		if !f.remove(i, i, i) {
+			t.Fatal(i)
+		}
+	}
+	for i := uint64(0); i < 100; i++ {
+		if f.mayHave(i, i, i) != (i%2 == 1) {
+			t.Fatal(i)
+		}
+	}
+	if f.remove(0, 0, 0) {
+		t.Fatal("")
+	}
+}
+
+func TestKTCuckooFilterPersistence(t *testing.T) {
+	f := newKTCuckooFilter(10, 0.01, 3)
+	for i := uint64(0); i < 10; i++ {
+		f.add(i, i, i)
+	}
+	m := &pullReplicationMsg{
+		vs:     nil,
+		header: make([]byte, _KT_BLOOM_FILTER_HEADER_BYTES+_PULL_REPLICATION_MSG_HEADER_BYTES),
+		body:   make([]byte, f.msgBodyLength()),
+	}
+	f.toMsg(m, _PULL_REPLICATION_MSG_HEADER_BYTES)
+	f2, ok := newKTFilterFromMsg(m, _PULL_REPLICATION_MSG_HEADER_BYTES).(*ktCuckooFilter)
+	if !ok {
+		t.Fatal("")
+	}
+	if f2.n != f.n || f2.p != f.p || f2.salt != f.salt || f2.buckets != f.buckets || f2.fpBits != f.fpBits {
+		t.Fatal(f2)
+	}
+	if !bytes.Equal(f2.bits, f.bits) {
+		t.Fatal("")
+	}
+	for i := uint64(0); i < 10; i++ {
+		if !f2.mayHave(i, i, i) {
+			t.Fatal(i)
+		}
+	}
+	m.body = m.body[:len(m.body)-1]
+	if newKTFilterFromMsg(m, _PULL_REPLICATION_MSG_HEADER_BYTES) != nil {
+		t.Fatal("")
+	}
+	m.header[_PULL_REPLICATION_MSG_HEADER_BYTES+_KT_FILTER_TYPE_OFFSET] = 7
+	if newKTFilterFromMsg(m, _PULL_REPLICATION_MSG_HEADER_BYTES) != nil {
+		t.Fatal("")
+	}
+}
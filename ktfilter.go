@@ -0,0 +1,55 @@
+package valuestore
+
+import (
+	"math"
+)
+
+// The filter types a pull-replication message may carry, recorded in the
+// otherwise unused byte _KT_FILTER_TYPE_OFFSET into the filter header; nodes
+// from before there was a choice leave it zero, which is the bloom filter.
+const (
+	_KT_FILTER_BLOOM  byte = 0
+	_KT_FILTER_CUCKOO byte = 1
+)
+
+const _KT_FILTER_TYPE_OFFSET = 18
+
+// The filter header byte holding flags for how the message is sent:
+// _KT_FILTER_FLAG_COMPRESSED has the filter sent as compressValue frames it
+// rather than as is, see pullReplicationDeflate; _KT_FILTER_FLAG_CHECKSUM has
+// the body followed by a checksum, see msgChecksum.
+const _KT_FILTER_FLAGS_OFFSET = 19
+
+const (
+	_KT_FILTER_FLAG_COMPRESSED byte = 0x01
+	_KT_FILTER_FLAG_CHECKSUM   byte = 0x80
+)
+
+// ktFilter is a key+timestamp set membership filter, as sent in
+// pull-replication messages; see Config.PullReplicationFilter.
+type ktFilter interface {
+	add(keyA uint64, keyB uint64, timestamp uint64)
+	mayHave(keyA uint64, keyB uint64, timestamp uint64) bool
+	reset(salt uint16)
+	toMsg(prm *pullReplicationMsg, headerOffset int)
+	// msgBodyLength is the length of the message body toMsg fills.
+	msgBodyLength() int
+}
+
+// newKTFilterFromMsg returns the filter the message carries, or nil if it is
+// of an unknown type or the message body is the wrong size for it.
+func newKTFilterFromMsg(prm *pullReplicationMsg, headerOffset int) ktFilter {
+	switch prm.header[headerOffset+_KT_FILTER_TYPE_OFFSET] {
+	case _KT_FILTER_BLOOM:
+		ktbf := newKTBloomFilterFromMsg(prm, headerOffset)
+		if ktbf.n == 0 || math.IsNaN(ktbf.p) || ktbf.p <= 0 || ktbf.p >= 1 || uint64(len(ktbf.bits))*8 < uint64(ktbf.m) {
+			return nil
+		}
+		return ktbf
+	case _KT_FILTER_CUCKOO:
+		if ktcf := newKTCuckooFilterFromMsg(prm, headerOffset); ktcf != nil {
+			return ktcf
+		}
+	}
+	return nil
+}
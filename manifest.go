@@ -0,0 +1,253 @@
+package valuestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// manifest: header:32 entries:n
+// manifest entry: namets:8, op:4, checksum:4
+const _MANIFEST_HEADER = "VALUESTOREMANIFEST v0           "
+const _MANIFEST_ENTRY_LENGTH = 16
+const _MANIFEST_NAME = "valuestore.manifest"
+
+// The ops a manifest entry records for the values and TOC files named namets;
+// the last entry for a namets is the one that counts.
+const (
+	// _MANIFEST_CREATE is recorded just before the files are created.
+	_MANIFEST_CREATE = 1
+	// _MANIFEST_COMPACT is recorded once compaction has rewritten what was
+	// still needed from the files and the rewrites are on disk, just before
+	// the files are removed.
+	_MANIFEST_COMPACT = 2
+	// _MANIFEST_DELETE is recorded just before files are removed for any
+	// other reason, such as a failed WriteStream.
+	_MANIFEST_DELETE = 3
+	// _MANIFEST_COMPACT_BEGIN is recorded as compaction starts on the files;
+	// should it not get as far as _MANIFEST_COMPACT, recovery rolls it back,
+	// keeping the files, and a _MANIFEST_CREATE is recorded should it fail
+	// while running.
+	_MANIFEST_COMPACT_BEGIN = 4
+)
+
+// manifestState keeps an append-only record of the values and TOC files
+// created and removed, so recovery knows which are live. Files recorded as
+// compacted or deleted but still around, left by a crash partway through
+// removing them, are removed by recovery rather than loaded again; files
+// recorded as being compacted are kept, the compaction rolled back. Files not
+// in the manifest at all, such as those restored from a backup or written
+// before there was a manifest, are adopted as live. Recovery rewrites the
+// manifest with just the live files, so it doesn't grow without bound.
+type manifestState struct {
+	name string
+	lock sync.Mutex
+	fp   *os.File
+}
+
+func (vs *DefaultValueStore) manifestConfig(cfg *Config) {
+	vs.manifestState.name = path.Join(vs.pathtoc, _MANIFEST_NAME)
+}
+
+// manifestAppend records op for the files named namets.
+func (vs *DefaultValueStore) manifestAppend(op uint32, namets int64) {
+	s := &vs.manifestState
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.fp == nil {
+		return
+	}
+	var b [_MANIFEST_ENTRY_LENGTH]byte
+	putManifestEntry(b[:], op, namets)
+	if _, err := s.fp.Write(b[:]); err != nil {
+		vs.logError("error appending to manifest %s: %s\n", s.name, err)
+	}
+}
+
+// manifestSync fsyncs the manifest, so the entries appended so far survive a
+// power loss.
+func (vs *DefaultValueStore) manifestSync() {
+	s := &vs.manifestState
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.fp == nil {
+		return
+	}
+	if err := s.fp.Sync(); err != nil {
+		vs.logError("error syncing manifest %s: %s\n", s.name, err)
+	}
+}
+
+func (vs *DefaultValueStore) manifestClose() {
+	s := &vs.manifestState
+	s.lock.Lock()
+	if s.fp != nil {
+		s.fp.Close()
+		s.fp = nil
+	}
+	s.lock.Unlock()
+}
+
+func putManifestEntry(b []byte, op uint32, namets int64) {
+	binary.BigEndian.PutUint64(b, uint64(namets))
+	binary.BigEndian.PutUint32(b[8:], op)
+	binary.BigEndian.PutUint32(b[12:], crc32.ChecksumIEEE(b[:12]))
+}
+
+// manifestRead returns the last op recorded for each namets, or nil if there
+// is no manifest. Any partial or damaged trailing entries, as from a crash
+// while appending, are dropped.
+func (vs *DefaultValueStore) manifestRead() map[int64]uint32 {
+	fp, err := os.Open(vs.manifestState.name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			vs.logError("error opening manifest %s: %s\n", vs.manifestState.name, err)
+		}
+		return nil
+	}
+	defer fp.Close()
+	r := bufio.NewReader(fp)
+	b := make([]byte, len(_MANIFEST_HEADER))
+	if _, err = io.ReadFull(r, b); err != nil || string(b) != _MANIFEST_HEADER {
+		vs.logError("bad header in manifest %s\n", vs.manifestState.name)
+		return nil
+	}
+	ops := make(map[int64]uint32)
+	b = b[:_MANIFEST_ENTRY_LENGTH]
+	for {
+		if _, err = io.ReadFull(r, b); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(b[:12]) != binary.BigEndian.Uint32(b[12:]) {
+			vs.logWarning("bad entry in manifest %s; ignoring the rest\n", vs.manifestState.name)
+			break
+		}
+		ops[int64(binary.BigEndian.Uint64(b))] = binary.BigEndian.Uint32(b[8:])
+	}
+	return ops
+}
+
+// manifestRecover returns which of the TOC files found by recovery to load,
+// first removing those the manifest records as compacted or deleted, and then
+// rewrites the manifest to list just the live files and opens it for
+// appending.
+func (vs *DefaultValueStore) manifestRecover(tocNames []string) []string {
+	ops := vs.manifestRead()
+	var live []string
+	var liveTS []int64
+	for _, name := range tocNames {
+		namets, err := strconv.ParseInt(name[:len(name)-len(".valuestoc")], 10, 64)
+		if err != nil || namets == 0 {
+			// Recovery reports these itself.
+			live = append(live, name)
+			continue
+		}
+		op, ok := ops[namets]
+		if ok && op != _MANIFEST_CREATE && op != _MANIFEST_COMPACT_BEGIN {
+			continue
+		}
+		if op == _MANIFEST_COMPACT_BEGIN {
+			atomic.AddInt32(&vs.recoveryCompactionRollbacks, 1)
+			vs.logWarning("rolling back interrupted compaction of %s\n", name)
+		}
+		if !ok && ops != nil {
+			vs.logWarning("%s not in manifest; adopting it\n", name)
+		}
+		live = append(live, name)
+		liveTS = append(liveTS, namets)
+		delete(ops, namets)
+	}
+	// What remains are the files recorded as removed, or as created but with
+	// no TOC file found.
+	var dead []int64
+	for namets, op := range ops {
+		if op == _MANIFEST_CREATE || op == _MANIFEST_COMPACT_BEGIN {
+			vs.logWarning("manifest lists %d but it has no TOC file\n", namets)
+			continue
+		}
+		removed := 0
+		failed := false
+		if err := os.Remove(vs.tocName(namets)); err == nil {
+			removed++
+		} else if !os.IsNotExist(err) {
+			vs.logError("error removing %d.valuestoc: %s\n", namets, err)
+			failed = true
+		}
+		// The values file may be left in any of the Paths when its TOC file
+		// is already gone.
+		for _, dir := range vs.valuesDirs() {
+			if err := vs.fileBackend.Remove(path.Join(dir, fmt.Sprintf("%019d.values", namets))); err == nil {
+				removed++
+			} else if !os.IsNotExist(err) {
+				vs.logError("error removing %019d.values: %s\n", namets, err)
+				failed = true
+			}
+		}
+		vs.pathsForget(namets)
+		if removed > 0 {
+			atomic.AddInt32(&vs.recoveryStrayFiles, int32(removed))
+			vs.logWarning("removed %d files of %d left by an interrupted removal\n", removed, namets)
+		}
+		// Whatever couldn't be removed is tried again next time.
+		if failed {
+			dead = append(dead, namets)
+		}
+	}
+	if err := vs.manifestRewrite(liveTS, dead); err != nil {
+		vs.logError("error writing manifest %s: %s\n", vs.manifestState.name, err)
+	}
+	return live
+}
+
+// manifestRewrite replaces the manifest with one listing just the given
+// files, written under a temporary name and then renamed so that a crash
+// midway leaves the previous manifest intact, and opens it for appending.
+func (vs *DefaultValueStore) manifestRewrite(live []int64, dead []int64) error {
+	s := &vs.manifestState
+	fp, err := os.Create(s.name + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fp)
+	b := make([]byte, _MANIFEST_ENTRY_LENGTH)
+	_, err = w.WriteString(_MANIFEST_HEADER)
+	for i := 0; err == nil && i < len(live); i++ {
+		putManifestEntry(b, _MANIFEST_CREATE, live[i])
+		_, err = w.Write(b)
+	}
+	for i := 0; err == nil && i < len(dead); i++ {
+		putManifestEntry(b, _MANIFEST_DELETE, dead[i])
+		_, err = w.Write(b)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(s.name+".tmp", s.name)
+	}
+	if err != nil {
+		os.Remove(s.name + ".tmp")
+		return err
+	}
+	fp, err = os.OpenFile(s.name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.fp = fp
+	s.lock.Unlock()
+	return nil
+}
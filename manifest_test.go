@@ -0,0 +1,90 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 100)
+	vs.Close()
+	tocs := h.files(".valuestoc")
+	values := h.files(".values")
+	if len(tocs) != 1 || len(values) != 1 {
+		t.Fatal(tocs, values)
+	}
+	namets, err := strconv.ParseInt(strings.TrimSuffix(path.Base(tocs[0]), ".valuestoc"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyFiles := func(to int64) {
+		for _, c := range [][2]string{
+			{tocs[0], path.Join(h.dir, fmt.Sprintf("%d.valuestoc", to))},
+			{values[0], path.Join(h.dir, fmt.Sprintf("%019d.values", to))},
+		} {
+			b, err := ioutil.ReadFile(c[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = ioutil.WriteFile(c[1], b, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Files compacted but left behind, as by a crash before their removal,
+	// are removed rather than loaded; a partial entry after the record is
+	// ignored.
+	copyFiles(namets + 1)
+	fp, err := os.OpenFile(path.Join(h.dir, _MANIFEST_NAME), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, _MANIFEST_ENTRY_LENGTH)
+	putManifestEntry(b, _MANIFEST_COMPACT, namets+1)
+	if _, err = fp.Write(append(b, 1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	vs = h.open()
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryStrayFiles != 2 || stats.RecoveryDuplicates != 0 {
+		t.Fatal(stats.RecoveryStrayFiles, stats.RecoveryDuplicates)
+	}
+	if got := h.files(".valuestoc"); len(got) != 1 || got[0] != tocs[0] {
+		t.Fatal(got)
+	}
+	if got := h.files(".values"); len(got) != 1 || got[0] != values[0] {
+		t.Fatal(got)
+	}
+	if n := h.verify(vs); n != len(h.values) {
+		t.Fatal(n, len(h.values))
+	}
+	vs.Close()
+	// Files the manifest doesn't know of are adopted, as are all of them when
+	// there is no manifest.
+	copyFiles(namets + 2)
+	vs = h.open()
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryStrayFiles != 0 || stats.RecoveryDuplicates != 100 {
+		t.Fatal(stats.RecoveryStrayFiles, stats.RecoveryDuplicates)
+	}
+	vs.Close()
+	if err = os.Remove(path.Join(h.dir, _MANIFEST_NAME)); err != nil {
+		t.Fatal(err)
+	}
+	vs = h.open()
+	defer vs.Close()
+	if n := h.verify(vs); n != len(h.values) {
+		t.Fatal(n, len(h.values))
+	}
+	ops := vs.manifestRead()
+	if len(ops) != 2 || ops[namets] != _MANIFEST_CREATE || ops[namets+2] != _MANIFEST_CREATE {
+		t.Fatal(ops)
+	}
+}
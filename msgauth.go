@@ -0,0 +1,191 @@
+package valuestore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+// _MSG_AUTH_LENGTH is the length of the HMAC-SHA256 that follows the content
+// of each message when Config.MessageAuthKey is set.
+const _MSG_AUTH_LENGTH = sha256.Size
+
+// _MSG_AUTH_SLACK is allowed on top of the largest message content a Config
+// cap permits, for the headers and trailers around the content; see
+// msgAuthState.msgMax.
+const _MSG_AUTH_SLACK = 4096
+
+// _MSG_AUTH_BUF_KEEP is the largest buffer put back in msgAuthState.bufs, so
+// one large message doesn't keep its buffer's memory for good.
+const _MSG_AUTH_BUF_KEEP = 65536
+
+// msgAuthState holds Config.MessageAuthKey. With it set, the MsgRing, and the
+// RemoteMsgRing, are wrapped in a msgAuthRing, so every message sent has an
+// HMAC of its type and content appended and every message received is read
+// whole and checked before its handler sees any of it. Those that do not
+// check out, whether from a peer with another key, or none, or from
+// something that is no peer at all, are dropped and counted. There is no
+// marker for whether a message carries the HMAC, so every node in the ring
+// needs the same key, set or changed with all of them down together.
+type msgAuthState struct {
+	key  []byte
+	macs sync.Pool
+	// msgMax is the longest message, HMAC included, that is read to be
+	// checked; longer ones can't have been sent by a peer and are discarded
+	// unread, so a claimed length can't have memory allocated for it before
+	// the HMAC proves the sender has the key.
+	msgMax uint64
+	// bufs holds *[]byte buffers incoming messages are read into to be
+	// checked.
+	bufs sync.Pool
+}
+
+func (vs *DefaultValueStore) msgAuthConfig(cfg *Config) {
+	s := &vs.msgAuthState
+	if len(cfg.MessageAuthKey) == 0 {
+		return
+	}
+	s.key = append([]byte(nil), cfg.MessageAuthKey...)
+	for _, c := range []int{cfg.MsgCap, cfg.BulkSetMsgCap, cfg.BulkSetAckMsgCap, cfg.ValueCap} {
+		vs.msgAuthAllow(c + _MSG_AUTH_SLACK)
+	}
+	s.macs.New = func() interface{} {
+		return hmac.New(sha256.New, s.key)
+	}
+	s.bufs.New = func() interface{} {
+		b := make([]byte, 0, _MSG_AUTH_BUF_KEEP)
+		return &b
+	}
+	vs.msgRing = vs.msgAuthWrap(vs.msgRing)
+}
+
+// msgAuthAllow raises msgAuthState.msgMax to allow messages of length bytes,
+// before their HMAC; for messages, such as pull replication's, whose length
+// isn't set by a Config cap. It is to be called only while configuring.
+func (vs *DefaultValueStore) msgAuthAllow(length int) {
+	if l := uint64(length) + _MSG_AUTH_LENGTH; l > vs.msgAuthState.msgMax {
+		vs.msgAuthState.msgMax = l
+	}
+}
+
+// msgAuthWrap returns the MsgRing wrapped in a msgAuthRing, or as is if there
+// is no Config.MessageAuthKey or no MsgRing.
+func (vs *DefaultValueStore) msgAuthWrap(msgRing ring.MsgRing) ring.MsgRing {
+	if len(vs.msgAuthState.key) == 0 || msgRing == nil {
+		return msgRing
+	}
+	return &msgAuthRing{MsgRing: msgRing, vs: vs}
+}
+
+// msgAuthMAC returns an HMAC, to be put back in vs.msgAuthState.macs, that
+// has had the message type written to it, ready for the content.
+func (vs *DefaultValueStore) msgAuthMAC(msgType uint64) hash.Hash {
+	mac := vs.msgAuthState.macs.Get().(hash.Hash)
+	mac.Reset()
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], msgType)
+	mac.Write(t[:])
+	return mac
+}
+
+// msgAuthRing is a ring.MsgRing that appends an HMAC to outgoing messages and
+// checks and strips it from incoming ones; see msgAuthState.
+type msgAuthRing struct {
+	ring.MsgRing
+	vs *DefaultValueStore
+}
+
+func (r *msgAuthRing) SetMsgHandler(msgType uint64, handler ring.MsgUnmarshaller) {
+	r.MsgRing.SetMsgHandler(msgType, func(rd io.Reader, l uint64) (uint64, error) {
+		return r.vs.msgAuthIn(msgType, handler, rd, l)
+	})
+}
+
+func (r *msgAuthRing) MsgToNode(msg ring.Msg, nodeID uint64, timeout time.Duration) {
+	r.MsgRing.MsgToNode(&msgAuthMsg{Msg: msg, vs: r.vs}, nodeID, timeout)
+}
+
+func (r *msgAuthRing) MsgToOtherReplicas(msg ring.Msg, partition uint32, timeout time.Duration) {
+	r.MsgRing.MsgToOtherReplicas(&msgAuthMsg{Msg: msg, vs: r.vs}, partition, timeout)
+}
+
+// msgAuthIn reads an incoming message of length l whole, and hands it to the
+// handler, less its HMAC, only if that checks out. Messages too short to hold
+// an HMAC, or longer than msgAuthState.msgMax, are discarded unread.
+func (vs *DefaultValueStore) msgAuthIn(msgType uint64, handler ring.MsgUnmarshaller, r io.Reader, l uint64) (uint64, error) {
+	s := &vs.msgAuthState
+	if l < _MSG_AUTH_LENGTH || l > s.msgMax {
+		left := l
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err := r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				return l - left, err
+			}
+		}
+		atomic.AddInt32(&vs.inMsgAuthFailures, 1)
+		return l, nil
+	}
+	buf := s.bufs.Get().(*[]byte)
+	defer func() {
+		if cap(*buf) <= _MSG_AUTH_BUF_KEEP {
+			s.bufs.Put(buf)
+		}
+	}()
+	if uint64(cap(*buf)) < l+_MSG_AUTH_LENGTH {
+		*buf = make([]byte, l, l+_MSG_AUTH_LENGTH)
+	}
+	b := (*buf)[:l]
+	n, err := io.ReadFull(r, b)
+	if err != nil {
+		return uint64(n), err
+	}
+	content, sum := b[:l-_MSG_AUTH_LENGTH], b[l-_MSG_AUTH_LENGTH:]
+	mac := vs.msgAuthMAC(msgType)
+	mac.Write(content)
+	ok := hmac.Equal(sum, mac.Sum(b[l:]))
+	s.macs.Put(mac)
+	if !ok {
+		atomic.AddInt32(&vs.inMsgAuthFailures, 1)
+		return l, nil
+	}
+	_, err = handler(bytes.NewReader(content), uint64(len(content)))
+	return l, err
+}
+
+// msgAuthMsg is an outgoing ring.Msg with its HMAC appended; see
+// msgAuthState.
+type msgAuthMsg struct {
+	ring.Msg
+	vs *DefaultValueStore
+}
+
+func (m *msgAuthMsg) MsgLength() uint64 {
+	return m.Msg.MsgLength() + _MSG_AUTH_LENGTH
+}
+
+// WriteContent sums the content as it is written, with an HMAC of its own as
+// the MsgRing may be writing the message to several nodes at once.
+func (m *msgAuthMsg) WriteContent(w io.Writer) (uint64, error) {
+	mac := m.vs.msgAuthMAC(m.MsgType())
+	defer m.vs.msgAuthState.macs.Put(mac)
+	n, err := m.Msg.WriteContent(io.MultiWriter(w, mac))
+	if err != nil {
+		return n, err
+	}
+	var sum [_MSG_AUTH_LENGTH]byte
+	sn, err := w.Write(mac.Sum(sum[:0]))
+	return n + uint64(sn), err
+}
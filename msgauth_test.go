@@ -0,0 +1,107 @@
+package valuestore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+type msgRingAuthTester struct {
+	handlers map[uint64]ring.MsgUnmarshaller
+	held     []ring.Msg
+}
+
+func (m *msgRingAuthTester) Ring() ring.Ring {
+	return nil
+}
+
+func (m *msgRingAuthTester) MaxMsgLength() uint64 {
+	return 65536
+}
+
+func (m *msgRingAuthTester) SetMsgHandler(msgType uint64, handler ring.MsgUnmarshaller) {
+	m.handlers[msgType] = handler
+}
+
+func (m *msgRingAuthTester) MsgToNode(msg ring.Msg, nodeID uint64, timeout time.Duration) {
+	m.held = append(m.held, msg)
+}
+
+func (m *msgRingAuthTester) MsgToOtherReplicas(msg ring.Msg, partition uint32, timeout time.Duration) {
+	m.held = append(m.held, msg)
+}
+
+func (m *msgRingAuthTester) deliver(t *testing.T, msg ring.Msg) {
+	buf := bytes.NewBuffer(nil)
+	n, err := msg.WriteContent(buf)
+	if err != nil || n != msg.MsgLength() {
+		t.Fatal(n, err)
+	}
+	l := uint64(buf.Len())
+	if n, err = m.handlers[msg.MsgType()](buf, l); err != nil || n != l {
+		t.Fatal(n, err)
+	}
+}
+
+func TestMsgAuth(t *testing.T) {
+	m := &msgRingAuthTester{handlers: make(map[uint64]ring.MsgUnmarshaller)}
+	vs := New(&Config{Path: t.TempDir(), MsgRing: m, MessageAuthKey: []byte("secret")})
+	defer vs.Close()
+	other := New(&Config{Path: t.TempDir(), MsgRing: &msgRingAuthTester{handlers: make(map[uint64]ring.MsgUnmarshaller)}, MessageAuthKey: []byte("other")})
+	defer other.Close()
+	out := vs.newOutBulkSetMsg()
+	out.add(1, 2, 0x300, []byte("testing"))
+	vs.msgRing.MsgToNode(out, 1, time.Second)
+	if len(m.held) != 1 || m.held[0].MsgLength() != out.MsgLength()+_MSG_AUTH_LENGTH {
+		t.Fatal(m.held)
+	}
+	m.deliver(t, m.held[0])
+	m.held[0].Free()
+	// Sent with another key, or none, the same message is dropped.
+	out = other.newOutBulkSetMsg()
+	out.add(1, 2, 0x300, []byte("testing"))
+	other.msgRing.MsgToNode(out, 1, time.Second)
+	m.deliver(t, other.msgRing.(*msgAuthRing).MsgRing.(*msgRingAuthTester).held[0])
+	m.deliver(t, out)
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSets != 1 || stats.InMsgAuthFailures != 2 {
+		t.Fatal(stats.InBulkSets, stats.InMsgAuthFailures)
+	}
+}
+
+type msgAuthZeroReader struct {
+	n uint64
+}
+
+func (r *msgAuthZeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	r.n += uint64(len(p))
+	return len(p), nil
+}
+
+func TestMsgAuthOversized(t *testing.T) {
+	m := &msgRingAuthTester{handlers: make(map[uint64]ring.MsgUnmarshaller)}
+	vs := New(&Config{Path: t.TempDir(), MsgRing: m, MessageAuthKey: []byte("secret")})
+	defer vs.Close()
+	// A claimed length past any legal message is read off and dropped
+	// without being buffered.
+	l := vs.msgAuthState.msgMax + 1
+	r := &msgAuthZeroReader{}
+	if n, err := m.handlers[_BULK_SET_MSG_TYPE](r, l); err != nil || n != l || r.n != l {
+		t.Fatal(n, err, r.n)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSets != 0 || stats.InMsgAuthFailures != 1 {
+		t.Fatal(stats.InBulkSets, stats.InMsgAuthFailures)
+	}
+	// A buffer grown for a large message isn't kept.
+	l = uint64(_MSG_AUTH_BUF_KEEP + 1)
+	if n, err := m.handlers[_BULK_SET_MSG_TYPE](&msgAuthZeroReader{}, l); err != nil || n != l {
+		t.Fatal(n, err)
+	}
+	if b := vs.msgAuthState.bufs.Get().(*[]byte); cap(*b) > _MSG_AUTH_BUF_KEEP {
+		t.Fatal(cap(*b))
+	}
+}
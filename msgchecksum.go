@@ -0,0 +1,38 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Bulk-set, bulk-set-ack, and pull-replication messages end with a CRC-32C of
+// their header and body, so a message damaged on its way is dropped rather
+// than having its offsets and lengths believed. Each message type marks that
+// it carries the checksum in a way nodes from before it existed ignore; see
+// _BULK_SET_MSG_FLAG_CHECKSUM, bulkSetAckMsgChecksummed, and
+// _KT_FILTER_FLAG_CHECKSUM.
+const _MSG_CHECKSUM_LENGTH = 4
+
+var msgChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+func msgChecksum(header []byte, body []byte) uint32 {
+	return crc32.Update(crc32.Checksum(header, msgChecksumTable), msgChecksumTable, body)
+}
+
+// msgChecksumTrailer returns the checksum of header and body as it is sent
+// after them.
+func msgChecksumTrailer(header []byte, body []byte) [_MSG_CHECKSUM_LENGTH]byte {
+	var b [_MSG_CHECKSUM_LENGTH]byte
+	binary.BigEndian.PutUint32(b[:], msgChecksum(header, body))
+	return b
+}
+
+// msgChecksumVerify returns body without its checksum trailer and whether the
+// trailer matched header and the rest of body.
+func msgChecksumVerify(header []byte, body []byte) ([]byte, bool) {
+	if len(body) < _MSG_CHECKSUM_LENGTH {
+		return body, false
+	}
+	o := len(body) - _MSG_CHECKSUM_LENGTH
+	return body[:o], binary.BigEndian.Uint32(body[o:]) == msgChecksum(header, body[:o])
+}
@@ -0,0 +1,123 @@
+package valuestore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMsgChecksumBulkSet(t *testing.T) {
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
+	for i := 0; i < len(vs.bulkSetState.inBulkSetDoneChans); i++ {
+		vs.bulkSetState.inMsgChan <- nil
+	}
+	for _, doneChan := range vs.bulkSetState.inBulkSetDoneChans {
+		<-doneChan
+	}
+	out := vs.newOutBulkSetMsg()
+	out.add(1, 2, 0x300, []byte("testing"))
+	buf := bytes.NewBuffer(nil)
+	if _, err := out.WriteContent(buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	n, err := vs.newInBulkSetMsg(bytes.NewBuffer(b), uint64(len(b)))
+	if err != nil || n != uint64(len(b)) {
+		t.Fatal(n, err)
+	}
+	bsm := <-vs.bulkSetState.inMsgChan
+	if !bytes.Equal(bsm.body, out.body) || bsm.header[16]&_BULK_SET_MSG_FLAG_CHECKSUM != 0 {
+		t.Fatal(bsm.header, bsm.body)
+	}
+	vs.inBulkSetFree(bsm)
+	// A changed length would otherwise have the entry run past the body.
+	b[_BULK_SET_MSG_HEADER_LENGTH+27]++
+	n, err = vs.newInBulkSetMsg(bytes.NewBuffer(b), uint64(len(b)))
+	if err != nil || n != uint64(len(b)) {
+		t.Fatal(n, err)
+	}
+	select {
+	case bsm = <-vs.bulkSetState.inMsgChan:
+		t.Fatal(bsm)
+	default:
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSets != 1 || stats.InBulkSetCorrupts != 1 {
+		t.Fatal(stats.InBulkSets, stats.InBulkSetCorrupts)
+	}
+	// Messages from nodes that do not send the checksum are taken as is.
+	b[_BULK_SET_MSG_HEADER_LENGTH+27]--
+	b[16] &^= _BULK_SET_MSG_FLAG_CHECKSUM
+	b = b[:len(b)-_MSG_CHECKSUM_LENGTH]
+	if _, err = vs.newInBulkSetMsg(bytes.NewBuffer(b), uint64(len(b))); err != nil {
+		t.Fatal(err)
+	}
+	bsm = <-vs.bulkSetState.inMsgChan
+	if !bytes.Equal(bsm.body, out.body) {
+		t.Fatal(bsm.body)
+	}
+}
+
+func TestMsgChecksumBulkSetAck(t *testing.T) {
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
+	for i := 0; i < len(vs.bulkSetAckState.inBulkSetAckDoneChans); i++ {
+		vs.bulkSetAckState.inMsgChan <- nil
+	}
+	for _, doneChan := range vs.bulkSetAckState.inBulkSetAckDoneChans {
+		<-doneChan
+	}
+	out := vs.newOutBulkSetAckMsg()
+	out.status = true
+	out.addMsgID(7)
+	out.addStatus(1, 2, 0x300, _BULK_SET_ACK_STATUS_APPLIED)
+	buf := bytes.NewBuffer(nil)
+	if _, err := out.WriteContent(buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	n, err := vs.newInBulkSetAckStatusMsg(bytes.NewBuffer(b), uint64(len(b)))
+	if err != nil || n != uint64(len(b)) {
+		t.Fatal(n, err)
+	}
+	bsam := <-vs.bulkSetAckState.inMsgChan
+	if !bytes.Equal(bsam.body, out.body) {
+		t.Fatal(bsam.body)
+	}
+	vs.bulkSetAckState.inFreeMsgChan <- bsam
+	b[len(b)-_MSG_CHECKSUM_LENGTH-1] ^= 0xff
+	n, err = vs.newInBulkSetAckStatusMsg(bytes.NewBuffer(b), uint64(len(b)))
+	if err != nil || n != uint64(len(b)) {
+		t.Fatal(n, err)
+	}
+	select {
+	case bsam = <-vs.bulkSetAckState.inMsgChan:
+		t.Fatal(bsam)
+	default:
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InBulkSetAcks != 1 || stats.InBulkSetAckCorrupts != 1 {
+		t.Fatal(stats.InBulkSetAcks, stats.InBulkSetAckCorrupts)
+	}
+}
+
+func TestMsgChecksumPullReplication(t *testing.T) {
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}})
+	f := vs.newOutKTFilter()
+	f.add(1, 2, 3)
+	out := vs.newOutPullReplicationMsg(1, 2, 3, 4, 5, f)
+	if out.flags()&_KT_FILTER_FLAG_CHECKSUM == 0 || out.MsgLength() != uint64(len(out.header)+len(out.body)+_MSG_CHECKSUM_LENGTH) {
+		t.Fatal(out.header, out.MsgLength())
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := out.WriteContent(buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if n, err := vs.newInPullReplicationMsg(bytes.NewBuffer(b), uint64(len(b))); err != nil || n != uint64(len(b)) {
+		t.Fatal(n, err)
+	}
+	b[len(out.header)+1] ^= 0xff
+	if n, err := vs.newInPullReplicationMsg(bytes.NewBuffer(b), uint64(len(b))); err != nil || n != uint64(len(b)) {
+		t.Fatal(n, err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.InPullReplications != 1 || stats.InPullReplicationCorrupts != 1 {
+		t.Fatal(stats.InPullReplications, stats.InPullReplicationCorrupts)
+	}
+}
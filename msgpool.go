@@ -0,0 +1,122 @@
+package valuestore
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// msgPool tracks the sizing and utilization of one of the free message pools.
+// The pools themselves are still buffered channels, created with the
+// capacity of the largest size allowed but only holding as many messages as
+// have been allocated so far. Messages are allocated as needed up to the
+// maximum and idle messages are discarded by msgPoolTrimmer down to the
+// minimum.
+type msgPool struct {
+	name      string
+	min       int32
+	max       int32
+	allocated int32
+	// lowWater is the fewest free messages seen since the last trim.
+	lowWater int32
+	misses   int32
+	grows    int32
+	shrinks  int32
+}
+
+type msgPoolState struct {
+	interval time.Duration
+	pools    []*msgPool
+	trims    []func() bool
+}
+
+// newMsgPool returns a msgPool sized based on the configured number of
+// messages and MsgPoolMinPercent and MsgPoolMaxPercent. The caller should
+// allocate pool.min messages to start with.
+func (vs *DefaultValueStore) newMsgPool(name string, cfg *Config, size int, trim func() bool) *msgPool {
+	min := size * cfg.MsgPoolMinPercent / 100
+	if min < 1 {
+		min = 1
+	}
+	max := size * cfg.MsgPoolMaxPercent / 100
+	if max < min {
+		max = min
+	}
+	pool := &msgPool{name: name, min: int32(min), max: int32(max), allocated: int32(min), lowWater: int32(min)}
+	vs.msgPoolState.pools = append(vs.msgPoolState.pools, pool)
+	vs.msgPoolState.trims = append(vs.msgPoolState.trims, trim)
+	return pool
+}
+
+func (vs *DefaultValueStore) msgPoolConfig(cfg *Config) {
+	vs.msgPoolState.interval = time.Duration(cfg.MsgPoolInterval) * time.Second
+}
+
+func (vs *DefaultValueStore) msgPoolLaunch() {
+	if len(vs.msgPoolState.pools) > 0 {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.msgPoolTrimmer()
+	}
+}
+
+// got records that a message was taken from the pool, leaving free messages
+// still in the pool.
+func (pool *msgPool) got(free int) {
+	for {
+		lowWater := atomic.LoadInt32(&pool.lowWater)
+		if int32(free) >= lowWater || atomic.CompareAndSwapInt32(&pool.lowWater, lowWater, int32(free)) {
+			return
+		}
+	}
+}
+
+// grow is called when the pool is empty and returns true if another message
+// may be allocated for the pool.
+func (pool *msgPool) grow() bool {
+	atomic.AddInt32(&pool.misses, 1)
+	pool.got(0)
+	for {
+		allocated := atomic.LoadInt32(&pool.allocated)
+		if allocated >= pool.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&pool.allocated, allocated, allocated+1) {
+			atomic.AddInt32(&pool.grows, 1)
+			return true
+		}
+	}
+}
+
+// msgPoolTrimmer periodically discards messages that have sat unused in their
+// pools for the whole interval.
+func (vs *DefaultValueStore) msgPoolTrimmer() {
+	for vs.closeSleep(vs.msgPoolState.interval) {
+		vs.msgPoolTrim()
+	}
+	vs.closeState.backgroundWG.Done()
+}
+
+func (vs *DefaultValueStore) msgPoolTrim() {
+	for i, pool := range vs.msgPoolState.pools {
+		n := atomic.SwapInt32(&pool.lowWater, pool.max)
+		for ; n > 0; n-- {
+			allocated := atomic.LoadInt32(&pool.allocated)
+			if allocated <= pool.min {
+				break
+			}
+			if !atomic.CompareAndSwapInt32(&pool.allocated, allocated, allocated-1) {
+				n++
+				continue
+			}
+			if !vs.msgPoolState.trims[i]() {
+				atomic.AddInt32(&pool.allocated, 1)
+				break
+			}
+			atomic.AddInt32(&pool.shrinks, 1)
+		}
+	}
+}
+
+func (pool *msgPool) String() string {
+	return fmt.Sprintf("%d allocated, %d min, %d max", atomic.LoadInt32(&pool.allocated), pool.min, pool.max)
+}
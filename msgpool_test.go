@@ -0,0 +1,42 @@
+package valuestore
+
+import (
+	"testing"
+)
+
+func TestMsgPoolGrowAndTrim(t *testing.T) {
+	vs := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{}, OutBulkSetMsgs: 4, MsgPoolMinPercent: 50, MsgPoolMaxPercent: 100})
+	pool := vs.bulkSetState.outPool
+	if pool.min != 2 || pool.max != 4 {
+		t.Fatal(pool.min, pool.max)
+	}
+	var bsms []*bulkSetMsg
+	for i := 0; i < 4; i++ {
+		bsms = append(bsms, vs.newOutBulkSetMsg())
+	}
+	if pool.allocated != 4 {
+		t.Fatal(pool.allocated)
+	}
+	if pool.grow() {
+		t.Fatal("")
+	}
+	for _, bsm := range bsms {
+		bsm.Free()
+	}
+	// Everything was in use at some point during this interval, so nothing
+	// is trimmed yet.
+	vs.msgPoolTrim()
+	if pool.allocated != 4 {
+		t.Fatal(pool.allocated)
+	}
+	// Nothing was used during this interval, so the pool shrinks back to its
+	// minimum.
+	vs.msgPoolTrim()
+	if pool.allocated != 2 || len(vs.bulkSetState.outFreeMsgChan) != 2 {
+		t.Fatal(pool.allocated, len(vs.bulkSetState.outFreeMsgChan))
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.MsgPoolGrows != 2 || stats.MsgPoolShrinks != 2 || stats.MsgPoolMisses != 3 {
+		t.Fatal(stats.MsgPoolGrows, stats.MsgPoolShrinks, stats.MsgPoolMisses)
+	}
+}
@@ -0,0 +1,171 @@
+package valuestore
+
+import (
+	"math"
+)
+
+// NAMESPACE_KEYB_MAX is the largest keyB a Namespace may be given; the top
+// byte of the keyB actually stored is the namespace ID.
+const NAMESPACE_KEYB_MAX = 1<<56 - 1
+
+const _NAMESPACE_SHIFT = 56
+
+// Namespace is returned by DefaultValueStore.Namespace and gives an
+// application its own part of the key space, so several can share a store
+// without having to agree on how to keep their keys apart.
+//
+// The namespace ID is kept in the top byte of keyB, leaving keyA, which
+// decides the partition, as the application gave it. Since it is just part of
+// the key, the ID goes everywhere the key does: the TOC files, bulk-set
+// messages, and replication. Keys written directly to the store, rather than
+// through a Namespace, are in whatever namespace the top byte of their keyB
+// names, so applications sharing a store should all use Namespaces.
+type Namespace struct {
+	vs *DefaultValueStore
+	id uint8
+}
+
+// NamespaceStats is returned by Namespace.Stats.
+type NamespaceStats struct {
+	// Values is the number of keys with values.
+	Values uint64
+	// ValueBytes is the total length of the values.
+	ValueBytes uint64
+	// Tombstones is the number of deletion markers.
+	Tombstones uint64
+}
+
+// Namespace returns the Namespace with the given ID.
+func (vs *DefaultValueStore) Namespace(id uint8) *Namespace {
+	return &Namespace{vs: vs, id: id}
+}
+
+// ID returns the namespace's ID.
+func (ns *Namespace) ID() uint8 {
+	return ns.id
+}
+
+// keyB returns the keyB stored for the namespace's keyB.
+func (ns *Namespace) keyB(keyB uint64) (uint64, error) {
+	if keyB > NAMESPACE_KEYB_MAX {
+		return 0, ErrNamespaceKeyB
+	}
+	return uint64(ns.id)<<_NAMESPACE_SHIFT | keyB, nil
+}
+
+// has returns true if the stored keyB is in the namespace.
+func (ns *Namespace) has(keyB uint64) bool {
+	return uint8(keyB>>_NAMESPACE_SHIFT) == ns.id
+}
+
+// Lookup is DefaultValueStore.Lookup within the namespace.
+func (ns *Namespace) Lookup(keyA uint64, keyB uint64) (int64, uint32, error) {
+	keyB, err := ns.keyB(keyB)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ns.vs.Lookup(keyA, keyB)
+}
+
+// Read is DefaultValueStore.Read within the namespace.
+func (ns *Namespace) Read(keyA uint64, keyB uint64, value []byte) (int64, []byte, error) {
+	keyB, err := ns.keyB(keyB)
+	if err != nil {
+		return 0, value, err
+	}
+	return ns.vs.Read(keyA, keyB, value)
+}
+
+// Write is DefaultValueStore.Write within the namespace.
+func (ns *Namespace) Write(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
+	keyB, err := ns.keyB(keyB)
+	if err != nil {
+		return 0, err
+	}
+	return ns.vs.Write(keyA, keyB, timestampmicro, value)
+}
+
+// Delete is DefaultValueStore.Delete within the namespace.
+func (ns *Namespace) Delete(keyA uint64, keyB uint64, timestampmicro int64) (int64, error) {
+	keyB, err := ns.keyB(keyB)
+	if err != nil {
+		return 0, err
+	}
+	return ns.vs.Delete(keyA, keyB, timestampmicro)
+}
+
+// DeleteIf is DefaultValueStore.DeleteIf within the namespace.
+func (ns *Namespace) DeleteIf(keyA uint64, keyB uint64, timestampmicro int64, expectedTimestampmicro int64) (int64, error) {
+	keyB, err := ns.keyB(keyB)
+	if err != nil {
+		return 0, err
+	}
+	return ns.vs.DeleteIf(keyA, keyB, timestampmicro, expectedTimestampmicro)
+}
+
+// Scan is DefaultValueStore.Scan within the namespace; callback is given
+// keyB as it was given to the namespace.
+func (ns *Namespace) Scan(start uint64, stop uint64, withValues bool, callback func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool) error {
+	return ns.vs.scan(start, stop, math.MaxUint64, ns.has, withValues, func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+		return callback(keyA, keyB&NAMESPACE_KEYB_MAX, timestampmicro, value)
+	})
+}
+
+// ScanKeys is DefaultValueStore.ScanKeys within the namespace; callback is
+// given keyB as it was given to the namespace.
+func (ns *Namespace) ScanKeys(start uint64, stop uint64, callback func(keyA uint64, keyB uint64, timestampmicro int64, length uint32) bool) {
+	ns.vs.scanKeys(start, stop, ns.has, func(keyA uint64, keyB uint64, timestampmicro int64, length uint32) bool {
+		return callback(keyA, keyB&NAMESPACE_KEYB_MAX, timestampmicro, length)
+	})
+}
+
+// DeleteAll deletes every key in the namespace with a value older than
+// timestampmicro, returning how many were deleted. The deletions replicate as
+// any other would. Any error from a delete ends it early and is returned.
+func (ns *Namespace) DeleteAll(timestampmicro int64) (int, error) {
+	cutoff := uint64(timestampmicro) << _TSB_UTIL_BITS
+	keys := make([]uint64, 0, _SCAN_BATCH_SIZE*2)
+	var deleted int
+	start := uint64(0)
+	more := true
+	for more {
+		keys = keys[:0]
+		// Deleting while in ScanCallback would have it in the way of the
+		// writes, so the keys are gathered in batches first.
+		start, more = ns.vs.vlm.ScanCallback(start, math.MaxUint64, 0, _TSB_DELETION|_TSB_LOCAL_REMOVAL, cutoff, _SCAN_BATCH_SIZE, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+			if ns.has(keyB) {
+				keys = append(keys, keyA, keyB)
+			}
+			return true
+		})
+		for i := 0; i < len(keys); i += 2 {
+			if _, err := ns.vs.Delete(keys[i], keys[i+1], timestampmicro); err == ErrNewerVersion {
+				// Written again since it was gathered.
+				continue
+			} else if err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Stats returns the namespace's NamespaceStats, found by scanning all its
+// keys.
+func (ns *Namespace) Stats() *NamespaceStats {
+	stats := &NamespaceStats{}
+	ns.vs.vlm.ScanCallback(0, math.MaxUint64, 0, _TSB_LOCAL_REMOVAL, math.MaxUint64, math.MaxUint64, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+		if !ns.has(keyB) {
+			return true
+		}
+		if timestampbits&_TSB_DELETION != 0 {
+			stats.Tombstones++
+		} else {
+			stats.Values++
+			stats.ValueBytes += uint64(length)
+		}
+		return true
+	})
+	return stats
+}
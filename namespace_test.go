@@ -0,0 +1,81 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	vs.EnableWrites()
+	defer vs.Close()
+	ns1 := vs.Namespace(1)
+	ns2 := vs.Namespace(2)
+	// The same key in two namespaces doesn't collide.
+	for keyA := uint64(1); keyA <= 10; keyA++ {
+		if _, err = ns1.Write(keyA, 2, 1000, []byte("one")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ns2.Write(keyA, 2, 1000, []byte("two!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, value, err := ns1.Read(1, 2, nil); err != nil || string(value) != "one" {
+		t.Fatal(string(value), err)
+	}
+	if _, value, err := ns2.Read(1, 2, nil); err != nil || string(value) != "two!" {
+		t.Fatal(string(value), err)
+	}
+	if _, _, err := vs.Read(1, 2, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if _, value, err := vs.Read(1, 1<<56|2, nil); err != nil || string(value) != "one" {
+		t.Fatal(string(value), err)
+	}
+	if _, err = ns1.Write(1, NAMESPACE_KEYB_MAX+1, 1000, nil); err != ErrNamespaceKeyB {
+		t.Fatal(err)
+	}
+	if _, err = ns1.Delete(1, 2, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if ts, _, err := ns1.Lookup(1, 2); err != ErrNotFound || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	for _, withValues := range []bool{false, true} {
+		var n int
+		if err = ns2.Scan(0, NAMESPACE_KEYB_MAX, withValues, func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+			if keyB != 2 || withValues && string(value) != "two!" {
+				t.Fatal(keyA, keyB, string(value))
+			}
+			n++
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if n != 10 {
+			t.Fatal(withValues, n)
+		}
+	}
+	if stats := ns1.Stats(); stats.Values != 9 || stats.ValueBytes != 27 || stats.Tombstones != 1 {
+		t.Fatal(stats)
+	}
+	if stats := ns2.Stats(); stats.Values != 10 || stats.ValueBytes != 40 || stats.Tombstones != 0 {
+		t.Fatal(stats)
+	}
+	// Deleting all of one namespace leaves the other alone.
+	if n, err := ns2.DeleteAll(3000); err != nil || n != 10 {
+		t.Fatal(n, err)
+	}
+	if stats := ns2.Stats(); stats.Values != 0 || stats.Tombstones != 10 {
+		t.Fatal(stats)
+	}
+	if stats := ns1.Stats(); stats.Values != 9 {
+		t.Fatal(stats)
+	}
+}
@@ -0,0 +1,23 @@
+package valuestore
+
+import (
+	"os"
+)
+
+// dropPageCacheName is dropPageCache for the file at name. Bulk passes over
+// files, such as compaction and recovery, call this when done with each so
+// the one-pass reads don't push the working set of regular reads out of the
+// OS page cache.
+func (vs *DefaultValueStore) dropPageCacheName(name string) {
+	fp, err := os.Open(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			vs.logError("error opening %s to drop its cached pages: %s\n", name, err)
+		}
+		return
+	}
+	if err = dropPageCache(fp); err != nil {
+		vs.logError("error dropping cached pages of %s: %s\n", name, err)
+	}
+	fp.Close()
+}
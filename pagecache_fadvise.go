@@ -0,0 +1,22 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package valuestore
+
+import (
+	"os"
+	"syscall"
+)
+
+const _POSIX_FADV_DONTNEED = 4
+
+// dropPageCache advises the OS that the pages it has cached for fp's file
+// won't be wanted again soon, so they can go ahead of those kept warm by
+// regular reads.
+func dropPageCache(fp *os.File) error {
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, fp.Fd(), 0, 0, _POSIX_FADV_DONTNEED, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
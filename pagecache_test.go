@@ -0,0 +1,63 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDropPageCache(t *testing.T) {
+	fp, err := ioutil.TempFile("", "pagecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+	if _, err = fp.Write(make([]byte, 65536)); err != nil {
+		t.Fatal(err)
+	}
+	if err = dropPageCache(fp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDropPageCacheCompactionRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pagecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Path: dir, IgnoreEnv: true, CompactionDropPageCache: true, RecoveryDropPageCache: true}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 1000; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Close()
+	verify := func() {
+		for keyB := uint64(1); keyB <= 1000; keyB++ {
+			if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d", keyB) {
+				t.Fatal(keyB, string(value), err)
+			}
+		}
+	}
+	vs = New(cfg)
+	defer vs.Close()
+	vs.EnableWrites()
+	verify()
+	files := vs.ListFiles()
+	if len(files) != 1 {
+		t.Fatal(files)
+	}
+	if err = vs.CompactFile(files[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	if after := vs.ListFiles(); len(after) != 1 || after[0].ID == files[0].ID {
+		t.Fatal(files, after)
+	}
+	verify()
+}
@@ -0,0 +1,255 @@
+package valuestore
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	_PATHS_PLACEMENT_ROUND_ROBIN = iota
+	_PATHS_PLACEMENT_FREE_SPACE
+)
+
+var pathsPlacements = map[string]int{
+	"round-robin": _PATHS_PLACEMENT_ROUND_ROBIN,
+	"free-space":  _PATHS_PLACEMENT_FREE_SPACE,
+}
+
+// pathsState spreads the values files, with Config.Paths, across several
+// directories, usually each on its own disk, with the TOC file for a values
+// file placed in Paths[i] going to PathsTOC[i]. Each new values file goes to
+// the next of the Paths in turn or, with PathsPlacement "free-space", to the
+// one with the most free space, either way skipping those at their
+// PathsLimits; see pathsLimitState. Which directories a file's values and
+// TOC files are in is remembered, in dirs, for those not in Path and PathTOC,
+// the first of them; recovery finds them all again by listing every PathsTOC
+// and looking for each values file through the Paths.
+type pathsState struct {
+	values    []string
+	toc       []string
+	placement int
+	next      uint32
+	detect    func(p string) uint64
+	lock      sync.RWMutex
+	dirs      map[int64][2]string
+}
+
+func (vs *DefaultValueStore) pathsConfig(cfg *Config) {
+	s := &vs.pathsState
+	s.values = cfg.Paths
+	s.toc = cfg.PathsTOC
+	s.placement = pathsPlacements[cfg.PathsPlacement]
+	s.detect = detectFreeBytes
+	s.dirs = make(map[int64][2]string)
+}
+
+// uniquePaths returns the distinct directories in the lists, in order.
+func uniquePaths(lists ...[]string) []string {
+	var dirs []string
+	seen := make(map[string]struct{})
+	for _, list := range lists {
+		for _, dir := range list {
+			if _, ok := seen[path.Clean(dir)]; ok {
+				continue
+			}
+			seen[path.Clean(dir)] = struct{}{}
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// valuesDirs and tocDirs return the distinct directories values and TOC
+// files may be in.
+func (vs *DefaultValueStore) valuesDirs() []string {
+	if len(vs.pathsState.values) == 0 {
+		return []string{vs.path}
+	}
+	return uniquePaths(vs.pathsState.values)
+}
+
+func (vs *DefaultValueStore) tocDirs() []string {
+	if len(vs.pathsState.toc) == 0 {
+		return []string{vs.pathtoc}
+	}
+	return uniquePaths(vs.pathsState.toc)
+}
+
+// pathsPlace picks the directories for the values and TOC files about to be
+// created with the name namets.
+func (vs *DefaultValueStore) pathsPlace(namets int64) {
+	s := &vs.pathsState
+	if len(s.values) < 2 {
+		vs.pathsLimitPlace(0)
+		return
+	}
+	i := int((atomic.AddUint32(&s.next, 1) - 1) % uint32(len(s.values)))
+	if s.placement == _PATHS_PLACEMENT_FREE_SPACE {
+		var most uint64
+		for j, dir := range s.values {
+			if free := s.detect(dir); free > most {
+				i = j
+				most = free
+			}
+		}
+	}
+	i = vs.pathsLimitPlace(i)
+	vs.pathsSet(namets, s.values[i], s.toc[i])
+}
+
+func (vs *DefaultValueStore) pathsSet(namets int64, valuesDir string, tocDir string) {
+	s := &vs.pathsState
+	s.lock.Lock()
+	if valuesDir == vs.path && tocDir == vs.pathtoc {
+		delete(s.dirs, namets)
+	} else {
+		s.dirs[namets] = [2]string{valuesDir, tocDir}
+	}
+	s.lock.Unlock()
+}
+
+// pathsForget is called once the files named namets have been removed.
+func (vs *DefaultValueStore) pathsForget(namets int64) {
+	s := &vs.pathsState
+	if s.dirs == nil {
+		return
+	}
+	s.lock.Lock()
+	delete(s.dirs, namets)
+	s.lock.Unlock()
+}
+
+// fileDirs returns the directories of the values and TOC files named namets.
+func (vs *DefaultValueStore) fileDirs(namets int64) (string, string) {
+	s := &vs.pathsState
+	if s.dirs != nil {
+		s.lock.RLock()
+		dirs, ok := s.dirs[namets]
+		s.lock.RUnlock()
+		if ok {
+			return dirs[0], dirs[1]
+		}
+	}
+	return vs.path, vs.pathtoc
+}
+
+// valuesName returns the full name of the values file named namets.
+func (vs *DefaultValueStore) valuesName(namets int64) string {
+	dir, _ := vs.fileDirs(namets)
+	return path.Join(dir, fmt.Sprintf("%019d.values", namets))
+}
+
+// tocName returns the full name of the TOC file named namets.
+func (vs *DefaultValueStore) tocName(namets int64) string {
+	_, dir := vs.fileDirs(namets)
+	return path.Join(dir, fmt.Sprintf("%d.valuestoc", namets))
+}
+
+// tocPath returns the full name of the TOC file with the base name given.
+func (vs *DefaultValueStore) tocPath(name string) string {
+	namets, err := strconv.ParseInt(strings.TrimSuffix(name, ".valuestoc"), 10, 64)
+	if err != nil {
+		return path.Join(vs.pathtoc, name)
+	}
+	_, dir := vs.fileDirs(namets)
+	return path.Join(dir, name)
+}
+
+// tocNames returns the full names of the TOC files in all the PathsTOC, in
+// the order of their base names.
+func (vs *DefaultValueStore) tocNames() ([]string, error) {
+	var names []string
+	for _, dir := range vs.tocDirs() {
+		fp, err := os.Open(dir)
+		if err != nil {
+			return nil, err
+		}
+		all, err := fp.Readdirnames(-1)
+		fp.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range all {
+			if strings.HasSuffix(name, ".valuestoc") {
+				names = append(names, path.Join(dir, name))
+			}
+		}
+	}
+	sort.Slice(names, func(i int, j int) bool {
+		return path.Base(names[i]) < path.Base(names[j])
+	})
+	return names, nil
+}
+
+// pathsRecover returns the base names of the TOC files in all the PathsTOC,
+// sorted, noting where each is and where its values file is. A values file
+// is looked for first in the Paths entry paired with where its TOC file is,
+// then in the rest; one not found anywhere is taken to be in Path, for
+// recovery to report.
+func (vs *DefaultValueStore) pathsRecover() ([]string, error) {
+	s := &vs.pathsState
+	var names []string
+	tocDirs := vs.tocDirs()
+	valuesDirs := vs.valuesDirs()
+	found := make(map[string]string)
+	for _, dir := range tocDirs {
+		fp, err := os.Open(dir)
+		if err == nil {
+			var all []string
+			all, err = fp.Readdirnames(-1)
+			fp.Close()
+			for _, name := range all {
+				if !strings.HasSuffix(name, ".valuestoc") {
+					continue
+				}
+				if other, ok := found[name]; ok {
+					vs.logError("%s is in both %s and %s; ignoring the latter\n", name, other, dir)
+					continue
+				}
+				found[name] = dir
+				names = append(names, name)
+			}
+		}
+		if err != nil {
+			return nil, &RecoveryError{Path: dir, Err: err}
+		}
+	}
+	sort.Strings(names)
+	if len(tocDirs) == 1 && len(valuesDirs) == 1 {
+		return names, nil
+	}
+	for _, name := range names {
+		namets, err := strconv.ParseInt(strings.TrimSuffix(name, ".valuestoc"), 10, 64)
+		if err != nil {
+			continue
+		}
+		tocDir := found[name]
+		valuesDir := vs.path
+		try := valuesDirs
+		for i := range s.toc {
+			if s.toc[i] == tocDir {
+				try = append([]string{s.values[i]}, valuesDirs...)
+				break
+			}
+		}
+		for _, dir := range try {
+			if len(valuesDirs) == 1 {
+				valuesDir = dir
+				break
+			}
+			if fp, err := vs.fileBackend.OpenRead(path.Join(dir, fmt.Sprintf("%019d.values", namets)), 0); err == nil {
+				fp.Close()
+				valuesDir = dir
+				break
+			}
+		}
+		vs.pathsSet(namets, valuesDir, tocDir)
+	}
+	return names, nil
+}
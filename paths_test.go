@@ -0,0 +1,168 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := path.Join(dir, "a")
+	b := path.Join(dir, "b")
+	toc := path.Join(dir, "toc")
+	cfg := &Config{Path: dir, IgnoreEnv: true, Paths: []string{a, b}, PathsTOC: []string{toc, toc}}
+	written := uint64(0)
+	// write adds 100 keys in a values file of their own.
+	write := func(vs *DefaultValueStore) {
+		for i := 0; i < 100; i++ {
+			written++
+			if _, err := vs.Write(1, written, 1000, []byte(fmt.Sprintf("value %d", written))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		vs.Flush()
+	}
+	count := func(dir string, suffix string) int {
+		names, err := filepath.Glob(path.Join(dir, "*"+suffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(names)
+	}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for i := 0; i < 4; i++ {
+		write(vs)
+	}
+	if count(a, ".values") != 2 || count(b, ".values") != 2 || count(toc, ".valuestoc") != 4 {
+		t.Fatal(count(a, ".values"), count(b, ".values"), count(toc, ".valuestoc"))
+	}
+	vs.pathsState.placement = _PATHS_PLACEMENT_FREE_SPACE
+	vs.pathsState.detect = func(p string) uint64 {
+		if p == b {
+			return 2000
+		}
+		return 1000
+	}
+	write(vs)
+	write(vs)
+	if count(a, ".values") != 2 || count(b, ".values") != 4 {
+		t.Fatal(count(a, ".values"), count(b, ".values"))
+	}
+	vs.Close()
+	// Recovery finds the values files wherever they are, even with the Paths
+	// in another order.
+	cfg.Paths = []string{b, a}
+	vs = New(cfg)
+	for keyB := uint64(1); keyB <= written; keyB++ {
+		if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d", keyB) {
+			vs.Close()
+			t.Fatal(keyB, string(value), err)
+		}
+	}
+	stats := vs.Stats(false).(*Stats)
+	vs.Close()
+	if stats.RecoveryFiles != 6 {
+		t.Fatal(stats.RecoveryFiles)
+	}
+	if errs := Preflight(cfg); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	report, err := VerifyFiles(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 6 || len(report.OrphanValuesFiles) != 0 {
+		t.Fatal(len(report.Files), report.OrphanValuesFiles)
+	}
+	for _, f := range report.Files {
+		if f.Problems() != 0 {
+			t.Fatalf("%#v", f)
+		}
+	}
+}
+
+func TestPathsLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := path.Join(dir, "a")
+	b := path.Join(dir, "b")
+	cfg := &Config{Path: dir, IgnoreEnv: true, Paths: []string{a, b}, PathsLimits: []int{1, 0}, ValueCap: 1024, ValuesFileCap: 8192}
+	written := uint64(0)
+	// write adds 100 keys in a values file of their own.
+	write := func(vs *DefaultValueStore) error {
+		for i := 0; i < 100; i++ {
+			written++
+			if _, err := vs.Write(1, written, 1000, []byte(fmt.Sprintf("value %d", written))); err != nil {
+				return err
+			}
+		}
+		vs.Flush()
+		return nil
+	}
+	count := func(dir string) int {
+		names, err := filepath.Glob(path.Join(dir, "*.values"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(names)
+	}
+	vs := New(cfg)
+	vs.EnableWrites()
+	// a has no room for a values file, so they all go to b.
+	for i := 0; i < 3; i++ {
+		if err = write(vs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count(a) != 0 || count(b) != 3 {
+		t.Fatal(count(a), count(b))
+	}
+	// With b's limit lowered, the rebalancer moves its files to a.
+	vs.pathsLimitState.limits = []int64{1 << 30, 1}
+	vs.pathsRebalancePass()
+	if count(b) != 0 || count(a) == 0 {
+		t.Fatal(count(a), count(b))
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.PathsRebalanceFiles != 3 || stats.PathsRebalanceBytes == 0 || stats.PathsRebalancePending != 0 {
+		t.Fatal(stats.PathsRebalanceFiles, stats.PathsRebalanceBytes, stats.PathsRebalancePending)
+	}
+	// With both at their limits, writes are refused until one has room.
+	vs.pathsLimitState.limits = []int64{1, 1}
+	vs.pathsLimitUpdate()
+	if _, err = vs.Write(1, written+1, 1000, []byte("refused")); err != ErrDiskFull {
+		t.Fatal(err)
+	}
+	vs.pathsLimitState.limits = []int64{1, 1 << 30}
+	vs.pathsLimitUpdate()
+	if err = write(vs); err != nil {
+		t.Fatal(err)
+	}
+	if stats = vs.Stats(false).(*Stats); stats.DiskFulls != 1 {
+		t.Fatal(stats.DiskFulls)
+	}
+	vs.Close()
+	vs = New(cfg)
+	defer vs.Close()
+	for keyB := uint64(1); keyB <= written; keyB++ {
+		if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d", keyB) {
+			t.Fatal(keyB, string(value), err)
+		}
+	}
+	cfg.PathsLimits = []int{1}
+	if limits := resolveConfig(cfg).PathsLimits; limits != nil {
+		t.Fatal(limits)
+	}
+}
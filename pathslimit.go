@@ -0,0 +1,209 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// pathsLimitState keeps the values files in each of the Paths within its
+// Config.PathsLimits entry. A new values file is only placed in a path with
+// room left for a whole ValuesFileCap; if none has, it goes to the one with
+// the most room and writes are refused, as with diskFreeState, until a path
+// has room again. used holds the bytes of values files in each path, as of
+// the last rebalancer pass plus ValuesFileCap for each file placed since.
+//
+// The rebalancer, every PathsRebalanceInterval, moves values files off the
+// paths over their limits, oldest first, by compacting them: their live
+// entries are rewritten into the values files being written, which the
+// placement keeps on paths with room, so it waits for those to be on such
+// paths. The compactions are throttled as compaction's are, by
+// CompactionMaxBytesPerSec and BackgroundCPUPercent.
+type pathsLimitState struct {
+	limits   []int64
+	used     []int64
+	interval time.Duration
+	// full is set while writes are being refused.
+	full int32
+}
+
+func (vs *DefaultValueStore) pathsLimitConfig(cfg *Config) {
+	s := &vs.pathsLimitState
+	s.interval = time.Duration(cfg.PathsRebalanceInterval) * time.Second
+	for _, limit := range cfg.PathsLimits {
+		if limit > 0 {
+			s.limits = make([]int64, len(cfg.PathsLimits))
+			for i, limit := range cfg.PathsLimits {
+				s.limits[i] = int64(limit)
+			}
+			s.used = make([]int64, len(cfg.PathsLimits))
+			break
+		}
+	}
+}
+
+func (vs *DefaultValueStore) pathsLimitLaunch() {
+	if vs.pathsLimitState.limits == nil {
+		return
+	}
+	vs.pathsLimitUpdate()
+	vs.closeState.backgroundWG.Add(1)
+	go vs.pathsRebalancer()
+}
+
+func (vs *DefaultValueStore) pathsRebalancer() {
+	for vs.closeSleep(vs.pathsLimitState.interval) {
+		vs.pathsRebalancePass()
+	}
+	vs.closeState.backgroundWG.Done()
+}
+
+// pathsLimitRoom returns how many bytes the ith of the Paths, which has a
+// limit, has left under it, negative if over it.
+func (vs *DefaultValueStore) pathsLimitRoom(i int) int64 {
+	s := &vs.pathsLimitState
+	return s.limits[i] - atomic.LoadInt64(&s.used[i])
+}
+
+// pathsLimitPlace is called by pathsPlace with the Paths index it picked and
+// returns the one to use instead: the same if it has no limit or room for a
+// whole values file, else the next such, else the one with the most room,
+// in which case writes are refused from now on.
+func (vs *DefaultValueStore) pathsLimitPlace(i int) int {
+	s := &vs.pathsLimitState
+	if s.limits == nil {
+		return i
+	}
+	fileCap := int64(vs.valuesFileCap)
+	best := -1
+	for j := range s.limits {
+		k := (i + j) % len(s.limits)
+		if s.limits[k] <= 0 {
+			return k
+		}
+		if vs.pathsLimitRoom(k) >= fileCap {
+			best = k
+			break
+		}
+		if best < 0 || vs.pathsLimitRoom(k) > vs.pathsLimitRoom(best) {
+			best = k
+		}
+	}
+	if atomic.AddInt64(&s.used[best], fileCap) > s.limits[best] {
+		vs.pathsLimitFull(vs.pathsState.values[best])
+	}
+	return best
+}
+
+func (vs *DefaultValueStore) pathsLimitFull(dir string) {
+	if atomic.CompareAndSwapInt32(&vs.pathsLimitState.full, 0, 1) {
+		atomic.AddInt32(&vs.diskFulls, 1)
+		vs.logError("%s is at its limit, as are the other paths; refusing writes\n", dir)
+	}
+}
+
+// pathsLimitUpdate recounts the bytes of values files in each of the Paths
+// and returns them; writes are refused if no path has room for a whole
+// values file, and accepted again once one has.
+func (vs *DefaultValueStore) pathsLimitUpdate() []int64 {
+	s := &vs.pathsLimitState
+	used := make([]int64, len(s.limits))
+	sums := make(map[string]int64)
+	for i, dir := range vs.pathsState.values {
+		sum, ok := sums[path.Clean(dir)]
+		if !ok {
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil {
+				vs.logError("error reading %s: %s\n", dir, err)
+				return nil
+			}
+			for _, fi := range fis {
+				if strings.HasSuffix(fi.Name(), ".values") {
+					sum += fi.Size()
+				}
+			}
+			sums[path.Clean(dir)] = sum
+		}
+		used[i] = sum
+		atomic.StoreInt64(&s.used[i], sum)
+	}
+	most := 0
+	for i := range s.limits {
+		if s.limits[i] <= 0 || vs.pathsLimitRoom(i) >= int64(vs.valuesFileCap) {
+			if atomic.CompareAndSwapInt32(&s.full, 1, 0) {
+				vs.logInfo("%s has room; accepting writes again\n", vs.pathsState.values[i])
+			}
+			return used
+		}
+		if vs.pathsLimitRoom(i) > vs.pathsLimitRoom(most) {
+			most = i
+		}
+	}
+	vs.pathsLimitFull(vs.pathsState.values[most])
+	return used
+}
+
+// pathsRebalancePass compacts the oldest values files off each of the Paths
+// over its limit until it no longer is; see pathsLimitState.
+func (vs *DefaultValueStore) pathsRebalancePass() {
+	s := &vs.pathsLimitState
+	used := vs.pathsLimitUpdate()
+	if used == nil {
+		return
+	}
+	index := make(map[string]int, len(used))
+	for i := len(used) - 1; i >= 0; i-- {
+		index[path.Clean(vs.pathsState.values[i])] = i
+	}
+	over := func(dir string) bool {
+		i, ok := index[path.Clean(dir)]
+		return ok && s.limits[i] > 0 && used[i] > s.limits[i]
+	}
+	pending := func() int64 {
+		var pending int64
+		for i := range used {
+			if s.limits[i] > 0 && used[i] > s.limits[i] {
+				pending += used[i] - s.limits[i]
+			}
+		}
+		atomic.StoreInt64(&vs.pathsRebalancePending, pending)
+		return pending
+	}
+	if pending() == 0 {
+		return
+	}
+	for _, active := range []*uint64{&vs.activeTOCA, &vs.activeTOCB} {
+		if namets := int64(atomic.LoadUint64(active)); namets != 0 {
+			if dir, _ := vs.fileDirs(namets); over(dir) {
+				return
+			}
+		}
+	}
+	for _, f := range vs.ListFiles() {
+		dir, _ := vs.fileDirs(f.ID)
+		if !over(dir) {
+			continue
+		}
+		switch err := vs.CompactFile(f.ID); err {
+		case nil:
+		case ErrFileInUse:
+			continue
+		case ErrClosed:
+			return
+		default:
+			vs.logError("error moving %s off %s: %s\n", f.Name, dir, err)
+			continue
+		}
+		atomic.AddInt32(&vs.pathsRebalanceFiles, 1)
+		atomic.AddInt64(&vs.pathsRebalanceBytes, f.Bytes)
+		used[index[path.Clean(dir)]] -= f.Bytes
+		if pending() == 0 {
+			break
+		}
+	}
+	if used = vs.pathsLimitUpdate(); used != nil {
+		pending()
+	}
+}
@@ -0,0 +1,177 @@
+package valuestore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+// peerFlowState tracks how many outgoing replication messages are currently
+// outstanding to each peer node. A message is outstanding from the time it is
+// handed to the MsgRing until the MsgRing calls its Free method, which
+// happens once it has been sent or has timed out. Capping this per node means
+// a slow peer can only tie up a bounded window of the outgoing message pools,
+// leaving the rest for replication to healthy peers.
+//
+// A bulk-set message that asks to be acked stays outstanding past its Free,
+// until each node it went to acks it, as a peer that is slow to store what it
+// is sent should slow the sending just as a slow link does. As acks may be
+// lost, or never sent by a peer with its own window full, the wait is bounded.
+type peerFlowState struct {
+	window      int
+	lock        sync.Mutex
+	outstanding map[uint64]int
+	// acking has, by msgID, the nodes yet to ack a bulk-set message.
+	acking map[uint64][]uint64
+}
+
+// peerFlowMsg is a ring.Msg that records which nodes it has been charged
+// against so the charge can be released when the message is freed.
+type peerFlowMsg interface {
+	ring.Msg
+	peerFlowNodeIDs() *[]uint64
+}
+
+func (vs *DefaultValueStore) peerFlowConfig(cfg *Config) {
+	vs.peerFlowState.window = cfg.OutPeerMsgWindow
+	vs.peerFlowState.outstanding = make(map[uint64]int)
+	vs.peerFlowState.acking = make(map[uint64][]uint64)
+}
+
+// peerFlowCharge counts a message against each of the nodeIDs, but only if
+// none of them has already reached the window; returns false (with nothing
+// charged) otherwise.
+func (vs *DefaultValueStore) peerFlowCharge(nodeIDs []uint64) bool {
+	vs.peerFlowState.lock.Lock()
+	for _, nodeID := range nodeIDs {
+		if vs.peerFlowState.outstanding[nodeID] >= vs.peerFlowState.window {
+			vs.peerFlowState.lock.Unlock()
+			return false
+		}
+	}
+	for _, nodeID := range nodeIDs {
+		vs.peerFlowState.outstanding[nodeID]++
+	}
+	vs.peerFlowState.lock.Unlock()
+	return true
+}
+
+// peerFlowRelease undoes a previous peerFlowCharge for the nodeIDs.
+func (vs *DefaultValueStore) peerFlowRelease(nodeIDs []uint64) {
+	if len(nodeIDs) == 0 {
+		return
+	}
+	vs.peerFlowState.lock.Lock()
+	for _, nodeID := range nodeIDs {
+		if c := vs.peerFlowState.outstanding[nodeID]; c > 1 {
+			vs.peerFlowState.outstanding[nodeID] = c - 1
+		} else {
+			delete(vs.peerFlowState.outstanding, nodeID)
+		}
+	}
+	vs.peerFlowState.lock.Unlock()
+}
+
+// peerFlowFree is called from the Free methods of peerFlowMsg
+// implementations before they requeue themselves.
+func (vs *DefaultValueStore) peerFlowFree(msg peerFlowMsg) {
+	nodeIDs := msg.peerFlowNodeIDs()
+	vs.peerFlowRelease(*nodeIDs)
+	*nodeIDs = (*nodeIDs)[:0]
+}
+
+// peerFlowAwaitAck is peerFlowFree for a message sent as msgID that asked to
+// be acked: the charge against each of its nodes is kept until peerFlowAcked
+// for that node or until timeout has passed.
+func (vs *DefaultValueStore) peerFlowAwaitAck(msg peerFlowMsg, msgID uint64, timeout time.Duration) {
+	nodeIDs := msg.peerFlowNodeIDs()
+	if len(*nodeIDs) == 0 {
+		return
+	}
+	s := &vs.peerFlowState
+	s.lock.Lock()
+	s.acking[msgID] = append([]uint64(nil), *nodeIDs...)
+	s.lock.Unlock()
+	*nodeIDs = (*nodeIDs)[:0]
+	time.AfterFunc(timeout, func() {
+		s.lock.Lock()
+		nodeIDs := s.acking[msgID]
+		delete(s.acking, msgID)
+		s.lock.Unlock()
+		if len(nodeIDs) > 0 {
+			atomic.AddInt32(&vs.outPeerMsgAckTimeouts, int32(len(nodeIDs)))
+			vs.peerFlowRelease(nodeIDs)
+		}
+	})
+}
+
+// peerFlowAcked releases the charge against nodeID for the message sent as
+// msgID, if it is still awaiting that node's ack.
+func (vs *DefaultValueStore) peerFlowAcked(msgID uint64, nodeID uint64) {
+	s := &vs.peerFlowState
+	s.lock.Lock()
+	nodeIDs := s.acking[msgID]
+	acked := false
+	for i, id := range nodeIDs {
+		if id == nodeID {
+			nodeIDs = append(nodeIDs[:i], nodeIDs[i+1:]...)
+			acked = true
+			break
+		}
+	}
+	if len(nodeIDs) == 0 {
+		delete(s.acking, msgID)
+	} else {
+		s.acking[msgID] = nodeIDs
+	}
+	s.lock.Unlock()
+	if acked {
+		vs.peerFlowRelease([]uint64{nodeID})
+	}
+}
+
+// msgToNode sends the msg to the nodeID using the MsgRing unless that node
+// already has too many messages outstanding, in which case the msg is freed
+// without being sent and false is returned. Replication is stateless, so
+// skipped data will simply be sent on a later pass.
+func (vs *DefaultValueStore) msgToNode(msg peerFlowMsg, nodeID uint64, timeout time.Duration) bool {
+	nodeIDs := msg.peerFlowNodeIDs()
+	*nodeIDs = append((*nodeIDs)[:0], nodeID)
+	if !vs.peerFlowCharge(*nodeIDs) {
+		*nodeIDs = (*nodeIDs)[:0]
+		atomic.AddInt32(&vs.outPeerMsgSkips, 1)
+		msg.Free()
+		return false
+	}
+	vs.msgRing.MsgToNode(msg, nodeID, timeout)
+	return true
+}
+
+// msgToOtherReplicas is the MsgToOtherReplicas counterpart of msgToNode. The
+// msg is only sent if every other node responsible for the partition has room
+// in its window.
+func (vs *DefaultValueStore) msgToOtherReplicas(msg peerFlowMsg, partition uint32, timeout time.Duration) bool {
+	nodeIDs := msg.peerFlowNodeIDs()
+	*nodeIDs = (*nodeIDs)[:0]
+	if r := vs.msgRing.Ring(); r != nil {
+		var localID uint64
+		if n := r.LocalNode(); n != nil {
+			localID = n.ID()
+		}
+		for _, n := range r.ResponsibleNodes(partition) {
+			if n.ID() != localID {
+				*nodeIDs = append(*nodeIDs, n.ID())
+			}
+		}
+	}
+	if !vs.peerFlowCharge(*nodeIDs) {
+		*nodeIDs = (*nodeIDs)[:0]
+		atomic.AddInt32(&vs.outPeerMsgSkips, 1)
+		msg.Free()
+		return false
+	}
+	vs.msgRing.MsgToOtherReplicas(msg, partition, timeout)
+	return true
+}
@@ -0,0 +1,131 @@
+package valuestore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+type msgRingHolder struct {
+	ring ring.Ring
+	lock sync.Mutex
+	held []ring.Msg
+}
+
+func (m *msgRingHolder) Ring() ring.Ring {
+	return m.ring
+}
+
+func (m *msgRingHolder) MaxMsgLength() uint64 {
+	return 65536
+}
+
+func (m *msgRingHolder) SetMsgHandler(msgType uint64, handler ring.MsgUnmarshaller) {
+}
+
+func (m *msgRingHolder) MsgToNode(msg ring.Msg, nodeID uint64, timeout time.Duration) {
+	m.lock.Lock()
+	m.held = append(m.held, msg)
+	m.lock.Unlock()
+}
+
+func (m *msgRingHolder) MsgToOtherReplicas(msg ring.Msg, partition uint32, timeout time.Duration) {
+	m.lock.Lock()
+	m.held = append(m.held, msg)
+	m.lock.Unlock()
+}
+
+func TestPeerFlowChargeRelease(t *testing.T) {
+	vs := newTestStore(t, &Config{OutPeerMsgWindow: 1})
+	if !vs.peerFlowCharge([]uint64{1, 2}) {
+		t.Fatal("")
+	}
+	if vs.peerFlowCharge([]uint64{2}) {
+		t.Fatal("")
+	}
+	// A failed charge must not partially charge the nodes that had room.
+	if vs.peerFlowCharge([]uint64{3, 2}) {
+		t.Fatal("")
+	}
+	if !vs.peerFlowCharge([]uint64{3}) {
+		t.Fatal("")
+	}
+	vs.peerFlowRelease([]uint64{1, 2})
+	if !vs.peerFlowCharge([]uint64{2}) {
+		t.Fatal("")
+	}
+	if len(vs.peerFlowState.outstanding) != 2 {
+		t.Fatal(vs.peerFlowState.outstanding)
+	}
+}
+
+func TestPeerFlowMsgToNode(t *testing.T) {
+	m := &msgRingHolder{}
+	vs := newTestStore(t, &Config{MsgRing: m, OutPeerMsgWindow: 2, OutBulkSetMsgs: 4})
+	// Without acks asked for, only the sending counts against the window.
+	newMsg := func() *bulkSetMsg {
+		bsm := vs.newOutBulkSetMsg()
+		bsm.setAckPolicy(BULK_SET_ACK_NONE)
+		return bsm
+	}
+	for i := 0; i < 2; i++ {
+		if !vs.msgToNode(newMsg(), 123, time.Second) {
+			t.Fatal(i)
+		}
+	}
+	if vs.msgToNode(newMsg(), 123, time.Second) {
+		t.Fatal("")
+	}
+	// Other peers are unaffected by the slow one.
+	if !vs.msgToNode(newMsg(), 456, time.Second) {
+		t.Fatal("")
+	}
+	if stats := vs.Stats(false).(*Stats); stats.OutPeerMsgSkips != 1 {
+		t.Fatal(stats.OutPeerMsgSkips)
+	}
+	m.lock.Lock()
+	m.held[0].Free()
+	m.lock.Unlock()
+	if !vs.msgToNode(newMsg(), 123, time.Second) {
+		t.Fatal("")
+	}
+}
+
+func TestPeerFlowAwaitAck(t *testing.T) {
+	m := &msgRingHolder{}
+	vs := newTestStore(t, &Config{MsgRing: m, OutPeerMsgWindow: 1, OutBulkSetMsgs: 4, InBulkSetResponseMsgTimeout: 50})
+	bsm := vs.newOutBulkSetMsg()
+	msgID := bsm.msgID()
+	if !vs.msgToNode(bsm, 123, time.Second) {
+		t.Fatal("")
+	}
+	// Sent but not yet acked, the message still holds the window.
+	bsm.Free()
+	if vs.msgToNode(vs.newOutBulkSetMsg(), 123, time.Second) {
+		t.Fatal("")
+	}
+	// An ack from another node, or for another message, frees nothing.
+	vs.peerFlowAcked(msgID, 456)
+	vs.peerFlowAcked(msgID+100, 123)
+	if vs.msgToNode(vs.newOutBulkSetMsg(), 123, time.Second) {
+		t.Fatal("")
+	}
+	vs.peerFlowAcked(msgID, 123)
+	bsm = vs.newOutBulkSetMsg()
+	if !vs.msgToNode(bsm, 123, time.Second) {
+		t.Fatal("")
+	}
+	// Never acked, the slot is freed after the timeout.
+	bsm.Free()
+	for i := 0; vs.Stats(false).(*Stats).OutPeerMsgAckTimeouts == 0; i++ {
+		if i == 200 {
+			t.Fatal("no ack timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !vs.msgToNode(vs.newOutBulkSetMsg(), 123, time.Second) {
+		t.Fatal("")
+	}
+}
@@ -0,0 +1,206 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The checks a PreflightError may be from.
+const (
+	// PREFLIGHT_PATH is Path or PathTOC not existing, not being a directory,
+	// or not being writable.
+	PREFLIGHT_PATH = "path"
+	// PREFLIGHT_FILE_SETS is a TOC file without its values file, or a values
+	// file without its TOC file; such as when PathTOC points at the wrong
+	// directory.
+	PREFLIGHT_FILE_SETS = "file sets"
+	// PREFLIGHT_DISK_SPACE is less free space than a full values file needs.
+	PREFLIGHT_DISK_SPACE = "disk space"
+	// PREFLIGHT_FILE_LIMIT is an open file limit too low for the
+	// ReaderBudget plus the files being written.
+	PREFLIGHT_FILE_LIMIT = "file limit"
+	// PREFLIGHT_ENCRYPTION_KEY is a values or TOC file encrypted with a key
+	// ID that neither Config.EncryptionKey nor Config.EncryptionKeys has.
+	PREFLIGHT_ENCRYPTION_KEY = "encryption key"
+)
+
+// PreflightError is a deployment problem found by Preflight before any data
+// is touched.
+type PreflightError struct {
+	// Check is one of the PREFLIGHT_ constants.
+	Check string
+	// Path is the file or directory the problem is with, if any.
+	Path string
+	// Err is the underlying error, if any.
+	Err error
+	// Detail describes the problem when there is no Err.
+	Detail string
+}
+
+func (e *PreflightError) Error() string {
+	s := "preflight " + e.Check
+	if e.Path != "" {
+		s += " " + e.Path
+	}
+	if e.Err != nil {
+		return s + ": " + e.Err.Error()
+	}
+	return s + ": " + e.Detail
+}
+
+// _PREFLIGHT_SPARE_FILES is how many open files are allowed for beyond the
+// ReaderBudget: the values and TOC files being written, those being
+// compacted, and the process' own.
+const _PREFLIGHT_SPARE_FILES = 64
+
+// Preflight checks the deployment the Config describes: that the Paths and
+// PathsTOC are writable directories, creating them if need be; that their
+// values and TOC files match up; that each of the Paths has room for a full
+// values file; that every encrypted file's key is configured; and that the
+// open file limit allows for the ReaderBudget. NewWithContext runs these checks before
+// recovery, returning any path or encryption key problem and, with
+// Config.PreflightStrict, any other problem as the error; otherwise the other
+// problems are logged as warnings.
+func Preflight(c *Config) []*PreflightError {
+	return preflight(resolveConfig(c))
+}
+
+func preflight(cfg *Config) []*PreflightError {
+	var errs []*PreflightError
+	for _, p := range uniquePaths(cfg.Paths, cfg.PathsTOC) {
+		if err := checkPath(p); err != nil {
+			errs = append(errs, &PreflightError{Check: PREFLIGHT_PATH, Path: p, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	errs = append(errs, preflightFileSets(cfg)...)
+	errs = append(errs, preflightEncryptionKeys(cfg)...)
+	for _, p := range uniquePaths(cfg.Paths) {
+		if free := detectFreeBytes(p); free > 0 && free < uint64(cfg.ValuesFileCap) {
+			errs = append(errs, &PreflightError{Check: PREFLIGHT_DISK_SPACE, Path: p, Detail: fmt.Sprintf("%d bytes free but a values file may reach %d bytes", free, cfg.ValuesFileCap)})
+		}
+	}
+	if limit := detectFileLimit(); limit > 0 && limit < uint64(cfg.ReaderBudget+_PREFLIGHT_SPARE_FILES) {
+		errs = append(errs, &PreflightError{Check: PREFLIGHT_FILE_LIMIT, Detail: fmt.Sprintf("open file limit %d is below the reader budget %d plus %d", limit, cfg.ReaderBudget, _PREFLIGHT_SPARE_FILES)})
+	}
+	return errs
+}
+
+// preflightFileSets returns an error for each TOC file without a values file
+// and each values file without a TOC file.
+func preflightFileSets(cfg *Config) []*PreflightError {
+	// names returns the full names in the dirs with the suffix by their
+	// timestamps; the values files' names are zero padded but the TOC files'
+	// aren't.
+	names := func(dirs []string, suffix string) (map[string]string, *PreflightError) {
+		m := map[string]string{}
+		for _, dir := range dirs {
+			fp, err := os.Open(dir)
+			if err != nil {
+				return nil, &PreflightError{Check: PREFLIGHT_PATH, Path: dir, Err: err}
+			}
+			all, err := fp.Readdirnames(-1)
+			fp.Close()
+			if err != nil {
+				return nil, &PreflightError{Check: PREFLIGHT_PATH, Path: dir, Err: err}
+			}
+			for _, name := range all {
+				if strings.HasSuffix(name, suffix) {
+					m[strings.TrimLeft(strings.TrimSuffix(name, suffix), "0")] = path.Join(dir, name)
+				}
+			}
+		}
+		return m, nil
+	}
+	valuesDirs := uniquePaths(cfg.Paths)
+	tocDirs := uniquePaths(cfg.PathsTOC)
+	tocs, perr := names(tocDirs, ".valuestoc")
+	if perr != nil {
+		return []*PreflightError{perr}
+	}
+	values, perr := names(valuesDirs, ".values")
+	if perr != nil {
+		return []*PreflightError{perr}
+	}
+	var errs []*PreflightError
+	for ts, name := range tocs {
+		if _, ok := values[ts]; !ok {
+			errs = append(errs, &PreflightError{Check: PREFLIGHT_FILE_SETS, Path: name, Detail: "no matching values file in " + strings.Join(valuesDirs, ", ")})
+		}
+	}
+	for ts, name := range values {
+		if _, ok := tocs[ts]; !ok {
+			errs = append(errs, &PreflightError{Check: PREFLIGHT_FILE_SETS, Path: name, Detail: "no matching TOC file in " + strings.Join(tocDirs, ", ")})
+		}
+	}
+	sort.Slice(errs, func(i int, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+	return errs
+}
+
+// preflightEncryptionKeys returns an error for each values or TOC file
+// encrypted with a key the Config doesn't have.
+func preflightEncryptionKeys(cfg *Config) []*PreflightError {
+	var errs []*PreflightError
+	for _, set := range []struct {
+		paths  []string
+		suffix string
+	}{{uniquePaths(cfg.Paths), ".values"}, {uniquePaths(cfg.PathsTOC), ".valuestoc"}} {
+		for _, p := range set.paths {
+			dir := struct {
+				path   string
+				suffix string
+			}{p, set.suffix}
+			fp, err := os.Open(dir.path)
+			if err != nil {
+				continue
+			}
+			names, err := fp.Readdirnames(-1)
+			fp.Close()
+			if err != nil {
+				continue
+			}
+			for _, name := range names {
+				if !strings.HasSuffix(name, dir.suffix) {
+					continue
+				}
+				keyID, err := encryptionKeyID(path.Join(dir.path, name))
+				if err != nil || keyID == 0 {
+					continue
+				}
+				if _, ok := cfg.EncryptionKeys[keyID]; ok {
+					continue
+				}
+				if len(cfg.EncryptionKey) > 0 && keyID == cfg.EncryptionKeyID {
+					continue
+				}
+				errs = append(errs, &PreflightError{Check: PREFLIGHT_ENCRYPTION_KEY, Path: path.Join(dir.path, name), Detail: fmt.Sprintf("no key for encryption key ID %d", keyID)})
+			}
+		}
+	}
+	sort.Slice(errs, func(i int, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+	return errs
+}
+
+// checkPath ensures the directory p exists and that files can be created
+// within it.
+func checkPath(p string) error {
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return err
+	}
+	fp, err := ioutil.TempFile(p, ".valuestore")
+	if err != nil {
+		return err
+	}
+	fp.Close()
+	return os.Remove(fp.Name())
+}
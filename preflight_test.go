@@ -0,0 +1,85 @@
+package valuestore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreflightFileSets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tocDir, err := ioutil.TempDir("", "valuestoretoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tocDir)
+	cfg := Config{Path: dir, PathTOC: tocDir, IgnoreEnv: true, ValueCap: 4096, PageSize: 4096, ValuesFileCap: 48 + 4096}
+	vs := New(&cfg)
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 100; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Close()
+	for _, perr := range Preflight(&cfg) {
+		if perr.Check == PREFLIGHT_FILE_SETS {
+			t.Fatal(perr)
+		}
+	}
+	// Pointing PathTOC elsewhere leaves every values file unmatched.
+	emptyDir, err := ioutil.TempDir("", "valuestoretoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(emptyDir)
+	cfg.PathTOC = emptyDir
+	values, err := filepath.Glob(path.Join(dir, "*.values"))
+	if err != nil || len(values) < 2 {
+		t.Fatal(values, err)
+	}
+	var found []string
+	for _, perr := range Preflight(&cfg) {
+		if perr.Check == PREFLIGHT_FILE_SETS {
+			found = append(found, perr.Path)
+		}
+	}
+	if len(found) != len(values) || found[0] != values[0] {
+		t.Fatal(found, values)
+	}
+	// Just warnings by default, but with PreflightStrict the store fails to
+	// start.
+	if vs, err = NewWithContext(context.Background(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	vs.Close()
+	cfg.PreflightStrict = true
+	if _, err = NewWithContext(context.Background(), &cfg); err == nil {
+		t.Fatal("no error")
+	} else if perr, ok := err.(*PreflightError); !ok || perr.Check != PREFLIGHT_FILE_SETS {
+		t.Fatal(err)
+	}
+}
+
+func TestPreflightPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "file")
+	if err = ioutil.WriteFile(p, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewWithContext(context.Background(), &Config{Path: dir, PathTOC: p})
+	if perr, ok := err.(*PreflightError); !ok || perr.Check != PREFLIGHT_PATH || perr.Path != p || perr.Err == nil {
+		t.Fatal(err)
+	}
+}
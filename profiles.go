@@ -0,0 +1,75 @@
+package valuestore
+
+// The Profile funcs return a Config filled out with a coherent set of tuning
+// values for a common situation. The values left zero will still get their
+// usual defaults, and any value can be changed before passing the Config to
+// New; for example:
+//
+//  cfg := ProfileHDD()
+//  cfg.Path = "/mnt/disk1"
+//  cfg.PathTOC = "/mnt/ssd1"
+//  vs := New(cfg)
+
+// ProfileSSD returns a Config suited to storing values and valuestoc files
+// on solid state drives, which cope well with many concurrent readers. This
+// is close to the defaults.
+func ProfileSSD() *Config {
+	return &Config{
+		WritePagesPerWorker:      3,
+		OutPullReplicationBloomN: 1000000,
+		OutPullReplicationBloomP: 0.001,
+	}
+}
+
+// ProfileHDD returns a Config suited to spinning drives, where seeks are
+// expensive. Writes are batched into larger pages, reads are limited to a few
+// descriptors per values file, and background passes run fewer workers less
+// often. Keeping PathTOC on a separate drive from Path is still recommended.
+func ProfileHDD() *Config {
+	return &Config{
+		PageSize:                  16 * 1024 * 1024,
+		WritePagesPerWorker:       4,
+		ValuesFileReaders:         2,
+		BackgroundInterval:        120,
+		CompactionWorkers:         1,
+		OutPullReplicationWorkers: 1,
+		OutPushReplicationWorkers: 1,
+		OutPullReplicationBloomN:  1000000,
+		OutPullReplicationBloomP:  0.001,
+	}
+}
+
+// ProfileLowMemory returns a Config that keeps buffers, message pools, and
+// bloom filters small, at the cost of throughput and smaller maximum values.
+func ProfileLowMemory() *Config {
+	return &Config{
+		ValueCap:                  1024 * 1024,
+		PageSize:                  1024*1024 + 64*1024,
+		WritePagesPerWorker:       2,
+		MsgCap:                    2 * 1024 * 1024,
+		MsgPoolMinPercent:         25,
+		MsgPoolMaxPercent:         100,
+		RecoveryBatchSize:         65536,
+		TombstoneDiscardBatchSize: 65536,
+		OutPullReplicationBloomN:  100000,
+		OutPullReplicationBloomP:  0.01,
+		OutPushReplicationBacklog: 4096,
+	}
+}
+
+// ProfileBulkLoad returns a Config suited to loading a large amount of data
+// quickly, such as when populating a new node. Writes are buffered more
+// heavily and background passes, other than replication, run much less
+// often. Switching back to a normal Config once the load is done is
+// recommended.
+func ProfileBulkLoad() *Config {
+	return &Config{
+		PageSize:                 16 * 1024 * 1024,
+		WritePagesPerWorker:      8,
+		MsgPoolMaxPercent:        200,
+		TombstoneDiscardInterval: 600,
+		CompactionInterval:       600,
+		OutPullReplicationBloomN: 1000000,
+		OutPullReplicationBloomP: 0.001,
+	}
+}
@@ -19,22 +19,36 @@ type pullReplicationState struct {
 	inMsgChan            chan *pullReplicationMsg
 	inFreeMsgChan        chan *pullReplicationMsg
 	inResponseMsgTimeout time.Duration
+	inResponseMsgs       int
 	outWorkers           uint64
 	outInterval          time.Duration
 	outNotifyChan        chan *backgroundNotification
 	outIteration         uint16
 	outAbort             uint32
 	outMsgChan           chan *pullReplicationMsg
-	outKTBFs             []*ktBloomFilter
+	outKTBFs             []ktFilter
 	outMsgTimeout        time.Duration
 	bloomN               uint64
 	bloomP               float64
+	cuckoo               bool
+	merkle               bool
+	inMerkleChan         chan *pullReplicationMerkleMsg
+	auditRunLock         sync.Mutex
+	auditLock            sync.Mutex
+	audit                *PullReplicationAudit
+	inPool               *msgPool
+	outPool              *msgPool
 }
 
 type pullReplicationMsg struct {
-	vs     *DefaultValueStore
-	header []byte
-	body   []byte
+	vs       *DefaultValueStore
+	header   []byte
+	body     []byte
+	peerFlow []uint64
+	audit    bool
+	// spare is what an outgoing message's filter is compressed into; see
+	// pullReplicationDeflate.
+	spare []byte
 }
 
 func (vs *DefaultValueStore) pullReplicationConfig(cfg *Config) {
@@ -44,58 +58,70 @@ func (vs *DefaultValueStore) pullReplicationConfig(cfg *Config) {
 	vs.pullReplicationState.outIteration = uint16(cfg.Rand.Uint32())
 	if vs.msgRing != nil {
 		vs.msgRing.SetMsgHandler(_MSG_PULL_REPLICATION, vs.newInPullReplicationMsg)
-		vs.pullReplicationState.inMsgChan = make(chan *pullReplicationMsg, cfg.InPullReplicationMsgs)
-		vs.pullReplicationState.inFreeMsgChan = make(chan *pullReplicationMsg, cfg.InPullReplicationMsgs)
-		for i := 0; i < cap(vs.pullReplicationState.inFreeMsgChan); i++ {
-			vs.pullReplicationState.inFreeMsgChan <- &pullReplicationMsg{
-				vs:     vs,
-				header: make([]byte, _KT_BLOOM_FILTER_HEADER_BYTES+_PULL_REPLICATION_MSG_HEADER_BYTES),
+		vs.msgRing.SetMsgHandler(_MSG_PULL_REPLICATION_AUDIT, vs.newInPullReplicationAuditMsg)
+		vs.msgRing.SetMsgHandler(_MSG_PULL_REPLICATION_AUDIT_RESPONSE, vs.newInPullReplicationAuditResponseMsg)
+		vs.msgRing.SetMsgHandler(_MSG_PULL_REPLICATION_MERKLE, vs.newInPullReplicationMerkleMsg)
+		vs.msgRing.SetMsgHandler(_MSG_PULL_REPLICATION_MERKLE_RESPONSE, vs.newInPullReplicationMerkleResponseMsg)
+		vs.pullReplicationState.inPool = vs.newMsgPool("inPullReplicationMsgPool", cfg, cfg.InPullReplicationMsgs, func() bool {
+			select {
+			case <-vs.pullReplicationState.inFreeMsgChan:
+				return true
+			default:
+				return false
 			}
+		})
+		vs.pullReplicationState.inMsgChan = make(chan *pullReplicationMsg, vs.pullReplicationState.inPool.max)
+		vs.pullReplicationState.inFreeMsgChan = make(chan *pullReplicationMsg, vs.pullReplicationState.inPool.max)
+		for i := int32(0); i < vs.pullReplicationState.inPool.min; i++ {
+			vs.pullReplicationState.inFreeMsgChan <- vs.allocInPullReplicationMsg()
 		}
+		vs.pullReplicationState.inMerkleChan = make(chan *pullReplicationMerkleMsg, vs.pullReplicationState.inPool.max)
 		vs.pullReplicationState.inWorkers = cfg.InPullReplicationWorkers
-		vs.pullReplicationState.outMsgChan = make(chan *pullReplicationMsg, cfg.OutPullReplicationMsgs)
+		vs.pullReplicationState.merkle = cfg.OutPullReplicationMode == "merkle"
 		vs.pullReplicationState.bloomN = uint64(cfg.OutPullReplicationBloomN)
 		vs.pullReplicationState.bloomP = cfg.OutPullReplicationBloomP
-		vs.pullReplicationState.outKTBFs = []*ktBloomFilter{newKTBloomFilter(vs.pullReplicationState.bloomN, vs.pullReplicationState.bloomP, 0)}
-		for i := 0; i < cap(vs.pullReplicationState.outMsgChan); i++ {
-			vs.pullReplicationState.outMsgChan <- &pullReplicationMsg{
-				vs:     vs,
-				header: make([]byte, _KT_BLOOM_FILTER_HEADER_BYTES+_PULL_REPLICATION_MSG_HEADER_BYTES),
-				body:   make([]byte, len(vs.pullReplicationState.outKTBFs[0].bits)),
+		vs.pullReplicationState.cuckoo = cfg.PullReplicationFilter == "cuckoo"
+		vs.pullReplicationState.outKTBFs = []ktFilter{vs.newOutKTFilter()}
+		// Peers with the same Config send filters just as long.
+		vs.msgAuthAllow(_PULL_REPLICATION_MSG_HEADER_BYTES + _KT_BLOOM_FILTER_HEADER_BYTES + vs.pullReplicationState.outKTBFs[0].msgBodyLength() + _MSG_CHECKSUM_LENGTH)
+		vs.pullReplicationState.outPool = vs.newMsgPool("outPullReplicationMsgPool", cfg, cfg.OutPullReplicationMsgs, func() bool {
+			select {
+			case <-vs.pullReplicationState.outMsgChan:
+				return true
+			default:
+				return false
 			}
+		})
+		vs.pullReplicationState.outMsgChan = make(chan *pullReplicationMsg, vs.pullReplicationState.outPool.max)
+		for i := int32(0); i < vs.pullReplicationState.outPool.min; i++ {
+			vs.pullReplicationState.outMsgChan <- vs.allocOutPullReplicationMsg()
 		}
 		vs.pullReplicationState.inResponseMsgTimeout = time.Duration(cfg.InPullReplicationResponseMsgTimeout) * time.Millisecond
+		vs.pullReplicationState.inResponseMsgs = cfg.InPullReplicationResponseMsgs
 		vs.pullReplicationState.outMsgTimeout = time.Duration(cfg.OutPullReplicationMsgTimeout) * time.Millisecond
 	}
 	vs.pullReplicationState.outNotifyChan = make(chan *backgroundNotification, 1)
 }
 
 func (vs *DefaultValueStore) pullReplicationLaunch() {
+	vs.closeState.backgroundWG.Add(vs.pullReplicationState.inWorkers * 2)
 	for i := 0; i < vs.pullReplicationState.inWorkers; i++ {
 		go vs.inPullReplication()
+		go vs.inPullReplicationMerkle()
 	}
+	vs.closeState.backgroundWG.Add(1)
 	go vs.outPullReplicationLauncher()
 }
 
 // DisableOutPullReplication will stop any outgoing pull replication requests
 // until EnableOutPullReplication is called.
 func (vs *DefaultValueStore) DisableOutPullReplication() {
-	c := make(chan struct{}, 1)
-	vs.pullReplicationState.outNotifyChan <- &backgroundNotification{
-		disable:  true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.pullReplicationState.outNotifyChan, &backgroundNotification{disable: true})
 }
 
 // EnableOutPullReplication will resume outgoing pull replication requests.
 func (vs *DefaultValueStore) EnableOutPullReplication() {
-	c := make(chan struct{}, 1)
-	vs.pullReplicationState.outNotifyChan <- &backgroundNotification{
-		enable:   true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.pullReplicationState.outNotifyChan, &backgroundNotification{enable: true})
 }
 
 var toss []byte = make([]byte, 65536)
@@ -103,12 +129,22 @@ var toss []byte = make([]byte, 65536)
 // newInPullReplicationMsg reads pull-replication messages from the MsgRing and
 // puts them on the inMsgChan for the inPullReplication workers to work on.
 func (vs *DefaultValueStore) newInPullReplicationMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInPullReplicationMsg(r, l, false)
+}
+
+func (vs *DefaultValueStore) readInPullReplicationMsg(r io.Reader, l uint64, audit bool) (uint64, error) {
 	var prm *pullReplicationMsg
 	select {
 	case prm = <-vs.pullReplicationState.inFreeMsgChan:
+		vs.pullReplicationState.inPool.got(len(vs.pullReplicationState.inFreeMsgChan))
 	default:
-		// If there isn't a free pullReplicationMsg, just read and discard the
-		// incoming pull-replication message.
+		if vs.pullReplicationState.inPool.grow() {
+			prm = vs.allocInPullReplicationMsg()
+			break
+		}
+		// If there isn't a free pullReplicationMsg, and the pool is at its
+		// maximum size, just read and discard the incoming pull-replication
+		// message.
 		left := l
 		var sn int
 		var err error
@@ -137,6 +173,7 @@ func (vs *DefaultValueStore) newInPullReplicationMsg(r io.Reader, l uint64) (uin
 		prm.body = make([]byte, bl)
 	}
 	prm.body = prm.body[:bl]
+	prm.audit = audit
 	var n int
 	var sn int
 	var err error
@@ -159,6 +196,15 @@ func (vs *DefaultValueStore) newInPullReplicationMsg(r io.Reader, l uint64) (uin
 		sn, err = r.Read(prm.body[n:])
 		n += sn
 	}
+	if prm.flags()&_KT_FILTER_FLAG_CHECKSUM != 0 {
+		var ok bool
+		if prm.body, ok = msgChecksumVerify(prm.header, prm.body); !ok {
+			vs.pullReplicationState.inFreeMsgChan <- prm
+			atomic.AddInt32(&vs.inPullReplicationCorrupts, 1)
+			return l, nil
+		}
+		prm.header[_PULL_REPLICATION_MSG_HEADER_BYTES+_KT_FILTER_FLAGS_OFFSET] &^= _KT_FILTER_FLAG_CHECKSUM
+	}
 	vs.pullReplicationState.inMsgChan <- prm
 	atomic.AddInt32(&vs.inPullReplications, 1)
 	return l, nil
@@ -169,8 +215,13 @@ func (vs *DefaultValueStore) newInPullReplicationMsg(r io.Reader, l uint64) (uin
 func (vs *DefaultValueStore) inPullReplication() {
 	k := make([]uint64, vs.bulkSetState.msgCap/_BULK_SET_MSG_MIN_ENTRY_LENGTH)
 	v := make([]byte, vs.valueCap)
+	var inflated []byte
 	for {
-		prm := <-vs.pullReplicationState.inMsgChan
+		var prm *pullReplicationMsg
+		select {
+		case prm = <-vs.pullReplicationState.inMsgChan:
+		case <-vs.closeState.backgroundChan:
+		}
 		if prm == nil {
 			break
 		}
@@ -179,19 +230,61 @@ func (vs *DefaultValueStore) inPullReplication() {
 			vs.pullReplicationState.inFreeMsgChan <- prm
 			continue
 		}
-		k = k[:0]
 		// This is what the remote system used when making its bloom filter,
 		// computed via its config.ReplicationIgnoreRecent setting. We want to
 		// use the exact same cutoff in our checks and possible response.
 		cutoff := prm.cutoff()
-		tombstoneCutoff := (uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS) - vs.tombstoneDiscardState.age
-		ktbf := prm.ktBloomFilter()
-		l := int64(vs.bulkSetState.msgCap)
+		tombstoneCutoff := (uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS) - atomic.LoadUint64(&vs.tombstoneDiscardState.age)
+		var err error
+		if inflated, err = vs.pullReplicationInflate(prm, _PULL_REPLICATION_MSG_HEADER_BYTES, inflated); err != nil {
+			vs.pullReplicationState.inFreeMsgChan <- prm
+			atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+			continue
+		}
+		ktbf := prm.ktFilter()
+		if ktbf == nil {
+			vs.pullReplicationState.inFreeMsgChan <- prm
+			atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+			continue
+		}
+		var l int64
+		// An audit request just wants to know how much would have been sent,
+		// so it counts everything missing rather than stopping at msgCap.
+		audit := prm.audit
+		var auditKeys uint64
+		var auditBytes uint64
+		// A response too large for one bulk-set message continues in more,
+		// up to Config.InPullReplicationResponseMsgs of them, each picking
+		// the scan up at the keyA the last stopped at; resumeBs are the keyBs
+		// there that have already been sent. An entry that would overflow a
+		// message is carried over to start the next.
+		var resumeA uint64
+		var resumeBs []uint64
+		var carry []uint64
+		var carryLength int64
 		callback := func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
 			if timestampbits&_TSB_DELETION == 0 || timestampbits >= tombstoneCutoff {
 				if !ktbf.mayHave(keyA, keyB, timestampbits) {
+					if audit {
+						auditKeys++
+						auditBytes += uint64(length)
+						return true
+					}
+					if keyA == resumeA {
+						for _, b := range resumeBs {
+							if b == keyB {
+								return true
+							}
+						}
+					}
+					entryLength := _BULK_SET_MSG_ENTRY_HEADER_LENGTH + int64(length)
+					if entryLength > l && len(k) > 0 {
+						carry = append(carry[:0], keyA, keyB)
+						carryLength = entryLength
+						return false
+					}
 					k = append(k, keyA, keyB)
-					l -= _BULK_SET_MSG_ENTRY_HEADER_LENGTH + int64(length)
+					l -= entryLength
 					if l <= 0 {
 						return false
 					}
@@ -207,46 +300,141 @@ func (vs *DefaultValueStore) inPullReplication() {
 		// replicas, will get different responses back instead of duplicate
 		// items if there is a lot of data to be sent.
 		scanStart := prm.rangeStart() + (prm.rangeStop()-prm.rangeStart())/uint64(ring.ReplicaCount())*uint64(ring.ResponsibleReplica(uint32(prm.rangeStart()>>(64-ring.PartitionBitCount()))))
-		scanStop := prm.rangeStop()
-		vs.vlm.ScanCallback(scanStart, scanStop, 0, _TSB_LOCAL_REMOVAL, cutoff, math.MaxUint64, callback)
-		if l > 0 {
-			scanStop = scanStart - 1
-			scanStart = prm.rangeStart()
-			vs.vlm.ScanCallback(scanStart, scanStop, 0, _TSB_LOCAL_REMOVAL, cutoff, math.MaxUint64, callback)
+		segments := [][2]uint64{{scanStart, prm.rangeStop()}}
+		if scanStart != prm.rangeStart() {
+			segments = append(segments, [2]uint64{prm.rangeStart(), scanStart - 1})
 		}
 		nodeID := prm.nodeID()
-		vs.pullReplicationState.inFreeMsgChan <- prm
-		if len(k) > 0 {
-			bsm := vs.newOutBulkSetMsg()
-			// Indicate that a response to this bulk-set message is not
-			// necessary. If the message fails to reach its destination, that
-			// destination will simply resend another pull replication message
-			// on its next pass.
-			binary.BigEndian.PutUint64(bsm.header, 0)
-			var t uint64
-			var err error
-			for i := 0; i < len(k); i += 2 {
-				t, v, err = vs.read(k[i], k[i+1], v[:0])
-				if err == ErrNotFound {
-					if t == 0 {
-						continue
+		partition := prm.partition()
+		missing := 0
+		oldest := uint64(math.MaxUint64)
+		start := scanStart
+		resumeBs = resumeBs[:0]
+		carry = carry[:0]
+		for msgs := 0; msgs < vs.pullReplicationState.inResponseMsgs && (len(segments) > 0 || len(carry) > 0); msgs++ {
+			// bulkSetMsg.add needs the body to stay under msgCap.
+			l = int64(vs.bulkSetState.msgCap) - 1
+			k = append(k[:0], carry...)
+			if len(carry) > 0 {
+				l -= carryLength
+				carry = carry[:0]
+			}
+			for len(segments) > 0 {
+				next, more := vs.vlm.ScanCallback(start, segments[0][1], 0, _TSB_LOCAL_REMOVAL, cutoff, math.MaxUint64, callback)
+				if more {
+					if next != resumeA {
+						resumeBs = resumeBs[:0]
 					}
-				} else if err != nil {
-					continue
-				}
-				if t&_TSB_LOCAL_REMOVAL == 0 {
-					if !bsm.add(k[i], k[i+1], t, v) {
-						break
+					resumeA = next
+					for i := 0; i < len(k); i += 2 {
+						if k[i] == next {
+							resumeBs = append(resumeBs, k[i+1])
+						}
 					}
-					atomic.AddInt32(&vs.outBulkSetValues, 1)
+					if len(carry) > 0 && carry[0] == next {
+						resumeBs = append(resumeBs, carry[1])
+					}
+					start = next
+					break
 				}
+				segments = segments[1:]
+				if len(segments) > 0 {
+					start = segments[0][0]
+				}
+			}
+			if audit {
+				break
 			}
-			if len(bsm.body) > 0 {
-				atomic.AddInt32(&vs.outBulkSets, 1)
-				vs.msgRing.MsgToNode(bsm, nodeID, vs.pullReplicationState.inResponseMsgTimeout)
+			if len(k) > 0 {
+				if msgs > 0 {
+					atomic.AddInt32(&vs.outBulkSetContinuations, 1)
+				}
+				var sent int
+				var sentOldest uint64
+				v, sent, sentOldest = vs.outPullReplicationBulkSet(nodeID, dedupeKeyList(k), v)
+				missing += sent
+				if sentOldest < oldest {
+					oldest = sentOldest
+				}
 			}
 		}
+		// The filter is the message's body, so the message can only be
+		// reused once the whole response is done.
+		vs.pullReplicationState.inFreeMsgChan <- prm
+		if audit {
+			vs.outPullReplicationAuditResponse(nodeID, partition, auditKeys, auditBytes)
+			continue
+		}
+		vs.replicationLagRecord(nodeID, partition, missing, oldest)
 	}
+	vs.closeState.backgroundWG.Done()
+}
+
+// outPullReplicationKeys sends the values for the keyA, keyB pairs in k to
+// nodeID in a bulk-set message, as many as will fit, in response to an
+// incoming pull-replication request for the partition, noting what was sent
+// for the replication lag estimates. The value buffer v is returned for
+// reuse.
+func (vs *DefaultValueStore) outPullReplicationKeys(nodeID uint64, partition uint32, k []uint64, v []byte) []byte {
+	v, missing, oldest := vs.outPullReplicationBulkSet(nodeID, k, v)
+	vs.replicationLagRecord(nodeID, partition, missing, oldest)
+	return v
+}
+
+// outPullReplicationBulkSet sends the values for the keyA, keyB pairs in k to
+// nodeID in a bulk-set message, as many as will fit, returning the value
+// buffer v for reuse, how many values were sent, and the oldest of their
+// timestampbits.
+func (vs *DefaultValueStore) outPullReplicationBulkSet(nodeID uint64, k []uint64, v []byte) ([]byte, int, uint64) {
+	missing := 0
+	oldest := uint64(math.MaxUint64)
+	if len(k) == 0 {
+		return v, missing, oldest
+	}
+	bsm := vs.newOutReplicationBulkSetMsg()
+	// Indicate that a response to this bulk-set message is not necessary. If
+	// the message fails to reach its destination, that destination will
+	// simply resend another pull replication message on its next pass.
+	bsm.setAckPolicy(BULK_SET_ACK_NONE)
+	var t uint64
+	var err error
+	for i := 0; i < len(k); i += 2 {
+		t, v, err = vs.read(k[i], k[i+1], v[:0])
+		if err == ErrNotFound {
+			if t == 0 {
+				continue
+			}
+		} else if err != nil {
+			continue
+		}
+		if t&_TSB_LOCAL_REMOVAL == 0 {
+			if !vs.outReplicationAdd(bsm, k[i], k[i+1], t, v) {
+				break
+			}
+			atomic.AddInt32(&vs.outBulkSetValues, 1)
+			missing++
+			if t < oldest {
+				oldest = t
+			}
+		}
+	}
+	if len(bsm.body) > 0 {
+		if vs.msgToNode(bsm, nodeID, vs.pullReplicationState.inResponseMsgTimeout) {
+			atomic.AddInt32(&vs.outBulkSets, 1)
+		}
+	} else {
+		bsm.Free()
+	}
+	return v, missing, oldest
+}
+
+// newOutKTFilter returns a filter of the Config.PullReplicationFilter type for
+// outgoing pull-replication messages.
+func (vs *DefaultValueStore) newOutKTFilter() ktFilter {
+	if vs.pullReplicationState.cuckoo {
+		return newKTCuckooFilter(vs.pullReplicationState.bloomN, vs.pullReplicationState.bloomP, 0)
+	}
+	return newKTBloomFilter(vs.pullReplicationState.bloomN, vs.pullReplicationState.bloomP, 0)
 }
 
 // OutPullReplicationPass will immediately execute an outgoing pull replication
@@ -258,14 +446,13 @@ func (vs *DefaultValueStore) inPullReplication() {
 // and so synchronization at that level is not possible.
 func (vs *DefaultValueStore) OutPullReplicationPass() {
 	atomic.StoreUint32(&vs.pullReplicationState.outAbort, 1)
-	c := make(chan struct{}, 1)
-	vs.pullReplicationState.outNotifyChan <- &backgroundNotification{doneChan: c}
-	<-c
+	vs.backgroundNotify(vs.pullReplicationState.outNotifyChan, &backgroundNotification{})
 }
 
 func (vs *DefaultValueStore) outPullReplicationLauncher() {
+	defer vs.closeState.backgroundWG.Done()
 	var enabled bool
-	interval := float64(vs.pullReplicationState.outInterval)
+	interval := float64(atomic.LoadInt64((*int64)(&vs.pullReplicationState.outInterval)))
 	vs.randMutex.Lock()
 	nextRun := time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 	vs.randMutex.Unlock()
@@ -276,13 +463,18 @@ func (vs *DefaultValueStore) outPullReplicationLauncher() {
 			select {
 			case notification = <-vs.pullReplicationState.outNotifyChan:
 			case <-time.After(sleep):
+			case <-vs.closeState.backgroundChan:
+				return
 			}
 		} else {
 			select {
 			case notification = <-vs.pullReplicationState.outNotifyChan:
+			case <-vs.closeState.backgroundChan:
+				return
 			default:
 			}
 		}
+		interval = float64(atomic.LoadInt64((*int64)(&vs.pullReplicationState.outInterval)))
 		vs.randMutex.Lock()
 		nextRun = time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 		vs.randMutex.Unlock()
@@ -299,23 +491,28 @@ func (vs *DefaultValueStore) outPullReplicationLauncher() {
 				continue
 			}
 			atomic.StoreUint32(&vs.pullReplicationState.outAbort, 0)
-			vs.outPullReplicationPass()
+			vs.outPullReplicationPass(notification.audit)
 			notification.doneChan <- struct{}{}
 		} else if enabled {
 			atomic.StoreUint32(&vs.pullReplicationState.outAbort, 0)
-			vs.outPullReplicationPass()
+			vs.outPullReplicationPass(false)
 		}
 	}
 }
 
-func (vs *DefaultValueStore) outPullReplicationPass() {
+// outPullReplicationPass sends out pull-replication messages for all the
+// partitions the local node is responsible for; if audit is true the messages
+// are sent as audit requests instead, see OutPullReplicationAudit. With
+// Config.OutPullReplicationMode "merkle", other than for audits, hash tree
+// requests are sent instead of bloom filters; see pullreplicationmerkle.go.
+func (vs *DefaultValueStore) outPullReplicationPass(audit bool) {
 	if vs.msgRing == nil {
 		return
 	}
 	if vs.logDebug != nil {
 		begin := time.Now()
 		defer func() {
-			vs.logDebug("out pull replication pass took %s\n", time.Now().Sub(begin))
+			vs.logDebug("out pull replication pass (audit %v) took %s\n", audit, time.Now().Sub(begin))
 		}()
 	}
 	ring := vs.msgRing.Ring()
@@ -332,9 +529,9 @@ func (vs *DefaultValueStore) outPullReplicationPass() {
 	ringVersion := ring.Version()
 	ws := vs.pullReplicationState.outWorkers
 	for uint64(len(vs.pullReplicationState.outKTBFs)) < ws {
-		vs.pullReplicationState.outKTBFs = append(vs.pullReplicationState.outKTBFs, newKTBloomFilter(vs.pullReplicationState.bloomN, vs.pullReplicationState.bloomP, 0))
+		vs.pullReplicationState.outKTBFs = append(vs.pullReplicationState.outKTBFs, vs.newOutKTFilter())
 	}
-	f := func(p uint64, w uint64, ktbf *ktBloomFilter) {
+	f := func(p uint64, w uint64, ktbf ktFilter, pacer *backgroundPacer) {
 		pb := p << rightwardPartitionShift
 		rb := pb + ((uint64(1) << rightwardPartitionShift) / ws * w)
 		var re uint64
@@ -348,7 +545,11 @@ func (vs *DefaultValueStore) outPullReplicationPass() {
 			re = pb + ((uint64(1) << rightwardPartitionShift) / ws * (w + 1)) - 1
 		}
 		timestampbitsnow := uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS
-		cutoff := timestampbitsnow - vs.replicationIgnoreRecent
+		cutoff := timestampbitsnow - atomic.LoadUint64(&vs.replicationIgnoreRecent)
+		if vs.pullReplicationState.merkle && !audit {
+			vs.outPullReplicationMerkle(ringVersion, uint32(p), cutoff, rb, re)
+			return
+		}
 		var more bool
 		for {
 			rbThis := rb
@@ -369,11 +570,17 @@ func (vs *DefaultValueStore) outPullReplicationPass() {
 				reThis = rb - 1
 			}
 			prm := vs.newOutPullReplicationMsg(ringVersion, uint32(p), cutoff, rbThis, reThis, ktbf)
-			atomic.AddInt32(&vs.outPullReplications, 1)
-			vs.msgRing.MsgToOtherReplicas(prm, uint32(p), vs.pullReplicationState.outMsgTimeout)
+			prm.audit = audit
+			if vs.msgToOtherReplicas(prm, uint32(p), vs.pullReplicationState.outMsgTimeout) {
+				atomic.AddInt32(&vs.outPullReplications, 1)
+				if audit {
+					vs.pullReplicationAuditRequestSent()
+				}
+			}
 			if !more {
 				break
 			}
+			pacer.pause()
 		}
 	}
 	wg := &sync.WaitGroup{}
@@ -381,6 +588,7 @@ func (vs *DefaultValueStore) outPullReplicationPass() {
 	for w := uint64(0); w < ws; w++ {
 		go func(w uint64) {
 			ktbf := vs.pullReplicationState.outKTBFs[w]
+			pacer := vs.newBackgroundPacer()
 			pb := partitionCount / ws * w
 			for p := pb; p < partitionCount; p++ {
 				if atomic.LoadUint32(&vs.pullReplicationState.outAbort) != 0 {
@@ -391,7 +599,7 @@ func (vs *DefaultValueStore) outPullReplicationPass() {
 					break
 				}
 				if ring.Responsible(uint32(p)) {
-					f(p, w, ktbf)
+					f(p, w, ktbf, pacer)
 				}
 			}
 			for p := uint64(0); p < pb; p++ {
@@ -403,7 +611,7 @@ func (vs *DefaultValueStore) outPullReplicationPass() {
 					break
 				}
 				if ring.Responsible(uint32(p)) {
-					f(p, w, ktbf)
+					f(p, w, ktbf, pacer)
 				}
 			}
 			wg.Done()
@@ -416,12 +624,22 @@ func (vs *DefaultValueStore) outPullReplicationPass() {
 // out and eventually sending using the MsgRing. The MsgRing (or someone else
 // if the message doesn't end up with the MsgRing) will call
 // pullReplicationMsg.Free() eventually and the pullReplicationMsg will be
-// requeued for reuse later. There is a fixed number of outgoing
+// requeued for reuse later. There is a maximum number of outgoing
 // pullReplicationMsg instances that can exist at any given time, capping
 // memory usage. Once the limit is reached, this method will block until a
 // pullReplicationMsg is available to return.
-func (vs *DefaultValueStore) newOutPullReplicationMsg(ringVersion int64, partition uint32, cutoff uint64, rangeStart uint64, rangeStop uint64, ktbf *ktBloomFilter) *pullReplicationMsg {
-	prm := <-vs.pullReplicationState.outMsgChan
+func (vs *DefaultValueStore) newOutPullReplicationMsg(ringVersion int64, partition uint32, cutoff uint64, rangeStart uint64, rangeStop uint64, ktbf ktFilter) *pullReplicationMsg {
+	var prm *pullReplicationMsg
+	select {
+	case prm = <-vs.pullReplicationState.outMsgChan:
+		vs.pullReplicationState.outPool.got(len(vs.pullReplicationState.outMsgChan))
+	default:
+		if vs.pullReplicationState.outPool.grow() {
+			prm = vs.allocOutPullReplicationMsg()
+		} else {
+			prm = <-vs.pullReplicationState.outMsgChan
+		}
+	}
 	if vs.msgRing != nil {
 		if r := vs.msgRing.Ring(); r != nil {
 			if n := r.LocalNode(); n != nil {
@@ -434,18 +652,33 @@ func (vs *DefaultValueStore) newOutPullReplicationMsg(ringVersion int64, partiti
 	binary.BigEndian.PutUint64(prm.header[20:], cutoff)
 	binary.BigEndian.PutUint64(prm.header[28:], rangeStart)
 	binary.BigEndian.PutUint64(prm.header[36:], rangeStop)
+	// The body may have been left short by compressing the last filter.
+	prm.body = prm.body[:ktbf.msgBodyLength()]
 	ktbf.toMsg(prm, _PULL_REPLICATION_MSG_HEADER_BYTES)
+	vs.pullReplicationDeflate(prm, _PULL_REPLICATION_MSG_HEADER_BYTES)
+	prm.header[_PULL_REPLICATION_MSG_HEADER_BYTES+_KT_FILTER_FLAGS_OFFSET] |= _KT_FILTER_FLAG_CHECKSUM
+	prm.audit = false
 	return prm
 }
 
 func (prm *pullReplicationMsg) MsgType() uint64 {
+	if prm.audit {
+		return _MSG_PULL_REPLICATION_AUDIT
+	}
 	return _MSG_PULL_REPLICATION
 }
 
 func (prm *pullReplicationMsg) MsgLength() uint64 {
+	if prm.flags()&_KT_FILTER_FLAG_CHECKSUM != 0 {
+		return uint64(len(prm.header)) + uint64(len(prm.body)) + _MSG_CHECKSUM_LENGTH
+	}
 	return uint64(len(prm.header)) + uint64(len(prm.body))
 }
 
+func (prm *pullReplicationMsg) flags() byte {
+	return prm.header[_PULL_REPLICATION_MSG_HEADER_BYTES+_KT_FILTER_FLAGS_OFFSET]
+}
+
 func (prm *pullReplicationMsg) nodeID() uint64 {
 	return binary.BigEndian.Uint64(prm.header)
 }
@@ -474,6 +707,12 @@ func (prm *pullReplicationMsg) ktBloomFilter() *ktBloomFilter {
 	return newKTBloomFilterFromMsg(prm, _PULL_REPLICATION_MSG_HEADER_BYTES)
 }
 
+// ktFilter returns the filter the message carries, of whichever type, or nil
+// if it cannot be made sense of.
+func (prm *pullReplicationMsg) ktFilter() ktFilter {
+	return newKTFilterFromMsg(prm, _PULL_REPLICATION_MSG_HEADER_BYTES)
+}
+
 func (prm *pullReplicationMsg) WriteContent(w io.Writer) (uint64, error) {
 	var n int
 	var sn int
@@ -485,9 +724,35 @@ func (prm *pullReplicationMsg) WriteContent(w io.Writer) (uint64, error) {
 	}
 	sn, err = w.Write(prm.body)
 	n += sn
+	if err != nil || prm.flags()&_KT_FILTER_FLAG_CHECKSUM == 0 {
+		return uint64(n), err
+	}
+	c := msgChecksumTrailer(prm.header, prm.body)
+	sn, err = w.Write(c[:])
+	n += sn
 	return uint64(n), err
 }
 
+func (vs *DefaultValueStore) allocInPullReplicationMsg() *pullReplicationMsg {
+	return &pullReplicationMsg{
+		vs:     vs,
+		header: make([]byte, _KT_BLOOM_FILTER_HEADER_BYTES+_PULL_REPLICATION_MSG_HEADER_BYTES),
+	}
+}
+
+func (vs *DefaultValueStore) allocOutPullReplicationMsg() *pullReplicationMsg {
+	return &pullReplicationMsg{
+		vs:     vs,
+		header: make([]byte, _KT_BLOOM_FILTER_HEADER_BYTES+_PULL_REPLICATION_MSG_HEADER_BYTES),
+		body:   make([]byte, vs.pullReplicationState.outKTBFs[0].msgBodyLength()),
+	}
+}
+
 func (prm *pullReplicationMsg) Free() {
+	prm.vs.peerFlowFree(prm)
 	prm.vs.pullReplicationState.outMsgChan <- prm
 }
+
+func (prm *pullReplicationMsg) peerFlowNodeIDs() *[]uint64 {
+	return &prm.peerFlow
+}
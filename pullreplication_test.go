@@ -1,6 +1,7 @@
 package valuestore
 
 import (
+	"math"
 	"sync"
 	"testing"
 	"time"
@@ -63,7 +64,7 @@ func TestPullReplicationSimple(t *testing.T) {
 	r := b.Ring()
 	r.SetLocalNode(n.ID())
 	m := &msgRingPullReplicationTester{ring: r}
-	vs := New(&Config{MsgRing: m})
+	vs := newTestStore(t, &Config{MsgRing: m})
 	vs.EnableAll()
 	defer vs.DisableAll()
 	_, err = vs.write(1, 2, 0x300, []byte("testing"))
@@ -91,3 +92,164 @@ func TestPullReplicationSimple(t *testing.T) {
 		t.Fatal("")
 	}
 }
+
+func TestPullReplicationAudit(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingPullReplicationTester{ring: r}
+	vs := newTestStore(t, &Config{MsgRing: m})
+	vs.EnableWrites()
+	_, err = vs.write(1, 2, 0x300, []byte("testing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := vs.OutPullReplicationAudit(10 * time.Millisecond)
+	if audit.Requests == 0 {
+		t.Fatal(audit.Requests)
+	}
+	if audit.Responses != 0 {
+		t.Fatal(audit.Responses)
+	}
+	m.lock.Lock()
+	v := len(m.headerToPartitions)
+	m.lock.Unlock()
+	if v != audit.Requests {
+		t.Fatal(v, audit.Requests)
+	}
+	audit.add(n2.ID(), 1, 10, 100)
+	if audit.Responses != 1 || audit.MissingKeys != 10 || audit.MissingBytes != 100 {
+		t.Fatal(audit)
+	}
+	if audit.Nodes[n2.ID()].MissingBytes != 100 || audit.Partitions[1].MissingKeys != 10 {
+		t.Fatal(audit)
+	}
+}
+
+func TestPullReplicationCuckoo(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingPullReplicationTester{ring: r}
+	vs := New(&Config{Path: t.TempDir(), MsgRing: m, PullReplicationFilter: "cuckoo"})
+	vs.EnableWrites()
+	defer vs.Close()
+	if _, err = vs.write(1, 2, 0x300, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	vs.OutPullReplicationPass()
+	m.lock.Lock()
+	headers := m.headerToPartitions
+	bodies := m.bodyToPartitions
+	m.lock.Unlock()
+	if len(headers) == 0 {
+		t.Fatal(len(headers))
+	}
+	mayHave := false
+	for i := range headers {
+		if headers[i][_PULL_REPLICATION_MSG_HEADER_BYTES+_KT_FILTER_TYPE_OFFSET] != _KT_FILTER_CUCKOO {
+			t.Fatal(headers[i])
+		}
+		prm := &pullReplicationMsg{vs: vs, header: headers[i], body: bodies[i]}
+		if prm.ktFilter().mayHave(1, 2, 0x300) {
+			mayHave = true
+		}
+	}
+	if !mayHave {
+		t.Fatal("")
+	}
+	// Answering its own requests, the store sends just what it has gained
+	// since making them.
+	if _, err = vs.write(1, 3, 0x300, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	for i := range headers {
+		prm := <-vs.pullReplicationState.inFreeMsgChan
+		copy(prm.header, headers[i])
+		prm.body = append(prm.body[:0], bodies[i]...)
+		vs.pullReplicationState.inMsgChan <- prm
+	}
+	for i := 0; i < 100; i++ {
+		m.lock.Lock()
+		v := len(m.msgToNodeIDs)
+		m.lock.Unlock()
+		if v != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.lock.Lock()
+	v := len(m.msgToNodeIDs)
+	m.lock.Unlock()
+	if v != 1 {
+		t.Fatal(v)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.OutBulkSetValues != 1 {
+		t.Fatal(stats.OutBulkSetValues)
+	}
+}
+
+func TestPullReplicationContinuation(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingPullReplicationTester{ring: r}
+	// Room for two of the values below per bulk-set message.
+	vs := New(&Config{Path: t.TempDir(), MsgRing: m, BulkSetMsgCap: 2*(_BULK_SET_MSG_ENTRY_HEADER_LENGTH+7) + 1})
+	vs.EnableWrites()
+	defer vs.Close()
+	for keyA := uint64(1); keyA <= 5; keyA++ {
+		if _, err = vs.write(keyA, 2, 0x300, []byte("testing")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request := func() {
+		out := vs.newOutPullReplicationMsg(r.Version(), 0, math.MaxUint64, 0, (uint64(1)<<(64-r.PartitionBitCount()))-1, vs.newOutKTFilter())
+		free := len(vs.pullReplicationState.inFreeMsgChan)
+		prm := <-vs.pullReplicationState.inFreeMsgChan
+		copy(prm.header, out.header)
+		prm.body = append(prm.body[:0], out.body...)
+		out.Free()
+		vs.pullReplicationState.inMsgChan <- prm
+		// The message is freed once the whole response is sent.
+		for i := 0; i < 100 && len(vs.pullReplicationState.inFreeMsgChan) != free; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	request()
+	stats := vs.Stats(false).(*Stats)
+	if stats.OutBulkSets != 3 || stats.OutBulkSetValues != 5 || stats.OutBulkSetContinuations != 2 {
+		t.Fatal(stats.OutBulkSets, stats.OutBulkSetValues, stats.OutBulkSetContinuations)
+	}
+	vs.pullReplicationState.inResponseMsgs = 2
+	request()
+	stats = vs.Stats(false).(*Stats)
+	if stats.OutBulkSets != 2 || stats.OutBulkSetValues != 4 || stats.OutBulkSetContinuations != 1 {
+		t.Fatal(stats.OutBulkSets, stats.OutBulkSetValues, stats.OutBulkSetContinuations)
+	}
+}
@@ -0,0 +1,244 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/gholt/brimtext.v1"
+)
+
+// Pull-replication audit requests are regular pull-replication messages sent
+// with a different message type. The receiver performs the same scan against
+// the bloom filter but, rather than sending back the missing data in a
+// bulk-set message, sends back a small audit response with the counts.
+const _MSG_PULL_REPLICATION_AUDIT = 0x7a1d8e2c5b3f4906
+
+// prarm: responderNodeID:8, partition:4, missingKeys:8, missingBytes:8
+const _MSG_PULL_REPLICATION_AUDIT_RESPONSE = 0x1c6f3a9e8d2b4457
+const _PULL_REPLICATION_AUDIT_RESPONSE_MSG_LENGTH = 28
+
+// PullReplicationAudit is the result of OutPullReplicationAudit. All counts
+// are estimates as bloom filter collisions will hide a small percentage of
+// missing items.
+type PullReplicationAudit struct {
+	// Requests is the number of audit requests sent out.
+	Requests int
+	// Responses is the number of audit responses received in time.
+	Responses int
+	// MissingKeys is the total number of keys other replicas would have sent.
+	MissingKeys uint64
+	// MissingBytes is the total number of value bytes other replicas would
+	// have sent.
+	MissingBytes uint64
+	// Partitions breaks down the results by partition.
+	Partitions map[uint32]*PullReplicationAuditEntry
+	// Nodes breaks down the results by responding node ID.
+	Nodes map[uint64]*PullReplicationAuditEntry
+
+	expected int
+	doneChan chan struct{}
+}
+
+// PullReplicationAuditEntry is the portion of a PullReplicationAudit for a
+// single partition or node.
+type PullReplicationAuditEntry struct {
+	Responses    int
+	MissingKeys  uint64
+	MissingBytes uint64
+}
+
+type pullReplicationAuditResponseMsg struct {
+	vs       *DefaultValueStore
+	body     []byte
+	peerFlow []uint64
+}
+
+// OutPullReplicationAudit performs an outgoing pull replication pass that
+// only asks the other replicas what they would send, without any bulk data
+// actually being transferred. It waits up to the given duration for the
+// responses and then returns what has been gathered. As with
+// OutPullReplicationPass, any pass currently executing will be stopped first.
+func (vs *DefaultValueStore) OutPullReplicationAudit(wait time.Duration) *PullReplicationAudit {
+	audit := &PullReplicationAudit{
+		Partitions: make(map[uint32]*PullReplicationAuditEntry),
+		Nodes:      make(map[uint64]*PullReplicationAuditEntry),
+		expected:   -1,
+		doneChan:   make(chan struct{}),
+	}
+	if vs.msgRing == nil {
+		return audit
+	}
+	vs.pullReplicationState.auditRunLock.Lock()
+	defer vs.pullReplicationState.auditRunLock.Unlock()
+	vs.pullReplicationState.auditLock.Lock()
+	vs.pullReplicationState.audit = audit
+	vs.pullReplicationState.auditLock.Unlock()
+	atomic.StoreUint32(&vs.pullReplicationState.outAbort, 1)
+	vs.backgroundNotify(vs.pullReplicationState.outNotifyChan, &backgroundNotification{audit: true})
+	vs.pullReplicationState.auditLock.Lock()
+	audit.expected = audit.Requests
+	if r := vs.msgRing.Ring(); r != nil {
+		audit.expected = audit.Requests * (r.ReplicaCount() - 1)
+	}
+	if audit.Responses >= audit.expected {
+		close(audit.doneChan)
+	}
+	vs.pullReplicationState.auditLock.Unlock()
+	select {
+	case <-audit.doneChan:
+	case <-time.After(wait):
+	}
+	vs.pullReplicationState.auditLock.Lock()
+	vs.pullReplicationState.audit = nil
+	vs.pullReplicationState.auditLock.Unlock()
+	return audit
+}
+
+func (vs *DefaultValueStore) pullReplicationAuditRequestSent() {
+	vs.pullReplicationState.auditLock.Lock()
+	if audit := vs.pullReplicationState.audit; audit != nil {
+		audit.Requests++
+	}
+	vs.pullReplicationState.auditLock.Unlock()
+}
+
+// newInPullReplicationAuditMsg is the MsgRing handler for pull-replication
+// audit requests; these are queued with the regular pull-replication
+// messages.
+func (vs *DefaultValueStore) newInPullReplicationAuditMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInPullReplicationMsg(r, l, true)
+}
+
+// outPullReplicationAuditResponse sends the results of processing an incoming
+// pull-replication audit request back to the requester.
+func (vs *DefaultValueStore) outPullReplicationAuditResponse(nodeID uint64, partition uint32, missingKeys uint64, missingBytes uint64) {
+	// These are tiny and infrequent enough that they aren't pooled.
+	prarm := &pullReplicationAuditResponseMsg{
+		vs:   vs,
+		body: make([]byte, _PULL_REPLICATION_AUDIT_RESPONSE_MSG_LENGTH),
+	}
+	if r := vs.msgRing.Ring(); r != nil {
+		if n := r.LocalNode(); n != nil {
+			binary.BigEndian.PutUint64(prarm.body, n.ID())
+		}
+	}
+	binary.BigEndian.PutUint32(prarm.body[8:], partition)
+	binary.BigEndian.PutUint64(prarm.body[12:], missingKeys)
+	binary.BigEndian.PutUint64(prarm.body[20:], missingBytes)
+	vs.msgToNode(prarm, nodeID, vs.pullReplicationState.inResponseMsgTimeout)
+}
+
+// newInPullReplicationAuditResponseMsg reads pull-replication audit responses
+// from the MsgRing and records them directly into any audit in progress.
+func (vs *DefaultValueStore) newInPullReplicationAuditResponseMsg(r io.Reader, l uint64) (uint64, error) {
+	if l != _PULL_REPLICATION_AUDIT_RESPONSE_MSG_LENGTH {
+		left := l
+		var sn int
+		var err error
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err = r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+				return l - left, err
+			}
+		}
+		atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+		return l, nil
+	}
+	b := make([]byte, _PULL_REPLICATION_AUDIT_RESPONSE_MSG_LENGTH)
+	var n int
+	var sn int
+	var err error
+	for n != len(b) {
+		sn, err = r.Read(b[n:])
+		n += sn
+		if err != nil {
+			atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+			return uint64(n), err
+		}
+	}
+	nodeID := binary.BigEndian.Uint64(b)
+	partition := binary.BigEndian.Uint32(b[8:])
+	missingKeys := binary.BigEndian.Uint64(b[12:])
+	missingBytes := binary.BigEndian.Uint64(b[20:])
+	vs.pullReplicationState.auditLock.Lock()
+	if audit := vs.pullReplicationState.audit; audit != nil {
+		audit.add(nodeID, partition, missingKeys, missingBytes)
+	}
+	vs.pullReplicationState.auditLock.Unlock()
+	return l, nil
+}
+
+func (audit *PullReplicationAudit) add(nodeID uint64, partition uint32, missingKeys uint64, missingBytes uint64) {
+	audit.Responses++
+	audit.MissingKeys += missingKeys
+	audit.MissingBytes += missingBytes
+	pe := audit.Partitions[partition]
+	if pe == nil {
+		pe = &PullReplicationAuditEntry{}
+		audit.Partitions[partition] = pe
+	}
+	pe.Responses++
+	pe.MissingKeys += missingKeys
+	pe.MissingBytes += missingBytes
+	ne := audit.Nodes[nodeID]
+	if ne == nil {
+		ne = &PullReplicationAuditEntry{}
+		audit.Nodes[nodeID] = ne
+	}
+	ne.Responses++
+	ne.MissingKeys += missingKeys
+	ne.MissingBytes += missingBytes
+	if audit.expected >= 0 && audit.Responses == audit.expected {
+		close(audit.doneChan)
+	}
+}
+
+func (audit *PullReplicationAudit) String() string {
+	report := [][]string{
+		{"Requests", fmt.Sprintf("%d", audit.Requests)},
+		{"Responses", fmt.Sprintf("%d", audit.Responses)},
+		{"MissingKeys", fmt.Sprintf("%d", audit.MissingKeys)},
+		{"MissingBytes", fmt.Sprintf("%d", audit.MissingBytes)},
+	}
+	nodeIDs := make([]uint64, 0, len(audit.Nodes))
+	for nodeID := range audit.Nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i] < nodeIDs[j] })
+	for _, nodeID := range nodeIDs {
+		e := audit.Nodes[nodeID]
+		report = append(report, []string{fmt.Sprintf("Node %d", nodeID), fmt.Sprintf("%d keys, %d bytes", e.MissingKeys, e.MissingBytes)})
+	}
+	return brimtext.Align(report, nil)
+}
+
+func (prarm *pullReplicationAuditResponseMsg) MsgType() uint64 {
+	return _MSG_PULL_REPLICATION_AUDIT_RESPONSE
+}
+
+func (prarm *pullReplicationAuditResponseMsg) MsgLength() uint64 {
+	return uint64(len(prarm.body))
+}
+
+func (prarm *pullReplicationAuditResponseMsg) WriteContent(w io.Writer) (uint64, error) {
+	n, err := w.Write(prarm.body)
+	return uint64(n), err
+}
+
+func (prarm *pullReplicationAuditResponseMsg) Free() {
+	prarm.vs.peerFlowFree(prarm)
+}
+
+func (prarm *pullReplicationAuditResponseMsg) peerFlowNodeIDs() *[]uint64 {
+	return &prarm.peerFlow
+}
@@ -0,0 +1,345 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/spaolacci/murmur3"
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// Merkle pull-replication, Config.OutPullReplicationMode "merkle", replaces
+// the bloom filter of a range with a level of a hash tree over it: the range
+// is split into _PULL_REPLICATION_MERKLE_FANOUT subranges and, for each, the
+// XOR of the hashes of its key, timestamp pairs along with how many there
+// are. A mostly synced partition then costs a few hundred bytes a pass rather
+// than a bloom filter sized for a million keys.
+//
+// The receiver computes the same level over its own items, with the
+// requester's cutoffs so both sides hash the same items. Subranges that match
+// are done with. Differing subranges small enough, by the receiver's count,
+// are re-scanned and shipped back in a bulk-set message as the regular
+// pull-replication does; the rest are named in a response so the requester
+// can send a request for the next level down of just those. Items only the
+// requester has are left for the receiver's own passes to pull.
+//
+// prmm: nodeID:8, ringVersion:8, partition:4, cutoff:8, rangeStart:8,
+// rangeStop:8, tombstoneCutoff:8, and for each subrange hash:8, count:4
+const _MSG_PULL_REPLICATION_MERKLE = 0x4d3e9a71c0b25f86
+const _PULL_REPLICATION_MERKLE_MSG_LENGTH = 52 + _PULL_REPLICATION_MERKLE_FANOUT*12
+
+// prmm: responderNodeID:8, ringVersion:8, partition:4, cutoff:8,
+// rangeStart:8, rangeStop:8, tombstoneCutoff:8, differing:2
+const _MSG_PULL_REPLICATION_MERKLE_RESPONSE = 0xb17c2e5a94d0386f
+const _PULL_REPLICATION_MERKLE_RESPONSE_MSG_LENGTH = 54
+
+const _PULL_REPLICATION_MERKLE_FANOUT = 16
+
+// _PULL_REPLICATION_MERKLE_LEAF_KEYS is the most keys a differing subrange
+// may have for it to be shipped rather than descended into.
+const _PULL_REPLICATION_MERKLE_LEAF_KEYS = 256
+
+type pullReplicationMerkleMsg struct {
+	vs       *DefaultValueStore
+	body     []byte
+	response bool
+	peerFlow []uint64
+}
+
+// pullReplicationMerkleLevel is a level of the hash tree for a range; see
+// vs.pullReplicationMerkleLevel.
+type pullReplicationMerkleLevel struct {
+	hashes [_PULL_REPLICATION_MERKLE_FANOUT]uint64
+	counts [_PULL_REPLICATION_MERKLE_FANOUT]uint32
+}
+
+// pullReplicationMerkleChild returns the bounds of subrange i of the range
+// rangeStart to rangeStop; ok is false if the range is too small to have a
+// subrange i.
+func pullReplicationMerkleChild(rangeStart uint64, rangeStop uint64, i int) (start uint64, stop uint64, ok bool) {
+	width := (rangeStop-rangeStart)/_PULL_REPLICATION_MERKLE_FANOUT + 1
+	offset := width * uint64(i)
+	if offset > rangeStop-rangeStart {
+		return 0, 0, false
+	}
+	start = rangeStart + offset
+	stop = start + width - 1
+	if i == _PULL_REPLICATION_MERKLE_FANOUT-1 || stop < start || stop > rangeStop {
+		stop = rangeStop
+	}
+	return start, stop, true
+}
+
+// pullReplicationMerkleLevel scans the range rangeStart to rangeStop and
+// returns its level of the hash tree. Items newer than cutoff, locally
+// removed, or deletions older than tombstoneCutoff are left out; the
+// timestamps are hashed without the util bits other than _TSB_DELETION, as
+// the rest only have local meaning.
+func (vs *DefaultValueStore) pullReplicationMerkleLevel(rangeStart uint64, rangeStop uint64, cutoff uint64, tombstoneCutoff uint64) *pullReplicationMerkleLevel {
+	level := &pullReplicationMerkleLevel{}
+	width := (rangeStop-rangeStart)/_PULL_REPLICATION_MERKLE_FANOUT + 1
+	var b [24]byte
+	vs.vlm.ScanCallback(rangeStart, rangeStop, 0, _TSB_LOCAL_REMOVAL, cutoff, math.MaxUint64, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+		if timestampbits&_TSB_DELETION != 0 && timestampbits < tombstoneCutoff {
+			return true
+		}
+		binary.BigEndian.PutUint64(b[:], keyA)
+		binary.BigEndian.PutUint64(b[8:], keyB)
+		binary.BigEndian.PutUint64(b[16:], timestampbits>>_TSB_UTIL_BITS<<_TSB_UTIL_BITS|timestampbits&_TSB_DELETION)
+		i := (keyA - rangeStart) / width
+		level.hashes[i] ^= murmur3.Sum64(b[:])
+		level.counts[i]++
+		return true
+	})
+	return level
+}
+
+// outPullReplicationMerkle sends a merkle pull-replication request for the
+// range rangeStart to rangeStop of the partition to the other replicas.
+func (vs *DefaultValueStore) outPullReplicationMerkle(ringVersion int64, partition uint32, cutoff uint64, rangeStart uint64, rangeStop uint64) {
+	tombstoneCutoff := (uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS) - atomic.LoadUint64(&vs.tombstoneDiscardState.age)
+	prmm := vs.newOutPullReplicationMerkleMsg(ringVersion, partition, cutoff, rangeStart, rangeStop, tombstoneCutoff)
+	prmm.setLevel(vs.pullReplicationMerkleLevel(rangeStart, rangeStop, cutoff, tombstoneCutoff))
+	if vs.msgToOtherReplicas(prmm, partition, vs.pullReplicationState.outMsgTimeout) {
+		atomic.AddInt32(&vs.outPullReplicationMerkles, 1)
+	}
+}
+
+// newOutPullReplicationMerkleMsg gives a pullReplicationMerkleMsg with the
+// fields common to requests and responses filled out. These are small enough
+// that they aren't pooled.
+func (vs *DefaultValueStore) newOutPullReplicationMerkleMsg(ringVersion int64, partition uint32, cutoff uint64, rangeStart uint64, rangeStop uint64, tombstoneCutoff uint64) *pullReplicationMerkleMsg {
+	prmm := &pullReplicationMerkleMsg{
+		vs:   vs,
+		body: make([]byte, _PULL_REPLICATION_MERKLE_MSG_LENGTH),
+	}
+	if r := vs.msgRing.Ring(); r != nil {
+		if n := r.LocalNode(); n != nil {
+			binary.BigEndian.PutUint64(prmm.body, n.ID())
+		}
+	}
+	binary.BigEndian.PutUint64(prmm.body[8:], uint64(ringVersion))
+	binary.BigEndian.PutUint32(prmm.body[16:], partition)
+	binary.BigEndian.PutUint64(prmm.body[20:], cutoff)
+	binary.BigEndian.PutUint64(prmm.body[28:], rangeStart)
+	binary.BigEndian.PutUint64(prmm.body[36:], rangeStop)
+	binary.BigEndian.PutUint64(prmm.body[44:], tombstoneCutoff)
+	return prmm
+}
+
+// newInPullReplicationMerkleMsg reads merkle pull-replication requests from
+// the MsgRing and puts them on the inMerkleChan for the
+// inPullReplicationMerkle workers to work on.
+func (vs *DefaultValueStore) newInPullReplicationMerkleMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInPullReplicationMerkleMsg(r, l, false)
+}
+
+// newInPullReplicationMerkleResponseMsg is the newInPullReplicationMerkleMsg
+// for responses; these go to the same workers, as they lead to new requests.
+func (vs *DefaultValueStore) newInPullReplicationMerkleResponseMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInPullReplicationMerkleMsg(r, l, true)
+}
+
+func (vs *DefaultValueStore) readInPullReplicationMerkleMsg(r io.Reader, l uint64, response bool) (uint64, error) {
+	expected := uint64(_PULL_REPLICATION_MERKLE_MSG_LENGTH)
+	if response {
+		expected = _PULL_REPLICATION_MERKLE_RESPONSE_MSG_LENGTH
+	}
+	if l != expected {
+		left := l
+		var sn int
+		var err error
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err = r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+				return l - left, err
+			}
+		}
+		atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+		return l, nil
+	}
+	prmm := &pullReplicationMerkleMsg{vs: vs, body: make([]byte, l), response: response}
+	var n int
+	var sn int
+	var err error
+	for n != len(prmm.body) {
+		sn, err = r.Read(prmm.body[n:])
+		n += sn
+		if err != nil && n != len(prmm.body) {
+			atomic.AddInt32(&vs.inPullReplicationInvalids, 1)
+			return uint64(n), err
+		}
+	}
+	select {
+	case vs.pullReplicationState.inMerkleChan <- prmm:
+		atomic.AddInt32(&vs.inPullReplicationMerkles, 1)
+	default:
+		atomic.AddInt32(&vs.inPullReplicationDrops, 1)
+	}
+	return l, nil
+}
+
+// inPullReplicationMerkle actually processes incoming merkle
+// pull-replication requests and responses; there may be more than one of
+// these workers.
+func (vs *DefaultValueStore) inPullReplicationMerkle() {
+	k := make([]uint64, vs.bulkSetState.msgCap/_BULK_SET_MSG_MIN_ENTRY_LENGTH)
+	v := make([]byte, vs.valueCap)
+	for {
+		var prmm *pullReplicationMerkleMsg
+		select {
+		case prmm = <-vs.pullReplicationState.inMerkleChan:
+		case <-vs.closeState.backgroundChan:
+		}
+		if prmm == nil {
+			break
+		}
+		ring := vs.msgRing.Ring()
+		if ring == nil {
+			continue
+		}
+		if prmm.response {
+			vs.inPullReplicationMerkleResponse(ring.Version(), prmm)
+			continue
+		}
+		cutoff := prmm.cutoff()
+		tombstoneCutoff := prmm.tombstoneCutoff()
+		rangeStart := prmm.rangeStart()
+		rangeStop := prmm.rangeStop()
+		level := vs.pullReplicationMerkleLevel(rangeStart, rangeStop, cutoff, tombstoneCutoff)
+		k = k[:0]
+		var differing uint16
+		for i := 0; i < _PULL_REPLICATION_MERKLE_FANOUT; i++ {
+			hash, count := prmm.hash(i)
+			if level.hashes[i] == hash && level.counts[i] == count {
+				continue
+			}
+			atomic.AddInt32(&vs.pullReplicationMerkleDiffs, 1)
+			if level.counts[i] == 0 {
+				continue
+			}
+			start, stop, _ := pullReplicationMerkleChild(rangeStart, rangeStop, i)
+			if level.counts[i] > _PULL_REPLICATION_MERKLE_LEAF_KEYS && stop-start >= _PULL_REPLICATION_MERKLE_FANOUT {
+				differing |= 1 << uint(i)
+				continue
+			}
+			vs.vlm.ScanCallback(start, stop, 0, _TSB_LOCAL_REMOVAL, cutoff, math.MaxUint64, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+				if timestampbits&_TSB_DELETION == 0 || timestampbits >= tombstoneCutoff {
+					k = append(k, keyA, keyB)
+				}
+				return true
+			})
+		}
+		nodeID := prmm.nodeID()
+		v = vs.outPullReplicationKeys(nodeID, prmm.partition(), dedupeKeyList(k), v)
+		if differing != 0 {
+			prmmr := vs.newOutPullReplicationMerkleMsg(prmm.ringVersion(), prmm.partition(), cutoff, rangeStart, rangeStop, tombstoneCutoff)
+			prmmr.response = true
+			prmmr.body = prmmr.body[:_PULL_REPLICATION_MERKLE_RESPONSE_MSG_LENGTH]
+			binary.BigEndian.PutUint16(prmmr.body[52:], differing)
+			vs.msgToNode(prmmr, nodeID, vs.pullReplicationState.inResponseMsgTimeout)
+		}
+	}
+	vs.closeState.backgroundWG.Done()
+}
+
+// inPullReplicationMerkleResponse sends requests for the next level down of
+// each differing subrange named in the response, so long as the ring hasn't
+// changed since the original request and outgoing pull replication hasn't
+// been stopped.
+func (vs *DefaultValueStore) inPullReplicationMerkleResponse(ringVersion int64, prmm *pullReplicationMerkleMsg) {
+	if prmm.ringVersion() != ringVersion || atomic.LoadUint32(&vs.pullReplicationState.outAbort) != 0 {
+		return
+	}
+	cutoff := prmm.cutoff()
+	tombstoneCutoff := prmm.tombstoneCutoff()
+	rangeStart := prmm.rangeStart()
+	rangeStop := prmm.rangeStop()
+	differing := binary.BigEndian.Uint16(prmm.body[52:])
+	for i := 0; i < _PULL_REPLICATION_MERKLE_FANOUT; i++ {
+		if differing&(1<<uint(i)) == 0 {
+			continue
+		}
+		start, stop, ok := pullReplicationMerkleChild(rangeStart, rangeStop, i)
+		if !ok {
+			continue
+		}
+		prmmr := vs.newOutPullReplicationMerkleMsg(ringVersion, prmm.partition(), cutoff, start, stop, tombstoneCutoff)
+		prmmr.setLevel(vs.pullReplicationMerkleLevel(start, stop, cutoff, tombstoneCutoff))
+		if vs.msgToNode(prmmr, prmm.nodeID(), vs.pullReplicationState.outMsgTimeout) {
+			atomic.AddInt32(&vs.outPullReplicationMerkles, 1)
+		}
+	}
+}
+
+func (prmm *pullReplicationMerkleMsg) setLevel(level *pullReplicationMerkleLevel) {
+	for i := 0; i < _PULL_REPLICATION_MERKLE_FANOUT; i++ {
+		binary.BigEndian.PutUint64(prmm.body[52+i*12:], level.hashes[i])
+		binary.BigEndian.PutUint32(prmm.body[60+i*12:], level.counts[i])
+	}
+}
+
+func (prmm *pullReplicationMerkleMsg) hash(i int) (uint64, uint32) {
+	return binary.BigEndian.Uint64(prmm.body[52+i*12:]), binary.BigEndian.Uint32(prmm.body[60+i*12:])
+}
+
+func (prmm *pullReplicationMerkleMsg) nodeID() uint64 {
+	return binary.BigEndian.Uint64(prmm.body)
+}
+
+func (prmm *pullReplicationMerkleMsg) ringVersion() int64 {
+	return int64(binary.BigEndian.Uint64(prmm.body[8:]))
+}
+
+func (prmm *pullReplicationMerkleMsg) partition() uint32 {
+	return binary.BigEndian.Uint32(prmm.body[16:])
+}
+
+func (prmm *pullReplicationMerkleMsg) cutoff() uint64 {
+	return binary.BigEndian.Uint64(prmm.body[20:])
+}
+
+func (prmm *pullReplicationMerkleMsg) rangeStart() uint64 {
+	return binary.BigEndian.Uint64(prmm.body[28:])
+}
+
+func (prmm *pullReplicationMerkleMsg) rangeStop() uint64 {
+	return binary.BigEndian.Uint64(prmm.body[36:])
+}
+
+func (prmm *pullReplicationMerkleMsg) tombstoneCutoff() uint64 {
+	return binary.BigEndian.Uint64(prmm.body[44:])
+}
+
+func (prmm *pullReplicationMerkleMsg) MsgType() uint64 {
+	if prmm.response {
+		return _MSG_PULL_REPLICATION_MERKLE_RESPONSE
+	}
+	return _MSG_PULL_REPLICATION_MERKLE
+}
+
+func (prmm *pullReplicationMerkleMsg) MsgLength() uint64 {
+	return uint64(len(prmm.body))
+}
+
+func (prmm *pullReplicationMerkleMsg) WriteContent(w io.Writer) (uint64, error) {
+	n, err := w.Write(prmm.body)
+	return uint64(n), err
+}
+
+func (prmm *pullReplicationMerkleMsg) Free() {
+	prmm.vs.peerFlowFree(prmm)
+}
+
+func (prmm *pullReplicationMerkleMsg) peerFlowNodeIDs() *[]uint64 {
+	return &prmm.peerFlow
+}
@@ -0,0 +1,156 @@
+package valuestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+// msgRingMerkleTester delivers every message straight to the handlers of its
+// peer, so two stores can replicate with each other.
+type msgRingMerkleTester struct {
+	ring     ring.Ring
+	lock     sync.Mutex
+	handlers map[uint64]ring.MsgUnmarshaller
+	peer     *msgRingMerkleTester
+	bytes    uint64
+}
+
+func (m *msgRingMerkleTester) Ring() ring.Ring {
+	return m.ring
+}
+
+func (m *msgRingMerkleTester) MaxMsgLength() uint64 {
+	return 65536
+}
+
+func (m *msgRingMerkleTester) SetMsgHandler(msgType uint64, handler ring.MsgUnmarshaller) {
+	m.lock.Lock()
+	if m.handlers == nil {
+		m.handlers = make(map[uint64]ring.MsgUnmarshaller)
+	}
+	m.handlers[msgType] = handler
+	m.lock.Unlock()
+}
+
+func (m *msgRingMerkleTester) MsgToNode(msg ring.Msg, nodeID uint64, timeout time.Duration) {
+	buf := &bytes.Buffer{}
+	msg.WriteContent(buf)
+	msg.Free()
+	m.lock.Lock()
+	m.bytes += uint64(buf.Len())
+	m.lock.Unlock()
+	m.peer.lock.Lock()
+	handler := m.peer.handlers[msg.MsgType()]
+	m.peer.lock.Unlock()
+	if handler != nil {
+		handler(buf, uint64(buf.Len()))
+	}
+}
+
+func (m *msgRingMerkleTester) MsgToOtherReplicas(msg ring.Msg, partition uint32, timeout time.Duration) {
+	m.MsgToNode(msg, 0, timeout)
+}
+
+func TestPullReplicationMerkle(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n1, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1 := b.Ring()
+	r1.SetLocalNode(n1.ID())
+	r2 := b.Ring()
+	r2.SetLocalNode(n2.ID())
+	m1 := &msgRingMerkleTester{ring: r1}
+	m2 := &msgRingMerkleTester{ring: r2, peer: m1}
+	m1.peer = m2
+	dir1, err := ioutil.TempDir("", "valuestoremerkle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir1)
+	dir2, err := ioutil.TempDir("", "valuestoremerkle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+	// Messages are delivered faster than they could be over a network, so
+	// the incoming queues need room for a whole pass's worth.
+	vs1 := New(&Config{Path: dir1, MsgRing: m1, OutPullReplicationMode: "merkle", InPullReplicationMsgs: 1024, InBulkSetMsgs: 32, InBulkSetPeerMsgs: 32})
+	vs1.EnableWrites()
+	defer vs1.Close()
+	vs2 := New(&Config{Path: dir2, MsgRing: m2, OutPullReplicationMode: "merkle", InPullReplicationMsgs: 1024})
+	vs2.EnableWrites()
+	defer vs2.Close()
+	// Both have the same 2000 items, all close together so the tree has to
+	// be descended into, and the second another 20 spread over the keyspace
+	// plus a newer version of one the first has.
+	value := []byte("testing")
+	for i := uint64(0); i < 2000; i++ {
+		keyA := i << 40
+		if _, err = vs1.write(keyA, i, 0x300, value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = vs2.write(keyA, i, 0x300, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(0); i < 20; i++ {
+		if _, err = vs2.write(i*0x0ccccccccccccccc+1, i, 0x300, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = vs2.write(7<<40, 7, 0x400, []byte("newer")); err != nil {
+		t.Fatal(err)
+	}
+	synced := func() bool {
+		for i := uint64(0); i < 20; i++ {
+			if _, v, err := vs1.Read(i*0x0ccccccccccccccc+1, i, nil); err != nil || string(v) != "testing" {
+				return false
+			}
+		}
+		ts, v, err := vs1.read(7<<40, 7, nil)
+		return err == nil && ts>>_TSB_UTIL_BITS == 4 && string(v) == "newer"
+	}
+	vs1.OutPullReplicationPass()
+	for i := 0; i < 100 && !synced(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !synced() {
+		t.Fatal(vs1.Stats(false), vs2.Stats(false))
+	}
+	// Stats resets the counters, so each is only gathered once.
+	stats1 := vs1.Stats(false).(*Stats)
+	stats2 := vs2.Stats(false).(*Stats)
+	if stats1.Values != 2020 || stats1.OutPullReplicationMerkles == 0 || stats2.PullReplicationMerkleDiffs == 0 {
+		t.Fatal(stats1.Values, stats1.OutPullReplicationMerkles, stats2.PullReplicationMerkleDiffs)
+	}
+	// Once in sync, a pass is just the top level requests.
+	m1.lock.Lock()
+	m1.bytes = 0
+	m1.lock.Unlock()
+	vs1.OutPullReplicationPass()
+	time.Sleep(50 * time.Millisecond)
+	stats1 = vs1.Stats(false).(*Stats)
+	stats2 = vs2.Stats(false).(*Stats)
+	if stats2.PullReplicationMerkleDiffs != 0 || stats2.OutBulkSets != 0 {
+		t.Fatal(stats2.PullReplicationMerkleDiffs, stats2.OutBulkSets)
+	}
+	m1.lock.Lock()
+	sent := m1.bytes
+	m1.lock.Unlock()
+	if sent != uint64(stats1.OutPullReplicationMerkles)*_PULL_REPLICATION_MERKLE_MSG_LENGTH {
+		t.Fatal(sent, stats1.OutPullReplicationMerkles)
+	}
+}
@@ -0,0 +1,266 @@
+package valuestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// pushBacklog: header:32 entries:n
+// pushBacklog entry: keyA:8, keyB:8
+const _PUSH_BACKLOG_HEADER = "VALUESTOREPUSHBACKLOG v0        "
+const _PUSH_BACKLOG_ENTRY_LENGTH = 16
+const _PUSH_BACKLOG_NAME = "valuestore.pushbacklog"
+
+// pushBacklogState remembers the keys push replication has sent but has not
+// yet had acked, saving them to a file every so often. After a restart, the
+// first push replication pass resends those keys right away rather than
+// waiting for scans to rediscover them.
+type pushBacklogState struct {
+	max      int
+	interval time.Duration
+	name     string
+	lock     sync.Mutex
+	keys     map[bulkSetKey]struct{}
+	dirty    bool
+	// resend is set when keys were loaded from the file and have yet to be
+	// resent.
+	resend bool
+}
+
+func (vs *DefaultValueStore) pushBacklogConfig(cfg *Config) {
+	vs.pushBacklogState.max = cfg.OutPushReplicationBacklog
+	vs.pushBacklogState.interval = time.Duration(cfg.OutPushReplicationBacklogInterval) * time.Second
+	vs.pushBacklogState.name = path.Join(vs.pathtoc, _PUSH_BACKLOG_NAME)
+	vs.pushBacklogState.keys = make(map[bulkSetKey]struct{})
+	// With no acks coming back there would be no way to know when keys are
+	// done with.
+	if cfg.OutPushReplicationAckPolicy == BULK_SET_ACK_NONE {
+		vs.pushBacklogState.max = 0
+	}
+	if vs.pushBacklogState.max > 0 {
+		vs.pushBacklogLoad()
+	}
+}
+
+func (vs *DefaultValueStore) pushBacklogLaunch() {
+	if vs.pushBacklogState.max > 0 {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.pushBacklogSaver()
+	}
+}
+
+// pushBacklogAdd records a key sent by push replication; it is ignored if
+// the backlog is already full.
+func (vs *DefaultValueStore) pushBacklogAdd(keyA uint64, keyB uint64) {
+	s := &vs.pushBacklogState
+	if s.max <= 0 {
+		return
+	}
+	s.lock.Lock()
+	if len(s.keys) < s.max {
+		s.keys[bulkSetKey{keyA, keyB}] = struct{}{}
+		s.dirty = true
+	}
+	s.lock.Unlock()
+}
+
+// pushBacklogRemove forgets the keys of the batch entries that were
+// successfully marked as acked.
+func (vs *DefaultValueStore) pushBacklogRemove(batch []valueWriteBatchEntry) {
+	s := &vs.pushBacklogState
+	if s.max <= 0 {
+		return
+	}
+	s.lock.Lock()
+	for i := range batch {
+		if batch[i].err == nil {
+			k := bulkSetKey{batch[i].keyA, batch[i].keyB}
+			if _, ok := s.keys[k]; ok {
+				delete(s.keys, k)
+				s.dirty = true
+			}
+		}
+	}
+	s.lock.Unlock()
+}
+
+func (vs *DefaultValueStore) pushBacklogSaver() {
+	for vs.closeSleep(vs.pushBacklogState.interval) {
+		vs.pushBacklogSave()
+	}
+	vs.closeState.backgroundWG.Done()
+}
+
+// pushBacklogSave writes out the backlog if it has changed since it was last
+// written. The file is written under a temporary name and then renamed so
+// that a crash midway leaves the previous file intact.
+func (vs *DefaultValueStore) pushBacklogSave() {
+	s := &vs.pushBacklogState
+	s.lock.Lock()
+	if !s.dirty {
+		s.lock.Unlock()
+		return
+	}
+	keys := make([]bulkSetKey, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	s.dirty = false
+	s.lock.Unlock()
+	if err := writePushBacklog(s.name, keys); err != nil {
+		vs.logError("error saving push backlog %s: %s\n", s.name, err)
+		s.lock.Lock()
+		s.dirty = true
+		s.lock.Unlock()
+		return
+	}
+	atomic.AddInt32(&vs.outPushBacklogSaves, 1)
+}
+
+func writePushBacklog(name string, keys []bulkSetKey) error {
+	fp, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fp)
+	b := make([]byte, _PUSH_BACKLOG_ENTRY_LENGTH)
+	_, err = w.WriteString(_PUSH_BACKLOG_HEADER)
+	for i := 0; err == nil && i < len(keys); i++ {
+		binary.BigEndian.PutUint64(b, keys[i].keyA)
+		binary.BigEndian.PutUint64(b[8:], keys[i].keyB)
+		_, err = w.Write(b)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func (vs *DefaultValueStore) pushBacklogLoad() {
+	s := &vs.pushBacklogState
+	fp, err := os.Open(s.name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			vs.logError("error opening push backlog %s: %s\n", s.name, err)
+		}
+		return
+	}
+	defer fp.Close()
+	r := bufio.NewReader(fp)
+	b := make([]byte, len(_PUSH_BACKLOG_HEADER))
+	if _, err = io.ReadFull(r, b); err != nil || string(b) != _PUSH_BACKLOG_HEADER {
+		vs.logError("bad header in push backlog %s\n", s.name)
+		return
+	}
+	b = b[:_PUSH_BACKLOG_ENTRY_LENGTH]
+	for len(s.keys) < s.max {
+		// Any partial trailing entry is just dropped.
+		if _, err = io.ReadFull(r, b); err != nil {
+			break
+		}
+		s.keys[bulkSetKey{binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])}] = struct{}{}
+	}
+	s.resend = len(s.keys) > 0
+}
+
+// outPushBacklogPass resends the keys loaded from the backlog file, if that
+// hasn't been done already. Keys that no longer need sending are dropped from
+// the backlog.
+func (vs *DefaultValueStore) outPushBacklogPass() {
+	s := &vs.pushBacklogState
+	s.lock.Lock()
+	if !s.resend {
+		s.lock.Unlock()
+		return
+	}
+	s.resend = false
+	keys := make([]bulkSetKey, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	s.lock.Unlock()
+	ring := vs.msgRing.Ring()
+	if ring == nil {
+		return
+	}
+	rightwardPartitionShift := 64 - uint64(ring.PartitionBitCount())
+	partitions := make(map[uint32][]bulkSetKey)
+	var drop []bulkSetKey
+	for _, k := range keys {
+		partition := uint32(k.keyA >> rightwardPartitionShift)
+		if ring.Responsible(partition) {
+			drop = append(drop, k)
+			continue
+		}
+		partitions[partition] = append(partitions[partition], k)
+	}
+	tombstoneCutoff := vs.tombstonePushCutoff(uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS)
+	valbuf := make([]byte, vs.valueCap)
+	for partition, pkeys := range partitions {
+		if atomic.LoadUint32(&vs.pushReplicationState.outAbort) != 0 {
+			break
+		}
+		var bsm *bulkSetMsg
+		for _, k := range pkeys {
+			timestampbits, v, err := vs.read(k.keyA, k.keyB, valbuf[:0])
+			if err == ErrNotFound {
+				if timestampbits == 0 {
+					drop = append(drop, k)
+					continue
+				}
+			} else if err != nil {
+				continue
+			}
+			if timestampbits&_TSB_LOCAL_REMOVAL != 0 || (timestampbits&_TSB_DELETION != 0 && timestampbits < tombstoneCutoff) {
+				drop = append(drop, k)
+				continue
+			}
+			if bsm != nil && !bsm.add(k.keyA, k.keyB, timestampbits, v) {
+				vs.outPushBacklogSend(bsm, partition)
+				bsm = nil
+			}
+			if bsm == nil {
+				bsm = vs.newOutBulkSetMsg()
+				bsm.setAckPolicy(vs.pushReplicationState.outAckPolicy)
+				bsm.setBackfill()
+				bsm.add(k.keyA, k.keyB, timestampbits, v)
+			}
+			atomic.AddInt32(&vs.outBulkSetPushValues, 1)
+		}
+		if bsm != nil {
+			vs.outPushBacklogSend(bsm, partition)
+		}
+	}
+	if len(drop) > 0 {
+		s.lock.Lock()
+		for _, k := range drop {
+			delete(s.keys, k)
+		}
+		s.dirty = true
+		s.lock.Unlock()
+	}
+}
+
+func (vs *DefaultValueStore) outPushBacklogSend(bsm *bulkSetMsg, partition uint32) {
+	if vs.msgToOtherReplicas(bsm, partition, vs.pushReplicationState.outMsgTimeout) {
+		atomic.AddInt32(&vs.outBulkSetPushes, 1)
+	}
+}
@@ -0,0 +1,45 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPushBacklogSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pushbacklog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir})
+	vs.pushBacklogAdd(1, 2)
+	vs.pushBacklogAdd(3, 4)
+	vs.pushBacklogRemove([]valueWriteBatchEntry{{keyA: 3, keyB: 4}, {keyA: 5, keyB: 6}})
+	vs.pushBacklogSave()
+	if stats := vs.Stats(false).(*Stats); stats.OutPushBacklogSaves != 1 {
+		t.Fatal(stats.OutPushBacklogSaves)
+	}
+	// Nothing changed, so nothing to save.
+	vs.pushBacklogSave()
+	if stats := vs.Stats(false).(*Stats); stats.OutPushBacklogSaves != 0 {
+		t.Fatal(stats.OutPushBacklogSaves)
+	}
+	vs.Close()
+	vs2 := New(&Config{Path: dir})
+	if !vs2.pushBacklogState.resend {
+		t.Fatal("")
+	}
+	if len(vs2.pushBacklogState.keys) != 1 {
+		t.Fatal(vs2.pushBacklogState.keys)
+	}
+	if _, ok := vs2.pushBacklogState.keys[bulkSetKey{1, 2}]; !ok {
+		t.Fatal(vs2.pushBacklogState.keys)
+	}
+	vs2.Close()
+	vs3 := New(&Config{Path: dir, OutPushReplicationBacklog: -1})
+	defer vs3.Close()
+	if vs3.pushBacklogState.resend || len(vs3.pushBacklogState.keys) != 0 {
+		t.Fatal(vs3.pushBacklogState.keys)
+	}
+}
@@ -11,48 +11,41 @@ import (
 
 type pushReplicationState struct {
 	outWorkers    int
-	outInterval   int
+	outInterval   int64
 	outNotifyChan chan *backgroundNotification
 	outAbort      uint32
 	outMsgChan    chan *pullReplicationMsg
 	outLists      [][]uint64
 	outValBufs    [][]byte
 	outMsgTimeout time.Duration
+	outAckPolicy  int
 }
 
 func (vs *DefaultValueStore) pushReplicationConfig(cfg *Config) {
 	vs.pushReplicationState.outWorkers = cfg.OutPushReplicationWorkers
-	vs.pushReplicationState.outInterval = cfg.OutPushReplicationInterval
+	vs.pushReplicationState.outInterval = int64(cfg.OutPushReplicationInterval)
 	if vs.msgRing != nil {
 		vs.pushReplicationState.outMsgChan = make(chan *pullReplicationMsg, cfg.OutPushReplicationMsgs)
 	}
 	vs.pushReplicationState.outNotifyChan = make(chan *backgroundNotification, 1)
 	vs.pushReplicationState.outMsgTimeout = time.Duration(cfg.OutPushReplicationMsgTimeout) * time.Millisecond
+	vs.pushReplicationState.outAckPolicy = cfg.OutPushReplicationAckPolicy
 }
 
 func (vs *DefaultValueStore) pushReplicationLaunch() {
+	vs.closeState.backgroundWG.Add(1)
 	go vs.outPushReplicationLauncher()
 }
 
 // DisableOutPushReplication will stop any outgoing push replication requests
 // until EnableOutPushReplication is called.
 func (vs *DefaultValueStore) DisableOutPushReplication() {
-	c := make(chan struct{}, 1)
-	vs.pushReplicationState.outNotifyChan <- &backgroundNotification{
-		disable:  true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.pushReplicationState.outNotifyChan, &backgroundNotification{disable: true})
 }
 
 // EnableOutPushReplication will resume outgoing push replication requests.
 func (vs *DefaultValueStore) EnableOutPushReplication() {
-	c := make(chan struct{}, 1)
-	vs.pushReplicationState.outNotifyChan <- &backgroundNotification{
-		enable:   true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.pushReplicationState.outNotifyChan, &backgroundNotification{enable: true})
 }
 
 // OutPushReplicationPass will immediately execute an outgoing push replication
@@ -64,14 +57,13 @@ func (vs *DefaultValueStore) EnableOutPushReplication() {
 // and so synchronization at that level is not possible.
 func (vs *DefaultValueStore) OutPushReplicationPass() {
 	atomic.StoreUint32(&vs.pushReplicationState.outAbort, 1)
-	c := make(chan struct{}, 1)
-	vs.pushReplicationState.outNotifyChan <- &backgroundNotification{doneChan: c}
-	<-c
+	vs.backgroundNotify(vs.pushReplicationState.outNotifyChan, &backgroundNotification{})
 }
 
 func (vs *DefaultValueStore) outPushReplicationLauncher() {
+	defer vs.closeState.backgroundWG.Done()
 	var enabled bool
-	interval := float64(vs.pushReplicationState.outInterval) * float64(time.Second)
+	interval := float64(atomic.LoadInt64(&vs.pushReplicationState.outInterval)) * float64(time.Second)
 	vs.randMutex.Lock()
 	nextRun := time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 	vs.randMutex.Unlock()
@@ -82,13 +74,18 @@ func (vs *DefaultValueStore) outPushReplicationLauncher() {
 			select {
 			case notification = <-vs.pushReplicationState.outNotifyChan:
 			case <-time.After(sleep):
+			case <-vs.closeState.backgroundChan:
+				return
 			}
 		} else {
 			select {
 			case notification = <-vs.pushReplicationState.outNotifyChan:
+			case <-vs.closeState.backgroundChan:
+				return
 			default:
 			}
 		}
+		interval = float64(atomic.LoadInt64(&vs.pushReplicationState.outInterval)) * float64(time.Second)
 		vs.randMutex.Lock()
 		nextRun = time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 		vs.randMutex.Unlock()
@@ -128,6 +125,7 @@ func (vs *DefaultValueStore) outPushReplicationPass() {
 	if ring == nil {
 		return
 	}
+	vs.outPushBacklogPass()
 	ringVersion := ring.Version()
 	pbc := ring.PartitionBitCount()
 	partitionShift := uint64(64 - pbc)
@@ -158,8 +156,8 @@ func (vs *DefaultValueStore) outPushReplicationPass() {
 			}
 		}
 		timestampbitsNow := uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS
-		cutoff := timestampbitsNow - vs.replicationIgnoreRecent
-		tombstoneCutoff := timestampbitsNow - vs.tombstoneDiscardState.age
+		cutoff := timestampbitsNow - atomic.LoadUint64(&vs.replicationIgnoreRecent)
+		tombstoneCutoff := vs.tombstonePushCutoff(timestampbitsNow)
 		availableBytes := int64(vs.bulkSetState.msgCap)
 		list = list[:0]
 		// We ignore the "more" option from ScanCallback and just send the
@@ -181,12 +179,17 @@ func (vs *DefaultValueStore) outPushReplicationPass() {
 		if len(list) <= 0 || atomic.LoadUint32(&vs.pushReplicationState.outAbort) != 0 {
 			return
 		}
+		list = dedupeKeyList(list)
 		ring2 := vs.msgRing.Ring()
 		if ring2 == nil || ring2.Version() != ringVersion {
 			return
 		}
 		// Then we build and send the actual message.
-		bsm := vs.newOutBulkSetMsg()
+		bsm := vs.newOutReplicationBulkSetMsg()
+		bsm.setAckPolicy(vs.pushReplicationState.outAckPolicy)
+		// This is a handoff, so have the receiver pass the values on to
+		// the other replicas right away.
+		bsm.setBackfill()
 		var timestampbits uint64
 		var err error
 		for i := 0; i < len(list); i += 2 {
@@ -202,14 +205,16 @@ func (vs *DefaultValueStore) outPushReplicationPass() {
 				continue
 			}
 			if timestampbits&_TSB_LOCAL_REMOVAL == 0 && timestampbits < cutoff && (timestampbits&_TSB_DELETION == 0 || timestampbits >= tombstoneCutoff) {
-				if !bsm.add(list[i], list[i+1], timestampbits, valbuf) {
+				if !vs.outReplicationAdd(bsm, list[i], list[i+1], timestampbits, valbuf) {
 					break
 				}
 				atomic.AddInt32(&vs.outBulkSetPushValues, 1)
+				vs.pushBacklogAdd(list[i], list[i+1])
 			}
 		}
-		atomic.AddInt32(&vs.outBulkSetPushes, 1)
-		vs.msgRing.MsgToOtherReplicas(bsm, uint32(partition), vs.pushReplicationState.outMsgTimeout)
+		if vs.msgToOtherReplicas(bsm, uint32(partition), vs.pushReplicationState.outMsgTimeout) {
+			atomic.AddInt32(&vs.outBulkSetPushes, 1)
+		}
 	}
 	wg := &sync.WaitGroup{}
 	wg.Add(int(workerMax + 1))
@@ -217,6 +222,7 @@ func (vs *DefaultValueStore) outPushReplicationPass() {
 		go func(worker uint64) {
 			list := vs.pushReplicationState.outLists[worker]
 			valbuf := vs.pushReplicationState.outValBufs[worker]
+			pacer := vs.newBackgroundPacer()
 			partitionBegin := (partitionMax + 1) / (workerMax + 1) * worker
 			for partition := partitionBegin; ; {
 				if atomic.LoadUint32(&vs.pushReplicationState.outAbort) != 0 {
@@ -228,6 +234,7 @@ func (vs *DefaultValueStore) outPushReplicationPass() {
 				}
 				if !ring.Responsible(uint32(partition)) {
 					work(partition, worker, list, valbuf)
+					pacer.pause()
 				}
 				partition++
 				if partition > partitionMax {
@@ -0,0 +1,379 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Quorum requests ask the other replicas for a partition to read or write a
+// key and answer with a quorum response; ReadQuorum and WriteQuorum wait for
+// enough responses to meet Config.Quorum.
+//
+// qrm: nodeID:8 requestID:8 op:1 keyA:8 keyB:8 timestampbits:8 value:n
+const _MSG_QUORUM_REQUEST = 0x61f0c8d7e39a2b45
+const _QUORUM_REQUEST_MSG_HEADER_LENGTH = 41
+
+// qrm: responderNodeID:8 requestID:8 status:1 timestampbits:8 value:n
+const _MSG_QUORUM_RESPONSE = 0xd82a5b4c0e7f9163
+const _QUORUM_RESPONSE_MSG_HEADER_LENGTH = 25
+
+const (
+	_QUORUM_OP_READ  = 0
+	_QUORUM_OP_WRITE = 1
+)
+
+const (
+	// _QUORUM_STATUS_OK indicates the value was read or written; for a write
+	// a newer value already in place is also ok.
+	_QUORUM_STATUS_OK = 0
+	// _QUORUM_STATUS_NOT_FOUND indicates a read found no value, though the
+	// timestampbits may be that of a deletion marker.
+	_QUORUM_STATUS_NOT_FOUND = 1
+	// _QUORUM_STATUS_ERROR indicates the read or write failed.
+	_QUORUM_STATUS_ERROR = 2
+)
+
+type quorumState struct {
+	quorum        int
+	timeout       time.Duration
+	nextRequestID uint64
+	waitingLock   sync.Mutex
+	waiting       map[uint64]chan *quorumResponse
+	inMsgChan     chan []byte
+}
+
+type quorumMsg struct {
+	response bool
+	body     []byte
+}
+
+type quorumResponse struct {
+	status        byte
+	timestampbits uint64
+	value         []byte
+}
+
+func (vs *DefaultValueStore) quorumConfig(cfg *Config) {
+	vs.quorumState.quorum = cfg.Quorum
+	vs.quorumState.timeout = time.Duration(cfg.QuorumTimeout) * time.Millisecond
+	vs.quorumState.waiting = make(map[uint64]chan *quorumResponse)
+	if vs.msgRing != nil {
+		vs.msgRing.SetMsgHandler(_MSG_QUORUM_REQUEST, vs.newInQuorumRequestMsg)
+		vs.msgRing.SetMsgHandler(_MSG_QUORUM_RESPONSE, vs.newInQuorumResponseMsg)
+		vs.quorumState.inMsgChan = make(chan []byte, cfg.Workers*4)
+	}
+}
+
+func (vs *DefaultValueStore) quorumLaunch() {
+	if vs.msgRing != nil {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.inQuorumRequest()
+	}
+}
+
+// ReadQuorum is Read coordinated with the other replicas for the key's
+// partition: it returns the newest of the answers once Config.Quorum
+// replicas, counting the local node if it is one, have answered, or
+// ErrQuorum if that many don't answer in time. A newer value from another
+// replica is also stored locally. Without a MsgRing, it is just Read.
+func (vs *DefaultValueStore) ReadQuorum(keyA uint64, keyB uint64, value []byte) (int64, []byte, error) {
+	if vs.msgRing == nil {
+		return vs.Read(keyA, keyB, value)
+	}
+	ring := vs.msgRing.Ring()
+	if ring == nil {
+		return vs.Read(keyA, keyB, value)
+	}
+	atomic.AddInt32(&vs.quorumReads, 1)
+	partition := uint32(keyA >> (64 - ring.PartitionBitCount()))
+	needed := vs.quorumNeeded(ring.ReplicaCount())
+	requestID, responses := vs.quorumWait(ring.ReplicaCount())
+	defer vs.quorumDone(requestID)
+	vs.msgRing.MsgToOtherReplicas(vs.newOutQuorumRequestMsg(requestID, _QUORUM_OP_READ, keyA, keyB, 0, nil), partition, vs.quorumState.timeout)
+	var answers int
+	var timestampbits uint64
+	var local bool
+	prefix := value
+	if ring.Responsible(partition) {
+		var err error
+		timestampbits, value, err = vs.read(keyA, keyB, value)
+		if err == nil || err == ErrNotFound {
+			answers++
+			local = true
+		}
+	}
+	localTimestampbits := timestampbits
+	t := time.NewTimer(vs.quorumState.timeout)
+	defer t.Stop()
+	for answers < needed {
+		select {
+		case qr := <-responses:
+			if qr.status == _QUORUM_STATUS_ERROR {
+				continue
+			}
+			answers++
+			if quorumNewer(qr.timestampbits, timestampbits) {
+				timestampbits = qr.timestampbits
+				value = append(prefix, qr.value...)
+				local = false
+			}
+		case <-t.C:
+			atomic.AddInt32(&vs.quorumFailures, 1)
+			return 0, prefix, ErrQuorum
+		}
+	}
+	if !local && quorumNewer(timestampbits, localTimestampbits) {
+		vs.write(keyA, keyB, timestampbits, value[len(prefix):])
+	}
+	if timestampbits == 0 || timestampbits&_TSB_DELETION != 0 {
+		return int64(timestampbits >> _TSB_UTIL_BITS), prefix, ErrNotFound
+	}
+	return int64(timestampbits >> _TSB_UTIL_BITS), value, nil
+}
+
+// WriteQuorum is Write coordinated with the other replicas for the key's
+// partition: the value is written locally and sent to them, and it returns
+// once Config.Quorum replicas, counting the local node if it is one, have
+// stored it, or ErrQuorum if that many don't in time; the value may still
+// have been stored on some of them. Without a MsgRing, it is just Write.
+func (vs *DefaultValueStore) WriteQuorum(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
+	rtimestampmicro, err := vs.Write(keyA, keyB, timestampmicro, value)
+	if err != nil || vs.msgRing == nil {
+		return rtimestampmicro, err
+	}
+	ring := vs.msgRing.Ring()
+	if ring == nil {
+		return rtimestampmicro, err
+	}
+	atomic.AddInt32(&vs.quorumWrites, 1)
+	if vs.monotonicWrites {
+		timestampmicro = rtimestampmicro
+	}
+	partition := uint32(keyA >> (64 - ring.PartitionBitCount()))
+	needed := vs.quorumNeeded(ring.ReplicaCount())
+	requestID, responses := vs.quorumWait(ring.ReplicaCount())
+	defer vs.quorumDone(requestID)
+	vs.msgRing.MsgToOtherReplicas(vs.newOutQuorumRequestMsg(requestID, _QUORUM_OP_WRITE, keyA, keyB, uint64(timestampmicro)<<_TSB_UTIL_BITS, value), partition, vs.quorumState.timeout)
+	var acks int
+	if ring.Responsible(partition) {
+		acks++
+	}
+	t := time.NewTimer(vs.quorumState.timeout)
+	defer t.Stop()
+	for acks < needed {
+		select {
+		case qr := <-responses:
+			if qr.status == _QUORUM_STATUS_OK {
+				acks++
+			}
+		case <-t.C:
+			atomic.AddInt32(&vs.quorumFailures, 1)
+			return rtimestampmicro, ErrQuorum
+		}
+	}
+	return rtimestampmicro, nil
+}
+
+// quorumNeeded gives Config.Quorum, or a majority if it is 0, capped to the
+// replica count.
+func (vs *DefaultValueStore) quorumNeeded(replicaCount int) int {
+	needed := vs.quorumState.quorum
+	if needed < 1 {
+		needed = replicaCount/2 + 1
+	}
+	if needed > replicaCount {
+		needed = replicaCount
+	}
+	return needed
+}
+
+// quorumNewer returns true if timestampbits a is newer than b; for the same
+// timestamp a deletion is newer, as with writes.
+func quorumNewer(a uint64, b uint64) bool {
+	if a>>_TSB_UTIL_BITS != b>>_TSB_UTIL_BITS {
+		return a>>_TSB_UTIL_BITS > b>>_TSB_UTIL_BITS
+	}
+	return a&_TSB_DELETION != 0 && b&_TSB_DELETION == 0
+}
+
+// quorumWait registers a new request ID and returns it with the channel its
+// responses will be sent on; quorumDone must be called once finished.
+func (vs *DefaultValueStore) quorumWait(replicaCount int) (uint64, chan *quorumResponse) {
+	requestID := atomic.AddUint64(&vs.quorumState.nextRequestID, 1)
+	responses := make(chan *quorumResponse, replicaCount)
+	vs.quorumState.waitingLock.Lock()
+	vs.quorumState.waiting[requestID] = responses
+	vs.quorumState.waitingLock.Unlock()
+	return requestID, responses
+}
+
+func (vs *DefaultValueStore) quorumDone(requestID uint64) {
+	vs.quorumState.waitingLock.Lock()
+	delete(vs.quorumState.waiting, requestID)
+	vs.quorumState.waitingLock.Unlock()
+}
+
+func (vs *DefaultValueStore) newOutQuorumRequestMsg(requestID uint64, op byte, keyA uint64, keyB uint64, timestampbits uint64, value []byte) *quorumMsg {
+	qrm := &quorumMsg{body: make([]byte, _QUORUM_REQUEST_MSG_HEADER_LENGTH+len(value))}
+	if r := vs.msgRing.Ring(); r != nil {
+		if n := r.LocalNode(); n != nil {
+			binary.BigEndian.PutUint64(qrm.body, n.ID())
+		}
+	}
+	binary.BigEndian.PutUint64(qrm.body[8:], requestID)
+	qrm.body[16] = op
+	binary.BigEndian.PutUint64(qrm.body[17:], keyA)
+	binary.BigEndian.PutUint64(qrm.body[25:], keyB)
+	binary.BigEndian.PutUint64(qrm.body[33:], timestampbits)
+	copy(qrm.body[_QUORUM_REQUEST_MSG_HEADER_LENGTH:], value)
+	return qrm
+}
+
+// newInQuorumRequestMsg is the MsgRing handler for quorum requests; they are
+// queued for inQuorumRequest, or dropped if too many are already queued.
+func (vs *DefaultValueStore) newInQuorumRequestMsg(r io.Reader, l uint64) (uint64, error) {
+	b, n, err := vs.readInQuorumMsg(r, l, _QUORUM_REQUEST_MSG_HEADER_LENGTH)
+	if b == nil {
+		return n, err
+	}
+	select {
+	case vs.quorumState.inMsgChan <- b:
+	default:
+		atomic.AddInt32(&vs.inQuorumDrops, 1)
+	}
+	return n, err
+}
+
+// newInQuorumResponseMsg is the MsgRing handler for quorum responses; they
+// are passed straight to the ReadQuorum or WriteQuorum waiting for them, if
+// it still is.
+func (vs *DefaultValueStore) newInQuorumResponseMsg(r io.Reader, l uint64) (uint64, error) {
+	b, n, err := vs.readInQuorumMsg(r, l, _QUORUM_RESPONSE_MSG_HEADER_LENGTH)
+	if b == nil {
+		return n, err
+	}
+	vs.quorumState.waitingLock.Lock()
+	responses := vs.quorumState.waiting[binary.BigEndian.Uint64(b[8:])]
+	vs.quorumState.waitingLock.Unlock()
+	if responses != nil {
+		qr := &quorumResponse{
+			status:        b[16],
+			timestampbits: binary.BigEndian.Uint64(b[17:]),
+			value:         b[_QUORUM_RESPONSE_MSG_HEADER_LENGTH:],
+		}
+		select {
+		case responses <- qr:
+		default:
+		}
+	}
+	return n, err
+}
+
+// readInQuorumMsg reads a quorum message of at least headerLength bytes,
+// returning nil if it was invalid.
+func (vs *DefaultValueStore) readInQuorumMsg(r io.Reader, l uint64, headerLength uint64) ([]byte, uint64, error) {
+	if l < headerLength || l > uint64(headerLength)+uint64(vs.valueCap) {
+		left := l
+		var sn int
+		var err error
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err = r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				break
+			}
+		}
+		atomic.AddInt32(&vs.inQuorumInvalids, 1)
+		return nil, l - left, err
+	}
+	b := make([]byte, l)
+	n, err := io.ReadFull(r, b)
+	if err != nil {
+		atomic.AddInt32(&vs.inQuorumInvalids, 1)
+		return nil, uint64(n), err
+	}
+	return b, l, nil
+}
+
+// inQuorumRequest performs the reads and writes asked for by quorum
+// requests and sends back the results. Reads go into valbuf, so each
+// response is only as large as the value it carries.
+func (vs *DefaultValueStore) inQuorumRequest() {
+	valbuf := make([]byte, vs.valueCap)
+	defer vs.closeState.backgroundWG.Done()
+	for {
+		var b []byte
+		select {
+		case b = <-vs.quorumState.inMsgChan:
+		case <-vs.closeState.backgroundChan:
+			return
+		}
+		atomic.AddInt32(&vs.inQuorumRequests, 1)
+		nodeID := binary.BigEndian.Uint64(b)
+		keyA := binary.BigEndian.Uint64(b[17:])
+		keyB := binary.BigEndian.Uint64(b[25:])
+		timestampbits := binary.BigEndian.Uint64(b[33:])
+		status := byte(_QUORUM_STATUS_OK)
+		var value []byte
+		switch b[16] {
+		case _QUORUM_OP_READ:
+			var err error
+			timestampbits, value, err = vs.read(keyA, keyB, valbuf[:0])
+			if err == ErrNotFound {
+				status = _QUORUM_STATUS_NOT_FOUND
+				if timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+					timestampbits = 0
+				}
+			} else if err != nil {
+				status = _QUORUM_STATUS_ERROR
+			}
+		case _QUORUM_OP_WRITE:
+			if _, err := vs.write(keyA, keyB, timestampbits, b[_QUORUM_REQUEST_MSG_HEADER_LENGTH:]); err != nil {
+				status = _QUORUM_STATUS_ERROR
+			}
+		default:
+			atomic.AddInt32(&vs.inQuorumInvalids, 1)
+			continue
+		}
+		if status != _QUORUM_STATUS_OK {
+			value = nil
+		}
+		qrm := &quorumMsg{response: true, body: make([]byte, _QUORUM_RESPONSE_MSG_HEADER_LENGTH+len(value))}
+		if r := vs.msgRing.Ring(); r != nil {
+			if n := r.LocalNode(); n != nil {
+				binary.BigEndian.PutUint64(qrm.body, n.ID())
+			}
+		}
+		copy(qrm.body[8:], b[8:16])
+		copy(qrm.body[_QUORUM_RESPONSE_MSG_HEADER_LENGTH:], value)
+		qrm.body[16] = status
+		binary.BigEndian.PutUint64(qrm.body[17:], timestampbits)
+		vs.msgRing.MsgToNode(qrm, nodeID, vs.quorumState.timeout)
+	}
+}
+
+func (qrm *quorumMsg) MsgType() uint64 {
+	if qrm.response {
+		return _MSG_QUORUM_RESPONSE
+	}
+	return _MSG_QUORUM_REQUEST
+}
+
+func (qrm *quorumMsg) MsgLength() uint64 {
+	return uint64(len(qrm.body))
+}
+
+func (qrm *quorumMsg) WriteContent(w io.Writer) (uint64, error) {
+	n, err := w.Write(qrm.body)
+	return uint64(n), err
+}
+
+func (qrm *quorumMsg) Free() {
+}
@@ -0,0 +1,93 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+func TestQuorum(t *testing.T) {
+	dir1, err := ioutil.TempDir("", "quorum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir1)
+	dir2, err := ioutil.TempDir("", "quorum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n1, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1 := b.Ring()
+	r1.SetLocalNode(n1.ID())
+	r2 := b.Ring()
+	r2.SetLocalNode(n2.ID())
+	m1 := &msgRingMerkleTester{ring: r1}
+	m2 := &msgRingMerkleTester{ring: r2, peer: m1}
+	m1.peer = m2
+	vs1 := New(&Config{Path: dir1, IgnoreEnv: true, MsgRing: m1, QuorumTimeout: 10000})
+	vs1.EnableWrites()
+	defer vs1.Close()
+	vs2 := New(&Config{Path: dir2, IgnoreEnv: true, MsgRing: m2})
+	vs2.EnableWrites()
+	defer vs2.Close()
+	// With two replicas, the default quorum is both.
+	if _, err = vs1.WriteQuorum(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, value, err := vs2.Read(1, 2, nil); err != nil || ts != 1000 || string(value) != "value" {
+		t.Fatal(ts, string(value), err)
+	}
+	// The newest answer wins and repairs the local copy.
+	if _, err = vs2.Write(1, 2, 2000, []byte("newer")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, value, err := vs1.ReadQuorum(1, 2, []byte("prefix:")); err != nil || ts != 2000 || string(value) != "prefix:newer" {
+		t.Fatal(ts, string(value), err)
+	}
+	if ts, value, err := vs1.Read(1, 2, nil); err != nil || ts != 2000 || string(value) != "newer" {
+		t.Fatal(ts, string(value), err)
+	}
+	if _, err = vs2.Delete(1, 2, 3000); err != nil {
+		t.Fatal(err)
+	}
+	if ts, _, err := vs1.ReadQuorum(1, 2, nil); err != ErrNotFound || ts != 3000 {
+		t.Fatal(ts, err)
+	}
+	if ts, _, err := vs1.ReadQuorum(3, 4, nil); err != ErrNotFound || ts != 0 {
+		t.Fatal(ts, err)
+	}
+	// Once the other replica stops answering, the quorum can't be met.
+	vs1.quorumState.timeout = 10 * time.Millisecond
+	m1.peer = &msgRingMerkleTester{}
+	if _, err = vs1.WriteQuorum(5, 6, 1000, []byte("value")); err != ErrQuorum {
+		t.Fatal(err)
+	}
+	if _, _, err = vs1.ReadQuorum(5, 6, nil); err != ErrQuorum {
+		t.Fatal(err)
+	}
+	// Unless a quorum of one is enough.
+	vs1.quorumState.quorum = 1
+	if _, err = vs1.WriteQuorum(5, 6, 2000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	stats1 := vs1.Stats(false).(*Stats)
+	if stats1.QuorumWrites != 3 || stats1.QuorumReads != 4 || stats1.QuorumFailures != 2 {
+		t.Fatal(stats1.QuorumWrites, stats1.QuorumReads, stats1.QuorumFailures)
+	}
+	if stats2 := vs2.Stats(false).(*Stats); stats2.InQuorumRequests != 4 {
+		t.Fatal(stats2.InQuorumRequests)
+	}
+}
@@ -0,0 +1,112 @@
+package valuestore
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// readCacheState keeps, within Config.ReadCacheBytes, copies of the values
+// Read has had to get from the values files, so reads of hot values skip the
+// disk. Each entry records the timestampbits it was read for and is only used
+// while the location map still has that version; writes and deletes that take
+// effect drop the entry for the key as well, so it doesn't hold memory for a
+// value that can no longer be served.
+type readCacheState struct {
+	max  int
+	lock sync.Mutex
+	size int
+	// lru has the entries, most recently used at the front.
+	lru     *list.List
+	entries map[bulkSetKey]*list.Element
+}
+
+type readCacheEntry struct {
+	key           bulkSetKey
+	timestampbits uint64
+	value         []byte
+}
+
+func (vs *DefaultValueStore) readCacheConfig(cfg *Config) {
+	vs.readCacheState.max = cfg.ReadCacheBytes
+	vs.readCacheState.lru = list.New()
+	vs.readCacheState.entries = map[bulkSetKey]*list.Element{}
+}
+
+// readCached is read with the read cache in front of the values files; values
+// still in memory are read as usual.
+func (vs *DefaultValueStore) readCached(keyA uint64, keyB uint64, value []byte) (uint64, []byte, error) {
+	s := &vs.readCacheState
+	if s.max == 0 {
+		return vs.read(keyA, keyB, value)
+	}
+	timestampbits, id, offset, length := vs.vlm.Get(keyA, keyB)
+	if id == 0 || timestampbits&_TSB_DELETION != 0 || timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+		return timestampbits, value, ErrNotFound
+	}
+	block := vs.valueLocBlock(id)
+	if _, ok := block.(*valuesMem); ok {
+		return block.read(keyA, keyB, timestampbits, offset, length, value)
+	}
+	key := bulkSetKey{keyA: keyA, keyB: keyB}
+	s.lock.Lock()
+	if e := s.entries[key]; e != nil {
+		if entry := e.Value.(*readCacheEntry); entry.timestampbits == timestampbits {
+			s.lru.MoveToFront(e)
+			value = append(value, entry.value...)
+			s.lock.Unlock()
+			atomic.AddInt32(&vs.readCacheHits, 1)
+			return timestampbits, value, nil
+		}
+	}
+	s.lock.Unlock()
+	atomic.AddInt32(&vs.readCacheMisses, 1)
+	start := len(value)
+	timestampbits, value, err := block.read(keyA, keyB, timestampbits, offset, length, value)
+	if err == nil {
+		vs.readCacheAdd(key, timestampbits, value[start:])
+	}
+	return timestampbits, value, err
+}
+
+// readCacheAdd caches a copy of value as read for timestampbits, replacing
+// any entry for the key and dropping the least recently used entries to stay
+// within the budget. Values larger than the whole budget aren't cached.
+func (vs *DefaultValueStore) readCacheAdd(key bulkSetKey, timestampbits uint64, value []byte) {
+	s := &vs.readCacheState
+	if len(value) > s.max {
+		return
+	}
+	entry := &readCacheEntry{key: key, timestampbits: timestampbits, value: append([]byte(nil), value...)}
+	s.lock.Lock()
+	if e := s.entries[key]; e != nil {
+		s.size -= len(e.Value.(*readCacheEntry).value)
+		s.lru.Remove(e)
+	}
+	s.entries[key] = s.lru.PushFront(entry)
+	s.size += len(value)
+	for s.size > s.max {
+		e := s.lru.Back()
+		old := e.Value.(*readCacheEntry)
+		s.size -= len(old.value)
+		s.lru.Remove(e)
+		delete(s.entries, old.key)
+	}
+	s.lock.Unlock()
+}
+
+// readCacheRemove drops any cached value for keyA, keyB.
+func (vs *DefaultValueStore) readCacheRemove(keyA uint64, keyB uint64) {
+	s := &vs.readCacheState
+	if s.max == 0 {
+		return
+	}
+	key := bulkSetKey{keyA: keyA, keyB: keyB}
+	s.lock.Lock()
+	if e := s.entries[key]; e != nil {
+		s.size -= len(e.Value.(*readCacheEntry).value)
+		s.lru.Remove(e)
+		delete(s.entries, key)
+	}
+	s.lock.Unlock()
+}
@@ -0,0 +1,98 @@
+package valuestore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newReadCacheTestStore returns a store, closed when the test ends, that was
+// reopened after 200 values were written to it, so its cache starts empty.
+func newReadCacheTestStore(t *testing.T, readCacheBytes int) *DefaultValueStore {
+	dir, err := ioutil.TempDir("", "readcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Path: dir, IgnoreEnv: true, ReadCacheBytes: readCacheBytes}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 200; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d", keyB))); err != nil {
+			vs.Close()
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	vs.Close()
+	vs = New(cfg)
+	vs.EnableWrites()
+	t.Cleanup(func() {
+		vs.Close()
+		os.RemoveAll(dir)
+	})
+	return vs
+}
+
+func readCacheTestVerify(t *testing.T, vs *DefaultValueStore) {
+	for keyB := uint64(1); keyB <= 200; keyB++ {
+		if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d", keyB) {
+			t.Fatal(keyB, string(value), err)
+		}
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	vs := newReadCacheTestStore(t, 1024*1024)
+	for i := 0; i < 2; i++ {
+		readCacheTestVerify(t, vs)
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.ReadCacheMisses != 200 || stats.ReadCacheHits != 200 {
+		t.Fatal(stats.ReadCacheMisses, stats.ReadCacheHits)
+	}
+	if _, err := vs.Write(1, 1, 2000, []byte("newer")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Delete(1, 2, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if len(vs.readCacheState.entries) != 198 {
+		t.Fatal(len(vs.readCacheState.entries))
+	}
+	if ts, value, err := vs.Read(1, 1, nil); err != nil || ts != 2000 || !bytes.Equal(value, []byte("newer")) {
+		t.Fatal(ts, string(value), err)
+	}
+	if _, _, err := vs.Read(1, 2, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	// A stale entry, such as one cached just before an overwrite, is not
+	// served once the location map has moved on.
+	vs.readCacheAdd(bulkSetKey{keyA: 1, keyB: 1}, uint64(1000)<<_TSB_UTIL_BITS, []byte("stale"))
+	vs.Flush()
+	if _, value, err := vs.Read(1, 1, nil); err != nil || !bytes.Equal(value, []byte("newer")) {
+		t.Fatal(string(value), err)
+	}
+}
+
+func TestReadCacheBytes(t *testing.T) {
+	vs := newReadCacheTestStore(t, 1024)
+	for i := 0; i < 2; i++ {
+		readCacheTestVerify(t, vs)
+	}
+	s := &vs.readCacheState
+	if s.size > 1024 || s.lru.Len() != len(s.entries) || len(s.entries) == 0 || len(s.entries) == 200 {
+		t.Fatal(s.size, s.lru.Len(), len(s.entries))
+	}
+	size := 0
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		size += len(e.Value.(*readCacheEntry).value)
+	}
+	if size != s.size {
+		t.Fatal(size, s.size)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.ReadCacheMisses <= 200 {
+		t.Fatal(stats.ReadCacheMisses)
+	}
+}
@@ -0,0 +1,140 @@
+package valuestore
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/gholt/brimutil.v1"
+)
+
+// readerPoolState keeps the total number of values file readers open, across
+// all the values files, within Config.ReaderBudget. Each values file has a
+// reader slot per concurrent read of the file allowed, see readerTuneState; a
+// slot's file descriptor is only opened when it is first read through and
+// may be closed again, least recently used first, to make room for another. Stores with many values files then keep descriptors for the
+// files actually being read rather than for every file.
+type readerPoolState struct {
+	budget int
+	lock   sync.Mutex
+	open   int
+	// lru has the open readers, most recently used at the front.
+	lru *list.List
+}
+
+// valuesFileReader is one reader slot of a values file. lock is held for the
+// duration of each read through the slot; fp, elem, and inUse are guarded by
+// the readerPoolState lock.
+type valuesFileReader struct {
+	vf    *valuesFile
+	lock  sync.Mutex
+	fp    brimutil.ChecksummedReader
+	elem  *list.Element
+	inUse bool
+}
+
+func (vs *DefaultValueStore) readerPoolConfig(cfg *Config) {
+	vs.readerPoolState.budget = cfg.ReaderBudget
+	vs.readerPoolState.lru = list.New()
+}
+
+// readerAcquire readies the reader slot r, which the caller holds the lock
+// of, for reading, opening it if need be. Before opening, the least recently
+// used idle readers are closed to stay within budget; if every open reader is
+// busy the budget is exceeded rather than waiting.
+func (vs *DefaultValueStore) readerAcquire(r *valuesFileReader) error {
+	s := &vs.readerPoolState
+	s.lock.Lock()
+	if r.fp != nil {
+		r.inUse = true
+		s.lru.MoveToFront(r.elem)
+		s.lock.Unlock()
+		return nil
+	}
+	for e := s.lru.Back(); e != nil && s.open >= s.budget; {
+		prev := e.Prev()
+		if r2 := e.Value.(*valuesFileReader); !r2.inUse {
+			r2.fp.Close()
+			r2.fp = nil
+			s.lru.Remove(e)
+			r2.elem = nil
+			s.open--
+			atomic.AddInt32(&vs.readerEvictions, 1)
+		}
+		e = prev
+	}
+	r.inUse = true
+	s.open++
+	s.lock.Unlock()
+	fp, err := r.vf.openReader()
+	s.lock.Lock()
+	if err != nil {
+		r.inUse = false
+		s.open--
+	} else {
+		r.fp = fp
+		r.elem = s.lru.PushFront(r)
+		atomic.AddInt32(&vs.readerOpens, 1)
+	}
+	s.lock.Unlock()
+	return err
+}
+
+// readerRelease marks the reader slot r as idle again, so it may be closed.
+func (vs *DefaultValueStore) readerRelease(r *valuesFileReader) {
+	vs.readerPoolState.lock.Lock()
+	r.inUse = false
+	vs.readerPoolState.lock.Unlock()
+}
+
+// readerCloseAll closes every open values file reader, waiting for any read
+// in progress through each to finish first. The readers are reopened if
+// read through again.
+func (vs *DefaultValueStore) readerCloseAll() {
+	s := &vs.readerPoolState
+	s.lock.Lock()
+	readers := make([]*valuesFileReader, 0, s.lru.Len())
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		readers = append(readers, e.Value.(*valuesFileReader))
+	}
+	s.lock.Unlock()
+	for _, r := range readers {
+		r.lock.Lock()
+		s.lock.Lock()
+		if r.fp != nil {
+			r.fp.Close()
+			r.fp = nil
+			s.lru.Remove(r.elem)
+			r.elem = nil
+			s.open--
+		}
+		s.lock.Unlock()
+		r.lock.Unlock()
+	}
+}
+
+// readersOpen returns how many values file readers are currently open.
+func (vs *DefaultValueStore) readersOpen() int {
+	vs.readerPoolState.lock.Lock()
+	open := vs.readerPoolState.open
+	vs.readerPoolState.lock.Unlock()
+	return open
+}
+
+func (vf *valuesFile) openReader() (brimutil.ChecksummedReader, error) {
+	name := vf.vs.valuesName(vf.bts)
+	fp, err := vf.openReadSeeker(name)
+	if err != nil {
+		return nil, err
+	}
+	rs, _, err := vf.vs.decryptReadSeeker(fp)
+	if err != nil {
+		if c, ok := fp.(io.Closer); ok {
+			c.Close()
+		}
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return brimutil.NewChecksummedReader(rs, int(vf.checksumInterval), vf.newHash), nil
+}
@@ -0,0 +1,52 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReaderBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readerpool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{
+		Path:              dir,
+		IgnoreEnv:         true,
+		ValueCap:          4096,
+		ValuesFileCap:     64 * 1024,
+		PageSize:          4 * 1024,
+		ValuesFileReaders: 2,
+		ReaderBudget:      3,
+	}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 2000; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d %0100d", keyB, 0))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Close()
+	vs = New(cfg)
+	defer vs.Close()
+	if n := len(vs.ListFiles()); n < 4 {
+		t.Fatal(n)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.ReadersOpen != 0 {
+		t.Fatal(stats.ReadersOpen)
+	}
+	for i := 0; i < 2; i++ {
+		for keyB := uint64(1); keyB <= 2000; keyB++ {
+			if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d %0100d", keyB, 0) {
+				t.Fatal(keyB, string(value), err)
+			}
+		}
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.ReadersOpen != 3 || stats.ReaderEvictions == 0 || stats.ReaderOpens != stats.ReaderEvictions+3 {
+		t.Fatal(stats.ReadersOpen, stats.ReaderOpens, stats.ReaderEvictions)
+	}
+}
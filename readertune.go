@@ -0,0 +1,129 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// readerTuneState adjusts, while running, how many of each values file's
+// reader slots are used; that is, how many concurrent reads each values file
+// allows. Every Config.ReaderTuneInterval the reads since the last interval
+// are looked at:
+//
+// If the device holding the values files has more requests in flight than
+// Config.ReaderQueueDepth, or reads are taking more than twice as long as
+// they have been on average, a slot is given up; the device is likely busy
+// with writes or compactions and more concurrent reads would only queue
+// there.
+//
+// Otherwise, if reads spent more time waiting for a free slot than reading,
+// a slot is added, up to Config.ValuesFileReadersMax.
+type readerTuneState struct {
+	// These are first so atomic access to them is aligned even on 32 bit
+	// platforms; see DefaultValueStore.
+	reads     int64
+	waitNanos int64
+	readNanos int64
+	// concurrency is how many reader slots of each values file are in use.
+	concurrency int32
+	max         int32
+	interval    time.Duration
+	queueDepth  int
+	// inflightName is the file giving the device's requests in flight, or
+	// empty if not known.
+	inflightName string
+	// latency is the running average nanoseconds per read, or 0 if there
+	// haven't been any reads yet.
+	latency int64
+}
+
+func (vs *DefaultValueStore) readerTuneConfig(cfg *Config) {
+	s := &vs.readerTuneState
+	s.concurrency = int32(cfg.ValuesFileReaders)
+	s.max = int32(cfg.ValuesFileReaders)
+	s.interval = time.Duration(cfg.ReaderTuneInterval) * time.Millisecond
+	if s.interval > 0 {
+		s.max = int32(cfg.ValuesFileReadersMax)
+		s.queueDepth = cfg.ReaderQueueDepth
+		s.inflightName = detectInflightName(vs.path)
+	}
+}
+
+func (vs *DefaultValueStore) readerTuneLaunch() {
+	if vs.readerTuneState.interval > 0 {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.readerTuner()
+	}
+}
+
+func (vs *DefaultValueStore) readerTuner() {
+	for vs.closeSleep(vs.readerTuneState.interval) {
+		vs.readerTune()
+	}
+	vs.closeState.backgroundWG.Done()
+}
+
+// readerSlot returns the reader slot of vf that reads of keyA should use.
+func (vs *DefaultValueStore) readerSlot(vf *valuesFile, keyA uint64) *valuesFileReader {
+	return vf.readers[int(keyA>>1)%int(atomic.LoadInt32(&vs.readerTuneState.concurrency))]
+}
+
+// readerObserve records a read that waited for its slot from start until
+// locked and then read until done.
+func (vs *DefaultValueStore) readerObserve(start time.Time, locked time.Time, done time.Time) {
+	s := &vs.readerTuneState
+	atomic.AddInt64(&s.reads, 1)
+	atomic.AddInt64(&s.waitNanos, int64(locked.Sub(start)))
+	atomic.AddInt64(&s.readNanos, int64(done.Sub(locked)))
+}
+
+func (vs *DefaultValueStore) readerTune() {
+	s := &vs.readerTuneState
+	reads := atomic.SwapInt64(&s.reads, 0)
+	waitNanos := atomic.SwapInt64(&s.waitNanos, 0)
+	readNanos := atomic.SwapInt64(&s.readNanos, 0)
+	if reads == 0 {
+		return
+	}
+	latency := readNanos / reads
+	inflight, known := readInflight(s.inflightName)
+	concurrency := atomic.LoadInt32(&s.concurrency)
+	if (known && inflight > s.queueDepth) || (s.latency > 0 && latency > s.latency*2) {
+		if concurrency > 1 {
+			atomic.StoreInt32(&s.concurrency, concurrency-1)
+			atomic.AddInt32(&vs.readerConcurrencyShrinks, 1)
+		}
+	} else if waitNanos > readNanos && concurrency < s.max {
+		atomic.StoreInt32(&s.concurrency, concurrency+1)
+		atomic.AddInt32(&vs.readerConcurrencyGrows, 1)
+	}
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = (s.latency*3 + latency) / 4
+	}
+}
+
+// readInflight returns the reads plus writes in flight given by the device's
+// inflight file name, and whether they could be read.
+func readInflight(name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return 0, false
+	}
+	inflight := 0
+	for _, field := range strings.Fields(string(b)) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, false
+		}
+		inflight += n
+	}
+	return inflight, true
+}
@@ -0,0 +1,78 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReaderTune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readertune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true, ValuesFileReaders: 2, ValuesFileReadersMax: 4, ReaderTuneInterval: 3600000})
+	defer vs.Close()
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 100; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	verify := func() {
+		for keyB := uint64(1); keyB <= 100; keyB++ {
+			if _, value, err := vs.Read(1, keyB, nil); err != nil || string(value) != fmt.Sprintf("value %d", keyB) {
+				t.Fatal(keyB, string(value), err)
+			}
+		}
+	}
+	s := &vs.readerTuneState
+	s.inflightName = ""
+	observe := func(wait time.Duration, read time.Duration) {
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			vs.readerObserve(start, start.Add(wait), start.Add(wait+read))
+		}
+		vs.readerTune()
+	}
+	// Reads waiting on their slots longer than reading allow more slots, up
+	// to the max.
+	for _, expected := range []int{3, 4, 4} {
+		observe(time.Millisecond, 100*time.Microsecond)
+		if stats := vs.Stats(false).(*Stats); stats.ReaderConcurrency != expected {
+			t.Fatal(stats.ReaderConcurrency, expected)
+		}
+		verify()
+	}
+	// Reads slowing down a lot give one up.
+	observe(0, 10*time.Millisecond)
+	if stats := vs.Stats(false).(*Stats); stats.ReaderConcurrency != 3 || stats.ReaderConcurrencyShrinks != 1 {
+		t.Fatal(stats.ReaderConcurrency, stats.ReaderConcurrencyShrinks)
+	}
+	// As does a deep device queue, even with reads waiting on their slots.
+	fp, err := ioutil.TempFile("", "valuestoreinflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	fp.WriteString("       40        1\n")
+	fp.Close()
+	if inflight, known := readInflight(fp.Name()); !known || inflight != 41 {
+		t.Fatal(inflight, known)
+	}
+	s.inflightName = fp.Name()
+	observe(time.Second, 0)
+	if stats := vs.Stats(false).(*Stats); stats.ReaderConcurrency != 2 {
+		t.Fatal(stats.ReaderConcurrency)
+	}
+	// No reads, no change.
+	vs.readerTune()
+	if stats := vs.Stats(false).(*Stats); stats.ReaderConcurrency != 2 {
+		t.Fatal(stats.ReaderConcurrency)
+	}
+	verify()
+}
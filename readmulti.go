@@ -0,0 +1,179 @@
+package valuestore
+
+import (
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// _READ_MULTI_RUN is the most values ReadMulti reads from a values file while
+// holding one of its reader slots, so that Reads of the same file aren't kept
+// waiting too long.
+const _READ_MULTI_RUN = 64
+
+// ReadMultiEntry is a key to read with ReadMulti and, once ReadMulti returns,
+// what Read would have returned for that key.
+type ReadMultiEntry struct {
+	KeyA           uint64
+	KeyB           uint64
+	Timestampmicro int64
+	Value          []byte
+	Err            error
+}
+
+type readMultiLocation struct {
+	index         int
+	timestampbits uint64
+	blockID       uint32
+	offset        uint32
+	length        uint32
+	tsn           int64
+	// vf is the values file holding the value, or nil if the value is
+	// still in memory.
+	vf         *valuesFile
+	fileOffset uint64
+}
+
+type readMultiLocations []readMultiLocation
+
+func (l readMultiLocations) Len() int {
+	return len(l)
+}
+
+func (l readMultiLocations) Less(i int, j int) bool {
+	if l[i].tsn != l[j].tsn {
+		return l[i].tsn < l[j].tsn
+	}
+	return l[i].fileOffset < l[j].fileOffset
+}
+
+func (l readMultiLocations) Swap(i int, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+// ReadMulti reads the keys of all the entries given, filling in each entry's
+// Timestampmicro, Value, and Err just as Read would return them. The values,
+// other than those stored compressed, all share one newly allocated []byte
+// and are read in values file and offset order to reduce seeking, with each
+// values file reader slot taken once for a whole run of values rather than
+// once per value, so this is better suited than many Read calls when fetching
+// a lot of keys at once.
+func (vs *DefaultValueStore) ReadMulti(entries []ReadMultiEntry) {
+	vs.readMulti(entries, nil)
+}
+
+// readMulti is ReadMulti but, if each is not nil, it is also called with
+// every entry that had a value to read, just after reading it; if each
+// returns false, the remaining values are left unread, their entries having
+// no Value or Err, and readMulti returns false.
+func (vs *DefaultValueStore) readMulti(entries []ReadMultiEntry, each func(e *ReadMultiEntry) bool) bool {
+	atomic.AddInt32(&vs.reads, int32(len(entries)))
+	locations := make(readMultiLocations, 0, len(entries))
+	total := 0
+	for i := range entries {
+		e := &entries[i]
+		timestampbits, id, offset, length := vs.vlm.Get(e.KeyA, e.KeyB)
+		e.Timestampmicro = int64(timestampbits >> _TSB_UTIL_BITS)
+		e.Value = nil
+		if id == 0 || timestampbits&_TSB_DELETION != 0 || timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+			e.Err = ErrNotFound
+			atomic.AddInt32(&vs.readErrors, 1)
+			continue
+		}
+		e.Err = nil
+		loc := readMultiLocation{index: i, timestampbits: timestampbits, blockID: id, offset: offset, length: length}
+		loc.tsn = vs.valueLocBlock(id).timestampnano()
+		loc.vf, loc.fileOffset = vs.valuesFileOffset(id, offset)
+		locations = append(locations, loc)
+		total += int(length)
+	}
+	sort.Sort(locations)
+	buf := make([]byte, total)
+	for len(locations) > 0 {
+		n := 1
+		if vf := locations[0].vf; vf != nil {
+			for n < len(locations) && n < _READ_MULTI_RUN && locations[n].vf == vf {
+				n++
+			}
+			vs.readMultiRun(vf, locations[:n], entries, buf)
+		} else {
+			loc := &locations[0]
+			e := &entries[loc.index]
+			timestampbits, value, err := vs.valueLocBlock(loc.blockID).read(e.KeyA, e.KeyB, loc.timestampbits, loc.offset, loc.length, buf[:0:loc.length])
+			e.Timestampmicro = int64(timestampbits >> _TSB_UTIL_BITS)
+			e.Value = value
+			e.Err = err
+		}
+		for _, loc := range locations[:n] {
+			buf = buf[loc.length:]
+			e := &entries[loc.index]
+			if e.Err != nil {
+				atomic.AddInt32(&vs.readErrors, 1)
+			}
+			if each != nil && !each(e) {
+				return false
+			}
+		}
+		locations = locations[n:]
+	}
+	return true
+}
+
+// readMultiRun reads the values of the run of locations, all in vf and in
+// offset order, into buf through one reader slot held for the whole run;
+// values that follow one another need no seek in between. It is otherwise
+// the same as reading each with vf.readAt.
+func (vs *DefaultValueStore) readMultiRun(vf *valuesFile, run readMultiLocations, entries []ReadMultiEntry, buf []byte) {
+	tuning := vs.readerTuneState.interval > 0
+	start := time.Now()
+	r := vs.readerSlot(vf, entries[run[0].index].KeyA)
+	r.lock.Lock()
+	locked := time.Now()
+	if err := vs.readerAcquire(r); err != nil {
+		r.lock.Unlock()
+		vs.diskHealthState.values.read(time.Since(locked), err)
+		for _, loc := range run {
+			e := &entries[loc.index]
+			e.Value = buf[:0]
+			e.Err = err
+			buf = buf[loc.length:]
+		}
+		return
+	}
+	var at uint64
+	for i, loc := range run {
+		e := &entries[loc.index]
+		if i == 0 || loc.fileOffset != at {
+			r.fp.Seek(int64(loc.fileOffset), 0)
+		}
+		value := buf[:loc.length]
+		buf = buf[loc.length:]
+		_, err := io.ReadFull(r.fp, value)
+		done := time.Now()
+		vs.diskHealthState.values.read(done.Sub(locked), err)
+		if tuning {
+			vs.readerObserve(start, locked, done)
+		}
+		// Only the first value waited for the slot.
+		start = done
+		locked = done
+		if err == nil && vf.framed && len(value) > 0 {
+			if value[0] == _COMPRESSION_NONE {
+				value = value[1:]
+			} else {
+				// Decompressed values get their own space, as they are
+				// longer than what was stored.
+				value, err = vs.decompress(nil, value)
+			}
+		}
+		e.Value = value
+		e.Err = err
+		at = loc.fileOffset + uint64(loc.length)
+		if err != nil {
+			at = 0
+		}
+	}
+	vs.readerRelease(r)
+	r.lock.Unlock()
+}
@@ -0,0 +1,82 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadMulti(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readmulti")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	defer vs.Close()
+	vs.EnableWrites()
+	// Spread the values over two values files and memory.
+	for keyB := uint64(1); keyB <= 30; keyB++ {
+		if _, err := vs.Write(1, keyB, int64(1000+keyB), []byte(fmt.Sprintf("value%d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+		if keyB%10 == 0 {
+			vs.Flush()
+		}
+	}
+	if _, err = vs.Delete(1, 5, 2000); err != nil {
+		t.Fatal(err)
+	}
+	entries := []ReadMultiEntry{{KeyA: 1, KeyB: 25}, {KeyA: 1, KeyB: 3}, {KeyA: 1, KeyB: 5}, {KeyA: 1, KeyB: 99}, {KeyA: 1, KeyB: 14}, {KeyA: 1, KeyB: 3}}
+	vs.ReadMulti(entries)
+	for _, e := range entries {
+		ts, value, err := vs.Read(e.KeyA, e.KeyB, nil)
+		if e.Timestampmicro != ts || string(e.Value) != string(value) || e.Err != err {
+			t.Fatal(e, ts, string(value), err)
+		}
+	}
+	if entries[2].Err != ErrNotFound || entries[2].Timestampmicro != 2000 || entries[3].Err != ErrNotFound || entries[3].Timestampmicro != 0 {
+		t.Fatal(entries[2], entries[3])
+	}
+	if string(entries[0].Value) != "value25" || string(entries[1].Value) != "value3" {
+		t.Fatal(string(entries[0].Value), string(entries[1].Value))
+	}
+	if stats := vs.Stats(false).(*Stats); stats.Reads != 12 || stats.ReadErrors != 4 {
+		t.Fatal(stats.Reads, stats.ReadErrors)
+	}
+}
+
+func TestReadMultiRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readmulti")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true, ValuesFileReaders: 1, ReaderBudget: 1})
+	defer vs.Close()
+	vs.EnableWrites()
+	// More values per file than fit in one run, with some left in memory.
+	const count = _READ_MULTI_RUN*3 + 10
+	for keyB := uint64(1); keyB <= count; keyB++ {
+		if _, err := vs.Write(1, keyB, int64(1000+keyB), []byte(fmt.Sprintf("value%d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+		if keyB%(_READ_MULTI_RUN*3/2) == 0 {
+			vs.Flush()
+		}
+	}
+	var entries []ReadMultiEntry
+	for keyB := uint64(count); keyB > 0; keyB-- {
+		entries = append(entries, ReadMultiEntry{KeyA: 1, KeyB: keyB})
+	}
+	vs.ReadMulti(entries)
+	for _, e := range entries {
+		if e.Err != nil || e.Timestampmicro != int64(1000+e.KeyB) || string(e.Value) != fmt.Sprintf("value%d", e.KeyB) {
+			t.Fatal(e.KeyB, e.Timestampmicro, string(e.Value), e.Err)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.ReadersOpen != 1 {
+		t.Fatal(stats.ReadersOpen)
+	}
+}
@@ -0,0 +1,115 @@
+package valuestore
+
+import (
+	"os"
+	"time"
+)
+
+// RecoveryProgress is given to Config.RecoveryCallback as recovery loads the
+// existing data at startup.
+type RecoveryProgress struct {
+	// Files is the number of TOC files to load and FilesDone how many of them
+	// have been gone through.
+	Files     int
+	FilesDone int
+	// Bytes is the total size of the TOC files and BytesDone how much of it
+	// has been gone through.
+	Bytes     int64
+	BytesDone int64
+	// Entries is the number of TOC entries loaded so far.
+	Entries int
+	// Elapsed is how long recovery has been running.
+	Elapsed time.Duration
+	// EntriesPerSecond is the rate entries have been loaded at so far.
+	EntriesPerSecond float64
+	// ETA is the estimated time left, from the rate the TOC files have been
+	// gone through so far; it is 0 until some of them have been.
+	ETA time.Duration
+	// Done is set for the final call, once recovery has finished.
+	Done bool
+}
+
+type recoveryProgressState struct {
+	callback RecoveryCallbackFunc
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+	// sizes are the sizes of the TOC files, in the order they're loaded.
+	sizes    []int64
+	progress RecoveryProgress
+}
+
+func (vs *DefaultValueStore) recoveryProgressConfig(cfg *Config) {
+	vs.recoveryProgressState.callback = cfg.RecoveryCallback
+	vs.recoveryProgressState.interval = time.Duration(cfg.RecoveryCallbackInterval) * time.Millisecond
+}
+
+// recoveryProgressBegin notes the TOC files recovery is about to load.
+func (vs *DefaultValueStore) recoveryProgressBegin(start time.Time, names []string) {
+	s := &vs.recoveryProgressState
+	s.start = start
+	s.last = start
+	s.sizes = s.sizes[:0]
+	s.progress = RecoveryProgress{}
+	for _, name := range names {
+		var size int64
+		if fi, err := os.Stat(vs.tocPath(name)); err == nil {
+			size = fi.Size()
+		}
+		s.sizes = append(s.sizes, size)
+		s.progress.Bytes += size
+	}
+	s.progress.Files = len(names)
+}
+
+// recoveryProgressFile notes that recovery is starting on the TOC file given
+// by its index in the names given to recoveryProgressBegin.
+func (vs *DefaultValueStore) recoveryProgressFile(file int, entries int) {
+	s := &vs.recoveryProgressState
+	s.progress.BytesDone = 0
+	for _, size := range s.sizes[:file] {
+		s.progress.BytesDone += size
+	}
+	s.progress.FilesDone = file
+	vs.recoveryProgressReport(entries, false)
+}
+
+// recoveryProgressRead notes that n more bytes of the current TOC file have
+// been read.
+func (vs *DefaultValueStore) recoveryProgressRead(n int, entries int) {
+	vs.recoveryProgressState.progress.BytesDone += int64(n)
+	vs.recoveryProgressReport(entries, false)
+}
+
+// recoveryProgressEnd makes the final call to the callback; the progress is
+// kept as the summary reported by Stats.
+func (vs *DefaultValueStore) recoveryProgressEnd(entries int) {
+	s := &vs.recoveryProgressState
+	s.progress.FilesDone = s.progress.Files
+	s.progress.BytesDone = s.progress.Bytes
+	s.progress.Done = true
+	vs.recoveryProgressReport(entries, true)
+}
+
+func (vs *DefaultValueStore) recoveryProgressReport(entries int, force bool) {
+	s := &vs.recoveryProgressState
+	now := time.Now()
+	if !force && (s.callback == nil || now.Sub(s.last) < s.interval) {
+		return
+	}
+	s.last = now
+	p := &s.progress
+	p.Entries = entries
+	p.Elapsed = now.Sub(s.start)
+	p.EntriesPerSecond = 0
+	if p.Elapsed > 0 {
+		p.EntriesPerSecond = float64(entries) / p.Elapsed.Seconds()
+	}
+	p.ETA = 0
+	if p.BytesDone > 0 && p.BytesDone < p.Bytes {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(p.Bytes-p.BytesDone) / float64(p.BytesDone))
+	}
+	if s.callback != nil {
+		s.callback(*p)
+	}
+}
@@ -0,0 +1,69 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoveryCallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recoveryprogress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Path: dir, IgnoreEnv: true}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 200; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+		if keyB == 100 {
+			vs.Flush()
+		}
+	}
+	vs.Close()
+	tocs, err := filepath.Glob(path.Join(dir, "*.valuestoc"))
+	if err != nil || len(tocs) != 2 {
+		t.Fatal(tocs, err)
+	}
+	var size int64
+	for _, name := range tocs {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	var calls []RecoveryProgress
+	cfg.RecoveryCallback = func(progress RecoveryProgress) {
+		calls = append(calls, progress)
+	}
+	cfg.RecoveryCallbackInterval = 1
+	vs = New(cfg)
+	defer vs.Close()
+	if len(calls) == 0 {
+		t.Fatal(calls)
+	}
+	for i, p := range calls[:len(calls)-1] {
+		if p.Done || p.Files != len(tocs) || p.BytesDone > p.Bytes || i > 0 && p.FilesDone < calls[i-1].FilesDone {
+			t.Fatalf("%d %#v", i, p)
+		}
+	}
+	last := calls[len(calls)-1]
+	if !last.Done || last.Files != len(tocs) || last.FilesDone != len(tocs) || last.Bytes != size || last.BytesDone != size || last.Entries != 200 || last.ETA != 0 {
+		t.Fatalf("%#v", last)
+	}
+	stats := vs.Stats(true).(*Stats)
+	if stats.RecoveryFiles != len(tocs) || stats.RecoveryBytes != size || stats.RecoveryEntries != 200 {
+		t.Fatal(stats.RecoveryFiles, stats.RecoveryBytes, stats.RecoveryEntries)
+	}
+	// The summary is not reset.
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryEntries != 200 {
+		t.Fatal(stats.RecoveryEntries)
+	}
+}
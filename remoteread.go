@@ -0,0 +1,243 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// rrm: nodeID:8 keyA:8 keyB:8
+const _REMOTE_READ_MSG_TYPE = 0x7c41e9b2d5038fa6
+const _REMOTE_READ_MSG_LENGTH = 24
+
+// A read-repair message is a remote-read message that also carries what the
+// requester has for the key; only a replica with something newer answers.
+//
+// rrm: nodeID:8 keyA:8 keyB:8 timestampbits:8
+const _READ_REPAIR_MSG_TYPE = 0x2f95d3a0c8e6b714
+const _READ_REPAIR_MSG_LENGTH = 32
+
+// remoteReadState handles falling back to the other replicas when a value
+// can't be read locally, such as when its checksum no longer matches.
+//
+// The replicas are sent a remote-read message and answer with a bulk-set
+// message holding what they have for the key. That bulk-set repairs the
+// local store as any other would and Read then serves the value from it.
+type remoteReadState struct {
+	fallback  bool
+	repair    bool
+	timeout   time.Duration
+	inMsgChan chan []byte
+}
+
+type remoteReadMsg struct {
+	body []byte
+}
+
+func (vs *DefaultValueStore) remoteReadConfig(cfg *Config) {
+	vs.remoteReadState.fallback = cfg.RemoteReadFallback
+	vs.remoteReadState.repair = cfg.ReadRepair
+	vs.remoteReadState.timeout = time.Duration(cfg.RemoteReadTimeout) * time.Millisecond
+	if vs.msgRing != nil {
+		vs.msgRing.SetMsgHandler(_REMOTE_READ_MSG_TYPE, vs.newInRemoteReadMsg)
+		vs.msgRing.SetMsgHandler(_READ_REPAIR_MSG_TYPE, vs.newInReadRepairMsg)
+		vs.remoteReadState.inMsgChan = make(chan []byte, cfg.Workers*4)
+	}
+}
+
+func (vs *DefaultValueStore) remoteReadLaunch() {
+	if vs.msgRing != nil {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.inRemoteRead()
+	}
+}
+
+// remoteRead is called by Read when reading keyA, keyB locally failed with
+// err. The bad local entry is forgotten, so a copy with the same timestamp
+// will be accepted, and the other replicas are asked for the key. If one
+// answers within the RemoteReadTimeout, the value is read again from the
+// repaired local store; otherwise the original error is returned. Even then,
+// with the entry forgotten, pull replication will restore it later.
+func (vs *DefaultValueStore) remoteRead(keyA uint64, keyB uint64, timestampbits uint64, value []byte, err error) (uint64, []byte, error) {
+	if !vs.remoteReadState.fallback || vs.msgRing == nil {
+		return timestampbits, value, err
+	}
+	ring := vs.msgRing.Ring()
+	if ring == nil {
+		return timestampbits, value, err
+	}
+	atomic.AddInt32(&vs.remoteReads, 1)
+	w := vs.Watch(keyA, keyB, 1)
+	defer w.Cancel()
+	// A newer write may have come in since the failed read; that one is left
+	// alone and will be read below.
+	vs.vlm.Set(keyA, keyB, timestampbits, 0, 0, 0, true)
+	rrm := &remoteReadMsg{body: make([]byte, _REMOTE_READ_MSG_LENGTH)}
+	if n := ring.LocalNode(); n != nil {
+		binary.BigEndian.PutUint64(rrm.body, n.ID())
+	}
+	binary.BigEndian.PutUint64(rrm.body[8:], keyA)
+	binary.BigEndian.PutUint64(rrm.body[16:], keyB)
+	vs.msgRing.MsgToOtherReplicas(rrm, uint32(keyA>>(64-ring.PartitionBitCount())), vs.remoteReadState.timeout)
+	t := time.NewTimer(vs.remoteReadState.timeout)
+	defer t.Stop()
+	select {
+	case <-w.C:
+	case <-t.C:
+		return timestampbits, value, err
+	}
+	timestampbits2, value2, err2 := vs.read(keyA, keyB, value)
+	if err2 == nil {
+		atomic.AddInt32(&vs.remoteReadRepairs, 1)
+	}
+	return timestampbits2, value2, err2
+}
+
+// readRepair is called by Read, with Config.ReadRepair, after reading keyA,
+// keyB locally gave timestampbits and err, nil or ErrNotFound. The other
+// replicas are asked if they have anything newer. On a miss, Read waits up to
+// the RemoteReadTimeout for an answer and then reads the key again from the
+// repaired local store; on a hit, the local value is returned right away and
+// any newer one just repairs the local store for later reads.
+func (vs *DefaultValueStore) readRepair(keyA uint64, keyB uint64, timestampbits uint64, value []byte, err error) (uint64, []byte, error) {
+	if !vs.remoteReadState.repair || vs.msgRing == nil {
+		return timestampbits, value, err
+	}
+	ring := vs.msgRing.Ring()
+	if ring == nil {
+		return timestampbits, value, err
+	}
+	atomic.AddInt32(&vs.readRepairQueries, 1)
+	var w *Watcher
+	if err != nil {
+		w = vs.Watch(keyA, keyB, 1)
+		defer w.Cancel()
+	}
+	rrm := &remoteReadMsg{body: make([]byte, _READ_REPAIR_MSG_LENGTH)}
+	if n := ring.LocalNode(); n != nil {
+		binary.BigEndian.PutUint64(rrm.body, n.ID())
+	}
+	binary.BigEndian.PutUint64(rrm.body[8:], keyA)
+	binary.BigEndian.PutUint64(rrm.body[16:], keyB)
+	binary.BigEndian.PutUint64(rrm.body[24:], timestampbits)
+	vs.msgRing.MsgToOtherReplicas(rrm, uint32(keyA>>(64-ring.PartitionBitCount())), vs.remoteReadState.timeout)
+	if w == nil {
+		return timestampbits, value, err
+	}
+	t := time.NewTimer(vs.remoteReadState.timeout)
+	defer t.Stop()
+	select {
+	case <-w.C:
+	case <-t.C:
+		return timestampbits, value, err
+	}
+	timestampbits2, value2, err2 := vs.read(keyA, keyB, value)
+	if err2 == nil {
+		atomic.AddInt32(&vs.readRepairs, 1)
+	}
+	return timestampbits2, value2, err2
+}
+
+// newInRemoteReadMsg is the MsgRing handler for remote-read messages; they
+// are queued for inRemoteRead, or dropped if too many are already queued.
+func (vs *DefaultValueStore) newInRemoteReadMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInRemoteReadMsg(r, l, _REMOTE_READ_MSG_LENGTH)
+}
+
+// newInReadRepairMsg is the MsgRing handler for read-repair messages; they
+// are queued along with the remote-read messages.
+func (vs *DefaultValueStore) newInReadRepairMsg(r io.Reader, l uint64) (uint64, error) {
+	return vs.readInRemoteReadMsg(r, l, _READ_REPAIR_MSG_LENGTH)
+}
+
+func (vs *DefaultValueStore) readInRemoteReadMsg(r io.Reader, l uint64, length uint64) (uint64, error) {
+	if l != length {
+		left := l
+		var sn int
+		var err error
+		for left > 0 {
+			t := toss
+			if left < uint64(len(t)) {
+				t = t[:left]
+			}
+			sn, err = r.Read(t)
+			left -= uint64(sn)
+			if err != nil {
+				break
+			}
+		}
+		atomic.AddInt32(&vs.inRemoteReadInvalids, 1)
+		return l - left, err
+	}
+	b := make([]byte, length)
+	n, err := io.ReadFull(r, b)
+	if err != nil {
+		atomic.AddInt32(&vs.inRemoteReadInvalids, 1)
+		return uint64(n), err
+	}
+	select {
+	case vs.remoteReadState.inMsgChan <- b:
+	default:
+		atomic.AddInt32(&vs.inRemoteReadDrops, 1)
+	}
+	return l, nil
+}
+
+// inRemoteRead answers remote-read messages by sending what is stored for
+// the key, value or deletion marker, back to the requesting node in a
+// bulk-set message. Read-repair messages are answered the same way, but only
+// if what is stored is newer than what the requester has.
+func (vs *DefaultValueStore) inRemoteRead() {
+	valbuf := make([]byte, vs.valueCap)
+	defer vs.closeState.backgroundWG.Done()
+	for {
+		var b []byte
+		select {
+		case b = <-vs.remoteReadState.inMsgChan:
+		case <-vs.closeState.backgroundChan:
+			return
+		}
+		atomic.AddInt32(&vs.inRemoteReads, 1)
+		nodeID := binary.BigEndian.Uint64(b)
+		keyA := binary.BigEndian.Uint64(b[8:])
+		keyB := binary.BigEndian.Uint64(b[16:])
+		timestampbits, v, err := vs.read(keyA, keyB, valbuf[:0])
+		if err == ErrNotFound {
+			if timestampbits == 0 {
+				continue
+			}
+		} else if err != nil {
+			continue
+		}
+		if timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+			continue
+		}
+		if len(b) == _READ_REPAIR_MSG_LENGTH && timestampbits>>_TSB_UTIL_BITS <= binary.BigEndian.Uint64(b[24:])>>_TSB_UTIL_BITS {
+			continue
+		}
+		bsm := vs.newOutBulkSetMsg()
+		bsm.setAckPolicy(BULK_SET_ACK_NONE)
+		bsm.add(keyA, keyB, timestampbits, v)
+		vs.msgToNode(bsm, nodeID, vs.remoteReadState.timeout)
+	}
+}
+
+func (rrm *remoteReadMsg) MsgType() uint64 {
+	if len(rrm.body) == _READ_REPAIR_MSG_LENGTH {
+		return _READ_REPAIR_MSG_TYPE
+	}
+	return _REMOTE_READ_MSG_TYPE
+}
+
+func (rrm *remoteReadMsg) MsgLength() uint64 {
+	return uint64(len(rrm.body))
+}
+
+func (rrm *remoteReadMsg) WriteContent(w io.Writer) (uint64, error) {
+	n, err := w.Write(rrm.body)
+	return uint64(n), err
+}
+
+func (rrm *remoteReadMsg) Free() {
+}
@@ -0,0 +1,212 @@
+package valuestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+type testRemoteReadBlock struct {
+	valueLocBlock
+}
+
+func (b *testRemoteReadBlock) read(keyA uint64, keyB uint64, timestampbits uint64, offset uint32, length uint32, value []byte) (uint64, []byte, error) {
+	return timestampbits, value, errors.New("checksum mismatch")
+}
+
+func TestRemoteReadFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remoteread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingPlaceholder{ring: r}
+	vs := New(&Config{Path: dir, IgnoreEnv: true, MsgRing: m, RemoteReadFallback: true, RemoteReadTimeout: 10})
+	defer vs.Close()
+	vs.EnableWrites()
+	if _, err = vs.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	timestampbits, blockID, offset, length := vs.vlm.Get(1, 2)
+	bad := vs.addValueLocBlock(&testRemoteReadBlock{})
+	vs.vlm.Set(1, 2, timestampbits, bad, offset, length, true)
+	// Nothing answers, so the error is returned, but the bad entry is gone.
+	if _, _, err = vs.Read(1, 2, nil); err == nil || err == ErrNotFound {
+		t.Fatal(err)
+	}
+	m.lock.Lock()
+	sent := len(m.msgToPartitions)
+	m.lock.Unlock()
+	if sent != 1 {
+		t.Fatal(sent)
+	}
+	if _, id, _, _ := vs.vlm.Get(1, 2); id != 0 {
+		t.Fatal(id)
+	}
+	// Now another replica answers, with a bulk-set, as soon as asked.
+	vs.vlm.Set(1, 2, timestampbits, bad, offset, length, true)
+	vs.remoteReadState.timeout = 10 * time.Second
+	go func() {
+		for {
+			m.lock.Lock()
+			sent := len(m.msgToPartitions)
+			m.lock.Unlock()
+			if sent > 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := vs.write(1, 2, timestampbits, []byte("value")); err != nil {
+			t.Error(err)
+		}
+	}()
+	ts, value, err := vs.Read(1, 2, nil)
+	if err != nil || ts != 1000 || !bytes.Equal(value, []byte("value")) {
+		t.Fatal(ts, string(value), err)
+	}
+	if _, id, _, _ := vs.vlm.Get(1, 2); id == bad || id == 0 {
+		t.Fatal(id, blockID)
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.RemoteReads != 2 || stats.RemoteReadRepairs != 1 {
+		t.Fatal(stats.RemoteReads, stats.RemoteReadRepairs)
+	}
+}
+
+func TestInRemoteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remoteread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := ring.NewBuilder(64)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingPlaceholder{ring: r}
+	vs := New(&Config{Path: dir, IgnoreEnv: true, MsgRing: m})
+	defer vs.Close()
+	vs.EnableWrites()
+	if _, err = vs.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, _REMOTE_READ_MSG_LENGTH)
+	binary.BigEndian.PutUint64(body, 5)
+	binary.BigEndian.PutUint64(body[8:], 1)
+	binary.BigEndian.PutUint64(body[16:], 2)
+	if n, err := vs.newInRemoteReadMsg(bytes.NewReader(body), uint64(len(body))); err != nil || n != uint64(len(body)) {
+		t.Fatal(n, err)
+	}
+	if n, err := vs.newInRemoteReadMsg(bytes.NewReader(body[:8]), 8); err != nil || n != 8 {
+		t.Fatal(n, err)
+	}
+	for {
+		m.lock.Lock()
+		nodeIDs := append([]uint64{}, m.msgToNodeIDs...)
+		m.lock.Unlock()
+		if len(nodeIDs) > 0 {
+			if len(nodeIDs) != 1 || nodeIDs[0] != 5 {
+				t.Fatal(nodeIDs)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.InRemoteReads != 1 || stats.InRemoteReadInvalids != 1 {
+		t.Fatal(stats.InRemoteReads, stats.InRemoteReadInvalids)
+	}
+}
+
+func TestReadRepair(t *testing.T) {
+	dir1, err := ioutil.TempDir("", "remoteread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir1)
+	dir2, err := ioutil.TempDir("", "remoteread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n1, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1 := b.Ring()
+	r1.SetLocalNode(n1.ID())
+	r2 := b.Ring()
+	r2.SetLocalNode(n2.ID())
+	m1 := &msgRingMerkleTester{ring: r1}
+	m2 := &msgRingMerkleTester{ring: r2, peer: m1}
+	m1.peer = m2
+	vs1 := New(&Config{Path: dir1, IgnoreEnv: true, MsgRing: m1, ReadRepair: true, RemoteReadTimeout: 10000})
+	vs1.EnableWrites()
+	defer vs1.Close()
+	vs2 := New(&Config{Path: dir2, IgnoreEnv: true, MsgRing: m2})
+	vs2.EnableWrites()
+	defer vs2.Close()
+	if _, err = vs2.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	// A miss waits for the other replica's copy.
+	ts, value, err := vs1.Read(1, 2, nil)
+	if err != nil || ts != 1000 || string(value) != "value" {
+		t.Fatal(ts, string(value), err)
+	}
+	// A hit returns the local copy, stale or not, and the newer one arrives
+	// for later reads.
+	if _, err = vs2.Write(1, 2, 2000, []byte("newer")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, value, err = vs1.Read(1, 2, nil); err != nil || ts != 1000 || string(value) != "value" {
+		t.Fatal(ts, string(value), err)
+	}
+	for i := 0; i < 100 && ts != 2000; i++ {
+		time.Sleep(10 * time.Millisecond)
+		ts, _, _ = vs1.Lookup(1, 2)
+	}
+	if ts != 2000 {
+		t.Fatal(ts)
+	}
+	// Nothing newer elsewhere, so nothing is sent back.
+	vs2.Stats(false)
+	if ts, value, err = vs1.Read(1, 2, nil); err != nil || ts != 2000 || string(value) != "newer" {
+		t.Fatal(ts, string(value), err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if stats2 := vs2.Stats(false).(*Stats); stats2.InRemoteReads != 1 || stats2.OutBulkSets != 0 {
+		t.Fatal(stats2.InRemoteReads, stats2.OutBulkSets)
+	}
+	stats1 := vs1.Stats(false).(*Stats)
+	if stats1.ReadRepairQueries != 3 || stats1.ReadRepairs != 1 {
+		t.Fatal(stats1.ReadRepairQueries, stats1.ReadRepairs)
+	}
+}
@@ -0,0 +1,322 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gholt/ring"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// remoteReplicationCheckpoint: header:32 timestampbits:8
+const _REMOTE_REPLICATION_CHECKPOINT_HEADER = "VALUESTOREREMOTECHECKPOINT v0   "
+const _REMOTE_REPLICATION_CHECKPOINT_NAME = "valuestore.remotecheckpoint"
+
+// remoteReplicationState tails the local writes and ships them, in batches,
+// to the Config.RemoteMsgRing; another cluster on its own ring, usually in
+// another datacenter, kept as a disaster recovery copy.
+//
+// Only the keys are queued as writes are made; the values are read when a
+// batch is shipped, so a key written many times in between is sent once.
+// With a local ring, only the first responsible replica of a partition ships
+// its keys, rather than every replica sending the same data.
+//
+// After each batch, the checkpoint is moved up to when the batch was taken
+// and saved to PathTOC. After a restart, or if the queue overflowed, the
+// first pass scans for every key with a timestamp at or past the checkpoint
+// and ships those. Note that the checkpoint goes by the writes' timestamps,
+// so a write arriving with a timestamp older than the checkpoint and lost to
+// a restart before being shipped will not be found by that scan.
+//
+// Being meant for links between datacenters, the values may be compressed
+// for the trip, with codec, and the messages held to bytesPerSec by a token
+// bucket; both are independent of the settings for replication within the
+// local ring.
+type remoteReplicationState struct {
+	msgRing    ring.MsgRing
+	interval   time.Duration
+	batch      int
+	max        int
+	msgTimeout time.Duration
+	name       string
+	notifyChan chan struct{}
+	lock       sync.Mutex
+	keys       map[bulkSetKey]struct{}
+	// oldest is the UnixNano when the oldest queued key was queued, or 0.
+	oldest int64
+	// checkpoint is the timestampbits at or past which keys may not yet have
+	// been shipped.
+	checkpoint uint64
+	// catchUp is set when keys may have been missed from the queue, so the
+	// next pass must scan from the checkpoint.
+	catchUp     bool
+	codec       byte
+	zstdEncoder *zstd.Encoder
+	bytesPerSec float64
+	tokens      float64
+	tokensLast  time.Time
+}
+
+func (vs *DefaultValueStore) remoteReplicationConfig(cfg *Config) {
+	s := &vs.remoteReplicationState
+	s.msgRing = vs.msgAuthWrap(cfg.RemoteMsgRing)
+	s.interval = time.Duration(cfg.RemoteReplicationInterval) * time.Millisecond
+	s.batch = cfg.RemoteReplicationBatch
+	s.max = cfg.RemoteReplicationBacklog
+	s.msgTimeout = time.Duration(cfg.RemoteReplicationMsgTimeout) * time.Millisecond
+	s.name = path.Join(vs.pathtoc, _REMOTE_REPLICATION_CHECKPOINT_NAME)
+	s.notifyChan = make(chan struct{}, 1)
+	s.keys = make(map[bulkSetKey]struct{})
+	s.codec = compressionCodecs[cfg.RemoteReplicationCompression]
+	if s.codec == _COMPRESSION_ZSTD {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			vs.logError("error setting up remote replication compression, using none: %s\n", err)
+			s.codec = _COMPRESSION_NONE
+		}
+		s.zstdEncoder = enc
+	}
+	s.bytesPerSec = float64(cfg.RemoteReplicationMaxBytesPerSec)
+	s.tokens = s.bytesPerSec
+	s.tokensLast = time.Now()
+	if s.msgRing != nil {
+		vs.remoteReplicationLoad()
+	}
+}
+
+func (vs *DefaultValueStore) remoteReplicationLaunch() {
+	if vs.remoteReplicationState.msgRing != nil {
+		vs.closeState.backgroundWG.Add(1)
+		go vs.remoteReplicationLauncher()
+	}
+}
+
+func (vs *DefaultValueStore) remoteReplicationLauncher() {
+	defer vs.closeState.backgroundWG.Done()
+	for {
+		select {
+		case <-vs.remoteReplicationState.notifyChan:
+		case <-time.After(vs.remoteReplicationState.interval):
+		case <-vs.closeState.backgroundChan:
+			return
+		}
+		vs.remoteReplicationPass()
+	}
+}
+
+// remoteReplicationAdd queues a key just written locally to be shipped.
+func (vs *DefaultValueStore) remoteReplicationAdd(keyA uint64, keyB uint64, timestampbits uint64) {
+	s := &vs.remoteReplicationState
+	if s.msgRing == nil || timestampbits&(_TSB_COMPACTION_REWRITE|_TSB_LOCAL_REMOVAL) != 0 {
+		return
+	}
+	if vs.msgRing != nil {
+		if r := vs.msgRing.Ring(); r != nil && r.ResponsibleReplica(uint32(keyA>>(64-r.PartitionBitCount()))) != 0 {
+			return
+		}
+	}
+	s.lock.Lock()
+	if len(s.keys) < s.max {
+		s.keys[bulkSetKey{keyA, keyB}] = struct{}{}
+		if s.oldest == 0 {
+			s.oldest = time.Now().UnixNano()
+		}
+	} else if !s.catchUp {
+		s.catchUp = true
+		atomic.AddInt32(&vs.remoteReplicationOverflows, 1)
+	}
+	full := len(s.keys) >= s.batch
+	s.lock.Unlock()
+	if full {
+		select {
+		case s.notifyChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// remoteReplicationLag returns how long the oldest key waiting to be shipped
+// has been waiting.
+func (vs *DefaultValueStore) remoteReplicationLag() time.Duration {
+	s := &vs.remoteReplicationState
+	s.lock.Lock()
+	oldest := s.oldest
+	s.lock.Unlock()
+	if oldest == 0 {
+		return 0
+	}
+	return time.Duration(time.Now().UnixNano() - oldest)
+}
+
+// remoteReplicationSend sends the message to the RemoteMsgRing, first waiting
+// as needed to stay within RemoteReplicationMaxBytesPerSec. Like
+// compactionThrottle, a message larger than the budget left is let through,
+// the wait coming before the next one instead.
+func (vs *DefaultValueStore) remoteReplicationSend(bsm *bulkSetMsg, partition uint32) {
+	s := &vs.remoteReplicationState
+	length := bsm.MsgLength()
+	if s.bytesPerSec > 0 {
+		now := time.Now()
+		s.tokens += now.Sub(s.tokensLast).Seconds() * s.bytesPerSec
+		if s.tokens > s.bytesPerSec {
+			s.tokens = s.bytesPerSec
+		}
+		s.tokensLast = now
+		if s.tokens <= 0 {
+			atomic.AddInt32(&vs.remoteReplicationThrottles, 1)
+			select {
+			case <-time.After(time.Duration(-s.tokens / s.bytesPerSec * float64(time.Second))):
+			case <-vs.closeState.backgroundChan:
+			}
+		}
+		s.tokens -= float64(length)
+	}
+	atomic.AddInt64(&vs.remoteReplicationBytes, int64(length))
+	s.msgRing.MsgToOtherReplicas(bsm, partition, s.msgTimeout)
+}
+
+// remoteReplicationPass ships the queued keys, scanning for any missed keys
+// first if need be, and then moves the checkpoint up.
+func (vs *DefaultValueStore) remoteReplicationPass() {
+	s := &vs.remoteReplicationState
+	r := s.msgRing.Ring()
+	if r == nil {
+		return
+	}
+	now := uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS
+	s.lock.Lock()
+	keys := s.keys
+	s.keys = make(map[bulkSetKey]struct{})
+	s.oldest = 0
+	catchUp := s.catchUp
+	s.catchUp = false
+	checkpoint := s.checkpoint
+	s.lock.Unlock()
+	rightwardPartitionShift := 64 - uint64(r.PartitionBitCount())
+	// Only one message is built at a time, as holding one per partition could
+	// use up the outgoing bulk-set messages; keys are shipped in key order so
+	// each partition's keys usually share a message.
+	var bsm *bulkSetMsg
+	var bsmPartition uint32
+	valbuf := make([]byte, vs.valueCap)
+	var cbuf []byte
+	ship := func(k bulkSetKey) {
+		timestampbits, v, err := vs.read(k.keyA, k.keyB, valbuf[:0])
+		if err == ErrNotFound {
+			if timestampbits == 0 {
+				return
+			}
+		} else if err != nil {
+			return
+		}
+		if timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+			return
+		}
+		if s.codec != _COMPRESSION_NONE {
+			cbuf = compressValue(s.codec, s.zstdEncoder, cbuf, v)
+			v = cbuf
+		}
+		partition := uint32(k.keyA >> rightwardPartitionShift)
+		if bsm != nil && (partition != bsmPartition || !bsm.add(k.keyA, k.keyB, timestampbits, v)) {
+			vs.remoteReplicationSend(bsm, bsmPartition)
+			bsm = nil
+		}
+		if bsm == nil {
+			bsm = vs.newOutBulkSetMsg()
+			bsm.setAckPolicy(BULK_SET_ACK_NONE)
+			if s.codec != _COMPRESSION_NONE {
+				bsm.setCompressed()
+			}
+			bsmPartition = partition
+			bsm.add(k.keyA, k.keyB, timestampbits, v)
+		}
+		atomic.AddInt32(&vs.remoteReplicationValues, 1)
+	}
+	sorted := make(keyPairList, 0, len(keys)*2)
+	for k := range keys {
+		sorted = append(sorted, k.keyA, k.keyB)
+	}
+	sort.Sort(sorted)
+	for i := 0; i < len(sorted); i += 2 {
+		ship(bulkSetKey{sorted[i], sorted[i+1]})
+	}
+	if catchUp {
+		list := make([]bulkSetKey, 0, _SCAN_BATCH_SIZE)
+		var start uint64
+		more := true
+		for more {
+			list = list[:0]
+			start, more = vs.vlm.ScanCallback(start, math.MaxUint64, 0, _TSB_LOCAL_REMOVAL, math.MaxUint64, _SCAN_BATCH_SIZE, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+				if timestampbits >= checkpoint {
+					list = append(list, bulkSetKey{keyA, keyB})
+				}
+				return true
+			})
+			for _, k := range list {
+				if _, ok := keys[k]; !ok {
+					ship(k)
+				}
+			}
+		}
+	}
+	if bsm != nil {
+		vs.remoteReplicationSend(bsm, bsmPartition)
+	}
+	if len(keys) == 0 && !catchUp {
+		return
+	}
+	s.lock.Lock()
+	if now > s.checkpoint {
+		s.checkpoint = now
+	}
+	s.lock.Unlock()
+	vs.remoteReplicationSave(now)
+}
+
+// remoteReplicationSave writes out the checkpoint under a temporary name and
+// then renames it, so a crash midway leaves the previous file intact.
+func (vs *DefaultValueStore) remoteReplicationSave(checkpoint uint64) {
+	name := vs.remoteReplicationState.name
+	b := make([]byte, len(_REMOTE_REPLICATION_CHECKPOINT_HEADER)+8)
+	copy(b, _REMOTE_REPLICATION_CHECKPOINT_HEADER)
+	binary.BigEndian.PutUint64(b[len(_REMOTE_REPLICATION_CHECKPOINT_HEADER):], checkpoint)
+	err := ioutil.WriteFile(name+".tmp", b, 0644)
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		vs.logError("error saving remote replication checkpoint %s: %s\n", name, err)
+		os.Remove(name + ".tmp")
+	}
+}
+
+// remoteReplicationLoad reads the checkpoint saved before a restart and sets
+// up the first pass to catch up from it. Without one, shipping starts with
+// the writes from now on; existing data is left to be seeded some other way.
+func (vs *DefaultValueStore) remoteReplicationLoad() {
+	s := &vs.remoteReplicationState
+	b, err := ioutil.ReadFile(s.name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			vs.logError("error reading remote replication checkpoint %s: %s\n", s.name, err)
+		}
+		s.checkpoint = uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS
+		vs.remoteReplicationSave(s.checkpoint)
+		return
+	}
+	if len(b) != len(_REMOTE_REPLICATION_CHECKPOINT_HEADER)+8 || string(b[:len(_REMOTE_REPLICATION_CHECKPOINT_HEADER)]) != _REMOTE_REPLICATION_CHECKPOINT_HEADER {
+		vs.logError("bad remote replication checkpoint %s; catching up from the start\n", s.name)
+		s.catchUp = true
+		return
+	}
+	s.checkpoint = binary.BigEndian.Uint64(b[len(_REMOTE_REPLICATION_CHECKPOINT_HEADER):])
+	s.catchUp = true
+}
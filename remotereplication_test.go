@@ -0,0 +1,206 @@
+package valuestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+)
+
+func TestRemoteReplication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotereplication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := ring.NewBuilder(64)
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	remote := &msgRingPlaceholder{ring: b.Ring()}
+	cfg := &Config{Path: dir, IgnoreEnv: true, RemoteMsgRing: remote, RemoteReplicationInterval: 3600000}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, err = vs.Write(keyA, 2, 1000, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = vs.Delete(1, 2, 2000); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	if stats := vs.Stats(false).(*Stats); stats.RemoteReplicationLag <= 0 {
+		t.Fatal(stats.RemoteReplicationLag)
+	}
+	vs.remoteReplicationPass()
+	stats := vs.Stats(false).(*Stats)
+	if stats.RemoteReplicationValues != 100 || stats.RemoteReplicationLag != 0 {
+		t.Fatal(stats.RemoteReplicationValues, stats.RemoteReplicationLag)
+	}
+	remote.lock.Lock()
+	sent := len(remote.msgToPartitions)
+	remote.lock.Unlock()
+	if sent == 0 {
+		t.Fatal(sent)
+	}
+	// Nothing queued means nothing more to ship.
+	vs.remoteReplicationPass()
+	if stats = vs.Stats(false).(*Stats); stats.RemoteReplicationValues != 0 {
+		t.Fatal(stats.RemoteReplicationValues)
+	}
+	checkpoint := vs.remoteReplicationState.checkpoint
+	vs.Close()
+	// After a restart, keys written at or past the checkpoint are found by a
+	// scan, as any queued keys were lost.
+	vs2 := New(cfg)
+	defer vs2.Close()
+	if vs2.remoteReplicationState.checkpoint != checkpoint || !vs2.remoteReplicationState.catchUp {
+		t.Fatal(vs2.remoteReplicationState.checkpoint, checkpoint)
+	}
+	vs2.remoteReplicationState.checkpoint = 2000 << _TSB_UTIL_BITS
+	vs2.remoteReplicationPass()
+	if stats = vs2.Stats(false).(*Stats); stats.RemoteReplicationValues != 1 {
+		t.Fatal(stats.RemoteReplicationValues)
+	}
+}
+
+func TestRemoteReplicationOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotereplication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := ring.NewBuilder(64)
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	vs := New(&Config{Path: dir, IgnoreEnv: true, RemoteMsgRing: &msgRingPlaceholder{ring: b.Ring()}, RemoteReplicationInterval: 3600000, RemoteReplicationBatch: 1000, RemoteReplicationBacklog: 10})
+	defer vs.Close()
+	vs.EnableWrites()
+	vs.remoteReplicationState.checkpoint = 0
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, err = vs.Write(keyA, 2, 1000, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	// The keys that didn't fit in the queue are found by a scan instead.
+	vs.remoteReplicationPass()
+	stats := vs.Stats(false).(*Stats)
+	if stats.RemoteReplicationOverflows != 1 || stats.RemoteReplicationValues != 100 {
+		t.Fatal(stats.RemoteReplicationOverflows, stats.RemoteReplicationValues)
+	}
+}
+
+func TestRemoteReplicationCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotereplication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := ring.NewBuilder(64)
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	remote := &msgRingHolder{ring: b.Ring()}
+	vs := New(&Config{Path: dir, IgnoreEnv: true, RemoteMsgRing: remote, RemoteReplicationInterval: 3600000, RemoteReplicationCompression: "snappy"})
+	defer vs.Close()
+	vs.EnableWrites()
+	value := bytes.Repeat([]byte("compressible"), 100)
+	for keyA := uint64(1); keyA <= 20; keyA++ {
+		if _, err = vs.Write(keyA, 2, 1000, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = vs.Delete(20, 2, 2000); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	vs.remoteReplicationPass()
+	// The keys are all in the one partition, so share a message.
+	remote.lock.Lock()
+	held := remote.held
+	remote.lock.Unlock()
+	if len(held) != 1 {
+		t.Fatal(len(held))
+	}
+	bsm := held[0].(*bulkSetMsg)
+	if !bsm.compressed() || len(bsm.body) >= 20*len(value) {
+		t.Fatal(bsm.header[16], len(bsm.body))
+	}
+	if stats := vs.Stats(false).(*Stats); stats.RemoteReplicationValues != 20 || stats.RemoteReplicationBytes != int64(bsm.MsgLength()) {
+		t.Fatal(stats.RemoteReplicationValues, stats.RemoteReplicationBytes)
+	}
+	// The receiving end, without compression of its own, decompresses the
+	// values as it applies them.
+	b2 := ring.NewBuilder(64)
+	n, err := b2.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b2.Ring()
+	r.SetLocalNode(n.ID())
+	vs2 := newTestStore(t, &Config{MsgRing: &msgRingPlaceholder{ring: r}, InBulkSetWorkers: 1, InBulkSetMsgs: 1})
+	vs2.EnableAll()
+	defer vs2.DisableAll()
+	in := <-vs2.bulkSetState.inFreeMsgChan
+	copy(in.header, bsm.header)
+	in.body = append(in.body[:0], bsm.body...)
+	vs2.bulkSetState.inMsgChan <- in
+	// There is only the one message, so getting it back means it was applied.
+	in = <-vs2.bulkSetState.inFreeMsgChan
+	for keyA := uint64(1); keyA < 20; keyA++ {
+		if _, v, err := vs2.Read(keyA, 2, nil); err != nil || !bytes.Equal(v, value) {
+			t.Fatal(keyA, err, len(v))
+		}
+	}
+	if ts, _, err := vs2.Read(20, 2, nil); err != ErrNotFound || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	// A corrupt message is dropped.
+	copy(in.header, bsm.header)
+	in.body = append(in.body[:0], bsm.body...)
+	in.body[_BULK_SET_MSG_ENTRY_HEADER_LENGTH] = 0xff
+	vs2.bulkSetState.inMsgChan <- in
+	<-vs2.bulkSetState.inFreeMsgChan
+	if stats := vs2.Stats(false).(*Stats); stats.InBulkSetInvalids != 1 {
+		t.Fatal(stats.InBulkSetInvalids)
+	}
+}
+
+func TestRemoteReplicationMaxBytesPerSec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotereplication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := ring.NewBuilder(64)
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	vs := New(&Config{Path: dir, IgnoreEnv: true, RemoteMsgRing: &msgRingPlaceholder{ring: b.Ring()}, RemoteReplicationInterval: 3600000, RemoteReplicationMaxBytesPerSec: 100000})
+	defer vs.Close()
+	vs.EnableWrites()
+	// Keys in different partitions go in different messages.
+	value := make([]byte, 10000)
+	for i := uint64(0); i < 30; i++ {
+		if _, err = vs.Write(i<<58, 2, 1000, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	begin := time.Now()
+	vs.remoteReplicationPass()
+	// 300000 bytes with a 100000 byte burst allowed is two seconds' worth.
+	if elapsed := time.Since(begin); elapsed < 1500*time.Millisecond {
+		t.Fatal(elapsed)
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.RemoteReplicationValues != 30 || stats.RemoteReplicationThrottles == 0 || stats.RemoteReplicationBytes < 300000 {
+		t.Fatal(stats.RemoteReplicationValues, stats.RemoteReplicationThrottles, stats.RemoteReplicationBytes)
+	}
+}
@@ -0,0 +1,94 @@
+package valuestore
+
+import (
+	"math"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// replicationCodecState compresses, with the Config.ReplicationCompression
+// codec, the values in the bulk-set messages of pull replication responses,
+// push replication, and handoff, flagged as for remote replication, and the
+// filters sent in pull-replication messages. Receivers handle either way, so
+// each node only decides what it sends.
+type replicationCodecState struct {
+	codec       byte
+	zstdEncoder *zstd.Encoder
+	// bufs holds *[]byte buffers values are compressed into on their way
+	// into a message.
+	bufs sync.Pool
+}
+
+func (vs *DefaultValueStore) replicationCodecConfig(cfg *Config) {
+	s := &vs.replicationCodecState
+	s.codec = compressionCodecs[cfg.ReplicationCompression]
+	if s.codec == _COMPRESSION_ZSTD {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			vs.logError("error setting up replication compression, using none: %s\n", err)
+			s.codec = _COMPRESSION_NONE
+		}
+		s.zstdEncoder = enc
+	}
+	s.bufs.New = func() interface{} {
+		b := make([]byte, 0, vs.valueCap)
+		return &b
+	}
+}
+
+// newOutReplicationBulkSetMsg is newOutBulkSetMsg for a message whose values
+// are added with outReplicationAdd.
+func (vs *DefaultValueStore) newOutReplicationBulkSetMsg() *bulkSetMsg {
+	bsm := vs.newOutBulkSetMsg()
+	if vs.replicationCodecState.codec != _COMPRESSION_NONE {
+		bsm.setCompressed()
+	}
+	return bsm
+}
+
+// outReplicationAdd is bulkSetMsg.add for a message from
+// newOutReplicationBulkSetMsg, compressing the value if the message is.
+func (vs *DefaultValueStore) outReplicationAdd(bsm *bulkSetMsg, keyA uint64, keyB uint64, timestampbits uint64, value []byte) bool {
+	if !bsm.compressed() {
+		return bsm.add(keyA, keyB, timestampbits, value)
+	}
+	s := &vs.replicationCodecState
+	buf := s.bufs.Get().(*[]byte)
+	*buf = compressValue(s.codec, s.zstdEncoder, *buf, value)
+	added := bsm.add(keyA, keyB, timestampbits, *buf)
+	s.bufs.Put(buf)
+	return added
+}
+
+// pullReplicationDeflate compresses the filter in the outgoing message's
+// body, if Config.ReplicationCompression is set and that makes it smaller,
+// leaving the body at its compressed length.
+func (vs *DefaultValueStore) pullReplicationDeflate(prm *pullReplicationMsg, headerOffset int) {
+	prm.header[headerOffset+_KT_FILTER_FLAGS_OFFSET] &^= _KT_FILTER_FLAG_COMPRESSED
+	s := &vs.replicationCodecState
+	if s.codec == _COMPRESSION_NONE {
+		return
+	}
+	prm.spare = compressValue(s.codec, s.zstdEncoder, prm.spare, prm.body)
+	if len(prm.spare) < len(prm.body) {
+		prm.body = prm.body[:copy(prm.body, prm.spare)]
+		prm.header[headerOffset+_KT_FILTER_FLAGS_OFFSET] |= _KT_FILTER_FLAG_COMPRESSED
+	}
+}
+
+// pullReplicationInflate replaces the incoming message's body with the
+// filter it holds decompressed, if it was compressed; inflated is a buffer to
+// decompress into, and the one to use next time is returned.
+func (vs *DefaultValueStore) pullReplicationInflate(prm *pullReplicationMsg, headerOffset int, inflated []byte) ([]byte, error) {
+	if prm.header[headerOffset+_KT_FILTER_FLAGS_OFFSET]&_KT_FILTER_FLAG_COMPRESSED == 0 {
+		return inflated, nil
+	}
+	inflated, err := vs.decompressMax(inflated[:0], prm.body, math.MaxInt32)
+	if err != nil {
+		return inflated, err
+	}
+	prm.body, inflated = inflated, prm.body
+	prm.header[headerOffset+_KT_FILTER_FLAGS_OFFSET] &^= _KT_FILTER_FLAG_COMPRESSED
+	return inflated, nil
+}
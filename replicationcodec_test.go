@@ -0,0 +1,74 @@
+package valuestore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gholt/ring"
+)
+
+func TestReplicationCompression(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingHolder{ring: r}
+	vs := New(&Config{Path: t.TempDir(), MsgRing: m, ReplicationCompression: "snappy"})
+	vs.EnableWrites()
+	defer vs.Close()
+	value := bytes.Repeat([]byte("a"), 1000)
+	if _, err = vs.write(1, 2, 0x300, value); err != nil {
+		t.Fatal(err)
+	}
+	vs.outPullReplicationKeys(7, 0, []uint64{1, 2}, nil)
+	m.lock.Lock()
+	held := m.held
+	m.lock.Unlock()
+	if len(held) != 1 {
+		t.Fatal(len(held))
+	}
+	bsm := held[0].(*bulkSetMsg)
+	if !bsm.compressed() || len(bsm.body) >= len(value) {
+		t.Fatal(bsm.header[16], len(bsm.body))
+	}
+	entries, err := vs.bulkSetInflate(nil, bsm.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(entries[_BULK_SET_MSG_ENTRY_HEADER_LENGTH:], value) {
+		t.Fatal(entries)
+	}
+	bsm.Free()
+	// A mostly empty filter compresses to next to nothing.
+	f := vs.newOutKTFilter()
+	f.add(1, 2, 0x300)
+	out := vs.newOutPullReplicationMsg(r.Version(), 0, 0x400, 0, 1<<60, f)
+	if out.header[_PULL_REPLICATION_MSG_HEADER_BYTES+_KT_FILTER_FLAGS_OFFSET]&_KT_FILTER_FLAG_COMPRESSED == 0 || len(out.body) >= f.msgBodyLength()/10 {
+		t.Fatal(len(out.body), f.msgBodyLength())
+	}
+	prm := &pullReplicationMsg{vs: vs, header: append([]byte{}, out.header...), body: append([]byte{}, out.body...)}
+	out.Free()
+	if _, err = vs.pullReplicationInflate(prm, _PULL_REPLICATION_MSG_HEADER_BYTES, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f2 := prm.ktFilter(); f2 == nil || !f2.mayHave(1, 2, 0x300) || f2.mayHave(1, 3, 0x300) {
+		t.Fatal(f2)
+	}
+	// The next message from the same pool gets its full length back.
+	f.reset(1)
+	out = vs.newOutPullReplicationMsg(r.Version(), 0, 0x400, 0, 1<<60, f)
+	vs.replicationCodecState.codec = _COMPRESSION_NONE
+	out2 := vs.newOutPullReplicationMsg(r.Version(), 0, 0x400, 0, 1<<60, f)
+	if len(out2.body) != f.msgBodyLength() || out2.header[_PULL_REPLICATION_MSG_HEADER_BYTES+_KT_FILTER_FLAGS_OFFSET]&_KT_FILTER_FLAG_COMPRESSED != 0 {
+		t.Fatal(len(out2.body))
+	}
+	out.Free()
+	out2.Free()
+}
@@ -0,0 +1,98 @@
+package valuestore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// ReplicationLagStats estimates how far behind a peer, or the replicas of a
+// partition, are, going by what incoming pull replication requests turned out
+// to be missing and were sent in response.
+type ReplicationLagStats struct {
+	// NodeID is the peer the estimate is for; 0 in a partition's estimate.
+	NodeID uint64
+	// Partition is the partition the estimate is for; 0 in a peer's.
+	Partition uint32
+	// Missing is the number of keys sent for being missing: for a peer,
+	// since the last Stats call; for a partition, in response to its most
+	// recent request.
+	Missing int
+	// Lag is how long ago the oldest of those values was written, as of
+	// when it was sent; with anti-entropy keeping up, this stays around
+	// Config.ReplicationIgnoreRecent.
+	Lag time.Duration
+}
+
+func (l *ReplicationLagStats) String() string {
+	return fmt.Sprintf("%d missing, lag %s", l.Missing, l.Lag)
+}
+
+// replicationLagState records, for each pull replication request answered,
+// how many keys the requester was missing and the oldest timestamp among
+// them. Peers' figures add up until the next Stats call; a partition's are
+// replaced by each request for it, and dropped once one is missing nothing.
+type replicationLagState struct {
+	lock       sync.Mutex
+	nodes      map[uint64]*ReplicationLagStats
+	partitions map[uint32]*ReplicationLagStats
+}
+
+func (vs *DefaultValueStore) replicationLagConfig(cfg *Config) {
+	vs.replicationLagState.nodes = make(map[uint64]*ReplicationLagStats)
+	vs.replicationLagState.partitions = make(map[uint32]*ReplicationLagStats)
+}
+
+// replicationLagRecord notes a response to nodeID's pull replication request
+// for the partition that sent missing keys, the oldest with timestampbits
+// oldest.
+func (vs *DefaultValueStore) replicationLagRecord(nodeID uint64, partition uint32, missing int, oldest uint64) {
+	var lag time.Duration
+	if missing > 0 {
+		lag = time.Duration(brimtime.TimeToUnixMicro(time.Now())-int64(oldest>>_TSB_UTIL_BITS)) * time.Microsecond
+	}
+	s := &vs.replicationLagState
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if missing == 0 {
+		delete(s.partitions, partition)
+	} else {
+		s.partitions[partition] = &ReplicationLagStats{Partition: partition, Missing: missing, Lag: lag}
+	}
+	n := s.nodes[nodeID]
+	if n == nil {
+		n = &ReplicationLagStats{NodeID: nodeID}
+		s.nodes[nodeID] = n
+	}
+	n.Missing += missing
+	if lag > n.Lag {
+		n.Lag = lag
+	}
+}
+
+// replicationLagStats returns the peers' and partitions' estimates, in order,
+// starting the peers' over.
+func (vs *DefaultValueStore) replicationLagStats() ([]ReplicationLagStats, []ReplicationLagStats) {
+	s := &vs.replicationLagState
+	s.lock.Lock()
+	nodes := make([]ReplicationLagStats, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, *n)
+	}
+	s.nodes = make(map[uint64]*ReplicationLagStats)
+	partitions := make([]ReplicationLagStats, 0, len(s.partitions))
+	for _, p := range s.partitions {
+		partitions = append(partitions, *p)
+	}
+	s.lock.Unlock()
+	sort.Slice(nodes, func(i int, j int) bool {
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+	sort.Slice(partitions, func(i int, j int) bool {
+		return partitions[i].Partition < partitions[j].Partition
+	})
+	return nodes, partitions
+}
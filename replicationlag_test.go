@@ -0,0 +1,51 @@
+package valuestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gholt/ring"
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+func TestReplicationLag(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.AddNode(true, 1, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	vs := New(&Config{Path: t.TempDir(), MsgRing: &msgRingPullReplicationTester{ring: r}})
+	vs.EnableWrites()
+	defer vs.Close()
+	now := brimtime.TimeToUnixMicro(time.Now())
+	if _, err = vs.Write(1, 2, now-int64(10*time.Second/time.Microsecond), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vs.Write(1, 3, now, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	vs.outPullReplicationKeys(7, 5, []uint64{1, 2, 1, 3, 1, 4}, nil)
+	stats := vs.Stats(false).(*Stats)
+	if len(stats.ReplicationLagNodes) != 1 || len(stats.ReplicationLagPartitions) != 1 {
+		t.Fatal(stats.ReplicationLagNodes, stats.ReplicationLagPartitions)
+	}
+	if l := stats.ReplicationLagNodes[0]; l.NodeID != 7 || l.Missing != 2 || l.Lag < 10*time.Second || l.Lag > time.Minute {
+		t.Fatalf("%#v", l)
+	}
+	if l := stats.ReplicationLagPartitions[0]; l.Partition != 5 || l.Missing != 2 || l.Lag < 10*time.Second {
+		t.Fatalf("%#v", l)
+	}
+	// A partition caught up is dropped; the peers' figures start over with
+	// each Stats call.
+	vs.outPullReplicationKeys(7, 5, nil, nil)
+	stats = vs.Stats(false).(*Stats)
+	if len(stats.ReplicationLagNodes) != 1 || stats.ReplicationLagNodes[0].Missing != 0 || stats.ReplicationLagNodes[0].Lag != 0 || len(stats.ReplicationLagPartitions) != 0 {
+		t.Fatal(stats.ReplicationLagNodes, stats.ReplicationLagPartitions)
+	}
+}
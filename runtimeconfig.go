@@ -0,0 +1,86 @@
+package valuestore
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// The Set methods change a running ValueStore's settings without a restart.
+// Config reflects the changes; the background launchers pick up new
+// intervals once their current wait is over.
+
+// SetCompactionThreshold changes Config.CompactionThreshold for the
+// compaction passes from now on. Out of range thresholds give the default of
+// 0.10, as with the Config.
+func (vs *DefaultValueStore) SetCompactionThreshold(threshold float64) {
+	if threshold >= 1.0 || threshold <= 0.01 {
+		threshold = 0.10
+	}
+	vs.configLock.Lock()
+	vs.config.CompactionThreshold = threshold
+	atomic.StoreUint64(&vs.compactionState.threshold, math.Float64bits(threshold))
+	vs.configLock.Unlock()
+}
+
+// SetCompactionMaxBytesPerSec changes Config.CompactionMaxBytesPerSec for the
+// compaction rewrites from now on, including those of a pass already under
+// way. Negative rates give 0, no limit.
+func (vs *DefaultValueStore) SetCompactionMaxBytesPerSec(bytesPerSec int) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	vs.configLock.Lock()
+	vs.config.CompactionMaxBytesPerSec = bytesPerSec
+	atomic.StoreInt64(&vs.compactionState.bytesPerSec, int64(bytesPerSec))
+	vs.configLock.Unlock()
+}
+
+// SetReplicationIgnoreRecent changes Config.ReplicationIgnoreRecent, in
+// seconds, for the replication passes from now on. Negative values give 0.
+func (vs *DefaultValueStore) SetReplicationIgnoreRecent(seconds int) {
+	if seconds < 0 {
+		seconds = 0
+	}
+	vs.configLock.Lock()
+	vs.config.ReplicationIgnoreRecent = seconds
+	atomic.StoreUint64(&vs.replicationIgnoreRecent, (uint64(seconds)*uint64(time.Second)/1000)<<_TSB_UTIL_BITS)
+	vs.configLock.Unlock()
+}
+
+// SetTombstoneAge changes Config.TombstoneAge, in seconds, for the tombstone
+// discard and replication passes from now on. Negative values give 0.
+func (vs *DefaultValueStore) SetTombstoneAge(seconds int) {
+	if seconds < 0 {
+		seconds = 0
+	}
+	vs.configLock.Lock()
+	vs.config.TombstoneAge = seconds
+	atomic.StoreUint64(&vs.tombstoneDiscardState.age, (uint64(seconds)*uint64(time.Second)/1000)<<_TSB_UTIL_BITS)
+	vs.configLock.Unlock()
+}
+
+// SetBackgroundIntervals changes Config.CompactionInterval,
+// TombstoneDiscardInterval, OutPullReplicationInterval, and
+// OutPushReplicationInterval, in seconds; any given as less than 1 are left
+// as they are.
+func (vs *DefaultValueStore) SetBackgroundIntervals(compaction int, tombstoneDiscard int, outPullReplication int, outPushReplication int) {
+	vs.configLock.Lock()
+	if compaction > 0 {
+		vs.config.CompactionInterval = compaction
+		atomic.StoreInt64(&vs.compactionState.interval, int64(compaction))
+	}
+	if tombstoneDiscard > 0 {
+		vs.config.TombstoneDiscardInterval = tombstoneDiscard
+		atomic.StoreInt64(&vs.tombstoneDiscardState.interval, int64(tombstoneDiscard))
+	}
+	if outPullReplication > 0 {
+		vs.config.OutPullReplicationInterval = outPullReplication
+		atomic.StoreInt64((*int64)(&vs.pullReplicationState.outInterval), int64(time.Duration(outPullReplication)*time.Second))
+	}
+	if outPushReplication > 0 {
+		vs.config.OutPushReplicationInterval = outPushReplication
+		atomic.StoreInt64(&vs.pushReplicationState.outInterval, int64(outPushReplication))
+	}
+	vs.configLock.Unlock()
+}
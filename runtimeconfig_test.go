@@ -0,0 +1,52 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRuntimeConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtimeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	defer vs.Close()
+	vs.EnableWrites()
+	outPullReplicationInterval := vs.Config().OutPullReplicationInterval
+	vs.SetCompactionThreshold(0.25)
+	vs.SetReplicationIgnoreRecent(5)
+	vs.SetTombstoneAge(10)
+	vs.SetBackgroundIntervals(2, 3, 0, 5)
+	cfg := vs.Config()
+	if cfg.CompactionThreshold != 0.25 || cfg.ReplicationIgnoreRecent != 5 || cfg.TombstoneAge != 10 {
+		t.Fatal(cfg.CompactionThreshold, cfg.ReplicationIgnoreRecent, cfg.TombstoneAge)
+	}
+	if cfg.CompactionInterval != 2 || cfg.TombstoneDiscardInterval != 3 || cfg.OutPullReplicationInterval != outPullReplicationInterval || cfg.OutPushReplicationInterval != 5 {
+		t.Fatal(cfg.CompactionInterval, cfg.TombstoneDiscardInterval, cfg.OutPullReplicationInterval, cfg.OutPushReplicationInterval)
+	}
+	if ignore := atomic.LoadUint64(&vs.replicationIgnoreRecent) >> _TSB_UTIL_BITS; ignore != uint64(5*time.Second/time.Microsecond) {
+		t.Fatal(ignore)
+	}
+	if age := atomic.LoadUint64(&vs.tombstoneDiscardState.age) >> _TSB_UTIL_BITS; age != uint64(10*time.Second/time.Microsecond) {
+		t.Fatal(age)
+	}
+	// Deletions older than the new, shorter, tombstone age are discarded.
+	vs.SetTombstoneAge(0)
+	if _, err = vs.Delete(1, 1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	vs.TombstoneDiscardPass()
+	vs.TombstoneDiscardPass()
+	if stats := vs.Stats(false).(*Stats); stats.Tombstones != 0 {
+		t.Fatal(stats.Tombstones)
+	}
+	vs.SetCompactionThreshold(2)
+	if cfg = vs.Config(); cfg.CompactionThreshold != 0.10 {
+		t.Fatal(cfg.CompactionThreshold)
+	}
+}
@@ -0,0 +1,58 @@
+package valuestore
+
+import (
+	"math"
+)
+
+// KeySample is a key chosen by Sample along with its timestamp and the
+// length of its value.
+type KeySample struct {
+	KeyA           uint64
+	KeyB           uint64
+	Timestampmicro int64
+	Length         uint32
+}
+
+// Sample returns up to n distinct keys chosen at random from those with
+// values, not deletion markers, and with keyA from start to stop inclusive.
+//
+// Rather than scanning the whole range, each key is found by scanning from a
+// random point for the next key present; since keyA values are hashes and so
+// evenly spread, this gives a close to uniform sample cheaply. Fewer than n
+// keys are returned if the range doesn't have that many, or if too many
+// random points keep landing on keys already chosen.
+func (vs *DefaultValueStore) Sample(start uint64, stop uint64, n int) []KeySample {
+	notMask := uint64(_TSB_DELETION | _TSB_LOCAL_REMOVAL)
+	samples := make([]KeySample, 0, n)
+	seen := make(map[bulkSetKey]struct{}, n)
+	var sample KeySample
+	found := false
+	callback := func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+		sample = KeySample{KeyA: keyA, KeyB: keyB, Timestampmicro: int64(timestampbits >> _TSB_UTIL_BITS), Length: length}
+		found = true
+		return false
+	}
+	for attempts := 0; len(samples) < n && attempts < n*4; attempts++ {
+		r := uint64(vs.rand.Int63())<<1 ^ uint64(vs.rand.Int63())
+		if stop-start < math.MaxUint64 {
+			r = start + r%(stop-start+1)
+		}
+		found = false
+		vs.vlm.ScanCallback(r, stop, 0, notMask, math.MaxUint64, 1, callback)
+		if !found && r > start {
+			// Nothing past the random point, so wrap around.
+			vs.vlm.ScanCallback(start, r-1, 0, notMask, math.MaxUint64, 1, callback)
+		}
+		if !found {
+			// Nothing in the range at all.
+			break
+		}
+		k := bulkSetKey{sample.KeyA, sample.KeyB}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		samples = append(samples, sample)
+	}
+	return samples
+}
@@ -0,0 +1,39 @@
+package valuestore
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	vs := newTestStore(t, &Config{Rand: rand.New(rand.NewSource(1))})
+	vs.EnableWrites()
+	step := uint64(math.MaxUint64 / 1000)
+	for i := uint64(0); i < 1000; i++ {
+		if _, err := vs.Write(i*step, i, int64(1000+i), make([]byte, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := vs.Delete(0, 0, 5000); err != nil {
+		t.Fatal(err)
+	}
+	samples := vs.Sample(0, math.MaxUint64, 100)
+	if len(samples) != 100 {
+		t.Fatal(len(samples))
+	}
+	seen := map[uint64]bool{}
+	for _, s := range samples {
+		if seen[s.KeyB] || s.KeyB == 0 || s.KeyA != s.KeyB*step || s.Timestampmicro != int64(1000+s.KeyB) || s.Length != uint32(s.KeyB) {
+			t.Fatal(s)
+		}
+		seen[s.KeyB] = true
+	}
+	// A range with only three keys can give only those three.
+	if samples = vs.Sample(10*step-step/2, 12*step+step/2, 10); len(samples) != 3 {
+		t.Fatal(samples)
+	}
+	if samples = vs.Sample(10*step+1, 11*step-1, 10); len(samples) != 0 {
+		t.Fatal(samples)
+	}
+}
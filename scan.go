@@ -0,0 +1,90 @@
+package valuestore
+
+import (
+	"math"
+)
+
+// _SCAN_BATCH_SIZE is how many keys Scan gathers before reading their values.
+const _SCAN_BATCH_SIZE = 1024
+
+// Scan calls callback for each key with a value, not a deletion marker, and
+// with keyA from start to stop inclusive; returning false from callback ends
+// the scan early.
+//
+// Without withValues, callback is given a nil value and is called in key
+// order. With withValues, callback is given the value too; the keys are
+// gathered in batches whose values are then read in values file and offset
+// order, as ReadMulti does, so callback is called in that order instead. Any
+// error reading a value ends the scan and is returned. Values read are
+// counted in the Reads stats.
+func (vs *DefaultValueStore) Scan(start uint64, stop uint64, withValues bool, callback func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool) error {
+	return vs.scan(start, stop, math.MaxUint64, nil, withValues, callback)
+}
+
+// ScanKeys calls callback for each key with a value, not a deletion marker,
+// and with keyA from start to stop inclusive, in key order; returning false
+// from callback ends the scan early. Callback is given the length of the value
+// rather than the value itself, as only the in-memory location map is
+// consulted and nothing is read from disk, making it the cheap way to
+// enumerate keys for audits and migrations.
+func (vs *DefaultValueStore) ScanKeys(start uint64, stop uint64, callback func(keyA uint64, keyB uint64, timestampmicro int64, length uint32) bool) {
+	vs.scanKeys(start, stop, nil, callback)
+}
+
+// scanKeys is ScanKeys but, if keyBFilter is not nil, only for keys it returns
+// true for.
+func (vs *DefaultValueStore) scanKeys(start uint64, stop uint64, keyBFilter func(keyB uint64) bool, callback func(keyA uint64, keyB uint64, timestampmicro int64, length uint32) bool) {
+	vs.vlm.ScanCallback(start, stop, 0, _TSB_DELETION|_TSB_LOCAL_REMOVAL, math.MaxUint64, math.MaxUint64, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+		if keyBFilter != nil && !keyBFilter(keyB) {
+			return true
+		}
+		return callback(keyA, keyB, int64(timestampbits>>_TSB_UTIL_BITS), length)
+	})
+}
+
+// scan is Scan but only for keys with timestampbits below cutoff; values
+// found to have changed to a timestampbits at or past cutoff by the time they
+// are read are skipped too. If keyBFilter is not nil, only keys it returns
+// true for are scanned.
+func (vs *DefaultValueStore) scan(start uint64, stop uint64, cutoff uint64, keyBFilter func(keyB uint64) bool, withValues bool, callback func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool) error {
+	notMask := uint64(_TSB_DELETION | _TSB_LOCAL_REMOVAL)
+	if !withValues {
+		vs.vlm.ScanCallback(start, stop, 0, notMask, cutoff, math.MaxUint64, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+			if keyBFilter != nil && !keyBFilter(keyB) {
+				return true
+			}
+			return callback(keyA, keyB, int64(timestampbits>>_TSB_UTIL_BITS), nil)
+		})
+		return nil
+	}
+	entries := make([]ReadMultiEntry, 0, _SCAN_BATCH_SIZE)
+	more := true
+	for more {
+		entries = entries[:0]
+		start, more = vs.vlm.ScanCallback(start, stop, 0, notMask, cutoff, _SCAN_BATCH_SIZE, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+			if keyBFilter == nil || keyBFilter(keyB) {
+				entries = append(entries, ReadMultiEntry{KeyA: keyA, KeyB: keyB})
+			}
+			return true
+		})
+		var err error
+		if !vs.readMulti(entries, func(e *ReadMultiEntry) bool {
+			if e.Err == ErrNotFound {
+				// Deleted since it was scanned.
+				return true
+			}
+			if e.Err != nil {
+				err = e.Err
+				return false
+			}
+			if uint64(e.Timestampmicro)<<_TSB_UTIL_BITS >= cutoff {
+				// Changed since it was scanned.
+				return true
+			}
+			return callback(e.KeyA, e.KeyB, e.Timestampmicro, e.Value)
+		}) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	defer vs.Close()
+	vs.EnableWrites()
+	for keyA := uint64(1); keyA <= 3000; keyA++ {
+		if _, err := vs.Write(keyA, 2, int64(1000+keyA), []byte(fmt.Sprintf("value%d", keyA))); err != nil {
+			t.Fatal(err)
+		}
+		if keyA%1000 == 0 {
+			vs.Flush()
+		}
+	}
+	if _, err = vs.Delete(5, 2, 5000); err != nil {
+		t.Fatal(err)
+	}
+	var last uint64
+	count := 0
+	if err = vs.Scan(1, 2000, false, func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+		if keyA <= last || keyA == 5 || value != nil || timestampmicro != int64(1000+keyA) {
+			t.Fatal(keyA, last, value, timestampmicro)
+		}
+		last = keyA
+		count++
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1999 {
+		t.Fatal(count)
+	}
+	seen := map[uint64]bool{}
+	if err = vs.Scan(1000, 3000, true, func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+		if seen[keyA] || string(value) != fmt.Sprintf("value%d", keyA) || timestampmicro != int64(1000+keyA) {
+			t.Fatal(keyA, string(value), timestampmicro)
+		}
+		seen[keyA] = true
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2001 {
+		t.Fatal(len(seen))
+	}
+	count = 0
+	if err = vs.Scan(0, 3000, true, func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+		count++
+		return count < 10
+	}); err != nil || count != 10 {
+		t.Fatal(count, err)
+	}
+}
+
+func TestScanKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	vs.EnableWrites()
+	defer vs.Close()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, err := vs.Write(keyA, 2, int64(1000+keyA), []byte(fmt.Sprintf("value%d", keyA))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = vs.Delete(5, 2, 5000); err != nil {
+		t.Fatal(err)
+	}
+	// Values handed off elsewhere are skipped, as deletion markers are.
+	if _, err = vs.write(6, 2, 2000<<_TSB_UTIL_BITS|_TSB_LOCAL_REMOVAL, []byte("value6")); err != nil {
+		t.Fatal(err)
+	}
+	var last uint64
+	count := 0
+	vs.ScanKeys(1, 50, func(keyA uint64, keyB uint64, timestampmicro int64, length uint32) bool {
+		if keyA <= last || keyA == 5 || keyA == 6 || keyB != 2 || timestampmicro != int64(1000+keyA) || length != uint32(len(fmt.Sprintf("value%d", keyA))) {
+			t.Fatal(keyA, last, keyB, timestampmicro, length)
+		}
+		last = keyA
+		count++
+		return true
+	})
+	if count != 48 {
+		t.Fatal(count)
+	}
+	count = 0
+	vs.ScanKeys(0, 100, func(keyA uint64, keyB uint64, timestampmicro int64, length uint32) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Fatal(count)
+	}
+	// Nothing is read from disk.
+	if stats := vs.Stats(false).(*Stats); stats.Reads != 0 {
+		t.Fatal(stats.Reads)
+	}
+}
@@ -0,0 +1,81 @@
+package valuestore
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// Snapshot is returned by DefaultValueStore.Snapshot and gives scans a view
+// of the store as of when it was taken. Release should be called once the
+// Snapshot is no longer wanted.
+//
+// While a Snapshot is held, compaction leaves alone every values file that
+// existed when it was taken, so values the Snapshot's scans go to read are
+// not removed out from under them. Scans only see keys last written before
+// the Snapshot was taken; the location map keeps only the newest version of
+// each key, so a key changed since is left out of the scan rather than shown
+// as it was.
+type Snapshot struct {
+	vs          *DefaultValueStore
+	nanos       int64
+	cutoff      uint64
+	releaseOnce sync.Once
+}
+
+type snapshotState struct {
+	lock      sync.Mutex
+	snapshots map[*Snapshot]struct{}
+}
+
+// Snapshot returns a new Snapshot of the store as it is now.
+func (vs *DefaultValueStore) Snapshot() *Snapshot {
+	return vs.SnapshotAt(brimtime.TimeToUnixMicro(time.Now()))
+}
+
+// SnapshotAt returns a new Snapshot of the store as of timestampmicro, so its
+// scans leave out any key last written after then. As with Snapshot, keys
+// changed after timestampmicro are left out rather than shown as they were,
+// so the view is only complete for keys not changed since.
+func (vs *DefaultValueStore) SnapshotAt(timestampmicro int64) *Snapshot {
+	s := &Snapshot{
+		vs:     vs,
+		nanos:  time.Now().UnixNano(),
+		cutoff: uint64(timestampmicro+1) << _TSB_UTIL_BITS,
+	}
+	vs.snapshotState.lock.Lock()
+	if vs.snapshotState.snapshots == nil {
+		vs.snapshotState.snapshots = make(map[*Snapshot]struct{})
+	}
+	vs.snapshotState.snapshots[s] = struct{}{}
+	vs.snapshotState.lock.Unlock()
+	return s
+}
+
+// Scan is DefaultValueStore.Scan but as of when the Snapshot was taken.
+func (s *Snapshot) Scan(start uint64, stop uint64, withValues bool, callback func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool) error {
+	return s.vs.scan(start, stop, s.cutoff, nil, withValues, callback)
+}
+
+// Release lets compaction go back to the values files the Snapshot pinned.
+func (s *Snapshot) Release() {
+	s.releaseOnce.Do(func() {
+		s.vs.snapshotState.lock.Lock()
+		delete(s.vs.snapshotState.snapshots, s)
+		s.vs.snapshotState.lock.Unlock()
+	})
+}
+
+// snapshotPinned returns true if the values file named namets existed when
+// some unreleased Snapshot was taken.
+func (vs *DefaultValueStore) snapshotPinned(namets int64) bool {
+	vs.snapshotState.lock.Lock()
+	defer vs.snapshotState.lock.Unlock()
+	for s := range vs.snapshotState.snapshots {
+		if namets < s.nanos {
+			return true
+		}
+	}
+	return false
+}
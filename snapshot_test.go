@@ -0,0 +1,95 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	defer vs.Close()
+	vs.EnableWrites()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, err := vs.Write(keyA, 2, int64(1000+keyA), []byte(fmt.Sprintf("value%d", keyA))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	before := time.Now().UnixNano()
+	s := vs.Snapshot()
+	future := time.Now().Add(time.Hour).UnixNano() / 1000
+	if _, err = vs.Write(1, 2, future, []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vs.Write(101, 2, future, []byte("added")); err != nil {
+		t.Fatal(err)
+	}
+	for _, withValues := range []bool{false, true} {
+		count := 0
+		if err = s.Scan(0, 1000, withValues, func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+			if keyA == 1 || keyA == 101 || timestampmicro != int64(1000+keyA) {
+				t.Fatal(withValues, keyA, timestampmicro)
+			}
+			if withValues && string(value) != fmt.Sprintf("value%d", keyA) {
+				t.Fatal(keyA, string(value))
+			}
+			count++
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if count != 99 {
+			t.Fatal(withValues, count)
+		}
+	}
+	if !vs.snapshotPinned(before) {
+		t.Fatal("not pinned")
+	}
+	if vs.snapshotPinned(time.Now().UnixNano()) {
+		t.Fatal("newer file pinned")
+	}
+	s.Release()
+	s.Release()
+	if vs.snapshotPinned(before) {
+		t.Fatal("pinned after release")
+	}
+}
+
+func TestSnapshotAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, IgnoreEnv: true})
+	defer vs.Close()
+	vs.EnableWrites()
+	for keyA := uint64(1); keyA <= 100; keyA++ {
+		if _, err := vs.Write(keyA, 2, int64(1000+keyA), []byte(fmt.Sprintf("value%d", keyA))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := vs.SnapshotAt(1050)
+	defer s.Release()
+	count := 0
+	if err = s.Scan(0, 1000, true, func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool {
+		if timestampmicro > 1050 || string(value) != fmt.Sprintf("value%d", keyA) {
+			t.Fatal(keyA, timestampmicro, string(value))
+		}
+		count++
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 50 {
+		t.Fatal(count)
+	}
+}
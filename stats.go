@@ -13,6 +13,9 @@ type Stats struct {
 	Values uint64
 	// ValuesBytes is the number of bytes of the values in the ValueStore.
 	ValueBytes uint64
+	// Tombstones is the number of deletion markers in the ValueStore not yet
+	// discarded; see Config.TombstoneAge.
+	Tombstones uint64
 	// Lookups is the number of calls to Lookup.
 	Lookups int32
 	// LookupErrors is the number of errors returned by Lookup.
@@ -28,13 +31,132 @@ type Stats struct {
 	// WritesOverridden is the number of calls to Write that resulted in no
 	// change.
 	WritesOverridden int32
+	// WritesBumped is the number of calls to Write that had their timestamp
+	// raised due to Config.MonotonicWrites.
+	WritesBumped int32
 	// Deletes is the number of calls to Delete.
 	Deletes int32
 	// DeleteErrors is the number of errors returned by Delete.
 	DeleteErrors int32
-	// DeletesOverridden is the number of calls to Delete that resulted in no
-	// change.
+	// DeletesOverridden is the number of calls to Delete and DeleteIf that
+	// resulted in no change.
 	DeletesOverridden int32
+	// WriteBatches is the number of successful WriteBatch.Commit calls.
+	WriteBatches int32
+	// WriteBatchErrors is the number of errors returned by WriteBatch.Commit.
+	WriteBatchErrors int32
+	// WriteStreams is the number of successful WriteStream calls.
+	WriteStreams int32
+	// WriteStreamErrors is the number of errors returned by WriteStream.
+	WriteStreamErrors int32
+	// TimestampRejections is the number of calls to Write and Delete that
+	// were rejected for timestamps too far from the local clock; these are
+	// also counted in WriteErrors and DeleteErrors.
+	TimestampRejections int32
+	// ValuesFileSyncs is the number of times values files were synced due to
+	// Config.StrictSync or Config.SyncPolicy.
+	ValuesFileSyncs int32
+	// RecoveryDuplicates is the number of times recovery found the same key
+	// and timestamp at more than one location; the location in the newest
+	// values file, latest within that file, is the one kept.
+	RecoveryDuplicates int32
+	// WatchEventsDropped is the number of WatchEvents not sent because the
+	// Watcher's buffer was full.
+	WatchEventsDropped int32
+	// RemoteReplicationValues is the number of values shipped to the
+	// Config.RemoteMsgRing.
+	RemoteReplicationValues int32
+	// RemoteReplicationOverflows is the number of times the queue of keys to
+	// ship to the Config.RemoteMsgRing was full, leaving the next pass to scan
+	// for the keys that were not queued.
+	RemoteReplicationOverflows int32
+	// RemoteReplicationBytes is the number of bytes of bulk-set messages
+	// shipped to the Config.RemoteMsgRing, after any compression.
+	RemoteReplicationBytes int64
+	// RemoteReplicationThrottles is the number of times shipping to the
+	// Config.RemoteMsgRing waited to stay within
+	// Config.RemoteReplicationMaxBytesPerSec.
+	RemoteReplicationThrottles int32
+	// RemoteReplicationLag is how long the oldest key waiting to be shipped to
+	// the Config.RemoteMsgRing has been waiting.
+	RemoteReplicationLag time.Duration
+	// RemoteReads is the number of calls to Read that failed to read the
+	// value locally and asked the other replicas for it, due to
+	// Config.RemoteReadFallback.
+	RemoteReads int32
+	// RemoteReadRepairs is the number of RemoteReads where another replica
+	// sent the value back in time to be returned.
+	RemoteReadRepairs int32
+	// ReadRepairQueries is the number of calls to Read that asked the other
+	// replicas for anything newer than the local copy, due to
+	// Config.ReadRepair.
+	ReadRepairQueries int32
+	// ReadRepairs is the number of ReadRepairQueries for keys missing
+	// locally where another replica sent the value back in time to be
+	// returned.
+	ReadRepairs int32
+	// QuorumReads is the number of calls to ReadQuorum coordinated with the
+	// other replicas.
+	QuorumReads int32
+	// QuorumWrites is the number of calls to WriteQuorum coordinated with
+	// the other replicas.
+	QuorumWrites int32
+	// QuorumFailures is the number of QuorumReads and QuorumWrites that
+	// returned ErrQuorum.
+	QuorumFailures int32
+	// ErasureReconstructions is the number of ReadErasure calls that had to
+	// rebuild missing or damaged shards.
+	ErasureReconstructions int32
+	// InQuorumRequests is the number of incoming quorum requests answered.
+	InQuorumRequests int32
+	// InQuorumDrops is the number of incoming quorum requests dropped due to
+	// the local system being overworked at the time.
+	InQuorumDrops int32
+	// InQuorumInvalids is the number of incoming quorum messages that
+	// couldn't be parsed.
+	InQuorumInvalids int32
+	// InRemoteReads is the number of incoming remote-read messages answered.
+	InRemoteReads int32
+	// InRemoteReadDrops is the number of incoming remote-read messages
+	// dropped due to too many already waiting to be answered.
+	InRemoteReadDrops int32
+	// InRemoteReadInvalids is the number of incoming remote-read messages
+	// that couldn't be parsed.
+	InRemoteReadInvalids int32
+	// RecoveryUntrusted is the number of TOC entries skipped by recovery as
+	// they referred to the part of a dirty values file that could not be
+	// verified.
+	RecoveryUntrusted int32
+	// RecoveryTornBatches is the number of batches, committed with
+	// WriteBatch.Commit, skipped by recovery as not all of their entries had
+	// made it to disk.
+	RecoveryTornBatches int32
+	// RecoveryMissingValuesFiles is the number of TOC files skipped by
+	// recovery as their values files were missing; this is only done with
+	// Config.RemoteReadFallback, relying on the other replicas to have
+	// them, and is otherwise a *RecoveryError.
+	RecoveryMissingValuesFiles int32
+	// RecoveryStrayFiles is the number of values and TOC files removed by
+	// recovery as the manifest recorded them as compacted or deleted, left
+	// behind by a crash partway through removing them.
+	RecoveryStrayFiles int32
+	// RecoveryCompactionRollbacks is the number of compactions recovery
+	// rolled back, keeping the values file being compacted, as a crash came
+	// before the rewrites of its entries were known to be on disk.
+	RecoveryCompactionRollbacks int32
+	// RecoveryDuration is how long loading the existing data took at
+	// startup. It is not reset.
+	RecoveryDuration time.Duration
+	// RecoveryFiles, RecoveryBytes, and RecoveryEntries are the number of TOC
+	// files, their total size, and the number of their entries loaded at
+	// startup; as RecoveryDuration, they are not reset.
+	RecoveryFiles   int
+	RecoveryBytes   int64
+	RecoveryEntries int
+	// DirtyValuesFiles is the number of values files found by recovery
+	// without a valid trailer, usually because they were being written when
+	// the process died.
+	DirtyValuesFiles int32
 	// OutBulkSets is the number of outgoing bulk-set messages in response to
 	// incoming pull replication messages.
 	OutBulkSets int32
@@ -42,20 +164,57 @@ type Stats struct {
 	// these bulk-set messages are those in response to incoming
 	// pull-replication messages.
 	OutBulkSetValues int32
+	// OutBulkSetContinuations is the number of outgoing bulk-set messages
+	// that continued a response to an incoming pull-replication message too
+	// large for one; see Config.InPullReplicationResponseMsgs.
+	OutBulkSetContinuations int32
 	// OutBulkSetPushes is the number of outgoing bulk-set messages due to push
 	// replication.
 	OutBulkSetPushes int32
 	// OutBulkSetPushValues is the number of values in outgoing bulk-set
 	// messages; these bulk-set messages are those due to push replication.
 	OutBulkSetPushValues int32
+	// OutBulkSetHandoffs is the number of outgoing bulk-set messages due to
+	// handoff passes, sent as the ring changes; see ValueStore.RingChanged.
+	OutBulkSetHandoffs int32
+	// OutBulkSetHandoffValues is the number of values in outgoing bulk-set
+	// messages due to handoff passes.
+	OutBulkSetHandoffValues int32
+	// HandoffPasses is the number of handoff passes started, one for each
+	// ring change noticed.
+	HandoffPasses int32
+	// OutBulkSetTombstones is the number of outgoing bulk-set messages of
+	// expired deletion markers sent to the replicas yet to acknowledge them;
+	// see Config.TombstoneDiscardAcked.
+	OutBulkSetTombstones int32
+	// OutBulkSetBackfills is the number of outgoing bulk-set messages passing
+	// on values received in incoming backfill bulk-set messages.
+	OutBulkSetBackfills int32
+	// OutPushBacklogSaves is the number of times the push replication backlog
+	// was saved to disk.
+	OutPushBacklogSaves int32
 	// InBulkSets is the number of incoming bulk-set messages.
 	InBulkSets int32
 	// InBulkSetDrops is the number of incoming bulk-set messages dropped due
 	// to the local system being overworked at the time.
 	InBulkSetDrops int32
+	// InBulkSetConflicts is the number of incoming bulk-set entries that had
+	// the same timestamp as the local entry but a different value; these are
+	// only looked for when Config.ConflictResolver is set.
+	InBulkSetConflicts int32
+	// InBulkSetPriorities is the number of incoming bulk-set messages that
+	// were backfills and so were processed by the priority workers.
+	InBulkSetPriorities int32
+	// InBulkSetPeerDrops is the number of incoming bulk-set messages dropped
+	// because the sending node was already using its share of the incoming
+	// bulk-set messages or was over its byte rate.
+	InBulkSetPeerDrops int32
 	// InBulkSetInvalids is the number of incoming bulk-set messages that
 	// couldn't be parsed.
 	InBulkSetInvalids int32
+	// InBulkSetCorrupts is the number of incoming bulk-set messages dropped
+	// for not matching their checksum.
+	InBulkSetCorrupts int32
 	// InBulkSetWrites is the number of writes due to incoming bulk-set
 	// messages.
 	InBulkSetWrites int32
@@ -65,6 +224,10 @@ type Stats struct {
 	// InBulkSetWritesOverridden is the number of writes from incoming bulk-set
 	// messages that result in no change.
 	InBulkSetWritesOverridden int32
+	// InBulkSetCoalesced is the number of entries in incoming bulk-set
+	// messages that were skipped because the same message also had a newer
+	// entry for the same key.
+	InBulkSetCoalesced int32
 	// OutBulkSetAcks is the number of outgoing bulk-set-ack messages.
 	OutBulkSetAcks int32
 	// InBulkSetAcks is the number of incoming bulk-set-ack messages.
@@ -75,6 +238,9 @@ type Stats struct {
 	// InBulkSetAckInvalids is the number of incoming bulk-set-ack messages
 	// that couldn't be parsed.
 	InBulkSetAckInvalids int32
+	// InBulkSetAckCorrupts is the number of incoming bulk-set-ack messages
+	// dropped for not matching their checksum.
+	InBulkSetAckCorrupts int32
 	// InBulkSetAckWrites is the number of writes (for local removal) due to
 	// incoming bulk-set-ack messages.
 	InBulkSetAckWrites int32
@@ -84,6 +250,13 @@ type Stats struct {
 	// InBulkSetAckWritesOverridden is the number of writes from incoming
 	// bulk-set-ack messages that result in no change.
 	InBulkSetAckWritesOverridden int32
+	// InBulkSetAckedMsgs is the number of outgoing bulk-set messages
+	// acknowledged by incoming bulk-set-ack messages.
+	InBulkSetAckedMsgs int32
+	// InBulkSetAckFailures is the number of entries in incoming status
+	// bulk-set-ack messages reporting the entry was not stored by a node
+	// responsible for it.
+	InBulkSetAckFailures int32
 	// OutPullReplications is the number of outgoing pull-replication messages.
 	OutPullReplications int32
 	// InPullReplications is the number of incoming pull-replication messages.
@@ -94,9 +267,44 @@ type Stats struct {
 	// InPullReplicationInvalids is the number of incoming pull-replication
 	// messages that couldn't be parsed.
 	InPullReplicationInvalids int32
+	// InPullReplicationCorrupts is the number of incoming pull-replication
+	// messages dropped for not matching their checksum.
+	InPullReplicationCorrupts int32
+	// OutPullReplicationMerkles is the number of outgoing merkle
+	// pull-replication requests; see Config.OutPullReplicationMode.
+	OutPullReplicationMerkles int32
+	// InPullReplicationMerkles is the number of incoming merkle
+	// pull-replication requests and responses.
+	InPullReplicationMerkles int32
+	// PullReplicationMerkleDiffs is the number of subranges found to differ
+	// while processing incoming merkle pull-replication requests.
+	PullReplicationMerkleDiffs int32
+	// OutPeerMsgSkips is the number of outgoing replication messages skipped
+	// because a destination node already had Config.OutPeerMsgWindow
+	// messages outstanding.
+	OutPeerMsgSkips int32
+	// OutPeerMsgAckTimeouts is the number of nodes whose ack of an outgoing
+	// bulk-set message did not arrive in time, so its Config.OutPeerMsgWindow
+	// slot was freed without one.
+	OutPeerMsgAckTimeouts int32
+	// InMsgAuthFailures is the number of incoming messages dropped for not
+	// carrying a matching HMAC; see Config.MessageAuthKey.
+	InMsgAuthFailures int32
+	// MsgPoolMisses is the number of times a message was needed from a
+	// message pool that had none free.
+	MsgPoolMisses int32
+	// MsgPoolGrows is the number of messages allocated to grow message pools.
+	MsgPoolGrows int32
+	// MsgPoolShrinks is the number of unused messages discarded to shrink
+	// message pools.
+	MsgPoolShrinks int32
 	// ExpiredDeletions is the number of recent deletes that have become old
 	// enough to be completely discarded.
 	ExpiredDeletions int32
+	// ExpiredDeletionsUnacked is the number of times an expired deletion
+	// marker was kept for want of acknowledgments from all its replicas; see
+	// Config.TombstoneDiscardAcked.
+	ExpiredDeletionsUnacked int32
 	// Compactions is the number of disk file sets compacted due to their
 	// contents exceeding a staleness threshold. For example, this happens when
 	// enough of the values have been overwritten or deleted in more recent
@@ -104,8 +312,112 @@ type Stats struct {
 	Compactions int32
 	// SmallFileCompactions is the number of disk file sets compacted due to
 	// the entire file size being too small. For example, this may happen when
-	// the valuestore is shutdown and restarted.
+	// the valuestore is shutdown and restarted. Only small file sets merged
+	// with others, see MergedCompactions, count here; one with no others to
+	// merge with is compacted only for its stale entries, as in Compactions.
 	SmallFileCompactions int32
+	// RecompressionCompactions is the number of disk file sets compacted,
+	// with Config.CompactionRecompress, due to having been written with a
+	// different Config.Compression.
+	RecompressionCompactions int32
+	// ReencryptionCompactions is the number of disk file sets compacted, with
+	// Config.CompactionReencrypt, due to having been written with a different
+	// Config.EncryptionKey.
+	ReencryptionCompactions int32
+	// MigrationCompactions is the number of disk file sets compacted, by
+	// MigrationPass, due to not being in the format new files are written in.
+	MigrationCompactions int32
+	// MergedCompactions is the number of groups of small disk file sets
+	// compacted together, so their live entries were rewritten into the same
+	// values file; each file set is also counted in SmallFileCompactions.
+	MergedCompactions int32
+	// CompactionFiltered is the number of live entries compaction dropped, as
+	// local removals, as Config.CompactionFilter returned false for them.
+	CompactionFiltered int32
+	// FileCompactions is the number of disk file sets compacted by
+	// CompactFile.
+	FileCompactions int32
+	// CompactionThrottles is the number of times compaction waited to stay
+	// within Config.CompactionMaxBytesPerSec.
+	CompactionThrottles int32
+	// LogicalWriteBytes is the number of value bytes newly stored, whether
+	// from Write or from replication, but not from compaction rewrites.
+	LogicalWriteBytes int64
+	// PhysicalWriteBytes is the number of bytes written to values and TOC
+	// files, including compaction rewrites, headers, and checksums.
+	PhysicalWriteBytes int64
+	// WriteAmplification is PhysicalWriteBytes / LogicalWriteBytes, or 0 with
+	// no LogicalWriteBytes.
+	WriteAmplification float64
+	// DiskBytes is the total size of the values and TOC files.
+	DiskBytes uint64
+	// SpaceAmplification is DiskBytes / ValueBytes, or 0 with no ValueBytes.
+	// Raising CompactionThreshold lowers write amplification at the cost of
+	// space amplification, and vice versa.
+	SpaceAmplification float64
+	// ValuesFiles are the sizes and estimated waste of each values file,
+	// oldest first.
+	ValuesFiles []ValuesFileStats
+	// ReaderOpens is the number of values file readers opened; see
+	// Config.ReaderBudget.
+	ReaderOpens int32
+	// ReaderEvictions is the number of idle values file readers closed to
+	// stay within Config.ReaderBudget.
+	ReaderEvictions int32
+	// ReadersOpen is the number of values file readers currently open.
+	ReadersOpen int
+	// ReaderConcurrency is the number of concurrent reads currently allowed
+	// per values file; see Config.ReaderTuneInterval.
+	ReaderConcurrency int
+	// ReaderConcurrencyGrows is the number of times reader tuning allowed
+	// another concurrent read per values file.
+	ReaderConcurrencyGrows int32
+	// ReaderConcurrencyShrinks is the number of times reader tuning allowed
+	// one fewer concurrent read per values file.
+	ReaderConcurrencyShrinks int32
+	// ReadCacheHits is the number of reads served from the read cache; see
+	// Config.ReadCacheBytes.
+	ReadCacheHits int32
+	// ReadCacheMisses is the number of reads of values file values the read
+	// cache didn't have.
+	ReadCacheMisses int32
+	// SyncFlushes is the number of flushes made to get writes onto disk; see
+	// Config.SyncPolicy and WriteDurable.
+	SyncFlushes int32
+	// DiskFulls is the number of times the disk was found full, by free space
+	// dropping below Config.DiskFreeMinimum, by a write failing for lack of
+	// space, or by all the Paths reaching their Config.PathsLimits, and
+	// writes started being refused.
+	DiskFulls int32
+	// DiskFullRejections is the number of writes refused with ErrDiskFull.
+	DiskFullRejections int32
+	// DiskFullRetries is the number of values or TOC file writes retried
+	// after failing for lack of space.
+	DiskFullRetries int32
+	// PathsRebalanceFiles and PathsRebalanceBytes are the number of values
+	// files, and their bytes, the rebalancer moved off Paths over their
+	// Config.PathsLimits.
+	PathsRebalanceFiles int32
+	PathsRebalanceBytes int64
+	// PathsRebalancePending is how many bytes the Paths were over their
+	// limits, in all, after the rebalancer's latest pass. It is not reset.
+	PathsRebalancePending int64
+	// BackgroundPauses is the number of times background pass workers paused
+	// to stay within Config.BackgroundCPUPercent.
+	BackgroundPauses int32
+	// Disks are the IO stats for each disk the store uses.
+	Disks []DiskStats
+	// ReplicationLagNodes estimate, for each peer, how far behind it is, by
+	// what was sent in response to its pull replication requests since the
+	// last Stats call.
+	ReplicationLagNodes []ReplicationLagStats
+	// ReplicationLagPartitions estimate, for each partition whose most recent
+	// pull replication request found keys missing, how far behind its
+	// replicas are.
+	ReplicationLagPartitions []ReplicationLagStats
+	// DiskHealth is the most severe Config.DiskHealth action taken so far;
+	// DISK_HEALTHY if none.
+	DiskHealth int
 
 	debug                      bool
 	freeableVMChansCap         int
@@ -138,10 +450,11 @@ type Stats struct {
 	minValueAlloc              int
 	writePagesPerWorker        int
 	tombstoneAge               int
-	valuesFileCap              uint32
+	valuesFileCap              uint64
 	valuesFileReaders          int
 	checksumInterval           uint32
 	replicationIgnoreRecent    int
+	msgPools                   [][]string
 	vlmDebugInfo               fmt.Stringer
 }
 
@@ -167,33 +480,121 @@ func (vs *DefaultValueStore) Stats(debug bool) fmt.Stringer {
 		Writes:                       atomic.LoadInt32(&vs.writes),
 		WriteErrors:                  atomic.LoadInt32(&vs.writeErrors),
 		WritesOverridden:             atomic.LoadInt32(&vs.writesOverridden),
+		WritesBumped:                 atomic.LoadInt32(&vs.writesBumped),
 		Deletes:                      atomic.LoadInt32(&vs.deletes),
 		DeleteErrors:                 atomic.LoadInt32(&vs.deleteErrors),
 		DeletesOverridden:            atomic.LoadInt32(&vs.deletesOverridden),
+		WriteBatches:                 atomic.LoadInt32(&vs.writeBatches),
+		WriteBatchErrors:             atomic.LoadInt32(&vs.writeBatchErrors),
+		WriteStreams:                 atomic.LoadInt32(&vs.writeStreams),
+		WriteStreamErrors:            atomic.LoadInt32(&vs.writeStreamErrors),
+		TimestampRejections:          atomic.LoadInt32(&vs.timestampRejections),
+		ValuesFileSyncs:              atomic.LoadInt32(&vs.valuesFileSyncs),
+		RecoveryDuplicates:           atomic.LoadInt32(&vs.recoveryDuplicates),
+		WatchEventsDropped:           atomic.LoadInt32(&vs.watchEventsDropped),
+		RemoteReplicationValues:      atomic.LoadInt32(&vs.remoteReplicationValues),
+		RemoteReplicationOverflows:   atomic.LoadInt32(&vs.remoteReplicationOverflows),
+		RemoteReplicationBytes:       atomic.LoadInt64(&vs.remoteReplicationBytes),
+		RemoteReplicationThrottles:   atomic.LoadInt32(&vs.remoteReplicationThrottles),
+		RemoteReplicationLag:         vs.remoteReplicationLag(),
+		RemoteReads:                  atomic.LoadInt32(&vs.remoteReads),
+		RemoteReadRepairs:            atomic.LoadInt32(&vs.remoteReadRepairs),
+		ReadRepairQueries:            atomic.LoadInt32(&vs.readRepairQueries),
+		ReadRepairs:                  atomic.LoadInt32(&vs.readRepairs),
+		QuorumReads:                  atomic.LoadInt32(&vs.quorumReads),
+		QuorumWrites:                 atomic.LoadInt32(&vs.quorumWrites),
+		QuorumFailures:               atomic.LoadInt32(&vs.quorumFailures),
+		ErasureReconstructions:       atomic.LoadInt32(&vs.erasureReconstructions),
+		InQuorumRequests:             atomic.LoadInt32(&vs.inQuorumRequests),
+		InQuorumDrops:                atomic.LoadInt32(&vs.inQuorumDrops),
+		InQuorumInvalids:             atomic.LoadInt32(&vs.inQuorumInvalids),
+		InRemoteReads:                atomic.LoadInt32(&vs.inRemoteReads),
+		InRemoteReadDrops:            atomic.LoadInt32(&vs.inRemoteReadDrops),
+		InRemoteReadInvalids:         atomic.LoadInt32(&vs.inRemoteReadInvalids),
+		RecoveryUntrusted:            atomic.LoadInt32(&vs.recoveryUntrusted),
+		RecoveryTornBatches:          atomic.LoadInt32(&vs.recoveryTornBatches),
+		RecoveryMissingValuesFiles:   atomic.LoadInt32(&vs.recoveryMissingValuesFiles),
+		RecoveryStrayFiles:           atomic.LoadInt32(&vs.recoveryStrayFiles),
+		RecoveryCompactionRollbacks:  atomic.LoadInt32(&vs.recoveryCompactionRollbacks),
+		RecoveryDuration:             vs.recoveryDuration,
+		RecoveryFiles:                vs.recoveryProgressState.progress.Files,
+		RecoveryBytes:                vs.recoveryProgressState.progress.Bytes,
+		RecoveryEntries:              vs.recoveryProgressState.progress.Entries,
+		DirtyValuesFiles:             atomic.LoadInt32(&vs.dirtyValuesFiles),
 		OutBulkSets:                  atomic.LoadInt32(&vs.outBulkSets),
 		OutBulkSetValues:             atomic.LoadInt32(&vs.outBulkSetValues),
+		OutBulkSetContinuations:      atomic.LoadInt32(&vs.outBulkSetContinuations),
 		OutBulkSetPushes:             atomic.LoadInt32(&vs.outBulkSetPushes),
 		OutBulkSetPushValues:         atomic.LoadInt32(&vs.outBulkSetPushValues),
+		OutBulkSetHandoffs:           atomic.LoadInt32(&vs.outBulkSetHandoffs),
+		OutBulkSetHandoffValues:      atomic.LoadInt32(&vs.outBulkSetHandoffValues),
+		HandoffPasses:                atomic.LoadInt32(&vs.handoffPasses),
+		OutBulkSetTombstones:         atomic.LoadInt32(&vs.outBulkSetTombstones),
+		OutBulkSetBackfills:          atomic.LoadInt32(&vs.outBulkSetBackfills),
+		OutPushBacklogSaves:          atomic.LoadInt32(&vs.outPushBacklogSaves),
 		InBulkSets:                   atomic.LoadInt32(&vs.inBulkSets),
 		InBulkSetDrops:               atomic.LoadInt32(&vs.inBulkSetDrops),
+		InBulkSetPriorities:          atomic.LoadInt32(&vs.inBulkSetPriorities),
+		InBulkSetConflicts:           atomic.LoadInt32(&vs.inBulkSetConflicts),
+		InBulkSetPeerDrops:           atomic.LoadInt32(&vs.inBulkSetPeerDrops),
 		InBulkSetInvalids:            atomic.LoadInt32(&vs.inBulkSetInvalids),
+		InBulkSetCorrupts:            atomic.LoadInt32(&vs.inBulkSetCorrupts),
 		InBulkSetWrites:              atomic.LoadInt32(&vs.inBulkSetWrites),
 		InBulkSetWriteErrors:         atomic.LoadInt32(&vs.inBulkSetWriteErrors),
 		InBulkSetWritesOverridden:    atomic.LoadInt32(&vs.inBulkSetWritesOverridden),
+		InBulkSetCoalesced:           atomic.LoadInt32(&vs.inBulkSetCoalesced),
 		OutBulkSetAcks:               atomic.LoadInt32(&vs.outBulkSetAcks),
 		InBulkSetAcks:                atomic.LoadInt32(&vs.inBulkSetAcks),
 		InBulkSetAckDrops:            atomic.LoadInt32(&vs.inBulkSetAckDrops),
 		InBulkSetAckInvalids:         atomic.LoadInt32(&vs.inBulkSetAckInvalids),
+		InBulkSetAckCorrupts:         atomic.LoadInt32(&vs.inBulkSetAckCorrupts),
 		InBulkSetAckWrites:           atomic.LoadInt32(&vs.inBulkSetAckWrites),
 		InBulkSetAckWriteErrors:      atomic.LoadInt32(&vs.inBulkSetAckWriteErrors),
 		InBulkSetAckWritesOverridden: atomic.LoadInt32(&vs.inBulkSetAckWritesOverridden),
+		InBulkSetAckedMsgs:           atomic.LoadInt32(&vs.inBulkSetAckedMsgs),
+		InBulkSetAckFailures:         atomic.LoadInt32(&vs.inBulkSetAckFailures),
 		OutPullReplications:          atomic.LoadInt32(&vs.outPullReplications),
 		InPullReplications:           atomic.LoadInt32(&vs.inPullReplications),
 		InPullReplicationDrops:       atomic.LoadInt32(&vs.inPullReplicationDrops),
 		InPullReplicationInvalids:    atomic.LoadInt32(&vs.inPullReplicationInvalids),
+		InPullReplicationCorrupts:    atomic.LoadInt32(&vs.inPullReplicationCorrupts),
+		OutPullReplicationMerkles:    atomic.LoadInt32(&vs.outPullReplicationMerkles),
+		InPullReplicationMerkles:     atomic.LoadInt32(&vs.inPullReplicationMerkles),
+		PullReplicationMerkleDiffs:   atomic.LoadInt32(&vs.pullReplicationMerkleDiffs),
+		OutPeerMsgSkips:              atomic.LoadInt32(&vs.outPeerMsgSkips),
+		OutPeerMsgAckTimeouts:        atomic.LoadInt32(&vs.outPeerMsgAckTimeouts),
+		InMsgAuthFailures:            atomic.LoadInt32(&vs.inMsgAuthFailures),
 		ExpiredDeletions:             atomic.LoadInt32(&vs.expiredDeletions),
+		ExpiredDeletionsUnacked:      atomic.LoadInt32(&vs.expiredDeletionsUnacked),
 		Compactions:                  atomic.LoadInt32(&vs.compactions),
 		SmallFileCompactions:         atomic.LoadInt32(&vs.smallFileCompactions),
+		RecompressionCompactions:     atomic.LoadInt32(&vs.recompressionCompactions),
+		ReencryptionCompactions:      atomic.LoadInt32(&vs.reencryptionCompactions),
+		MigrationCompactions:         atomic.LoadInt32(&vs.migrationCompactions),
+		MergedCompactions:            atomic.LoadInt32(&vs.mergedCompactions),
+		CompactionFiltered:           atomic.LoadInt32(&vs.compactionFiltered),
+		FileCompactions:              atomic.LoadInt32(&vs.fileCompactions),
+		CompactionThrottles:          atomic.LoadInt32(&vs.compactionThrottles),
+		LogicalWriteBytes:            atomic.LoadInt64(&vs.logicalWriteBytes),
+		PhysicalWriteBytes:           atomic.LoadInt64(&vs.physicalWriteBytes),
+		ReaderOpens:                  atomic.LoadInt32(&vs.readerOpens),
+		ReaderEvictions:              atomic.LoadInt32(&vs.readerEvictions),
+		ReadersOpen:                  vs.readersOpen(),
+		ReaderConcurrency:            int(atomic.LoadInt32(&vs.readerTuneState.concurrency)),
+		ReaderConcurrencyGrows:       atomic.LoadInt32(&vs.readerConcurrencyGrows),
+		ReaderConcurrencyShrinks:     atomic.LoadInt32(&vs.readerConcurrencyShrinks),
+		ReadCacheHits:                atomic.LoadInt32(&vs.readCacheHits),
+		ReadCacheMisses:              atomic.LoadInt32(&vs.readCacheMisses),
+		SyncFlushes:                  atomic.LoadInt32(&vs.syncFlushes),
+		DiskFulls:                    atomic.LoadInt32(&vs.diskFulls),
+		DiskFullRejections:           atomic.LoadInt32(&vs.diskFullRejections),
+		DiskFullRetries:              atomic.LoadInt32(&vs.diskFullRetries),
+		PathsRebalanceFiles:          atomic.LoadInt32(&vs.pathsRebalanceFiles),
+		PathsRebalanceBytes:          atomic.LoadInt64(&vs.pathsRebalanceBytes),
+		PathsRebalancePending:        atomic.LoadInt64(&vs.pathsRebalancePending),
+		BackgroundPauses:             atomic.LoadInt32(&vs.backgroundPauses),
+		Disks:                        vs.diskStats(),
+		DiskHealth:                   int(atomic.LoadInt32(&vs.diskHealthState.action)),
 	}
 	atomic.AddInt32(&vs.lookups, -stats.Lookups)
 	atomic.AddInt32(&vs.lookupErrors, -stats.LookupErrors)
@@ -202,33 +603,122 @@ func (vs *DefaultValueStore) Stats(debug bool) fmt.Stringer {
 	atomic.AddInt32(&vs.writes, -stats.Writes)
 	atomic.AddInt32(&vs.writeErrors, -stats.WriteErrors)
 	atomic.AddInt32(&vs.writesOverridden, -stats.WritesOverridden)
+	atomic.AddInt32(&vs.writesBumped, -stats.WritesBumped)
 	atomic.AddInt32(&vs.writes, -stats.Deletes)
 	atomic.AddInt32(&vs.writeErrors, -stats.DeleteErrors)
 	atomic.AddInt32(&vs.writesOverridden, -stats.DeletesOverridden)
+	atomic.AddInt32(&vs.writeBatches, -stats.WriteBatches)
+	atomic.AddInt32(&vs.writeBatchErrors, -stats.WriteBatchErrors)
+	atomic.AddInt32(&vs.writeStreams, -stats.WriteStreams)
+	atomic.AddInt32(&vs.writeStreamErrors, -stats.WriteStreamErrors)
+	atomic.AddInt32(&vs.timestampRejections, -stats.TimestampRejections)
+	atomic.AddInt32(&vs.valuesFileSyncs, -stats.ValuesFileSyncs)
+	atomic.AddInt32(&vs.recoveryDuplicates, -stats.RecoveryDuplicates)
+	atomic.AddInt32(&vs.watchEventsDropped, -stats.WatchEventsDropped)
+	atomic.AddInt32(&vs.remoteReplicationValues, -stats.RemoteReplicationValues)
+	atomic.AddInt32(&vs.remoteReplicationOverflows, -stats.RemoteReplicationOverflows)
+	atomic.AddInt64(&vs.remoteReplicationBytes, -stats.RemoteReplicationBytes)
+	atomic.AddInt32(&vs.remoteReplicationThrottles, -stats.RemoteReplicationThrottles)
+	atomic.AddInt32(&vs.remoteReads, -stats.RemoteReads)
+	atomic.AddInt32(&vs.remoteReadRepairs, -stats.RemoteReadRepairs)
+	atomic.AddInt32(&vs.readRepairQueries, -stats.ReadRepairQueries)
+	atomic.AddInt32(&vs.readRepairs, -stats.ReadRepairs)
+	atomic.AddInt32(&vs.quorumReads, -stats.QuorumReads)
+	atomic.AddInt32(&vs.quorumWrites, -stats.QuorumWrites)
+	atomic.AddInt32(&vs.quorumFailures, -stats.QuorumFailures)
+	atomic.AddInt32(&vs.erasureReconstructions, -stats.ErasureReconstructions)
+	atomic.AddInt32(&vs.inQuorumRequests, -stats.InQuorumRequests)
+	atomic.AddInt32(&vs.inQuorumDrops, -stats.InQuorumDrops)
+	atomic.AddInt32(&vs.inQuorumInvalids, -stats.InQuorumInvalids)
+	atomic.AddInt32(&vs.inRemoteReads, -stats.InRemoteReads)
+	atomic.AddInt32(&vs.inRemoteReadDrops, -stats.InRemoteReadDrops)
+	atomic.AddInt32(&vs.inRemoteReadInvalids, -stats.InRemoteReadInvalids)
+	atomic.AddInt32(&vs.recoveryUntrusted, -stats.RecoveryUntrusted)
+	atomic.AddInt32(&vs.recoveryTornBatches, -stats.RecoveryTornBatches)
+	atomic.AddInt32(&vs.recoveryMissingValuesFiles, -stats.RecoveryMissingValuesFiles)
+	atomic.AddInt32(&vs.recoveryStrayFiles, -stats.RecoveryStrayFiles)
+	atomic.AddInt32(&vs.recoveryCompactionRollbacks, -stats.RecoveryCompactionRollbacks)
+	atomic.AddInt32(&vs.dirtyValuesFiles, -stats.DirtyValuesFiles)
 	atomic.AddInt32(&vs.outBulkSets, -stats.OutBulkSets)
 	atomic.AddInt32(&vs.outBulkSetValues, -stats.OutBulkSetValues)
+	atomic.AddInt32(&vs.outBulkSetContinuations, -stats.OutBulkSetContinuations)
 	atomic.AddInt32(&vs.outBulkSetPushes, -stats.OutBulkSetPushes)
 	atomic.AddInt32(&vs.outBulkSetPushValues, -stats.OutBulkSetPushValues)
+	atomic.AddInt32(&vs.outBulkSetHandoffs, -stats.OutBulkSetHandoffs)
+	atomic.AddInt32(&vs.outBulkSetHandoffValues, -stats.OutBulkSetHandoffValues)
+	atomic.AddInt32(&vs.handoffPasses, -stats.HandoffPasses)
+	atomic.AddInt32(&vs.outBulkSetTombstones, -stats.OutBulkSetTombstones)
+	atomic.AddInt32(&vs.outBulkSetBackfills, -stats.OutBulkSetBackfills)
+	atomic.AddInt32(&vs.outPushBacklogSaves, -stats.OutPushBacklogSaves)
 	atomic.AddInt32(&vs.inBulkSets, -stats.InBulkSets)
 	atomic.AddInt32(&vs.inBulkSetDrops, -stats.InBulkSetDrops)
+	atomic.AddInt32(&vs.inBulkSetPriorities, -stats.InBulkSetPriorities)
+	atomic.AddInt32(&vs.inBulkSetConflicts, -stats.InBulkSetConflicts)
+	atomic.AddInt32(&vs.inBulkSetPeerDrops, -stats.InBulkSetPeerDrops)
 	atomic.AddInt32(&vs.inBulkSetInvalids, -stats.InBulkSetInvalids)
+	atomic.AddInt32(&vs.inBulkSetCorrupts, -stats.InBulkSetCorrupts)
 	atomic.AddInt32(&vs.inBulkSetWrites, -stats.InBulkSetWrites)
 	atomic.AddInt32(&vs.inBulkSetWriteErrors, -stats.InBulkSetWriteErrors)
 	atomic.AddInt32(&vs.inBulkSetWritesOverridden, -stats.InBulkSetWritesOverridden)
+	atomic.AddInt32(&vs.inBulkSetCoalesced, -stats.InBulkSetCoalesced)
 	atomic.AddInt32(&vs.outBulkSetAcks, -stats.OutBulkSetAcks)
 	atomic.AddInt32(&vs.inBulkSetAcks, -stats.InBulkSetAcks)
 	atomic.AddInt32(&vs.inBulkSetAckDrops, -stats.InBulkSetAckDrops)
 	atomic.AddInt32(&vs.inBulkSetAckInvalids, -stats.InBulkSetAckInvalids)
+	atomic.AddInt32(&vs.inBulkSetAckCorrupts, -stats.InBulkSetAckCorrupts)
 	atomic.AddInt32(&vs.inBulkSetAckWrites, -stats.InBulkSetAckWrites)
 	atomic.AddInt32(&vs.inBulkSetAckWriteErrors, -stats.InBulkSetAckWriteErrors)
 	atomic.AddInt32(&vs.inBulkSetAckWritesOverridden, -stats.InBulkSetAckWritesOverridden)
+	atomic.AddInt32(&vs.inBulkSetAckedMsgs, -stats.InBulkSetAckedMsgs)
+	atomic.AddInt32(&vs.inBulkSetAckFailures, -stats.InBulkSetAckFailures)
 	atomic.AddInt32(&vs.outPullReplications, -stats.OutPullReplications)
 	atomic.AddInt32(&vs.inPullReplications, -stats.InPullReplications)
 	atomic.AddInt32(&vs.inPullReplicationDrops, -stats.InPullReplicationDrops)
 	atomic.AddInt32(&vs.inPullReplicationInvalids, -stats.InPullReplicationInvalids)
+	atomic.AddInt32(&vs.inPullReplicationCorrupts, -stats.InPullReplicationCorrupts)
+	atomic.AddInt32(&vs.outPullReplicationMerkles, -stats.OutPullReplicationMerkles)
+	atomic.AddInt32(&vs.inPullReplicationMerkles, -stats.InPullReplicationMerkles)
+	atomic.AddInt32(&vs.pullReplicationMerkleDiffs, -stats.PullReplicationMerkleDiffs)
+	atomic.AddInt32(&vs.outPeerMsgSkips, -stats.OutPeerMsgSkips)
+	atomic.AddInt32(&vs.outPeerMsgAckTimeouts, -stats.OutPeerMsgAckTimeouts)
+	atomic.AddInt32(&vs.inMsgAuthFailures, -stats.InMsgAuthFailures)
 	atomic.AddInt32(&vs.expiredDeletions, -stats.ExpiredDeletions)
+	atomic.AddInt32(&vs.expiredDeletionsUnacked, -stats.ExpiredDeletionsUnacked)
 	atomic.AddInt32(&vs.compactions, -stats.Compactions)
 	atomic.AddInt32(&vs.smallFileCompactions, -stats.SmallFileCompactions)
+	atomic.AddInt32(&vs.recompressionCompactions, -stats.RecompressionCompactions)
+	atomic.AddInt32(&vs.reencryptionCompactions, -stats.ReencryptionCompactions)
+	atomic.AddInt32(&vs.migrationCompactions, -stats.MigrationCompactions)
+	atomic.AddInt32(&vs.mergedCompactions, -stats.MergedCompactions)
+	atomic.AddInt32(&vs.compactionFiltered, -stats.CompactionFiltered)
+	atomic.AddInt32(&vs.fileCompactions, -stats.FileCompactions)
+	atomic.AddInt32(&vs.compactionThrottles, -stats.CompactionThrottles)
+	atomic.AddInt64(&vs.logicalWriteBytes, -stats.LogicalWriteBytes)
+	atomic.AddInt64(&vs.physicalWriteBytes, -stats.PhysicalWriteBytes)
+	atomic.AddInt32(&vs.readerOpens, -stats.ReaderOpens)
+	atomic.AddInt32(&vs.readerEvictions, -stats.ReaderEvictions)
+	atomic.AddInt32(&vs.readerConcurrencyGrows, -stats.ReaderConcurrencyGrows)
+	atomic.AddInt32(&vs.readerConcurrencyShrinks, -stats.ReaderConcurrencyShrinks)
+	atomic.AddInt32(&vs.readCacheHits, -stats.ReadCacheHits)
+	atomic.AddInt32(&vs.readCacheMisses, -stats.ReadCacheMisses)
+	atomic.AddInt32(&vs.syncFlushes, -stats.SyncFlushes)
+	atomic.AddInt32(&vs.diskFulls, -stats.DiskFulls)
+	atomic.AddInt32(&vs.diskFullRejections, -stats.DiskFullRejections)
+	atomic.AddInt32(&vs.diskFullRetries, -stats.DiskFullRetries)
+	atomic.AddInt32(&vs.pathsRebalanceFiles, -stats.PathsRebalanceFiles)
+	atomic.AddInt64(&vs.pathsRebalanceBytes, -stats.PathsRebalanceBytes)
+	atomic.AddInt32(&vs.backgroundPauses, -stats.BackgroundPauses)
+	for _, pool := range vs.msgPoolState.pools {
+		misses := atomic.LoadInt32(&pool.misses)
+		grows := atomic.LoadInt32(&pool.grows)
+		shrinks := atomic.LoadInt32(&pool.shrinks)
+		stats.MsgPoolMisses += misses
+		stats.MsgPoolGrows += grows
+		stats.MsgPoolShrinks += shrinks
+		atomic.AddInt32(&pool.misses, -misses)
+		atomic.AddInt32(&pool.grows, -grows)
+		atomic.AddInt32(&pool.shrinks, -shrinks)
+	}
 	vs.statsLock.Unlock()
 	if !debug {
 		vlmStats := vs.vlm.Stats(false)
@@ -263,25 +753,38 @@ func (vs *DefaultValueStore) Stats(debug bool) fmt.Stringer {
 		stats.path = vs.path
 		stats.pathtoc = vs.pathtoc
 		stats.workers = vs.workers
-		stats.tombstoneDiscardInterval = vs.tombstoneDiscardState.interval
+		stats.tombstoneDiscardInterval = int(atomic.LoadInt64(&vs.tombstoneDiscardState.interval))
 		stats.outPullReplicationWorkers = vs.pullReplicationState.outWorkers
-		stats.outPullReplicationInterval = vs.pullReplicationState.outInterval
+		stats.outPullReplicationInterval = time.Duration(atomic.LoadInt64((*int64)(&vs.pullReplicationState.outInterval)))
 		stats.outPushReplicationWorkers = vs.pushReplicationState.outWorkers
-		stats.outPushReplicationInterval = vs.pushReplicationState.outInterval
+		stats.outPushReplicationInterval = int(atomic.LoadInt64(&vs.pushReplicationState.outInterval))
 		stats.valueCap = vs.valueCap
 		stats.pageSize = vs.pageSize
 		stats.minValueAlloc = vs.minValueAlloc
 		stats.writePagesPerWorker = vs.writePagesPerWorker
-		stats.tombstoneAge = int((vs.tombstoneDiscardState.age >> _TSB_UTIL_BITS) * 1000 / uint64(time.Second))
+		stats.tombstoneAge = int((atomic.LoadUint64(&vs.tombstoneDiscardState.age) >> _TSB_UTIL_BITS) * 1000 / uint64(time.Second))
 		stats.valuesFileCap = vs.valuesFileCap
 		stats.valuesFileReaders = vs.valuesFileReaders
 		stats.checksumInterval = vs.checksumInterval
-		stats.replicationIgnoreRecent = int(vs.replicationIgnoreRecent / uint64(time.Second))
+		stats.replicationIgnoreRecent = int(atomic.LoadUint64(&vs.replicationIgnoreRecent) / uint64(time.Second))
+		for _, pool := range vs.msgPoolState.pools {
+			stats.msgPools = append(stats.msgPools, []string{pool.name, pool.String()})
+		}
 		vlmStats := vs.vlm.Stats(true)
 		stats.Values = vlmStats.ActiveCount
 		stats.ValueBytes = vlmStats.ActiveBytes
 		stats.vlmDebugInfo = vlmStats
 	}
+	if stats.LogicalWriteBytes > 0 {
+		stats.WriteAmplification = float64(stats.PhysicalWriteBytes) / float64(stats.LogicalWriteBytes)
+	}
+	stats.Tombstones = vs.tombstones()
+	stats.DiskBytes = vs.diskBytes()
+	stats.ValuesFiles = vs.valuesFileStats()
+	stats.ReplicationLagNodes, stats.ReplicationLagPartitions = vs.replicationLagStats()
+	if stats.ValueBytes > 0 {
+		stats.SpaceAmplification = float64(stats.DiskBytes) / float64(stats.ValueBytes)
+	}
 	return stats
 }
 
@@ -289,6 +792,7 @@ func (stats *Stats) String() string {
 	report := [][]string{
 		{"Values", fmt.Sprintf("%d", stats.Values)},
 		{"ValueBytes", fmt.Sprintf("%d", stats.ValueBytes)},
+		{"Tombstones", fmt.Sprintf("%d", stats.Tombstones)},
 		{"Lookups", fmt.Sprintf("%d", stats.Lookups)},
 		{"LookupErrors", fmt.Sprintf("%d", stats.LookupErrors)},
 		{"Reads", fmt.Sprintf("%d", stats.Reads)},
@@ -296,33 +800,138 @@ func (stats *Stats) String() string {
 		{"Writes", fmt.Sprintf("%d", stats.Writes)},
 		{"WriteErrors", fmt.Sprintf("%d", stats.WriteErrors)},
 		{"WritesOverridden", fmt.Sprintf("%d", stats.WritesOverridden)},
+		{"WritesBumped", fmt.Sprintf("%d", stats.WritesBumped)},
 		{"Deletes", fmt.Sprintf("%d", stats.Deletes)},
 		{"DeleteErrors", fmt.Sprintf("%d", stats.DeleteErrors)},
 		{"DeletesOverridden", fmt.Sprintf("%d", stats.DeletesOverridden)},
+		{"WriteBatches", fmt.Sprintf("%d", stats.WriteBatches)},
+		{"WriteBatchErrors", fmt.Sprintf("%d", stats.WriteBatchErrors)},
+		{"WriteStreams", fmt.Sprintf("%d", stats.WriteStreams)},
+		{"WriteStreamErrors", fmt.Sprintf("%d", stats.WriteStreamErrors)},
+		{"TimestampRejections", fmt.Sprintf("%d", stats.TimestampRejections)},
+		{"ValuesFileSyncs", fmt.Sprintf("%d", stats.ValuesFileSyncs)},
+		{"RecoveryDuplicates", fmt.Sprintf("%d", stats.RecoveryDuplicates)},
+		{"WatchEventsDropped", fmt.Sprintf("%d", stats.WatchEventsDropped)},
+		{"RemoteReplicationValues", fmt.Sprintf("%d", stats.RemoteReplicationValues)},
+		{"RemoteReplicationOverflows", fmt.Sprintf("%d", stats.RemoteReplicationOverflows)},
+		{"RemoteReplicationBytes", fmt.Sprintf("%d", stats.RemoteReplicationBytes)},
+		{"RemoteReplicationThrottles", fmt.Sprintf("%d", stats.RemoteReplicationThrottles)},
+		{"RemoteReplicationLag", stats.RemoteReplicationLag.String()},
+		{"RemoteReads", fmt.Sprintf("%d", stats.RemoteReads)},
+		{"RemoteReadRepairs", fmt.Sprintf("%d", stats.RemoteReadRepairs)},
+		{"ReadRepairQueries", fmt.Sprintf("%d", stats.ReadRepairQueries)},
+		{"ReadRepairs", fmt.Sprintf("%d", stats.ReadRepairs)},
+		{"QuorumReads", fmt.Sprintf("%d", stats.QuorumReads)},
+		{"QuorumWrites", fmt.Sprintf("%d", stats.QuorumWrites)},
+		{"QuorumFailures", fmt.Sprintf("%d", stats.QuorumFailures)},
+		{"ErasureReconstructions", fmt.Sprintf("%d", stats.ErasureReconstructions)},
+		{"InQuorumRequests", fmt.Sprintf("%d", stats.InQuorumRequests)},
+		{"InQuorumDrops", fmt.Sprintf("%d", stats.InQuorumDrops)},
+		{"InQuorumInvalids", fmt.Sprintf("%d", stats.InQuorumInvalids)},
+		{"InRemoteReads", fmt.Sprintf("%d", stats.InRemoteReads)},
+		{"InRemoteReadDrops", fmt.Sprintf("%d", stats.InRemoteReadDrops)},
+		{"InRemoteReadInvalids", fmt.Sprintf("%d", stats.InRemoteReadInvalids)},
+		{"RecoveryUntrusted", fmt.Sprintf("%d", stats.RecoveryUntrusted)},
+		{"RecoveryTornBatches", fmt.Sprintf("%d", stats.RecoveryTornBatches)},
+		{"RecoveryMissingValuesFiles", fmt.Sprintf("%d", stats.RecoveryMissingValuesFiles)},
+		{"RecoveryStrayFiles", fmt.Sprintf("%d", stats.RecoveryStrayFiles)},
+		{"RecoveryCompactionRollbacks", fmt.Sprintf("%d", stats.RecoveryCompactionRollbacks)},
+		{"RecoveryDuration", stats.RecoveryDuration.String()},
+		{"RecoveryFiles", fmt.Sprintf("%d", stats.RecoveryFiles)},
+		{"RecoveryBytes", fmt.Sprintf("%d", stats.RecoveryBytes)},
+		{"RecoveryEntries", fmt.Sprintf("%d", stats.RecoveryEntries)},
+		{"DirtyValuesFiles", fmt.Sprintf("%d", stats.DirtyValuesFiles)},
 		{"OutBulkSets", fmt.Sprintf("%d", stats.OutBulkSets)},
 		{"OutBulkSetValues", fmt.Sprintf("%d", stats.OutBulkSetValues)},
+		{"OutBulkSetContinuations", fmt.Sprintf("%d", stats.OutBulkSetContinuations)},
 		{"OutBulkSetPushes", fmt.Sprintf("%d", stats.OutBulkSetPushes)},
 		{"OutBulkSetPushValues", fmt.Sprintf("%d", stats.OutBulkSetPushValues)},
+		{"OutBulkSetHandoffs", fmt.Sprintf("%d", stats.OutBulkSetHandoffs)},
+		{"OutBulkSetHandoffValues", fmt.Sprintf("%d", stats.OutBulkSetHandoffValues)},
+		{"HandoffPasses", fmt.Sprintf("%d", stats.HandoffPasses)},
+		{"OutBulkSetTombstones", fmt.Sprintf("%d", stats.OutBulkSetTombstones)},
+		{"OutBulkSetBackfills", fmt.Sprintf("%d", stats.OutBulkSetBackfills)},
+		{"OutPushBacklogSaves", fmt.Sprintf("%d", stats.OutPushBacklogSaves)},
 		{"InBulkSets", fmt.Sprintf("%d", stats.InBulkSets)},
 		{"InBulkSetDrops", fmt.Sprintf("%d", stats.InBulkSetDrops)},
+		{"InBulkSetPriorities", fmt.Sprintf("%d", stats.InBulkSetPriorities)},
+		{"InBulkSetConflicts", fmt.Sprintf("%d", stats.InBulkSetConflicts)},
+		{"InBulkSetPeerDrops", fmt.Sprintf("%d", stats.InBulkSetPeerDrops)},
 		{"InBulkSetInvalids", fmt.Sprintf("%d", stats.InBulkSetInvalids)},
+		{"InBulkSetCorrupts", fmt.Sprintf("%d", stats.InBulkSetCorrupts)},
 		{"InBulkSetWrites", fmt.Sprintf("%d", stats.InBulkSetWrites)},
 		{"InBulkSetWriteErrors", fmt.Sprintf("%d", stats.InBulkSetWriteErrors)},
 		{"InBulkSetWritesOverridden", fmt.Sprintf("%d", stats.InBulkSetWritesOverridden)},
+		{"InBulkSetCoalesced", fmt.Sprintf("%d", stats.InBulkSetCoalesced)},
 		{"OutBulkSetAcks", fmt.Sprintf("%d", stats.OutBulkSetAcks)},
 		{"InBulkSetAcks", fmt.Sprintf("%d", stats.InBulkSetAcks)},
 		{"InBulkSetAckDrops", fmt.Sprintf("%d", stats.InBulkSetAckDrops)},
 		{"InBulkSetAckInvalids", fmt.Sprintf("%d", stats.InBulkSetAckInvalids)},
+		{"InBulkSetAckCorrupts", fmt.Sprintf("%d", stats.InBulkSetAckCorrupts)},
 		{"InBulkSetAckWrites", fmt.Sprintf("%d", stats.InBulkSetAckWrites)},
 		{"InBulkSetAckWriteErrors", fmt.Sprintf("%d", stats.InBulkSetAckWriteErrors)},
 		{"InBulkSetAckWritesOverridden", fmt.Sprintf("%d", stats.InBulkSetAckWritesOverridden)},
+		{"InBulkSetAckedMsgs", fmt.Sprintf("%d", stats.InBulkSetAckedMsgs)},
+		{"InBulkSetAckFailures", fmt.Sprintf("%d", stats.InBulkSetAckFailures)},
 		{"OutPullReplications", fmt.Sprintf("%d", stats.OutPullReplications)},
 		{"InPullReplications", fmt.Sprintf("%d", stats.InPullReplications)},
 		{"InPullReplicationDrops", fmt.Sprintf("%d", stats.InPullReplicationDrops)},
 		{"InPullReplicationInvalids", fmt.Sprintf("%d", stats.InPullReplicationInvalids)},
+		{"InPullReplicationCorrupts", fmt.Sprintf("%d", stats.InPullReplicationCorrupts)},
+		{"OutPullReplicationMerkles", fmt.Sprintf("%d", stats.OutPullReplicationMerkles)},
+		{"InPullReplicationMerkles", fmt.Sprintf("%d", stats.InPullReplicationMerkles)},
+		{"PullReplicationMerkleDiffs", fmt.Sprintf("%d", stats.PullReplicationMerkleDiffs)},
+		{"OutPeerMsgSkips", fmt.Sprintf("%d", stats.OutPeerMsgSkips)},
+		{"OutPeerMsgAckTimeouts", fmt.Sprintf("%d", stats.OutPeerMsgAckTimeouts)},
+		{"InMsgAuthFailures", fmt.Sprintf("%d", stats.InMsgAuthFailures)},
+		{"MsgPoolMisses", fmt.Sprintf("%d", stats.MsgPoolMisses)},
+		{"MsgPoolGrows", fmt.Sprintf("%d", stats.MsgPoolGrows)},
+		{"MsgPoolShrinks", fmt.Sprintf("%d", stats.MsgPoolShrinks)},
 		{"ExpiredDeletions", fmt.Sprintf("%d", stats.ExpiredDeletions)},
+		{"ExpiredDeletionsUnacked", fmt.Sprintf("%d", stats.ExpiredDeletionsUnacked)},
 		{"Compactions", fmt.Sprintf("%d", stats.Compactions)},
 		{"SmallFileCompactions", fmt.Sprintf("%d", stats.SmallFileCompactions)},
+		{"RecompressionCompactions", fmt.Sprintf("%d", stats.RecompressionCompactions)},
+		{"ReencryptionCompactions", fmt.Sprintf("%d", stats.ReencryptionCompactions)},
+		{"MigrationCompactions", fmt.Sprintf("%d", stats.MigrationCompactions)},
+		{"MergedCompactions", fmt.Sprintf("%d", stats.MergedCompactions)},
+		{"CompactionFiltered", fmt.Sprintf("%d", stats.CompactionFiltered)},
+		{"FileCompactions", fmt.Sprintf("%d", stats.FileCompactions)},
+		{"CompactionThrottles", fmt.Sprintf("%d", stats.CompactionThrottles)},
+		{"LogicalWriteBytes", fmt.Sprintf("%d", stats.LogicalWriteBytes)},
+		{"PhysicalWriteBytes", fmt.Sprintf("%d", stats.PhysicalWriteBytes)},
+		{"WriteAmplification", fmt.Sprintf("%.2f", stats.WriteAmplification)},
+		{"DiskBytes", fmt.Sprintf("%d", stats.DiskBytes)},
+		{"SpaceAmplification", fmt.Sprintf("%.2f", stats.SpaceAmplification)},
+		{"ReaderOpens", fmt.Sprintf("%d", stats.ReaderOpens)},
+		{"ReaderEvictions", fmt.Sprintf("%d", stats.ReaderEvictions)},
+		{"ReadersOpen", fmt.Sprintf("%d", stats.ReadersOpen)},
+		{"ReaderConcurrency", fmt.Sprintf("%d", stats.ReaderConcurrency)},
+		{"ReaderConcurrencyGrows", fmt.Sprintf("%d", stats.ReaderConcurrencyGrows)},
+		{"ReaderConcurrencyShrinks", fmt.Sprintf("%d", stats.ReaderConcurrencyShrinks)},
+		{"ReadCacheHits", fmt.Sprintf("%d", stats.ReadCacheHits)},
+		{"ReadCacheMisses", fmt.Sprintf("%d", stats.ReadCacheMisses)},
+		{"SyncFlushes", fmt.Sprintf("%d", stats.SyncFlushes)},
+		{"DiskFulls", fmt.Sprintf("%d", stats.DiskFulls)},
+		{"DiskFullRejections", fmt.Sprintf("%d", stats.DiskFullRejections)},
+		{"DiskFullRetries", fmt.Sprintf("%d", stats.DiskFullRetries)},
+		{"PathsRebalanceFiles", fmt.Sprintf("%d", stats.PathsRebalanceFiles)},
+		{"PathsRebalanceBytes", fmt.Sprintf("%d", stats.PathsRebalanceBytes)},
+		{"PathsRebalancePending", fmt.Sprintf("%d", stats.PathsRebalancePending)},
+		{"BackgroundPauses", fmt.Sprintf("%d", stats.BackgroundPauses)},
+		{"DiskHealth", fmt.Sprintf("%d", stats.DiskHealth)},
+	}
+	for i := range stats.Disks {
+		report = append(report, []string{"Disk " + stats.Disks[i].Path, stats.Disks[i].String()})
+	}
+	for i := range stats.ValuesFiles {
+		report = append(report, []string{"ValuesFile " + stats.ValuesFiles[i].Name, stats.ValuesFiles[i].String()})
+	}
+	for i := range stats.ReplicationLagNodes {
+		report = append(report, []string{fmt.Sprintf("ReplicationLag node %016x", stats.ReplicationLagNodes[i].NodeID), stats.ReplicationLagNodes[i].String()})
+	}
+	for i := range stats.ReplicationLagPartitions {
+		report = append(report, []string{fmt.Sprintf("ReplicationLag partition %d", stats.ReplicationLagPartitions[i].Partition), stats.ReplicationLagPartitions[i].String()})
 	}
 	if stats.debug {
 		report = append(report, [][]string{
@@ -361,8 +970,9 @@ func (stats *Stats) String() string {
 			{"valuesFileReaders", fmt.Sprintf("%d", stats.valuesFileReaders)},
 			{"checksumInterval", fmt.Sprintf("%d", stats.checksumInterval)},
 			{"replicationIgnoreRecent", fmt.Sprintf("%d", stats.replicationIgnoreRecent)},
-			{"vlmDebugInfo", stats.vlmDebugInfo.String()},
 		}...)
+		report = append(report, stats.msgPools...)
+		report = append(report, []string{"vlmDebugInfo", stats.vlmDebugInfo.String()})
 	}
 	return brimtext.Align(report, nil)
 }
@@ -0,0 +1,73 @@
+package valuestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Path: dir, IgnoreEnv: true}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 1000; keyB++ {
+		if _, err = vs.Write(1, keyB, 1000, []byte(fmt.Sprintf("value %d", keyB))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for keyB := uint64(1); keyB <= 10; keyB++ {
+		if _, err := vs.Delete(1, keyB, 5000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	stats := vs.Stats(false).(*Stats)
+	if stats.Values != 990 || stats.Tombstones != 10 {
+		t.Fatal(stats.Values, stats.Tombstones)
+	}
+	if len(stats.ValuesFiles) == 0 {
+		t.Fatal("no values files")
+	}
+	var total int64
+	for _, f := range stats.ValuesFiles {
+		if f.Waste != -1 {
+			t.Fatalf("%s has waste %f before any sample", f.Name, f.Waste)
+		}
+		total += f.Bytes
+	}
+	if total == 0 || uint64(total) > stats.DiskBytes {
+		t.Fatal(total, stats.DiskBytes)
+	}
+	vs.Close()
+	vs = New(cfg)
+	defer vs.Close()
+	vs.EnableWrites()
+	stats = vs.Stats(false).(*Stats)
+	if stats.RecoveryDuration <= 0 || stats.Tombstones != 10 {
+		t.Fatal(stats.RecoveryDuration, stats.Tombstones)
+	}
+	vs.compactionState.ageThreshold = 0
+	vs.compactionPass(false)
+	sampled := false
+	for _, f := range vs.Stats(false).(*Stats).ValuesFiles {
+		if f.Waste >= 0 && f.Waste <= 1 {
+			sampled = true
+		}
+	}
+	if !sampled {
+		t.Fatal("no values file waste sampled by compaction")
+	}
+	s := vs.Stats(false).String()
+	for _, row := range []string{"Tombstones", "RecoveryDuration", "ValuesFile "} {
+		if !strings.Contains(s, row) {
+			t.Fatalf("%q missing from %s", row, s)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package valuestore
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	_SYNC_POLICY_NEVER = iota
+	_SYNC_POLICY_INTERVAL
+	_SYNC_POLICY_ALWAYS
+)
+
+var syncPolicies = map[string]int{
+	"never":    _SYNC_POLICY_NEVER,
+	"interval": _SYNC_POLICY_INTERVAL,
+	"always":   _SYNC_POLICY_ALWAYS,
+}
+
+// syncPolicyState makes writes durable as Config.SyncPolicy and WriteDurable
+// ask. Values only reach disk in whole checksum blocks, so the only way to
+// get a write onto disk promptly is a Flush, which closes the values and TOC
+// files being written; the syncer does these flushes, one for however many
+// writes are waiting on it, with the files fsynced as they are closed.
+type syncPolicyState struct {
+	policy   int
+	interval time.Duration
+	// durable counts the WriteDurable calls waiting for a sync under the
+	// "never" policy; while any are, files are fsynced as they are closed,
+	// including those closed for reaching ValuesFileCap before the flush.
+	durable int32
+	// dirty is set by each write, so the "interval" policy can skip flushing
+	// when nothing has been written.
+	dirty   int32
+	reqChan chan chan struct{}
+}
+
+func (vs *DefaultValueStore) syncPolicyConfig(cfg *Config) {
+	vs.syncPolicyState.policy = syncPolicies[cfg.SyncPolicy]
+	vs.syncPolicyState.interval = time.Duration(cfg.SyncInterval) * time.Millisecond
+	vs.syncPolicyState.reqChan = make(chan chan struct{})
+}
+
+func (vs *DefaultValueStore) syncPolicyLaunch() {
+	vs.closeState.backgroundWG.Add(1)
+	go vs.syncer()
+}
+
+func (vs *DefaultValueStore) syncer() {
+	defer vs.closeState.backgroundWG.Done()
+	s := &vs.syncPolicyState
+	var tick <-chan time.Time
+	if s.policy == _SYNC_POLICY_INTERVAL {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var waiting []chan struct{}
+	for {
+		select {
+		case c := <-s.reqChan:
+			waiting = append(waiting, c)
+		case <-tick:
+			if atomic.LoadInt32(&s.dirty) == 0 {
+				continue
+			}
+		case <-vs.closeState.backgroundChan:
+			return
+		}
+		// Anyone else already waiting is covered by the same flush.
+		for more := true; more; {
+			select {
+			case c := <-s.reqChan:
+				waiting = append(waiting, c)
+			default:
+				more = false
+			}
+		}
+		atomic.StoreInt32(&s.dirty, 0)
+		vs.Flush()
+		for _, dir := range vs.tocDirs() {
+			vs.syncDir(dir)
+		}
+		if _, ok := vs.fileBackend.(osFileBackend); ok {
+			for _, dir := range vs.valuesDirs() {
+				vs.syncDir(dir)
+			}
+		}
+		atomic.AddInt32(&vs.syncFlushes, 1)
+		for _, c := range waiting {
+			c <- struct{}{}
+		}
+		waiting = waiting[:0]
+	}
+}
+
+// syncWait returns once everything written before it was called has been
+// flushed and fsynced, or ErrClosed if the store is closed first.
+func (vs *DefaultValueStore) syncWait() error {
+	c := make(chan struct{}, 1)
+	select {
+	case vs.syncPolicyState.reqChan <- c:
+	case <-vs.closeState.backgroundChan:
+		return ErrClosed
+	}
+	<-c
+	return nil
+}
+
+// syncAlways is syncWait with the "always" policy and nothing otherwise.
+func (vs *DefaultValueStore) syncAlways() error {
+	if vs.syncPolicyState.policy != _SYNC_POLICY_ALWAYS {
+		return nil
+	}
+	return vs.syncWait()
+}
+
+// syncOnClose returns whether values and TOC files should be fsynced as they
+// are closed.
+func (vs *DefaultValueStore) syncOnClose() bool {
+	return vs.syncPolicyState.policy != _SYNC_POLICY_NEVER || atomic.LoadInt32(&vs.syncPolicyState.durable) > 0
+}
+
+// syncDir fsyncs the directory, so the files the flush created are found
+// after a power loss.
+func (vs *DefaultValueStore) syncDir(dir string) {
+	fp, err := os.Open(dir)
+	if err != nil {
+		vs.logError("error opening %s: %s\n", dir, err)
+		return
+	}
+	if err = fp.Sync(); err != nil {
+		vs.logError("error syncing %s: %s\n", dir, err)
+	}
+	fp.Close()
+}
+
+// WriteDurable is Write with the choice, whatever Config.SyncPolicy is, of
+// whether to wait for the value to be on disk: with sync, the value and its
+// TOC entry are flushed and fsynced before it returns, sharing the flush with
+// any other writes waiting on one at the time. Should the store be closed
+// while waiting, ErrClosed is returned, though the value will usually have
+// been written by Close's own flush.
+func (vs *DefaultValueStore) WriteDurable(keyA uint64, keyB uint64, timestampmicro int64, value []byte, sync bool) (int64, error) {
+	if !sync {
+		return vs.writeValue(keyA, keyB, timestampmicro, value)
+	}
+	atomic.AddInt32(&vs.syncPolicyState.durable, 1)
+	defer atomic.AddInt32(&vs.syncPolicyState.durable, -1)
+	ptimestampmicro, err := vs.writeValue(keyA, keyB, timestampmicro, value)
+	if err == nil {
+		if err = vs.syncWait(); err != nil {
+			atomic.AddInt32(&vs.writeErrors, 1)
+		}
+	}
+	return ptimestampmicro, err
+}
+
+// syncingWriteCloser is a TOC file that is fsynced before it is closed when
+// syncOnClose says so.
+type syncingWriteCloser struct {
+	*os.File
+	vs *DefaultValueStore
+}
+
+// Write is followed by the failpoint _FAILPOINT_TOC_WRITE.
+func (w *syncingWriteCloser) Write(b []byte) (int, error) {
+	n, err := w.File.Write(b)
+	w.vs.failpoint(_FAILPOINT_TOC_WRITE)
+	return n, err
+}
+
+func (w *syncingWriteCloser) Close() error {
+	if w.vs.syncOnClose() {
+		if err := w.File.Sync(); err != nil {
+			w.File.Close()
+			return err
+		}
+	}
+	return w.File.Close()
+}
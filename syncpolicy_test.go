@@ -0,0 +1,90 @@
+package valuestore
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncPolicyAlways(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	h.cfg.SyncPolicy = "always"
+	vs := h.open()
+	if _, err := vs.Write(1, 1, 1001, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Delete(1, 2, 1002); err != nil {
+		t.Fatal(err)
+	}
+	stats := vs.Stats(false).(*Stats)
+	if stats.SyncFlushes != 2 || stats.ValuesFileSyncs == 0 {
+		t.Fatal(stats.SyncFlushes, stats.ValuesFileSyncs)
+	}
+	vs2 := h.crash()
+	if ts, value, err := vs2.Read(1, 1, nil); err != nil || ts != 1001 || !bytes.Equal(value, []byte("one")) {
+		t.Fatal(ts, string(value), err)
+	}
+	if ts, _, err := vs2.Read(1, 2, nil); err != ErrNotFound || ts != 1002 {
+		t.Fatal(ts, err)
+	}
+}
+
+func TestSyncPolicyInterval(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	h.cfg.SyncPolicy = "interval"
+	h.cfg.SyncInterval = 10
+	vs := h.open()
+	if _, err := vs.Write(1, 1, 1001, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; vs.Stats(false).(*Stats).SyncFlushes == 0; i++ {
+		if i == 200 {
+			t.Fatal("no sync flush")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ts, _, err := h.crash().Read(1, 1, nil); err != nil || ts != 1001 {
+		t.Fatal(ts, err)
+	}
+	// With nothing new written, no more flushes are made.
+	vs.Stats(true)
+	time.Sleep(50 * time.Millisecond)
+	if stats := vs.Stats(false).(*Stats); stats.SyncFlushes != 0 {
+		t.Fatal(stats.SyncFlushes)
+	}
+}
+
+func TestWriteDurable(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	if _, err := vs.WriteDurable(1, 1, 1001, []byte("buffered"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.crash().Read(1, 1, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := uint64(2); i < 12; i++ {
+		wg.Add(1)
+		go func(keyB uint64) {
+			defer wg.Done()
+			if _, err := vs.WriteDurable(1, keyB, int64(1000+keyB), []byte("durable"), true); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if stats := vs.Stats(false).(*Stats); stats.SyncFlushes == 0 || stats.SyncFlushes > 10 {
+		t.Fatal(stats.SyncFlushes)
+	}
+	vs2 := h.crash()
+	for i := uint64(1); i < 12; i++ {
+		if ts, _, err := vs2.Read(1, i, nil); err != nil || ts != int64(1000+i) {
+			t.Fatal(i, ts, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package valuestore
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// tombstoneAckState keeps, with Config.TombstoneDiscardAcked, expired
+// deletion markers until the other replicas have acknowledged them. Each
+// discard pass sends the expired markers of the partitions the local node is
+// responsible for to the replicas yet to acknowledge them, remembering which
+// node each bulk-set message went to by its msgID, since the bulk-set-ack
+// messages that come back only echo the msgIDs. Markers in partitions the
+// local node is not responsible for are left to push replication, whose
+// handoffs are removed once acked anyway.
+type tombstoneAckState struct {
+	enabled bool
+	lock    sync.Mutex
+	// sent maps the msgIDs of the bulk-set messages sent this pass to the
+	// nodes they were sent to, and prevSent those of the pass before, so
+	// acks arriving after the next pass has started still count.
+	sent     map[uint64]uint64
+	prevSent map[uint64]uint64
+	acks     map[bulkSetKey]*tombstoneAck
+	pass     uint64
+}
+
+type tombstoneAck struct {
+	timestampbits uint64
+	nodeIDs       []uint64
+	// pass is the last discard pass to find the marker still expired; acks
+	// for markers no longer around are forgotten at the end of each pass.
+	pass uint64
+}
+
+func (vs *DefaultValueStore) tombstoneAckConfig(cfg *Config) {
+	s := &vs.tombstoneAckState
+	s.enabled = cfg.TombstoneDiscardAcked && vs.msgRing != nil
+	s.sent = make(map[uint64]uint64)
+	s.prevSent = make(map[uint64]uint64)
+	s.acks = make(map[bulkSetKey]*tombstoneAck)
+}
+
+// tombstonePushCutoff returns the timestampbits below which push replication
+// should leave deletion markers out; with TombstoneDiscardAcked there is no
+// such cutoff, as the markers are kept until acked.
+func (vs *DefaultValueStore) tombstonePushCutoff(timestampbitsNow uint64) uint64 {
+	if vs.tombstoneAckState.enabled {
+		return 0
+	}
+	return timestampbitsNow - atomic.LoadUint64(&vs.tombstoneDiscardState.age)
+}
+
+func (vs *DefaultValueStore) tombstoneAckPassBegin() {
+	s := &vs.tombstoneAckState
+	s.lock.Lock()
+	s.prevSent = s.sent
+	s.sent = make(map[uint64]uint64)
+	s.pass++
+	s.lock.Unlock()
+}
+
+func (vs *DefaultValueStore) tombstoneAckPassEnd() {
+	s := &vs.tombstoneAckState
+	s.lock.Lock()
+	for k, a := range s.acks {
+		if a.pass != s.pass {
+			delete(s.acks, k)
+		}
+	}
+	s.lock.Unlock()
+}
+
+// tombstoneAckMissing appends to missing those of nodeIDs yet to acknowledge
+// the deletion marker. Once none are missing, the acks are forgotten, as the
+// marker is about to be discarded.
+func (vs *DefaultValueStore) tombstoneAckMissing(keyA uint64, keyB uint64, timestampbits uint64, nodeIDs []uint64, missing []uint64) []uint64 {
+	s := &vs.tombstoneAckState
+	k := bulkSetKey{keyA: keyA, keyB: keyB}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.acks[k]
+	if a == nil || a.timestampbits != timestampbits {
+		a = &tombstoneAck{timestampbits: timestampbits}
+		s.acks[k] = a
+	}
+	a.pass = s.pass
+	for _, nodeID := range nodeIDs {
+		acked := false
+		for _, ackedID := range a.nodeIDs {
+			if ackedID == nodeID {
+				acked = true
+				break
+			}
+		}
+		if !acked {
+			missing = append(missing, nodeID)
+		}
+	}
+	if len(missing) == 0 {
+		delete(s.acks, k)
+	}
+	return missing
+}
+
+// tombstoneAckOut adds the deletion marker to the outgoing message for
+// nodeID, sending the message on and starting another if it is full.
+func (vs *DefaultValueStore) tombstoneAckOut(out map[uint64]*bulkSetMsg, nodeID uint64, keyA uint64, keyB uint64, timestampbits uint64) {
+	bsm := out[nodeID]
+	if bsm != nil && !bsm.add(keyA, keyB, timestampbits, nil) {
+		vs.tombstoneAckSend(bsm, nodeID)
+		bsm = nil
+	}
+	if bsm == nil {
+		bsm = vs.newOutBulkSetMsg()
+		bsm.setAckPolicy(BULK_SET_ACK_APPLIED)
+		out[nodeID] = bsm
+		bsm.add(keyA, keyB, timestampbits, nil)
+	}
+}
+
+func (vs *DefaultValueStore) tombstoneAckSend(bsm *bulkSetMsg, nodeID uint64) {
+	s := &vs.tombstoneAckState
+	s.lock.Lock()
+	s.sent[bsm.msgID()] = nodeID
+	s.lock.Unlock()
+	if vs.msgToNode(bsm, nodeID, vs.pushReplicationState.outMsgTimeout) {
+		atomic.AddInt32(&vs.outBulkSetTombstones, 1)
+	}
+}
+
+// tombstoneAckNode returns the node the bulk-set-ack message is from if it
+// acks a bulk-set message sent by tombstoneAckSend.
+func (vs *DefaultValueStore) tombstoneAckNode(bsam *bulkSetAckMsg) (uint64, bool) {
+	s := &vs.tombstoneAckState
+	if !s.enabled {
+		return 0, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := 0; i < bsam.msgIDCount(); i++ {
+		msgID := bsam.msgID(i)
+		if nodeID, ok := s.sent[msgID]; ok {
+			return nodeID, true
+		}
+		if nodeID, ok := s.prevSent[msgID]; ok {
+			return nodeID, true
+		}
+	}
+	return 0, false
+}
+
+// tombstoneAckAdd records that nodeID has acknowledged the deletion marker.
+func (vs *DefaultValueStore) tombstoneAckAdd(nodeID uint64, keyA uint64, keyB uint64, timestampbits uint64) {
+	s := &vs.tombstoneAckState
+	s.lock.Lock()
+	if a := s.acks[bulkSetKey{keyA: keyA, keyB: keyB}]; a != nil && a.timestampbits == timestampbits {
+		acked := false
+		for _, ackedID := range a.nodeIDs {
+			if ackedID == nodeID {
+				acked = true
+				break
+			}
+		}
+		if !acked {
+			a.nodeIDs = append(a.nodeIDs, nodeID)
+		}
+	}
+	s.lock.Unlock()
+}
@@ -0,0 +1,69 @@
+package valuestore
+
+import (
+	"testing"
+
+	"github.com/gholt/ring"
+)
+
+func TestTombstoneDiscardAcked(t *testing.T) {
+	b := ring.NewBuilder(64)
+	b.SetReplicaCount(2)
+	n, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := b.AddNode(true, 1, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b.Ring()
+	r.SetLocalNode(n.ID())
+	m := &msgRingPlaceholder{ring: r}
+	vs := New(&Config{
+		Path:                  t.TempDir(),
+		MsgRing:               m,
+		TombstoneAge:          -1,
+		TombstoneDiscardAcked: true,
+		InBulkSetAckWorkers:   1,
+		InBulkSetAckMsgs:      1,
+	})
+	vs.EnableWrites()
+	defer vs.Close()
+	if _, err := vs.write(1, 2, 0x300|_TSB_DELETION, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Expired, but kept and sent on, as n2 has yet to acknowledge it.
+	vs.TombstoneDiscardPass()
+	if ts, _, err := vs.read(1, 2, nil); err != ErrNotFound || ts != 0x300|_TSB_DELETION {
+		t.Fatal(ts, err)
+	}
+	m.lock.Lock()
+	if len(m.msgToNodeIDs) != 1 || m.msgToNodeIDs[0] != n2.ID() {
+		t.Fatal(m.msgToNodeIDs)
+	}
+	m.lock.Unlock()
+	if stats := vs.Stats(false).(*Stats); stats.ExpiredDeletionsUnacked != 1 || stats.ExpiredDeletions != 0 || stats.OutBulkSetTombstones != 1 {
+		t.Fatal(stats.ExpiredDeletionsUnacked, stats.ExpiredDeletions, stats.OutBulkSetTombstones)
+	}
+	var msgID uint64
+	for msgID = range vs.tombstoneAckState.sent {
+	}
+	bsam := <-vs.bulkSetAckState.inFreeMsgChan
+	bsam.body = bsam.body[:0]
+	bsam.addMsgID(msgID)
+	bsam.add(1, 2, 0x300|_TSB_DELETION)
+	vs.bulkSetAckState.inMsgChan <- bsam
+	// Only one of these, so getting it back means the ack was processed.
+	vs.bulkSetAckState.inFreeMsgChan <- <-vs.bulkSetAckState.inFreeMsgChan
+	vs.TombstoneDiscardPass()
+	if ts, _, err := vs.read(1, 2, nil); err != ErrNotFound || ts != 0x300|_TSB_DELETION|_TSB_LOCAL_REMOVAL {
+		t.Fatal(ts, err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.ExpiredDeletions != 1 {
+		t.Fatal(stats.ExpiredDeletions)
+	}
+	if len(vs.tombstoneAckState.acks) != 0 {
+		t.Fatal(vs.tombstoneAckState.acks)
+	}
+}
@@ -10,12 +10,15 @@ import (
 )
 
 type tombstoneDiscardState struct {
-	interval      int
+	// interval and age may be changed while running, so are accessed
+	// atomically.
+	interval      int64
 	age           uint64
 	notifyChan    chan *backgroundNotification
 	abort         uint32
 	localRemovals [][]localRemovalEntry
 	batchSize     int
+	workers       int
 }
 
 type localRemovalEntry struct {
@@ -25,13 +28,15 @@ type localRemovalEntry struct {
 }
 
 func (vs *DefaultValueStore) tombstoneDiscardConfig(cfg *Config) {
-	vs.tombstoneDiscardState.interval = cfg.TombstoneDiscardInterval
+	vs.tombstoneDiscardState.interval = int64(cfg.TombstoneDiscardInterval)
 	vs.tombstoneDiscardState.age = (uint64(cfg.TombstoneAge) * uint64(time.Second) / 1000) << _TSB_UTIL_BITS
 	vs.tombstoneDiscardState.notifyChan = make(chan *backgroundNotification, 1)
 	vs.tombstoneDiscardState.batchSize = cfg.TombstoneDiscardBatchSize
+	vs.tombstoneDiscardState.workers = backgroundWorkerCap(cfg)
 }
 
 func (vs *DefaultValueStore) tombstoneDiscardLaunch() {
+	vs.closeState.backgroundWG.Add(1)
 	go vs.tombstoneDiscardLauncher()
 }
 
@@ -39,23 +44,13 @@ func (vs *DefaultValueStore) tombstoneDiscardLaunch() {
 // EnableTombstoneDiscard is called. A discard pass removes expired tombstones
 // (deletion markers).
 func (vs *DefaultValueStore) DisableTombstoneDiscard() {
-	c := make(chan struct{}, 1)
-	vs.tombstoneDiscardState.notifyChan <- &backgroundNotification{
-		disable:  true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.tombstoneDiscardState.notifyChan, &backgroundNotification{disable: true})
 }
 
 // EnableTombstoneDiscard will resume discard passes. A discard pass removes
 // expired tombstones (deletion markers).
 func (vs *DefaultValueStore) EnableTombstoneDiscard() {
-	c := make(chan struct{}, 1)
-	vs.tombstoneDiscardState.notifyChan <- &backgroundNotification{
-		enable:   true,
-		doneChan: c,
-	}
-	<-c
+	vs.backgroundNotify(vs.tombstoneDiscardState.notifyChan, &backgroundNotification{enable: true})
 }
 
 // TombstoneDiscardPass will immediately execute a pass to discard expired
@@ -64,14 +59,13 @@ func (vs *DefaultValueStore) EnableTombstoneDiscard() {
 // call to this function ensures one complete pass occurs.
 func (vs *DefaultValueStore) TombstoneDiscardPass() {
 	atomic.StoreUint32(&vs.tombstoneDiscardState.abort, 1)
-	c := make(chan struct{}, 1)
-	vs.tombstoneDiscardState.notifyChan <- &backgroundNotification{doneChan: c}
-	<-c
+	vs.backgroundNotify(vs.tombstoneDiscardState.notifyChan, &backgroundNotification{})
 }
 
 func (vs *DefaultValueStore) tombstoneDiscardLauncher() {
+	defer vs.closeState.backgroundWG.Done()
 	var enabled bool
-	interval := float64(vs.tombstoneDiscardState.interval) * float64(time.Second)
+	interval := float64(atomic.LoadInt64(&vs.tombstoneDiscardState.interval)) * float64(time.Second)
 	vs.randMutex.Lock()
 	nextRun := time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 	vs.randMutex.Unlock()
@@ -82,13 +76,18 @@ func (vs *DefaultValueStore) tombstoneDiscardLauncher() {
 			select {
 			case notification = <-vs.tombstoneDiscardState.notifyChan:
 			case <-time.After(sleep):
+			case <-vs.closeState.backgroundChan:
+				return
 			}
 		} else {
 			select {
 			case notification = <-vs.tombstoneDiscardState.notifyChan:
+			case <-vs.closeState.backgroundChan:
+				return
 			default:
 			}
 		}
+		interval = float64(atomic.LoadInt64(&vs.tombstoneDiscardState.interval)) * float64(time.Second)
 		vs.randMutex.Lock()
 		nextRun = time.Now().Add(time.Duration(interval + interval*vs.rand.NormFloat64()*0.1))
 		vs.randMutex.Unlock()
@@ -140,7 +139,7 @@ func (vs *DefaultValueStore) tombstoneDiscardPassLocalRemovals() {
 		partitionShift = 64 - pbc
 		partitionMax = (uint64(1) << pbc) - 1
 	}
-	workerMax := uint64(vs.workers - 1)
+	workerMax := uint64(vs.tombstoneDiscardState.workers - 1)
 	workerPartitionPiece := (uint64(1) << partitionShift) / (workerMax + 1)
 	work := func(partition uint64, worker uint64) {
 		partitionOnLeftBits := partition << partitionShift
@@ -164,12 +163,15 @@ func (vs *DefaultValueStore) tombstoneDiscardPassLocalRemovals() {
 	workerPartitionOffset := (partitionMax + 1) / (workerMax + 1)
 	for worker := uint64(0); worker <= workerMax; worker++ {
 		go func(worker uint64) {
+			pacer := vs.newBackgroundPacer()
 			partitionBegin := workerPartitionOffset * worker
 			for partition := partitionBegin; partition <= partitionMax; partition++ {
 				work(partition, worker)
+				pacer.pause()
 			}
 			for partition := uint64(0); partition < partitionBegin; partition++ {
 				work(partition, worker)
+				pacer.pause()
 			}
 			wg.Done()
 		}(worker)
@@ -179,7 +181,9 @@ func (vs *DefaultValueStore) tombstoneDiscardPassLocalRemovals() {
 
 // tombstoneDiscardPassExpiredDeletions scans for valuelocmap entries marked
 // with _TSB_DELETION (but not _TSB_LOCAL_REMOVAL) that are older than the
-// maximum tombstone age and marks them for _TSB_LOCAL_REMOVAL.
+// maximum tombstone age and marks them for _TSB_LOCAL_REMOVAL. With
+// TombstoneDiscardAcked, only those acknowledged by all the other replicas
+// are marked; the rest are sent to the replicas yet to acknowledge them.
 func (vs *DefaultValueStore) tombstoneDiscardPassExpiredDeletions() {
 	// Each worker will perform a pass on a subsection of each partition's key
 	// space. Additionally, each worker will start their work on different
@@ -192,9 +196,14 @@ func (vs *DefaultValueStore) tombstoneDiscardPassExpiredDeletions() {
 		partitionShift = 64 - pbc
 		partitionMax = (uint64(1) << pbc) - 1
 	}
-	workerMax := uint64(vs.workers - 1)
+	acked := vs.tombstoneAckState.enabled
+	if acked {
+		vs.tombstoneAckPassBegin()
+		defer vs.tombstoneAckPassEnd()
+	}
+	workerMax := uint64(vs.tombstoneDiscardState.workers - 1)
 	workerPartitionPiece := (uint64(1) << partitionShift) / (workerMax + 1)
-	work := func(partition uint64, worker uint64, localRemovals []localRemovalEntry) {
+	work := func(partition uint64, worker uint64, localRemovals []localRemovalEntry, pacer *backgroundPacer, out map[uint64]*bulkSetMsg) {
 		partitionOnLeftBits := partition << partitionShift
 		rangeBegin := partitionOnLeftBits + (workerPartitionPiece * worker)
 		var rangeEnd uint64
@@ -209,7 +218,25 @@ func (vs *DefaultValueStore) tombstoneDiscardPassExpiredDeletions() {
 				rangeEnd = math.MaxUint64
 			}
 		}
-		cutoff := (uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS) - vs.tombstoneDiscardState.age
+		// The other replicas of the partition have to acknowledge each marker
+		// before it is discarded; handoffs are left to push replication.
+		var nodeIDs, missing []uint64
+		if acked {
+			ring := vs.msgRing.Ring()
+			if ring == nil || !ring.Responsible(uint32(partition)) {
+				return
+			}
+			var localID uint64
+			if n := ring.LocalNode(); n != nil {
+				localID = n.ID()
+			}
+			for _, n := range ring.ResponsibleNodes(uint32(partition)) {
+				if n.ID() != localID {
+					nodeIDs = append(nodeIDs, n.ID())
+				}
+			}
+		}
+		cutoff := (uint64(brimtime.TimeToUnixMicro(time.Now())) << _TSB_UTIL_BITS) - atomic.LoadUint64(&vs.tombstoneDiscardState.age)
 		more := true
 		for more {
 			localRemovalsIndex := 0
@@ -224,13 +251,23 @@ func (vs *DefaultValueStore) tombstoneDiscardPassExpiredDeletions() {
 				localRemovalsIndex++
 				return true
 			})
-			atomic.AddInt32(&vs.expiredDeletions, int32(localRemovalsIndex))
 			for i := 0; i < localRemovalsIndex; i++ {
 				e := &localRemovals[i]
+				if acked {
+					if missing = vs.tombstoneAckMissing(e.keyA, e.keyB, e.timestampbits, nodeIDs, missing[:0]); len(missing) > 0 {
+						atomic.AddInt32(&vs.expiredDeletionsUnacked, 1)
+						for _, nodeID := range missing {
+							vs.tombstoneAckOut(out, nodeID, e.keyA, e.keyB, e.timestampbits)
+						}
+						continue
+					}
+				}
+				atomic.AddInt32(&vs.expiredDeletions, 1)
 				// These writes go through the entire system, so they're
 				// persisted and therefore restored on restarts.
 				vs.write(e.keyA, e.keyB, e.timestampbits|_TSB_LOCAL_REMOVAL, nil)
 			}
+			pacer.pause()
 		}
 	}
 	// To avoid memory churn, the localRemovals scratchpads are allocated just
@@ -243,9 +280,11 @@ func (vs *DefaultValueStore) tombstoneDiscardPassExpiredDeletions() {
 	for worker := uint64(0); worker <= workerMax; worker++ {
 		go func(worker uint64) {
 			localRemovals := vs.tombstoneDiscardState.localRemovals[worker]
+			pacer := vs.newBackgroundPacer()
+			out := make(map[uint64]*bulkSetMsg)
 			partitionBegin := (partitionMax + 1) / (workerMax + 1) * worker
 			for partition := partitionBegin; ; {
-				work(partition, worker, localRemovals)
+				work(partition, worker, localRemovals, pacer, out)
 				partition++
 				if partition > partitionMax {
 					partition = 0
@@ -254,8 +293,21 @@ func (vs *DefaultValueStore) tombstoneDiscardPassExpiredDeletions() {
 					break
 				}
 			}
+			for nodeID, bsm := range out {
+				vs.tombstoneAckSend(bsm, nodeID)
+			}
 			wg.Done()
 		}(worker)
 	}
 	wg.Wait()
 }
+
+// tombstones returns the number of deletion markers not yet discarded.
+func (vs *DefaultValueStore) tombstones() uint64 {
+	var count uint64
+	vs.vlm.ScanCallback(0, math.MaxUint64, _TSB_DELETION, _TSB_LOCAL_REMOVAL, math.MaxUint64, math.MaxUint64, func(keyA uint64, keyB uint64, timestampbits uint64, length uint32) bool {
+		count++
+		return true
+	})
+	return count
+}
@@ -1,37 +1,45 @@
 package valuestore
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
+	"hash"
 	"io"
-	"os"
-	"path"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/spaolacci/murmur3"
-	"gopkg.in/gholt/brimutil.v1"
 )
 
 // TODO: No more panicking since we might not be the only reason this go
 // process is running.
 
 type valuesFile struct {
+	atOffset            uint64
 	vs                  *DefaultValueStore
 	id                  uint32
+	segmentLock         sync.Mutex
+	segmentIDs          []uint32
 	bts                 int64
+	checksumInterval    uint32
+	newHash             func() hash.Hash32
 	writerFP            io.WriteCloser
-	atOffset            uint32
+	entries             uint32
 	freeChan            chan *valuesFileWriteBuf
 	checksumChan        chan *valuesFileWriteBuf
 	writeChan           chan *valuesFileWriteBuf
 	doneChan            chan struct{}
 	buf                 *valuesFileWriteBuf
 	freeableVMChanIndex int
-	readerFPs           []brimutil.ChecksummedReader
-	readerLocks         []sync.Mutex
-	readerLens          [][]byte
+	openReadSeeker      func(name string) (io.ReadSeeker, error)
+	readers             []*valuesFileReader
+	// framed is set for a v1 values file, whose values have a leading codec
+	// byte; codec is what it was written with. See _COMPRESSION_NONE.
+	framed bool
+	codec  byte
+	// keyID is the encryption key ID the file was written with, 0 for none.
+	keyID uint32
 }
 
 type valuesFileWriteBuf struct {
@@ -41,40 +49,61 @@ type valuesFileWriteBuf struct {
 	vms    []*valuesMem
 }
 
-func osOpenReadSeeker(name string) (io.ReadSeeker, error) {
-	return os.Open(name)
-}
-
-func osCreateWriteCloser(name string) (io.WriteCloser, error) {
-	return os.Create(name)
-}
-
-func newValuesFile(vs *DefaultValueStore, bts int64, openReadSeeker func(name string) (io.ReadSeeker, error)) *valuesFile {
-	vf := &valuesFile{vs: vs, bts: bts}
-	name := path.Join(vs.path, fmt.Sprintf("%019d.values", vf.bts))
-	vf.readerFPs = make([]brimutil.ChecksummedReader, vs.valuesFileReaders)
-	vf.readerLocks = make([]sync.Mutex, len(vf.readerFPs))
-	vf.readerLens = make([][]byte, len(vf.readerFPs))
-	for i := 0; i < len(vf.readerFPs); i++ {
-		fp, err := openReadSeeker(name)
-		if err != nil {
-			panic(err)
+func newValuesFile(vs *DefaultValueStore, bts int64, openReadSeeker func(name string) (io.ReadSeeker, error)) (*valuesFile, error) {
+	vf := &valuesFile{vs: vs, bts: bts, checksumInterval: vs.checksumInterval, newHash: murmur3.New32, openReadSeeker: openReadSeeker}
+	name := vs.valuesName(vf.bts)
+	fp, err := openReadSeeker(name)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 32)
+	rs, keyID, err := vs.decryptReadSeeker(fp)
+	vf.keyID = keyID
+	if err == nil {
+		_, err = io.ReadFull(rs, head)
+	}
+	if err == nil {
+		vf.framed, vf.codec = valuesFileHeadCodec(head)
+		var interval uint32
+		var newHash func() hash.Hash32
+		if interval, newHash, err = checksumHeader(head); err == nil {
+			vf.checksumInterval = interval
+			vf.newHash = newHash
 		}
-		vf.readerFPs[i] = brimutil.NewChecksummedReader(fp, int(vs.checksumInterval), murmur3.New32)
-		vf.readerLens[i] = make([]byte, 4)
 	}
+	if err != nil {
+		vs.logError("bad header checksum settings for %s, assuming current ones: %s\n", name, err)
+	}
+	if c, ok := fp.(io.Closer); ok {
+		c.Close()
+	}
+	vf.initReaders()
 	vf.id = vs.addValueLocBlock(vf)
-	return vf
+	return vf, nil
+}
+
+// initReaders sets up the reader slots; they are opened as reads need them.
+func (vf *valuesFile) initReaders() {
+	vf.readers = make([]*valuesFileReader, vf.vs.readerTuneState.max)
+	for i := range vf.readers {
+		vf.readers[i] = &valuesFileReader{vf: vf}
+	}
 }
 
 func createValuesFile(vs *DefaultValueStore, createWriteCloser func(name string) (io.WriteCloser, error), openReadSeeker func(name string) (io.ReadSeeker, error)) *valuesFile {
-	vf := &valuesFile{vs: vs, bts: time.Now().UnixNano()}
-	name := path.Join(vs.path, fmt.Sprintf("%019d.values", vf.bts))
+	vf := &valuesFile{vs: vs, bts: time.Now().UnixNano(), checksumInterval: vs.checksumInterval, newHash: murmur3.New32, openReadSeeker: openReadSeeker}
+	vs.pathsPlace(vf.bts)
+	name := vs.valuesName(vf.bts)
+	vs.manifestAppend(_MANIFEST_CREATE, vf.bts)
 	fp, err := createWriteCloser(name)
 	if err != nil {
 		panic(err)
 	}
-	vf.writerFP = fp
+	vf.writerFP, err = vs.encryptWriteCloser(&countingWriteCloser{WriteCloser: fp, vs: vs, disk: vs.diskHealthState.values}, vs.checksumInterval+4)
+	if err != nil {
+		panic(err)
+	}
+	vf.keyID = vs.encryptionState.keyID
 	vf.freeChan = make(chan *valuesFileWriteBuf, vs.workers)
 	for i := 0; i < vs.workers; i++ {
 		vf.freeChan <- &valuesFileWriteBuf{buf: make([]byte, vs.checksumInterval+4)}
@@ -83,25 +112,17 @@ func createValuesFile(vs *DefaultValueStore, createWriteCloser func(name string)
 	vf.writeChan = make(chan *valuesFileWriteBuf, vs.workers)
 	vf.doneChan = make(chan struct{})
 	vf.buf = <-vf.freeChan
-	head := []byte("VALUESTORE v0                   ")
-	binary.BigEndian.PutUint32(head[28:], vs.checksumInterval)
+	vf.framed = vs.compressionState.framed
+	vf.codec = vs.compressionState.codec
+	head := vs.valuesFileHead()
+	putChecksumHeader(head, _CHECKSUM_MURMUR3, vs.checksumInterval)
 	vf.buf.offset = uint32(copy(vf.buf.buf, head))
-	atomic.StoreUint32(&vf.atOffset, vf.buf.offset)
+	atomic.StoreUint64(&vf.atOffset, uint64(vf.buf.offset))
 	go vf.writer()
 	for i := 0; i < vs.workers; i++ {
 		go vf.checksummer()
 	}
-	vf.readerFPs = make([]brimutil.ChecksummedReader, vs.valuesFileReaders)
-	vf.readerLocks = make([]sync.Mutex, len(vf.readerFPs))
-	vf.readerLens = make([][]byte, len(vf.readerFPs))
-	for i := 0; i < len(vf.readerFPs); i++ {
-		fp, err := openReadSeeker(name)
-		if err != nil {
-			panic(err)
-		}
-		vf.readerFPs[i] = brimutil.NewChecksummedReader(fp, int(vs.checksumInterval), murmur3.New32)
-		vf.readerLens[i] = make([]byte, 4)
-	}
+	vf.initReaders()
 	vf.id = vs.addValueLocBlock(vf)
 	return vf
 }
@@ -111,28 +132,57 @@ func (vf *valuesFile) timestampnano() int64 {
 }
 
 func (vf *valuesFile) read(keyA uint64, keyB uint64, timestampbits uint64, offset uint32, length uint32, value []byte) (uint64, []byte, error) {
-	// TODO: Add calling Verify occasionally on the readerFPs, maybe randomly
+	return vf.readAt(keyA, keyB, timestampbits, uint64(offset), length, value)
+}
+
+func (vf *valuesFile) readAt(keyA uint64, keyB uint64, timestampbits uint64, offset uint64, length uint32, value []byte) (uint64, []byte, error) {
+	// TODO: Add calling Verify occasionally on the readers, maybe randomly
 	// inside here or maybe randomly requested by the caller.
 	if timestampbits&_TSB_DELETION != 0 {
 		return timestampbits, value, ErrNotFound
 	}
-	i := int(keyA>>1) % len(vf.readerFPs)
-	vf.readerLocks[i].Lock()
-	vf.readerFPs[i].Seek(int64(offset), 0)
-	end := len(value) + int(length)
-	if end <= cap(value) {
-		value = value[:end]
+	tuning := vf.vs.readerTuneState.interval > 0
+	var start time.Time
+	if tuning {
+		start = time.Now()
+	}
+	r := vf.vs.readerSlot(vf, keyA)
+	r.lock.Lock()
+	locked := time.Now()
+	if err := vf.vs.readerAcquire(r); err != nil {
+		r.lock.Unlock()
+		vf.vs.diskHealthState.values.read(time.Since(locked), err)
+		return timestampbits, value, err
+	}
+	r.fp.Seek(int64(offset), 0)
+	stored := value
+	if vf.framed {
+		stored = nil
+	}
+	end := len(stored) + int(length)
+	if end <= cap(stored) {
+		stored = stored[:end]
 	} else {
-		value2 := make([]byte, end)
-		copy(value2, value)
-		value = value2
+		stored2 := make([]byte, end)
+		copy(stored2, stored)
+		stored = stored2
+	}
+	_, err := io.ReadFull(r.fp, stored[len(stored)-int(length):])
+	vf.vs.readerRelease(r)
+	r.lock.Unlock()
+	done := time.Now()
+	vf.vs.diskHealthState.values.read(done.Sub(locked), err)
+	if tuning {
+		vf.vs.readerObserve(start, locked, done)
+	}
+	if err != nil {
+		return timestampbits, value, err
 	}
-	if _, err := io.ReadFull(vf.readerFPs[i], value[len(value)-int(length):]); err != nil {
-		vf.readerLocks[i].Unlock()
+	if vf.framed {
+		value, err = vf.vs.decompress(value, stored)
 		return timestampbits, value, err
 	}
-	vf.readerLocks[i].Unlock()
-	return timestampbits, value, nil
+	return timestampbits, stored, nil
 }
 
 func (vf *valuesFile) write(vm *valuesMem) {
@@ -140,7 +190,8 @@ func (vf *valuesFile) write(vm *valuesMem) {
 		return
 	}
 	vm.vfID = vf.id
-	vm.vfOffset = atomic.LoadUint32(&vf.atOffset)
+	vm.vfOffset = atomic.LoadUint64(&vf.atOffset)
+	vf.entries += uint32(len(vm.toc) / 32)
 	if len(vm.values) < 1 {
 		vf.vs.freeableVMChans[vf.freeableVMChanIndex] <- vm
 		vf.freeableVMChanIndex++
@@ -152,7 +203,7 @@ func (vf *valuesFile) write(vm *valuesMem) {
 	left := len(vm.values)
 	for left > 0 {
 		n := copy(vf.buf.buf[vf.buf.offset:vf.vs.checksumInterval], vm.values[len(vm.values)-left:])
-		atomic.AddUint32(&vf.atOffset, uint32(n))
+		atomic.AddUint64(&vf.atOffset, uint64(n))
 		vf.buf.offset += uint32(n)
 		if vf.buf.offset >= vf.vs.checksumInterval {
 			s := vf.buf.seq
@@ -162,7 +213,10 @@ func (vf *valuesFile) write(vm *valuesMem) {
 		}
 		left -= n
 	}
-	if vf.buf.offset == 0 {
+	// With strictSync, the vm has to wait for the next write even if its
+	// values ended exactly on a buffer boundary, since that buffer may not
+	// have been written and synced yet.
+	if vf.buf.offset == 0 && !vf.vs.strictSync {
 		vf.vs.freeableVMChans[vf.freeableVMChanIndex] <- vm
 		vf.freeableVMChanIndex++
 		if vf.freeableVMChanIndex >= len(vf.vs.freeableVMChans) {
@@ -173,6 +227,44 @@ func (vf *valuesFile) write(vm *valuesMem) {
 	}
 }
 
+// writeFrom writes a single value of length bytes read from r, reading
+// straight into the checksum blocks rather than through a valuesMem; used by
+// WriteStream for a values file of its own. If the file is framed, the value
+// is given a leading _COMPRESSION_NONE byte, so it is stored a byte longer.
+// Any error from r is returned, with io.ErrUnexpectedEOF if r ends early; the
+// file should then be discarded.
+func (vf *valuesFile) writeFrom(r io.Reader, length uint32) error {
+	left := length
+	if vf.framed && length > 0 {
+		r = io.MultiReader(bytes.NewReader([]byte{_COMPRESSION_NONE}), r)
+		left++
+	}
+	for left > 0 {
+		b := vf.buf.buf[vf.buf.offset:vf.vs.checksumInterval]
+		if uint32(len(b)) > left {
+			b = b[:left]
+		}
+		n, err := io.ReadFull(r, b)
+		atomic.AddUint64(&vf.atOffset, uint64(n))
+		vf.buf.offset += uint32(n)
+		if vf.buf.offset >= vf.vs.checksumInterval {
+			s := vf.buf.seq
+			vf.checksumChan <- vf.buf
+			vf.buf = <-vf.freeChan
+			vf.buf.seq = s + 1
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		left -= uint32(n)
+	}
+	vf.entries++
+	return nil
+}
+
 func (vf *valuesFile) close() {
 	close(vf.checksumChan)
 	for i := 0; i < cap(vf.checksumChan); i++ {
@@ -180,8 +272,11 @@ func (vf *valuesFile) close() {
 	}
 	vf.writeChan <- nil
 	<-vf.doneChan
+	// The trailer is the entry count, the final offset, and "TERM"; it is
+	// covered by the checksum of the block(s) it ends up in.
 	term := make([]byte, 16)
-	binary.BigEndian.PutUint64(term[4:], uint64(atomic.LoadUint32(&vf.atOffset)))
+	binary.BigEndian.PutUint32(term, vf.entries)
+	binary.BigEndian.PutUint64(term[4:], atomic.LoadUint64(&vf.atOffset))
 	copy(term[12:], "TERM")
 	left := len(term)
 	for left > 0 {
@@ -194,6 +289,9 @@ func (vf *valuesFile) close() {
 		vf.buf.offset = 0
 		left -= n
 	}
+	if vf.vs.strictSync || vf.vs.syncOnClose() {
+		vf.fsync()
+	}
 	if err := vf.writerFP.Close(); err != nil {
 		panic(err)
 	}
@@ -212,6 +310,164 @@ func (vf *valuesFile) close() {
 	vf.buf = nil
 }
 
+// sync ensures, with strictSync, that what has been written so far is on disk
+// before the TOC entries for it can be written; the vms are only released to
+// the memClearers, which create the TOC entries, after calling this.
+func (vf *valuesFile) sync() {
+	if vf.vs.strictSync {
+		vf.fsync()
+	}
+}
+
+// fsync syncs what has been written so far to disk.
+func (vf *valuesFile) fsync() {
+	if s, ok := vf.writerFP.(interface {
+		Sync() error
+	}); ok {
+		if err := s.Sync(); err != nil {
+			panic(err)
+		}
+		atomic.AddInt32(&vf.vs.valuesFileSyncs, 1)
+	}
+}
+
+// check verifies the values file's trailer, returning the entry count it
+// records and how far into the file, in offset terms, its contents can be
+// trusted. A file without a valid trailer, such as one being written when the
+// process died, is dirty; only its leading run of blocks with good checksums
+// is trusted. Older files record an entry count of 0.
+func (vf *valuesFile) check(openReadSeeker func(name string) (io.ReadSeeker, error)) (entries uint32, trusted uint64, dirty bool) {
+	rs, err := openReadSeeker(vf.vs.valuesName(vf.bts))
+	if err != nil {
+		return 0, 0, true
+	}
+	if c, ok := rs.(io.Closer); ok {
+		defer c.Close()
+	}
+	fp, _, err := vf.vs.decryptReadSeeker(rs)
+	if err != nil {
+		return 0, 0, true
+	}
+	size, err := fp.Seek(0, 2)
+	if err != nil {
+		return 0, 0, true
+	}
+	interval := int64(vf.checksumInterval)
+	blockSize := interval + 4
+	buf := make([]byte, blockSize)
+	h := vf.newHash()
+	// block returns the verified contents of the given block, or nil.
+	block := func(i int64) []byte {
+		n := blockSize
+		if (i+1)*blockSize > size {
+			n = size - i*blockSize
+		}
+		if n <= 4 {
+			return nil
+		}
+		if _, err := fp.Seek(i*blockSize, 0); err != nil {
+			return nil
+		}
+		if _, err := io.ReadFull(fp, buf[:n]); err != nil {
+			return nil
+		}
+		n -= 4
+		if checksum32(h, buf[:n]) != binary.BigEndian.Uint32(buf[n:]) {
+			return nil
+		}
+		return buf[:n]
+	}
+	length := size / blockSize * interval
+	if rem := size % blockSize; rem > 4 {
+		length += rem - 4
+	} else if rem > 0 {
+		length = 0
+	}
+	if length >= 48 {
+		term := make([]byte, 0, 16)
+		for i := (length - 16) / interval; i <= (length-1)/interval; i++ {
+			b := block(i)
+			if b == nil {
+				break
+			}
+			start := length - 16 - i*interval
+			if start < 0 {
+				start = 0
+			}
+			term = append(term, b[start:]...)
+		}
+		if len(term) == 16 && string(term[12:]) == "TERM" && binary.BigEndian.Uint64(term[4:]) == uint64(length-16) {
+			return binary.BigEndian.Uint32(term), uint64(length - 16), false
+		}
+	}
+	for i := int64(0); ; i++ {
+		b := block(i)
+		if b == nil {
+			break
+		}
+		trusted += uint64(len(b))
+		if int64(len(b)) < interval {
+			break
+		}
+	}
+	return 0, trusted, true
+}
+
+// The value locations kept in the locmap have 32 bit offsets, so each 4GiB
+// segment of a values file past the first gets its own value loc block ID;
+// the first segment uses the values file's own ID.
+type valuesFileSegment struct {
+	vf   *valuesFile
+	base uint64
+}
+
+func (vfs *valuesFileSegment) timestampnano() int64 {
+	return vfs.vf.bts
+}
+
+func (vfs *valuesFileSegment) read(keyA uint64, keyB uint64, timestampbits uint64, offset uint32, length uint32, value []byte) (uint64, []byte, error) {
+	return vfs.vf.readAt(keyA, keyB, timestampbits, vfs.base+uint64(offset), length, value)
+}
+
+// location returns the value loc block ID and 32 bit offset to use in the
+// locmap for the given offset within the values file.
+func (vf *valuesFile) location(offset uint64) (uint32, uint32) {
+	segment := int(offset >> 32)
+	if segment == 0 {
+		return vf.id, uint32(offset)
+	}
+	vf.segmentLock.Lock()
+	for len(vf.segmentIDs) < segment {
+		vf.segmentIDs = append(vf.segmentIDs, vf.vs.addValueLocBlock(&valuesFileSegment{vf: vf, base: uint64(len(vf.segmentIDs)+1) << 32}))
+	}
+	id := vf.segmentIDs[segment-1]
+	vf.segmentLock.Unlock()
+	return id, uint32(offset)
+}
+
+// valuesFileOffset returns the values file and the offset within it for a
+// value loc block ID and offset from the locmap; the values file will be nil
+// if the block isn't a values file, such as for a valuesMem.
+func (vs *DefaultValueStore) valuesFileOffset(blockID uint32, offset uint32) (*valuesFile, uint64) {
+	switch b := vs.valueLocBlock(blockID).(type) {
+	case *valuesFile:
+		return b, uint64(offset)
+	case *valuesFileSegment:
+		return b.vf, b.base + uint64(offset)
+	}
+	return nil, uint64(offset)
+}
+
+// inValuesFile returns true if blockID is the values file given by
+// valuesFileID or one of its segments.
+func (vs *DefaultValueStore) inValuesFile(blockID uint32, valuesFileID uint32) bool {
+	if blockID == valuesFileID {
+		return true
+	}
+	vf, _ := vs.valuesFileOffset(blockID, 0)
+	return vf != nil && vf.id == valuesFileID
+}
+
 func (vf *valuesFile) checksummer() {
 	for {
 		buf := <-vf.checksumChan
@@ -246,6 +502,7 @@ func (vf *valuesFile) writer() {
 			panic(err)
 		}
 		if len(buf.vms) > 0 {
+			vf.sync()
 			for _, vm := range buf.vms {
 				vf.vs.freeableVMChans[vf.freeableVMChanIndex] <- vm
 				vf.freeableVMChanIndex++
@@ -254,6 +511,8 @@ func (vf *valuesFile) writer() {
 				}
 			}
 			buf.vms = buf.vms[:0]
+		} else {
+			vf.vs.failpoint(_FAILPOINT_VALUES_PAGE)
 		}
 		buf.offset = 0
 		vf.freeChan <- buf
@@ -57,14 +57,14 @@ func (f *memFile) Close() error {
 }
 
 func TestValuesFileReading(t *testing.T) {
-	vs := New(nil)
+	vs := newTestStore(t, nil)
 	buf := &memBuf{buf: []byte("0123456789abcdef")}
 	openReadSeeker := func(name string) (io.ReadSeeker, error) {
 		return &memFile{buf: buf}, nil
 	}
-	vf := newValuesFile(vs, 12345, openReadSeeker)
-	if vf == nil {
-		t.Fatal("")
+	vf, err := newValuesFile(vs, 12345, openReadSeeker)
+	if err != nil {
+		t.Fatal(err)
 	}
 	tsn := vf.timestampnano()
 	if tsn != 12345 {
@@ -138,7 +138,7 @@ func TestValuesFileReading(t *testing.T) {
 }
 
 func TestValuesFileWritingEmpty(t *testing.T) {
-	vs := New(nil)
+	vs := newTestStore(t, nil)
 	buf := &memBuf{}
 	createWriteCloser := func(name string) (io.WriteCloser, error) {
 		return &memFile{buf: buf}, nil
@@ -176,7 +176,9 @@ func TestValuesFileWritingEmpty(t *testing.T) {
 }
 
 func TestValuesFileWritingEmpty2(t *testing.T) {
-	vs := New(nil)
+	vs := newTestStore(t, nil)
+	// The store's channels are put back for Close.
+	defer func(freeableVMChans []chan *valuesMem) { vs.freeableVMChans = freeableVMChans }(vs.freeableVMChans)
 	vs.freeableVMChans = make([]chan *valuesMem, 1)
 	vs.freeableVMChans[0] = make(chan *valuesMem, 1)
 	buf := &memBuf{}
@@ -222,7 +224,11 @@ func TestValuesFileWritingEmpty2(t *testing.T) {
 }
 
 func TestValuesFileWriting(t *testing.T) {
-	vs := New(nil)
+	vs := newTestStore(t, nil)
+	// The vms written here are not the store's own, so they are kept from
+	// its memClearers; the store's channels are put back for Close.
+	defer func(freeableVMChans []chan *valuesMem) { vs.freeableVMChans = freeableVMChans }(vs.freeableVMChans)
+	vs.freeableVMChans = []chan *valuesMem{make(chan *valuesMem, 16)}
 	buf := &memBuf{}
 	createWriteCloser := func(name string) (io.WriteCloser, error) {
 		return &memFile{buf: buf}, nil
@@ -267,7 +273,11 @@ func TestValuesFileWriting(t *testing.T) {
 }
 
 func TestValuesFileWritingMore(t *testing.T) {
-	vs := New(nil)
+	vs := newTestStore(t, nil)
+	// The vms written here are not the store's own, so they are kept from
+	// its memClearers; the store's channels are put back for Close.
+	defer func(freeableVMChans []chan *valuesMem) { vs.freeableVMChans = freeableVMChans }(vs.freeableVMChans)
+	vs.freeableVMChans = []chan *valuesMem{make(chan *valuesMem, 16)}
 	buf := &memBuf{}
 	createWriteCloser := func(name string) (io.WriteCloser, error) {
 		return &memFile{buf: buf}, nil
@@ -309,7 +319,9 @@ func TestValuesFileWritingMore(t *testing.T) {
 }
 
 func TestValuesFileWritingMultiple(t *testing.T) {
-	vs := New(nil)
+	vs := newTestStore(t, nil)
+	// The store's channels are put back for Close.
+	defer func(freeableVMChans []chan *valuesMem) { vs.freeableVMChans = freeableVMChans }(vs.freeableVMChans)
 	vs.freeableVMChans = make([]chan *valuesMem, 1)
 	vs.freeableVMChans[0] = make(chan *valuesMem, 2)
 	buf := &memBuf{}
@@ -361,3 +373,124 @@ func TestValuesFileWritingMultiple(t *testing.T) {
 		t.Fatal(binary.BigEndian.Uint32(buf.buf[bl-4:]))
 	}
 }
+
+type syncMemFile struct {
+	memFile
+	syncs   int
+	written int
+	// syncedAt is how much had been written at the last sync.
+	syncedAt int
+}
+
+func (f *syncMemFile) Write(p []byte) (int, error) {
+	f.written += len(p)
+	return f.memFile.Write(p)
+}
+
+func (f *syncMemFile) Sync() error {
+	f.syncs++
+	f.syncedAt = f.written
+	return nil
+}
+
+func TestValuesFileWritingStrictSync(t *testing.T) {
+	vs := newTestStore(t, &Config{StrictSync: true})
+	// The vms written here are not the store's own, so they are kept from
+	// its memClearers; the store's channels are put back for Close.
+	defer func(freeableVMChans []chan *valuesMem) { vs.freeableVMChans = freeableVMChans }(vs.freeableVMChans)
+	vs.freeableVMChans = []chan *valuesMem{make(chan *valuesMem, 16)}
+	buf := &memBuf{}
+	fp := &syncMemFile{memFile: memFile{buf: buf}}
+	createWriteCloser := func(name string) (io.WriteCloser, error) {
+		return fp, nil
+	}
+	openReadSeeker := func(name string) (io.ReadSeeker, error) {
+		return &memFile{buf: buf}, nil
+	}
+	vf := createValuesFile(vs, createWriteCloser, openReadSeeker)
+	// Exactly fills the first buffer, so without StrictSync the vm would be
+	// released before the buffer was written.
+	vm := &valuesMem{values: make([]byte, vs.checksumInterval-32)}
+	vf.write(vm)
+	if len(vf.buf.vms) != 1 || vf.buf.vms[0] != vm {
+		t.Fatal("vm released early")
+	}
+	vf.close()
+	if fp.syncs != 1 || fp.syncedAt != fp.written {
+		t.Fatal(fp.syncs, fp.syncedAt, fp.written)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.ValuesFileSyncs != 1 {
+		t.Fatal(stats.ValuesFileSyncs)
+	}
+}
+
+func TestValuesFileCheck(t *testing.T) {
+	vs := newTestStore(t, &Config{ChecksumInterval: 256})
+	// The vms written here are not the store's own, so they are kept from
+	// its memClearers; the store's channels are put back for Close.
+	defer func(freeableVMChans []chan *valuesMem) { vs.freeableVMChans = freeableVMChans }(vs.freeableVMChans)
+	vs.freeableVMChans = []chan *valuesMem{make(chan *valuesMem, 16)}
+	buf := &memBuf{}
+	createWriteCloser := func(name string) (io.WriteCloser, error) {
+		return &memFile{buf: buf}, nil
+	}
+	openReadSeeker := func(name string) (io.ReadSeeker, error) {
+		return &memFile{buf: buf}, nil
+	}
+	vf := createValuesFile(vs, createWriteCloser, openReadSeeker)
+	vf.write(&valuesMem{toc: make([]byte, 64), values: make([]byte, 1000)})
+	vf.close()
+	entries, trusted, dirty := vf.check(openReadSeeker)
+	if entries != 2 || trusted != 1032 || dirty {
+		t.Fatal(entries, trusted, dirty)
+	}
+	// Cutting into the last block loses the trailer and that block.
+	buf.buf = buf.buf[:len(buf.buf)-10]
+	entries, trusted, dirty = vf.check(openReadSeeker)
+	if entries != 0 || trusted != 1024 || !dirty {
+		t.Fatal(entries, trusted, dirty)
+	}
+	// So does a bad checksum in the middle, along with everything after it.
+	buf.buf[300]++
+	if _, trusted, dirty = vf.check(openReadSeeker); trusted != 256 || !dirty {
+		t.Fatal(trusted, dirty)
+	}
+}
+
+func TestValuesFileLocation(t *testing.T) {
+	vs := newTestStore(t, nil)
+	buf := &memBuf{}
+	createWriteCloser := func(name string) (io.WriteCloser, error) {
+		return &memFile{buf: buf}, nil
+	}
+	openReadSeeker := func(name string) (io.ReadSeeker, error) {
+		return &memFile{buf: buf}, nil
+	}
+	vf := createValuesFile(vs, createWriteCloser, openReadSeeker)
+	if id, offset := vf.location(123); id != vf.id || offset != 123 {
+		t.Fatal(id, offset)
+	}
+	id2, offset := vf.location(2<<32 + 123)
+	if id2 == vf.id || offset != 123 {
+		t.Fatal(id2, offset)
+	}
+	id1, offset := vf.location(1<<32 + 456)
+	if id1 == vf.id || id1 == id2 || offset != 456 {
+		t.Fatal(id1, offset)
+	}
+	if id, _ := vf.location(2<<32 + 789); id != id2 {
+		t.Fatal(id, id2)
+	}
+	if f, fileOffset := vs.valuesFileOffset(id2, 123); f != vf || fileOffset != 2<<32+123 {
+		t.Fatal(f, fileOffset)
+	}
+	if !vs.inValuesFile(id1, vf.id) || vs.inValuesFile(vf.id, id1) {
+		t.Fatal("")
+	}
+	if !vs.recoveryNewerLocation(id1, 0, vf.id, 1000) || vs.recoveryNewerLocation(id1, 0, id2, 0) {
+		t.Fatal("")
+	}
+	if vs.valueLocBlock(id2).timestampnano() != vf.timestampnano() {
+		t.Fatal(vs.valueLocBlock(id2).timestampnano(), vf.timestampnano())
+	}
+}
@@ -5,11 +5,16 @@ import (
 	"sync"
 )
 
+// _VALUES_MEM_TOC_ENTRY_SIZE is the size of each entry in a valuesMem's toc,
+// keyA:8 keyB:8 timestampbits:8 offset:4 length:4, with the offset within its
+// values; the memClearers turn these into TOC file entries.
+const _VALUES_MEM_TOC_ENTRY_SIZE = 32
+
 type valuesMem struct {
 	vs          *DefaultValueStore
 	id          uint32
 	vfID        uint32
-	vfOffset    uint32
+	vfOffset    uint64
 	toc         []byte
 	values      []byte
 	discardLock sync.RWMutex
@@ -30,7 +35,23 @@ func (vm *valuesMem) read(keyA uint64, keyB uint64, timestampbits uint64, offset
 		vm.discardLock.RUnlock()
 		return vm.vs.valueLocBlock(id).read(keyA, keyB, timestampbits, offset, length, value)
 	}
+	if vm.vs.compressionState.framed {
+		var err error
+		value, err = vm.vs.decompress(value, vm.values[offset:offset+length])
+		vm.discardLock.RUnlock()
+		return timestampbits, value, err
+	}
 	value = append(value, vm.values[offset:offset+length]...)
 	vm.discardLock.RUnlock()
 	return timestampbits, value, nil
 }
+
+// readAt reads the value stored at offset without consulting the location
+// map, as for an older version kept with Config.RetainVersions; the caller
+// must hold discardLock.
+func (vm *valuesMem) readAt(offset uint32, length uint32, value []byte) ([]byte, error) {
+	if vm.vs.compressionState.framed {
+		return vm.vs.decompress(value, vm.values[offset:offset+length])
+	}
+	return append(value, vm.values[offset:offset+length]...), nil
+}
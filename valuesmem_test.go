@@ -6,9 +6,11 @@ import (
 )
 
 func TestValuesMemRead(t *testing.T) {
-	vs := New(nil)
+	vs := newTestStore(t, nil)
 	vm1 := &valuesMem{id: 1, vs: vs, values: []byte("0123456789abcdef")}
 	vm2 := &valuesMem{id: 2, vs: vs, values: []byte("fedcba9876543210")}
+	// Put back for Close.
+	defer func(valueLocBlocks []valueLocBlock) { vs.valueLocBlocks = valueLocBlocks }(vs.valueLocBlocks)
 	vs.valueLocBlocks = []valueLocBlock{nil, vm1, vm2}
 	tsn := vm1.timestampnano()
 	if tsn != math.MaxInt64 {
@@ -56,6 +56,7 @@ package valuestore
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -63,8 +64,6 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"path"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -74,6 +73,7 @@ import (
 	"github.com/gholt/ring"
 	"github.com/gholt/valuelocmap"
 	"github.com/spaolacci/murmur3"
+	"gopkg.in/gholt/brimtime.v1"
 	"gopkg.in/gholt/brimutil.v1"
 )
 
@@ -90,6 +90,13 @@ const (
 	// for local removal will be retained in memory until the local removal
 	// marker is written to disk.
 	_TSB_LOCAL_REMOVAL = 0x02
+	// _TSB_BATCH_BEGIN and _TSB_BATCH_COMMIT are not bits but the whole
+	// timestampbits of the TOC entries marking the start and end of a batch
+	// committed with WriteBatch.Commit; any other entry has a timestamp above
+	// zero. The markers' keyB is how many entries are between them; recovery
+	// only applies those entries if they are all there, between both markers.
+	_TSB_BATCH_BEGIN  = 0x04
+	_TSB_BATCH_COMMIT = 0x08
 )
 
 const (
@@ -97,6 +104,9 @@ const (
 	TIMESTAMPMICRO_MAX = int64(uint64(math.MaxUint64) >> _TSB_UTIL_BITS)
 )
 
+// _KEY_LOCKS is how many keyLocks the keys are spread over.
+const _KEY_LOCKS = 64
+
 // ValueStore is an interface for a disk-backed data structure that stores
 // []byte values referenced by 128 bit keys with options for replication.
 //
@@ -104,8 +114,32 @@ const (
 type ValueStore interface {
 	Lookup(keyA uint64, keyB uint64) (int64, uint32, error)
 	Read(keyA uint64, keyB uint64, value []byte) (int64, []byte, error)
+	ReadVersion(keyA uint64, keyB uint64, timestamp int64, value []byte) (int64, []byte, error)
+	ListVersions(keyA uint64, keyB uint64) []Version
+	ReadAsOf(keyA uint64, keyB uint64, timestamp int64, value []byte) (int64, []byte, error)
+	ReadQuorum(keyA uint64, keyB uint64, value []byte) (int64, []byte, error)
+	ReadErasure(keyA uint64, keyB uint64, value []byte) (int64, []byte, error)
+	ReadMulti(entries []ReadMultiEntry)
+	Watch(keyA uint64, keyB uint64, buffer int) *Watcher
+	WatchRange(start uint64, stop uint64, buffer int) *Watcher
+	Sample(start uint64, stop uint64, n int) []KeySample
+	Scan(start uint64, stop uint64, withValues bool, callback func(keyA uint64, keyB uint64, timestampmicro int64, value []byte) bool) error
+	ScanKeys(start uint64, stop uint64, callback func(keyA uint64, keyB uint64, timestampmicro int64, length uint32) bool)
+	Snapshot() *Snapshot
+	SnapshotAt(timestampmicro int64) *Snapshot
+	BackupTo(w io.Writer) error
+	ExportSince(timestampmicro int64, w io.Writer) error
+	Namespace(id uint8) *Namespace
 	Write(keyA uint64, keyB uint64, timestamp int64, value []byte) (int64, error)
+	WriteDurable(keyA uint64, keyB uint64, timestamp int64, value []byte, sync bool) (int64, error)
+	WriteQuorum(keyA uint64, keyB uint64, timestamp int64, value []byte) (int64, error)
+	WriteErasure(keyA uint64, keyB uint64, timestamp int64, value []byte) (int64, error)
+	WriteStream(keyA uint64, keyB uint64, timestamp int64, length uint32, r io.Reader) (int64, error)
 	Delete(keyA uint64, keyB uint64, timestamp int64) (int64, error)
+	DeleteIf(keyA uint64, keyB uint64, timestamp int64, expectedTimestamp int64) (int64, error)
+	DeleteErasure(keyA uint64, keyB uint64, timestamp int64) (int64, error)
+	NewWriteBatch() *WriteBatch
+	WriteBatchCap() int
 	EnableAll()
 	DisableAll()
 	DisableAllBackground()
@@ -115,63 +149,238 @@ type ValueStore interface {
 	EnableCompaction()
 	DisableCompaction()
 	CompactionPass()
+	MigrationPass()
+	CompactFile(id int64) error
+	ListFiles() []ValuesFileStats
 	EnableOutPullReplication()
 	DisableOutPullReplication()
 	OutPullReplicationPass()
+	OutPullReplicationAudit(wait time.Duration) *PullReplicationAudit
 	EnableOutPushReplication()
 	DisableOutPushReplication()
 	OutPushReplicationPass()
+	RingChanged()
 	EnableWrites()
 	DisableWrites()
 	Flush()
 	Stats(debug bool) fmt.Stringer
 	ValueCap() uint32
+	Config() *Config
+	SetCompactionThreshold(threshold float64)
+	SetCompactionMaxBytesPerSec(bytesPerSec int)
+	SetReplicationIgnoreRecent(seconds int)
+	SetTombstoneAge(seconds int)
+	SetBackgroundIntervals(compaction int, tombstoneDiscard int, outPullReplication int, outPushReplication int)
+	Close()
 }
 
 var ErrNotFound error = errors.New("not found")
 var ErrDisabled error = errors.New("disabled")
 
+// ErrBatchTooLarge is returned by WriteBatch.Commit when the batch has more
+// entries than WriteBatchCap or more value bytes than Config.PageSize.
+var ErrBatchTooLarge error = errors.New("batch too large")
+
+// ErrBatchNotSynced is returned by WriteBatch.Commit, with the "always"
+// Config.SyncPolicy, when the batch was applied but the store was closed
+// before the flush that makes it durable; it is then recovered whole or not
+// at all, as with the other policies.
+var ErrBatchNotSynced error = errors.New("batch applied but not synced")
+
+// ErrClosed is returned by writes made once Close has stopped the writers.
+var ErrClosed error = errors.New("closed")
+
+// ErrNamespaceKeyB is returned by Namespace methods given a keyB larger than
+// NAMESPACE_KEYB_MAX.
+var ErrNamespaceKeyB error = errors.New("keyB too large for a namespace")
+
+// ErrQuorum is returned by ReadQuorum and WriteQuorum when fewer than
+// Config.Quorum replicas answer in time.
+var ErrQuorum error = errors.New("quorum not reached")
+
+// ErrErasureShards is returned by ReadErasure when too few of a value's
+// shards can be read to rebuild it.
+var ErrErasureShards error = errors.New("not enough erasure shards")
+
+// ErrNewerVersion is returned by Delete when a newer version of the key is
+// already stored, and by DeleteIf when the stored version isn't the one
+// expected.
+var ErrNewerVersion error = errors.New("newer version stored")
+
+// ErrFileInUse is returned by CompactFile for a values file still being
+// written or pinned by a Snapshot.
+var ErrFileInUse error = errors.New("file in use")
+
+// ErrRestoreNotEmpty is returned by RestoreFrom when Path or PathTOC already
+// has values or TOC files.
+var ErrRestoreNotEmpty error = errors.New("restore path not empty")
+
+// ErrDiskFull is returned by writes while the free space on Config.Path is
+// below Config.DiskFreeMinimum.
+var ErrDiskFull error = errors.New("disk full")
+
+// ErrLocked is what a *LockError reports itself as to errors.Is.
+var ErrLocked error = errors.New("unable to lock directory")
+
+// LockError is returned by NewWithContext when Path or PathTOC couldn't be
+// locked, because another ValueStore, in this process or another, has it
+// open.
+type LockError struct {
+	// Path is the lock file that couldn't be locked.
+	Path string
+	Err  error
+}
+
+func (e *LockError) Error() string {
+	return ErrLocked.Error() + ": " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LockError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// ErrRecovery is what a *RecoveryError reports itself as to errors.Is.
+var ErrRecovery error = errors.New("recovery failed")
+
+// RecoveryError is returned by NewWithContext when the existing data could
+// not be loaded, such as when the TOC directory can't be read or a TOC file's
+// values file can't be opened. A missing values file is not an error with
+// Config.RemoteReadFallback; see Stats.RecoveryMissingValuesFiles.
+type RecoveryError struct {
+	// Path is the file or directory the problem is with.
+	Path string
+	Err  error
+}
+
+func (e *RecoveryError) Error() string {
+	return ErrRecovery.Error() + ": " + e.Err.Error()
+}
+
+func (e *RecoveryError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RecoveryError) Is(target error) bool {
+	return target == ErrRecovery
+}
+
+// TimestampError is returned by Write and Delete when the timestamp given is
+// further from the local clock than Config.MaxFutureTimestamp or
+// Config.MaxPastTimestamp allow.
+type TimestampError struct {
+	Timestampmicro int64
+	Nowmicro       int64
+}
+
+func (e *TimestampError) Error() string {
+	if e.Timestampmicro > e.Nowmicro {
+		return fmt.Sprintf("timestamp %d is %s in the future", e.Timestampmicro, time.Duration(e.Timestampmicro-e.Nowmicro)*time.Microsecond)
+	}
+	return fmt.Sprintf("timestamp %d is %s in the past", e.Timestampmicro, time.Duration(e.Nowmicro-e.Timestampmicro)*time.Microsecond)
+}
+
 // DefaultValueStore instances are created with New.
 type DefaultValueStore struct {
-	logCritical             LogFunc
-	logError                LogFunc
-	logWarning              LogFunc
-	logInfo                 LogFunc
-	logDebug                LogFunc
-	randMutex               sync.Mutex
-	rand                    *rand.Rand
-	freeableVMChans         []chan *valuesMem
-	freeVMChan              chan *valuesMem
-	freeVWRChans            []chan *valueWriteReq
-	pendingVWRChans         []chan *valueWriteReq
-	vfVMChan                chan *valuesMem
-	freeTOCBlockChan        chan []byte
-	pendingTOCBlockChan     chan []byte
-	activeTOCA              uint64
-	activeTOCB              uint64
-	flushedChan             chan struct{}
+	// These 64 bit counters are first so atomic access to them is aligned
+	// even on 32 bit platforms; readerTuneState likewise starts with its own.
+	logicalWriteBytes      int64
+	physicalWriteBytes     int64
+	remoteReplicationBytes int64
+	pathsRebalanceBytes    int64
+	pathsRebalancePending  int64
+	readerTuneState        readerTuneState
+	configLock             sync.Mutex
+	config                 Config
+	logCritical            LogFunc
+	logError               LogFunc
+	logWarning             LogFunc
+	logInfo                LogFunc
+	logDebug               LogFunc
+	randMutex              sync.Mutex
+	rand                   *rand.Rand
+	freeableVMChans        []chan *valuesMem
+	freeVMChan             chan *valuesMem
+	freeVWRChans           []chan *valueWriteReq
+	pendingVWRChans        []chan *valueWriteReq
+	vfVMChan               chan *valuesMem
+	freeTOCBlockChan       chan []byte
+	pendingTOCBlockChan    chan []byte
+	activeTOCA             uint64
+	activeTOCB             uint64
+	flushedChan            chan struct{}
+	// keyLocks, by keyA, are held by the memWriters around a conditional
+	// write's check and write, and around each write of a batch; see
+	// writeIf.
+	keyLocks [_KEY_LOCKS]sync.Mutex
+	// flushLock keeps concurrent Flush calls, such as the syncer's, from
+	// interleaving their flush markers.
+	flushLock               sync.Mutex
 	valueLocBlocks          []valueLocBlock
 	valueLocBlockIDer       uint64
 	path                    string
 	pathtoc                 string
+	fileBackend             FileBackend
 	vlm                     valuelocmap.ValueLocMap
 	workers                 int
 	recoveryBatchSize       int
+	recoveryDropPageCache   bool
 	valueCap                uint32
 	pageSize                uint32
 	minValueAlloc           int
 	writePagesPerWorker     int
-	valuesFileCap           uint32
+	valuesFileCap           uint64
+	tocEntrySize            int
 	valuesFileReaders       int
 	checksumInterval        uint32
 	msgRing                 ring.MsgRing
 	tombstoneDiscardState   tombstoneDiscardState
 	replicationIgnoreRecent uint64
+	maxFutureMicro          int64
+	maxPastMicro            int64
+	monotonicWrites         bool
+	strictSync              bool
+	directIO                bool
 	pullReplicationState    pullReplicationState
 	pushReplicationState    pushReplicationState
+	pushBacklogState        pushBacklogState
+	remoteReplicationState  remoteReplicationState
+	replicationCodecState   replicationCodecState
+	msgAuthState            msgAuthState
+	remoteReadState         remoteReadState
+	quorumState             quorumState
+	erasureState            erasureState
+	readerPoolState         readerPoolState
+	readCacheState          readCacheState
+	versionsState           versionsState
+	recoveryProgressState   recoveryProgressState
+	manifestState           manifestState
+	dirLocks                []string
+	syncPolicyState         syncPolicyState
+	diskHealthState         diskHealthState
+	diskFreeState           diskFreeState
+	pathsState              pathsState
+	pathsLimitState         pathsLimitState
+	handoffState            handoffState
+	replicationLagState     replicationLagState
+	cpuBudgetState          cpuBudgetState
+	watchState              watchState
+	snapshotState           snapshotState
 	compactionState         compactionState
 	bulkSetState            bulkSetState
+	bulkSetPeersState       bulkSetPeersState
 	bulkSetAckState         bulkSetAckState
+	tombstoneAckState       tombstoneAckState
+	peerFlowState           peerFlowState
+	msgPoolState            msgPoolState
+	closeState              closeState
+	writeStreamState        writeStreamState
+	compressionState        compressionState
+	encryptionState         encryptionState
+	failpointState          failpointState
 
 	statsLock                    sync.Mutex
 	lookups                      int32
@@ -181,33 +390,108 @@ type DefaultValueStore struct {
 	writes                       int32
 	writeErrors                  int32
 	writesOverridden             int32
+	writesBumped                 int32
 	deletes                      int32
 	deleteErrors                 int32
 	deletesOverridden            int32
+	writeBatches                 int32
+	writeBatchErrors             int32
+	writeStreams                 int32
+	writeStreamErrors            int32
+	timestampRejections          int32
+	valuesFileSyncs              int32
+	recoveryDuplicates           int32
+	watchEventsDropped           int32
+	remoteReplicationValues      int32
+	remoteReplicationOverflows   int32
+	remoteReplicationThrottles   int32
+	remoteReads                  int32
+	remoteReadRepairs            int32
+	readRepairQueries            int32
+	readRepairs                  int32
+	quorumReads                  int32
+	quorumWrites                 int32
+	quorumFailures               int32
+	erasureReconstructions       int32
+	inQuorumRequests             int32
+	inQuorumDrops                int32
+	inQuorumInvalids             int32
+	inRemoteReads                int32
+	inRemoteReadDrops            int32
+	inRemoteReadInvalids         int32
+	readerOpens                  int32
+	readerEvictions              int32
+	readerConcurrencyGrows       int32
+	readerConcurrencyShrinks     int32
+	readCacheHits                int32
+	readCacheMisses              int32
+	syncFlushes                  int32
+	diskFulls                    int32
+	diskFullRejections           int32
+	diskFullRetries              int32
+	pathsRebalanceFiles          int32
+	backgroundPauses             int32
+	recoveryUntrusted            int32
+	recoveryTornBatches          int32
+	recoveryMissingValuesFiles   int32
+	recoveryStrayFiles           int32
+	recoveryCompactionRollbacks  int32
+	dirtyValuesFiles             int32
+	recoveryDuration             time.Duration
 	outBulkSets                  int32
 	outBulkSetValues             int32
+	outBulkSetContinuations      int32
 	outBulkSetPushes             int32
 	outBulkSetPushValues         int32
+	outBulkSetHandoffs           int32
+	outBulkSetHandoffValues      int32
+	handoffPasses                int32
+	outBulkSetTombstones         int32
+	outBulkSetBackfills          int32
+	outPushBacklogSaves          int32
 	inBulkSets                   int32
 	inBulkSetDrops               int32
+	inBulkSetConflicts           int32
+	inBulkSetPriorities          int32
+	inBulkSetPeerDrops           int32
 	inBulkSetInvalids            int32
+	inBulkSetCorrupts            int32
 	inBulkSetWrites              int32
 	inBulkSetWriteErrors         int32
 	inBulkSetWritesOverridden    int32
+	inBulkSetCoalesced           int32
 	outBulkSetAcks               int32
 	inBulkSetAcks                int32
 	inBulkSetAckDrops            int32
 	inBulkSetAckInvalids         int32
+	inBulkSetAckCorrupts         int32
 	inBulkSetAckWrites           int32
 	inBulkSetAckWriteErrors      int32
 	inBulkSetAckWritesOverridden int32
+	inBulkSetAckedMsgs           int32
+	inBulkSetAckFailures         int32
 	outPullReplications          int32
 	inPullReplications           int32
 	inPullReplicationDrops       int32
 	inPullReplicationInvalids    int32
+	inPullReplicationCorrupts    int32
+	outPullReplicationMerkles    int32
+	inPullReplicationMerkles     int32
+	pullReplicationMerkleDiffs   int32
+	outPeerMsgSkips              int32
+	outPeerMsgAckTimeouts        int32
+	inMsgAuthFailures            int32
 	expiredDeletions             int32
+	expiredDeletionsUnacked      int32
 	compactions                  int32
 	smallFileCompactions         int32
+	recompressionCompactions     int32
+	migrationCompactions         int32
+	mergedCompactions            int32
+	compactionFiltered           int32
+	fileCompactions              int32
+	compactionThrottles          int32
+	reencryptionCompactions      int32
 }
 
 type valueWriteReq struct {
@@ -216,6 +500,83 @@ type valueWriteReq struct {
 	timestampbits uint64
 	value         []byte
 	errChan       chan error
+	// batch, if not nil, holds multiple writes to be applied together,
+	// sharing pages and TOC runs, instead of the single write above.
+	batch []valueWriteBatchEntry
+	// whole indicates the batch is to be recovered all or nothing; see
+	// _TSB_BATCH_BEGIN.
+	whole bool
+	// streamed indicates the value has already been written to its own
+	// values file by WriteStream; only its location, blockID, offset, and
+	// length, is to be stored. The logicalLength is that of the value before
+	// any framing; see _COMPRESSION_NONE.
+	streamed      bool
+	blockID       uint32
+	offset        uint32
+	length        uint32
+	logicalLength uint32
+	// conditional indicates the write is only to be made if the
+	// timestampmicro stored for the key is expected; see writeIf.
+	conditional bool
+	expected    int64
+}
+
+// valueWriteBatchEntry is a single write within a batch given to writeBatch.
+// After writeBatch returns, ptimestampbits and err hold the same results
+// write would have returned for the entry.
+type valueWriteBatchEntry struct {
+	keyA           uint64
+	keyB           uint64
+	timestampbits  uint64
+	value          []byte
+	ptimestampbits uint64
+	err            error
+}
+
+// TOC files written while ValuesFileCap is at most 4GiB are v0, with 32 bit
+// value offsets; otherwise they are v1, with 64 bit value offsets. Each entry
+// is keyA:8 keyB:8 timestampbits:8 offset:4|8 length:4 and both versions are
+// always readable.
+const (
+	_TOC_HEADER_V0      = "VALUESTORETOC v0            "
+	_TOC_HEADER_V1      = "VALUESTORETOC v1            "
+	_TOC_ENTRY_SIZE_V0  = 32
+	_TOC_ENTRY_SIZE_V1  = 36
+	_TOC_ENTRY_SIZE_MAX = _TOC_ENTRY_SIZE_V1
+)
+
+// tocEntrySize returns the entry size for the TOC file with the given header,
+// or 0 if the header is not recognized.
+func tocEntrySize(head []byte) int {
+	switch string(head[:28]) {
+	case _TOC_HEADER_V0:
+		return _TOC_ENTRY_SIZE_V0
+	case _TOC_HEADER_V1:
+		return _TOC_ENTRY_SIZE_V1
+	}
+	return 0
+}
+
+// tocEntryLocation returns the values file offset and length recorded in the
+// TOC entry.
+func tocEntryLocation(entry []byte, entrySize int) (uint64, uint32) {
+	if entrySize == _TOC_ENTRY_SIZE_V1 {
+		return binary.BigEndian.Uint64(entry[24:]), binary.BigEndian.Uint32(entry[32:])
+	}
+	return uint64(binary.BigEndian.Uint32(entry[24:])), binary.BigEndian.Uint32(entry[28:])
+}
+
+func putTOCEntry(entry []byte, entrySize int, keyA uint64, keyB uint64, timestampbits uint64, offset uint64, length uint32) {
+	binary.BigEndian.PutUint64(entry, keyA)
+	binary.BigEndian.PutUint64(entry[8:], keyB)
+	binary.BigEndian.PutUint64(entry[16:], timestampbits)
+	if entrySize == _TOC_ENTRY_SIZE_V1 {
+		binary.BigEndian.PutUint64(entry[24:], offset)
+		binary.BigEndian.PutUint32(entry[32:], length)
+	} else {
+		binary.BigEndian.PutUint32(entry[24:], uint32(offset))
+		binary.BigEndian.PutUint32(entry[28:], length)
+	}
 }
 
 var enableValueWriteReq *valueWriteReq = &valueWriteReq{}
@@ -229,26 +590,62 @@ type valueLocBlock interface {
 }
 
 type backgroundNotification struct {
-	enable   bool
-	disable  bool
-	doneChan chan struct{}
+	enable  bool
+	disable bool
+	// audit indicates the pass should only gather information rather than
+	// make any changes; only used by pull replication currently.
+	audit bool
+	// migrate indicates the pass should also rewrite every file not in the
+	// format new files are written in; only used by compaction currently.
+	migrate bool
+	// compactFile, if not 0, names the one values file the pass should
+	// compact and err is set to how that went; only used by compaction
+	// currently.
+	compactFile int64
+	err         error
+	doneChan    chan struct{}
 }
 
 // New creates a DefaultValueStore for use in storing []byte values referenced
 // by 128 bit keys.
 //
 // Note that a lot of buffering, multiple cores, and background processes can
-// be in use and therefore DisableAll() and Flush() should be called prior to
-// the process exiting to ensure all processing is done and the buffers are
-// flushed.
+// be in use and therefore Close() should be called prior to the process
+// exiting to ensure all processing is done and the buffers are flushed.
+//
+// New panics on any error NewWithContext would return.
 func New(c *Config) *DefaultValueStore {
+	vs, err := NewWithContext(context.Background(), c)
+	if err != nil {
+		panic(err)
+	}
+	return vs
+}
+
+// NewWithContext is the same as New except that problems with the paths
+// (such as permissions) and with the recovery of existing data are returned
+// as errors rather than being panics. The Preflight checks are run first; a
+// problem they find is returned as a *PreflightError. Path and PathTOC are
+// then locked against other stores, with a *LockError returned should either
+// already be locked. A problem loading the existing data is returned
+// as a *RecoveryError. If the ctx is done before recovery completes, the
+// ctx.Err() will be returned. On error, no background processes will have
+// been started and any files opened are closed, with the locks released.
+func NewWithContext(ctx context.Context, c *Config) (*DefaultValueStore, error) {
 	cfg := resolveConfig(c)
+	for _, perr := range preflight(cfg) {
+		if perr.Check == PREFLIGHT_PATH || perr.Check == PREFLIGHT_ENCRYPTION_KEY || cfg.PreflightStrict {
+			return nil, perr
+		}
+		cfg.LogWarning("%s\n", perr)
+	}
 	vlm := cfg.ValueLocMap
 	if vlm == nil {
 		vlm = valuelocmap.New(nil)
 	}
 	vlm.SetInactiveMask(_TSB_INACTIVE)
 	vs := &DefaultValueStore{
+		config:                  *cfg,
 		logCritical:             cfg.LogCritical,
 		logError:                cfg.LogError,
 		logWarning:              cfg.LogWarning,
@@ -258,15 +655,22 @@ func New(c *Config) *DefaultValueStore {
 		valueLocBlocks:          make([]valueLocBlock, math.MaxUint16),
 		path:                    cfg.Path,
 		pathtoc:                 cfg.PathTOC,
+		fileBackend:             cfg.FileBackend,
 		vlm:                     vlm,
 		workers:                 cfg.Workers,
 		recoveryBatchSize:       cfg.RecoveryBatchSize,
+		recoveryDropPageCache:   cfg.RecoveryDropPageCache,
 		replicationIgnoreRecent: (uint64(cfg.ReplicationIgnoreRecent) * uint64(time.Second) / 1000) << _TSB_UTIL_BITS,
+		maxFutureMicro:          int64(cfg.MaxFutureTimestamp) * 1000000,
+		maxPastMicro:            int64(cfg.MaxPastTimestamp) * 1000000,
+		monotonicWrites:         cfg.MonotonicWrites,
+		strictSync:              cfg.StrictSync,
 		valueCap:                uint32(cfg.ValueCap),
 		pageSize:                uint32(cfg.PageSize),
 		minValueAlloc:           cfg.minValueAlloc,
 		writePagesPerWorker:     cfg.WritePagesPerWorker,
-		valuesFileCap:           uint32(cfg.ValuesFileCap),
+		valuesFileCap:           uint64(cfg.ValuesFileCap),
+		tocEntrySize:            _TOC_ENTRY_SIZE_V0,
 		valuesFileReaders:       cfg.ValuesFileReaders,
 		checksumInterval:        uint32(cfg.ChecksumInterval),
 		msgRing:                 cfg.MsgRing,
@@ -279,9 +683,32 @@ func New(c *Config) *DefaultValueStore {
 	vs.freeVWRChans = make([]chan *valueWriteReq, vs.workers)
 	vs.pendingVWRChans = make([]chan *valueWriteReq, vs.workers)
 	vs.vfVMChan = make(chan *valuesMem, vs.workers)
+	if vs.valuesFileCap > math.MaxUint32 {
+		vs.tocEntrySize = _TOC_ENTRY_SIZE_V1
+	}
 	vs.freeTOCBlockChan = make(chan []byte, vs.workers*2)
 	vs.pendingTOCBlockChan = make(chan []byte, vs.workers)
 	vs.flushedChan = make(chan struct{}, 1)
+	vs.readerPoolConfig(cfg)
+	vs.readCacheConfig(cfg)
+	vs.versionsConfig(cfg)
+	vs.recoveryProgressConfig(cfg)
+	vs.manifestConfig(cfg)
+	vs.pathsConfig(cfg)
+	vs.readerTuneConfig(cfg)
+	vs.diskHealthConfig(cfg)
+	vs.diskFreeConfig(cfg)
+	vs.pathsLimitConfig(cfg)
+	vs.closeConfig(cfg)
+	if err := vs.compressionConfig(cfg); err != nil {
+		vs.closeFiles()
+		return nil, err
+	}
+	if err := vs.encryptionConfig(cfg); err != nil {
+		vs.closeFiles()
+		return nil, err
+	}
+	vs.directIOConfig(cfg)
 	for i := 0; i < cap(vs.freeVMChan); i++ {
 		vm := &valuesMem{
 			vs:     vs,
@@ -303,6 +730,17 @@ func New(c *Config) *DefaultValueStore {
 	for i := 0; i < cap(vs.freeTOCBlockChan); i++ {
 		vs.freeTOCBlockChan <- make([]byte, 0, vs.pageSize)
 	}
+	if err := vs.dirLock(); err != nil {
+		vs.closeFiles()
+		return nil, err
+	}
+	// Recovery only needs the vlm, so it's done before starting anything that
+	// would need stopping if it fails.
+	if err := vs.recovery(ctx); err != nil {
+		vs.closeFiles()
+		return nil, err
+	}
+	vs.closeState.writersWG.Add(2 + len(vs.freeableVMChans) + len(vs.pendingVWRChans))
 	go vs.tocWriter()
 	go vs.vfWriter()
 	for i := 0; i < len(vs.freeableVMChans); i++ {
@@ -311,20 +749,46 @@ func New(c *Config) *DefaultValueStore {
 	for i := 0; i < len(vs.pendingVWRChans); i++ {
 		go vs.memWriter(vs.pendingVWRChans[i])
 	}
-	vs.recovery()
+	vs.cpuBudgetConfig(cfg)
+	vs.msgAuthConfig(cfg)
+	vs.peerFlowConfig(cfg)
+	vs.msgPoolConfig(cfg)
 	vs.tombstoneDiscardConfig(cfg)
 	vs.compactionConfig(cfg)
 	vs.pullReplicationConfig(cfg)
+	vs.replicationLagConfig(cfg)
 	vs.pushReplicationConfig(cfg)
+	vs.pushBacklogConfig(cfg)
+	vs.handoffConfig(cfg)
+	vs.remoteReplicationConfig(cfg)
+	vs.replicationCodecConfig(cfg)
+	vs.remoteReadConfig(cfg)
+	vs.quorumConfig(cfg)
+	vs.erasureConfig(cfg)
+	vs.syncPolicyConfig(cfg)
 	vs.bulkSetConfig(cfg)
+	vs.bulkSetPeersConfig(cfg)
 	vs.bulkSetAckConfig(cfg)
+	vs.tombstoneAckConfig(cfg)
 	vs.tombstoneDiscardLaunch()
 	vs.compactionLaunch()
 	vs.pullReplicationLaunch()
 	vs.pushReplicationLaunch()
+	vs.pushBacklogLaunch()
+	vs.handoffLaunch()
+	vs.remoteReplicationLaunch()
+	vs.remoteReadLaunch()
+	vs.quorumLaunch()
+	vs.readerTuneLaunch()
+	vs.diskHealthLaunch()
+	vs.diskFreeLaunch()
+	vs.pathsLimitLaunch()
 	vs.bulkSetLaunch()
+	vs.bulkSetPeersLaunch()
+	vs.msgPoolLaunch()
 	vs.bulkSetAckLaunch()
-	return vs
+	vs.syncPolicyLaunch()
+	return vs, nil
 }
 
 // ValueCap returns the maximum length of a value the ValueStore can
@@ -333,6 +797,18 @@ func (vs *DefaultValueStore) ValueCap() uint32 {
 	return vs.valueCap
 }
 
+// Config returns a copy of the configuration the ValueStore is actually
+// running with; that is, after defaults, environment overrides, and limits
+// have been applied, along with any changes made by the Set methods since.
+// Changing the returned Config, its Paths and keys included, has no effect
+// on the ValueStore.
+func (vs *DefaultValueStore) Config() *Config {
+	vs.configLock.Lock()
+	cfg := vs.config.copy()
+	vs.configLock.Unlock()
+	return cfg
+}
+
 // DisableAll calls DisableAllBackground(), and DisableWrites().
 func (vs *DefaultValueStore) DisableAll() {
 	vs.DisableAllBackground()
@@ -375,22 +851,34 @@ func (vs *DefaultValueStore) EnableWrites() {
 }
 
 // Flush will ensure buffered data (at the time of the call) is written to
-// disk.
+// disk, including the push replication backlog.
 func (vs *DefaultValueStore) Flush() {
+	vs.flushLock.Lock()
 	for _, c := range vs.pendingVWRChans {
 		c <- flushValueWriteReq
 	}
 	<-vs.flushedChan
+	vs.flushLock.Unlock()
+	if vs.pushBacklogState.max > 0 {
+		vs.pushBacklogSave()
+	}
 }
 
-// Lookup will return timestampmicro, length, err for keyA, keyB.
+// Lookup will return timestampmicro, length, err for keyA, keyB; only the
+// in-memory location map is consulted, so no value is read from disk, making
+// it the cheap way to check existence, size, or version.
 //
 // Note that err == ErrNotFound with timestampmicro == 0 indicates keyA, keyB
 // was not known at all whereas err == ErrNotFound with timestampmicro != 0
 // indicates keyA, keyB was known and had a deletion marker (aka tombstone).
+// A value this node has handed off to its proper replicas, but not yet
+// discarded, is reported as not known at all, just as Read would.
 func (vs *DefaultValueStore) Lookup(keyA uint64, keyB uint64) (int64, uint32, error) {
 	atomic.AddInt32(&vs.lookups, 1)
 	timestampbits, _, length, err := vs.lookup(keyA, keyB)
+	if err == nil && timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+		timestampbits, length, err = 0, 0, ErrNotFound
+	}
 	if err != nil {
 		atomic.AddInt32(&vs.lookupErrors, 1)
 	}
@@ -414,11 +902,16 @@ func (vs *DefaultValueStore) lookup(keyA, keyB uint64) (uint64, uint32, uint32,
 // indicates keyA, keyB was known and had a deletion marker (aka tombstone).
 func (vs *DefaultValueStore) Read(keyA uint64, keyB uint64, value []byte) (int64, []byte, error) {
 	atomic.AddInt32(&vs.reads, 1)
-	timestampbits, value, err := vs.read(keyA, keyB, value)
+	timestampbits, value2, err := vs.readCached(keyA, keyB, value)
+	if err != nil && err != ErrNotFound {
+		timestampbits, value2, err = vs.remoteRead(keyA, keyB, timestampbits, value, err)
+	} else if vs.remoteReadState.repair {
+		timestampbits, value2, err = vs.readRepair(keyA, keyB, timestampbits, value2, err)
+	}
 	if err != nil {
 		atomic.AddInt32(&vs.readErrors, 1)
 	}
-	return int64(timestampbits >> _TSB_UTIL_BITS), value, err
+	return int64(timestampbits >> _TSB_UTIL_BITS), value2, err
 }
 
 func (vs *DefaultValueStore) read(keyA uint64, keyB uint64, value []byte) (uint64, []byte, error) {
@@ -433,7 +926,19 @@ func (vs *DefaultValueStore) read(keyA uint64, keyB uint64, value []byte) (uint6
 // stored timestampmicro or returns any error; a newer timestampmicro already
 // in place is not reported as an error. Note that with a write and a delete
 // for the exact same timestampmicro, the delete wins.
+//
+// With Config.MonotonicWrites, timestampmicro is raised as needed to be newer
+// than the one already stored and the timestampmicro actually used is
+// returned instead.
+//
+// With the "always" Config.SyncPolicy, Write returns once the value is on
+// disk; see WriteDurable.
 func (vs *DefaultValueStore) Write(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
+	return vs.WriteDurable(keyA, keyB, timestampmicro, value, vs.syncPolicyState.policy == _SYNC_POLICY_ALWAYS)
+}
+
+// writeValue is Write without regard to Config.SyncPolicy.
+func (vs *DefaultValueStore) writeValue(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, error) {
 	atomic.AddInt32(&vs.writes, 1)
 	if timestampmicro < TIMESTAMPMICRO_MIN {
 		atomic.AddInt32(&vs.writeErrors, 1)
@@ -443,6 +948,25 @@ func (vs *DefaultValueStore) Write(keyA uint64, keyB uint64, timestampmicro int6
 		atomic.AddInt32(&vs.writeErrors, 1)
 		return 0, fmt.Errorf("timestamp %d > %d", timestampmicro, TIMESTAMPMICRO_MAX)
 	}
+	if err := vs.checkTimestamp(timestampmicro); err != nil {
+		atomic.AddInt32(&vs.writeErrors, 1)
+		return 0, err
+	}
+	if err := vs.diskFull(); err != nil {
+		atomic.AddInt32(&vs.writeErrors, 1)
+		return 0, err
+	}
+	if vs.monotonicWrites {
+		ptimestampbits, _, _, _ := vs.vlm.Get(keyA, keyB)
+		if ptimestampmicro := int64(ptimestampbits >> _TSB_UTIL_BITS); timestampmicro <= ptimestampmicro {
+			if ptimestampmicro >= TIMESTAMPMICRO_MAX {
+				atomic.AddInt32(&vs.writeErrors, 1)
+				return 0, fmt.Errorf("timestamp %d > %d", ptimestampmicro+1, TIMESTAMPMICRO_MAX)
+			}
+			timestampmicro = ptimestampmicro + 1
+			atomic.AddInt32(&vs.writesBumped, 1)
+		}
+	}
 	timestampbits, err := vs.write(keyA, keyB, uint64(timestampmicro)<<_TSB_UTIL_BITS, value)
 	if err != nil {
 		atomic.AddInt32(&vs.writeErrors, 1)
@@ -450,29 +974,127 @@ func (vs *DefaultValueStore) Write(keyA uint64, keyB uint64, timestampmicro int6
 	if timestampmicro <= int64(timestampbits>>_TSB_UTIL_BITS) {
 		atomic.AddInt32(&vs.writesOverridden, 1)
 	}
+	if vs.monotonicWrites {
+		return timestampmicro, err
+	}
 	return int64(timestampbits >> _TSB_UTIL_BITS), err
 }
 
+// checkTimestamp returns a *TimestampError if timestampmicro is too far from
+// the local clock.
+func (vs *DefaultValueStore) checkTimestamp(timestampmicro int64) error {
+	if vs.maxFutureMicro == 0 && vs.maxPastMicro == 0 {
+		return nil
+	}
+	nowmicro := brimtime.TimeToUnixMicro(time.Now())
+	if (vs.maxFutureMicro != 0 && timestampmicro > nowmicro+vs.maxFutureMicro) || (vs.maxPastMicro != 0 && timestampmicro < nowmicro-vs.maxPastMicro) {
+		atomic.AddInt32(&vs.timestampRejections, 1)
+		return &TimestampError{Timestampmicro: timestampmicro, Nowmicro: nowmicro}
+	}
+	return nil
+}
+
+// keyLock returns the keyLock for keyA.
+func (vs *DefaultValueStore) keyLock(keyA uint64) *sync.Mutex {
+	return &vs.keyLocks[keyA%_KEY_LOCKS]
+}
+
 func (vs *DefaultValueStore) write(keyA uint64, keyB uint64, timestampbits uint64, value []byte) (uint64, error) {
+	return vs.writeIf(keyA, keyB, timestampbits, value, false, 0)
+}
+
+// writeIf is write that, with conditional, only writes if the timestampmicro
+// stored for keyA, keyB is expected, returning ErrNewerVersion and the stored
+// timestampbits otherwise. The memWriter holds the key's keyLock from the
+// check through the write. Other writes of the key go through that same
+// memWriter, except those in a batch, which writeBatch gives all to one
+// memWriter; each of those is written holding its key's keyLock too, so
+// nothing can change the key in between.
+func (vs *DefaultValueStore) writeIf(keyA uint64, keyB uint64, timestampbits uint64, value []byte, conditional bool, expected int64) (uint64, error) {
 	i := int(keyA>>1) % len(vs.freeVWRChans)
 	vwr := <-vs.freeVWRChans[i]
 	vwr.keyA = keyA
 	vwr.keyB = keyB
 	vwr.timestampbits = timestampbits
 	vwr.value = value
-	vs.pendingVWRChans[i] <- vwr
+	vwr.conditional = conditional
+	vwr.expected = expected
+	select {
+	case vs.pendingVWRChans[i] <- vwr:
+	case <-vs.closeState.writersChan:
+		vwr.value = nil
+		vwr.conditional = false
+		vs.freeVWRChans[i] <- vwr
+		return timestampbits, ErrClosed
+	}
 	err := <-vwr.errChan
 	ptimestampbits := vwr.timestampbits
 	vwr.value = nil
+	vwr.conditional = false
 	vs.freeVWRChans[i] <- vwr
+	if err == nil && ptimestampbits < timestampbits {
+		vs.readCacheRemove(keyA, keyB)
+		atomic.StoreInt32(&vs.syncPolicyState.dirty, 1)
+	}
 	return ptimestampbits, err
 }
 
+// writeBatch applies all the writes in the batch through a single memWriter,
+// so they share pages and end up as one run in the TOC, rather than each
+// making its own round trip; each holding its key's keyLock, see writeIf. The results are stored in each entry. If whole
+// is true, the batch is written within a single page between batch markers,
+// so it is recovered all or nothing; the caller must have checked it fits.
+func (vs *DefaultValueStore) writeBatch(batch []valueWriteBatchEntry, whole bool) {
+	if len(batch) == 0 {
+		return
+	}
+	i := int(batch[0].keyA>>1) % len(vs.freeVWRChans)
+	vwr := <-vs.freeVWRChans[i]
+	vwr.batch = batch
+	vwr.whole = whole
+	select {
+	case vs.pendingVWRChans[i] <- vwr:
+	case <-vs.closeState.writersChan:
+		for j := range batch {
+			batch[j].ptimestampbits = batch[j].timestampbits
+			batch[j].err = ErrClosed
+		}
+		vwr.batch = nil
+		vwr.whole = false
+		vs.freeVWRChans[i] <- vwr
+		return
+	}
+	<-vwr.errChan
+	vwr.batch = nil
+	vwr.whole = false
+	vs.freeVWRChans[i] <- vwr
+	for j := range batch {
+		if batch[j].err == nil && batch[j].ptimestampbits < batch[j].timestampbits {
+			vs.readCacheRemove(batch[j].keyA, batch[j].keyB)
+			atomic.StoreInt32(&vs.syncPolicyState.dirty, 1)
+		}
+	}
+}
+
 // Delete stores timestampmicro for keyA, keyB and returns the previously
-// stored timestampmicro or returns any error; a newer timestampmicro already
-// in place is not reported as an error. Note that with a write and a delete
-// for the exact same timestampmicro, the delete wins.
+// stored timestampmicro or returns any error. A newer timestampmicro already in
+// place is left alone and ErrNewerVersion is returned along with it, so stale
+// deletes can be told from those that took effect. Note that with a write and
+// a delete for the exact same timestampmicro, the delete wins.
 func (vs *DefaultValueStore) Delete(keyA uint64, keyB uint64, timestampmicro int64) (int64, error) {
+	return vs.deleteIf(keyA, keyB, timestampmicro, false, 0)
+}
+
+// DeleteIf is Delete only if the timestampmicro stored for keyA, keyB is
+// expectedTimestampmicro, as from an earlier Read or Lookup, 0 being nothing
+// stored at all; so a delete decided on from what was read doesn't remove a
+// value written since. Otherwise nothing is stored and ErrNewerVersion is
+// returned with the timestampmicro actually stored.
+func (vs *DefaultValueStore) DeleteIf(keyA uint64, keyB uint64, timestampmicro int64, expectedTimestampmicro int64) (int64, error) {
+	return vs.deleteIf(keyA, keyB, timestampmicro, true, expectedTimestampmicro)
+}
+
+func (vs *DefaultValueStore) deleteIf(keyA uint64, keyB uint64, timestampmicro int64, conditional bool, expectedTimestampmicro int64) (int64, error) {
 	atomic.AddInt32(&vs.deletes, 1)
 	if timestampmicro < TIMESTAMPMICRO_MIN {
 		atomic.AddInt32(&vs.deleteErrors, 1)
@@ -482,12 +1104,26 @@ func (vs *DefaultValueStore) Delete(keyA uint64, keyB uint64, timestampmicro int
 		atomic.AddInt32(&vs.deleteErrors, 1)
 		return 0, fmt.Errorf("timestamp %d > %d", timestampmicro, TIMESTAMPMICRO_MAX)
 	}
-	ptimestampbits, err := vs.write(keyA, keyB, (uint64(timestampmicro)<<_TSB_UTIL_BITS)|_TSB_DELETION, nil)
+	if err := vs.checkTimestamp(timestampmicro); err != nil {
+		atomic.AddInt32(&vs.deleteErrors, 1)
+		return 0, err
+	}
+	ptimestampbits, err := vs.writeIf(keyA, keyB, (uint64(timestampmicro)<<_TSB_UTIL_BITS)|_TSB_DELETION, nil, conditional, expectedTimestampmicro)
+	if err == ErrNewerVersion {
+		atomic.AddInt32(&vs.deletesOverridden, 1)
+		return int64(ptimestampbits >> _TSB_UTIL_BITS), err
+	}
+	if err == nil {
+		err = vs.syncAlways()
+	}
 	if err != nil {
 		atomic.AddInt32(&vs.deleteErrors, 1)
 	}
 	if timestampmicro <= int64(ptimestampbits>>_TSB_UTIL_BITS) {
 		atomic.AddInt32(&vs.deletesOverridden, 1)
+		if err == nil && timestampmicro < int64(ptimestampbits>>_TSB_UTIL_BITS) {
+			err = ErrNewerVersion
+		}
 	}
 	return int64(ptimestampbits >> _TSB_UTIL_BITS), err
 }
@@ -518,12 +1154,41 @@ func (vs *DefaultValueStore) valueLocBlockIDFromTimestampnano(tsn int64) uint32
 	return 0
 }
 
+// recoveryNewerLocation returns true if the location given by blockIDA,
+// offsetA is newer than blockIDB, offsetB; that is, in a newer values file or
+// later in the same values file. Block ID 0, used for local removals, is
+// considered older than any other.
+func (vs *DefaultValueStore) recoveryNewerLocation(blockIDA uint32, offsetA uint32, blockIDB uint32, offsetB uint32) bool {
+	if blockIDA == blockIDB {
+		return offsetA > offsetB
+	}
+	if blockIDA == 0 || blockIDB == 0 {
+		return blockIDB == 0
+	}
+	vfA, fileOffsetA := vs.valuesFileOffset(blockIDA, offsetA)
+	vfB, fileOffsetB := vs.valuesFileOffset(blockIDB, offsetB)
+	if vfA != nil && vfA == vfB {
+		return fileOffsetA > fileOffsetB
+	}
+	return vs.valueLocBlock(blockIDA).timestampnano() > vs.valueLocBlock(blockIDB).timestampnano()
+}
+
 func (vs *DefaultValueStore) memClearer(freeableVMChan chan *valuesMem) {
 	var tb []byte
 	var tbTS int64
 	var tbOffset int
+	// inBatch is set between batch markers; the batch's entries are all
+	// kept, even those already overridden, so that recovery finds the
+	// number of entries the markers give.
+	var inBatch bool
 	for {
-		vm := <-freeableVMChan
+		var vm *valuesMem
+		select {
+		case vm = <-freeableVMChan:
+		case <-vs.closeState.writersChan:
+			vs.closeState.writersWG.Done()
+			return
+		}
 		if vm == flushValuesMem {
 			if tb != nil {
 				vs.pendingTOCBlockChan <- tb
@@ -537,22 +1202,36 @@ func (vs *DefaultValueStore) memClearer(freeableVMChan chan *valuesMem) {
 			vs.pendingTOCBlockChan <- tb
 			tb = nil
 		}
-		for vmTOCOffset := 0; vmTOCOffset < len(vm.toc); vmTOCOffset += 32 {
+		for vmTOCOffset := 0; vmTOCOffset < len(vm.toc); vmTOCOffset += _VALUES_MEM_TOC_ENTRY_SIZE {
 			keyA := binary.BigEndian.Uint64(vm.toc[vmTOCOffset:])
 			keyB := binary.BigEndian.Uint64(vm.toc[vmTOCOffset+8:])
 			timestampbits := binary.BigEndian.Uint64(vm.toc[vmTOCOffset+16:])
 			var blockID uint32
+			var fileOffset uint64
 			var offset uint32
 			var length uint32
-			if timestampbits&_TSB_LOCAL_REMOVAL == 0 {
-				blockID = vm.vfID
-				offset = vm.vfOffset + binary.BigEndian.Uint32(vm.toc[vmTOCOffset+24:])
-				length = binary.BigEndian.Uint32(vm.toc[vmTOCOffset+28:])
-			}
-			if vs.vlm.Set(keyA, keyB, timestampbits, blockID, offset, length, true) > timestampbits {
-				continue
+			if timestampbits>>_TSB_UTIL_BITS == 0 {
+				// A batch marker; the whole batch goes in one TOC block.
+				inBatch = timestampbits == _TSB_BATCH_BEGIN
+				if inBatch && tb != nil && int(keyB) > batchCap(cap(tb)-tbOffset, vs.tocEntrySize) {
+					vs.pendingTOCBlockChan <- tb
+					tb = nil
+				}
+			} else {
+				if timestampbits&_TSB_LOCAL_REMOVAL == 0 {
+					blockID = vm.vfID
+					fileOffset = vm.vfOffset + uint64(binary.BigEndian.Uint32(vm.toc[vmTOCOffset+24:]))
+					offset = uint32(fileOffset)
+					if vf, ok := vf.(*valuesFile); ok {
+						blockID, offset = vf.location(fileOffset)
+					}
+					length = binary.BigEndian.Uint32(vm.toc[vmTOCOffset+28:])
+				}
+				if ptimestampbits, kept := vs.versionsMove(keyA, keyB, timestampbits, blockID, offset, length); ptimestampbits > timestampbits && !inBatch && !kept {
+					continue
+				}
 			}
-			if tb != nil && tbOffset+32 > cap(tb) {
+			if tb != nil && tbOffset+vs.tocEntrySize > cap(tb) {
 				vs.pendingTOCBlockChan <- tb
 				tb = nil
 			}
@@ -563,13 +1242,9 @@ func (vs *DefaultValueStore) memClearer(freeableVMChan chan *valuesMem) {
 				binary.BigEndian.PutUint64(tb, uint64(tbTS))
 				tbOffset = 8
 			}
-			tb = tb[:tbOffset+32]
-			binary.BigEndian.PutUint64(tb[tbOffset:], keyA)
-			binary.BigEndian.PutUint64(tb[tbOffset+8:], keyB)
-			binary.BigEndian.PutUint64(tb[tbOffset+16:], timestampbits)
-			binary.BigEndian.PutUint32(tb[tbOffset+24:], offset)
-			binary.BigEndian.PutUint32(tb[tbOffset+28:], length)
-			tbOffset += 32
+			tb = tb[:tbOffset+vs.tocEntrySize]
+			putTOCEntry(tb[tbOffset:], vs.tocEntrySize, keyA, keyB, timestampbits, fileOffset, length)
+			tbOffset += vs.tocEntrySize
 		}
 		vm.discardLock.Lock()
 		vm.vfID = 0
@@ -586,38 +1261,23 @@ func (vs *DefaultValueStore) memWriter(pendingVWRChan chan *valueWriteReq) {
 	var vm *valuesMem
 	var vmTOCOffset int
 	var vmMemOffset int
-	for {
-		vwr := <-pendingVWRChan
-		if vwr == enableValueWriteReq {
-			enabled = true
-			continue
-		}
-		if vwr == disableValueWriteReq {
-			enabled = false
-			continue
-		}
-		if vwr == flushValueWriteReq {
-			if vm != nil && len(vm.toc) > 0 {
-				vs.vfVMChan <- vm
-				vm = nil
-			}
-			vs.vfVMChan <- flushValuesMem
-			continue
+	// compressed is reused for each value when Config.Compression is set.
+	var compressed []byte
+	write := func(keyA uint64, keyB uint64, timestampbits uint64, value []byte) (uint64, error) {
+		logicalLength := len(value)
+		if logicalLength > int(vs.valueCap) {
+			return timestampbits, fmt.Errorf("value length of %d > %d", logicalLength, vs.valueCap)
 		}
-		if !enabled {
-			vwr.errChan <- ErrDisabled
-			continue
-		}
-		length := len(vwr.value)
-		if length > int(vs.valueCap) {
-			vwr.errChan <- fmt.Errorf("value length of %d > %d", length, vs.valueCap)
-			continue
+		if vs.compressionState.framed {
+			compressed = vs.compress(compressed, value)
+			value = compressed
 		}
+		length := len(value)
 		alloc := length
 		if alloc < vs.minValueAlloc {
 			alloc = vs.minValueAlloc
 		}
-		if vm != nil && (vmTOCOffset+32 > cap(vm.toc) || vmMemOffset+alloc > cap(vm.values)) {
+		if vm != nil && (vmTOCOffset+_VALUES_MEM_TOC_ENTRY_SIZE > cap(vm.toc) || vmMemOffset+alloc > cap(vm.values)) {
 			vs.vfVMChan <- vm
 			vm = nil
 		}
@@ -629,27 +1289,173 @@ func (vs *DefaultValueStore) memWriter(pendingVWRChan chan *valueWriteReq) {
 		vm.discardLock.Lock()
 		vm.values = vm.values[:vmMemOffset+alloc]
 		vm.discardLock.Unlock()
-		copy(vm.values[vmMemOffset:], vwr.value)
+		copy(vm.values[vmMemOffset:], value)
 		if alloc > length {
 			for i, j := vmMemOffset+length, vmMemOffset+alloc; i < j; i++ {
 				vm.values[i] = 0
 			}
 		}
-		ptimestampbits := vs.vlm.Set(vwr.keyA, vwr.keyB, vwr.timestampbits, vm.id, uint32(vmMemOffset), uint32(length), false)
-		if ptimestampbits < vwr.timestampbits {
-			vm.toc = vm.toc[:vmTOCOffset+32]
-			binary.BigEndian.PutUint64(vm.toc[vmTOCOffset:], vwr.keyA)
-			binary.BigEndian.PutUint64(vm.toc[vmTOCOffset+8:], vwr.keyB)
-			binary.BigEndian.PutUint64(vm.toc[vmTOCOffset+16:], vwr.timestampbits)
+		// A kept older version, as rewritten by compaction, is stored though
+		// it isn't current, see Config.RetainVersions, as is a rewrite of an
+		// earlier rewrite, though its timestampbits are those stored.
+		ptimestampbits, kept := vs.versionsSet(keyA, keyB, timestampbits, vm.id, uint32(vmMemOffset), uint32(length))
+		if ptimestampbits < timestampbits || kept {
+			if timestampbits&(_TSB_COMPACTION_REWRITE|_TSB_LOCAL_REMOVAL) == 0 {
+				atomic.AddInt64(&vs.logicalWriteBytes, int64(logicalLength))
+			}
+			vm.toc = vm.toc[:vmTOCOffset+_VALUES_MEM_TOC_ENTRY_SIZE]
+			binary.BigEndian.PutUint64(vm.toc[vmTOCOffset:], keyA)
+			binary.BigEndian.PutUint64(vm.toc[vmTOCOffset+8:], keyB)
+			binary.BigEndian.PutUint64(vm.toc[vmTOCOffset+16:], timestampbits)
 			binary.BigEndian.PutUint32(vm.toc[vmTOCOffset+24:], uint32(vmMemOffset))
 			binary.BigEndian.PutUint32(vm.toc[vmTOCOffset+28:], uint32(length))
-			vmTOCOffset += 32
+			vmTOCOffset += _VALUES_MEM_TOC_ENTRY_SIZE
 			vmMemOffset += alloc
+			if !kept {
+				vs.watchNotify(keyA, keyB, timestampbits)
+				vs.remoteReplicationAdd(keyA, keyB, timestampbits)
+			}
 		} else {
 			vm.discardLock.Lock()
 			vm.values = vm.values[:vmMemOffset]
 			vm.discardLock.Unlock()
 		}
+		return ptimestampbits, nil
+	}
+	// lockedWrite is write holding the key's keyLock, for the writes of a
+	// batch, which may be of keys other memWriters write.
+	lockedWrite := func(keyA uint64, keyB uint64, timestampbits uint64, value []byte) (uint64, error) {
+		lock := vs.keyLock(keyA)
+		lock.Lock()
+		defer lock.Unlock()
+		return write(keyA, keyB, timestampbits, value)
+	}
+	// marker appends a batch marker TOC entry to the vm.
+	marker := func(timestampbits uint64, count int) {
+		vm.toc = vm.toc[:vmTOCOffset+_VALUES_MEM_TOC_ENTRY_SIZE]
+		binary.BigEndian.PutUint64(vm.toc[vmTOCOffset:], 0)
+		binary.BigEndian.PutUint64(vm.toc[vmTOCOffset+8:], uint64(count))
+		binary.BigEndian.PutUint64(vm.toc[vmTOCOffset+16:], timestampbits)
+		binary.BigEndian.PutUint64(vm.toc[vmTOCOffset+24:], 0)
+		vmTOCOffset += _VALUES_MEM_TOC_ENTRY_SIZE
+	}
+	// writeWhole writes the batch within one vm between batch markers; the
+	// memClearer likewise keeps it within one TOC block, and WriteBatchCap
+	// keeps it small enough for both.
+	writeWhole := func(batch []valueWriteBatchEntry) {
+		alloc := 0
+		for i := range batch {
+			if l := vs.storedLengthMax(len(batch[i].value)); l < vs.minValueAlloc {
+				alloc += vs.minValueAlloc
+			} else {
+				alloc += l
+			}
+		}
+		if vm != nil && (len(batch) > batchCap(cap(vm.toc)-vmTOCOffset, _VALUES_MEM_TOC_ENTRY_SIZE) || vmMemOffset+alloc > cap(vm.values)) {
+			vs.vfVMChan <- vm
+			vm = nil
+		}
+		if vm == nil {
+			vm = <-vs.freeVMChan
+			vmTOCOffset = 0
+			vmMemOffset = 0
+		}
+		begin := vmTOCOffset
+		marker(_TSB_BATCH_BEGIN, 0)
+		for i := range batch {
+			e := &batch[i]
+			e.ptimestampbits, e.err = lockedWrite(e.keyA, e.keyB, e.timestampbits, e.value)
+		}
+		// Entries overridden by newer ones already stored aren't written.
+		count := (vmTOCOffset-begin)/_VALUES_MEM_TOC_ENTRY_SIZE - 1
+		if count == 0 {
+			vm.toc = vm.toc[:begin]
+			vmTOCOffset = begin
+			return
+		}
+		binary.BigEndian.PutUint64(vm.toc[begin+8:], uint64(count))
+		marker(_TSB_BATCH_COMMIT, count)
+	}
+	for {
+		var vwr *valueWriteReq
+		select {
+		case vwr = <-pendingVWRChan:
+		case <-vs.closeState.writersChan:
+			vs.closeState.writersWG.Done()
+			return
+		}
+		if vwr == enableValueWriteReq {
+			enabled = true
+			continue
+		}
+		if vwr == disableValueWriteReq {
+			enabled = false
+			continue
+		}
+		if vwr == flushValueWriteReq {
+			if vm != nil && len(vm.toc) > 0 {
+				vs.vfVMChan <- vm
+				vm = nil
+			}
+			vs.vfVMChan <- flushValuesMem
+			continue
+		}
+		if vwr.batch != nil && vwr.whole && enabled {
+			writeWhole(vwr.batch)
+			vwr.errChan <- nil
+			continue
+		}
+		if vwr.batch != nil {
+			for i := range vwr.batch {
+				e := &vwr.batch[i]
+				if !enabled {
+					e.ptimestampbits = e.timestampbits
+					e.err = ErrDisabled
+					continue
+				}
+				e.ptimestampbits, e.err = lockedWrite(e.keyA, e.keyB, e.timestampbits, e.value)
+			}
+			vwr.errChan <- nil
+			continue
+		}
+		if !enabled {
+			vwr.errChan <- ErrDisabled
+			continue
+		}
+		if vwr.conditional {
+			lock := vs.keyLock(vwr.keyA)
+			lock.Lock()
+			ptimestampbits, _, _, _ := vs.vlm.Get(vwr.keyA, vwr.keyB)
+			if int64(ptimestampbits>>_TSB_UTIL_BITS) != vwr.expected {
+				lock.Unlock()
+				vwr.timestampbits = ptimestampbits
+				vwr.errChan <- ErrNewerVersion
+				continue
+			}
+			ptimestampbits, err := write(vwr.keyA, vwr.keyB, vwr.timestampbits, vwr.value)
+			lock.Unlock()
+			if err == nil {
+				vwr.timestampbits = ptimestampbits
+			}
+			vwr.errChan <- err
+			continue
+		}
+		if vwr.streamed {
+			ptimestampbits, _ := vs.versionsSet(vwr.keyA, vwr.keyB, vwr.timestampbits, vwr.blockID, vwr.offset, vwr.length)
+			if ptimestampbits < vwr.timestampbits {
+				atomic.AddInt64(&vs.logicalWriteBytes, int64(vwr.logicalLength))
+				vs.watchNotify(vwr.keyA, vwr.keyB, vwr.timestampbits)
+				vs.remoteReplicationAdd(vwr.keyA, vwr.keyB, vwr.timestampbits)
+			}
+			vwr.timestampbits = ptimestampbits
+			vwr.errChan <- nil
+			continue
+		}
+		ptimestampbits, err := write(vwr.keyA, vwr.keyB, vwr.timestampbits, vwr.value)
+		if err != nil {
+			vwr.errChan <- err
+			continue
+		}
 		vwr.timestampbits = ptimestampbits
 		vwr.errChan <- nil
 	}
@@ -661,7 +1467,13 @@ func (vs *DefaultValueStore) vfWriter() {
 	var tocLen uint64
 	var valueLen uint64
 	for {
-		vm := <-vs.vfVMChan
+		var vm *valuesMem
+		select {
+		case vm = <-vs.vfVMChan:
+		case <-vs.closeState.writersChan:
+			vs.closeState.writersWG.Done()
+			return
+		}
 		if vm == flushValuesMem {
 			memWritersFlushLeft--
 			if memWritersFlushLeft > 0 {
@@ -682,7 +1494,7 @@ func (vs *DefaultValueStore) vfWriter() {
 			vf = nil
 		}
 		if vf == nil {
-			vf = createValuesFile(vs, osCreateWriteCloser, osOpenReadSeeker)
+			vf = createValuesFile(vs, vs.createValuesWriteCloser, vs.openValuesReadSeeker)
 			tocLen = 32
 			valueLen = 32
 		}
@@ -701,12 +1513,21 @@ func (vs *DefaultValueStore) tocWriter() {
 	var offsetA uint64
 	var writerB io.WriteCloser
 	var offsetB uint64
-	head := []byte("VALUESTORETOC v0                ")
-	binary.BigEndian.PutUint32(head[28:], uint32(vs.checksumInterval))
+	head := []byte(_TOC_HEADER_V0 + "    ")
+	if vs.tocEntrySize == _TOC_ENTRY_SIZE_V1 {
+		head = []byte(_TOC_HEADER_V1 + "    ")
+	}
+	putChecksumHeader(head, _CHECKSUM_MURMUR3, vs.checksumInterval)
 	term := make([]byte, 16)
 	copy(term[12:], "TERM")
 	for {
-		t := <-vs.pendingTOCBlockChan
+		var t []byte
+		select {
+		case t = <-vs.pendingTOCBlockChan:
+		case <-vs.closeState.writersChan:
+			vs.closeState.writersWG.Done()
+			return
+		}
 		if t == nil {
 			memClearersFlushLeft--
 			if memClearersFlushLeft > 0 {
@@ -771,11 +1592,15 @@ func (vs *DefaultValueStore) tocWriter() {
 				writerB = writerA
 				offsetB = offsetA
 				atomic.StoreUint64(&vs.activeTOCA, bts)
-				fp, err := os.Create(path.Join(vs.pathtoc, fmt.Sprintf("%d.valuestoc", bts)))
+				fp, err := os.Create(vs.tocName(int64(bts)))
+				if err != nil {
+					panic(err)
+				}
+				ew, err := vs.encryptWriteCloser(&countingWriteCloser{WriteCloser: &syncingWriteCloser{File: fp, vs: vs}, vs: vs, disk: vs.diskHealthState.toc}, vs.checksumInterval+4)
 				if err != nil {
 					panic(err)
 				}
-				writerA = brimutil.NewMultiCoreChecksummedWriter(fp, int(vs.checksumInterval), murmur3.New32, vs.workers)
+				writerA = brimutil.NewMultiCoreChecksummedWriter(ew, int(vs.checksumInterval), murmur3.New32, vs.workers)
 				if _, err := writerA.Write(head); err != nil {
 					panic(err)
 				}
@@ -789,7 +1614,7 @@ func (vs *DefaultValueStore) tocWriter() {
 	}
 }
 
-func (vs *DefaultValueStore) recovery() {
+func (vs *DefaultValueStore) recovery(ctx context.Context) error {
 	start := time.Now()
 	fromDiskCount := 0
 	causedChangeCount := int64(0)
@@ -825,12 +1650,23 @@ func (vs *DefaultValueStore) recovery() {
 					if wr.timestampbits&_TSB_LOCAL_REMOVAL != 0 {
 						wr.blockID = 0
 					}
-					if vs.logDebug != nil {
-						if vs.vlm.Set(wr.keyA, wr.keyB, wr.timestampbits, wr.blockID, wr.offset, wr.length, true) < wr.timestampbits {
+					ptimestampbits := vs.versionsRecover(wr.keyA, wr.keyB, wr.timestampbits, wr.blockID, wr.offset, wr.length)
+					if ptimestampbits < wr.timestampbits {
+						if vs.logDebug != nil {
 							atomic.AddInt64(&causedChangeCount, 1)
 						}
-					} else {
-						vs.vlm.Set(wr.keyA, wr.keyB, wr.timestampbits, wr.blockID, wr.offset, wr.length, true)
+					} else if ptimestampbits == wr.timestampbits {
+						// The same key and timestamp was already recovered;
+						// if it points somewhere else, keep whichever
+						// location is newest regardless of the order the
+						// entries were read in.
+						_, blockID, offset, _ := vs.vlm.Get(wr.keyA, wr.keyB)
+						if blockID != wr.blockID || offset != wr.offset {
+							atomic.AddInt32(&vs.recoveryDuplicates, 1)
+							if vs.recoveryNewerLocation(wr.blockID, wr.offset, blockID, offset) {
+								vs.vlm.Set(wr.keyA, wr.keyB, wr.timestampbits, wr.blockID, wr.offset, wr.length, true)
+							}
+						}
 					}
 				}
 				freeBatchChan <- batch
@@ -839,25 +1675,28 @@ func (vs *DefaultValueStore) recovery() {
 		}(pendingBatchChans[i], freeBatchChans[i])
 	}
 	fromDiskBuf := make([]byte, vs.checksumInterval+4)
-	fromDiskOverflow := make([]byte, 0, 32)
+	fromDiskOverflow := make([]byte, 0, _TOC_ENTRY_SIZE_MAX)
+	var batchBuf []byte
 	batches := make([][]writeReq, len(freeBatchChans))
 	batchesPos := make([]int, len(batches))
-	fp, err := os.Open(vs.pathtoc)
-	if err != nil {
-		panic(err)
-	}
-	names, err := fp.Readdirnames(-1)
-	fp.Close()
-	if err != nil {
-		panic(err)
+	tocNames, err := vs.pathsRecover()
+	if err == nil {
+		tocNames = vs.manifestRecover(tocNames)
 	}
-	sort.Strings(names)
-	for i := 0; i < len(names); i++ {
+	names := tocNames
+	vs.recoveryProgressBegin(start, tocNames)
+	file := 0
+	for i := 0; err == nil && i < len(names); i++ {
 		if !strings.HasSuffix(names[i], ".valuestoc") {
 			continue
 		}
-		namets := int64(0)
-		if namets, err = strconv.ParseInt(names[i][:len(names[i])-len(".valuestoc")], 10, 64); err != nil {
+		vs.recoveryProgressFile(file, fromDiskCount)
+		file++
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		namets, perr := strconv.ParseInt(names[i][:len(names[i])-len(".valuestoc")], 10, 64)
+		if perr != nil {
 			vs.logError("bad timestamp in name: %#v\n", names[i])
 			continue
 		}
@@ -865,18 +1704,109 @@ func (vs *DefaultValueStore) recovery() {
 			vs.logError("bad timestamp in name: %#v\n", names[i])
 			continue
 		}
-		vf := newValuesFile(vs, namets, osOpenReadSeeker)
-		fp, err := os.Open(path.Join(vs.pathtoc, names[i]))
+		vf, verr := newValuesFile(vs, namets, vs.openValuesReadSeeker)
+		if verr != nil {
+			// With RemoteReadFallback, the other replicas are relied on for
+			// what a lost values file held, as replication brings it back;
+			// without, the store would quietly be missing the data.
+			if errors.Is(verr, os.ErrNotExist) && vs.config.RemoteReadFallback && vs.msgRing != nil {
+				atomic.AddInt32(&vs.recoveryMissingValuesFiles, 1)
+				vs.logError("values file %s is missing; skipping %s for the other replicas to restore\n", vs.valuesName(namets), names[i])
+				continue
+			}
+			err = &RecoveryError{Path: vs.valuesName(namets), Err: verr}
+			break
+		}
+		entries, trusted, dirty := vf.check(vs.openValuesReadSeeker)
+		if dirty {
+			atomic.AddInt32(&vs.dirtyValuesFiles, 1)
+			vs.logWarning("no valid trailer for values file %019d.values; trusting only its first %d bytes\n", namets, trusted)
+		}
+		fileCount := fromDiskCount
+		untrusted := 0
+		tornBatches := 0
+		fp, err := vs.openFile(vs.tocName(namets))
 		if err != nil {
 			vs.logError("error opening %s: %s\n", names[i], err)
 			continue
 		}
+		interval, newHash, err := readChecksumHeader(fp)
+		if err != nil {
+			vs.logError("bad header checksum settings: %s: %s\n", names[i], err)
+			fp.Close()
+			continue
+		}
+		h := newHash()
+		if cap(fromDiskBuf) < int(interval)+4 {
+			fromDiskBuf = make([]byte, interval+4)
+		}
+		fromDiskBuf = fromDiskBuf[:interval+4]
+		applyEntry := func(entry []byte, entrySize int) {
+			fileOffset, length := tocEntryLocation(entry, entrySize)
+			if dirty && fileOffset+uint64(length) > trusted {
+				untrusted++
+				return
+			}
+			keyB := binary.BigEndian.Uint64(entry[8:])
+			k := keyB % workers
+			if batches[k] == nil {
+				batches[k] = <-freeBatchChans[k]
+				batchesPos[k] = 0
+			}
+			wr := &batches[k][batchesPos[k]]
+			wr.keyA = binary.BigEndian.Uint64(entry)
+			wr.keyB = keyB
+			wr.timestampbits = binary.BigEndian.Uint64(entry[16:])
+			wr.blockID, wr.offset = vf.location(fileOffset)
+			wr.length = length
+			batchesPos[k]++
+			if batchesPos[k] >= vs.recoveryBatchSize {
+				pendingBatchChans[k] <- batches[k]
+				batches[k] = nil
+			}
+			fromDiskCount++
+		}
+		// The entries of a batch are held in batchBuf until its commit
+		// marker shows they are all there.
+		inBatch := false
+		batchCount := 0
+		batchBuf = batchBuf[:0]
+		recoverEntry := func(entry []byte, entrySize int) {
+			timestampbits := binary.BigEndian.Uint64(entry[16:])
+			if timestampbits>>_TSB_UTIL_BITS != 0 {
+				if inBatch {
+					batchBuf = append(batchBuf, entry[:entrySize]...)
+				} else {
+					applyEntry(entry, entrySize)
+				}
+				return
+			}
+			count := int(binary.BigEndian.Uint64(entry[8:]))
+			whole := inBatch && timestampbits == _TSB_BATCH_COMMIT && count == batchCount && len(batchBuf) == count*entrySize
+			for j := 0; whole && j < len(batchBuf); j += entrySize {
+				if fileOffset, length := tocEntryLocation(batchBuf[j:], entrySize); dirty && fileOffset+uint64(length) > trusted {
+					whole = false
+				}
+			}
+			if whole {
+				for j := 0; j < len(batchBuf); j += entrySize {
+					applyEntry(batchBuf[j:], entrySize)
+				}
+			} else if inBatch {
+				tornBatches++
+			}
+			inBatch = timestampbits == _TSB_BATCH_BEGIN
+			batchCount = count
+			batchBuf = batchBuf[:0]
+		}
 		checksumFailures := 0
 		first := true
 		terminated := false
+		entrySize := 0
 		fromDiskOverflow = fromDiskOverflow[:0]
 		for {
 			n, err := io.ReadFull(fp, fromDiskBuf)
+			vs.recoveryProgressRead(n, fromDiskCount)
 			if n < 4 {
 				if err != io.EOF && err != io.ErrUnexpectedEOF {
 					vs.logError("error reading %s: %s\n", names[i], err)
@@ -884,23 +1814,19 @@ func (vs *DefaultValueStore) recovery() {
 				break
 			}
 			n -= 4
-			if murmur3.Sum32(fromDiskBuf[:n]) != binary.BigEndian.Uint32(fromDiskBuf[n:]) {
+			if checksum32(h, fromDiskBuf[:n]) != binary.BigEndian.Uint32(fromDiskBuf[n:]) {
 				checksumFailures++
 			} else {
 				j := 0
 				if first {
-					if !bytes.Equal(fromDiskBuf[:28], []byte("VALUESTORETOC v0            ")) {
+					if entrySize = tocEntrySize(fromDiskBuf); entrySize == 0 {
 						vs.logError("bad header: %s\n", names[i])
 						break
 					}
-					if binary.BigEndian.Uint32(fromDiskBuf[28:]) != vs.checksumInterval {
-						vs.logError("bad header checksum interval: %s\n", names[i])
-						break
-					}
 					j += 32
 					first = false
 				}
-				if n < int(vs.checksumInterval) {
+				if n < int(interval) {
 					if binary.BigEndian.Uint32(fromDiskBuf[n-16:]) != 0 {
 						vs.logError("bad terminator size marker: %s\n", names[i])
 						break
@@ -913,49 +1839,13 @@ func (vs *DefaultValueStore) recovery() {
 					terminated = true
 				}
 				if len(fromDiskOverflow) > 0 {
-					j += 32 - len(fromDiskOverflow)
-					fromDiskOverflow = append(fromDiskOverflow, fromDiskBuf[j-32+len(fromDiskOverflow):j]...)
-					keyB := binary.BigEndian.Uint64(fromDiskOverflow[8:])
-					k := keyB % workers
-					if batches[k] == nil {
-						batches[k] = <-freeBatchChans[k]
-						batchesPos[k] = 0
-					}
-					wr := &batches[k][batchesPos[k]]
-					wr.keyA = binary.BigEndian.Uint64(fromDiskOverflow)
-					wr.keyB = keyB
-					wr.timestampbits = binary.BigEndian.Uint64(fromDiskOverflow[16:])
-					wr.blockID = vf.id
-					wr.offset = binary.BigEndian.Uint32(fromDiskOverflow[24:])
-					wr.length = binary.BigEndian.Uint32(fromDiskOverflow[28:])
-					batchesPos[k]++
-					if batchesPos[k] >= vs.recoveryBatchSize {
-						pendingBatchChans[k] <- batches[k]
-						batches[k] = nil
-					}
-					fromDiskCount++
+					j += entrySize - len(fromDiskOverflow)
+					fromDiskOverflow = append(fromDiskOverflow, fromDiskBuf[j-entrySize+len(fromDiskOverflow):j]...)
+					recoverEntry(fromDiskOverflow, entrySize)
 					fromDiskOverflow = fromDiskOverflow[:0]
 				}
-				for ; j+32 <= n; j += 32 {
-					keyB := binary.BigEndian.Uint64(fromDiskBuf[j+8:])
-					k := keyB % workers
-					if batches[k] == nil {
-						batches[k] = <-freeBatchChans[k]
-						batchesPos[k] = 0
-					}
-					wr := &batches[k][batchesPos[k]]
-					wr.keyA = binary.BigEndian.Uint64(fromDiskBuf[j:])
-					wr.keyB = keyB
-					wr.timestampbits = binary.BigEndian.Uint64(fromDiskBuf[j+16:])
-					wr.blockID = vf.id
-					wr.offset = binary.BigEndian.Uint32(fromDiskBuf[j+24:])
-					wr.length = binary.BigEndian.Uint32(fromDiskBuf[j+28:])
-					batchesPos[k]++
-					if batchesPos[k] >= vs.recoveryBatchSize {
-						pendingBatchChans[k] <- batches[k]
-						batches[k] = nil
-					}
-					fromDiskCount++
+				for ; j+entrySize <= n; j += entrySize {
+					recoverEntry(fromDiskBuf[j:], entrySize)
 				}
 				if j != n {
 					fromDiskOverflow = fromDiskOverflow[:n-j]
@@ -968,12 +1858,30 @@ func (vs *DefaultValueStore) recovery() {
 			}
 		}
 		fp.Close()
+		if vs.recoveryDropPageCache {
+			vs.dropPageCacheName(vs.tocName(namets))
+			vs.dropPageCacheName(vs.valuesName(namets))
+		}
 		if !terminated {
 			vs.logError("early end of file: %s\n", names[i])
 		}
 		if checksumFailures > 0 {
 			vs.logWarning("%d checksum failures for %s\n", checksumFailures, names[i])
 		}
+		if untrusted > 0 {
+			atomic.AddInt32(&vs.recoveryUntrusted, int32(untrusted))
+			vs.logWarning("%d entries in %s skipped as beyond the trusted part of its values file\n", untrusted, names[i])
+		}
+		if inBatch {
+			tornBatches++
+		}
+		if tornBatches > 0 {
+			atomic.AddInt32(&vs.recoveryTornBatches, int32(tornBatches))
+			vs.logWarning("%d incomplete batches in %s skipped\n", tornBatches, names[i])
+		}
+		if entries > 0 && fromDiskCount-fileCount > int(entries) {
+			vs.logWarning("%s has %d entries but its values file trailer records only %d\n", names[i], fromDiskCount-fileCount, entries)
+		}
 	}
 	for i := 0; i < len(batches); i++ {
 		if batches[i] != nil {
@@ -982,9 +1890,18 @@ func (vs *DefaultValueStore) recovery() {
 		pendingBatchChans[i] <- nil
 	}
 	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if duplicates := atomic.LoadInt32(&vs.recoveryDuplicates); duplicates > 0 {
+		vs.logWarning("%d duplicate key locations resolved during recovery\n", duplicates)
+	}
+	vs.recoveryProgressEnd(fromDiskCount)
+	vs.recoveryDuration = time.Now().Sub(start)
 	if vs.logDebug != nil {
-		dur := time.Now().Sub(start)
+		dur := vs.recoveryDuration
 		stats := vs.Stats(false).(*Stats)
 		vs.logInfo("%d key locations loaded in %s, %.0f/s; %d caused change; %d resulting locations referencing %d bytes.\n", fromDiskCount, dur, float64(fromDiskCount)/(float64(dur)/float64(time.Second)), causedChangeCount, stats.Values, stats.ValueBytes)
 	}
+	return nil
 }
@@ -0,0 +1,380 @@
+package valuestore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/gholt/brimtime.v1"
+)
+
+// newTestStore returns New(cfg) with its Path set to a temporary directory,
+// so each test starts empty; the store is closed and the directory removed
+// when the test ends.
+func newTestStore(t *testing.T, cfg *Config) *DefaultValueStore {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{}
+	if cfg != nil {
+		c = *cfg
+	}
+	c.Path = dir
+	vs := New(&c)
+	t.Cleanup(func() {
+		vs.Close()
+		os.RemoveAll(dir)
+	})
+	return vs
+}
+
+func TestNewWithContextBadPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "file")
+	if err = ioutil.WriteFile(p, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewWithContext(context.Background(), &Config{Path: path.Join(p, "sub")})
+	if err == nil {
+		t.Fatal(vs)
+	}
+	vs, err = NewWithContext(context.Background(), &Config{Path: dir, PathTOC: p})
+	if err == nil {
+		t.Fatal(vs)
+	}
+}
+
+func TestNewWithContextCreatesPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "a", "b")
+	vs, err := NewWithContext(context.Background(), &Config{Path: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs.Close()
+	if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+		t.Fatal(fi, err)
+	}
+}
+
+func TestNewWithContextCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(path.Join(dir, "1.valuestoc"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = NewWithContext(ctx, &Config{Path: dir}); err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWithContextRecoveryError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A TOC file without its values file can't be recovered.
+	if err = ioutil.WriteFile(path.Join(dir, "1.valuestoc"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewWithContext(context.Background(), &Config{Path: dir, LogWarning: func(string, ...interface{}) {}})
+	if !errors.Is(err, ErrRecovery) || !os.IsNotExist(errors.Unwrap(err)) {
+		t.Fatal(err)
+	}
+	if rerr, ok := err.(*RecoveryError); !ok || rerr.Path != path.Join(dir, "0000000000000000001.values") {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWithContextMissingValuesFileFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Path: dir, IgnoreEnv: true, LogError: func(string, ...interface{}) {}}
+	vs := New(cfg)
+	vs.EnableWrites()
+	if _, err = vs.Write(1, 2, 1000, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	vs.Close()
+	names, err := filepath.Glob(filepath.Join(dir, "*.values"))
+	if err != nil || len(names) != 1 {
+		t.Fatal(names, err)
+	}
+	if err = os.Remove(names[0]); err != nil {
+		t.Fatal(err)
+	}
+	// With the other replicas to fall back on, the values file's entries are
+	// just skipped.
+	cfg.MsgRing = &msgRingPlaceholder{}
+	cfg.RemoteReadFallback = true
+	vs, err = NewWithContext(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vs.Close()
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryMissingValuesFiles != 1 {
+		t.Fatal(stats.RecoveryMissingValuesFiles)
+	}
+	if _, _, err = vs.Read(1, 2, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWithContextErrorClosesFiles(t *testing.T) {
+	openFDs := func() int {
+		fds, err := ioutil.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip(err)
+		}
+		return len(fds)
+	}
+	dir, err := ioutil.TempDir("", "valuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Path: dir, IgnoreEnv: true, LogWarning: func(string, ...interface{}) {}}
+	for i := int64(0); i < 3; i++ {
+		vs := New(cfg)
+		vs.EnableWrites()
+		for keyB := uint64(1); keyB <= 100; keyB++ {
+			if _, err = vs.Write(1, keyB, 1000+i, []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		vs.Close()
+	}
+	before := openFDs()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = NewWithContext(ctx, cfg); err != context.Canceled {
+		t.Fatal(err)
+	}
+	// The newest values file goes missing, so recovery fails after the
+	// others have been opened.
+	names, err := filepath.Glob(filepath.Join(dir, "*.values"))
+	if err != nil || len(names) != 3 {
+		t.Fatal(names, err)
+	}
+	if err = os.Remove(names[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewWithContext(context.Background(), cfg); !errors.Is(err, ErrRecovery) {
+		t.Fatal(err)
+	}
+	if after := openFDs(); after != before {
+		t.Fatal(before, after)
+	}
+	// Nor is anything left locked.
+	if err = os.Remove(strings.TrimSuffix(names[2], ".values") + ".valuestoc"); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := NewWithContext(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs.Close()
+}
+
+func TestWriteTimestampLimits(t *testing.T) {
+	vs := newTestStore(t, &Config{MaxFutureTimestamp: 60, MaxPastTimestamp: 3600})
+	vs.EnableWrites()
+	now := brimtime.TimeToUnixMicro(time.Now())
+	if _, err := vs.Write(1, 2, now, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := vs.Write(1, 2, now+int64(2*time.Minute/time.Microsecond), []byte("testing"))
+	if terr, ok := err.(*TimestampError); !ok || terr.Timestampmicro <= terr.Nowmicro {
+		t.Fatal(err)
+	}
+	_, err = vs.Delete(1, 2, now-int64(2*time.Hour/time.Microsecond))
+	if terr, ok := err.(*TimestampError); !ok || terr.Timestampmicro >= terr.Nowmicro {
+		t.Fatal(err)
+	}
+	// Within the limits, though older than the value written above.
+	if _, err = vs.Delete(1, 2, now-int64(time.Minute/time.Microsecond)); err != ErrNewerVersion {
+		t.Fatal(err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.TimestampRejections != 2 || stats.WriteErrors != 1 || stats.DeleteErrors != 1 {
+		t.Fatal(stats.TimestampRejections, stats.WriteErrors, stats.DeleteErrors)
+	}
+	// Without limits, anything valid goes.
+	vs = newTestStore(t, nil)
+	vs.EnableWrites()
+	if _, err = vs.Write(1, 2, TIMESTAMPMICRO_MAX, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteMonotonic(t *testing.T) {
+	vs := newTestStore(t, &Config{MonotonicWrites: true})
+	vs.EnableWrites()
+	ts, err := vs.Write(1, 2, 1000, []byte("first"))
+	if err != nil || ts != 1000 {
+		t.Fatal(ts, err)
+	}
+	// Same and older timestamps are raised just past the stored one.
+	if ts, err = vs.Write(1, 2, 1000, []byte("second")); err != nil || ts != 1001 {
+		t.Fatal(ts, err)
+	}
+	if ts, err = vs.Write(1, 2, 500, []byte("third")); err != nil || ts != 1002 {
+		t.Fatal(ts, err)
+	}
+	if ts, err = vs.Write(1, 2, 2000, []byte("fourth")); err != nil || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	ts, v, err := vs.Read(1, 2, nil)
+	if err != nil || ts != 2000 || string(v) != "fourth" {
+		t.Fatal(ts, string(v), err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.WritesBumped != 2 || stats.WritesOverridden != 0 {
+		t.Fatal(stats.WritesBumped, stats.WritesOverridden)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestorelookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir})
+	vs.EnableWrites()
+	defer vs.Close()
+	if ts, length, err := vs.Lookup(1, 2); err != ErrNotFound || ts != 0 || length != 0 {
+		t.Fatal(ts, length, err)
+	}
+	if _, err := vs.Write(1, 2, 1000, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, length, err := vs.Lookup(1, 2); err != nil || ts != 1000 || length != 7 {
+		t.Fatal(ts, length, err)
+	}
+	// A deletion marker is not found, but its timestamp is still given.
+	if _, err := vs.Delete(1, 2, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if ts, length, err := vs.Lookup(1, 2); err != ErrNotFound || ts != 2000 || length != 0 {
+		t.Fatal(ts, length, err)
+	}
+	// A value handed off elsewhere is as good as not known at all.
+	if _, err := vs.write(3, 4, 0x300|_TSB_LOCAL_REMOVAL, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, length, err := vs.Lookup(3, 4); err != ErrNotFound || ts != 0 || length != 0 {
+		t.Fatal(ts, length, err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.Lookups != 4 || stats.LookupErrors != 3 {
+		t.Fatal(stats.Lookups, stats.LookupErrors)
+	}
+}
+
+func TestDeleteNewerVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestoredelete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir})
+	vs.EnableWrites()
+	defer vs.Close()
+	if _, err := vs.Write(1, 2, 2000, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := vs.Delete(1, 2, 1000); err != ErrNewerVersion || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	if ts, _, err := vs.Read(1, 2, nil); err != nil || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	// The same timestamp is no newer; the delete wins.
+	if ts, err := vs.Delete(1, 2, 2000); err != nil || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	if _, err := vs.Delete(1, 2, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.DeletesOverridden != 3 || stats.DeleteErrors != 0 {
+		t.Fatal(stats.DeletesOverridden, stats.DeleteErrors)
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "valuestoredeleteif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir, Workers: 2})
+	vs.EnableWrites()
+	defer vs.Close()
+	if ts, err := vs.DeleteIf(1, 2, 1000, 0); err != nil || ts != 0 {
+		t.Fatal(ts, err)
+	}
+	if _, err := vs.Write(1, 2, 2000, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := vs.DeleteIf(1, 2, 3000, 1000); err != ErrNewerVersion || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	if ts, _, err := vs.Read(1, 2, nil); err != nil || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	if ts, err := vs.DeleteIf(1, 2, 3000, 2000); err != nil || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	if ts, _, err := vs.Read(1, 2, nil); err != ErrNotFound || ts != 3000 {
+		t.Fatal(ts, err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.Deletes != 3 || stats.DeletesOverridden != 1 || stats.DeleteErrors != 0 {
+		t.Fatal(stats.Deletes, stats.DeletesOverridden, stats.DeleteErrors)
+	}
+	// A batch is written by the memWriter of its first key, here not the
+	// one of keyA 1, but still waits on the key's keyLock, as held from
+	// DeleteIf's check through its write.
+	lock := vs.keyLock(1)
+	lock.Lock()
+	b := vs.NewWriteBatch()
+	b.Write(2, 1, []byte("testing"))
+	b.Write(1, 2, []byte("testing"))
+	done := make(chan error)
+	go func() {
+		done <- b.Commit(4000)
+	}()
+	select {
+	case err := <-done:
+		t.Fatal("batch written while the key was locked", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	lock.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if ts, _, err := vs.Read(1, 2, nil); err != nil || ts != 4000 {
+		t.Fatal(ts, err)
+	}
+}
@@ -0,0 +1,365 @@
+package valuestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VerifyReport is returned by VerifyFiles.
+type VerifyReport struct {
+	// Files has a VerifyFileReport for each TOC file, in name order.
+	Files []*VerifyFileReport
+	// OrphanValuesFiles are the values files without a TOC file; their values
+	// are unreachable.
+	OrphanValuesFiles []string
+}
+
+// Problems returns the number of problems found across all the files.
+func (r *VerifyReport) Problems() int {
+	problems := len(r.OrphanValuesFiles)
+	for _, f := range r.Files {
+		problems += f.Problems()
+	}
+	return problems
+}
+
+// VerifyFileReport describes what VerifyFiles found for one TOC file and its
+// values file.
+type VerifyFileReport struct {
+	TOCPath    string
+	ValuesPath string
+	// Err is set if the files could not be read at all, in which case the
+	// rest of the report is incomplete.
+	Err error
+	// Entries is the number of TOC entries read, batch markers included.
+	Entries int
+	// ValuesFileMissing is set for a TOC file without its values file; all
+	// its entries are orphaned.
+	ValuesFileMissing bool
+	// ValuesFileDirty is set for a values file without a valid trailer, such
+	// as one being written when the process died; only its leading run of
+	// blocks with good checksums can be trusted.
+	ValuesFileDirty bool
+	// ValuesFileTrusted is how far into the values file, in offset terms, its
+	// contents can be trusted.
+	ValuesFileTrusted uint64
+	// BadHeader is set for a TOC file whose header isn't recognized; none of
+	// its entries can be read.
+	BadHeader bool
+	// ChecksumFailures is the number of TOC file blocks with bad checksums.
+	ChecksumFailures int
+	// Truncated is set for a TOC file without its terminator, such as one
+	// being written when the process died.
+	Truncated bool
+	// Orphaned is the number of TOC entries whose value lies beyond the
+	// trusted part of the values file.
+	Orphaned int
+	// BadLengths is the number of TOC entries with a length larger than a
+	// value could be stored with.
+	BadLengths int
+	// RepairedAt is, with repair, the length the TOC file was truncated to at
+	// its first checksum failure; 0 if it was left alone.
+	RepairedAt int64
+}
+
+// Problems returns the number of problems found with the files.
+func (f *VerifyFileReport) Problems() int {
+	problems := f.ChecksumFailures + f.Orphaned + f.BadLengths
+	for _, b := range []bool{f.Err != nil, f.ValuesFileMissing, f.ValuesFileDirty, f.BadHeader, f.Truncated} {
+		if b {
+			problems++
+		}
+	}
+	return problems
+}
+
+// VerifyFiles checks the values and TOC files in the Paths and PathsTOC the
+// Config describes, cross-checking every TOC entry against its values file,
+// and returns what it found. It is meant for stores not currently open; the
+// files of an open store that are still being written will show as dirty and
+// truncated.
+//
+// With repair, each unencrypted TOC file with a checksum failure is truncated
+// just before the failing block, so recovery stops there rather than reading
+// on past the corruption; everything else is only reported, recovery already
+// skipping entries beyond the trusted part of a values file. An error is
+// returned if any of the Paths or PathsTOC can't be read.
+func VerifyFiles(c *Config, repair bool) (*VerifyReport, error) {
+	vs, cfg, err := newVerifyStore(c)
+	if err != nil {
+		return nil, err
+	}
+	tocs, values, err := verifyFileNames(cfg)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{}
+	for namets, name := range values {
+		if _, ok := tocs[namets]; !ok {
+			report.OrphanValuesFiles = append(report.OrphanValuesFiles, name)
+		}
+	}
+	sort.Strings(report.OrphanValuesFiles)
+	for namets, name := range tocs {
+		report.Files = append(report.Files, vs.verifyFile(cfg, namets, name, values[namets], repair, nil))
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].TOCPath < report.Files[j].TOCPath })
+	return report, nil
+}
+
+// TOCEntry is a TOC file entry as read by InspectFile.
+type TOCEntry struct {
+	KeyA uint64
+	KeyB uint64
+	// Timestampmicro is 0 for a batch marker, which has no value.
+	Timestampmicro int64
+	Deletion       bool
+	Offset         uint64
+	Length         uint32
+	// Orphaned and BadLength are set for the entries VerifyFileReport counts
+	// as Orphaned and BadLengths.
+	Orphaned  bool
+	BadLength bool
+}
+
+// InspectFile is VerifyFiles for just the TOC file and values file with the
+// given ID, as in ValuesFileStats, also calling entry, if not nil, with each
+// of the TOC file's entries as they are read. ErrNotFound is returned if
+// there is no such TOC file.
+func InspectFile(c *Config, id int64, entry func(e *TOCEntry)) (*VerifyFileReport, error) {
+	vs, cfg, err := newVerifyStore(c)
+	if err != nil {
+		return nil, err
+	}
+	tocs, values, err := verifyFileNames(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tocs[id] == "" {
+		return nil, ErrNotFound
+	}
+	return vs.verifyFile(cfg, id, tocs[id], values[id], false, entry), nil
+}
+
+// newVerifyStore returns a DefaultValueStore with just enough set up to read
+// the files of the Config, without opening the store.
+func newVerifyStore(c *Config) (*DefaultValueStore, *Config, error) {
+	cfg := resolveConfig(c)
+	vs := &DefaultValueStore{
+		path:             cfg.Path,
+		pathtoc:          cfg.PathTOC,
+		fileBackend:      cfg.FileBackend,
+		valueCap:         uint32(cfg.ValueCap),
+		checksumInterval: uint32(cfg.ChecksumInterval),
+	}
+	if err := vs.encryptionConfig(cfg); err != nil {
+		return nil, nil, err
+	}
+	vs.pathsState.dirs = make(map[int64][2]string)
+	return vs, cfg, nil
+}
+
+// verifyFileNames returns the TOC files and values files of the Config by
+// their IDs.
+func verifyFileNames(cfg *Config) (map[int64]string, map[int64]string, error) {
+	names := func(dirs []string, suffix string) (map[int64]string, error) {
+		m := map[int64]string{}
+		for _, dir := range dirs {
+			fp, err := os.Open(dir)
+			if err != nil {
+				return nil, err
+			}
+			all, err := fp.Readdirnames(-1)
+			fp.Close()
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range all {
+				if strings.HasSuffix(name, suffix) {
+					if namets, err := strconv.ParseInt(strings.TrimSuffix(name, suffix), 10, 64); err == nil && namets != 0 {
+						m[namets] = path.Join(dir, name)
+					}
+				}
+			}
+		}
+		return m, nil
+	}
+	tocs, err := names(uniquePaths(cfg.PathsTOC), ".valuestoc")
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := names(uniquePaths(cfg.Paths), ".values")
+	if err != nil {
+		return nil, nil, err
+	}
+	return tocs, values, nil
+}
+
+// verifyFile returns the report for the TOC file tocName and its values file
+// valuesName, which is "" if missing.
+func (vs *DefaultValueStore) verifyFile(cfg *Config, namets int64, tocName string, valuesName string, repair bool, entry func(e *TOCEntry)) *VerifyFileReport {
+	f := &VerifyFileReport{
+		TOCPath:    tocName,
+		ValuesPath: valuesName,
+	}
+	if f.ValuesPath == "" {
+		f.ValuesPath = path.Join(cfg.Path, fmt.Sprintf("%019d.values", namets))
+		f.ValuesFileMissing = true
+	} else {
+		vs.pathsSet(namets, path.Dir(f.ValuesPath), path.Dir(f.TOCPath))
+		vs.verifyValuesFile(f, namets)
+	}
+	if f.Err == nil {
+		vs.verifyTOCFile(f, repair, entry)
+	}
+	return f
+}
+
+// verifyValuesFile fills in what the values file's own checks give.
+func (vs *DefaultValueStore) verifyValuesFile(f *VerifyFileReport, namets int64) {
+	vf := &valuesFile{vs: vs, bts: namets, checksumInterval: vs.checksumInterval}
+	fp, err := vs.openFile(f.ValuesPath)
+	if err != nil {
+		f.Err = err
+		return
+	}
+	vf.checksumInterval, vf.newHash, err = readChecksumHeader(fp)
+	fp.Close()
+	if err != nil {
+		f.Err = fmt.Errorf("%s: %s", f.ValuesPath, err)
+		return
+	}
+	_, f.ValuesFileTrusted, f.ValuesFileDirty = vf.check(vs.openValuesReadSeeker)
+}
+
+// verifyTOCFile reads the TOC file as recovery does, checking each entry
+// against the values file and passing it to each, if not nil.
+func (vs *DefaultValueStore) verifyTOCFile(f *VerifyFileReport, repair bool, each func(e *TOCEntry)) {
+	fp, err := vs.openFile(f.TOCPath)
+	if err != nil {
+		f.Err = err
+		return
+	}
+	defer fp.Close()
+	interval, newHash, err := readChecksumHeader(fp)
+	if err != nil {
+		f.Err = fmt.Errorf("%s: %s", f.TOCPath, err)
+		return
+	}
+	h := newHash()
+	buf := make([]byte, interval+4)
+	overflow := make([]byte, 0, _TOC_ENTRY_SIZE_MAX)
+	// The largest a stored value can be is ValueCap with the codec byte and
+	// uvarint length compression frames it with.
+	maxLength := uint64(vs.valueCap) + 1 + binary.MaxVarintLen64
+	entrySize := 0
+	entry := func(b []byte) {
+		f.Entries++
+		timestampbits := binary.BigEndian.Uint64(b[16:])
+		var e TOCEntry
+		// Batch markers have no value.
+		if timestampbits>>_TSB_UTIL_BITS != 0 {
+			e.Offset, e.Length = tocEntryLocation(b, entrySize)
+			if uint64(e.Length) > maxLength {
+				f.BadLengths++
+				e.BadLength = true
+			} else if f.ValuesFileMissing || e.Offset+uint64(e.Length) > f.ValuesFileTrusted {
+				f.Orphaned++
+				e.Orphaned = true
+			}
+		}
+		if each != nil {
+			e.KeyA = binary.BigEndian.Uint64(b)
+			e.KeyB = binary.BigEndian.Uint64(b[8:])
+			e.Timestampmicro = int64(timestampbits >> _TSB_UTIL_BITS)
+			e.Deletion = timestampbits&_TSB_DELETION != 0
+			each(&e)
+		}
+	}
+	var block int64
+	firstFailure := int64(-1)
+	lost := false
+	for ; ; block++ {
+		n, err := io.ReadFull(fp, buf)
+		if n < 4 {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				f.Err = fmt.Errorf("%s: %s", f.TOCPath, err)
+			}
+			f.Truncated = true
+			break
+		}
+		n -= 4
+		if checksum32(h, buf[:n]) != binary.BigEndian.Uint32(buf[n:]) {
+			f.ChecksumFailures++
+			if block == 0 {
+				// Without the header, the entry size isn't known.
+				f.BadHeader = true
+				return
+			}
+			if firstFailure < 0 {
+				firstFailure = block
+			}
+			// Entries spanning into a bad block are lost too.
+			overflow = overflow[:0]
+			lost = true
+			if n < int(interval) {
+				f.Truncated = true
+				break
+			}
+			continue
+		}
+		j := 0
+		if block == 0 {
+			if entrySize = tocEntrySize(buf); entrySize == 0 {
+				f.BadHeader = true
+				return
+			}
+			j = 32
+		} else if lost {
+			// The entries follow the header with no regard for the blocks, so
+			// the first to start in this one is found from its position.
+			j = (entrySize - int((block*int64(interval)-32)%int64(entrySize))) % entrySize
+			lost = false
+		}
+		last := n < int(interval)
+		if last {
+			if n < 16 || binary.BigEndian.Uint32(buf[n-16:]) != 0 || !bytes.Equal(buf[n-4:n], []byte("TERM")) {
+				f.Truncated = true
+			} else {
+				n -= 16
+			}
+		}
+		if len(overflow) > 0 && j+entrySize-len(overflow) <= n {
+			k := j + entrySize - len(overflow)
+			overflow = append(overflow, buf[j:k]...)
+			entry(overflow)
+			overflow = overflow[:0]
+			j = k
+		}
+		for ; j+entrySize <= n; j += entrySize {
+			entry(buf[j:])
+		}
+		overflow = append(overflow[:0], buf[j:n]...)
+		if last || err != nil {
+			break
+		}
+	}
+	if repair && firstFailure > 0 {
+		if keyID, err := encryptionKeyID(f.TOCPath); err != nil || keyID != 0 {
+			return
+		}
+		at := firstFailure * int64(interval+4)
+		if err := os.Truncate(f.TOCPath, at); err != nil {
+			f.Err = err
+			return
+		}
+		f.RepairedAt = at
+	}
+}
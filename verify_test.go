@@ -0,0 +1,119 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVerifyFiles(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 200)
+	vs.Close()
+	report, err := VerifyFiles(h.cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 1 || report.Problems() != 0 || report.Files[0].Entries < 200 {
+		t.Fatalf("%#v", report.Files[0])
+	}
+	// A corrupted TOC block is found and, with repair, cut off.
+	name := report.Files[0].TOCPath
+	fp, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fp.WriteAt([]byte("corrupt"), 2*1028+100); err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	if report, err = VerifyFiles(h.cfg, true); err != nil {
+		t.Fatal(err)
+	}
+	f := report.Files[0]
+	if f.ChecksumFailures != 1 || f.Truncated || f.Orphaned != 0 || f.RepairedAt != 2*1028 {
+		t.Fatalf("%#v", f)
+	}
+	entries := f.Entries
+	if report, err = VerifyFiles(h.cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	if f = report.Files[0]; f.ChecksumFailures != 0 || !f.Truncated || f.Entries >= entries {
+		t.Fatalf("%#v", f)
+	}
+	// Values cut off the end of the values file leave entries orphaned.
+	h.truncate(f.ValuesPath, 0.1)
+	if report, err = VerifyFiles(h.cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	if f = report.Files[0]; !f.ValuesFileDirty || f.Orphaned == 0 {
+		t.Fatalf("%#v", f)
+	}
+	vs = h.open()
+	defer vs.Close()
+	if readable := h.verify(vs); readable == 0 || readable >= len(h.values) {
+		t.Fatal(readable)
+	}
+}
+
+func TestInspectFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspectfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Path: dir, IgnoreEnv: true}
+	vs := New(cfg)
+	vs.EnableWrites()
+	for keyB := uint64(1); keyB <= 10; keyB++ {
+		if _, err = vs.Write(1, keyB, int64(1000+keyB), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs.Flush()
+	if _, err = vs.Delete(1, 5, 2000); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	files := vs.ListFiles()
+	vs.Close()
+	var entries []TOCEntry
+	for _, file := range files {
+		f, err := InspectFile(cfg, file.ID, func(e *TOCEntry) {
+			if e.Timestampmicro != 0 {
+				entries = append(entries, *e)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Problems() != 0 {
+			t.Fatalf("%#v", f)
+		}
+	}
+	if len(entries) != 11 {
+		t.Fatal(len(entries))
+	}
+	deletions := 0
+	for _, e := range entries {
+		if e.KeyA != 1 || e.KeyB < 1 || e.KeyB > 10 || e.Orphaned || e.BadLength {
+			t.Fatalf("%#v", e)
+		}
+		if e.Deletion {
+			deletions++
+			if e.KeyB != 5 || e.Timestampmicro != 2000 {
+				t.Fatalf("%#v", e)
+			}
+		} else if e.Timestampmicro != int64(1000+e.KeyB) || e.Length == 0 {
+			t.Fatalf("%#v", e)
+		}
+	}
+	if deletions != 1 {
+		t.Fatal(deletions)
+	}
+	if _, err = InspectFile(cfg, files[len(files)-1].ID+1, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
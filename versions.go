@@ -0,0 +1,296 @@
+package valuestore
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const _VERSIONS_SHARDS = 64
+
+// versionsState keeps, with Config.RetainVersions, the locations of the older
+// versions of each key alongside the location map, which only has the
+// current one. Older versions keep their TOC entries and are rewritten by
+// compaction like current values, so they survive restarts; those beyond the
+// count are dropped as newer versions arrive and their space is reclaimed by
+// compaction as any stale value's is.
+//
+// Changes to the location map of a key and to its older versions are made
+// under the key's shard lock, so a version can't be lost between the two.
+type versionsState struct {
+	// retain is the number of older versions to keep; 0 if disabled.
+	retain int
+	shards [_VERSIONS_SHARDS]versionsShard
+}
+
+type versionsShard struct {
+	lock sync.Mutex
+	// versions has each key's older versions, newest first.
+	versions map[bulkSetKey][]versionLoc
+}
+
+type versionLoc struct {
+	timestampbits uint64
+	blockID       uint32
+	offset        uint32
+	length        uint32
+}
+
+// Version describes one version of a key, as given by ListVersions.
+type Version struct {
+	Timestampmicro int64
+	// Length is the stored length of the value; see Lookup.
+	Length uint32
+	// Deleted is set for a deletion marker, which has no value.
+	Deleted bool
+}
+
+func (vs *DefaultValueStore) versionsConfig(cfg *Config) {
+	vs.versionsState.retain = cfg.RetainVersions - 1
+	for i := range vs.versionsState.shards {
+		vs.versionsState.shards[i].versions = map[bulkSetKey][]versionLoc{}
+	}
+}
+
+func (vs *DefaultValueStore) versionsShard(keyA uint64) *versionsShard {
+	return &vs.versionsState.shards[keyA%_VERSIONS_SHARDS]
+}
+
+// versionsSet is the vlm.Set of a new value's location. A compaction rewrite
+// of the current version takes the new location even if its timestampbits are
+// those stored, as they are when the entry rewritten is itself an earlier
+// rewrite; otherwise the key would be left in the file being compacted away.
+// With retention, the version it replaces is kept, and a compaction rewrite
+// of a kept version, which isn't current, moves the kept version to the new
+// location instead. The second value returned says whether either was done,
+// in which case the value is to be stored though vlm.Set had it as no newer.
+// A local removal, handing the key off or expiring its deletion marker, drops
+// the key's older versions.
+func (vs *DefaultValueStore) versionsSet(keyA uint64, keyB uint64, timestampbits uint64, blockID uint32, offset uint32, length uint32) (uint64, bool) {
+	rewrite := timestampbits&(_TSB_COMPACTION_REWRITE|_TSB_LOCAL_REMOVAL) == _TSB_COMPACTION_REWRITE
+	if vs.versionsState.retain == 0 {
+		ptimestampbits := vs.vlm.Set(keyA, keyB, timestampbits, blockID, offset, length, rewrite)
+		return ptimestampbits, rewrite && ptimestampbits == timestampbits
+	}
+	key := bulkSetKey{keyA: keyA, keyB: keyB}
+	shard := vs.versionsShard(keyA)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	ptimestampbits, pblockID, poffset, plength := vs.vlm.Get(keyA, keyB)
+	ptimestampbits = vs.vlm.Set(keyA, keyB, timestampbits, blockID, offset, length, rewrite)
+	if timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+		if ptimestampbits < timestampbits {
+			delete(shard.versions, key)
+		}
+		return ptimestampbits, false
+	}
+	if ptimestampbits < timestampbits {
+		if pblockID != 0 && ptimestampbits>>_TSB_UTIL_BITS < timestampbits>>_TSB_UTIL_BITS {
+			shard.add(vs.versionsState.retain, key, versionLoc{timestampbits: ptimestampbits, blockID: pblockID, offset: poffset, length: plength})
+		}
+		return ptimestampbits, false
+	}
+	if rewrite && ptimestampbits == timestampbits {
+		return ptimestampbits, true
+	}
+	if timestampbits&_TSB_COMPACTION_REWRITE != 0 && ptimestampbits>>_TSB_UTIL_BITS != timestampbits>>_TSB_UTIL_BITS {
+		return ptimestampbits, shard.move(key, timestampbits, blockID, offset, length)
+	}
+	return ptimestampbits, false
+}
+
+// versionsMove is the vlm.Set memClearer makes as a value moves from its
+// valuesMem to its values file. The second value returned says whether the
+// value was a kept older version, moved instead, whose TOC entry is then to be
+// written though vlm.Set had it as older.
+func (vs *DefaultValueStore) versionsMove(keyA uint64, keyB uint64, timestampbits uint64, blockID uint32, offset uint32, length uint32) (uint64, bool) {
+	if vs.versionsState.retain == 0 || blockID == 0 {
+		return vs.vlm.Set(keyA, keyB, timestampbits, blockID, offset, length, true), false
+	}
+	shard := vs.versionsShard(keyA)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	ptimestampbits := vs.vlm.Set(keyA, keyB, timestampbits, blockID, offset, length, true)
+	if ptimestampbits>>_TSB_UTIL_BITS == timestampbits>>_TSB_UTIL_BITS {
+		return ptimestampbits, false
+	}
+	return ptimestampbits, shard.move(bulkSetKey{keyA: keyA, keyB: keyB}, timestampbits, blockID, offset, length)
+}
+
+// versionsRecover is the vlm.Set recovery makes for each TOC entry; recovery
+// gives the entries of a key to the same worker, so the older versions are
+// found in whatever order the entries are read.
+func (vs *DefaultValueStore) versionsRecover(keyA uint64, keyB uint64, timestampbits uint64, blockID uint32, offset uint32, length uint32) uint64 {
+	if vs.versionsState.retain == 0 || blockID == 0 {
+		return vs.vlm.Set(keyA, keyB, timestampbits, blockID, offset, length, false)
+	}
+	key := bulkSetKey{keyA: keyA, keyB: keyB}
+	shard := vs.versionsShard(keyA)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	ptimestampbits, pblockID, poffset, plength := vs.vlm.Get(keyA, keyB)
+	ptimestampbits = vs.vlm.Set(keyA, keyB, timestampbits, blockID, offset, length, false)
+	if ptimestampbits>>_TSB_UTIL_BITS < timestampbits>>_TSB_UTIL_BITS {
+		if pblockID != 0 {
+			shard.add(vs.versionsState.retain, key, versionLoc{timestampbits: ptimestampbits, blockID: pblockID, offset: poffset, length: plength})
+		}
+	} else if ptimestampbits>>_TSB_UTIL_BITS > timestampbits>>_TSB_UTIL_BITS {
+		shard.add(vs.versionsState.retain, key, versionLoc{timestampbits: timestampbits, blockID: blockID, offset: offset, length: length})
+	}
+	return ptimestampbits
+}
+
+// versionsKept returns whether the TOC entry for keyA, keyB at timestampbits,
+// which isn't current, is a kept older version located in the values file
+// being compacted.
+func (vs *DefaultValueStore) versionsKept(keyA uint64, keyB uint64, timestampbits uint64, valuesFileID uint32) bool {
+	if vs.versionsState.retain == 0 {
+		return false
+	}
+	loc, ok := vs.versionsGet(keyA, keyB, int64(timestampbits>>_TSB_UTIL_BITS))
+	return ok && vs.inValuesFile(loc.blockID, valuesFileID)
+}
+
+// versionsCompact rewrites, for compaction, the TOC entry for keyA, keyB at
+// timestampbits if it is a kept older version located in the values file
+// being compacted, returning whether it was.
+func (vs *DefaultValueStore) versionsCompact(keyA uint64, keyB uint64, timestampbits uint64, valuesFileID uint32) (bool, error) {
+	if !vs.versionsKept(keyA, keyB, timestampbits, valuesFileID) {
+		return false, nil
+	}
+	ts, value, err := vs.readVersion(keyA, keyB, int64(timestampbits>>_TSB_UTIL_BITS), nil)
+	if ts == 0 {
+		// Dropped since.
+		return false, nil
+	}
+	if err != nil && err != ErrNotFound {
+		return true, err
+	}
+	vs.compactionThrottle(len(value))
+	_, err = vs.write(keyA, keyB, ts|_TSB_COMPACTION_REWRITE, value)
+	return true, err
+}
+
+// versionsGet returns the location of keyA, keyB's kept older version at
+// timestampmicro.
+func (vs *DefaultValueStore) versionsGet(keyA uint64, keyB uint64, timestampmicro int64) (versionLoc, bool) {
+	shard := vs.versionsShard(keyA)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	for _, loc := range shard.versions[bulkSetKey{keyA: keyA, keyB: keyB}] {
+		if int64(loc.timestampbits>>_TSB_UTIL_BITS) == timestampmicro {
+			return loc, true
+		}
+	}
+	return versionLoc{}, false
+}
+
+// add keeps loc as an older version of key, dropping the oldest beyond
+// retain; a version already kept with the same timestamp stays as is.
+func (shard *versionsShard) add(retain int, key bulkSetKey, loc versionLoc) {
+	versions := shard.versions[key]
+	i := 0
+	for ; i < len(versions); i++ {
+		if versions[i].timestampbits>>_TSB_UTIL_BITS == loc.timestampbits>>_TSB_UTIL_BITS {
+			return
+		}
+		if versions[i].timestampbits < loc.timestampbits {
+			break
+		}
+	}
+	if i >= retain {
+		return
+	}
+	versions = append(versions, versionLoc{})
+	copy(versions[i+1:], versions[i:])
+	versions[i] = loc
+	if len(versions) > retain {
+		versions = versions[:retain]
+	}
+	shard.versions[key] = versions
+}
+
+// move updates the location of key's kept older version at timestampbits,
+// returning false if there is no such version.
+func (shard *versionsShard) move(key bulkSetKey, timestampbits uint64, blockID uint32, offset uint32, length uint32) bool {
+	versions := shard.versions[key]
+	for i := range versions {
+		if versions[i].timestampbits>>_TSB_UTIL_BITS == timestampbits>>_TSB_UTIL_BITS {
+			versions[i].blockID = blockID
+			versions[i].offset = offset
+			versions[i].length = length
+			return true
+		}
+	}
+	return false
+}
+
+// ListVersions returns the versions kept of keyA, keyB, newest first: the
+// current one, deletion markers included, followed with
+// Config.RetainVersions by the older ones still kept. A key not known, or
+// handed off to its proper replicas, has none.
+func (vs *DefaultValueStore) ListVersions(keyA uint64, keyB uint64) []Version {
+	shard := vs.versionsShard(keyA)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	timestampbits, id, _, length := vs.vlm.Get(keyA, keyB)
+	if id == 0 || timestampbits&_TSB_LOCAL_REMOVAL != 0 {
+		return nil
+	}
+	older := shard.versions[bulkSetKey{keyA: keyA, keyB: keyB}]
+	versions := make([]Version, 0, 1+len(older))
+	versions = append(versions, Version{Timestampmicro: int64(timestampbits >> _TSB_UTIL_BITS), Length: length, Deleted: timestampbits&_TSB_DELETION != 0})
+	for _, loc := range older {
+		versions = append(versions, Version{Timestampmicro: int64(loc.timestampbits >> _TSB_UTIL_BITS), Length: loc.length, Deleted: loc.timestampbits&_TSB_DELETION != 0})
+	}
+	return versions
+}
+
+// ReadVersion is Read of the version of keyA, keyB with exactly
+// timestampmicro, which may be the current one or, with
+// Config.RetainVersions, an older one still kept; see ListVersions. As with
+// Read, ErrNotFound with timestampmicro returned indicates the version is a
+// deletion marker, and with 0 that there is no such version. Only the local
+// copy is read.
+func (vs *DefaultValueStore) ReadVersion(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (int64, []byte, error) {
+	atomic.AddInt32(&vs.reads, 1)
+	timestampbits, value, err := vs.readVersion(keyA, keyB, timestampmicro, value)
+	if err != nil {
+		atomic.AddInt32(&vs.readErrors, 1)
+	}
+	return int64(timestampbits >> _TSB_UTIL_BITS), value, err
+}
+
+func (vs *DefaultValueStore) readVersion(keyA uint64, keyB uint64, timestampmicro int64, value []byte) (uint64, []byte, error) {
+	for {
+		timestampbits, id, _, _ := vs.vlm.Get(keyA, keyB)
+		if id != 0 && timestampbits&_TSB_LOCAL_REMOVAL == 0 && int64(timestampbits>>_TSB_UTIL_BITS) == timestampmicro {
+			timestampbits, value2, err := vs.read(keyA, keyB, value)
+			if int64(timestampbits>>_TSB_UTIL_BITS) == timestampmicro {
+				return timestampbits, value2, err
+			}
+			// Replaced while being read; it is an older version now.
+			continue
+		}
+		loc, ok := vs.versionsGet(keyA, keyB, timestampmicro)
+		if !ok {
+			return 0, value, ErrNotFound
+		}
+		if loc.timestampbits&_TSB_DELETION != 0 {
+			return loc.timestampbits, value, ErrNotFound
+		}
+		vm, ok := vs.valueLocBlock(loc.blockID).(*valuesMem)
+		if !ok {
+			return vs.valueLocBlock(loc.blockID).read(keyA, keyB, loc.timestampbits, loc.offset, loc.length, value)
+		}
+		// The valuesMem is cleared for reuse once the version has moved on to
+		// its values file, so the location is checked again while the
+		// valuesMem is held.
+		vm.discardLock.RLock()
+		if loc2, ok := vs.versionsGet(keyA, keyB, timestampmicro); ok && loc2 == loc {
+			value, err := vm.readAt(loc.offset, loc.length, value)
+			vm.discardLock.RUnlock()
+			return loc.timestampbits, value, err
+		}
+		vm.discardLock.RUnlock()
+	}
+}
@@ -0,0 +1,107 @@
+package valuestore
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// versionsCheck checks that keyA 1, keyB 1 has versions 1004, 1003 and 1002
+// and that each reads back as written by versionsWorkload.
+func versionsCheck(t *testing.T, vs *DefaultValueStore) {
+	expected := []Version{{Timestampmicro: 1004, Length: 2}, {Timestampmicro: 1003, Length: 2}, {Timestampmicro: 1002, Length: 2}}
+	if versions := vs.ListVersions(1, 1); !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("%#v", versions)
+	}
+	for ts := int64(1002); ts <= 1004; ts++ {
+		if ts2, value, err := vs.ReadVersion(1, 1, ts, nil); err != nil || ts2 != ts || string(value) != fmt.Sprintf("v%d", ts-1000) {
+			t.Fatal(ts, ts2, string(value), err)
+		}
+	}
+	if ts, _, err := vs.ReadVersion(1, 1, 1001, nil); err != ErrNotFound || ts != 0 {
+		t.Fatal(ts, err)
+	}
+}
+
+func versionsWorkload(t *testing.T, vs *DefaultValueStore) {
+	for ts := int64(1001); ts <= 1004; ts++ {
+		if _, err := vs.Write(1, 1, ts, []byte(fmt.Sprintf("v%d", ts-1000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRetainVersions(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	h.cfg.RetainVersions = 3
+	vs := h.open()
+	versionsWorkload(t, vs)
+	versionsCheck(t, vs)
+	vs.Flush()
+	versionsCheck(t, vs)
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	versionsCheck(t, vs)
+	if _, err := vs.Delete(1, 1, 1005); err != nil {
+		t.Fatal(err)
+	}
+	if versions := vs.ListVersions(1, 1); len(versions) != 3 || !versions[0].Deleted || versions[0].Timestampmicro != 1005 || versions[2].Timestampmicro != 1003 {
+		t.Fatalf("%#v", versions)
+	}
+	if ts, _, err := vs.ReadVersion(1, 1, 1005, nil); err != ErrNotFound || ts != 1005 {
+		t.Fatal(ts, err)
+	}
+	if _, value, err := vs.ReadVersion(1, 1, 1003, nil); err != nil || string(value) != "v3" {
+		t.Fatal(string(value), err)
+	}
+	if versions := vs.ListVersions(1, 2); versions != nil {
+		t.Fatalf("%#v", versions)
+	}
+}
+
+func TestRetainVersionsCompaction(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	h.cfg.RetainVersions = 3
+	vs := h.open()
+	versionsWorkload(t, vs)
+	vs.Close()
+	vs = h.open()
+	files := vs.ListFiles()
+	if len(files) != 1 {
+		t.Fatalf("%#v", files)
+	}
+	if err := vs.CompactFile(files[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	for _, f := range vs.ListFiles() {
+		if f.ID == files[0].ID {
+			t.Fatalf("%#v", f)
+		}
+	}
+	versionsCheck(t, vs)
+	vs.Close()
+	vs = h.open()
+	defer vs.Close()
+	versionsCheck(t, vs)
+}
+
+func TestRetainVersionsDisabled(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	defer vs.Close()
+	versionsWorkload(t, vs)
+	if versions := vs.ListVersions(1, 1); !reflect.DeepEqual(versions, []Version{{Timestampmicro: 1004, Length: 2}}) {
+		t.Fatalf("%#v", versions)
+	}
+	if _, _, err := vs.ReadVersion(1, 1, 1003, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if _, value, err := vs.ReadVersion(1, 1, 1004, nil); err != nil || string(value) != "v4" {
+		t.Fatal(string(value), err)
+	}
+}
@@ -0,0 +1,131 @@
+package valuestore
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WatchEvent is sent to a Watcher when its key, or a key in its range, is
+// changed, whether by a local Write or Delete or by replication.
+type WatchEvent struct {
+	KeyA           uint64
+	KeyB           uint64
+	Timestampmicro int64
+	Deleted        bool
+}
+
+// Watcher is returned by Watch and WatchRange and has the channel its
+// WatchEvents are sent to. Events are dropped, and counted in the
+// WatchEventsDropped stat, rather than holding up writes when the channel's
+// buffer is full. Cancel should be called once the Watcher is no longer
+// wanted; C is closed at that point.
+type Watcher struct {
+	C          <-chan WatchEvent
+	c          chan WatchEvent
+	vs         *DefaultValueStore
+	key        bulkSetKey
+	ranged     bool
+	rangeStart uint64
+	rangeStop  uint64
+	cancelOnce sync.Once
+}
+
+type watchState struct {
+	count    int32
+	lock     sync.RWMutex
+	watchers map[bulkSetKey][]*Watcher
+	ranges   []*Watcher
+}
+
+// Watch returns a Watcher that will be sent a WatchEvent whenever keyA, keyB
+// changes, buffering up to buffer events.
+func (vs *DefaultValueStore) Watch(keyA uint64, keyB uint64, buffer int) *Watcher {
+	if buffer < 1 {
+		buffer = 1
+	}
+	w := &Watcher{c: make(chan WatchEvent, buffer), vs: vs, key: bulkSetKey{keyA, keyB}}
+	w.C = w.c
+	vs.watchState.lock.Lock()
+	if vs.watchState.watchers == nil {
+		vs.watchState.watchers = make(map[bulkSetKey][]*Watcher)
+	}
+	vs.watchState.watchers[w.key] = append(vs.watchState.watchers[w.key], w)
+	atomic.AddInt32(&vs.watchState.count, 1)
+	vs.watchState.lock.Unlock()
+	return w
+}
+
+// WatchRange returns a Watcher that will be sent a WatchEvent whenever any
+// key with keyA from start to stop inclusive changes, buffering up to buffer
+// events. As with Watch, events are sent as changes are stored, at which
+// point they are what Read gives, rather than once they are flushed to disk.
+func (vs *DefaultValueStore) WatchRange(start uint64, stop uint64, buffer int) *Watcher {
+	if buffer < 1 {
+		buffer = 1
+	}
+	w := &Watcher{c: make(chan WatchEvent, buffer), vs: vs, ranged: true, rangeStart: start, rangeStop: stop}
+	w.C = w.c
+	vs.watchState.lock.Lock()
+	vs.watchState.ranges = append(vs.watchState.ranges, w)
+	atomic.AddInt32(&vs.watchState.count, 1)
+	vs.watchState.lock.Unlock()
+	return w
+}
+
+// Cancel stops events being sent to the Watcher and closes its channel.
+func (w *Watcher) Cancel() {
+	w.cancelOnce.Do(func() {
+		vs := w.vs
+		vs.watchState.lock.Lock()
+		if w.ranged {
+			vs.watchState.ranges = watchRemove(vs.watchState.ranges, w)
+		} else if watchers := watchRemove(vs.watchState.watchers[w.key], w); len(watchers) == 0 {
+			delete(vs.watchState.watchers, w.key)
+		} else {
+			vs.watchState.watchers[w.key] = watchers
+		}
+		atomic.AddInt32(&vs.watchState.count, -1)
+		close(w.c)
+		vs.watchState.lock.Unlock()
+	})
+}
+
+func watchRemove(watchers []*Watcher, w *Watcher) []*Watcher {
+	for i, w2 := range watchers {
+		if w2 == w {
+			return append(watchers[:i], watchers[i+1:]...)
+		}
+	}
+	return watchers
+}
+
+// watchNotify is called for every change stored; compaction rewrites and
+// local removals of expired deletion markers aren't logical changes and so
+// are not sent.
+func (vs *DefaultValueStore) watchNotify(keyA uint64, keyB uint64, timestampbits uint64) {
+	if atomic.LoadInt32(&vs.watchState.count) == 0 || timestampbits&(_TSB_COMPACTION_REWRITE|_TSB_LOCAL_REMOVAL) != 0 {
+		return
+	}
+	vs.watchState.lock.RLock()
+	watchers := vs.watchState.watchers[bulkSetKey{keyA, keyB}]
+	if len(watchers) > 0 || len(vs.watchState.ranges) > 0 {
+		event := WatchEvent{KeyA: keyA, KeyB: keyB, Timestampmicro: int64(timestampbits >> _TSB_UTIL_BITS), Deleted: timestampbits&_TSB_DELETION != 0}
+		for _, w := range watchers {
+			vs.watchSend(w, event)
+		}
+		for _, w := range vs.watchState.ranges {
+			if keyA >= w.rangeStart && keyA <= w.rangeStop {
+				vs.watchSend(w, event)
+			}
+		}
+	}
+	vs.watchState.lock.RUnlock()
+}
+
+func (vs *DefaultValueStore) watchSend(w *Watcher, event WatchEvent) {
+	select {
+	case w.c <- event:
+	default:
+		atomic.AddInt32(&vs.watchEventsDropped, 1)
+	}
+}
@@ -0,0 +1,100 @@
+package valuestore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	vs := newTestStore(t, nil)
+	vs.EnableWrites()
+	w := vs.Watch(1, 2, 1)
+	if _, err := vs.Write(1, 2, 1000, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	// Other keys and writes that change nothing don't send events.
+	if _, err := vs.Write(1, 3, 1000, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vs.Write(1, 2, 900, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-w.C; e.KeyA != 1 || e.KeyB != 2 || e.Timestampmicro != 1000 || e.Deleted {
+		t.Fatal(e)
+	}
+	select {
+	case e := <-w.C:
+		t.Fatal(e)
+	default:
+	}
+	// Writes arriving by replication are sent too.
+	vs.writeBatch([]valueWriteBatchEntry{{keyA: 1, keyB: 2, timestampbits: 1100 << _TSB_UTIL_BITS, value: []byte("testing")}}, false)
+	if e := <-w.C; e.Timestampmicro != 1100 || e.Deleted {
+		t.Fatal(e)
+	}
+	if _, err := vs.Delete(1, 2, 1200); err != nil {
+		t.Fatal(err)
+	}
+	// The buffer is full, so this one is dropped.
+	if _, err := vs.Write(1, 2, 1300, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-w.C; e.Timestampmicro != 1200 || !e.Deleted {
+		t.Fatal(e)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.WatchEventsDropped != 1 {
+		t.Fatal(stats.WatchEventsDropped)
+	}
+	w.Cancel()
+	w.Cancel()
+	if _, ok := <-w.C; ok {
+		t.Fatal("")
+	}
+	if _, err := vs.Write(1, 2, 1400, []byte("testing")); err != nil {
+		t.Fatal(err)
+	}
+	if len(vs.watchState.watchers) != 0 || vs.watchState.count != 0 {
+		t.Fatal(vs.watchState.watchers, vs.watchState.count)
+	}
+}
+
+func TestWatchRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vs := New(&Config{Path: dir})
+	vs.EnableWrites()
+	defer vs.Close()
+	w := vs.WatchRange(10, 20, 10)
+	for keyA := uint64(5); keyA <= 25; keyA += 5 {
+		if _, err := vs.Write(keyA, 2, 1000, []byte("testing")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := vs.Delete(20, 3, 1100); err != nil {
+		t.Fatal(err)
+	}
+	for _, keyA := range []uint64{10, 15, 20} {
+		if e := <-w.C; e.KeyA != keyA || e.KeyB != 2 || e.Timestampmicro != 1000 || e.Deleted {
+			t.Fatal(e)
+		}
+	}
+	if e := <-w.C; e.KeyA != 20 || e.KeyB != 3 || e.Timestampmicro != 1100 || !e.Deleted {
+		t.Fatal(e)
+	}
+	select {
+	case e := <-w.C:
+		t.Fatal(e)
+	default:
+	}
+	w.Cancel()
+	if _, ok := <-w.C; ok {
+		t.Fatal("")
+	}
+	if len(vs.watchState.ranges) != 0 || vs.watchState.count != 0 {
+		t.Fatal(vs.watchState.ranges, vs.watchState.count)
+	}
+}
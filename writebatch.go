@@ -0,0 +1,135 @@
+package valuestore
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// WriteBatch collects writes and deletes to be committed together with
+// Commit. Once committed, either all of the batch is recovered after a crash
+// or none of it is. A WriteBatch is not safe for use by more than one
+// goroutine.
+//
+// The batch is kept whole by applying it within a single write page, so the
+// total length of its values, plus a byte for each with Config.Compression,
+// may not exceed Config.PageSize and its TOC entries, plus two markers, have
+// to fit in a page as well; see WriteBatchCap.
+type WriteBatch struct {
+	vs      *DefaultValueStore
+	entries []valueWriteBatchEntry
+	alloc   int
+}
+
+// NewWriteBatch returns an empty WriteBatch for the store.
+func (vs *DefaultValueStore) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{vs: vs}
+}
+
+// WriteBatchCap returns the most entries a WriteBatch may have, if their
+// values fit.
+func (vs *DefaultValueStore) WriteBatchCap() int {
+	// A TOC block starts with the 8 byte timestamp of its values file.
+	return batchCap(int(vs.pageSize)-8, vs.tocEntrySize)
+}
+
+// batchCap returns the most entries a batch may have for them, with its
+// begin and commit markers, to fit in tocLength bytes of entrySize entries.
+func batchCap(tocLength int, entrySize int) int {
+	return tocLength/entrySize - 2
+}
+
+// Write adds a write of value for keyA, keyB to the batch. The value is not
+// copied and must not be changed until Commit returns.
+func (b *WriteBatch) Write(keyA uint64, keyB uint64, value []byte) {
+	b.add(keyA, keyB, 0, value)
+}
+
+// Delete adds a delete of keyA, keyB to the batch.
+func (b *WriteBatch) Delete(keyA uint64, keyB uint64) {
+	b.add(keyA, keyB, _TSB_DELETION, nil)
+}
+
+func (b *WriteBatch) add(keyA uint64, keyB uint64, tsb uint64, value []byte) {
+	b.entries = append(b.entries, valueWriteBatchEntry{keyA: keyA, keyB: keyB, timestampbits: tsb, value: value})
+	alloc := b.vs.storedLengthMax(len(value))
+	if alloc < b.vs.minValueAlloc {
+		alloc = b.vs.minValueAlloc
+	}
+	b.alloc += alloc
+}
+
+// Len returns how many writes and deletes are in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Reset empties the batch for reuse.
+func (b *WriteBatch) Reset() {
+	for i := range b.entries {
+		b.entries[i].value = nil
+	}
+	b.entries = b.entries[:0]
+	b.alloc = 0
+}
+
+// Commit stores all the writes and deletes in the batch with timestampmicro.
+// As with Write and Delete, an entry for a key that already has a newer
+// timestampmicro is not applied, and is not an error. Nothing is applied if
+// an error is returned, except ErrBatchNotSynced, which says the batch was
+// applied but not made durable. Note that while the batch is recovered whole,
+// reads made during the Commit may see some of its entries before others.
+func (b *WriteBatch) Commit(timestampmicro int64) error {
+	err := b.commit(timestampmicro)
+	if err != nil {
+		atomic.AddInt32(&b.vs.writeBatchErrors, 1)
+	} else {
+		atomic.AddInt32(&b.vs.writeBatches, 1)
+	}
+	return err
+}
+
+func (b *WriteBatch) commit(timestampmicro int64) error {
+	vs := b.vs
+	if len(b.entries) == 0 {
+		return nil
+	}
+	if timestampmicro < TIMESTAMPMICRO_MIN {
+		return fmt.Errorf("timestamp %d < %d", timestampmicro, TIMESTAMPMICRO_MIN)
+	}
+	if timestampmicro > TIMESTAMPMICRO_MAX {
+		return fmt.Errorf("timestamp %d > %d", timestampmicro, TIMESTAMPMICRO_MAX)
+	}
+	if err := vs.checkTimestamp(timestampmicro); err != nil {
+		return err
+	}
+	if len(b.entries) > vs.WriteBatchCap() || b.alloc > int(vs.pageSize) {
+		return ErrBatchTooLarge
+	}
+	// A batch of just deletes is let through a full disk, as Delete is.
+	for i := range b.entries {
+		if b.entries[i].timestampbits&_TSB_DELETION == 0 {
+			if err := vs.diskFull(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for i := range b.entries {
+		e := &b.entries[i]
+		if len(e.value) > int(vs.valueCap) {
+			return fmt.Errorf("value length of %d > %d", len(e.value), vs.valueCap)
+		}
+		e.timestampbits = uint64(timestampmicro)<<_TSB_UTIL_BITS | e.timestampbits&_TSB_DELETION
+		e.err = nil
+	}
+	vs.writeBatch(b.entries, true)
+	for i := range b.entries {
+		if err := b.entries[i].err; err != nil {
+			return err
+		}
+	}
+	if err := vs.syncAlways(); err != nil {
+		return ErrBatchNotSynced
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package valuestore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	if _, err := vs.Write(1, 2, 5000, []byte("newer")); err != nil {
+		t.Fatal(err)
+	}
+	b := vs.NewWriteBatch()
+	for keyB := uint64(1); keyB <= 100; keyB++ {
+		b.Write(1, keyB, []byte(fmt.Sprintf("value%d", keyB)))
+	}
+	b.Delete(1, 3)
+	if err := b.Commit(2000); err != nil {
+		t.Fatal(err)
+	}
+	check := func(vs *DefaultValueStore) {
+		if ts, value, err := vs.Read(1, 1, nil); err != nil || ts != 2000 || string(value) != "value1" {
+			t.Fatal(ts, string(value), err)
+		}
+		if ts, value, err := vs.Read(1, 2, nil); err != nil || ts != 5000 || string(value) != "newer" {
+			t.Fatal(ts, string(value), err)
+		}
+		if ts, _, err := vs.Read(1, 3, nil); err != ErrNotFound || ts != 2000 {
+			t.Fatal(ts, err)
+		}
+		if ts, value, err := vs.Read(1, 100, nil); err != nil || ts != 2000 || string(value) != "value100" {
+			t.Fatal(ts, string(value), err)
+		}
+	}
+	check(vs)
+	if stats := vs.Stats(false).(*Stats); stats.WriteBatches != 1 || stats.WriteBatchErrors != 0 {
+		t.Fatal(stats.WriteBatches, stats.WriteBatchErrors)
+	}
+	vs.Close()
+	vs = h.open()
+	check(vs)
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryTornBatches != 0 {
+		t.Fatal(stats.RecoveryTornBatches)
+	}
+	vs.Close()
+}
+
+func TestWriteBatchTorn(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	b := vs.NewWriteBatch()
+	for keyB := uint64(1); keyB <= 100; keyB++ {
+		b.Write(1, keyB, []byte(fmt.Sprintf("value%d", keyB)))
+	}
+	if err := b.Commit(2000); err != nil {
+		t.Fatal(err)
+	}
+	vs.Close()
+	// The batch's TOC entries span several checksum blocks, so cutting the
+	// TOC file in half leaves the first of them recoverable on their own.
+	tocs := h.files(".valuestoc")
+	if len(tocs) != 1 {
+		t.Fatal(tocs)
+	}
+	h.truncate(tocs[0], 0.5)
+	vs = h.open()
+	defer vs.Close()
+	for keyB := uint64(1); keyB <= 100; keyB++ {
+		if ts, value, err := vs.Read(1, keyB, nil); err != ErrNotFound || ts != 0 {
+			t.Fatal(keyB, ts, string(value), err)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryTornBatches != 1 {
+		t.Fatal(stats.RecoveryTornBatches)
+	}
+}
+
+func TestWriteBatchCrashMidBatch(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	h.workload(vs, 1, 100)
+	b := vs.NewWriteBatch()
+	for keyB := uint64(1001); keyB <= 1100; keyB++ {
+		b.Write(1, keyB, []byte(fmt.Sprintf("value%d", keyB)))
+	}
+	// The batch's TOC entries span several checksum blocks; killed after
+	// the first of them is written, the TOC has the begin marker and some
+	// entries but not the commit marker.
+	h.killAt(vs, _FAILPOINT_TOC_WRITE, 0)
+	if err := b.Commit(2000); err != nil {
+		t.Fatal(err)
+	}
+	vs.Flush()
+	vs.Close()
+	vs = h.recover()
+	for keyB := uint64(1001); keyB <= 1100; keyB++ {
+		if ts, value, err := vs.Read(1, keyB, nil); err != ErrNotFound || ts != 0 {
+			t.Fatal(keyB, ts, string(value), err)
+		}
+	}
+	if stats := vs.Stats(false).(*Stats); stats.RecoveryTornBatches != 1 {
+		t.Fatal(stats.RecoveryTornBatches)
+	}
+	// What was written before the batch is unaffected.
+	if n := h.verify(vs); n != len(h.values) {
+		t.Fatal(n, len(h.values))
+	}
+	h.verifyWritable(vs)
+}
+
+func TestWriteBatchTooLarge(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	defer vs.Close()
+	b := vs.NewWriteBatch()
+	for i := 0; i <= vs.WriteBatchCap(); i++ {
+		b.Delete(1, uint64(i))
+	}
+	if err := b.Commit(2000); err != ErrBatchTooLarge {
+		t.Fatal(err)
+	}
+	b.Reset()
+	for i := 0; i <= int(vs.pageSize)/int(vs.valueCap); i++ {
+		b.Write(1, uint64(i), make([]byte, vs.valueCap))
+	}
+	if err := b.Commit(2000); err != ErrBatchTooLarge {
+		t.Fatal(err)
+	}
+	if _, _, err := vs.Read(1, 0, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if stats := vs.Stats(false).(*Stats); stats.WriteBatches != 0 || stats.WriteBatchErrors != 2 {
+		t.Fatal(stats.WriteBatches, stats.WriteBatchErrors)
+	}
+}
@@ -0,0 +1,207 @@
+package valuestore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spaolacci/murmur3"
+	"gopkg.in/gholt/brimutil.v1"
+)
+
+// writeStreamState tracks the values files WriteStream is still writing, by
+// their timestampnanos; a slow stream may take longer than
+// Config.CompactionAgeThreshold and compaction has to leave its files alone
+// until the value is in the locmap.
+type writeStreamState struct {
+	lock   sync.Mutex
+	active map[int64]struct{}
+}
+
+// WriteStream stores length bytes read from r as the value for keyA, keyB
+// with timestampmicro, as Write would, but without the value having to be in
+// memory all at once: it is read in Config.ChecksumInterval sized chunks
+// straight into a values file of its own. The length may be up to ValueCap
+// and r has to provide at least that many bytes. If a newer timestampmicro is
+// already in place, r is not read at all. The previously stored
+// timestampmicro is returned, as with Write.
+//
+// Each call creates a values file and TOC file pair, so this is meant for
+// large values; small ones are better given to Write. Compaction folds these
+// files in with the rest once they are older than
+// Config.CompactionAgeThreshold. Both files are complete once WriteStream
+// returns; there is nothing for Flush to do for the value.
+func (vs *DefaultValueStore) WriteStream(keyA uint64, keyB uint64, timestampmicro int64, length uint32, r io.Reader) (int64, error) {
+	ptimestampmicro, err := vs.writeStream(keyA, keyB, timestampmicro, length, r)
+	if err == nil {
+		err = vs.syncAlways()
+	}
+	if err != nil {
+		atomic.AddInt32(&vs.writeStreamErrors, 1)
+	} else {
+		atomic.AddInt32(&vs.writeStreams, 1)
+	}
+	return ptimestampmicro, err
+}
+
+func (vs *DefaultValueStore) writeStream(keyA uint64, keyB uint64, timestampmicro int64, length uint32, r io.Reader) (int64, error) {
+	if timestampmicro < TIMESTAMPMICRO_MIN {
+		return 0, fmt.Errorf("timestamp %d < %d", timestampmicro, TIMESTAMPMICRO_MIN)
+	}
+	if timestampmicro > TIMESTAMPMICRO_MAX {
+		return 0, fmt.Errorf("timestamp %d > %d", timestampmicro, TIMESTAMPMICRO_MAX)
+	}
+	if err := vs.checkTimestamp(timestampmicro); err != nil {
+		return 0, err
+	}
+	if length > vs.valueCap {
+		return 0, fmt.Errorf("value length of %d > %d", length, vs.valueCap)
+	}
+	stored := vs.storedLengthMax(int(length))
+	if stored > math.MaxUint32 {
+		return 0, fmt.Errorf("value length of %d > %d", stored, uint32(math.MaxUint32))
+	}
+	if atomic.LoadUint32(&vs.closeState.closed) != 0 {
+		return 0, ErrClosed
+	}
+	if err := vs.diskFull(); err != nil {
+		return 0, err
+	}
+	ptimestampbits, _, _, _ := vs.vlm.Get(keyA, keyB)
+	ptimestampmicro := int64(ptimestampbits >> _TSB_UTIL_BITS)
+	if vs.monotonicWrites && timestampmicro <= ptimestampmicro {
+		if ptimestampmicro >= TIMESTAMPMICRO_MAX {
+			return 0, fmt.Errorf("timestamp %d > %d", ptimestampmicro+1, TIMESTAMPMICRO_MAX)
+		}
+		timestampmicro = ptimestampmicro + 1
+		atomic.AddInt32(&vs.writesBumped, 1)
+	}
+	timestampbits := uint64(timestampmicro) << _TSB_UTIL_BITS
+	if ptimestampbits >= timestampbits {
+		atomic.AddInt32(&vs.writesOverridden, 1)
+		return ptimestampmicro, nil
+	}
+	// The TOC file is written before the value is stored in the locmap, so
+	// that by the time the value can be read the files are like any others;
+	// if it can't be stored after all, both files are removed again.
+	vf := createValuesFile(vs, vs.createValuesWriteCloser, vs.openValuesReadSeeker)
+	vs.writeStreamState.lock.Lock()
+	if vs.writeStreamState.active == nil {
+		vs.writeStreamState.active = make(map[int64]struct{})
+	}
+	vs.writeStreamState.active[vf.bts] = struct{}{}
+	vs.writeStreamState.lock.Unlock()
+	defer func() {
+		vs.writeStreamState.lock.Lock()
+		delete(vs.writeStreamState.active, vf.bts)
+		vs.writeStreamState.lock.Unlock()
+	}()
+	offset := atomic.LoadUint64(&vf.atOffset)
+	err := vf.writeFrom(r, length)
+	vf.close()
+	if err == nil {
+		err = vs.writeStreamTOC(vf.bts, keyA, keyB, timestampbits, offset, uint32(stored))
+		if err == nil {
+			blockID, blockOffset := vf.location(offset)
+			ptimestampbits, err = vs.writeStreamed(keyA, keyB, timestampbits, blockID, blockOffset, uint32(stored), length)
+		}
+	}
+	if err != nil {
+		vs.removeStreamFiles(vf.bts)
+		return 0, err
+	}
+	// If a newer write got in while this one was being read, the files are
+	// left for compaction to discard.
+	if ptimestampbits >= timestampbits {
+		atomic.AddInt32(&vs.writesOverridden, 1)
+	}
+	if vs.monotonicWrites {
+		return timestampmicro, nil
+	}
+	return int64(ptimestampbits >> _TSB_UTIL_BITS), nil
+}
+
+// writeStreaming returns true if WriteStream is still writing the values file
+// named namets.
+func (vs *DefaultValueStore) writeStreaming(namets int64) bool {
+	vs.writeStreamState.lock.Lock()
+	_, ok := vs.writeStreamState.active[namets]
+	vs.writeStreamState.lock.Unlock()
+	return ok
+}
+
+// writeStreamed stores the location of a value written by WriteStream
+// through the same memWriter as any other write for the key, so it is
+// ordered with them and honors DisableWrites.
+func (vs *DefaultValueStore) writeStreamed(keyA uint64, keyB uint64, timestampbits uint64, blockID uint32, offset uint32, length uint32, logicalLength uint32) (uint64, error) {
+	i := int(keyA>>1) % len(vs.freeVWRChans)
+	vwr := <-vs.freeVWRChans[i]
+	vwr.keyA = keyA
+	vwr.keyB = keyB
+	vwr.timestampbits = timestampbits
+	vwr.streamed = true
+	vwr.blockID = blockID
+	vwr.offset = offset
+	vwr.length = length
+	vwr.logicalLength = logicalLength
+	var err error
+	select {
+	case vs.pendingVWRChans[i] <- vwr:
+		err = <-vwr.errChan
+	case <-vs.closeState.writersChan:
+		err = ErrClosed
+	}
+	ptimestampbits := vwr.timestampbits
+	vwr.streamed = false
+	vs.freeVWRChans[i] <- vwr
+	return ptimestampbits, err
+}
+
+// writeStreamTOC writes the TOC file for a values file written by
+// WriteStream, holding just the one entry.
+func (vs *DefaultValueStore) writeStreamTOC(bts int64, keyA uint64, keyB uint64, timestampbits uint64, offset uint64, length uint32) error {
+	fp, err := os.Create(vs.tocName(bts))
+	if err != nil {
+		return err
+	}
+	head := []byte(_TOC_HEADER_V0 + "    ")
+	if vs.tocEntrySize == _TOC_ENTRY_SIZE_V1 {
+		head = []byte(_TOC_HEADER_V1 + "    ")
+	}
+	putChecksumHeader(head, _CHECKSUM_MURMUR3, vs.checksumInterval)
+	b := make([]byte, len(head)+vs.tocEntrySize+16)
+	copy(b, head)
+	putTOCEntry(b[len(head):], vs.tocEntrySize, keyA, keyB, timestampbits, offset, length)
+	term := b[len(head)+vs.tocEntrySize:]
+	binary.BigEndian.PutUint64(term[4:], uint64(len(head)+vs.tocEntrySize))
+	copy(term[12:], "TERM")
+	ew, err := vs.encryptWriteCloser(&countingWriteCloser{WriteCloser: fp, vs: vs, disk: vs.diskHealthState.toc}, vs.checksumInterval+4)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	w := brimutil.NewMultiCoreChecksummedWriter(ew, int(vs.checksumInterval), murmur3.New32, 1)
+	if _, err = w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// removeStreamFiles removes what a failed WriteStream may have created.
+func (vs *DefaultValueStore) removeStreamFiles(bts int64) {
+	vs.manifestAppend(_MANIFEST_DELETE, bts)
+	name := vs.tocName(bts)
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		vs.logError("unable to remove %s after a failed stream write: %s\n", name, err)
+	}
+	name = vs.valuesName(bts)
+	if err := vs.fileBackend.Remove(name); err != nil && !os.IsNotExist(err) {
+		vs.logError("unable to remove %s after a failed stream write: %s\n", name, err)
+	}
+	vs.pathsForget(bts)
+}
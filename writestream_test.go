@@ -0,0 +1,62 @@
+package valuestore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("should not have been read")
+}
+
+func TestWriteStream(t *testing.T) {
+	h := newCrashHarness(t)
+	defer h.close()
+	vs := h.open()
+	value := bytes.Repeat([]byte("0123456789"), 400)
+	if _, err := vs.WriteStream(1, 1, 2000, uint32(len(value)), bytes.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+	if ts, v, err := vs.Read(1, 1, nil); err != nil || ts != 2000 || !bytes.Equal(v, value) {
+		t.Fatal(ts, len(v), err)
+	}
+	// An older timestamp is overridden without the reader being touched.
+	if ts, err := vs.WriteStream(1, 1, 1000, 10, failingReader{}); err != nil || ts != 2000 {
+		t.Fatal(ts, err)
+	}
+	valuesFiles := len(h.files(".values"))
+	if _, err := vs.WriteStream(1, 2, 2000, uint32(len(value)), bytes.NewReader(value[:1500])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if n := len(h.files(".values")); n != valuesFiles {
+		t.Fatalf("%d values files after a failed stream, %d before", n, valuesFiles)
+	}
+	if _, _, err := vs.Read(1, 2, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if _, err := vs.WriteStream(1, 3, 2000, vs.ValueCap()+1, bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected an error for a length over ValueCap")
+	}
+	if stats := vs.Stats(false).(*Stats); stats.WriteStreams != 2 || stats.WriteStreamErrors != 2 {
+		t.Fatal(stats.WriteStreams, stats.WriteStreamErrors)
+	}
+	vs.Close()
+	vs = h.open()
+	if ts, v, err := vs.Read(1, 1, nil); err != nil || ts != 2000 || !bytes.Equal(v, value) {
+		t.Fatal(ts, len(v), err)
+	}
+	vs.DisableWrites()
+	if _, err := vs.WriteStream(1, 4, 2000, uint32(len(value)), bytes.NewReader(value)); err != ErrDisabled {
+		t.Fatalf("expected ErrDisabled, got %v", err)
+	}
+	vs.Close()
+	vs = h.open()
+	if _, _, err := vs.Read(1, 4, nil); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	vs.Close()
+}